@@ -2,22 +2,32 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
-	"fmt"
 	"log/slog"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/relaypoint/relaypoint/internal/config"
-	"github.com/relaypoint/relaypoint/internal/health"
-	"github.com/relaypoint/relaypoint/internal/loadbalancer"
-	"github.com/relaypoint/relaypoint/internal/proxy"
+	"github.com/relaypoint/relaypoint/pkg/gateway"
+)
+
+// Set at build time with -ldflags.
+var (
+	version   = "dev"
+	buildTime = "unknown"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(runValidate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTest(os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "relaypoint.yml", "Path to the configuration file")
 	flag.Parse()
 
@@ -29,102 +39,53 @@ func main() {
 	logger.Info("Starting RelayPoint", "config", *configPath)
 
 	// Load configuration
-	cfg, err := config.Load(*configPath)
+	cfg, err := gateway.LoadConfig(*configPath)
 	if err != nil {
 		logger.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
 
-	logger.Info("configuration loaded", "routes", len(cfg.Routes), "upstreams", len(cfg.Upstreams), "rate_limiting", cfg.RateLimit.Enabled)
+	logger.Info("configuration loaded", "routes", len(cfg.Routes), "upstreams", len(cfg.Upstreams), "rate_limiting", cfg.RateLimit.Enabled,
+		"version", version, "fingerprint", cfg.Fingerprint())
 
-	p, err := proxy.New(cfg)
+	g, err := gateway.New(cfg,
+		gateway.WithLogger(logger),
+		gateway.WithConfigFile(*configPath),
+		gateway.WithVersion(version, buildTime),
+	)
 	if err != nil {
 		logger.Error("Failed to create proxy", "error", err)
 		os.Exit(1)
 	}
-	defer p.Stop()
-
-	upstreams := make(map[string]loadbalancer.LoadBalancer)
-	healthConfigs := make(map[string]*config.HealthCheck)
-	for _, u := range cfg.Upstreams {
-		if u.HealthCheck != nil {
-			healthConfigs[u.Name] = u.HealthCheck
-		}
-	}
-
-	if len(healthConfigs) > 0 {
-		// Get upstreams from proxy - we need to expose this
-		// For now, skip health checker setup
-		logger.Info("Health checks configured", "upstreams", len(healthConfigs))
-	}
-
-	mux := http.NewServeMux()
-	mux.Handle("/", p)
-
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"status":"healthy"}`))
-	})
-
-	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		stats := p.UsageStats()
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(stats)
-	})
-
-	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	server := &http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-	}
-
-	var metricsServer *http.Server
-	if cfg.Metrics.Enabled {
-		metricsMux := http.NewServeMux()
-		metricsMux.Handle(cfg.Metrics.Path, p.Metrics().Handler())
-		metricsServer = &http.Server{
-			Addr:    fmt.Sprintf(":%d", cfg.Metrics.Port),
-			Handler: metricsMux,
-		}
-		go func() {
-			logger.Info("metrics server starting", "port", cfg.Metrics.Port, "path", cfg.Metrics.Path)
-			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				logger.Error("metrics server error", "error", err)
-			}
-		}()
+	if err := g.Start(context.Background()); err != nil {
+		logger.Error("server error", "error", err)
+		os.Exit(1)
 	}
 
-	var checker *health.Checker
-	_ = checker // suppress unused variable for now
-	_ = upstreams
-
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	go func() {
-		logger.Info("relaypoint API Gateway starting", "address", addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("server error", "error", err)
-			os.Exit(1)
+		for range reload {
+			cfg, err := gateway.LoadConfig(*configPath)
+			if err != nil {
+				logger.Error("failed to reload configuration", "error", err)
+				continue
+			}
+			g.Reload(cfg, "sighup")
 		}
 	}()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-
-	logger.Info("shutting down server...")
-
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
-	defer cancel()
-
-	if metricsServer != nil {
-		_ = metricsServer.Shutdown(ctx)
+	select {
+	case <-quit:
+	case <-g.Done():
 	}
 
-	if err := server.Shutdown(ctx); err != nil {
-		logger.Error("server shutdown error", "error", err)
+	// Fails /ready, waits out the pre-stop delay and drains in-flight
+	// requests; returns at once if a preStop hook already ran it.
+	_ = g.Shutdown(context.Background())
+	if g.Err() != nil {
+		os.Exit(1)
 	}
-
-	logger.Info("server gracefully stopped")
-
 }
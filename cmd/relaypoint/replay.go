@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/relaypoint/relaypoint/internal/capture"
+)
+
+// runReplay implements "relaypoint replay": it sends the requests of a
+// capture file to a target and reports status codes that differ from the
+// recording. It returns the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "Capture file (JSON lines) to replay")
+	target := fs.String("target", "", "Base URL to replay against, e.g. http://staging:8080")
+	speed := fs.Float64("speed", 1, "Pacing relative to the recording: 2 is twice as fast, 0 sends back to back")
+	_ = fs.Parse(args)
+
+	if *file == "" || *target == "" {
+		fmt.Fprintln(os.Stderr, "usage: relaypoint replay -file capture.jsonl -target http://host:port [-speed 1]")
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rp := &capture.Replayer{Target: *target, Speed: *speed}
+	report, err := rp.Replay(ctx, f)
+	if report != nil {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 1
+	}
+	if len(report.Mismatches) > 0 {
+		return 3
+	}
+	return 0
+}
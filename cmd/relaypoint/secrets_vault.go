@@ -0,0 +1,13 @@
+//go:build vault
+
+package main
+
+import (
+	"github.com/relaypoint/relaypoint/internal/secrets"
+	"github.com/relaypoint/relaypoint/internal/secrets/vault"
+)
+
+// Built with -tags vault, config values may reference Vault secrets.
+func init() {
+	secrets.Register("vault", vault.FromEnv())
+}
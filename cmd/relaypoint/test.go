@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/fixtures"
+)
+
+// runTest implements "relaypoint test": it runs the fixtures of a
+// configuration's tests section against an in-process gateway, without
+// calling any upstream, and prints a JUnit report. It returns the process
+// exit code.
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	path := fs.String("config", "relaypoint.yml", "Configuration file whose tests to run")
+	junit := fs.String("junit", "-", "File to write the JUnit report to, - for stdout")
+	_ = fs.Parse(args)
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(cfg.Tests) == 0 {
+		fmt.Fprintf(os.Stderr, "test: %s has no tests\n", *path)
+		return 0
+	}
+
+	// The gateway's own logs would drown the report.
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	report, err := fixtures.Run(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "test:", err)
+		return 1
+	}
+
+	out := os.Stdout
+	if *junit != "-" {
+		f, err := os.Create(*junit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := report.WriteJUnit(out, *path); err != nil {
+		fmt.Fprintln(os.Stderr, "test:", err)
+		return 1
+	}
+
+	for _, r := range report.Results {
+		for _, failure := range r.Failures {
+			fmt.Fprintf(os.Stderr, "FAIL %s: %s\n", r.Name, failure)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d tests passed\n", len(report.Results)-report.Failed, len(report.Results))
+	if report.Failed > 0 {
+		return 3
+	}
+	return 0
+}
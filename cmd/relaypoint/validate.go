@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// runValidate implements "relaypoint validate": it checks a configuration
+// file, including for duplicate and shadowed routes, and, given the
+// baseline it replaces, reports the routes, upstreams and API keys it
+// adds, modifies or deletes. With -changed-by, changes to entities owned
+// by other teams are flagged, and rejected when the baseline sets
+// enforce_ownership. It returns the process exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("config", "relaypoint.yml", "Configuration file to check")
+	baseline := fs.String("baseline", "", "Configuration it replaces, e.g. the one deployed")
+	changedBy := fs.String("changed-by", "", "Team making the change")
+	_ = fs.Parse(args)
+
+	if *changedBy != "" && *baseline == "" {
+		fmt.Fprintln(os.Stderr, "usage: relaypoint validate -config new.yml [-baseline current.yml [-changed-by team]]")
+		return 2
+	}
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, warning := range cfg.Warnings() {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+	if !checkRoutes(cfg) {
+		return 1
+	}
+	if *baseline == "" {
+		fmt.Println("configuration is valid")
+		return 0
+	}
+	base, err := config.Load(*baseline)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "baseline:", err)
+		return 1
+	}
+
+	report := config.CheckOwnership(base, cfg, *changedBy)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	for _, c := range report.Foreign {
+		owner := c.PreviousOwner
+		if owner == "" || owner == *changedBy {
+			owner = c.Owner
+		}
+		fmt.Fprintf(os.Stderr, "%s %s %s: owned by %s\n", c.Kind, c.Name, c.Change, owner)
+	}
+	if report.Rejected {
+		fmt.Fprintln(os.Stderr, "validate: changes to entities of other teams are not allowed (enforce_ownership)")
+		return 3
+	}
+	return 0
+}
+
+// checkRoutes prints the duplicate and shadowed routes of cfg, as the
+// gateway would find them at startup, and reports whether it has no
+// duplicates.
+func checkRoutes(cfg *config.Config) bool {
+	r := router.New(cfg.Routes)
+	if cfg.Server.CaseSensitivePaths {
+		r.MatchCaseSensitivePaths()
+	}
+	ok := true
+	for _, c := range r.Conflicts() {
+		if c.Duplicate {
+			fmt.Fprintln(os.Stderr, c)
+			ok = false
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "warning:", c)
+	}
+	return ok
+}
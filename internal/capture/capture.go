@@ -0,0 +1,238 @@
+// Package capture records a sample of live requests to a sink so they can
+// be replayed against another deployment, and replays such recordings.
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Redacted replaces the value of every redacted header, query parameter and
+// JSON body field.
+const Redacted = "REDACTED"
+
+// alwaysRedacted are the credentials never written to a capture, whatever
+// the route configures.
+var alwaysRedacted = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"X-API-Key",
+	"api_key",
+}
+
+// Record is one captured request and the status it was answered with.
+type Record struct {
+	Time          time.Time   `json:"time"`
+	Route         string      `json:"route"`
+	Method        string      `json:"method"`
+	Host          string      `json:"host"`
+	Path          string      `json:"path"`
+	Query         string      `json:"query,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+	BodyOmitted   bool        `json:"body_omitted,omitempty"` // JSON that could not be redacted
+	Status        int         `json:"status"`
+	Duration      float64     `json:"duration_ms"`
+}
+
+// Sink stores records. Write is only called from the capturer's writer
+// goroutine.
+type Sink interface {
+	Write(rec *Record) error
+	Close() error
+}
+
+// Capturer hands records to a sink from a background goroutine, so the
+// request path never waits on I/O. Records beyond maxPerSecond, or that
+// arrive while the queue is full, are dropped.
+type Capturer struct {
+	sink         Sink
+	maxPerSecond int64
+	done         chan struct{}
+
+	queueMu sync.RWMutex // guards sends on queue against Close
+	queue   chan *Record
+	closed  bool
+
+	mu       sync.Mutex
+	window   int64 // unix second the count applies to
+	inWindow int64
+
+	dropped atomic.Int64
+	errs    atomic.Int64
+}
+
+// NewCapturer starts a capturer writing to sink.
+func NewCapturer(sink Sink, maxPerSecond int) *Capturer {
+	if maxPerSecond <= 0 {
+		maxPerSecond = 100
+	}
+	c := &Capturer{
+		sink:         sink,
+		maxPerSecond: int64(maxPerSecond),
+		queue:        make(chan *Record, 1024),
+		done:         make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// Allow reports whether another record fits under the throughput cap. Call
+// it before doing the work of building a record.
+func (c *Capturer) Allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sec := now.Unix(); sec != c.window {
+		c.window = sec
+		c.inWindow = 0
+	}
+	if c.inWindow >= c.maxPerSecond {
+		c.dropped.Add(1)
+		return false
+	}
+	c.inWindow++
+	return true
+}
+
+// Add queues rec for the sink, dropping it if the queue is full.
+func (c *Capturer) Add(rec *Record) {
+	c.queueMu.RLock()
+	defer c.queueMu.RUnlock()
+
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- rec:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Dropped reports how many records were not captured because of the
+// throughput cap or a full queue.
+func (c *Capturer) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// Errors reports how many records the sink failed to write.
+func (c *Capturer) Errors() int64 {
+	return c.errs.Load()
+}
+
+func (c *Capturer) run() {
+	defer close(c.done)
+	for rec := range c.queue {
+		if err := c.sink.Write(rec); err != nil {
+			c.errs.Add(1)
+		}
+	}
+}
+
+// Close writes out the queued records and closes the sink. Records added
+// afterwards are discarded.
+func (c *Capturer) Close() error {
+	c.queueMu.Lock()
+	if c.closed {
+		c.queueMu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.queue)
+	c.queueMu.Unlock()
+
+	<-c.done
+	return c.sink.Close()
+}
+
+// Redactor blanks out credentials in captured requests. Names match header
+// names, query parameters and JSON object keys, case-insensitively.
+type Redactor struct {
+	names map[string]bool
+}
+
+// NewRedactor redacts names on top of the credentials always redacted.
+func NewRedactor(names []string) *Redactor {
+	r := &Redactor{names: make(map[string]bool)}
+	for _, n := range append(append([]string{}, alwaysRedacted...), names...) {
+		r.names[strings.ToLower(n)] = true
+	}
+	return r
+}
+
+func (r *Redactor) redacts(name string) bool {
+	return r.names[strings.ToLower(name)]
+}
+
+// Header returns a copy of h with redacted values replaced.
+func (r *Redactor) Header(h http.Header) http.Header {
+	out := h.Clone()
+	for name, values := range out {
+		if r.redacts(name) {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// Query returns rawQuery with redacted parameter values replaced. Other
+// parameters keep their original encoding.
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if r.redacts(name) {
+			parts[i] = key + "=" + Redacted
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// JSON redacts matching keys anywhere in a JSON document. ok is false when
+// body is not valid JSON, e.g. because it was truncated.
+func (r *Redactor) JSON(body []byte) (redacted []byte, ok bool) {
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, false
+	}
+	out, err := json.Marshal(r.walk(v))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (r *Redactor) walk(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if r.redacts(k) {
+				v[k] = Redacted
+			} else {
+				v[k] = r.walk(child)
+			}
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = r.walk(child)
+		}
+	}
+	return v
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor([]string{"X-Session", "password", "token"})
+
+	h := http.Header{
+		"Authorization": {"Bearer secret"},
+		"X-Session":     {"abc", "def"},
+		"Accept":        {"application/json"},
+	}
+	got := r.Header(h)
+	if got.Get("Authorization") != Redacted || got.Values("X-Session")[1] != Redacted {
+		t.Errorf("credentials kept: %v", got)
+	}
+	if got.Get("Accept") != "application/json" {
+		t.Errorf("Accept = %q", got.Get("Accept"))
+	}
+	if h.Get("Authorization") != "Bearer secret" {
+		t.Error("Header modified its input")
+	}
+
+	if q := r.Query("page=2&api_key=k1&TOKEN=t&q=a%20b"); q != "page=2&api_key=REDACTED&TOKEN=REDACTED&q=a%20b" {
+		t.Errorf("Query = %q", q)
+	}
+
+	body, ok := r.JSON([]byte(`{"user":"ann","password":"hunter2","nested":[{"token":"t","id":1}]}`))
+	if !ok {
+		t.Fatal("valid JSON rejected")
+	}
+	var v map[string]any
+	_ = json.Unmarshal(body, &v)
+	if v["password"] != Redacted || v["user"] != "ann" {
+		t.Errorf("JSON = %s", body)
+	}
+	if nested := v["nested"].([]any)[0].(map[string]any); nested["token"] != Redacted || nested["id"] != float64(1) {
+		t.Errorf("nested JSON = %s", body)
+	}
+
+	if _, ok := r.JSON([]byte(`{"password":"hun`)); ok {
+		t.Error("truncated JSON accepted")
+	}
+}
+
+type memorySink struct{ records chan *Record }
+
+func (s *memorySink) Write(rec *Record) error { s.records <- rec; return nil }
+func (s *memorySink) Close() error            { close(s.records); return nil }
+
+func TestCapturer_ThroughputCap(t *testing.T) {
+	sink := &memorySink{records: make(chan *Record, 100)}
+	c := NewCapturer(sink, 3)
+
+	now := time.Unix(1000, 0)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if c.Allow(now) {
+			allowed++
+			c.Add(&Record{Path: "/"})
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("allowed %d records in one second, want 3", allowed)
+	}
+	if !c.Allow(now.Add(time.Second)) {
+		t.Error("cap did not reset in the next second")
+	}
+	if c.Dropped() != 7 {
+		t.Errorf("Dropped() = %d, want 7", c.Dropped())
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.Add(&Record{}) // must not panic after Close
+	if n := len(sink.records); n != 3 {
+		t.Errorf("sink received %d records, want 3", n)
+	}
+}
+
+func TestFileSink_Rotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	s, err := NewFileSink(path, 400, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Write(&Record{Method: "GET", Path: "/" + strings.Repeat("x", 100), Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("missing %s: %v", name, err)
+		}
+		if info.Size() > 400 {
+			t.Errorf("%s is %d bytes, over the 400 byte limit", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than max_files rotated files kept")
+	}
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil || rec.Status != 200 {
+			t.Errorf("bad line %q", sc.Text())
+		}
+	}
+}
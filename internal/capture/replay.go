@@ -0,0 +1,141 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Replayer sends captured requests to Target with the original spacing
+// between them divided by Speed. A Speed of zero sends them back to back.
+type Replayer struct {
+	Target string
+	Speed  float64
+	Client *http.Client
+
+	// sleep waits for d or until ctx is done; tests replace it.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Mismatch is a replayed request whose status differed from the recording.
+type Mismatch struct {
+	Line     int    `json:"line"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Recorded int    `json:"recorded"`
+	Replayed int    `json:"replayed"` // 0 when the request failed
+	Error    string `json:"error,omitempty"`
+}
+
+// Report summarizes a replay.
+type Report struct {
+	Total      int        `json:"total"`
+	Matched    int        `json:"matched"`
+	Skipped    int        `json:"skipped"` // records whose body was omitted or truncated
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// Replay reads JSON-lines records from r and replays them.
+func (rp *Replayer) Replay(ctx context.Context, r io.Reader) (*Report, error) {
+	client := rp.Client
+	if client == nil {
+		client = &http.Client{
+			Timeout: 30 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	sleep := rp.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	target := strings.TrimSuffix(rp.Target, "/")
+
+	report := &Report{Mismatches: []Mismatch{}}
+	var first time.Time
+	replayStart := time.Now()
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return report, fmt.Errorf("line %d: %w", line, err)
+		}
+		report.Total++
+		if rec.BodyOmitted || rec.BodyTruncated {
+			report.Skipped++
+			continue
+		}
+
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if rp.Speed > 0 {
+			due := time.Duration(float64(rec.Time.Sub(first)) / rp.Speed)
+			if wait := due - time.Since(replayStart); wait > 0 {
+				if err := sleep(ctx, wait); err != nil {
+					return report, err
+				}
+			}
+		}
+
+		status, err := replayOne(ctx, client, target, &rec)
+		if err == nil && status == rec.Status {
+			report.Matched++
+			continue
+		}
+		m := Mismatch{Line: line, Method: rec.Method, Path: rec.Path, Recorded: rec.Status, Replayed: status}
+		if err != nil {
+			m.Error = err.Error()
+		}
+		report.Mismatches = append(report.Mismatches, m)
+	}
+	return report, sc.Err()
+}
+
+func replayOne(ctx context.Context, client *http.Client, target string, rec *Record) (int, error) {
+	u := target + rec.Path
+	if rec.Query != "" {
+		u += "?" + rec.Query
+	}
+	req, err := http.NewRequestWithContext(ctx, rec.Method, u, bytes.NewReader(rec.Body))
+	if err != nil {
+		return 0, err
+	}
+	for name, values := range rec.Header {
+		req.Header[name] = values
+	}
+	req.Host = rec.Host
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,99 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func captureFile(t *testing.T, records ...Record) string {
+	t.Helper()
+	var b strings.Builder
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestReplayer(t *testing.T) {
+	type seen struct {
+		method, path, query, host, header, body string
+	}
+	got := make(chan seen, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- seen{r.Method, r.URL.Path, r.URL.RawQuery, r.Host, r.Header.Get("X-Tenant"), string(body)}
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer target.Close()
+
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	file := captureFile(t,
+		Record{Time: t0, Method: "GET", Host: "api.example.com", Path: "/a", Query: "x=1", Header: http.Header{"X-Tenant": {"t1"}}, Status: 200},
+		Record{Time: t0.Add(2 * time.Second), Method: "POST", Path: "/b", Body: []byte(`{"id":1}`), Status: 200},
+		Record{Time: t0.Add(3 * time.Second), Method: "POST", Path: "/c", BodyTruncated: true, Status: 200},
+		Record{Time: t0.Add(4 * time.Second), Method: "DELETE", Path: "/gone", Status: 204},
+	)
+
+	for _, tt := range []struct {
+		speed float64
+		waits []time.Duration
+	}{
+		{speed: 1, waits: []time.Duration{2 * time.Second, 4 * time.Second}},
+		{speed: 2, waits: []time.Duration{time.Second, 2 * time.Second}},
+		{speed: 0, waits: nil},
+	} {
+		var waits []time.Duration
+		rp := &Replayer{
+			Target: target.URL,
+			Speed:  tt.speed,
+			sleep: func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			},
+		}
+		report, err := rp.Replay(context.Background(), strings.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Total != 4 || report.Matched != 2 || report.Skipped != 1 || len(report.Mismatches) != 1 {
+			t.Fatalf("speed %v: report = %+v", tt.speed, report)
+		}
+		if m := report.Mismatches[0]; m.Path != "/gone" || m.Recorded != 204 || m.Replayed != 410 || m.Line != 4 {
+			t.Errorf("mismatch = %+v", m)
+		}
+
+		// The fake sleep returns at once, so each wait is the record's offset
+		// from the first one, less the time the requests themselves took.
+		if len(waits) != len(tt.waits) {
+			t.Fatalf("speed %v: waited %v, want %v", tt.speed, waits, tt.waits)
+		}
+		for i, w := range waits {
+			if w > tt.waits[i] || w < tt.waits[i]-500*time.Millisecond {
+				t.Errorf("speed %v: wait %d = %v, want about %v", tt.speed, i, w, tt.waits[i])
+			}
+		}
+
+		a := <-got
+		if a != (seen{"GET", "/a", "x=1", "api.example.com", "t1", ""}) {
+			t.Errorf("replayed request = %+v", a)
+		}
+		if b := <-got; b.body != `{"id":1}` {
+			t.Errorf("replayed body = %q", b.body)
+		}
+		<-got
+	}
+}
@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// FileSink writes records as JSON lines to path. Once the file reaches
+// maxSize bytes it is renamed to path.1, path.1 to path.2 and so on; files
+// beyond maxFiles are removed.
+type FileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending.
+func NewFileSink(path string, maxSize int64, maxFiles int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = 100 << 20
+	}
+	if maxFiles <= 0 {
+		maxFiles = 5
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink POSTs records to a collector as JSON lines, in batches of up to
+// batchSize records or whatever accumulated within flushInterval.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+
+	buf       bytes.Buffer
+	count     int
+	lastFlush time.Time
+}
+
+const (
+	httpSinkBatchSize     = 100
+	httpSinkFlushInterval = time.Second
+)
+
+// NewHTTPSink creates a sink posting to url.
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url:       url,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		lastFlush: time.Now(),
+	}
+}
+
+func (s *HTTPSink) Write(rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.buf.Write(line)
+	s.buf.WriteByte('\n')
+	s.count++
+
+	if s.count >= httpSinkBatchSize || time.Since(s.lastFlush) >= httpSinkFlushInterval {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *HTTPSink) flush() error {
+	s.lastFlush = time.Now()
+	if s.count == 0 {
+		return nil
+	}
+	body := bytes.NewReader(bytes.Clone(s.buf.Bytes()))
+	s.buf.Reset()
+	s.count = 0
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Close sends any records still buffered.
+func (s *HTTPSink) Close() error {
+	return s.flush()
+}
@@ -0,0 +1,318 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// ProtocolVersion is the payload version this node speaks. Payloads with a
+// different version are rejected so mixed-version fleets fail loudly.
+const ProtocolVersion = 1
+
+const signatureHeader = "X-Relaypoint-Signature"
+
+// maxPayloadSize bounds how much a peer may send in one sync.
+const maxPayloadSize = 4 << 20
+
+// Provider contributes one section of local state to each sync and applies
+// the same section received from peers.
+type Provider interface {
+	// Name identifies the payload section, e.g. "ratelimit".
+	Name() string
+	// Snapshot returns the local state to push to every peer this round.
+	Snapshot() (json.RawMessage, error)
+	// Apply merges a peer's state into local state.
+	Apply(peer string, data json.RawMessage) error
+	// Forget discards anything learned from a peer that went silent.
+	Forget(peer string)
+}
+
+// Payload is the versioned document exchanged between peers.
+type Payload struct {
+	Version  int                        `json:"version"`
+	Node     string                     `json:"node"`
+	SentAt   time.Time                  `json:"sent_at"`
+	Sections map[string]json.RawMessage `json:"sections"`
+}
+
+// Config for creating a new Node
+type Config struct {
+	NodeID       string
+	Peers        []string // base URLs, e.g. http://10.0.0.2:8080
+	DiscoveryDNS string   // host:port resolved on every round
+	Path         string
+	Interval     time.Duration
+	Secret       string
+}
+
+// Node periodically pushes local state to its peers and accepts theirs.
+type Node struct {
+	cfg       Config
+	providers []Provider
+	client    *http.Client
+	metrics   *metrics.Metrics
+	logger    *slog.Logger
+	resolver  func(ctx context.Context, host string) ([]string, error)
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time // node ID -> last payload received
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode creates a node; call Start to begin syncing.
+func NewNode(cfg Config, providers []Provider, m *metrics.Metrics, logger *slog.Logger) *Node {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/_cluster/sync"
+	}
+
+	return &Node{
+		cfg:       cfg,
+		providers: providers,
+		client:    &http.Client{Timeout: cfg.Interval},
+		metrics:   m,
+		logger:    logger,
+		resolver:  net.DefaultResolver.LookupHost,
+		lastSeen:  make(map[string]time.Time),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start begins the sync loop.
+func (n *Node) Start() {
+	n.wg.Add(1)
+	go n.loop()
+}
+
+// Stop ends the sync loop and waits for it to exit.
+func (n *Node) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+}
+
+func (n *Node) loop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.SyncOnce(context.Background())
+			n.expirePeers(time.Now())
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// SyncOnce builds one payload and pushes it to every known peer.
+func (n *Node) SyncOnce(ctx context.Context) {
+	payload := Payload{
+		Version:  ProtocolVersion,
+		Node:     n.cfg.NodeID,
+		SentAt:   time.Now().UTC(),
+		Sections: make(map[string]json.RawMessage, len(n.providers)),
+	}
+	for _, p := range n.providers {
+		data, err := p.Snapshot()
+		if err != nil {
+			n.logger.Warn("cluster snapshot failed", "section", p.Name(), "error", err)
+			continue
+		}
+		payload.Sections[p.Name()] = data
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.logger.Error("cluster payload encoding failed", "error", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range n.peers(ctx) {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			err := n.push(ctx, peer, body)
+			if n.metrics != nil {
+				n.metrics.RecordPeerUp(peer, err == nil)
+			}
+			if err != nil {
+				n.logger.Warn("cluster sync failed", "peer", peer, "error", err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// peers returns the static peer list plus any addresses discovered via DNS.
+func (n *Node) peers(ctx context.Context) []string {
+	seen := make(map[string]bool)
+	var peers []string
+	for _, p := range n.cfg.Peers {
+		if !seen[p] {
+			seen[p] = true
+			peers = append(peers, p)
+		}
+	}
+
+	if n.cfg.DiscoveryDNS != "" {
+		host, port, err := net.SplitHostPort(n.cfg.DiscoveryDNS)
+		if err != nil {
+			n.logger.Warn("invalid cluster discovery address", "address", n.cfg.DiscoveryDNS, "error", err)
+			return peers
+		}
+		addrs, err := n.resolver(ctx, host)
+		if err != nil {
+			n.logger.Warn("cluster peer discovery failed", "host", host, "error", err)
+			return peers
+		}
+		sort.Strings(addrs)
+		for _, a := range addrs {
+			p := "http://" + net.JoinHostPort(a, port)
+			if !seen[p] {
+				seen[p] = true
+				peers = append(peers, p)
+			}
+		}
+	}
+	return peers
+}
+
+func (n *Node) push(ctx context.Context, peer string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+n.cfg.Path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, sign(n.cfg.Secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Handler accepts payloads pushed by peers.
+func (n *Node) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if !verify(n.cfg.Secret, body, r.Header.Get(signatureHeader)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if payload.Version != ProtocolVersion {
+			http.Error(w, fmt.Sprintf("unsupported protocol version %d", payload.Version), http.StatusConflict)
+			return
+		}
+		if payload.Node == n.cfg.NodeID {
+			// Discovered ourselves; nothing to merge.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		n.receive(payload, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (n *Node) receive(payload Payload, now time.Time) {
+	n.mu.Lock()
+	n.lastSeen[payload.Node] = now
+	n.mu.Unlock()
+
+	if n.metrics != nil {
+		n.metrics.RecordPeerSync(payload.Node, payload.SentAt)
+	}
+
+	for _, p := range n.providers {
+		data, ok := payload.Sections[p.Name()]
+		if !ok {
+			continue
+		}
+		if err := p.Apply(payload.Node, data); err != nil {
+			n.logger.Warn("cluster apply failed", "peer", payload.Node, "section", p.Name(), "error", err)
+		}
+	}
+}
+
+// expirePeers forgets peers that have not pushed for three intervals.
+func (n *Node) expirePeers(now time.Time) {
+	cutoff := now.Add(-3 * n.cfg.Interval)
+
+	n.mu.Lock()
+	var expired []string
+	for peer, seen := range n.lastSeen {
+		if seen.Before(cutoff) {
+			expired = append(expired, peer)
+			delete(n.lastSeen, peer)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, peer := range expired {
+		n.logger.Warn("cluster peer lost", "peer", peer)
+		for _, p := range n.providers {
+			p.Forget(peer)
+		}
+	}
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verify(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
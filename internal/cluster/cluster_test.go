@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// recordingProvider snapshots a fixed value and records what peers sent.
+type recordingProvider struct {
+	value string
+
+	mu        sync.Mutex
+	received  map[string]string
+	forgotten []string
+}
+
+func newRecordingProvider(value string) *recordingProvider {
+	return &recordingProvider{value: value, received: make(map[string]string)}
+}
+
+func (rp *recordingProvider) Name() string { return "test" }
+
+func (rp *recordingProvider) Snapshot() (json.RawMessage, error) {
+	return json.Marshal(rp.value)
+}
+
+func (rp *recordingProvider) Apply(peer string, data json.RawMessage) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.received[peer] = v
+	return nil
+}
+
+func (rp *recordingProvider) Forget(peer string) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.forgotten = append(rp.forgotten, peer)
+}
+
+type testNode struct {
+	node     *Node
+	provider *recordingProvider
+	server   *httptest.Server
+	metrics  *metrics.Metrics
+}
+
+func startCluster(t *testing.T, names ...string) []*testNode {
+	t.Helper()
+
+	nodes := make([]*testNode, len(names))
+	for i, name := range names {
+		provider := newRecordingProvider("state-of-" + name)
+		m := metrics.New(metrics.DefaultConfig())
+		node := NewNode(Config{NodeID: name, Secret: "s3cret", Interval: time.Second}, []Provider{provider}, m, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		mux := http.NewServeMux()
+		mux.Handle(node.cfg.Path, node.Handler())
+		server := httptest.NewServer(mux)
+		t.Cleanup(server.Close)
+		nodes[i] = &testNode{node: node, provider: provider, server: server, metrics: m}
+	}
+
+	for _, n := range nodes {
+		for _, other := range nodes {
+			if other != n {
+				n.node.cfg.Peers = append(n.node.cfg.Peers, other.server.URL)
+			}
+		}
+	}
+	return nodes
+}
+
+func TestNode_SyncConverges(t *testing.T) {
+	nodes := startCluster(t, "a", "b", "c")
+
+	for _, n := range nodes {
+		n.node.SyncOnce(context.Background())
+	}
+
+	for _, n := range nodes {
+		if len(n.provider.received) != 2 {
+			t.Errorf("node %s: expected state from 2 peers, got %v", n.node.cfg.NodeID, n.provider.received)
+		}
+		for peer, v := range n.provider.received {
+			if v != "state-of-"+peer {
+				t.Errorf("node %s: unexpected state from %s: %q", n.node.cfg.NodeID, peer, v)
+			}
+		}
+	}
+
+	out := scrape(nodes[0].metrics)
+	if !strings.Contains(out, "gateway_cluster_peer_up{peer=\""+nodes[1].server.URL+"\"} 1") {
+		t.Errorf("expected peer liveness gauge, got:\n%s", out)
+	}
+	if !strings.Contains(out, `gateway_cluster_sync_lag_seconds{peer="b"}`) {
+		t.Errorf("expected sync lag gauge, got:\n%s", out)
+	}
+}
+
+func TestNode_ToleratesPeerLoss(t *testing.T) {
+	nodes := startCluster(t, "a", "b", "c")
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	b.node.SyncOnce(context.Background())
+	c.server.Close()
+
+	a.node.SyncOnce(context.Background())
+	if a.provider.received["b"] == "" {
+		t.Fatal("expected a to have state from b")
+	}
+	if got := b.provider.received["a"]; got != "state-of-a" {
+		t.Errorf("sync to live peer should succeed despite a dead one, got %q", got)
+	}
+
+	out := scrape(a.metrics)
+	if !strings.Contains(out, "gateway_cluster_peer_up{peer=\""+c.server.URL+"\"} 0") {
+		t.Errorf("expected dead peer to be reported down, got:\n%s", out)
+	}
+
+	// b stops pushing; after three intervals a forgets it.
+	a.node.expirePeers(time.Now().Add(4 * time.Second))
+	if len(a.provider.forgotten) != 1 || a.provider.forgotten[0] != "b" {
+		t.Errorf("expected b to be forgotten, got %v", a.provider.forgotten)
+	}
+}
+
+func TestNode_RejectsUnauthenticatedPayload(t *testing.T) {
+	nodes := startCluster(t, "a")
+	body, _ := json.Marshal(Payload{Version: ProtocolVersion, Node: "mallory", Sections: map[string]json.RawMessage{"test": json.RawMessage(`"evil"`)}})
+
+	for _, sig := range []string{"", "deadbeef", sign("wrong-secret", body)} {
+		req := httptest.NewRequest(http.MethodPost, "/_cluster/sync", bytes.NewReader(body))
+		req.Header.Set(signatureHeader, sig)
+		rec := httptest.NewRecorder()
+		nodes[0].node.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("signature %q: expected 401, got %d", sig, rec.Code)
+		}
+	}
+	if len(nodes[0].provider.received) != 0 {
+		t.Error("unauthenticated state must not be applied")
+	}
+}
+
+func TestNode_RejectsUnknownVersion(t *testing.T) {
+	nodes := startCluster(t, "a")
+	body, _ := json.Marshal(Payload{Version: ProtocolVersion + 1, Node: "future"})
+
+	req := httptest.NewRequest(http.MethodPost, "/_cluster/sync", bytes.NewReader(body))
+	req.Header.Set(signatureHeader, sign("s3cret", body))
+	rec := httptest.NewRecorder()
+	nodes[0].node.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for unknown version, got %d", rec.Code)
+	}
+}
+
+func TestNode_DNSDiscovery(t *testing.T) {
+	nodes := startCluster(t, "a")
+	n := nodes[0].node
+	n.cfg.Peers = nil
+	n.cfg.DiscoveryDNS = "relaypoint.internal:8080"
+	n.resolver = func(ctx context.Context, host string) ([]string, error) {
+		if host != "relaypoint.internal" {
+			t.Errorf("unexpected lookup %q", host)
+		}
+		return []string{"10.0.0.2", "10.0.0.1"}, nil
+	}
+
+	peers := n.peers(context.Background())
+	expected := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+	if strings.Join(peers, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, peers)
+	}
+}
+
+func scrape(m *metrics.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
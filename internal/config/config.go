@@ -1,11 +1,25 @@
 package config
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/relaypoint/relaypoint/internal/secrets"
 )
 
 func DefaultConfig() *Config {
@@ -16,6 +30,12 @@ func DefaultConfig() *Config {
 			ReadTimeout:     30 * time.Second,
 			WriteTimeout:    30 * time.Second,
 			ShutdownTimeout: 10 * time.Second,
+			StripCredentials: StripCredentials{
+				Enabled: true,
+			},
+			ConnectionLimits: ConnectionLimits{
+				ReadHeaderTimeout: 10 * time.Second,
+			},
 		},
 		RateLimit: RateLimitConfig{
 			Enabled:         true,
@@ -25,12 +45,35 @@ func DefaultConfig() *Config {
 			PerAPIKey:       true,
 			CleanupInterval: 5 * time.Minute,
 		},
+		Quota: QuotaConfig{
+			Period: 24 * time.Hour,
+		},
 		Metrics: MetricsConfig{
 			Enabled:        true,
 			Port:           9090,
 			Path:           "/metrics",
 			LatencyBuckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
 		},
+		Cluster: ClusterConfig{
+			Path:     "/_cluster/sync",
+			Interval: 5 * time.Second,
+		},
+		FlightRecorder: FlightRecorderConfig{
+			Size:          2048,
+			SampleRate:    0.01,
+			SlowThreshold: 500 * time.Millisecond,
+		},
+		AccessLog: AccessLogConfig{
+			SampleRate: 1,
+		},
+		Tracing: TracingConfig{
+			SampleRatio: 1,
+		},
+		Capture: CaptureConfig{
+			MaxFileSize:  100 << 20,
+			MaxFiles:     5,
+			MaxPerSecond: 100,
+		},
 	}
 }
 
@@ -39,12 +82,23 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	return Parse(data)
+}
 
+// Parse builds a validated configuration from YAML, as Load does for a
+// file.
+func Parse(data []byte) (*Config, error) {
 	cfg := DefaultConfig()
 	if err := yaml.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if err := cfg.resolveSecrets(context.Background(), secrets.Default); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+
+	cfg.applyDefaults()
+
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
@@ -52,37 +106,1171 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// applyDefaults fills in values that depend on other fields and therefore
+// cannot be set up front in DefaultConfig.
+func (c *Config) applyDefaults() {
+	if c.Cluster.NodeID == "" {
+		if host, err := os.Hostname(); err == nil {
+			c.Cluster.NodeID = fmt.Sprintf("%s:%d", host, c.Server.Port)
+		}
+	}
+}
+
 func (c *Config) Validate() error {
 	if c.Server.Port <= 0 || c.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", c.Server.Port)
 	}
 
+	if c.Server.ProbePort != 0 {
+		if c.Server.ProbePort < 0 || c.Server.ProbePort > 65535 {
+			return fmt.Errorf("invalid server probe_port: %d", c.Server.ProbePort)
+		}
+		if c.Server.ProbePort == c.Server.Port || (c.Metrics.Enabled && c.Server.ProbePort == c.Metrics.Port) {
+			return fmt.Errorf("server probe_port %d is already in use by another listener", c.Server.ProbePort)
+		}
+	}
+
+	if c.Server.MaxBodySize < 0 {
+		return fmt.Errorf("server max_body_size cannot be negative")
+	}
+
+	if c.Server.CopyBufferSize < 0 {
+		return fmt.Errorf("server copy_buffer_size cannot be negative")
+	}
+
+	if c.Server.InitialHealthTimeout < 0 {
+		return fmt.Errorf("server initial_health_timeout cannot be negative")
+	}
+
+	if c.Server.PreStopDelay < 0 {
+		return fmt.Errorf("server pre_stop_delay cannot be negative")
+	}
+
+	if _, err := ParseIPPrefixes(c.Server.AllowIPs); err != nil {
+		return fmt.Errorf("server allow_ips: %w", err)
+	}
+	if _, err := ParseIPPrefixes(c.Server.DenyIPs); err != nil {
+		return fmt.Errorf("server deny_ips: %w", err)
+	}
+	if _, err := ParseIPPrefixes(c.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("server trusted_proxies: %w", err)
+	}
+	if err := c.Server.Forwarded.validate(); err != nil {
+		return fmt.Errorf("server forwarded: %w", err)
+	}
+
+	for _, name := range c.Server.StripCredentials.QueryParams {
+		if name == "" {
+			return fmt.Errorf("server strip_credentials query_params cannot contain empty names")
+		}
+	}
+
+	switch c.Server.RequestFraming {
+	case "", FramingEnforce, FramingReport, FramingOff:
+	default:
+		return fmt.Errorf("server request_framing must be enforce, report or off")
+	}
+
+	switch c.Server.PathMatching {
+	case "", PathMatchingDecoded, PathMatchingRaw:
+	default:
+		return fmt.Errorf("server path_matching must be decoded or raw")
+	}
+	if b := c.Server.BasePath; b != "" && (!strings.HasPrefix(b, "/") || strings.ContainsAny(b, "?#%") ||
+		(b != "/" && path.Clean(b) != strings.TrimSuffix(b, "/"))) {
+		return fmt.Errorf("server base_path must be a clean absolute path without query or escapes, got %q", b)
+	}
+	if s := c.Server.OutsideBasePathStatus; s != 0 && (s < 400 || s > 599) {
+		return fmt.Errorf("server outside_base_path_status must be between 400 and 599")
+	}
+	if c.Server.SlowRequestThreshold < 0 {
+		return fmt.Errorf("server slow_request_threshold cannot be negative")
+	}
+	if cl := c.Server.ConnectionLimits; cl.MaxPerIP < 0 || cl.ReadHeaderTimeout < 0 || cl.MinDataRate < 0 || cl.MinDataRateGrace < 0 {
+		return fmt.Errorf("server connection_limits cannot be negative")
+	}
+	if _, err := ParseIPPrefixes(c.Server.ConnectionLimits.Exempt); err != nil {
+		return fmt.Errorf("server connection_limits exempt: %w", err)
+	}
+
+	for _, m := range c.Server.DeniedMethods {
+		if !validMethod(m) {
+			return fmt.Errorf("server denied_methods: invalid method %q", m)
+		}
+	}
+
+	if c.Server.TLS != nil && (c.Server.TLS.CertFile == "" || c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server tls requires cert_file and key_file")
+	}
+
+	switch c.Mode {
+	case "", ModeActive, ModeReadOnly:
+	default:
+		return fmt.Errorf("mode must be active or read_only")
+	}
+	for _, m := range c.ReadOnly.Methods {
+		if m == "" || strings.ToUpper(m) != m {
+			return fmt.Errorf("read_only methods must be upper-case method names, got %q", m)
+		}
+	}
+	if c.ReadOnly.RetryAfter < 0 {
+		return fmt.Errorf("read_only retry_after cannot be negative")
+	}
+	if err := c.SecurityHeaders.validate(); err != nil {
+		return fmt.Errorf("security_headers: %w", err)
+	}
+	routeNames := make(map[string]bool)
+	for _, r := range c.Routes {
+		routeNames[r.Name], routeNames[r.Path] = true, true
+	}
+	testNames := make(map[string]bool)
+	for _, f := range c.Tests {
+		if f.Name == "" || testNames[f.Name] {
+			return fmt.Errorf("tests need a unique name, got %q", f.Name)
+		}
+		testNames[f.Name] = true
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("test %s: %w", f.Name, err)
+		}
+		if f.Expect.Route != "" && !routeNames[f.Expect.Route] {
+			return fmt.Errorf("test %s expects undefined route %s", f.Name, f.Expect.Route)
+		}
+	}
+	if c.RouteActivity.FlushInterval < 0 {
+		return fmt.Errorf("route_activity flush_interval cannot be negative")
+	}
+	if s := c.RouteActivity.ArchivedStatus; s != 0 && (s < 400 || s > 599) {
+		return fmt.Errorf("route_activity archived_status must be between 400 and 599")
+	}
+
+	if err := validateOnError(c.RateLimit.OnError); err != nil {
+		return fmt.Errorf("rate_limit %w", err)
+	}
+	if c.RateLimit.IPv4Prefix < 0 || c.RateLimit.IPv4Prefix > 32 {
+		return fmt.Errorf("rate_limit ipv4_prefix must be between 0 and 32")
+	}
+	if c.RateLimit.IPv6Prefix < 0 || c.RateLimit.IPv6Prefix > 128 {
+		return fmt.Errorf("rate_limit ipv6_prefix must be between 0 and 128")
+	}
+
+	if c.Quota.Enabled && (c.Quota.Requests <= 0 || c.Quota.Period <= 0) {
+		return fmt.Errorf("quota requires requests and period greater than 0")
+	}
+	if err := validateOnError(c.Quota.OnError); err != nil {
+		return fmt.Errorf("quota %w", err)
+	}
+
+	if err := c.Auth.JWT.validate(); err != nil {
+		return fmt.Errorf("auth.jwt %w", err)
+	}
+
+	if c.Secrets.RefreshInterval < 0 {
+		return fmt.Errorf("secrets refresh_interval cannot be negative")
+	}
+	if err := validateOnError(c.Secrets.OnError); err != nil {
+		return fmt.Errorf("secrets %w", err)
+	}
+
+	if c.Capture.File != "" && c.Capture.URL != "" {
+		return fmt.Errorf("capture accepts file or url, not both")
+	}
+	if c.Capture.MaxPerSecond < 0 {
+		return fmt.Errorf("capture max_per_second cannot be negative")
+	}
+
+	if c.FlightRecorder.Enabled {
+		if c.FlightRecorder.Size <= 0 {
+			return fmt.Errorf("flight_recorder size must be positive")
+		}
+		if c.FlightRecorder.SampleRate < 0 || c.FlightRecorder.SampleRate > 1 {
+			return fmt.Errorf("flight_recorder sample_rate must be between 0 and 1")
+		}
+	}
+
+	if c.AccessLog.SampleRate < 0 || c.AccessLog.SampleRate > 1 {
+		return fmt.Errorf("access_log sample_rate must be between 0 and 1")
+	}
+
+	switch c.ErrorResponses.Format {
+	case "", ErrorFormatJSON, ErrorFormatText:
+	default:
+		return fmt.Errorf("error_responses format must be json or text")
+	}
+	for status, t := range c.ErrorResponses.Templates {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("error_responses template for %d: status must be between 400 and 599", status)
+		}
+		if _, err := ParseErrorTemplate(t.Body); err != nil {
+			return fmt.Errorf("error_responses template for %d: %w", status, err)
+		}
+	}
+
+	if c.Tracing.Enabled {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("tracing endpoint must be an http or https URL")
+		}
+		if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("tracing sample_ratio must be between 0 and 1")
+		}
+	}
+
 	if len(c.Routes) == 0 {
 		return fmt.Errorf("at least one route must be defined")
 	}
 
 	upstreamMap := make(map[string]bool)
+	tokenAuth := make(map[string]bool) // upstreams whose auth sets Authorization
 	for _, u := range c.Upstreams {
 		if u.Name == "" {
 			return fmt.Errorf("upstream name cannot be empty")
 		}
-		if len(u.Targets) == 0 {
+		if len(u.Targets) == 0 && !c.Server.PartialStart {
 			return fmt.Errorf("upstream %s must have at least one target", u.Name)
 		}
+		switch u.Protocol {
+		case "", "http1", "h2", "h2c":
+		default:
+			return fmt.Errorf("upstream %s has unknown protocol %q", u.Name, u.Protocol)
+		}
+		if w := u.WaitForInitialHealth; w != nil && *w && u.HealthCheck == nil {
+			return fmt.Errorf("upstream %s wait_for_initial_health requires a health_check", u.Name)
+		}
+		if t := u.Transport; t != nil && (t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 ||
+			t.IdleConnTimeout < 0 || t.DialTimeout < 0 || t.ResponseHeaderTimeout < 0 || t.TLSHandshakeTimeout < 0) {
+			return fmt.Errorf("upstream %s transport settings cannot be negative", u.Name)
+		}
+		if u.TLS != nil && (u.TLS.CertFile == "") != (u.TLS.KeyFile == "") {
+			return fmt.Errorf("upstream %s tls requires both cert_file and key_file", u.Name)
+		}
+		if err := u.Auth.validate(); err != nil {
+			return fmt.Errorf("upstream %s auth: %w", u.Name, err)
+		}
+		tokenAuth[u.Name] = u.Auth != nil
+		standby := 0
+		for _, t := range u.Targets {
+			if t.ExpectedRTT.Duration < 0 {
+				return fmt.Errorf("upstream %s target %s expected_rtt cannot be negative", u.Name, t.URL)
+			}
+			if t.Standby {
+				standby++
+			}
+		}
+		if standby > 0 && standby == len(u.Targets) {
+			return fmt.Errorf("upstream %s needs a target that is not standby", u.Name)
+		}
 		upstreamMap[u.Name] = true
 	}
 
+	flags := make(map[string]bool)
+	for _, f := range c.FeatureFlags {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("feature flag %s: %w", f.Name, err)
+		}
+		if flags[f.Name] {
+			return fmt.Errorf("duplicate feature flag name: %s", f.Name)
+		}
+		flags[f.Name] = true
+	}
+
 	for _, r := range c.Routes {
 		if r.Path == "" {
 			return fmt.Errorf("route path cannot be empty")
 		}
+		for _, segment := range strings.Split(r.Path, "/") {
+			name, pattern, _ := ParsePathParam(segment)
+			if pattern == "" {
+				continue
+			}
+			if _, err := CompilePathPattern(pattern); err != nil {
+				return fmt.Errorf("route %s path parameter %s has invalid pattern: %w", r.Name, name, err)
+			}
+		}
+		switch r.TrailingSlash {
+		case "", TrailingSlashMerge, TrailingSlashStrict, TrailingSlashRedirect:
+		default:
+			return fmt.Errorf("route %s trailing_slash must be merge, strict or redirect", r.Name)
+		}
+		if r.Host != "" && len(r.Hosts) > 0 {
+			return fmt.Errorf("route %s cannot set both host and hosts", r.Name)
+		}
+		for _, host := range r.Hosts {
+			if host == "" {
+				return fmt.Errorf("route %s hosts cannot contain an empty host", r.Name)
+			}
+		}
+		for _, host := range append([]string{r.Host}, r.Hosts...) {
+			if strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+				return fmt.Errorf("route %s host %q may only use a wildcard as its first label, as in *.example.com", r.Name, host)
+			}
+		}
 		if r.Upstream == "" {
 			return fmt.Errorf("route %s must specify an upstream", r.Name)
 		}
 		if !upstreamMap[r.Upstream] {
 			return fmt.Errorf("route %s references unknown upstream %s", r.Name, r.Upstream)
 		}
+		if _, ok := r.Query[""]; ok {
+			return fmt.Errorf("route %s query parameter name cannot be empty", r.Name)
+		}
+		for name := range r.MatchHeaders {
+			if name == "" || strings.ContainsAny(name, " \t:\r\n") {
+				return fmt.Errorf("route %s match_headers has invalid header name %q", r.Name, name)
+			}
+		}
+		if r.MaxBodySize < 0 {
+			return fmt.Errorf("route %s has negative max_body_size", r.Name)
+		}
+		if r.MaxConcurrent < 0 {
+			return fmt.Errorf("route %s has negative max_concurrent", r.Name)
+		}
+		if q := r.ConcurrencyQueue; q != nil {
+			if r.MaxConcurrent == 0 {
+				return fmt.Errorf("route %s concurrency_queue requires max_concurrent", r.Name)
+			}
+			if q.Depth <= 0 || q.Timeout < 0 {
+				return fmt.Errorf("route %s concurrency_queue needs a positive depth and a non-negative timeout", r.Name)
+			}
+		}
+		if r.RequireJWT && c.Auth.JWT == nil {
+			return fmt.Errorf("route %s require_jwt needs auth.jwt", r.Name)
+		}
+		if a := r.AdaptiveConcurrency; a != nil {
+			if r.MaxConcurrent > 0 {
+				return fmt.Errorf("route %s cannot set both max_concurrent and adaptive_concurrency", r.Name)
+			}
+			if err := a.validate(); err != nil {
+				return fmt.Errorf("route %s adaptive_concurrency: %w", r.Name, err)
+			}
+		}
+		if h := r.Hedging; h != nil && (h.Delay <= 0 || h.MaxAttempts < 0 || h.MaxAttempts == 1) {
+			return fmt.Errorf("route %s hedging needs a positive delay and max_attempts of at least 2", r.Name)
+		}
+		if r.BodyMatch != nil {
+			if err := r.BodyMatch.validate(upstreamMap); err != nil {
+				return fmt.Errorf("route %s body_match: %w", r.Name, err)
+			}
+		}
+		if m := r.Mirror; m != nil {
+			if !upstreamMap[m.Upstream] {
+				return fmt.Errorf("route %s mirror references unknown upstream %s", r.Name, m.Upstream)
+			}
+			if m.Percent < 0 || m.Percent > 100 {
+				return fmt.Errorf("route %s mirror percent must be between 0 and 100", r.Name)
+			}
+			if m.MaxConcurrent < 0 {
+				return fmt.Errorf("route %s mirror has negative max_concurrent", r.Name)
+			}
+		}
+		for _, rules := range []*HeaderRules{r.RequestHeaders, r.ResponseHeaders} {
+			if rules == nil {
+				continue
+			}
+			for _, name := range rules.Remove {
+				if name == "" || name == "*" || strings.Contains(strings.TrimSuffix(name, "*"), "*") {
+					return fmt.Errorf("route %s has invalid header removal %q", r.Name, name)
+				}
+			}
+		}
+		if rw := r.Rewrite; rw != nil {
+			if rw.Pattern == "" {
+				return fmt.Errorf("route %s rewrite requires a pattern", r.Name)
+			}
+			if _, err := regexp.Compile(rw.Pattern); err != nil {
+				return fmt.Errorf("route %s rewrite has invalid pattern: %w", r.Name, err)
+			}
+		}
+		if r.UpstreamPath != "" {
+			if err := r.validateUpstreamPath(); err != nil {
+				return fmt.Errorf("route %s upstream_path: %w", r.Name, err)
+			}
+		}
+		for _, name := range r.FeatureFlags {
+			if !flags[name] {
+				return fmt.Errorf("route %s references unknown feature flag %s", r.Name, name)
+			}
+		}
+		if k := r.IdempotencyKeys; k != nil && (k.TTL < 0 || k.MaxBodySize < 0 || k.MaxEntries < 0) {
+			return fmt.Errorf("route %s idempotency_keys settings cannot be negative", r.Name)
+		}
+		if c := r.Cache; c != nil {
+			if c.TTL < 0 || c.NegativeTTL < 0 || c.MaxEntries < 0 || c.MaxBodySize < 0 {
+				return fmt.Errorf("route %s cache settings cannot be negative", r.Name)
+			}
+			if c.TTL == 0 && c.NegativeTTL == 0 {
+				return fmt.Errorf("route %s cache requires ttl or negative_ttl", r.Name)
+			}
+			for _, specs := range [][]string{c.VaryOn, c.BypassWhen} {
+				for _, spec := range specs {
+					if _, _, err := ParseCacheComponent(spec); err != nil {
+						return fmt.Errorf("route %s cache: %w", r.Name, err)
+					}
+				}
+			}
+			if err := validateOnError(c.OnError); err != nil {
+				return fmt.Errorf("route %s cache %w", r.Name, err)
+			}
+		}
+		if err := r.AllowedResponseContentTypes.validate(); err != nil {
+			return fmt.Errorf("route %s allowed_response_content_types: %w", r.Name, err)
+		}
+		if err := r.AutoValidators.validate(); err != nil {
+			return fmt.Errorf("route %s auto_validators: %w", r.Name, err)
+		}
+		if rc := r.Capture; rc != nil {
+			if c.Capture.File == "" && c.Capture.URL == "" {
+				return fmt.Errorf("route %s capture requires a capture file or url", r.Name)
+			}
+			if rc.SampleRate < 0 || rc.SampleRate > 1 {
+				return fmt.Errorf("route %s capture sample_rate must be between 0 and 1", r.Name)
+			}
+			if rc.MaxBodyBytes < 0 {
+				return fmt.Errorf("route %s capture max_body_bytes cannot be negative", r.Name)
+			}
+		}
+		if err := validatePipeline(r.Pipeline); err != nil {
+			return fmt.Errorf("route %s pipeline: %w", r.Name, err)
+		}
+		if _, err := ParseIPPrefixes(r.AllowIPs); err != nil {
+			return fmt.Errorf("route %s allow_ips: %w", r.Name, err)
+		}
+		if _, err := ParseIPPrefixes(r.DenyIPs); err != nil {
+			return fmt.Errorf("route %s deny_ips: %w", r.Name, err)
+		}
+		if rf := r.RequestFingerprint; rf != nil {
+			if rf.MaxBodyBytes < 0 || rf.Window < 0 {
+				return fmt.Errorf("route %s request_fingerprint max_body_bytes and window cannot be negative", r.Name)
+			}
+			for _, h := range rf.Headers {
+				if volatileHeaders[http.CanonicalHeaderKey(h)] {
+					return fmt.Errorf("route %s request_fingerprint cannot include %s, which changes on every request", r.Name, h)
+				}
+			}
+		}
+		if err := r.Forwarded.validate(); err != nil {
+			return fmt.Errorf("route %s forwarded: %w", r.Name, err)
+		}
+		if err := r.Maintenance.validate(); err != nil {
+			return fmt.Errorf("route %s maintenance: %w", r.Name, err)
+		}
+		if r.SlowRequestThreshold < 0 {
+			return fmt.Errorf("route %s slow_request_threshold cannot be negative", r.Name)
+		}
+		if r.ResponseIdleTimeout < 0 {
+			return fmt.Errorf("route %s response_idle_timeout cannot be negative", r.Name)
+		}
+		if err := r.DeadlinePropagation.validate(r.Timeout); err != nil {
+			return fmt.Errorf("route %s deadline_propagation: %w", r.Name, err)
+		}
+		if err := r.SecurityHeaders.validate(); err != nil {
+			return fmt.Errorf("route %s security_headers: %w", r.Name, err)
+		}
+		if err := r.RequestSchema.validate(); err != nil {
+			return fmt.Errorf("route %s request_schema: %w", r.Name, err)
+		}
+		if err := r.UpstreamAuth.validate(); err != nil {
+			return fmt.Errorf("route %s upstream_auth: %w", r.Name, err)
+		}
+		if tokenAuth[r.Upstream] && r.UpstreamAuth.setsAuthorization() {
+			return fmt.Errorf("route %s upstream_auth cannot set Authorization: upstream %s auth sets it", r.Name, r.Upstream)
+		}
+		if l := r.LearnBodyLimit; l != nil && (l.Rejections < 0 || l.Window < 0 || l.Reprobe < 0) {
+			return fmt.Errorf("route %s learn_body_limit rejections, window and reprobe cannot be negative", r.Name)
+		}
+		if r.Degradation != nil {
+			if err := r.Degradation.validate(); err != nil {
+				return fmt.Errorf("route %s degradation: %w", r.Name, err)
+			}
+		}
+		if len(r.MethodOverrideMethods) > 0 && !r.HonorMethodOverride {
+			return fmt.Errorf("route %s method_override_methods requires honor_method_override", r.Name)
+		}
+		for _, m := range r.MethodOverrideMethods {
+			if !validMethod(m) {
+				return fmt.Errorf("route %s method_override_methods: invalid method %q", r.Name, m)
+			}
+		}
+		for from, to := range r.MethodMap {
+			if !validMethod(from) || !validMethod(to) {
+				return fmt.Errorf("route %s method_map: invalid mapping %q to %q", r.Name, from, to)
+			}
+		}
+		for _, m := range r.DeniedMethods {
+			if !validMethod(m) {
+				return fmt.Errorf("route %s denied_methods: invalid method %q", r.Name, m)
+			}
+		}
+		if r.PreserveHost && r.UpstreamHost != "" {
+			return fmt.Errorf("route %s cannot set both preserve_host and upstream_host", r.Name)
+		}
+		if c := r.Compression; c != nil {
+			if c.MinSize < 0 {
+				return fmt.Errorf("route %s compression has negative min_size", r.Name)
+			}
+			if c.Level < 0 || c.Level > 9 {
+				return fmt.Errorf("route %s compression level must be between 1 and 9", r.Name)
+			}
+		}
+	}
+
+	for _, k := range c.APIKeys {
+		if k.Name == "" {
+			return fmt.Errorf("api key name cannot be empty")
+		}
+		if (k.Key == "") == (k.KeyHash == "") {
+			return fmt.Errorf("api key %s must set exactly one of key or key_hash", k.Name)
+		}
+		if _, err := k.Digest(); err != nil {
+			return fmt.Errorf("api key %s: %w", k.Name, err)
+		}
+	}
+
+	if c.Degradation != nil {
+		if err := c.Degradation.validate(); err != nil {
+			return fmt.Errorf("degradation: %w", err)
+		}
+	}
+
+	probes := make(map[string]bool)
+	for _, sp := range c.SyntheticProbes {
+		if sp.Name == "" {
+			return fmt.Errorf("synthetic probe name cannot be empty")
+		}
+		if probes[sp.Name] {
+			return fmt.Errorf("duplicate synthetic probe name: %s", sp.Name)
+		}
+		probes[sp.Name] = true
+		if !strings.HasPrefix(sp.Path, "/") {
+			return fmt.Errorf("synthetic probe %s path must start with /", sp.Name)
+		}
+		if sp.ExpectStatus != 0 && (sp.ExpectStatus < 100 || sp.ExpectStatus > 599) {
+			return fmt.Errorf("synthetic probe %s expect_status must be between 100 and 599", sp.Name)
+		}
+		if sp.Interval < 0 || sp.Timeout < 0 {
+			return fmt.Errorf("synthetic probe %s interval and timeout cannot be negative", sp.Name)
+		}
+	}
+
+	if c.Cluster.Enabled {
+		if c.Cluster.Secret == "" {
+			return fmt.Errorf("cluster secret is required when clustering is enabled")
+		}
+		if len(c.Cluster.Peers) == 0 && c.Cluster.DiscoveryDNS == "" {
+			return fmt.Errorf("cluster requires peers or discovery_dns")
+		}
+		if c.Cluster.NodeID == "" {
+			return fmt.Errorf("cluster node_id is required")
+		}
+	}
+
+	return nil
+}
+
+func (b *BodyMatch) validate(upstreams map[string]bool) error {
+	if len(b.Rules) == 0 {
+		return fmt.Errorf("at least one rule is required")
+	}
+	for _, rule := range b.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name cannot be empty")
+		}
+		if rule.Pointer != "" && !strings.HasPrefix(rule.Pointer, "/") {
+			return fmt.Errorf("rule %s: pointer %q must start with /", rule.Name, rule.Pointer)
+		}
+		conditions := 0
+		if rule.Equals != nil {
+			conditions++
+		}
+		if rule.Contains != "" {
+			conditions++
+		}
+		if rule.Regex != "" {
+			conditions++
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				return fmt.Errorf("rule %s: invalid regex: %w", rule.Name, err)
+			}
+		}
+		if conditions != 1 {
+			return fmt.Errorf("rule %s must set exactly one of equals, contains or regex", rule.Name)
+		}
+		if !upstreams[rule.Upstream] {
+			return fmt.Errorf("rule %s references unknown upstream %s", rule.Name, rule.Upstream)
+		}
+	}
+	return nil
+}
+
+// validMethod reports whether m is usable as an HTTP method name.
+func validMethod(m string) bool {
+	return m != "" && strings.IndexFunc(m, func(c rune) bool {
+		return (c < 'A' || c > 'Z') && (c < 'a' || c > 'z')
+	}) == -1
+}
+
+// volatileHeaders differ between otherwise identical requests, such as
+// retries, so they would make every request fingerprint unique.
+var volatileHeaders = map[string]bool{
+	"Date":                  true,
+	"Traceparent":           true,
+	"Tracestate":            true,
+	"Baggage":               true,
+	"X-Request-Id":          true,
+	"X-Amzn-Trace-Id":       true,
+	"X-Cloud-Trace-Context": true,
+	"X-B3-Traceid":          true,
+	"X-B3-Spanid":           true,
+	"X-B3-Parentspanid":     true,
+	"X-B3-Sampled":          true,
+	"B3":                    true,
+	"Uber-Trace-Id":         true,
+	"Sentry-Trace":          true,
+}
+
+func (d *Degradation) validate() error {
+	if d.Interval < 0 || d.Window < 0 {
+		return fmt.Errorf("interval and window cannot be negative")
+	}
+	if d.Window > 0 && d.Window < d.Interval {
+		return fmt.Errorf("window must be at least the interval")
+	}
+	if len(d.Levels) == 0 {
+		return fmt.Errorf("at least one level is required")
+	}
+	for i, l := range d.Levels {
+		name := l.Name
+		if name == "" {
+			name = fmt.Sprint(i + 1)
+		}
+		switch l.Action {
+		case DegradeDisableTransforms, DegradeServeStale, DegradeReject:
+		case DegradeShed:
+			if l.ShedPercent <= 0 || l.ShedPercent > 100 {
+				return fmt.Errorf("level %s shed_percent must be between 0 and 100", name)
+			}
+		default:
+			return fmt.Errorf("level %s has unknown action %q", name, l.Action)
+		}
+		if l.Enter == (DegradationThresholds{}) {
+			return fmt.Errorf("level %s needs at least one enter threshold", name)
+		}
+		for _, t := range []DegradationThresholds{l.Enter, l.Exit} {
+			if t.InFlight < 0 || t.Latency < 0 || t.ErrorRate < 0 || t.ErrorRate > 1 {
+				return fmt.Errorf("level %s thresholds must be non-negative and error_rate at most 1", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePipeline checks a route's custom stage order. An empty pipeline
+// means DefaultPipeline.
+func (f *FeatureFlag) validate() error {
+	if !validFlagToken(f.Name) {
+		return fmt.Errorf("name must be letters, digits, '-', '_' or '.'")
+	}
+	if f.Default != "" && !validFlagToken(f.Default) {
+		return fmt.Errorf("default must be letters, digits, '-', '_' or '.'")
+	}
+	switch f.BucketBy {
+	case "", FlagBucketAPIKey, FlagBucketClientIP:
+	default:
+		if _, _, err := ParseCacheComponent(f.BucketBy); err != nil {
+			return fmt.Errorf("bucket_by must be api_key, client_ip or a header:, cookie: or query: component")
+		}
+	}
+	if f.Header != "" && !validFlagToken(f.Header) {
+		return fmt.Errorf("header %q is not a valid header name", f.Header)
+	}
+	for i, rule := range f.Rules {
+		if !validFlagToken(rule.Variant) {
+			return fmt.Errorf("rule %d variant must be letters, digits, '-', '_' or '.'", i+1)
+		}
+		if rule.Percent != nil && (*rule.Percent < 0 || *rule.Percent > 100) {
+			return fmt.Errorf("rule %d percent must be between 0 and 100", i+1)
+		}
+		for name := range rule.Headers {
+			if name == "" {
+				return fmt.Errorf("rule %d headers cannot contain empty names", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// validFlagToken reports whether s can be a feature flag name or variant,
+// which appear unquoted in X-Feature-Flags.
+func validFlagToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validatePipeline(stages []string) error {
+	if len(stages) == 0 {
+		return nil
+	}
+	known := make(map[string]bool, len(DefaultPipeline))
+	for _, name := range DefaultPipeline {
+		known[name] = true
+	}
+	seen := make(map[string]bool, len(stages))
+	for _, name := range stages {
+		if !known[name] {
+			return fmt.Errorf("unknown stage %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate stage %q", name)
+		}
+		seen[name] = true
+	}
+	if stages[len(stages)-1] != "proxy" {
+		return fmt.Errorf(`"proxy" must be the last stage`)
+	}
+	return nil
+}
+
+// Digest returns the SHA-256 digest the key is matched by, computed from
+// Key or decoded from KeyHash.
+func (k *APIKey) Digest() ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	if k.KeyHash == "" {
+		return sha256.Sum256([]byte(k.Key)), nil
+	}
+	hexDigest, ok := strings.CutPrefix(k.KeyHash, "sha256:")
+	if !ok {
+		return digest, fmt.Errorf("key_hash must start with sha256:")
+	}
+	b, err := hex.DecodeString(hexDigest)
+	if err != nil || len(b) != sha256.Size {
+		return digest, fmt.Errorf("key_hash must be sha256: followed by 64 hex digits")
+	}
+	copy(digest[:], b)
+	return digest, nil
+}
+
+// upstreamPathPlaceholder matches the {name} placeholders of an
+// upstream_path.
+var upstreamPathPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validateUpstreamPath checks that every placeholder of the route's
+// upstream_path is captured when the route matches: a parameter of its
+// path, {**} for a trailing wildcard, or host.<label> for a wildcard host.
+func (r *Route) validateUpstreamPath() error {
+	if !strings.HasPrefix(r.UpstreamPath, "/") {
+		return fmt.Errorf("must start with /")
+	}
+	if r.Rewrite != nil {
+		return fmt.Errorf("cannot be combined with rewrite")
+	}
+	captured := make(map[string]bool)
+	segments := strings.Split(strings.Trim(r.Path, "/"), "/")
+	for _, segment := range segments {
+		if name, _, ok := ParsePathParam(segment); ok {
+			captured[name] = true
+		}
+	}
+	if last := segments[len(segments)-1]; last == "*" || last == "**" {
+		captured["**"] = true
+	}
+	for _, host := range append([]string{r.Host}, r.Hosts...) {
+		if rest, ok := strings.CutPrefix(host, "*."); ok {
+			label, _, _ := strings.Cut(rest, ".")
+			captured["host."+strings.ToLower(label)] = true
+		}
+	}
+	for _, m := range upstreamPathPlaceholder.FindAllStringSubmatch(r.UpstreamPath, -1) {
+		if !captured[m[1]] {
+			return fmt.Errorf("placeholder {%s} is not captured by the route", m[1])
+		}
+	}
+	return nil
+}
+
+// Warnings reports settings that are valid but probably not what was
+// meant, such as routes that share an explicit priority.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	byPriority := make(map[int]string)
+	for _, r := range c.Routes {
+		if r.Priority == 0 {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = r.Path
+		}
+		if other, ok := byPriority[r.Priority]; ok {
+			warnings = append(warnings, fmt.Sprintf("routes %s and %s both have priority %d; their computed priority decides which is tried first", other, name, r.Priority))
+			continue
+		}
+		byPriority[r.Priority] = name
+	}
+	return warnings
+}
+
+// ParseErrorTemplate parses the body of an error_responses template.
+func ParseErrorTemplate(body string) (*template.Template, error) {
+	if body == "" {
+		return nil, fmt.Errorf("body cannot be empty")
 	}
+	return template.New("error").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(body)
+}
+
+// ParseCacheComponent splits a cache key component such as
+// header:X-Currency into its kind and name.
+func ParseCacheComponent(spec string) (kind, name string, err error) {
+	kind, name, _ = strings.Cut(spec, ":")
+	switch kind {
+	case CacheComponentHeader, CacheComponentCookie, CacheComponentQuery:
+	default:
+		return "", "", fmt.Errorf("component %q must be header:, cookie: or query: followed by a name", spec)
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("component %q has no name", spec)
+	}
+	return kind, name, nil
+}
 
+// ParsePathParam parses a segment of a route path that names a parameter,
+// :id or {id}, optionally constrained to a regular expression as in
+// :id(\d+) or {id:[0-9]+}. pattern is empty when there is no constraint;
+// ok is false for any other segment.
+func ParsePathParam(segment string) (name, pattern string, ok bool) {
+	switch {
+	case strings.HasPrefix(segment, ":"):
+		name = segment[1:]
+		if i := strings.IndexByte(name, '('); i >= 0 && strings.HasSuffix(name, ")") {
+			name, pattern = name[:i], name[i+1:len(name)-1]
+		}
+		return name, pattern, true
+	case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+		name, pattern, _ = strings.Cut(segment[1:len(segment)-1], ":")
+		return name, pattern, true
+	}
+	return "", "", false
+}
+
+// CompilePathPattern compiles the pattern of a path parameter to match
+// its whole segment.
+func CompilePathPattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// ParseIPPrefixes parses a list of CIDRs. A bare address is taken as a
+// single-host prefix.
+func ParseIPPrefixes(list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range list {
+		if prefix, err := netip.ParsePrefix(v); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", v)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+func (a *AdaptiveConcurrency) validate() error {
+	switch a.Algorithm {
+	case "", AdaptiveGradient, AdaptiveAIMD:
+	default:
+		return fmt.Errorf("algorithm must be gradient or aimd, got %q", a.Algorithm)
+	}
+	if a.MinLimit < 0 || a.MaxLimit < 0 || a.InitialLimit < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if a.MaxLimit > 0 && a.MinLimit > a.MaxLimit {
+		return fmt.Errorf("min_limit %d exceeds max_limit %d", a.MinLimit, a.MaxLimit)
+	}
+	if a.Tolerance != 0 && a.Tolerance < 1 {
+		return fmt.Errorf("tolerance must be at least 1")
+	}
 	return nil
 }
+
+func (f *Forwarded) validate() error {
+	if f == nil {
+		return nil
+	}
+	switch f.Mode {
+	case "", ForwardedLegacy, ForwardedStandard, ForwardedBoth:
+	default:
+		return fmt.Errorf("mode must be x_forwarded, forwarded or both, got %q", f.Mode)
+	}
+	for _, name := range f.Omit {
+		if !slices.ContainsFunc(ForwardedHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			return fmt.Errorf("omit: %q is not one of %s", name, strings.Join(ForwardedHeaders, ", "))
+		}
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts a duration or "auto".
+func (e *ExpectedRTT) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Value == "auto" {
+		*e = ExpectedRTT{Auto: true}
+		return nil
+	}
+	*e = ExpectedRTT{}
+	return node.Decode(&e.Duration)
+}
+
+// UnmarshalYAML accepts the schema file name on its own as well as the full
+// form.
+func (s *RequestSchema) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.File)
+	}
+	type plain RequestSchema
+	return node.Decode((*plain)(s))
+}
+
+// UnmarshalYAML accepts the list of types on its own as well as the full
+// block.
+func (c *ResponseContentTypes) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&c.Types)
+	}
+	type plain ResponseContentTypes
+	return node.Decode((*plain)(c))
+}
+
+func (c *ResponseContentTypes) validate() error {
+	if c == nil {
+		return nil
+	}
+	if len(c.Types) == 0 {
+		return fmt.Errorf("at least one type is required")
+	}
+	for _, t := range c.Types {
+		if typ, sub, ok := strings.Cut(t, "/"); !ok || typ == "" || sub == "" || strings.ContainsAny(t, " ;,") {
+			return fmt.Errorf("invalid media type %q", t)
+		}
+	}
+	switch c.Mode {
+	case "", "reject", "sanitize":
+	default:
+		return fmt.Errorf("mode must be reject or sanitize, got %q", c.Mode)
+	}
+	switch c.Missing {
+	case "", "violation", "sniff":
+	default:
+		return fmt.Errorf("missing must be violation or sniff, got %q", c.Missing)
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts true for the default settings and false for none,
+// as well as the full block.
+func (v *AutoValidators) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var on bool
+		if err := node.Decode(&on); err != nil {
+			return err
+		}
+		v.off = !on
+		return nil
+	}
+	type plain AutoValidators
+	return node.Decode((*plain)(v))
+}
+
+func (v *AutoValidators) validate() error {
+	if v == nil {
+		return nil
+	}
+	if v.TTL < 0 || v.MaxBodySize < 0 || v.MaxEntries < 0 {
+		return fmt.Errorf("settings cannot be negative")
+	}
+	return nil
+}
+
+func (m *Maintenance) validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.Status != 0 && (m.Status < 400 || m.Status > 599) {
+		return fmt.Errorf("status must be between 400 and 599, got %d", m.Status)
+	}
+	if m.RetryAfter < 0 {
+		return fmt.Errorf("retry_after cannot be negative")
+	}
+	return nil
+}
+
+func (d *DeadlinePropagation) validate(timeout time.Duration) error {
+	if d == nil {
+		return nil
+	}
+	for _, h := range []string{d.Header, d.ClientHeader} {
+		if strings.ContainsAny(h, " \t:\r\n") {
+			return fmt.Errorf("invalid header name %q", h)
+		}
+	}
+	if d.MaxClientTimeout < 0 || d.MinRemaining < 0 {
+		return fmt.Errorf("durations cannot be negative")
+	}
+	if d.MaxClientTimeout > 0 && d.ClientHeader == "" {
+		return fmt.Errorf("max_client_timeout requires client_header")
+	}
+	if timeout > 0 && d.MinRemaining >= timeout {
+		return fmt.Errorf("min_remaining %s leaves no time within the route timeout %s", d.MinRemaining, timeout)
+	}
+	return nil
+}
+
+func (h *SecurityHeaders) validate() error {
+	if h == nil {
+		return nil
+	}
+	switch strings.ToLower(h.ContentTypeOptions) {
+	case "", "nosniff", SecurityHeaderOff:
+	default:
+		return fmt.Errorf("content_type_options must be nosniff or off")
+	}
+	switch strings.ToUpper(h.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN", "OFF":
+	default:
+		return fmt.Errorf("frame_options must be DENY, SAMEORIGIN or off")
+	}
+	for _, v := range []string{h.StrictTransportSecurity, h.ReferrerPolicy, h.ContentSecurityPolicy} {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("header values cannot contain line breaks")
+		}
+	}
+	return nil
+}
+
+func (s *RequestSchema) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.File == "" {
+		return fmt.Errorf("file is required")
+	}
+	if s.MaxSize < 0 {
+		return fmt.Errorf("max_size cannot be negative")
+	}
+	for _, m := range s.SkipMethods {
+		if m == "" || m != strings.ToUpper(m) {
+			return fmt.Errorf("skip_methods must be upper-case method names, got %q", m)
+		}
+	}
+	return nil
+}
+
+// setsAuthorization reports whether a sends its credential as
+// Authorization.
+func (a *UpstreamAuth) setsAuthorization() bool {
+	if a == nil {
+		return false
+	}
+	return a.BearerToken != "" || a.Basic != nil || (a.Header != nil && strings.EqualFold(a.Header.Name, "Authorization"))
+}
+
+func (a *UpstreamTokenAuth) validate() error {
+	if a == nil {
+		return nil
+	}
+	if a.Type != AuthOAuth2ClientCredentials {
+		return fmt.Errorf("type must be %s", AuthOAuth2ClientCredentials)
+	}
+	u, err := url.Parse(a.TokenURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("token_url must be an http or https URL")
+	}
+	if a.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	if a.RefreshMargin < 0 {
+		return fmt.Errorf("refresh_margin cannot be negative")
+	}
+	return validateOnError(a.OnError)
+}
+
+func (a *JWTAuth) validate() error {
+	if a == nil {
+		return nil
+	}
+	u, err := url.Parse(a.JWKSURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("jwks_url must be an http or https URL")
+	}
+	if a.RefreshInterval < 0 {
+		return fmt.Errorf("refresh_interval cannot be negative")
+	}
+	return validateOnError(a.OnError)
+}
+
+func (a *UpstreamAuth) validate() error {
+	if a == nil {
+		return nil
+	}
+	set := 0
+	if a.BearerToken != "" {
+		set++
+	}
+	if a.Basic != nil {
+		set++
+		if a.Basic.Username == "" {
+			return fmt.Errorf("basic requires a username")
+		}
+	}
+	if a.Header != nil {
+		set++
+		if a.Header.Name == "" || strings.ContainsAny(a.Header.Name, " \t:\r\n") {
+			return fmt.Errorf("invalid header name %q", a.Header.Name)
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("set only one of bearer_token, basic and header")
+	}
+	if set == 0 && !a.StripClientAuth {
+		return fmt.Errorf("set bearer_token, basic or header")
+	}
+	return nil
+}
+
+func (f *Fixture) validate() error {
+	if !strings.HasPrefix(f.Request.Path, "/") {
+		return fmt.Errorf("request path must start with /")
+	}
+	if f.Request.Method != "" && !validMethod(f.Request.Method) {
+		return fmt.Errorf("invalid request method %q", f.Request.Method)
+	}
+	e := f.Expect
+	if e.Status != 0 && (e.Status < 100 || e.Status > 599) {
+		return fmt.Errorf("invalid expected status %d", e.Status)
+	}
+	if e.Route == "" && e.UpstreamURL == "" && len(e.UpstreamHeaders) == 0 && e.Status == 0 {
+		return fmt.Errorf("expect checks nothing")
+	}
+	return nil
+}
+
+// validateOnError checks a subsystem's on_error policy; empty means the
+// subsystem's default.
+func validateOnError(policy string) error {
+	switch policy {
+	case "", OnErrorAllow, OnErrorDeny, OnErrorDegrade:
+		return nil
+	}
+	return fmt.Errorf("on_error must be allow, deny or degrade, got %q", policy)
+}
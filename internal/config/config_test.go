@@ -0,0 +1,1493 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidate_RejectsInvalidRewrite(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []Route{{
+		Name: "bad", Path: "/x", Upstream: "backend",
+		Rewrite: &Rewrite{Pattern: "^/x/(", Replacement: "/y"},
+	}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an invalid rewrite pattern to fail validation")
+	}
+}
+
+func TestValidate_RejectsConflictingHostOptions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []Route{{
+		Name: "bad", Path: "/x", Upstream: "backend",
+		PreserveHost: true, UpstreamHost: "internal.example.com",
+	}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected preserve_host with upstream_host to fail validation")
+	}
+}
+
+func TestValidate_Pipeline(t *testing.T) {
+	tests := []struct {
+		pipeline []string
+		valid    bool
+	}{
+		{nil, true},
+		{[]string{"proxy"}, true},
+		{[]string{"cache", "ratelimit", "proxy"}, true},
+		{[]string{"auth", "proxy"}, false},
+		{[]string{"ratelimit", "ratelimit", "proxy"}, false},
+		{[]string{"proxy", "cache"}, false},
+		{[]string{"ratelimit"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Pipeline: tt.pipeline}}
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("pipeline %v: Validate() = %v, want valid=%v", tt.pipeline, err, tt.valid)
+		}
+	}
+}
+
+func TestValidate_RouteCaptureRequiresSink(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []Route{{
+		Name: "r", Path: "/x", Upstream: "backend",
+		Capture: &RouteCapture{SampleRate: 0.1},
+	}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected route capture without a capture sink to fail validation")
+	}
+
+	cfg.Capture.File = "/var/lib/relaypoint/capture.jsonl"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	cfg.Routes[0].Capture.SampleRate = 1.5
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected a sample rate above 1 to fail validation")
+	}
+}
+
+func TestValidate_APIKeys(t *testing.T) {
+	const hash = "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	tests := []struct {
+		name string
+		key  APIKey
+		ok   bool
+	}{
+		{"plaintext", APIKey{Name: "a", Key: "test"}, true},
+		{"hash", APIKey{Name: "a", KeyHash: hash}, true},
+		{"neither", APIKey{Name: "a"}, false},
+		{"both", APIKey{Name: "a", Key: "test", KeyHash: hash}, false},
+		{"no algorithm", APIKey{Name: "a", KeyHash: hash[len("sha256:"):]}, false},
+		{"short digest", APIKey{Name: "a", KeyHash: "sha256:9f86d081"}, false},
+		{"no name", APIKey{Key: "test"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.APIKeys = []APIKey{tt.key}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestAPIKey_Digest(t *testing.T) {
+	plain := APIKey{Key: "test"}
+	hashed := APIKey{KeyHash: "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	a, err := plain.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := hashed.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Error("key and key_hash of the same secret have different digests")
+	}
+}
+
+func TestValidate_SyntheticProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes []SyntheticProbe
+		ok     bool
+	}{
+		{"valid", []SyntheticProbe{{Name: "a", Path: "/health"}, {Name: "b", Path: "/x?y=1", ExpectStatus: 204}}, true},
+		{"no name", []SyntheticProbe{{Path: "/"}}, false},
+		{"duplicate", []SyntheticProbe{{Name: "a", Path: "/"}, {Name: "a", Path: "/x"}}, false},
+		{"relative path", []SyntheticProbe{{Name: "a", Path: "health"}}, false},
+		{"bad status", []SyntheticProbe{{Name: "a", Path: "/", ExpectStatus: 42}}, false},
+		{"negative interval", []SyntheticProbe{{Name: "a", Path: "/", Interval: -1}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.SyntheticProbes = tt.probes
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_IPLists(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		ok    bool
+	}{
+		{"cidrs", []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.1.2.3/32"}, true},
+		{"bare addresses", []string{"10.0.0.1", "::1"}, nil, true},
+		{"bad prefix length", []string{"10.0.0.0/33"}, nil, false},
+		{"not an address", nil, []string{"office"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", AllowIPs: tt.allow, DenyIPs: tt.deny}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("route %s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+
+		cfg.Routes[0].AllowIPs, cfg.Routes[0].DenyIPs = nil, nil
+		cfg.Server.AllowIPs, cfg.Server.DenyIPs = tt.allow, tt.deny
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("server %s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Degradation(t *testing.T) {
+	enter := DegradationThresholds{InFlight: 100}
+	tests := []struct {
+		name string
+		d    Degradation
+		ok   bool
+	}{
+		{"valid", Degradation{Levels: []DegradationLevel{
+			{Action: DegradeDisableTransforms, Enter: enter},
+			{Action: DegradeShed, ShedPercent: 25, Enter: DegradationThresholds{Latency: time.Second}},
+		}}, true},
+		{"no levels", Degradation{}, false},
+		{"unknown action", Degradation{Levels: []DegradationLevel{{Action: "panic", Enter: enter}}}, false},
+		{"shed without percent", Degradation{Levels: []DegradationLevel{{Action: DegradeShed, Enter: enter}}}, false},
+		{"no enter threshold", Degradation{Levels: []DegradationLevel{{Action: DegradeReject}}}, false},
+		{"error rate above 1", Degradation{Levels: []DegradationLevel{{Action: DegradeReject, Enter: DegradationThresholds{ErrorRate: 2}}}}, false},
+		{"window below interval", Degradation{Interval: time.Minute, Window: time.Second, Levels: []DegradationLevel{{Action: DegradeReject, Enter: enter}}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Degradation: &tt.d}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RequestFingerprint(t *testing.T) {
+	tests := []struct {
+		name string
+		rf   RequestFingerprint
+		ok   bool
+	}{
+		{"defaults", RequestFingerprint{}, true},
+		{"stable headers", RequestFingerprint{Headers: []string{"Content-Type", "x-tenant"}}, true},
+		{"date", RequestFingerprint{Headers: []string{"Date"}}, false},
+		{"trace header any case", RequestFingerprint{Headers: []string{"TRACEPARENT"}}, false},
+		{"negative body bytes", RequestFingerprint{MaxBodyBytes: -1}, false},
+		{"negative window", RequestFingerprint{Window: -time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", RequestFingerprint: &tt.rf}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Tracing(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing TracingConfig
+		ok      bool
+	}{
+		{"disabled", TracingConfig{Endpoint: "not a url"}, true},
+		{"otlp http", TracingConfig{Enabled: true, Endpoint: "http://tempo:4318/v1/traces", SampleRatio: 0.1}, true},
+		{"no endpoint", TracingConfig{Enabled: true}, false},
+		{"grpc scheme", TracingConfig{Enabled: true, Endpoint: "grpc://tempo:4317"}, false},
+		{"ratio above 1", TracingConfig{Enabled: true, Endpoint: "http://tempo:4318/v1/traces", SampleRatio: 1.5}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Tracing = tt.tracing
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_MethodOverride(t *testing.T) {
+	tests := []struct {
+		name  string
+		route Route
+		ok    bool
+	}{
+		{"override", Route{HonorMethodOverride: true, MethodOverrideMethods: []string{"PUT", "delete"}}, true},
+		{"methods without override", Route{MethodOverrideMethods: []string{"PUT"}}, false},
+		{"invalid override method", Route{HonorMethodOverride: true, MethodOverrideMethods: []string{"P UT"}}, false},
+		{"map", Route{MethodMap: map[string]string{"post": "PUT"}}, true},
+		{"empty map target", Route{MethodMap: map[string]string{"POST": ""}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		tt.route.Name, tt.route.Path, tt.route.Upstream = "r", "/x", "backend"
+		cfg.Routes = []Route{tt.route}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_ErrorResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		er   ErrorResponses
+		ok   bool
+	}{
+		{"default", ErrorResponses{}, true},
+		{"text", ErrorResponses{Format: ErrorFormatText}, true},
+		{"unknown format", ErrorResponses{Format: "xml"}, false},
+		{"template", ErrorResponses{Templates: map[int]ErrorTemplate{429: {Body: `{"detail":{{json .Message}}}`}}}, true},
+		{"template for success", ErrorResponses{Templates: map[int]ErrorTemplate{200: {Body: "ok"}}}, false},
+		{"empty template", ErrorResponses{Templates: map[int]ErrorTemplate{502: {}}}, false},
+		{"bad template", ErrorResponses{Templates: map[int]ErrorTemplate{502: {Body: "{{.Code"}}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.ErrorResponses = tt.er
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RequestFraming(t *testing.T) {
+	for _, mode := range []string{"", FramingEnforce, FramingReport, FramingOff, "strict"} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.RequestFraming = mode
+		if err := cfg.Validate(); (err == nil) != (mode != "strict") {
+			t.Errorf("request_framing %q: Validate() = %v", mode, err)
+		}
+	}
+}
+
+func TestValidate_PathMatching(t *testing.T) {
+	for _, mode := range []string{"", PathMatchingDecoded, PathMatchingRaw, "exact"} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.PathMatching = mode
+		if err := cfg.Validate(); (err == nil) != (mode != "exact") {
+			t.Errorf("path_matching %q: Validate() = %v", mode, err)
+		}
+	}
+}
+
+func TestValidate_BasePath(t *testing.T) {
+	tests := []struct {
+		basePath string
+		status   int
+		ok       bool
+	}{
+		{"", 0, true},
+		{"/", 0, true},
+		{"/gateway", 0, true},
+		{"/gateway/", 0, true},
+		{"/edge/gateway/", 421, true},
+		{"gateway", 0, false},
+		{"/gateway//v1", 0, false},
+		{"/gateway/../admin", 0, false},
+		{"/gateway/./v1", 0, false},
+		{"/gate%77ay", 0, false},
+		{"/gateway?x=1", 0, false},
+		{"/gateway", 302, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.BasePath = tt.basePath
+		cfg.Server.OutsideBasePathStatus = tt.status
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("base_path %q, status %d: Validate() = %v, want ok=%v", tt.basePath, tt.status, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_InitialHealth(t *testing.T) {
+	yes, no := true, false
+	check := &HealthCheck{Path: "/healthz"}
+	tests := []struct {
+		name     string
+		upstream Upstream
+		timeout  time.Duration
+		ok       bool
+	}{
+		{"with health check", Upstream{WaitForInitialHealth: &yes, HealthCheck: check}, 0, true},
+		{"opt out", Upstream{WaitForInitialHealth: &no}, 0, true},
+		{"without health check", Upstream{WaitForInitialHealth: &yes}, 0, false},
+		{"negative timeout", Upstream{HealthCheck: check}, -time.Second, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.upstream.Name, tt.upstream.Targets = "backend", []Target{{URL: "http://localhost:1"}}
+		cfg.Upstreams = []Upstream{tt.upstream}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.InitialHealthTimeout = tt.timeout
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RateLimitIPPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipv4, ipv6 int
+		ok         bool
+	}{
+		{"per address", 0, 0, true},
+		{"networks", 24, 64, true},
+		{"full length", 32, 128, true},
+		{"v4 too long", 33, 0, false},
+		{"v6 too long", 0, 129, false},
+		{"negative", -1, 0, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.RateLimit.IPv4Prefix, cfg.RateLimit.IPv6Prefix = tt.ipv4, tt.ipv6
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_OnError(t *testing.T) {
+	subsystems := map[string]func(cfg *Config, onError string){
+		"secrets":    func(cfg *Config, onError string) { cfg.Secrets.OnError = onError },
+		"rate_limit": func(cfg *Config, onError string) { cfg.RateLimit.OnError = onError },
+		"quota":      func(cfg *Config, onError string) { cfg.Quota.OnError = onError },
+		"auth.jwt": func(cfg *Config, onError string) {
+			cfg.Auth.JWT = &JWTAuth{JWKSURL: "https://idp.example.com/jwks.json", OnError: onError}
+		},
+		"cache": func(cfg *Config, onError string) {
+			cfg.Routes[0].Cache = &Cache{TTL: time.Minute, OnError: onError}
+		},
+	}
+	for subsystem, set := range subsystems {
+		for _, tt := range []struct {
+			onError string
+			ok      bool
+		}{
+			{"", true},
+			{OnErrorAllow, true},
+			{OnErrorDeny, true},
+			{OnErrorDegrade, true},
+			{"ignore", false},
+		} {
+			cfg := DefaultConfig()
+			cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+			cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+			set(cfg, tt.onError)
+			if err := cfg.Validate(); (err == nil) != tt.ok {
+				t.Errorf("%s on_error %q: Validate() = %v, want ok=%v", subsystem, tt.onError, err, tt.ok)
+			}
+		}
+	}
+}
+
+func TestValidate_JWT(t *testing.T) {
+	tests := []struct {
+		name string
+		jwt  *JWTAuth
+		ok   bool
+	}{
+		{"valid", &JWTAuth{JWKSURL: "https://idp.example.com/jwks.json"}, true},
+		{"without auth.jwt", nil, false},
+		{"relative jwks_url", &JWTAuth{JWKSURL: "/jwks.json"}, false},
+		{"negative refresh_interval", &JWTAuth{JWKSURL: "https://idp.example.com/jwks.json", RefreshInterval: -time.Second}, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", RequireJWT: true}}
+		cfg.Auth.JWT = tt.jwt
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_UpstreamTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		transport *UpstreamTransport
+		ok        bool
+	}{
+		{"omitted", nil, true},
+		{"tuned", &UpstreamTransport{MaxIdleConnsPerHost: 256, DialTimeout: time.Second}, true},
+		{"negative pool", &UpstreamTransport{MaxConnsPerHost: -1}, false},
+		{"negative timeout", &UpstreamTransport{ResponseHeaderTimeout: -time.Second}, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}, Transport: tt.transport}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_LearnBodyLimit(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		learn *BodyLimitLearning
+		ok    bool
+	}{
+		{"defaults", &BodyLimitLearning{}, true},
+		{"tuned", &BodyLimitLearning{Rejections: 5, Window: time.Minute, Reprobe: time.Hour}, true},
+		{"negative rejections", &BodyLimitLearning{Rejections: -1}, false},
+		{"negative reprobe", &BodyLimitLearning{Reprobe: -time.Second}, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", LearnBodyLimit: tt.learn}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_TrustedProxies(t *testing.T) {
+	for _, tt := range []struct {
+		proxies []string
+		ok      bool
+	}{
+		{nil, true},
+		{[]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"}, true},
+		{[]string{"10.0.0.0/33"}, false},
+		{[]string{"proxy.internal"}, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.TrustedProxies = tt.proxies
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%v: Validate() = %v, want ok=%v", tt.proxies, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Forwarded(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		server, route *Forwarded
+		ok            bool
+	}{
+		{"omitted", nil, nil, true},
+		{"both", &Forwarded{Mode: ForwardedBoth}, nil, true},
+		{"route override", nil, &Forwarded{Mode: ForwardedStandard, ObfuscateFor: true}, true},
+		{"unknown server mode", &Forwarded{Mode: "rfc7239"}, nil, false},
+		{"unknown route mode", nil, &Forwarded{Mode: "x-forwarded"}, false},
+		{"omit", nil, &Forwarded{Omit: []string{"X-Forwarded-Port", "x-real-ip"}}, true},
+		{"omit unknown header", nil, &Forwarded{Omit: []string{"X-Request-ID"}}, false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Forwarded: tt.route}}
+		cfg.Server.Forwarded = tt.server
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_PartialStart(t *testing.T) {
+	for _, partial := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.Server.PartialStart = partial
+		cfg.Upstreams = []Upstream{{Name: "backend"}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		// Upstreams without targets are left for proxy.New to disable.
+		if err := cfg.Validate(); (err == nil) != partial {
+			t.Errorf("partial_start=%v: Validate() = %v", partial, err)
+		}
+	}
+}
+
+func TestValidate_UpstreamAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		auth *UpstreamAuth
+		ok   bool
+	}{
+		{"bearer", &UpstreamAuth{BearerToken: "t"}, true},
+		{"basic", &UpstreamAuth{Basic: &UpstreamBasicAuth{Username: "u", Password: "p"}}, true},
+		{"header", &UpstreamAuth{Header: &UpstreamAuthHeader{Name: "X-Key", Value: "v"}}, true},
+		{"strip only", &UpstreamAuth{StripClientAuth: true}, true},
+		{"empty", &UpstreamAuth{}, false},
+		{"two credentials", &UpstreamAuth{BearerToken: "t", Header: &UpstreamAuthHeader{Name: "X-Key"}}, false},
+		{"basic without username", &UpstreamAuth{Basic: &UpstreamBasicAuth{Password: "p"}}, false},
+		{"bad header name", &UpstreamAuth{Header: &UpstreamAuthHeader{Name: "X Key"}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", UpstreamAuth: tt.auth}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_MaxConcurrent(t *testing.T) {
+	tests := []struct {
+		name  string
+		max   int
+		queue *ConcurrencyQueue
+		ok    bool
+	}{
+		{"limit", 10, nil, true},
+		{"queue", 10, &ConcurrencyQueue{Depth: 5, Timeout: time.Second}, true},
+		{"negative", -1, nil, false},
+		{"queue without limit", 0, &ConcurrencyQueue{Depth: 5}, false},
+		{"empty queue", 10, &ConcurrencyQueue{}, false},
+		{"negative timeout", 10, &ConcurrencyQueue{Depth: 5, Timeout: -time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", MaxConcurrent: tt.max, ConcurrencyQueue: tt.queue}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Hedging(t *testing.T) {
+	tests := []struct {
+		name    string
+		hedging *Hedging
+		ok      bool
+	}{
+		{"default attempts", &Hedging{Delay: 50 * time.Millisecond}, true},
+		{"three attempts", &Hedging{Delay: 50 * time.Millisecond, MaxAttempts: 3}, true},
+		{"no delay", &Hedging{}, false},
+		{"single attempt", &Hedging{Delay: 50 * time.Millisecond, MaxAttempts: 1}, false},
+		{"negative attempts", &Hedging{Delay: 50 * time.Millisecond, MaxAttempts: -1}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Hedging: tt.hedging}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_StandbyTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		ok      bool
+	}{
+		{"primary and standby", []Target{{URL: "http://localhost:1"}, {URL: "http://dr:1", Standby: true, ExpectedRTT: ExpectedRTT{Duration: 80 * time.Millisecond}}}, true},
+		{"auto rtt", []Target{{URL: "http://localhost:1", ExpectedRTT: ExpectedRTT{Auto: true}}}, true},
+		{"only standby", []Target{{URL: "http://dr:1", Standby: true}}, false},
+		{"negative rtt", []Target{{URL: "http://localhost:1", ExpectedRTT: ExpectedRTT{Duration: -time.Millisecond}}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: tt.targets}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestExpectedRTT_YAML(t *testing.T) {
+	var targets []Target
+	data := "- {url: 'http://a:1', expected_rtt: 80ms}\n- {url: 'http://b:1', expected_rtt: auto}\n- {url: 'http://c:1'}\n"
+	if err := yaml.Unmarshal([]byte(data), &targets); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := []ExpectedRTT{{Duration: 80 * time.Millisecond}, {Auto: true}, {}}
+	for i, target := range targets {
+		if target.ExpectedRTT != want[i] {
+			t.Errorf("%s: expected_rtt = %+v, want %+v", target.URL, target.ExpectedRTT, want[i])
+		}
+	}
+	if err := yaml.Unmarshal([]byte("url: 'http://a:1'\nexpected_rtt: soon\n"), &Target{}); err == nil {
+		t.Error("invalid expected_rtt was accepted")
+	}
+}
+
+func TestValidate_CacheComponents(t *testing.T) {
+	tests := []struct {
+		name   string
+		varyOn []string
+		bypass []string
+		ok     bool
+	}{
+		{"none", nil, nil, true},
+		{"all kinds", []string{"header:X-Currency", "cookie:locale", "query:page"}, []string{"header:Authorization"}, true},
+		{"unknown kind", []string{"body:x"}, nil, false},
+		{"missing name", []string{"header:"}, nil, false},
+		{"bare name", nil, []string{"Authorization"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Cache: &Cache{TTL: time.Minute, VaryOn: tt.varyOn, BypassWhen: tt.bypass}}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RouteQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query map[string]string
+		ok    bool
+	}{
+		{"none", nil, true},
+		{"values", map[string]string{"engine": "v2", "debug": "*", "empty": ""}, true},
+		{"empty name", map[string]string{"": "v2"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Query: tt.query}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_PathParamPattern(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"/orders/:id", true},
+		{`/orders/:id(\d+)`, true},
+		{"/orders/{id:[0-9]+}/items", true},
+		{"/orders/{sku:[A-Z]{3}-[0-9]+}", true},
+		{"/orders/:id([0-9)", false},
+		{"/orders/{id:a(b}", false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: tt.path, Upstream: "backend"}}
+		err := cfg.Validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.path, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "route r ") {
+			t.Errorf("%s: error %q does not name the route", tt.path, err)
+		}
+	}
+}
+
+func TestValidate_RouteHosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		hosts []string
+		ok    bool
+	}{
+		{"none", "", nil, true},
+		{"host", "*.example.com", nil, true},
+		{"hosts", "", []string{"api.example.com", "*.tenant.example.com"}, true},
+		{"both", "api.example.com", []string{"api.example.org"}, false},
+		{"empty entry", "", []string{"api.example.com", ""}, false},
+		{"inner wildcard", "", []string{"api.*.example.com"}, false},
+		{"partial wildcard", "api*.example.com", nil, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Host: tt.host, Hosts: tt.hosts, Path: "/x", Upstream: "backend"}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestConfig_Warnings(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []Route{
+		{Name: "a", Path: "/a", Priority: 5, Upstream: "backend"},
+		{Name: "b", Path: "/b", Priority: 5, Upstream: "backend"},
+		{Name: "c", Path: "/c", Priority: 1, Upstream: "backend"},
+		{Name: "d", Path: "/d", Upstream: "backend"},
+		{Name: "e", Path: "/e", Upstream: "backend"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("shared priorities must not fail validation: %v", err)
+	}
+	warnings := cfg.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "routes a and b both have priority 5") {
+		t.Errorf("Warnings() = %q", warnings)
+	}
+}
+
+func TestValidate_UpstreamPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		hosts        []string
+		upstreamPath string
+		rewrite      *Rewrite
+		ok           bool
+	}{
+		{"none", "/x", nil, "", nil, true},
+		{"static", "/x", nil, "/internal/x", nil, true},
+		{"params", "/tenants/:tenant/users/{id:[0-9]+}", nil, "/internal/{tenant}/users/{id}", nil, true},
+		{"remainder", "/tenants/:tenant/**", nil, "/{tenant}/{**}", nil, true},
+		{"single wildcard remainder", "/tenants/:tenant/*", nil, "/{tenant}/{**}", nil, true},
+		{"host label", "/x", []string{"*.tenant.example.com"}, "/tenants/{host.tenant}", nil, true},
+		{"unknown param", "/tenants/:tenant", nil, "/internal/{tenantId}", nil, false},
+		{"remainder without wildcard", "/tenants/:tenant", nil, "/internal/{**}", nil, false},
+		{"host label without wildcard host", "/x", []string{"api.example.com"}, "/{host.api}", nil, false},
+		{"empty placeholder", "/x", nil, "/internal/{}", nil, false},
+		{"relative", "/x", nil, "internal/x", nil, false},
+		{"with rewrite", "/x", nil, "/y", &Rewrite{Pattern: "^/x$", Replacement: "/z"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: tt.path, Hosts: tt.hosts, Upstream: "backend", UpstreamPath: tt.upstreamPath, Rewrite: tt.rewrite}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_TrailingSlash(t *testing.T) {
+	for policy, ok := range map[string]bool{
+		"":                    true,
+		TrailingSlashMerge:    true,
+		TrailingSlashStrict:   true,
+		TrailingSlashRedirect: true,
+		"ignore":              false,
+	} {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", TrailingSlash: policy}}
+		if err := cfg.Validate(); (err == nil) != ok {
+			t.Errorf("trailing_slash %q: Validate() = %v, want ok=%v", policy, err, ok)
+		}
+	}
+}
+
+func TestValidate_MatchHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{"none", nil, true},
+		{"values", map[string]string{"X-Api-Version": "2", "x-beta": "*", "Authorization": "Partner *"}, true},
+		{"empty name", map[string]string{"": "2"}, false},
+		{"name with colon", map[string]string{"X-Api-Version:": "2"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", MatchHeaders: tt.headers}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_IdempotencyKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys IdempotencyKeys
+		ok   bool
+	}{
+		{"defaults", IdempotencyKeys{}, true},
+		{"all set", IdempotencyKeys{TTL: 24 * time.Hour, MaxBodySize: 1 << 20, MaxEntries: 100}, true},
+		{"negative ttl", IdempotencyKeys{TTL: -time.Second}, false},
+		{"negative max_body_size", IdempotencyKeys{MaxBodySize: -1}, false},
+		{"negative max_entries", IdempotencyKeys{MaxEntries: -1}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", IdempotencyKeys: &tt.keys}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_DeadlinePropagation(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		deadline DeadlinePropagation
+		ok       bool
+	}{
+		{"defaults", 0, DeadlinePropagation{}, true},
+		{"all set", 2 * time.Second, DeadlinePropagation{Header: "X-Budget", ClientHeader: "X-Client-Budget", MaxClientTimeout: 10 * time.Second, MinRemaining: 50 * time.Millisecond}, true},
+		{"bad header", 0, DeadlinePropagation{Header: "X Budget"}, false},
+		{"bad client_header", 0, DeadlinePropagation{ClientHeader: "X-Budget:"}, false},
+		{"negative min_remaining", 0, DeadlinePropagation{MinRemaining: -time.Second}, false},
+		{"max_client_timeout without client_header", 0, DeadlinePropagation{MaxClientTimeout: time.Second}, false},
+		{"min_remaining over timeout", time.Second, DeadlinePropagation{MinRemaining: time.Second}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Timeout: tt.timeout, DeadlinePropagation: &tt.deadline}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_FeatureFlags(t *testing.T) {
+	half, over := 50.0, 101.0
+	tests := []struct {
+		name  string
+		flags []FeatureFlag
+		route []string
+		ok    bool
+	}{
+		{"none", nil, nil, true},
+		{"full", []FeatureFlag{{Name: "new-checkout", Default: "v1", BucketBy: "cookie:rp_bucket", Header: "X-Checkout", Rules: []FlagRule{
+			{Variant: "v2", APIKeys: []string{"partner"}, Headers: map[string]string{"X-Beta": "*"}},
+			{Variant: "v2", Percent: &half},
+		}}}, []string{"new-checkout"}, true},
+		{"client_ip buckets", []FeatureFlag{{Name: "f", BucketBy: FlagBucketClientIP}}, nil, true},
+		{"empty name", []FeatureFlag{{}}, nil, false},
+		{"name with comma", []FeatureFlag{{Name: "a,b"}}, nil, false},
+		{"duplicate name", []FeatureFlag{{Name: "f"}, {Name: "f"}}, nil, false},
+		{"variant with equals", []FeatureFlag{{Name: "f", Rules: []FlagRule{{Variant: "a=b"}}}}, nil, false},
+		{"missing variant", []FeatureFlag{{Name: "f", Rules: []FlagRule{{Percent: &half}}}}, nil, false},
+		{"percent over 100", []FeatureFlag{{Name: "f", Rules: []FlagRule{{Variant: "on", Percent: &over}}}}, nil, false},
+		{"unknown bucket_by", []FeatureFlag{{Name: "f", BucketBy: "session"}}, nil, false},
+		{"invalid header", []FeatureFlag{{Name: "f", Header: "X Flag"}}, nil, false},
+		{"unknown flag on route", nil, []string{"f"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", FeatureFlags: tt.route}}
+		cfg.FeatureFlags = tt.flags
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_AdaptiveConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		adaptive *AdaptiveConcurrency
+		max      int
+		ok       bool
+	}{
+		{"defaults", &AdaptiveConcurrency{}, 0, true},
+		{"aimd with bounds", &AdaptiveConcurrency{Algorithm: AdaptiveAIMD, MinLimit: 5, MaxLimit: 50, Tolerance: 2}, 0, true},
+		{"with max_concurrent", &AdaptiveConcurrency{}, 10, false},
+		{"unknown algorithm", &AdaptiveConcurrency{Algorithm: "vegas"}, 0, false},
+		{"floor above ceiling", &AdaptiveConcurrency{MinLimit: 50, MaxLimit: 5}, 0, false},
+		{"tolerance below 1", &AdaptiveConcurrency{Tolerance: 0.5}, 0, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", MaxConcurrent: tt.max, AdaptiveConcurrency: tt.adaptive}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_ReadOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		readOnly ReadOnlyConfig
+		ok       bool
+	}{
+		{"defaults", "", ReadOnlyConfig{}, true},
+		{"read_only with methods", ModeReadOnly, ReadOnlyConfig{Methods: []string{"POST", "PURGE"}, RetryAfter: 60}, true},
+		{"unknown mode", "standby", ReadOnlyConfig{}, false},
+		{"lower-case method", ModeReadOnly, ReadOnlyConfig{Methods: []string{"post"}}, false},
+		{"empty method", ModeReadOnly, ReadOnlyConfig{Methods: []string{""}}, false},
+		{"negative retry_after", ModeReadOnly, ReadOnlyConfig{RetryAfter: -1}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Mode, cfg.ReadOnly = tt.mode, tt.readOnly
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_DeniedMethods(t *testing.T) {
+	tests := []struct {
+		name   string
+		server []string
+		route  []string
+		ok     bool
+	}{
+		{"none", nil, nil, true},
+		{"server and route", []string{"TRACE", "TRACK"}, []string{"delete"}, true},
+		{"empty server method", []string{""}, nil, false},
+		{"invalid route method", nil, []string{"GET POST"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", DeniedMethods: tt.route}}
+		cfg.Server.DeniedMethods = tt.server
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_ConnectionLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits ConnectionLimits
+		ok     bool
+	}{
+		{"defaults", DefaultConfig().Server.ConnectionLimits, true},
+		{"all set", ConnectionLimits{MaxPerIP: 64, ReadHeaderTimeout: 5 * time.Second, MinDataRate: 512, MinDataRateGrace: 20 * time.Second, Exempt: []string{"10.0.0.0/8", "fd00::/8"}}, true},
+		{"negative max_per_ip", ConnectionLimits{MaxPerIP: -1}, false},
+		{"negative min_data_rate", ConnectionLimits{MinDataRate: -1}, false},
+		{"invalid exempt", ConnectionLimits{Exempt: []string{"10.0.0.0/33"}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.ConnectionLimits = tt.limits
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_SecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		global SecurityHeaders
+		route  *SecurityHeaders
+		ok     bool
+	}{
+		{"none", SecurityHeaders{}, nil, true},
+		{"values", SecurityHeaders{FrameOptions: "sameorigin", ContentTypeOptions: "nosniff", ContentSecurityPolicy: "default-src 'self'"}, &SecurityHeaders{FrameOptions: "off"}, true},
+		{"invalid frame_options", SecurityHeaders{FrameOptions: "ALLOW-FROM https://example.com"}, nil, false},
+		{"invalid route content_type_options", SecurityHeaders{}, &SecurityHeaders{ContentTypeOptions: "sniff"}, false},
+		{"line break", SecurityHeaders{ReferrerPolicy: "no-referrer\r\nSet-Cookie: a=b"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", SecurityHeaders: tt.route}}
+		cfg.SecurityHeaders = tt.global
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_SlowRequestThreshold(t *testing.T) {
+	tests := []struct {
+		name          string
+		server, route time.Duration
+		ok            bool
+	}{
+		{"unset", 0, 0, true},
+		{"server and route", 2 * time.Second, 500 * time.Millisecond, true},
+		{"negative server", -time.Second, 0, false},
+		{"negative route", 0, -time.Second, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", SlowRequestThreshold: tt.route}}
+		cfg.Server.SlowRequestThreshold = tt.server
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_UpstreamTokenAuth(t *testing.T) {
+	valid := func() *UpstreamTokenAuth {
+		return &UpstreamTokenAuth{Type: AuthOAuth2ClientCredentials, TokenURL: "https://idp.example.com/token", ClientID: "gateway"}
+	}
+	tests := []struct {
+		name   string
+		mutate func(a *UpstreamTokenAuth, r *Route)
+		ok     bool
+	}{
+		{"valid", func(a *UpstreamTokenAuth, r *Route) {}, true},
+		{"scopes and margin", func(a *UpstreamTokenAuth, r *Route) {
+			a.Scopes, a.RefreshMargin, a.OnError = []string{"read"}, 30*time.Second, OnErrorDeny
+		}, true},
+		{"unknown type", func(a *UpstreamTokenAuth, r *Route) { a.Type = "oauth2_password" }, false},
+		{"relative token_url", func(a *UpstreamTokenAuth, r *Route) { a.TokenURL = "/token" }, false},
+		{"no client_id", func(a *UpstreamTokenAuth, r *Route) { a.ClientID = "" }, false},
+		{"negative refresh_margin", func(a *UpstreamTokenAuth, r *Route) { a.RefreshMargin = -time.Second }, false},
+		{"bad on_error", func(a *UpstreamTokenAuth, r *Route) { a.OnError = "retry" }, false},
+		{"route sets another header", func(a *UpstreamTokenAuth, r *Route) {
+			r.UpstreamAuth = &UpstreamAuth{Header: &UpstreamAuthHeader{Name: "X-Tenant", Value: "acme"}}
+		}, true},
+		{"route sets Authorization", func(a *UpstreamTokenAuth, r *Route) {
+			r.UpstreamAuth = &UpstreamAuth{BearerToken: "static"}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		auth := valid()
+		route := Route{Name: "r", Path: "/x", Upstream: "backend"}
+		tt.mutate(auth, &route)
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}, Auth: auth}}
+		cfg.Routes = []Route{route}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_ProbePort(t *testing.T) {
+	tests := []struct {
+		name string
+		port int
+		ok   bool
+	}{
+		{"unset", 0, true},
+		{"own port", 8081, true},
+		{"server port", 8080, false},
+		{"metrics port", 9090, false},
+		{"out of range", 70000, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Server.ProbePort = tt.port
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RouteActivity(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity RouteActivityConfig
+		ok       bool
+	}{
+		{"defaults", RouteActivityConfig{}, true},
+		{"custom", RouteActivityConfig{StateFile: "/var/lib/relaypoint/activity.json", FlushInterval: time.Minute, ArchivedStatus: 404}, true},
+		{"negative flush_interval", RouteActivityConfig{FlushInterval: -time.Second}, false},
+		{"success status", RouteActivityConfig{ArchivedStatus: 301}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.RouteActivity = tt.activity
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Tests(t *testing.T) {
+	request := FixtureRequest{Path: "/x"}
+	tests := []struct {
+		name     string
+		fixtures []Fixture
+		ok       bool
+	}{
+		{"route", []Fixture{{Name: "a", Request: request, Expect: FixtureExpect{Route: "r"}}}, true},
+		{"upstream", []Fixture{{Name: "a", Request: FixtureRequest{Method: "POST", Path: "/x?q=1", Body: "{}"}, Expect: FixtureExpect{UpstreamURL: "http://localhost:1/x?q=1"}}}, true},
+		{"unnamed", []Fixture{{Request: request, Expect: FixtureExpect{Status: 200}}}, false},
+		{"duplicate", []Fixture{{Name: "a", Request: request, Expect: FixtureExpect{Status: 200}}, {Name: "a", Request: request, Expect: FixtureExpect{Status: 404}}}, false},
+		{"relative path", []Fixture{{Name: "a", Request: FixtureRequest{Path: "x"}, Expect: FixtureExpect{Status: 200}}}, false},
+		{"undefined route", []Fixture{{Name: "a", Request: request, Expect: FixtureExpect{Route: "other"}}}, false},
+		{"no expectation", []Fixture{{Name: "a", Request: request}}, false},
+		{"invalid status", []Fixture{{Name: "a", Request: request, Expect: FixtureExpect{Status: 700}}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend"}}
+		cfg.Tests = tt.fixtures
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_Maintenance(t *testing.T) {
+	tests := []struct {
+		name        string
+		maintenance *Maintenance
+		ok          bool
+	}{
+		{"defaults", &Maintenance{Enabled: true}, true},
+		{"disabled", &Maintenance{Body: "back soon"}, true},
+		{"custom", &Maintenance{Enabled: true, Status: 502, Body: "{}", ContentType: "application/json", RetryAfter: 60}, true},
+		{"success status", &Maintenance{Enabled: true, Status: 200}, false},
+		{"negative retry_after", &Maintenance{Enabled: true, RetryAfter: -1}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Maintenance: tt.maintenance}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestValidate_RequestSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *RequestSchema
+		ok     bool
+	}{
+		{"file", &RequestSchema{File: "schemas/order.json"}, true},
+		{"skip methods", &RequestSchema{File: "schemas/order.json", SkipMethods: []string{"PATCH"}}, true},
+		{"no file", &RequestSchema{MaxSize: 1024}, false},
+		{"negative max size", &RequestSchema{File: "schemas/order.json", MaxSize: -1}, false},
+		{"lower-case method", &RequestSchema{File: "schemas/order.json", SkipMethods: []string{"patch"}}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", RequestSchema: tt.schema}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRequestSchema_FileShorthand(t *testing.T) {
+	var route Route
+	if err := yaml.Unmarshal([]byte("request_schema: schemas/order.json"), &route); err != nil {
+		t.Fatal(err)
+	}
+	if route.RequestSchema == nil || route.RequestSchema.File != "schemas/order.json" {
+		t.Fatalf("request_schema = %+v, want the file", route.RequestSchema)
+	}
+
+	route = Route{}
+	if err := yaml.Unmarshal([]byte("request_schema: {file: schemas/order.json, max_size: 512}"), &route); err != nil {
+		t.Fatal(err)
+	}
+	if route.RequestSchema == nil || route.RequestSchema.MaxSize != 512 {
+		t.Fatalf("request_schema = %+v, want max_size 512", route.RequestSchema)
+	}
+}
+
+func TestAutoValidators_YAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		on   bool
+		ttl  time.Duration
+	}{
+		{"auto_validators: true", true, 0},
+		{"auto_validators: false", false, 0},
+		{"auto_validators: {ttl: 5m}", true, 5 * time.Minute},
+		{"path: /x", false, 0},
+	}
+
+	for _, tt := range tests {
+		var route Route
+		if err := yaml.Unmarshal([]byte(tt.yaml), &route); err != nil {
+			t.Fatalf("%s: %v", tt.yaml, err)
+		}
+		if route.AutoValidators.On() != tt.on {
+			t.Errorf("%s: On() = %v, want %v", tt.yaml, route.AutoValidators.On(), tt.on)
+		}
+		if tt.on && route.AutoValidators.TTL != tt.ttl {
+			t.Errorf("%s: ttl = %v, want %v", tt.yaml, route.AutoValidators.TTL, tt.ttl)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", AutoValidators: &AutoValidators{TTL: -time.Second}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a negative ttl")
+	}
+}
+
+func TestValidate_AllowedResponseContentTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *ResponseContentTypes
+		ok     bool
+	}{
+		{"types", &ResponseContentTypes{Types: []string{"application/json", "image/*"}}, true},
+		{"sanitize and sniff", &ResponseContentTypes{Types: []string{"application/json"}, Mode: "sanitize", Missing: "sniff"}, true},
+		{"no types", &ResponseContentTypes{Mode: "reject"}, false},
+		{"bare type", &ResponseContentTypes{Types: []string{"json"}}, false},
+		{"parameters", &ResponseContentTypes{Types: []string{"text/html; charset=utf-8"}}, false},
+		{"unknown mode", &ResponseContentTypes{Types: []string{"application/json"}, Mode: "drop"}, false},
+		{"unknown missing", &ResponseContentTypes{Types: []string{"application/json"}, Missing: "allow"}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", AllowedResponseContentTypes: tt.policy}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+
+	var route Route
+	if err := yaml.Unmarshal([]byte("allowed_response_content_types: [application/json]"), &route); err != nil {
+		t.Fatal(err)
+	}
+	if p := route.AllowedResponseContentTypes; p == nil || len(p.Types) != 1 || p.Mode != "" {
+		t.Errorf("list form = %+v, want one type and the default mode", p)
+	}
+}
+
+func TestCheckOwnership(t *testing.T) {
+	base := DefaultConfig()
+	base.Upstreams = []Upstream{{Name: "orders", Owner: "team-b", Targets: []Target{{URL: "http://orders:1"}}}}
+	base.Routes = []Route{
+		{Name: "mine", Owner: "team-a", Path: "/a", Upstream: "orders"},
+		{Name: "theirs", Owner: "team-b", Path: "/b", Upstream: "orders"},
+		{Path: "/shared", Upstream: "orders"},
+	}
+	base.APIKeys = []APIKey{{Name: "partner", Owner: "team-b", Key: "k"}}
+
+	next := DefaultConfig()
+	next.Upstreams = base.Upstreams
+	next.Routes = []Route{
+		{Name: "mine", Owner: "team-a", Path: "/a/v2", Upstream: "orders"},
+		{Name: "theirs", Owner: "team-b", Path: "/b", Upstream: "orders", StripPath: true},
+		{Path: "/shared", Upstream: "orders", MaxBodySize: 1024},
+		{Name: "new", Owner: "team-b", Path: "/n", Upstream: "orders"},
+		{Name: "taken", Owner: "team-a", Path: "/t", Upstream: "orders"},
+	}
+	base.Routes = append(base.Routes, Route{Name: "taken", Owner: "team-b", Path: "/t", Upstream: "orders"})
+
+	report := CheckOwnership(base, next, "team-a")
+	if len(report.Changes) != 6 {
+		t.Errorf("changes = %+v, want 6", report.Changes)
+	}
+	var foreign []string
+	for _, c := range report.Foreign {
+		foreign = append(foreign, c.Kind+" "+c.Name+" "+c.Change)
+	}
+	want := []string{"api_key partner deleted", "route new added", "route taken modified", "route theirs modified"}
+	if strings.Join(foreign, ", ") != strings.Join(want, ", ") {
+		t.Errorf("foreign changes = %v, want %v", foreign, want)
+	}
+	if report.Rejected {
+		t.Error("foreign changes rejected without enforce_ownership")
+	}
+
+	base.EnforceOwnership = true
+	if report := CheckOwnership(base, next, "team-a"); !report.Rejected {
+		t.Error("foreign changes not rejected with enforce_ownership")
+	}
+	if report := CheckOwnership(base, next, "team-b"); len(report.Foreign) != 2 {
+		t.Errorf("team-b foreign changes = %+v, want mine and taken", report.Foreign)
+	}
+	if report := CheckOwnership(base, next, ""); report.Foreign != nil || report.Rejected {
+		t.Errorf("changes without a team were flagged: %+v", report)
+	}
+}
+
+func TestFingerprint_IgnoresHowConfigIsWritten(t *testing.T) {
+	t.Setenv("RELAYPOINT_TEST_FINGERPRINT_KEY", "k-0123456789")
+
+	a := `
+server:
+  port: 8080
+  read_timeout: 30s
+upstreams:
+  - name: backend
+    targets:
+      - url: http://localhost:3000
+routes:
+  - name: api
+    path: /api/**
+    upstream: backend
+    timeout: 1500ms
+    headers:
+      X-A: "1"
+      X-B: "2"
+api_keys:
+  - name: ci
+    key: secretref:env:RELAYPOINT_TEST_FINGERPRINT_KEY
+    requests_per_second: 10
+`
+	b := `
+# Same configuration, written differently.
+api_keys:
+  - requests_per_second: 10
+    key: secretref:env:RELAYPOINT_TEST_FINGERPRINT_KEY
+    name: ci
+routes:
+  - upstream: backend
+    path: /api/**
+    name: api
+    headers: {X-B: "2", X-A: "1"}
+    timeout: 1.5s
+    strip_path: false
+upstreams:
+  - targets: [{url: "http://localhost:3000"}]
+    name: backend
+cluster:
+  node_id: another-replica
+`
+	cfgA, err := Parse([]byte(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgB, err := Parse([]byte(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fa, fb := cfgA.Fingerprint(), cfgB.Fingerprint(); fa != fb {
+		t.Errorf("fingerprints differ:\n%s\n%s", cfgA.canonical(), cfgB.canonical())
+	}
+	if strings.Contains(string(cfgA.canonical()), "k-0123456789") {
+		t.Error("canonical form contains a resolved secret")
+	}
+	if len(cfgA.Fingerprint()) != 64 {
+		t.Errorf("Fingerprint() = %q, want a hex SHA-256", cfgA.Fingerprint())
+	}
+}
+
+func TestFingerprint_ChangesWithAnyField(t *testing.T) {
+	base := func() *Config {
+		cfg := DefaultConfig()
+		cfg.Upstreams = []Upstream{{Name: "backend", Targets: []Target{{URL: "http://localhost:1"}}}}
+		cfg.Routes = []Route{{Name: "r", Path: "/x", Upstream: "backend", Headers: map[string]string{"X-A": "1"}}}
+		cfg.APIKeys = []APIKey{{Name: "ci", Key: "k1"}}
+		cfg.secretValues = map[string]bool{"k1": true, "k2": true}
+		return cfg
+	}
+	seen := map[string]string{base().Fingerprint(): "base"}
+	for _, tt := range []struct {
+		name   string
+		mutate func(cfg *Config)
+	}{
+		{"server port", func(cfg *Config) { cfg.Server.Port = 8081 }},
+		{"duration", func(cfg *Config) { cfg.Server.ReadTimeout = 31 * time.Second }},
+		{"zeroed default", func(cfg *Config) { cfg.Server.WriteTimeout = 0 }},
+		{"float", func(cfg *Config) { cfg.FlightRecorder.SampleRate = 0.02 }},
+		{"bool", func(cfg *Config) { cfg.RateLimit.PerIP = false }},
+		{"header value", func(cfg *Config) { cfg.Routes[0].Headers["X-A"] = "2" }},
+		{"header added", func(cfg *Config) { cfg.Routes[0].Headers["X-B"] = "1" }},
+		{"target weight", func(cfg *Config) { cfg.Upstreams[0].Targets[0].Weight = 2 }},
+		{"route added", func(cfg *Config) {
+			cfg.Routes = append(cfg.Routes, Route{Name: "s", Path: "/y", Upstream: "backend"})
+		}},
+		{"route order", func(cfg *Config) {
+			cfg.Routes = []Route{{Name: "s", Path: "/y", Upstream: "backend"}, cfg.Routes[0]}
+		}},
+		{"explicit false", func(cfg *Config) { off := false; cfg.Routes[0].RequireAPIKey = &off }},
+		{"secret rotated", func(cfg *Config) { cfg.APIKeys[0].Key = "k2" }},
+	} {
+		cfg := base()
+		tt.mutate(cfg)
+		fp := cfg.Fingerprint()
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s: fingerprint equals that of %s", tt.name, other)
+		}
+		seen[fp] = tt.name
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// fingerprintExcluded lists the fields, by YAML path, that differ between
+// replicas of the same configuration or do not change what the gateway
+// does, and are left out of its fingerprint.
+var fingerprintExcluded = map[string]bool{
+	"cluster.node_id": true, // defaults to the replica's hostname
+	"tests":           true, // only read by "relaypoint test"
+}
+
+// Fingerprint returns a hex SHA-256 digest of the configuration's
+// canonical form, so replicas can tell whether they run the same thing.
+func (c *Config) Fingerprint() string {
+	sum := sha256.Sum256(c.canonical())
+	return hex.EncodeToString(sum[:])
+}
+
+// canonical serializes the configuration so that configurations that mean
+// the same produce the same bytes: fields and map keys are sorted, zero
+// values are left out whether they were written or not, durations are in
+// nanoseconds whatever unit they were written in, and secrets resolved
+// from references are replaced by their SHA-256 hash.
+func (c *Config) canonical() []byte {
+	v, _ := c.canonicalValue(reflect.ValueOf(c).Elem(), "")
+	data, err := json.Marshal(v) // sorts map keys
+	if err != nil {
+		panic(fmt.Sprintf("config: canonical form: %v", err)) // only built from marshalable values
+	}
+	return data
+}
+
+// canonicalValue returns v as a tree of maps, slices and scalars, and
+// false if v is empty and left out.
+func (c *Config) canonicalValue(v reflect.Value, path string) (any, bool) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, false
+		}
+		// A set pointer is kept even if it points to a zero value: an
+		// explicit false differs from unset.
+		value, ok := c.canonicalValue(v.Elem(), path)
+		if !ok {
+			return map[string]any{}, true
+		}
+		return value, true
+	case reflect.Struct:
+		fields := make(map[string]any)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := yamlName(field)
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			if fingerprintExcluded[fieldPath] {
+				continue
+			}
+			if value, ok := c.canonicalValue(v.Field(i), fieldPath); ok {
+				fields[name] = value
+			}
+		}
+		return fields, len(fields) > 0
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return nil, false
+		}
+		items := make([]any, v.Len())
+		for i := range items {
+			items[i], _ = c.canonicalValue(v.Index(i), path)
+		}
+		return items, true
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil, false
+		}
+		entries := make(map[string]any, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())], _ = c.canonicalValue(iter.Value(), path)
+		}
+		return entries, true
+	case reflect.String:
+		s := v.String()
+		if c.secretValues[s] {
+			sum := sha256.Sum256([]byte(s))
+			s = "sha256:" + hex.EncodeToString(sum[:])
+		}
+		return s, s != ""
+	default:
+		return v.Interface(), !v.IsZero()
+	}
+}
+
+// yamlName returns the name field is read from in YAML.
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// WithReloadable returns a copy of c with the settings of next that a
+// running gateway applies on reload: the maintenance and enabled settings
+// of c's routes, matched by name or, without one, by path, and the
+// feature flags. Everything else is kept from c, so the copy's fingerprint
+// is that of the configuration in effect after the reload.
+func (c *Config) WithReloadable(next *Config) *Config {
+	reloaded := *c
+	reloaded.Routes = slices.Clone(c.Routes)
+	nextRoutes := routesByName(next)
+	for i := range reloaded.Routes {
+		r := &reloaded.Routes[i]
+		name := r.Name
+		if name == "" {
+			name = r.Path
+		}
+		if n, ok := nextRoutes[name]; ok {
+			r.Maintenance = n.value.(Route).Maintenance
+			r.Enabled = n.value.(Route).Enabled
+		}
+	}
+	reloaded.FeatureFlags = next.FeatureFlags
+	reloaded.secretValues = mergeSecretValues(c.secretValues, next.secretValues)
+	return &reloaded
+}
+
+// WithAPIKeys returns a copy of c with next's API keys, as applied when
+// secrets rotate.
+func (c *Config) WithAPIKeys(next *Config) *Config {
+	rotated := *c
+	rotated.APIKeys = next.APIKeys
+	rotated.secretValues = mergeSecretValues(c.secretValues, next.secretValues)
+	return &rotated
+}
+
+func mergeSecretValues(a, b map[string]bool) map[string]bool {
+	if len(b) == 0 {
+		return a
+	}
+	merged := maps.Clone(a)
+	if merged == nil {
+		merged = make(map[string]bool, len(b))
+	}
+	maps.Copy(merged, b)
+	return merged
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Entity kinds that carry an owner.
+const (
+	EntityRoute    = "route"
+	EntityUpstream = "upstream"
+	EntityAPIKey   = "api_key"
+)
+
+// Entity changes.
+const (
+	ChangeAdded    = "added"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// EntityChange is a route, upstream or API key that differs between two
+// configurations. Routes are identified by name, or by path when they
+// have none.
+type EntityChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	// Owner is the owner after the change, PreviousOwner the one before;
+	// an added entity has no previous owner and a deleted one no owner.
+	Owner         string `json:"owner,omitempty"`
+	PreviousOwner string `json:"previous_owner,omitempty"`
+}
+
+// ForeignTo reports whether the change touches an entity that another
+// team than team owned before or owns after it. Unowned entities belong
+// to everyone.
+func (c EntityChange) ForeignTo(team string) bool {
+	return (c.Owner != "" && c.Owner != team) || (c.PreviousOwner != "" && c.PreviousOwner != team)
+}
+
+// OwnershipReport is the outcome of checking a configuration change made
+// by one team.
+type OwnershipReport struct {
+	Changes []EntityChange `json:"changes"`
+	// Foreign are the changes to entities of other teams, and Rejected
+	// whether they make the change unacceptable.
+	Foreign  []EntityChange `json:"foreign_changes,omitempty"`
+	Rejected bool           `json:"rejected,omitempty"`
+}
+
+// CheckOwnership compares next with base, the configuration it replaces.
+// When changedBy names the team making the change, the changes foreign to
+// it are flagged, and rejected if base sets enforce_ownership.
+func CheckOwnership(base, next *Config, changedBy string) OwnershipReport {
+	report := OwnershipReport{Changes: Diff(base, next)}
+	if changedBy == "" {
+		return report
+	}
+	for _, c := range report.Changes {
+		if c.ForeignTo(changedBy) {
+			report.Foreign = append(report.Foreign, c)
+		}
+	}
+	report.Rejected = base.EnforceOwnership && len(report.Foreign) > 0
+	return report
+}
+
+// Diff lists the routes, upstreams and API keys that were added, modified
+// or deleted from base to next, ordered by kind and name.
+func Diff(base, next *Config) []EntityChange {
+	changes := []EntityChange{}
+	add := func(kind string, before, after map[string]owned) {
+		for name, b := range before {
+			a, ok := after[name]
+			switch {
+			case !ok:
+				changes = append(changes, EntityChange{Kind: kind, Name: name, Change: ChangeDeleted, PreviousOwner: b.owner})
+			case !reflect.DeepEqual(a.value, b.value):
+				changes = append(changes, EntityChange{Kind: kind, Name: name, Change: ChangeModified, Owner: a.owner, PreviousOwner: b.owner})
+			}
+		}
+		for name, a := range after {
+			if _, ok := before[name]; !ok {
+				changes = append(changes, EntityChange{Kind: kind, Name: name, Change: ChangeAdded, Owner: a.owner})
+			}
+		}
+	}
+	add(EntityAPIKey, apiKeysByName(base), apiKeysByName(next))
+	add(EntityRoute, routesByName(base), routesByName(next))
+	add(EntityUpstream, upstreamsByName(base), upstreamsByName(next))
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// owned is one configured entity and its owner.
+type owned struct {
+	owner string
+	value any
+}
+
+func routesByName(c *Config) map[string]owned {
+	m := make(map[string]owned, len(c.Routes))
+	for _, r := range c.Routes {
+		name := r.Name
+		if name == "" {
+			name = r.Path
+		}
+		m[name] = owned{r.Owner, r}
+	}
+	return m
+}
+
+func upstreamsByName(c *Config) map[string]owned {
+	m := make(map[string]owned, len(c.Upstreams))
+	for _, u := range c.Upstreams {
+		m[u.Name] = owned{u.Owner, u}
+	}
+	return m
+}
+
+func apiKeysByName(c *Config) map[string]owned {
+	m := make(map[string]owned, len(c.APIKeys))
+	for _, k := range c.APIKeys {
+		m[k.Name] = owned{k.Owner, k}
+	}
+	return m
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/relaypoint/relaypoint/internal/secrets"
+)
+
+// resolveSecrets replaces every string value of the form
+// "secretref:<provider>:<path>[#field]" with the secret it points to.
+func (c *Config) resolveSecrets(ctx context.Context, store *secrets.Store) error {
+	c.secretValues = make(map[string]bool)
+	return resolveValue(ctx, store, reflect.ValueOf(c).Elem(), c.secretValues)
+}
+
+// resolveValue resolves the references in v, adding the secrets to
+// resolved.
+func resolveValue(ctx context.Context, store *secrets.Store, v reflect.Value, resolved map[string]bool) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return resolveValue(ctx, store, v.Elem(), resolved)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := resolveValue(ctx, store, v.Field(i), resolved); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveValue(ctx, store, v.Index(i), resolved); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := resolveString(ctx, store, iter.Value().String(), resolved)
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(value).Convert(v.Type().Elem()))
+		}
+	case reflect.String:
+		value, err := resolveString(ctx, store, v.String(), resolved)
+		if err != nil {
+			return err
+		}
+		v.SetString(value)
+	}
+	return nil
+}
+
+func resolveString(ctx context.Context, store *secrets.Store, s string, resolved map[string]bool) (string, error) {
+	ref, ok, err := secrets.ParseRef(s)
+	if err != nil || !ok {
+		return s, err
+	}
+	value, err := store.Get(ctx, ref)
+	if err == nil && value != "" {
+		resolved[value] = true
+	}
+	return value, err
+}
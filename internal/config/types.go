@@ -9,6 +9,380 @@ type Config struct {
 	RateLimit RateLimitConfig `yaml:"rate_limit"`
 	Metrics   MetricsConfig   `yaml:"metrics"`
 	APIKeys   []APIKey        `yaml:"api_keys"`
+	Cluster   ClusterConfig   `yaml:"cluster"`
+
+	FlightRecorder FlightRecorderConfig `yaml:"flight_recorder"`
+	AccessLog      AccessLogConfig      `yaml:"access_log"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	ErrorResponses ErrorResponses       `yaml:"error_responses"`
+	Secrets        SecretsConfig        `yaml:"secrets"`
+	Capture        CaptureConfig        `yaml:"capture"`
+
+	SyntheticProbes []SyntheticProbe `yaml:"synthetic_probes,omitempty"`
+
+	// FeatureFlags are evaluated at the edge for the routes that list them
+	// and forwarded to the upstream. They are reloaded on SIGHUP.
+	FeatureFlags []FeatureFlag `yaml:"feature_flags,omitempty"`
+
+	// Quota caps the requests each API key may make per period.
+	Quota QuotaConfig `yaml:"quota"`
+
+	// Auth holds the ways clients authenticate besides API keys.
+	Auth AuthConfig `yaml:"auth"`
+
+	// Degradation applies to routes without a degradation block of their
+	// own, with load measured across all of them.
+	Degradation *Degradation `yaml:"degradation,omitempty"`
+
+	// EnforceOwnership rejects configuration changes, checked against this
+	// configuration, that touch routes, upstreams or API keys owned by a
+	// team other than the one making the change. Without it such changes
+	// are only flagged.
+	EnforceOwnership bool `yaml:"enforce_ownership,omitempty"`
+
+	// Mode is the gateway's operating mode: active (default) or read_only.
+	// A mode set through the admin API is kept in read_only.state_file and
+	// wins over this one on restart.
+	Mode     string         `yaml:"mode,omitempty"`
+	ReadOnly ReadOnlyConfig `yaml:"read_only"`
+
+	// SecurityHeaders adds standard security headers to every response.
+	// Routes may override it with a block of their own.
+	SecurityHeaders SecurityHeaders `yaml:"security_headers"`
+
+	// RouteActivity tracks when each route last served a request, to find
+	// idle routes and archive them through the admin API.
+	RouteActivity RouteActivityConfig `yaml:"route_activity"`
+
+	// Tests are request fixtures "relaypoint test" runs against the
+	// configuration; the gateway itself ignores them.
+	Tests []Fixture `yaml:"tests,omitempty"`
+
+	// secretValues holds the values resolved from secret references, which
+	// Fingerprint hashes.
+	secretValues map[string]bool
+}
+
+// Gateway modes.
+const (
+	ModeActive   = "active"
+	ModeReadOnly = "read_only"
+)
+
+// ReadOnlyConfig shapes read_only mode, in which the gateway refuses
+// mutating requests, e.g. at a disaster recovery site pointed at read
+// replicas until failover is declared.
+type ReadOnlyConfig struct {
+	// Methods are refused with 503, default POST, PUT, PATCH and DELETE.
+	Methods    []string `yaml:"methods,omitempty"`
+	RetryAfter int      `yaml:"retry_after,omitempty"` // seconds, default 30
+
+	// StateFile keeps the mode set through the admin API across restarts.
+	StateFile string `yaml:"state_file,omitempty"`
+}
+
+// RouteActivityConfig shapes route activity tracking and archival.
+type RouteActivityConfig struct {
+	// StateFile keeps the activity and the archived routes across
+	// restarts; without it they last as long as the process.
+	StateFile     string        `yaml:"state_file,omitempty"`
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"` // default 1m
+
+	// ArchivedStatus answers requests to archived routes, default 410.
+	ArchivedStatus int `yaml:"archived_status,omitempty"`
+}
+
+// Fixture is a request and the outcome the configuration must give it.
+type Fixture struct {
+	Name    string         `yaml:"name"`
+	Request FixtureRequest `yaml:"request"`
+	Expect  FixtureExpect  `yaml:"expect"`
+}
+
+// FixtureRequest is the client request of a fixture.
+type FixtureRequest struct {
+	Method  string            `yaml:"method,omitempty"` // default GET
+	Host    string            `yaml:"host,omitempty"`
+	Path    string            `yaml:"path"` // may carry a query
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+}
+
+// FixtureExpect is what a fixture checks; fields left empty are not.
+type FixtureExpect struct {
+	Route string `yaml:"route,omitempty"`
+	// UpstreamURL is the URL the gateway calls, with the query.
+	UpstreamURL string `yaml:"upstream_url,omitempty"`
+	// UpstreamHeaders are headers of the upstream request; an empty value
+	// checks that the header is not sent.
+	UpstreamHeaders map[string]string `yaml:"upstream_headers,omitempty"`
+	// Status is the status of the response. Upstream calls are answered
+	// 200, so it is meant for responses the gateway makes itself.
+	Status int `yaml:"status,omitempty"`
+}
+
+// SecurityHeaderOff disables one security header.
+const SecurityHeaderOff = "off"
+
+// SecurityHeaders is the set of security headers added to responses. Each
+// header takes its value from the route's block, then the top-level one,
+// then its default; "off" leaves it out. Enabled, Override and
+// HideServerTokens likewise fall back from the route to the top level.
+type SecurityHeaders struct {
+	// Enabled adds the headers; false on a route turns them off there.
+	Enabled *bool `yaml:"enabled,omitempty"`
+
+	StrictTransportSecurity string `yaml:"strict_transport_security,omitempty"` // default max-age=31536000; includeSubDomains
+	ContentTypeOptions      string `yaml:"content_type_options,omitempty"`      // default nosniff
+	FrameOptions            string `yaml:"frame_options,omitempty"`             // default DENY
+	ReferrerPolicy          string `yaml:"referrer_policy,omitempty"`           // default strict-origin-when-cross-origin
+	ContentSecurityPolicy   string `yaml:"content_security_policy,omitempty"`   // none by default
+
+	// Override replaces headers the upstream already set; by default the
+	// upstream's win.
+	Override *bool `yaml:"override,omitempty"`
+
+	// HideServerTokens removes Server and X-Powered-By from responses,
+	// whether or not the headers are enabled.
+	HideServerTokens *bool `yaml:"hide_server_tokens,omitempty"`
+}
+
+// Degradation is an ordered ladder of responses to overload. Each level
+// adds its action to those of the levels below it.
+type Degradation struct {
+	Interval time.Duration      `yaml:"interval"` // how often the level is evaluated, default 1s
+	Window   time.Duration      `yaml:"window"`   // period latency and errors are measured over, default 10s
+	Levels   []DegradationLevel `yaml:"levels"`
+}
+
+// Degradation level actions.
+const (
+	DegradeDisableTransforms = "disable_transforms" // no compression or response header rules
+	DegradeServeStale        = "serve_stale"        // serve expired cache entries
+	DegradeShed              = "shed"               // reject shed_percent of requests with 503
+	DegradeReject            = "reject"             // reject every request with 503
+)
+
+// DegradationLevel is entered from the level below when any Enter
+// threshold is reached, and left once the load is below every Exit
+// threshold.
+type DegradationLevel struct {
+	Name        string                `yaml:"name"`
+	Action      string                `yaml:"action"`
+	ShedPercent float64               `yaml:"shed_percent,omitempty"` // 0-100, shed only
+	Enter       DegradationThresholds `yaml:"enter"`
+	// Exit defaults to 80% of Enter, so the level does not flap around a
+	// single threshold.
+	Exit DegradationThresholds `yaml:"exit"`
+}
+
+// DegradationThresholds are load levels; zero fields are not checked.
+type DegradationThresholds struct {
+	InFlight  int64         `yaml:"in_flight"`
+	Latency   time.Duration `yaml:"latency"`    // mean request duration over the window
+	ErrorRate float64       `yaml:"error_rate"` // share of 5xx responses over the window, 0-1
+}
+
+// SyntheticProbe is a request the gateway periodically sends through its
+// own routing, rewrites and auth, without going over the network.
+type SyntheticProbe struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"` // default GET
+	Path    string            `yaml:"path"`   // may include a query string
+	Host    string            `yaml:"host"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	ExpectStatus int    `yaml:"expect_status"` // default 200
+	ExpectBody   string `yaml:"expect_body"`   // substring of the response body
+
+	Interval time.Duration `yaml:"interval"` // default 30s
+	Timeout  time.Duration `yaml:"timeout"`  // default 5s
+
+	// Ready fails /ready while the probe is failing.
+	Ready bool `yaml:"ready"`
+}
+
+// CaptureConfig is where requests sampled by a route's capture block are
+// written, for replay with "relaypoint replay". Set either File or URL.
+type CaptureConfig struct {
+	File         string `yaml:"file"`           // JSON lines, rotated at MaxFileSize
+	MaxFileSize  int64  `yaml:"max_file_size"`  // bytes
+	MaxFiles     int    `yaml:"max_files"`      // rotated files kept
+	URL          string `yaml:"url"`            // collector receiving POSTed JSON lines
+	MaxPerSecond int    `yaml:"max_per_second"` // hard cap across all routes
+}
+
+// SecretsConfig controls re-resolution of "secretref:" values.
+type SecretsConfig struct {
+	// RefreshInterval re-resolves every referenced secret on this period so
+	// rotations reach API keys without a restart. Zero disables refreshing.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// OnError decides how API key checks behave while the last refresh
+	// failed: OnErrorDegrade (default) keeps the keys resolved before,
+	// OnErrorDeny rejects requests on routes requiring a key and
+	// OnErrorAllow skips the key check on them.
+	OnError string `yaml:"on_error,omitempty"`
+}
+
+// AuthConfig holds the ways clients authenticate besides API keys.
+type AuthConfig struct {
+	// JWT verifies the bearer tokens of requests to routes with
+	// require_jwt.
+	JWT *JWTAuth `yaml:"jwt,omitempty"`
+}
+
+// JWTAuth verifies ES256 and RS256 bearer tokens with the keys of a JSON
+// Web Key Set, which is fetched on first use and cached.
+type JWTAuth struct {
+	JWKSURL  string `yaml:"jwks_url"`
+	Issuer   string `yaml:"issuer,omitempty"`   // iss tokens must carry, if set
+	Audience string `yaml:"audience,omitempty"` // aud tokens must include, if set
+
+	// RefreshInterval is how long fetched keys are used before the key
+	// set is fetched again, default 5m.
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+
+	// OnError decides how tokens are checked while the key set cannot be
+	// fetched: OnErrorDegrade (default) verifies them with the keys fetched
+	// before, OnErrorDeny rejects requests and OnErrorAllow lets them
+	// through unverified.
+	OnError string `yaml:"on_error,omitempty"`
+}
+
+// Policies for requests while a subsystem's backing store is failing.
+const (
+	OnErrorAllow   = "allow"
+	OnErrorDeny    = "deny"
+	OnErrorDegrade = "degrade"
+)
+
+// AccessLogConfig writes one structured line per request once its response
+// has been sent.
+type AccessLogConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	File       string  `yaml:"file"`        // JSON lines; empty logs through the gateway logger
+	SampleRate float64 `yaml:"sample_rate"` // 0-1
+}
+
+// Error response formats.
+const (
+	ErrorFormatJSON = "json"
+	ErrorFormatText = "text"
+)
+
+// Request framing check modes.
+const (
+	FramingEnforce = "enforce" // reject ambiguous framing with 400
+	FramingReport  = "report"  // log and count it, but serve the request
+	FramingOff     = "off"
+)
+
+// Path matching modes.
+const (
+	PathMatchingDecoded = "decoded" // percent-encoding is decoded before matching
+	PathMatchingRaw     = "raw"     // the path is matched as the client encoded it
+)
+
+// Trailing slash policies of a route.
+const (
+	TrailingSlashMerge    = "merge"    // /users and /users/ both match (default)
+	TrailingSlashStrict   = "strict"   // only the form of the route's path matches
+	TrailingSlashRedirect = "redirect" // the other form is redirected to it
+)
+
+// Forwarding header styles.
+const (
+	ForwardedLegacy   = "x_forwarded" // X-Forwarded-For, -Host, -Proto, -Port, -Prefix and X-Real-IP
+	ForwardedStandard = "forwarded"   // RFC 7239 Forwarded
+	ForwardedBoth     = "both"
+)
+
+// Forwarded configures the headers that tell upstreams where a request came
+// from.
+type Forwarded struct {
+	Mode string `yaml:"mode,omitempty"` // default x_forwarded
+	// ObfuscateFor writes for=_hidden in the Forwarded header instead of
+	// the client address.
+	ObfuscateFor bool `yaml:"obfuscate_for,omitempty"`
+	// Omit names headers of the X-Forwarded-* set, or X-Real-IP, that are
+	// removed from the request instead of being set, for backends that
+	// choke on them.
+	Omit []string `yaml:"omit,omitempty"`
+}
+
+// ForwardedHeaders are the headers of x_forwarded mode, which Omit may
+// name.
+var ForwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Forwarded-Port", "X-Forwarded-Prefix", "X-Real-IP"}
+
+// ConnectionLimits bounds what one client can hold open on the listener.
+// Clients are told apart by the TCP peer address, so behind a load
+// balancer the balancer's range belongs in Exempt.
+type ConnectionLimits struct {
+	// MaxPerIP caps the open connections from one address; further ones
+	// are closed as soon as they are accepted. Zero means no cap.
+	MaxPerIP int `yaml:"max_per_ip,omitempty"`
+
+	// ReadHeaderTimeout bounds reading a request head (default 10s).
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+
+	// MinDataRate closes connections whose client reads responses slower
+	// than this many bytes per second, once writes to it have been
+	// blocked for MinDataRateGrace (default 10s) in total. Zero disables
+	// the check.
+	MinDataRate      int           `yaml:"min_data_rate,omitempty"`
+	MinDataRateGrace time.Duration `yaml:"min_data_rate_grace,omitempty"`
+
+	// Exempt lists CIDRs the cap and the data rate do not apply to.
+	Exempt []string `yaml:"exempt,omitempty"`
+}
+
+// StripCredentials keeps the API keys clients present to the gateway away
+// from upstreams, where they would end up in access logs and caches.
+type StripCredentials struct {
+	Enabled bool `yaml:"enabled"` // default true
+
+	// QueryParams names query parameters that carry an API key besides
+	// api_key. The gateway reads keys from them too.
+	QueryParams []string `yaml:"query_params,omitempty"`
+
+	// Headers also removes X-API-Key, and Authorization when it holds a
+	// Bearer or ApiKey credential. Leave it off for upstreams that check
+	// the same credential themselves.
+	Headers bool `yaml:"headers"`
+}
+
+// ErrorResponses shapes the bodies of errors the gateway generates itself.
+// Responses relayed from upstreams are never changed.
+type ErrorResponses struct {
+	Format string `yaml:"format"` // json (default) or text
+
+	// Templates replace the body for one status code.
+	Templates map[int]ErrorTemplate `yaml:"templates,omitempty"`
+}
+
+// ErrorTemplate is a text/template rendered with .Code, .Message,
+// .RequestID and .Type. The json function quotes a value for JSON bodies.
+type ErrorTemplate struct {
+	ContentType string `yaml:"content_type"` // default application/json
+	Body        string `yaml:"body"`
+}
+
+// TracingConfig exports OpenTelemetry spans for proxied requests to an
+// OTLP/HTTP collector.
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`     // e.g. http://tempo:4318/v1/traces
+	SampleRatio float64 `yaml:"sample_ratio"` // 0-1, for requests without a sampled parent
+	ServiceName string  `yaml:"service_name"` // default relaypoint
+}
+
+// FlightRecorderConfig keeps timing breakdowns of recent requests for the
+// admin API. Slow requests are always kept; others are sampled.
+type FlightRecorderConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Size          int           `yaml:"size"`
+	SampleRate    float64       `yaml:"sample_rate"`    // 0-1
+	SlowThreshold time.Duration `yaml:"slow_threshold"` // zero keeps only sampled requests
 }
 
 type ServerConfig struct {
@@ -17,18 +391,227 @@ type ServerConfig struct {
 	ReadTimeout     time.Duration `yaml:"read_timeout"`
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// PreStopDelay keeps serving for this long after shutdown starts, with
+	// /ready failing, so external load balancers can stop routing to the
+	// gateway before it drains.
+	PreStopDelay time.Duration `yaml:"pre_stop_delay"`
+
+	// ProbePort, if set, serves the probe endpoints and the admin API on a
+	// listener of their own, outside connection_limits, so probes still
+	// get a connection while the main listener is saturated.
+	ProbePort int `yaml:"probe_port,omitempty"`
+
+	// PanicThreshold disables a route once it panics this many times within
+	// a minute. Zero keeps routes serving regardless of panics.
+	PanicThreshold int `yaml:"panic_threshold"`
+
+	// H2C accepts cleartext HTTP/2 (prior knowledge) from clients alongside
+	// HTTP/1.1, e.g. for gRPC callers without TLS.
+	H2C bool `yaml:"h2c"`
+
+	// RequestFraming checks the raw framing of HTTP/1 requests against
+	// request smuggling: enforce (default), report or off. It applies to
+	// plaintext listeners only.
+	RequestFraming string `yaml:"request_framing"`
+
+	// PathMatching is whether routes match the decoded request path
+	// (default) or the raw one, after duplicate slashes and dot segments
+	// are removed from it either way.
+	PathMatching string `yaml:"path_matching,omitempty"`
+
+	// CaseSensitivePaths matches the literal segments of route paths
+	// case-sensitively, so /Users and /users reach different routes. By
+	// default they are compared case-insensitively. Hosts always are.
+	CaseSensitivePaths bool `yaml:"case_sensitive_paths,omitempty"`
+
+	// BasePath is the path prefix the gateway is served under, e.g. behind
+	// an ingress at /gateway/. It is removed from requests before anything
+	// else looks at them, proxied routes and the gateway's own endpoints
+	// alike; requests outside it are answered with OutsideBasePathStatus,
+	// default 404.
+	BasePath              string `yaml:"base_path,omitempty"`
+	OutsideBasePathStatus int    `yaml:"outside_base_path_status,omitempty"`
+
+	// MaxBodySize caps request bodies in bytes; routes may override it.
+	// Zero means unlimited.
+	MaxBodySize int64 `yaml:"max_body_size"`
+
+	// CopyBufferSize is the size in bytes of the pooled buffers response
+	// bodies are copied through. Zero means 32 KiB.
+	CopyBufferSize int `yaml:"copy_buffer_size,omitempty"`
+
+	// SlowRequestThreshold logs a warning for every proxied request that
+	// takes at least this long; routes may override it. Zero disables it.
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold,omitempty"`
+
+	// RequireAPIKey rejects requests without an enabled API key on every
+	// route that does not set require_api_key itself.
+	RequireAPIKey bool `yaml:"require_api_key"`
+
+	// StripCredentials removes the API key credentials the gateway reads
+	// from requests before they are sent upstream.
+	StripCredentials StripCredentials `yaml:"strip_credentials"`
+
+	// AllowIPs and DenyIPs filter clients by IP on every route, in CIDR
+	// notation; routes may add their own lists. A deny match wins, and a
+	// non-empty allow list rejects clients outside it.
+	AllowIPs []string `yaml:"allow_ips,omitempty"`
+	DenyIPs  []string `yaml:"deny_ips,omitempty"`
+
+	// DeniedMethods are answered with 405 on every route, whatever methods
+	// the route accepts, e.g. TRACE.
+	DeniedMethods []string `yaml:"denied_methods,omitempty"`
+
+	// ConnectionLimits protects the listener from clients that hold many
+	// connections or move data too slowly.
+	ConnectionLimits ConnectionLimits `yaml:"connection_limits"`
+
+	// TrustedProxies lists the CIDRs of proxies in front of the gateway.
+	// X-Forwarded-For and X-Real-IP are only believed when the peer is
+	// one of them; otherwise the peer address is the client.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+
+	// Forwarded selects the forwarding headers sent upstream. Routes may
+	// override it.
+	Forwarded *Forwarded `yaml:"forwarded,omitempty"`
+
+	// WaitForInitialHealth keeps /ready failing until every upstream with a
+	// health_check has completed its first check cycle, for at most
+	// InitialHealthTimeout (default 30s). Upstreams may override it.
+	WaitForInitialHealth bool          `yaml:"wait_for_initial_health"`
+	InitialHealthTimeout time.Duration `yaml:"initial_health_timeout"`
+	// InitialHealthReject answers 503 on routes to an upstream awaiting its
+	// first check instead of proxying to targets not checked yet.
+	InitialHealthReject bool `yaml:"initial_health_reject"`
+
+	// PartialStart starts the gateway even when some upstreams cannot be
+	// built, e.g. for an invalid target URL or no targets. Those upstreams
+	// are disabled and routes to them answer 503; by default any such
+	// error stops the start.
+	PartialStart bool `yaml:"partial_start"`
+
+	// TLS terminates HTTPS on the gateway listener when set.
+	TLS *ServerTLS `yaml:"tls,omitempty"`
+}
+
+type ServerTLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// DenyFingerprints rejects requests from connections whose JA3
+	// fingerprint (MD5 hex) is listed.
+	DenyFingerprints []string `yaml:"deny_fingerprints,omitempty"`
 }
 
 type Upstream struct {
 	Name        string       `yaml:"name"`
+	Owner       string       `yaml:"owner,omitempty"` // team that owns the upstream
 	Targets     []Target     `yaml:"targets"`
 	HealthCheck *HealthCheck `yaml:"health_check,omitempty"`
 	LoadBalance string       `yaml:"load_balance"` // round_robin, least_conn, random
+	Protocol    string       `yaml:"protocol"`     // http1 (default), h2, h2c
+	TLS         *UpstreamTLS `yaml:"tls,omitempty"`
+
+	// ReportSecret authorizes targets to push their own health and load to
+	// /admin/upstreams/{name}/targets/{url}/report. Pushes are refused when
+	// it is empty.
+	ReportSecret string `yaml:"report_secret,omitempty"`
+
+	// WaitForInitialHealth overrides server.wait_for_initial_health for
+	// this upstream. It requires a health_check.
+	WaitForInitialHealth *bool `yaml:"wait_for_initial_health,omitempty"`
+
+	// Transport tunes the connection pool used for this upstream. Unset
+	// fields keep the defaults shared by all upstreams.
+	Transport *UpstreamTransport `yaml:"transport,omitempty"`
+
+	// Auth obtains a bearer token the gateway sends as Authorization on
+	// every request to this upstream, replacing the client's.
+	Auth *UpstreamTokenAuth `yaml:"auth,omitempty"`
+}
+
+// Upstream token types.
+const AuthOAuth2ClientCredentials = "oauth2_client_credentials"
+
+// UpstreamTokenAuth fetches an upstream's bearer token, caches it and
+// refreshes it before it expires.
+type UpstreamTokenAuth struct {
+	Type     string   `yaml:"type"` // oauth2_client_credentials
+	TokenURL string   `yaml:"token_url"`
+	ClientID string   `yaml:"client_id"`
+	Scopes   []string `yaml:"scopes,omitempty"`
+
+	// ClientSecret is normally a secretref: reference, resolved like any
+	// other configuration value.
+	ClientSecret string `yaml:"client_secret_ref"`
+
+	// RefreshMargin is how long before expiry the token is refreshed,
+	// default 60s. Requests keep using the old token meanwhile.
+	RefreshMargin time.Duration `yaml:"refresh_margin,omitempty"`
+
+	// OnError decides how requests behave while no token can be fetched:
+	// OnErrorDegrade (default) keeps sending the last token until it
+	// expires, OnErrorDeny answers 503 and OnErrorAllow forwards requests
+	// without a token.
+	OnError string `yaml:"on_error,omitempty"`
+}
+
+// UpstreamTransport configures the connections to an upstream's targets.
+type UpstreamTransport struct {
+	MaxIdleConns          int           `yaml:"max_idle_conns"`          // default 100
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host"` // default 10
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host"`      // default unlimited
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout"`       // default 90s
+	DialTimeout           time.Duration `yaml:"dial_timeout"`            // default 10s
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"` // default none
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`   // default none
+}
+
+// WaitsForInitialHealth reports whether the gateway waits for the first
+// health check cycle of u before it is ready.
+func (c *Config) WaitsForInitialHealth(u Upstream) bool {
+	if u.HealthCheck == nil {
+		return false
+	}
+	if u.WaitForInitialHealth != nil {
+		return *u.WaitForInitialHealth
+	}
+	return c.Server.WaitForInitialHealth
+}
+
+// UpstreamTLS configures how the gateway verifies https targets.
+type UpstreamTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty"` // PEM bundle trusted instead of the system roots
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"` // overrides the name checked against the certificate
+
+	// CertFile and KeyFile are the client certificate presented to targets
+	// that require mutual TLS. They are reloaded on SIGHUP.
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
 }
 
 type Target struct {
 	URL    string `yaml:"url"`
 	Weight int    `yaml:"weight"`
+
+	// Standby targets, e.g. a disaster recovery site in another region,
+	// only receive requests while no other target of the upstream is
+	// available. An upstream needs at least one target that is not.
+	Standby bool `yaml:"standby,omitempty"`
+
+	// ExpectedRTT is the round trip the target adds over a local one, such
+	// as 80ms for another region. It lengthens the target's health check
+	// timeout and, on routes with compensate_rtt, the timeout of requests
+	// failed over to it. "auto" uses the measured health check latency.
+	ExpectedRTT ExpectedRTT `yaml:"expected_rtt,omitempty"`
+}
+
+// ExpectedRTT is a fixed round trip, or Auto to use the measured one.
+type ExpectedRTT struct {
+	Duration time.Duration
+	Auto     bool
 }
 
 type HealthCheck struct {
@@ -38,16 +621,489 @@ type HealthCheck struct {
 }
 
 type Route struct {
-	Name       string            `yaml:"name"`
-	Host       string            `yaml:"host"`
-	Path       string            `yaml:"path"`
-	Methods    []string          `yaml:"methods,omitempty"`
-	Upstream   string            `yaml:"upstream"`
-	StripPath  bool              `yaml:"strip_path"`
-	Headers    map[string]string `yaml:"headers,omitempty"`
-	RateLimit  *RouteRateLimit   `yaml:"rate_limit,omitempty"`
-	Timeout    time.Duration     `yaml:"timeout,omitempty"`
-	RetryCount int               `yaml:"retry_count,omitempty"`
+	Name         string            `yaml:"name"`
+	Owner        string            `yaml:"owner,omitempty"` // team that owns the route
+	Host         string            `yaml:"host"`
+	Hosts        []string          `yaml:"hosts,omitempty"` // alternative to host: any of these, "*.example.com" wildcards allowed
+	Path         string            `yaml:"path"`
+	Enabled      *bool             `yaml:"enabled,omitempty"`  // false keeps the route dark until enabled at runtime
+	Priority     int               `yaml:"priority,omitempty"` // higher is tried first; the computed priority breaks ties
+	Methods      []string          `yaml:"methods,omitempty"`
+	Query        map[string]string `yaml:"query,omitempty"`         // required query parameters: a value, or "*" for any
+	MatchHeaders map[string]string `yaml:"match_headers,omitempty"` // required headers: a value, "*" for any, or a prefix ending in "*"
+	Upstream     string            `yaml:"upstream"`
+	StripPath    bool              `yaml:"strip_path"`
+	Headers      map[string]string `yaml:"headers,omitempty"`
+	RateLimit    *RouteRateLimit   `yaml:"rate_limit,omitempty"`
+	Timeout      time.Duration     `yaml:"timeout,omitempty"`
+	RetryCount   int               `yaml:"retry_count,omitempty"`
+
+	// ServerTiming adds the gateway's own time and the upstream round trip
+	// to the Server-Timing response header.
+	ServerTiming bool `yaml:"server_timing,omitempty"`
+
+	// AllowInReadOnly keeps serving every method while the gateway is in
+	// read_only mode.
+	AllowInReadOnly bool `yaml:"allow_in_read_only,omitempty"`
+
+	// CompensateRTT adds the extra round trip of a standby target to
+	// Timeout when requests are failed over to it, so the slower region
+	// does not turn into 504s.
+	CompensateRTT bool `yaml:"compensate_rtt,omitempty"`
+
+	// WebSocketIdleTimeout closes an upgraded tunnel after no bytes have
+	// moved in either direction for this long. Zero disables the timeout.
+	WebSocketIdleTimeout time.Duration `yaml:"websocket_idle_timeout,omitempty"`
+
+	// FlushInterval forces periodic flushing of the response body to the
+	// client. A negative value flushes after every write. Streaming responses
+	// (SSE, chunked, unknown length) are always flushed after every write.
+	FlushInterval time.Duration `yaml:"flush_interval,omitempty"`
+
+	// ResponseIdleTimeout aborts the response when the upstream sends no
+	// body bytes for this long, answering 504 if nothing was relayed yet.
+	// Zero disables the timeout.
+	ResponseIdleTimeout time.Duration `yaml:"response_idle_timeout,omitempty"`
+
+	// DeadlinePropagation tells the upstream how much of the request's
+	// time budget is left, and lets clients shorten it.
+	DeadlinePropagation *DeadlinePropagation `yaml:"deadline_propagation,omitempty"`
+
+	// WireFidelity forwards client headers as received and skips the
+	// optional headers the gateway would otherwise add.
+	WireFidelity bool `yaml:"wire_fidelity,omitempty"`
+
+	// MaxBodySize caps request bodies in bytes, overriding server.max_body_size.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty"`
+
+	// DecompressRequest decodes gzip and deflate request bodies before they
+	// are proxied, so the upstream receives them identity-encoded.
+	DecompressRequest bool `yaml:"decompress_request,omitempty"`
+
+	// SlowRequestThreshold overrides server.slow_request_threshold.
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold,omitempty"`
+
+	// ConfigFingerprintHeader adds X-Config-Fingerprint to the route's
+	// responses, for fleet consistency checks through an internal route.
+	ConfigFingerprintHeader bool `yaml:"config_fingerprint_header,omitempty"`
+
+	// MaxConcurrent caps the requests the route serves at once; requests
+	// over the limit are shed with 503, or wait in ConcurrencyQueue if set.
+	MaxConcurrent    int               `yaml:"max_concurrent,omitempty"`
+	ConcurrencyQueue *ConcurrencyQueue `yaml:"concurrency_queue,omitempty"`
+
+	// AdaptiveConcurrency replaces a fixed max_concurrent with a limit that
+	// follows the route's latency.
+	AdaptiveConcurrency *AdaptiveConcurrency `yaml:"adaptive_concurrency,omitempty"`
+
+	// Hedging sends a copy of a slow request to another target of the
+	// upstream and relays whichever response arrives first.
+	Hedging *Hedging `yaml:"hedging,omitempty"`
+
+	BodyMatch *BodyMatch `yaml:"body_match,omitempty"`
+
+	// Compression gzips identity-encoded responses for clients that accept it.
+	Compression *Compression `yaml:"compression,omitempty"`
+
+	// Mirror sends a copy of each request to a second upstream and discards
+	// the response.
+	Mirror *Mirror `yaml:"mirror,omitempty"`
+
+	// RequestHeaders and ResponseHeaders transform headers on the way to
+	// the upstream and back to the client.
+	RequestHeaders  *HeaderRules `yaml:"request_headers,omitempty"`
+	ResponseHeaders *HeaderRules `yaml:"response_headers,omitempty"`
+
+	// Rewrite maps the request path (after strip_path) to the upstream path.
+	Rewrite *Rewrite `yaml:"rewrite,omitempty"`
+
+	// UpstreamPath builds the upstream path from a template instead, such
+	// as /internal/{tenant}/users/{**}: {param} is a path parameter and
+	// {**} what the route's trailing wildcard matched. It replaces the
+	// whole path, so strip_path has no effect on it.
+	UpstreamPath string `yaml:"upstream_path,omitempty"`
+
+	// PreserveHost forwards the client's Host header instead of the upstream
+	// target's. UpstreamHost sends a fixed Host instead. At most one may be set.
+	PreserveHost bool   `yaml:"preserve_host,omitempty"`
+	UpstreamHost string `yaml:"upstream_host,omitempty"`
+
+	// RequireAPIKey rejects requests without an enabled API key, overriding
+	// server.require_api_key.
+	RequireAPIKey *bool `yaml:"require_api_key,omitempty"`
+	// RequireJWT rejects requests without a bearer token that auth.jwt
+	// verifies.
+	RequireJWT bool `yaml:"require_jwt,omitempty"`
+
+	// CaseSensitivePaths overrides server.case_sensitive_paths.
+	CaseSensitivePaths *bool `yaml:"case_sensitive_paths,omitempty"`
+
+	// TrailingSlash is whether a request path ending in a slash matches a
+	// route path without one and the other way round: merge (default),
+	// strict, or redirect to the route's form.
+	TrailingSlash string `yaml:"trailing_slash,omitempty"`
+
+	// AllowIPs and DenyIPs filter clients by IP after server.allow_ips and
+	// server.deny_ips.
+	AllowIPs []string `yaml:"allow_ips,omitempty"`
+	DenyIPs  []string `yaml:"deny_ips,omitempty"`
+
+	// Exclusive stops routing at this route once its host and path match:
+	// a request with another method gets 405 here instead of falling
+	// through to a lower-priority route.
+	Exclusive bool `yaml:"exclusive,omitempty"`
+
+	// HonorMethodOverride lets POST requests name their method in
+	// X-HTTP-Method-Override. The override is applied before method
+	// matching and must be one of MethodOverrideMethods, by default PUT,
+	// PATCH and DELETE.
+	HonorMethodOverride   bool     `yaml:"honor_method_override,omitempty"`
+	MethodOverrideMethods []string `yaml:"method_override_methods,omitempty"`
+
+	// MethodMap replaces request methods after matching, e.g. {POST: PUT},
+	// so the upstream and metrics see the mapped method.
+	MethodMap map[string]string `yaml:"method_map,omitempty"`
+
+	// DeniedMethods are answered with 405 on this route in addition to
+	// server.denied_methods. OptionsPassthrough forwards OPTIONS even when
+	// a deny list names it, for upstreams that answer CORS preflights.
+	DeniedMethods      []string `yaml:"denied_methods,omitempty"`
+	OptionsPassthrough bool     `yaml:"options_passthrough,omitempty"`
+
+	// SecurityHeaders overrides the top-level security_headers, field by
+	// field, on this route.
+	SecurityHeaders *SecurityHeaders `yaml:"security_headers,omitempty"`
+
+	// AllowedResponseContentTypes lists the media types the upstream may
+	// answer with; other responses are rejected or neutralized.
+	AllowedResponseContentTypes *ResponseContentTypes `yaml:"allowed_response_content_types,omitempty"`
+
+	// Cache keeps upstream responses to GET requests in memory.
+	Cache *Cache `yaml:"cache,omitempty"`
+
+	// IdempotencyKeys answers retries of a completed request that carry
+	// the same Idempotency-Key header with its stored response.
+	IdempotencyKeys *IdempotencyKeys `yaml:"idempotency_keys,omitempty"`
+
+	// FeatureFlags names the feature_flags evaluated for the route's
+	// requests and sent upstream in X-Feature-Flags.
+	FeatureFlags []string `yaml:"feature_flags,omitempty"`
+
+	// AutoValidators adds an ETag and Last-Modified to responses whose
+	// upstream sends none, and answers matching If-None-Match requests with
+	// 304 while the validator is fresh.
+	AutoValidators *AutoValidators `yaml:"auto_validators,omitempty"`
+
+	// Capture samples requests to the capture sink for offline replay.
+	Capture *RouteCapture `yaml:"capture,omitempty"`
+
+	// RequestFingerprint hashes the content of each request so duplicates
+	// and replays can be spotted. It is off by default.
+	RequestFingerprint *RequestFingerprint `yaml:"request_fingerprint,omitempty"`
+
+	// Forwarded overrides server.forwarded for this route.
+	Forwarded *Forwarded `yaml:"forwarded,omitempty"`
+
+	// LearnBodyLimit learns the body size the upstream accepts from its 413
+	// responses and rejects larger requests at the gateway.
+	LearnBodyLimit *BodyLimitLearning `yaml:"learn_body_limit,omitempty"`
+
+	// RequestSchema validates JSON request bodies against a JSON Schema
+	// before they are proxied.
+	RequestSchema *RequestSchema `yaml:"request_schema,omitempty"`
+
+	// UpstreamAuth is the credential the gateway sends to the upstream on
+	// this route, which clients never see.
+	UpstreamAuth *UpstreamAuth `yaml:"upstream_auth,omitempty"`
+
+	// Maintenance answers every request with a static response instead of
+	// proxying it. It can also be switched at runtime through the admin API.
+	Maintenance *Maintenance `yaml:"maintenance,omitempty"`
+
+	// Degradation overrides the top-level degradation ladder for this route,
+	// with load measured on this route alone.
+	Degradation *Degradation `yaml:"degradation,omitempty"`
+
+	// Pipeline lists the request stages to run, in order, replacing
+	// DefaultPipeline. Stages left out are skipped; "proxy" must come last.
+	Pipeline []string `yaml:"pipeline,omitempty"`
+}
+
+// RouteCapture selects which requests of a route are captured.
+type RouteCapture struct {
+	SampleRate   float64  `yaml:"sample_rate"`              // 0-1
+	MaxBodyBytes int64    `yaml:"max_body_bytes,omitempty"` // default 64 KiB
+	Redact       []string `yaml:"redact,omitempty"`         // header, query and JSON field names
+}
+
+// RequestFingerprint selects what goes into a request's content hash. The
+// method, host, path and query are always included.
+type RequestFingerprint struct {
+	Headers      []string      `yaml:"headers,omitempty"`        // request headers to include
+	MaxBodyBytes int64         `yaml:"max_body_bytes,omitempty"` // larger bodies count by length only, default 64 KiB
+	Window       time.Duration `yaml:"window,omitempty"`         // how long repeats are counted, default 60s
+}
+
+// BodyLimitLearning configures how a route learns its upstream's body limit.
+type BodyLimitLearning struct {
+	Rejections int           `yaml:"rejections,omitempty"` // 413s within window before a limit is learned, default 3
+	Window     time.Duration `yaml:"window,omitempty"`     // default 5m
+	Reprobe    time.Duration `yaml:"reprobe,omitempty"`    // how often one larger request re-checks the limit, default 10m
+}
+
+// RequestSchema names the JSON Schema file request bodies must match. In
+// YAML it may also be given as just the file name.
+type RequestSchema struct {
+	File        string   `yaml:"file"`
+	MaxSize     int64    `yaml:"max_size,omitempty"`     // largest body validated, default 1 MiB; larger ones are rejected
+	SkipMethods []string `yaml:"skip_methods,omitempty"` // methods proxied without validation
+}
+
+// Maintenance is the static response a route serves while its backend is
+// down for maintenance.
+type Maintenance struct {
+	Enabled     bool   `yaml:"enabled"`
+	Status      int    `yaml:"status,omitempty"`       // default 503
+	Body        string `yaml:"body,omitempty"`         // default the standard error envelope
+	ContentType string `yaml:"content_type,omitempty"` // of body, default text/plain
+	RetryAfter  int    `yaml:"retry_after,omitempty"`  // seconds, sent as Retry-After
+}
+
+// DeadlinePropagation sends the time left until a request's deadline, set
+// by the route's timeout or the client, with every upstream attempt.
+type DeadlinePropagation struct {
+	Header           string        `yaml:"header,omitempty"`             // sent upstream in milliseconds, default X-Request-Timeout
+	ClientHeader     string        `yaml:"client_header,omitempty"`      // clients' own budget in milliseconds, not read unless set
+	MaxClientTimeout time.Duration `yaml:"max_client_timeout,omitempty"` // cap on client budgets; timeout applies either way
+	MinRemaining     time.Duration `yaml:"min_remaining,omitempty"`      // attempts with less left are answered 504 instead
+}
+
+// UpstreamAuth sets one credential on requests to the upstream: a bearer
+// token, basic auth or a named header. Values are usually secret
+// references, e.g. secretref:env:BACKEND_TOKEN or
+// secretref:file:/run/secrets/backend, so they stay out of the YAML.
+type UpstreamAuth struct {
+	BearerToken string              `yaml:"bearer_token,omitempty"`
+	Basic       *UpstreamBasicAuth  `yaml:"basic,omitempty"`
+	Header      *UpstreamAuthHeader `yaml:"header,omitempty"`
+
+	// StripClientAuth removes the client's Authorization header, so its
+	// credential for the gateway never reaches the upstream.
+	StripClientAuth bool `yaml:"strip_client_auth"`
+}
+
+type UpstreamBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type UpstreamAuthHeader struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// DefaultPipeline is the order in which a request passes through the
+// gateway's stages unless a route sets its own pipeline:
+//
+//   - tls_deny: reject denied TLS client fingerprints
+//   - ratelimit: route, API key, IP and TLS fingerprint rate limits
+//   - concurrency: max_concurrent, shedding or queueing excess requests
+//   - capture: sample the request for offline replay
+//   - body_limit: max_body_size
+//   - decompress: decode gzip and deflate bodies for decompress_request
+//   - schema: validate the JSON body against request_schema
+//   - idempotency: replay the stored response for a retried Idempotency-Key
+//   - fingerprint: hash the request content and count repeats
+//   - body_match: pick the upstream from the JSON body
+//   - feature_flags: evaluate the route's feature flags
+//   - mirror: send a shadow copy to the mirror upstream
+//   - validators: answer revalidations of auto_validators ETags with 304
+//   - cache: answer from the response cache, or invalidate it on writes
+//   - proxy: forward to the upstream
+var DefaultPipeline = []string{"tls_deny", "ratelimit", "concurrency", "capture", "body_limit", "decompress", "schema", "idempotency", "fingerprint", "body_match", "feature_flags", "mirror", "validators", "cache", "proxy"}
+
+// Cache stores 200 responses for TTL and, when NegativeTTL is set, 404 and
+// 410 responses for NegativeTTL.
+type Cache struct {
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl,omitempty"`
+	MaxEntries  int           `yaml:"max_entries,omitempty"`   // default 1000
+	MaxBodySize int64         `yaml:"max_body_size,omitempty"` // default 1 MiB
+
+	// InvalidateOnWrite drops the cached responses for a path when a request
+	// other than GET or HEAD is sent to it. Defaults to true.
+	InvalidateOnWrite *bool `yaml:"invalidate_on_write,omitempty"`
+
+	// VaryOn keeps a separate response per value of these request
+	// components, each header:<name>, cookie:<name> or query:<name>. With
+	// query components only the listed parameters are part of the key
+	// rather than the whole query string.
+	VaryOn []string `yaml:"vary_on,omitempty"`
+	// BypassWhen skips the cache for requests that carry any of these
+	// components, given like VaryOn, e.g. header:Authorization.
+	BypassWhen []string `yaml:"bypass_when,omitempty"`
+
+	// OnError is allow, deny or degrade (the default): what requests do
+	// when the cache store fails. Degrade bypasses the cache, deny answers
+	// 503 and allow treats the failure as a miss.
+	OnError string `yaml:"on_error,omitempty"`
+}
+
+// Request components a cache key can vary on.
+const (
+	CacheComponentHeader = "header"
+	CacheComponentCookie = "cookie"
+	CacheComponentQuery  = "query"
+)
+
+// ResponseContentTypes is the policy for upstream response content types.
+// In YAML it may also be given as just the list of types.
+type ResponseContentTypes struct {
+	Types []string `yaml:"types"` // media types, or type/* for a whole type
+
+	// Mode is reject (the default), answering 502 instead, or sanitize,
+	// relaying the body as application/octet-stream with nosniff.
+	Mode string `yaml:"mode,omitempty"`
+	// Missing is violation (the default) or sniff, which checks the type
+	// detected from the first 512 bytes of the body. Only sniff reads the
+	// body; streaming routes should keep the header-only default.
+	Missing string `yaml:"missing,omitempty"`
+}
+
+// FeatureFlag is a named flag the gateway evaluates for every request of
+// the routes that list it. Rules are tried in order and the first that
+// matches picks the variant; a request no rule matches gets Default.
+type FeatureFlag struct {
+	Name    string     `yaml:"name"`
+	Default string     `yaml:"default,omitempty"` // default "off"
+	Rules   []FlagRule `yaml:"rules,omitempty"`
+
+	// BucketBy is the stable key percentage rules hash: api_key (default),
+	// client_ip, or a header:, cookie: or query: component. A missing
+	// cookie is assigned a random value the response sets.
+	BucketBy string `yaml:"bucket_by,omitempty"`
+
+	// Header also sends the variant in a header of its own, besides
+	// X-Feature-Flags.
+	Header string `yaml:"header,omitempty"`
+}
+
+// FlagRule matches requests that meet all of the conditions it sets; a
+// rule without conditions matches every request.
+type FlagRule struct {
+	Variant string            `yaml:"variant"`
+	APIKeys []string          `yaml:"api_keys,omitempty"` // API key names
+	Headers map[string]string `yaml:"headers,omitempty"`  // exact values, or "*" for any
+	Percent *float64          `yaml:"percent,omitempty"`  // 0-100 of bucket keys
+}
+
+// Feature flag bucket keys besides header:, cookie: and query: components.
+const (
+	FlagBucketAPIKey   = "api_key"
+	FlagBucketClientIP = "client_ip"
+)
+
+// IdempotencyKeys stores the responses to requests other than GET, HEAD
+// and OPTIONS that carry an Idempotency-Key header, per route and per API
+// key or, without one, per client IP. Responses with a 5xx status are not
+// stored, so those requests can be retried.
+type IdempotencyKeys struct {
+	TTL         time.Duration `yaml:"ttl,omitempty"`           // how long a response is replayed, default 1h
+	MaxBodySize int64         `yaml:"max_body_size,omitempty"` // larger responses are not stored, default 64 KiB
+	MaxEntries  int           `yaml:"max_entries,omitempty"`   // default 10000
+}
+
+// AutoValidators configures gateway-computed validators. Only hashes are
+// kept, never bodies. In YAML it may also be given as just true or false.
+type AutoValidators struct {
+	TTL         time.Duration `yaml:"ttl,omitempty"`           // how long a validator answers 304s, default 60s
+	MaxBodySize int64         `yaml:"max_body_size,omitempty"` // larger bodies get no validators, default 1 MiB
+	MaxEntries  int           `yaml:"max_entries,omitempty"`   // validators remembered, default 10000
+
+	off bool // auto_validators: false
+}
+
+// On reports whether validators are enabled.
+func (v *AutoValidators) On() bool {
+	return v != nil && !v.off
+}
+
+// ConcurrencyQueue lets requests over max_concurrent wait for a slot.
+type ConcurrencyQueue struct {
+	Depth   int           `yaml:"depth"`             // requests waiting at once
+	Timeout time.Duration `yaml:"timeout,omitempty"` // longest wait, default 1s
+}
+
+// Adaptive concurrency algorithms.
+const (
+	AdaptiveGradient = "gradient"
+	AdaptiveAIMD     = "aimd"
+)
+
+// AdaptiveConcurrency adjusts a route's concurrency limit from the ratio of
+// its current latency to a learned baseline: the limit shrinks while
+// latency is inflated and probes upward while it is not.
+type AdaptiveConcurrency struct {
+	Algorithm    string  `yaml:"algorithm,omitempty"`     // gradient (default) or aimd
+	MinLimit     int     `yaml:"min_limit,omitempty"`     // floor, default 1
+	MaxLimit     int     `yaml:"max_limit,omitempty"`     // ceiling, default 1000
+	InitialLimit int     `yaml:"initial_limit,omitempty"` // default 20, within the bounds
+	Tolerance    float64 `yaml:"tolerance,omitempty"`     // latency ratio still healthy, default 1.5
+}
+
+// Hedging races idempotent requests without a body against copies sent to
+// other targets once the first attempt is slow to answer.
+type Hedging struct {
+	Delay       time.Duration `yaml:"delay"`                  // wait before each copy
+	MaxAttempts int           `yaml:"max_attempts,omitempty"` // requests in total, default 2
+}
+
+// Rewrite is a regular expression replacement on the upstream path. The
+// replacement may use $1 / ${name} for capture groups and {param} for path
+// parameters captured by the route pattern.
+type Rewrite struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// HeaderRules are applied in the order remove, set, add, so a wildcard
+// removal never strips a header the same block sets or adds.
+type HeaderRules struct {
+	Add    map[string]string `yaml:"add,omitempty"`
+	Set    map[string]string `yaml:"set,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"` // names, or prefixes ending in *
+}
+
+// Mirror shadows traffic to another upstream without affecting clients.
+type Mirror struct {
+	Upstream      string  `yaml:"upstream"`
+	Percent       float64 `yaml:"percent"`        // share of requests mirrored (default 100)
+	MaxConcurrent int     `yaml:"max_concurrent"` // in-flight mirrored requests (default 64)
+}
+
+// Compression controls gateway-side gzip of upstream responses.
+type Compression struct {
+	MinSize      int64    `yaml:"min_size"`      // smaller bodies are sent as-is (default 1024)
+	ContentTypes []string `yaml:"content_types"` // allowlist; "text/*" style wildcards allowed
+	Level        int      `yaml:"level"`         // 1-9, 0 for the gzip default
+}
+
+// BodyMatch routes small JSON requests to alternative upstreams based on
+// values inside the body. The route's own upstream is the fallback.
+type BodyMatch struct {
+	MaxSize int64           `yaml:"max_size"` // bytes buffered for inspection (default 64KiB)
+	Rules   []BodyMatchRule `yaml:"rules"`
+}
+
+// BodyMatchRule compares the value at a JSON pointer with exactly one of
+// Equals, Contains or Regex.
+type BodyMatchRule struct {
+	Name     string  `yaml:"name"`
+	Pointer  string  `yaml:"pointer"` // RFC 6901, e.g. /operation/type
+	Equals   *string `yaml:"equals,omitempty"`
+	Contains string  `yaml:"contains,omitempty"`
+	Regex    string  `yaml:"regex,omitempty"`
+	Upstream string  `yaml:"upstream"`
 }
 
 type RouteRateLimit struct {
@@ -57,12 +1113,39 @@ type RouteRateLimit struct {
 }
 
 type RateLimitConfig struct {
-	Enabled         bool          `yaml:"enabled"`
-	DefaultRPS      int           `yaml:"default_rps"`
-	DefaultBurst    int           `yaml:"default_burst"`
-	PerIP           bool          `yaml:"per_ip"`
-	PerAPIKey       bool          `yaml:"per_api_key"`
-	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+	Enabled           bool          `yaml:"enabled"`
+	DefaultRPS        int           `yaml:"default_rps"`
+	DefaultBurst      int           `yaml:"default_burst"`
+	PerIP             bool          `yaml:"per_ip"`
+	PerAPIKey         bool          `yaml:"per_api_key"`
+	PerTLSFingerprint bool          `yaml:"per_tls_fingerprint"`
+	CleanupInterval   time.Duration `yaml:"cleanup_interval"`
+
+	// IPv4Prefix and IPv6Prefix make per_ip share one bucket between all
+	// clients in the same network of that length, e.g. 64 for IPv6, where
+	// single addresses cost an attacker nothing. 0 limits each address.
+	IPv4Prefix int `yaml:"ipv4_prefix,omitempty"`
+	IPv6Prefix int `yaml:"ipv6_prefix,omitempty"`
+
+	// OnError decides how requests are limited while the rate limit store
+	// fails: OnErrorDegrade (default) applies the limits in this process
+	// alone, OnErrorDeny rejects requests and OnErrorAllow lets them
+	// through unlimited.
+	OnError string `yaml:"on_error,omitempty"`
+}
+
+// QuotaConfig caps the requests each API key may make over a fixed period,
+// counted across routes. Requests without an API key are not counted.
+type QuotaConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Requests int64         `yaml:"requests"`
+	Period   time.Duration `yaml:"period"` // default 24h
+
+	// OnError decides what requests do while the quota store fails:
+	// OnErrorDegrade (default) counts them in this process alone,
+	// OnErrorDeny rejects them and OnErrorAllow lets them through
+	// uncounted.
+	OnError string `yaml:"on_error,omitempty"`
 }
 
 type MetricsConfig struct {
@@ -73,9 +1156,24 @@ type MetricsConfig struct {
 }
 
 type APIKey struct {
-	Key               string `yaml:"key"`
+	// Key is the plaintext key. KeyHash ("sha256:<hex>") can be given
+	// instead, so the key itself never appears in the configuration.
+	Key               string `yaml:"key,omitempty"`
+	KeyHash           string `yaml:"key_hash,omitempty"`
 	Name              string `yaml:"name"`
+	Owner             string `yaml:"owner,omitempty"` // team that owns the key
 	RequestsPerSecond int    `yaml:"requests_per_second"`
 	BurstSize         int    `yaml:"burst_size"`
 	Enabled           bool   `yaml:"enabled"`
 }
+
+// ClusterConfig enables state sharing between gateway replicas.
+type ClusterConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	NodeID       string        `yaml:"node_id"`
+	Peers        []string      `yaml:"peers,omitempty"`         // base URLs of the other replicas
+	DiscoveryDNS string        `yaml:"discovery_dns,omitempty"` // host:port resolved to peer addresses
+	Path         string        `yaml:"path"`
+	Interval     time.Duration `yaml:"interval"`
+	Secret       string        `yaml:"secret"`
+}
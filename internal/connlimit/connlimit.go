@@ -0,0 +1,296 @@
+// Package connlimit protects the gateway's listener from clients that tie
+// up its connections: it caps the connections open from one address,
+// closes connections whose client reads responses too slowly, and counts
+// connections dropped for taking too long to send a request head.
+//
+// Clients are told apart by the TCP peer address. Behind a load balancer
+// or another proxy every connection comes from the proxy, whatever
+// X-Forwarded-For says, so its range must be exempted.
+package connlimit
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// Reasons a connection is refused or closed.
+const (
+	// ReasonIPLimit is a connection over the per-address cap, closed as
+	// soon as it was accepted.
+	ReasonIPLimit = "ip_limit"
+	// ReasonHeaderTimeout is a connection that did not send its first
+	// request head within the read header timeout.
+	ReasonHeaderTimeout = "header_timeout"
+	// ReasonMinDataRate is a connection whose client read responses
+	// slower than the minimum data rate.
+	ReasonMinDataRate = "min_data_rate"
+)
+
+const defaultGrace = 10 * time.Second
+
+// Config sets the limits. Zero values disable the cap and the data rate.
+type Config struct {
+	MaxPerIP    int
+	MinDataRate int           // bytes per second
+	Grace       time.Duration // blocked write time before the rate applies
+	Exempt      []netip.Prefix
+}
+
+// Limiter enforces Config on the connections accepted through its
+// listener.
+type Limiter struct {
+	cfg     Config
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+
+	mu    sync.Mutex
+	perIP map[netip.Addr]int
+	conns map[*conn]struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a limiter. With a minimum data rate it checks open
+// connections in the background until Stop is called.
+func New(cfg Config, m *metrics.Metrics, logger *slog.Logger) *Limiter {
+	if cfg.Grace <= 0 {
+		cfg.Grace = defaultGrace
+	}
+	l := &Limiter{
+		cfg:     cfg,
+		metrics: m,
+		logger:  logger,
+		perIP:   make(map[netip.Addr]int),
+		conns:   make(map[*conn]struct{}),
+		stop:    make(chan struct{}),
+	}
+	if cfg.MinDataRate > 0 {
+		go l.reap(min(time.Second, cfg.Grace/2))
+	}
+	return l
+}
+
+// Stop ends the background checks.
+func (l *Limiter) Stop() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+// Listener wraps ln so the limits apply to every connection it accepts.
+// It must wrap the network listener itself, below TLS and any other
+// listener wrapper.
+func (l *Limiter) Listener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, limiter: l}
+}
+
+// Install hooks the limiter into the connection states of server, which
+// must serve a listener from Listener.
+func (l *Limiter) Install(server *http.Server) {
+	prevConnState := server.ConnState
+	server.ConnState = func(c net.Conn, s http.ConnState) {
+		if lc := unwrap(c); lc != nil {
+			lc.setState(s)
+		}
+		if prevConnState != nil {
+			prevConnState(c, s)
+		}
+	}
+}
+
+// unwrap finds the limiter's conn under TLS and other wrappers that expose
+// the connection they wrap.
+func unwrap(c net.Conn) *conn {
+	for {
+		switch v := c.(type) {
+		case *conn:
+			return v
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *Limiter) exempt(addr netip.Addr) bool {
+	for _, prefix := range l.cfg.Exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire counts a new connection from addr, unless addr is at the cap.
+func (l *Limiter) acquire(c *conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.MaxPerIP > 0 && l.perIP[c.addr] >= l.cfg.MaxPerIP {
+		return false
+	}
+	l.perIP[c.addr]++
+	l.conns[c] = struct{}{}
+	return true
+}
+
+func (l *Limiter) release(c *conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.perIP[c.addr]--; l.perIP[c.addr] <= 0 {
+		delete(l.perIP, c.addr)
+	}
+	delete(l.conns, c)
+}
+
+// reap closes the connections that fell below the minimum data rate.
+func (l *Limiter) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			var slow []*conn
+			l.mu.Lock()
+			for c := range l.conns {
+				if c.tooSlow(now, l.cfg.MinDataRate, l.cfg.Grace) {
+					slow = append(slow, c)
+				}
+			}
+			l.mu.Unlock()
+			for _, c := range slow {
+				l.metrics.RecordConnectionClosed(ReasonMinDataRate)
+				l.logger.Warn("slow connection closed",
+					"reason", ReasonMinDataRate,
+					"remote_addr", c.RemoteAddr().String(),
+					"min_data_rate", l.cfg.MinDataRate)
+				_ = c.Close()
+			}
+		}
+	}
+}
+
+type listener struct {
+	net.Listener
+	limiter *Limiter
+}
+
+// Accept returns the next connection within the limits. Connections over
+// the per-address cap are closed without a response: the client has not
+// sent a request yet, and writing one would cost what the cap saves.
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr := peerAddr(c)
+		if !addr.IsValid() || l.limiter.exempt(addr) {
+			return c, nil
+		}
+		lc := &conn{Conn: c, limiter: l.limiter, addr: addr}
+		if l.limiter.acquire(lc) {
+			return lc, nil
+		}
+		l.limiter.metrics.RecordConnectionClosed(ReasonIPLimit)
+		_ = c.Close()
+	}
+}
+
+func peerAddr(c net.Conn) netip.Addr {
+	if tcp, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return tcp.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
+
+// conn is an accepted connection counted against its address. It measures
+// how long writes to it block: a client reading slowly keeps them blocked
+// while little data moves. Reads are not measured, since net/http keeps
+// one pending on an idle or HTTP/2 connection without the client being
+// slow; request heads are bounded by the read header timeout instead.
+type conn struct {
+	net.Conn
+	limiter   *Limiter
+	addr      netip.Addr
+	closeOnce sync.Once
+
+	mu             sync.Mutex
+	state          http.ConnState
+	headerTimedOut bool          // net/http may read again after the deadline passed
+	writing        time.Time     // start of the write in progress, zero if none
+	blocked        time.Duration // time spent in writes since the connection was last idle
+	written        int64         // bytes written in that time
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		c.mu.Lock()
+		count := c.state == http.StateNew && !c.headerTimedOut
+		c.headerTimedOut = true
+		c.mu.Unlock()
+		if count {
+			c.limiter.metrics.RecordConnectionClosed(ReasonHeaderTimeout)
+		}
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if c.limiter.cfg.MinDataRate == 0 {
+		return c.Conn.Write(p)
+	}
+	c.mu.Lock()
+	c.writing = time.Now()
+	c.mu.Unlock()
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	c.blocked += time.Since(c.writing)
+	c.writing = time.Time{}
+	c.written += int64(n)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *conn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.limiter.release(c) })
+	return err
+}
+
+// setState follows the connection's HTTP state. An idle connection has
+// delivered its responses, so the data rate starts over with the next.
+func (c *conn) setState(s http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == http.StateNew && s == http.StateClosed {
+		// Counted by Read if it timed out; state must stay New for that.
+		return
+	}
+	c.state = s
+	if s == http.StateIdle {
+		c.blocked, c.written = 0, 0
+	}
+}
+
+// tooSlow reports whether writes have been blocked for at least grace in
+// total while moving fewer than rate bytes per second.
+func (c *conn) tooSlow(now time.Time, rate int, grace time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blocked := c.blocked
+	if !c.writing.IsZero() {
+		blocked += now.Sub(c.writing)
+	}
+	return blocked >= grace && float64(c.written) < float64(rate)*blocked.Seconds()
+}
@@ -0,0 +1,224 @@
+package connlimit
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// startServer serves handler behind a limiter with cfg.
+func startServer(t *testing.T, cfg Config, handler http.Handler, configure func(*http.Server)) (*httptest.Server, *metrics.Metrics) {
+	t.Helper()
+	m := metrics.New(metrics.Config{})
+	l := New(cfg, m, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	t.Cleanup(l.Stop)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Listener = l.Listener(srv.Listener)
+	if configure != nil {
+		configure(srv.Config)
+	}
+	l.Install(srv.Config)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, m
+}
+
+func dial(t *testing.T, srv *httptest.Server) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// get sends a request on c and reports whether a response came back.
+func get(c net.Conn) bool {
+	_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		return false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode == http.StatusOK
+}
+
+func closedTotal(t *testing.T, m *metrics.Metrics, reason string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	prefix := `gateway_connections_closed_total{reason="` + reason + `"} `
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return "0"
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {}
+
+func TestLimiter_MaxPerIP(t *testing.T) {
+	srv, m := startServer(t, Config{MaxPerIP: 3}, http.HandlerFunc(ok), nil)
+
+	// Idle connections hold their slots.
+	var held []net.Conn
+	for range 3 {
+		c := dial(t, srv)
+		if !get(c) {
+			t.Fatal("request within the cap failed")
+		}
+		held = append(held, c)
+	}
+	for range 5 {
+		if get(dial(t, srv)) {
+			t.Fatal("connection over the cap was served")
+		}
+	}
+	if got := closedTotal(t, m, ReasonIPLimit); got != "5" {
+		t.Errorf("ip_limit closes = %s, want 5", got)
+	}
+
+	// Closing one frees its slot once the server has seen it go.
+	_ = held[0].Close()
+	waitFor(t, "a freed slot", func() bool { return get(dial(t, srv)) })
+	if !get(held[1]) {
+		t.Error("held connection no longer served")
+	}
+}
+
+func TestLimiter_Exempt(t *testing.T) {
+	srv, m := startServer(t, Config{
+		MaxPerIP: 1,
+		Exempt:   []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+	}, http.HandlerFunc(ok), nil)
+
+	for range 5 {
+		if !get(dial(t, srv)) {
+			t.Fatal("exempt connection not served")
+		}
+	}
+	if got := closedTotal(t, m, ReasonIPLimit); got != "0" {
+		t.Errorf("ip_limit closes = %s, want 0", got)
+	}
+}
+
+func TestLimiter_HeaderTimeout(t *testing.T) {
+	srv, m := startServer(t, Config{}, http.HandlerFunc(ok), func(s *http.Server) {
+		s.ReadHeaderTimeout = 100 * time.Millisecond
+		s.IdleTimeout = 100 * time.Millisecond
+	})
+
+	// A request head that never completes.
+	c := dial(t, srv)
+	if _, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = io.Copy(io.Discard, c)
+	waitFor(t, "the header timeout", func() bool { return closedTotal(t, m, ReasonHeaderTimeout) == "1" })
+
+	// An idle keep-alive connection timing out is not a slow header.
+	c = dial(t, srv)
+	if !get(c) {
+		t.Fatal("request failed")
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _ = io.Copy(io.Discard, c)
+	if got := closedTotal(t, m, ReasonHeaderTimeout); got != "1" {
+		t.Errorf("header_timeout closes = %s, want 1", got)
+	}
+}
+
+func TestLimiter_MinDataRate(t *testing.T) {
+	chunk := make([]byte, 64<<10)
+	done := make(chan error, 1)
+	srv, m := startServer(t, Config{MaxPerIP: 1, MinDataRate: 16 << 20, Grace: 200 * time.Millisecond},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/small" {
+				return
+			}
+			// Far more than the socket buffers hold, so writes block on a
+			// client that does not read. The rate is set high for the
+			// data the buffers take in before that.
+			for range 1024 {
+				if _, err := w.Write(chunk); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}), nil)
+
+	c := dial(t, srv)
+	if _, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("response to a client that does not read completed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow reader not closed")
+	}
+	if got := closedTotal(t, m, ReasonMinDataRate); got != "1" {
+		t.Errorf("min_data_rate closes = %s, want 1", got)
+	}
+
+	// The reaped connection no longer counts against the cap.
+	waitFor(t, "a freed slot", func() bool {
+		c := dial(t, srv)
+		defer c.Close()
+		_ = c.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.WriteString(c, "GET /small HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+			return false
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		return err == nil && resp.StatusCode == http.StatusOK
+	})
+}
+
+func TestLimiter_FastClientKept(t *testing.T) {
+	body := strings.Repeat("x", 1<<20)
+	srv, m := startServer(t, Config{MinDataRate: 1024, Grace: 50 * time.Millisecond},
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, body)
+		}), nil)
+
+	c := dial(t, srv)
+	for range 5 {
+		if !get(c) {
+			t.Fatal("request from a client reading at full speed failed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := closedTotal(t, m, ReasonMinDataRate); got != "0" {
+		t.Errorf("min_data_rate closes = %s, want 0", got)
+	}
+}
@@ -0,0 +1,224 @@
+// Package degradation steps a route through an ordered ladder of overload
+// responses as its load rises and falls.
+package degradation
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+const (
+	defaultInterval = time.Second
+	defaultWindow   = 10 * time.Second
+
+	// exitRatio is the share of an enter threshold the load must fall below
+	// to leave a level when no exit threshold is configured.
+	exitRatio = 0.8
+)
+
+// Load is what the ladder measured over its window.
+type Load struct {
+	InFlight  int64
+	Latency   time.Duration // mean request duration
+	ErrorRate float64       // share of 5xx responses
+}
+
+// bucket holds the requests finished during one interval.
+type bucket struct {
+	count   int64
+	errors  int64
+	latency time.Duration
+}
+
+// Ladder tracks the load of one route, or of all routes sharing the
+// top-level ladder, and its current level. Level 0 is normal operation;
+// level n means the actions of the first n levels apply.
+type Ladder struct {
+	scope    string
+	levels   []config.DegradationLevel
+	interval time.Duration
+
+	inFlight atomic.Int64
+	level    atomic.Int32
+
+	mu      sync.Mutex
+	current bucket
+	window  []bucket // ring of completed intervals
+	next    int
+
+	metrics *metrics.Metrics // may be nil
+	logger  *slog.Logger
+}
+
+// New creates a ladder at level 0. scope names it in logs and metrics.
+func New(scope string, cfg *config.Degradation, m *metrics.Metrics, logger *slog.Logger) *Ladder {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	window := cfg.Window
+	if window <= 0 {
+		window = defaultWindow
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	levels := make([]config.DegradationLevel, len(cfg.Levels))
+	for i, l := range cfg.Levels {
+		if l.Exit.InFlight == 0 && l.Enter.InFlight > 0 {
+			l.Exit.InFlight = max(1, int64(float64(l.Enter.InFlight)*exitRatio))
+		}
+		if l.Exit.Latency == 0 {
+			l.Exit.Latency = time.Duration(float64(l.Enter.Latency) * exitRatio)
+		}
+		if l.Exit.ErrorRate == 0 {
+			l.Exit.ErrorRate = l.Enter.ErrorRate * exitRatio
+		}
+		levels[i] = l
+	}
+
+	l := &Ladder{
+		scope:    scope,
+		levels:   levels,
+		interval: interval,
+		window:   make([]bucket, max(1, int(window/interval))),
+		metrics:  m,
+		logger:   logger,
+	}
+	if m != nil {
+		m.RecordDegradationLevel(scope, 0)
+	}
+	return l
+}
+
+// Interval is how often Evaluate should be called.
+func (l *Ladder) Interval() time.Duration {
+	return l.interval
+}
+
+// Begin counts a request as in flight. The returned function must be called
+// with its status once it is answered.
+func (l *Ladder) Begin() (done func(status int)) {
+	start := time.Now()
+	l.inFlight.Add(1)
+	return func(status int) {
+		l.inFlight.Add(-1)
+		l.observe(status, time.Since(start))
+	}
+}
+
+func (l *Ladder) observe(status int, d time.Duration) {
+	l.mu.Lock()
+	l.current.count++
+	l.current.latency += d
+	if status >= 500 {
+		l.current.errors++
+	}
+	l.mu.Unlock()
+}
+
+// load closes the current interval and returns the load over the window.
+func (l *Ladder) load() Load {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.window[l.next] = l.current
+	l.next = (l.next + 1) % len(l.window)
+	l.current = bucket{}
+
+	var total bucket
+	for _, b := range l.window {
+		total.count += b.count
+		total.errors += b.errors
+		total.latency += b.latency
+	}
+	load := Load{InFlight: l.inFlight.Load()}
+	if total.count > 0 {
+		load.Latency = total.latency / time.Duration(total.count)
+		load.ErrorRate = float64(total.errors) / float64(total.count)
+	}
+	return load
+}
+
+// Evaluate closes the current interval and moves at most one level up or
+// down: up when the next level's enter condition holds, down when the
+// load is below every exit threshold of the current level.
+func (l *Ladder) Evaluate() {
+	load := l.load()
+	from := int(l.level.Load())
+	to := from
+	switch {
+	case from < len(l.levels) && reached(load, l.levels[from].Enter):
+		to = from + 1
+	case from > 0 && below(load, l.levels[from-1].Exit):
+		to = from - 1
+	}
+	if to == from {
+		return
+	}
+	l.level.Store(int32(to))
+	if l.metrics != nil {
+		l.metrics.RecordDegradationLevel(l.scope, to)
+	}
+
+	name := "normal"
+	if to > 0 {
+		name = l.levels[to-1].Name
+	}
+	l.logger.Warn("degradation level changed",
+		"scope", l.scope,
+		"from", from,
+		"to", to,
+		"level", name,
+		"in_flight", load.InFlight,
+		"latency", load.Latency,
+		"error_rate", load.ErrorRate,
+	)
+}
+
+// reached reports whether any threshold set in t is reached.
+func reached(load Load, t config.DegradationThresholds) bool {
+	return (t.InFlight > 0 && load.InFlight >= t.InFlight) ||
+		(t.Latency > 0 && load.Latency >= t.Latency) ||
+		(t.ErrorRate > 0 && load.ErrorRate >= t.ErrorRate)
+}
+
+// below reports whether the load is under every threshold set in t.
+func below(load Load, t config.DegradationThresholds) bool {
+	return (t.InFlight == 0 || load.InFlight < t.InFlight) &&
+		(t.Latency == 0 || load.Latency < t.Latency) &&
+		(t.ErrorRate == 0 || load.ErrorRate < t.ErrorRate)
+}
+
+// Level returns the current level, 0 meaning normal operation.
+func (l *Ladder) Level() int {
+	return int(l.level.Load())
+}
+
+// Active reports whether a level with action is in effect.
+func (l *Ladder) Active(action string) bool {
+	for _, lv := range l.levels[:l.Level()] {
+		if lv.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// ShedPercent returns the share of requests to shed, the highest of the
+// shed levels in effect.
+func (l *Ladder) ShedPercent() float64 {
+	var pct float64
+	for _, lv := range l.levels[:l.Level()] {
+		if lv.Action == config.DegradeShed {
+			pct = max(pct, lv.ShedPercent)
+		}
+	}
+	return pct
+}
@@ -0,0 +1,143 @@
+package degradation
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+var quiet = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func ladderConfig() *config.Degradation {
+	return &config.Degradation{
+		Interval: time.Second,
+		Window:   3 * time.Second,
+		Levels: []config.DegradationLevel{
+			{Name: "no-transforms", Action: config.DegradeDisableTransforms, Enter: config.DegradationThresholds{InFlight: 10}},
+			{Name: "stale", Action: config.DegradeServeStale, Enter: config.DegradationThresholds{InFlight: 20}},
+			{Name: "shed", Action: config.DegradeShed, ShedPercent: 50, Enter: config.DegradationThresholds{InFlight: 30}},
+			{Name: "reject", Action: config.DegradeReject, Enter: config.DegradationThresholds{ErrorRate: 0.5}},
+		},
+	}
+}
+
+func TestLadder_EngagesAndReleasesInOrder(t *testing.T) {
+	m := metrics.New(metrics.Config{})
+	l := New("orders", ladderConfig(), m, quiet)
+
+	// Synthetic overload: 40 requests stuck in flight.
+	var done []func(int)
+	for i := 0; i < 40; i++ {
+		done = append(done, l.Begin())
+	}
+	for want := 1; want <= 3; want++ {
+		l.Evaluate()
+		if got := l.Level(); got != want {
+			t.Fatalf("level = %d after %d evaluations, want %d", got, want, want)
+		}
+	}
+	if !l.Active(config.DegradeDisableTransforms) || !l.Active(config.DegradeServeStale) || l.ShedPercent() != 50 {
+		t.Error("lower levels not in effect at level 3")
+	}
+	// Level 4 needs errors, not in-flight requests.
+	l.Evaluate()
+	if got := l.Level(); got != 3 {
+		t.Fatalf("level = %d without errors, want 3", got)
+	}
+
+	body := httptest.NewRecorder()
+	m.Handler().ServeHTTP(body, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(body.Body.String(), `gateway_degradation_level{scope="orders"} 3`) {
+		t.Errorf("metrics missing level 3:\n%s", body.Body.String())
+	}
+
+	// Load drops: the ladder steps down one level per evaluation.
+	for _, finish := range done {
+		finish(http.StatusOK)
+	}
+	for want := 2; want >= 0; want-- {
+		l.Evaluate()
+		if got := l.Level(); got != want {
+			t.Fatalf("level = %d while releasing, want %d", got, want)
+		}
+	}
+	if l.Active(config.DegradeDisableTransforms) || l.ShedPercent() != 0 {
+		t.Error("actions still in effect at level 0")
+	}
+}
+
+func TestLadder_Hysteresis(t *testing.T) {
+	l := New("orders", ladderConfig(), nil, quiet)
+
+	var done []func(int)
+	begin := func(n int) {
+		for i := 0; i < n; i++ {
+			done = append(done, l.Begin())
+		}
+	}
+	finish := func(n int) {
+		for i := 0; i < n; i++ {
+			done[len(done)-1](http.StatusOK)
+			done = done[:len(done)-1]
+		}
+	}
+
+	begin(10)
+	l.Evaluate()
+	if l.Level() != 1 {
+		t.Fatalf("level = %d at the enter threshold, want 1", l.Level())
+	}
+	// Between the exit threshold (80% of 10) and the enter threshold the
+	// level holds.
+	finish(1)
+	for i := 0; i < 3; i++ {
+		l.Evaluate()
+		if l.Level() != 1 {
+			t.Fatalf("level = %d at 9 in flight, want 1", l.Level())
+		}
+	}
+	finish(2)
+	l.Evaluate()
+	if l.Level() != 0 {
+		t.Errorf("level = %d below the exit threshold, want 0", l.Level())
+	}
+}
+
+func TestLadder_ErrorRateWindow(t *testing.T) {
+	cfg := &config.Degradation{
+		Interval: time.Second,
+		Window:   2 * time.Second,
+		Levels: []config.DegradationLevel{
+			{Name: "reject", Action: config.DegradeReject, Enter: config.DegradationThresholds{ErrorRate: 0.5}},
+		},
+	}
+	l := New("orders", cfg, nil, quiet)
+
+	for i := 0; i < 4; i++ {
+		l.Begin()(http.StatusBadGateway)
+	}
+	l.Begin()(http.StatusOK)
+	l.Evaluate()
+	if !l.Active(config.DegradeReject) {
+		t.Fatal("80% errors did not engage the level")
+	}
+
+	// The failing interval is still in the two-interval window.
+	l.Begin()(http.StatusOK)
+	l.Evaluate()
+	if l.Level() != 1 {
+		t.Fatal("released while the window still holds the errors")
+	}
+	l.Begin()(http.StatusOK)
+	l.Evaluate()
+	if l.Level() != 0 {
+		t.Error("not released once the errors left the window")
+	}
+}
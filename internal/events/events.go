@@ -0,0 +1,114 @@
+// Package events is the in-process bus for gateway state changes, such as
+// target health transitions, consumed by the admin event stream.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// Event types published by the gateway.
+const (
+	TargetHealth     = "target_health"
+	RouteTripped     = "route_tripped"
+	ConfigReload     = "config_reload"
+	TargetReport     = "target_report"
+	SyntheticProbe   = "synthetic_probe"
+	RouteDebug       = "route_debug"
+	RouteMaintenance = "route_maintenance"
+	GatewayMode      = "gateway_mode"
+	RouteArchive     = "route_archive"
+	RouteEnabled     = "route_enabled"
+)
+
+// Event is one state change. Data holds type-specific fields.
+type Event struct {
+	Type string         `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a
+// subscriber whose buffer is full misses the event and it is counted.
+type Bus struct {
+	metrics *metrics.Metrics
+
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus creates a bus. m may be nil.
+func NewBus(m *metrics.Metrics) *Bus {
+	return &Bus{
+		metrics: m,
+		subs:    make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events of the requested types on C.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	types   map[string]bool // empty means all types
+	dropped atomic.Int64
+}
+
+// Dropped reports how many events this subscriber missed because its
+// buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Subscribe registers a subscriber for the given types (all types if none)
+// with a buffer of the given size.
+func (b *Bus) Subscribe(types []string, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = 1
+	}
+	ch := make(chan Event, buffer)
+	s := &Subscription{C: ch, ch: ch, types: make(map[string]bool)}
+	for _, t := range types {
+		s.types[t] = true
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes s; no further events are delivered to it.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+}
+
+// Publish delivers an event of type typ to every interested subscriber.
+func (b *Bus) Publish(typ string, data map[string]any) {
+	if b == nil {
+		return
+	}
+	e := Event{Type: typ, Time: time.Now().UTC(), Data: data}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		if len(s.types) > 0 && !s.types[typ] {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+			if b.metrics != nil {
+				b.metrics.RecordEventDropped(typ)
+			}
+		}
+	}
+}
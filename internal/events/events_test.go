@@ -0,0 +1,48 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus_FiltersByType(t *testing.T) {
+	b := NewBus(nil)
+	sub := b.Subscribe([]string{TargetHealth}, 4)
+	defer b.Unsubscribe(sub)
+
+	b.Publish(ConfigReload, nil)
+	b.Publish(TargetHealth, map[string]any{"healthy": false})
+
+	e := <-sub.C
+	if e.Type != TargetHealth {
+		t.Fatalf("got %q event, want %q", e.Type, TargetHealth)
+	}
+	if len(sub.C) != 0 {
+		t.Errorf("unexpected extra events: %d", len(sub.C))
+	}
+}
+
+func TestBus_DropsWhenSubscriberIsFull(t *testing.T) {
+	b := NewBus(nil)
+	slow := b.Subscribe(nil, 2)
+	fast := b.Subscribe(nil, 8)
+
+	for i := 0; i < 5; i++ {
+		b.Publish(TargetHealth, map[string]any{"n": i})
+	}
+
+	if got := slow.Dropped(); got != 3 {
+		t.Errorf("slow subscriber dropped %d events, want 3", got)
+	}
+	if got := fast.Dropped(); got != 0 {
+		t.Errorf("fast subscriber dropped %d events, want 0", got)
+	}
+	if e := <-slow.C; e.Data["n"] != 0 {
+		t.Errorf("slow subscriber kept %v, want the oldest events", e.Data["n"])
+	}
+
+	b.Unsubscribe(slow)
+	b.Publish(TargetHealth, nil)
+	if got := slow.Dropped(); got != 3 {
+		t.Errorf("unsubscribed subscriber still receiving: dropped = %d", got)
+	}
+}
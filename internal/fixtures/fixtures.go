@@ -0,0 +1,261 @@
+// Package fixtures runs the request fixtures of a configuration's tests
+// section against an in-process gateway, with upstream calls intercepted
+// so that no request leaves the process.
+package fixtures
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/proxy"
+)
+
+// Token is the access token fetches from an upstream's token_url return,
+// so fixtures can check the Authorization the gateway sends.
+const Token = "fixture-token"
+
+// Result is the outcome of one fixture.
+type Result struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+	Failures []string      `json:"failures,omitempty"`
+}
+
+// Report summarizes a run.
+type Report struct {
+	Results []Result `json:"results"`
+	Failed  int      `json:"failed"`
+}
+
+// Run builds a gateway from cfg and runs cfg.Tests against it in order.
+// Subsystems that write files or reach the network, such as the access
+// log, tracing, capture, mirroring and state files, are turned off in a
+// copy of cfg first; cfg itself is not modified.
+func Run(cfg *config.Config) (*Report, error) {
+	p, err := proxy.New(sandbox(cfg))
+	if err != nil {
+		return nil, err
+	}
+	defer p.Stop()
+	t := newTransport(cfg)
+	p.SetTransport(t)
+	// Fixture paths are written as clients send them, under the base path.
+	h := p.BasePathHandler(p)
+
+	report := &Report{Results: make([]Result, 0, len(cfg.Tests))}
+	for _, f := range cfg.Tests {
+		start := time.Now()
+		result := Result{Name: f.Name, Failures: run(p, h, t, f)}
+		result.Duration = time.Since(start)
+		if len(result.Failures) > 0 {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+func sandbox(cfg *config.Config) *config.Config {
+	c := *cfg
+	c.AccessLog.Enabled = false
+	c.Tracing.Enabled = false
+	c.Capture = config.CaptureConfig{}
+	c.ReadOnly.StateFile = ""
+	c.RouteActivity.StateFile = ""
+	// Health checks do not run, so no upstream would ever be checked.
+	c.Server.WaitForInitialHealth = false
+	c.Routes = slices.Clone(cfg.Routes)
+	for i := range c.Routes {
+		c.Routes[i].Mirror = nil
+	}
+	return &c
+}
+
+// run sends the fixture's request and returns what did not go as expected.
+func run(p *proxy.Proxy, h http.Handler, t *transport, f config.Fixture) []string {
+	req := f.Request
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if req.Body != "" {
+		body = strings.NewReader(req.Body)
+	}
+	r := httptest.NewRequest(method, req.Path, body)
+	if req.Host != "" {
+		r.Host = req.Host
+	}
+	for name, value := range req.Headers {
+		r.Header.Set(name, value)
+	}
+
+	route := p.RouteFor(r)
+	t.reset()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	upstream := t.first()
+
+	var failures []string
+	fail := func(format string, args ...any) {
+		failures = append(failures, fmt.Sprintf(format, args...))
+	}
+	e := f.Expect
+	if e.Route != "" && route != e.Route {
+		if route == "" {
+			fail("no route matched, want %s", e.Route)
+		} else {
+			fail("route = %s, want %s", route, e.Route)
+		}
+	}
+	if (e.UpstreamURL != "" || len(e.UpstreamHeaders) > 0) && upstream == nil {
+		fail("no upstream request was sent (status %d)", rec.Code)
+	} else if upstream != nil {
+		if e.UpstreamURL != "" && upstream.URL.String() != e.UpstreamURL {
+			fail("upstream URL = %s, want %s", upstream.URL, e.UpstreamURL)
+		}
+		for _, name := range sortedKeys(e.UpstreamHeaders) {
+			want, got := e.UpstreamHeaders[name], upstream.Header.Get(name)
+			switch {
+			case want == "" && got != "":
+				fail("upstream header %s = %q, want it absent", name, got)
+			case got != want:
+				fail("upstream header %s = %q, want %q", name, got, want)
+			}
+		}
+	}
+	if e.Status != 0 && rec.Code != e.Status {
+		fail("status = %d, want %d", rec.Code, e.Status)
+	}
+	return failures
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// transport stands in for the upstreams: it keeps the requests the gateway
+// sends and answers them 200 with an empty body, or with a token for
+// requests to a token_url.
+type transport struct {
+	tokenURLs map[string]bool
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newTransport(cfg *config.Config) *transport {
+	t := &transport{tokenURLs: make(map[string]bool)}
+	for _, u := range cfg.Upstreams {
+		if u.Auth != nil {
+			t.tokenURLs[u.Auth.TokenURL] = true
+		}
+	}
+	return t
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+	if t.tokenURLs[req.URL.String()] {
+		token := fmt.Sprintf(`{"access_token":%q,"token_type":"Bearer"}`, Token)
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Body = io.NopCloser(strings.NewReader(token))
+		resp.ContentLength = int64(len(token))
+		return resp, nil
+	}
+
+	t.mu.Lock()
+	t.requests = append(t.requests, req)
+	t.mu.Unlock()
+	return resp, nil
+}
+
+func (t *transport) reset() {
+	t.mu.Lock()
+	t.requests = nil
+	t.mu.Unlock()
+}
+
+// first returns the first upstream request sent since reset, or nil.
+func (t *transport) first() *http.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.requests) == 0 {
+		return nil
+	}
+	return t.requests[0]
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite, which CI systems
+// display per fixture.
+func (r *Report) WriteJUnit(w io.Writer, suite string) error {
+	type failure struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+	type testCase struct {
+		Name      string   `xml:"name,attr"`
+		Classname string   `xml:"classname,attr"`
+		Time      string   `xml:"time,attr"`
+		Failure   *failure `xml:"failure,omitempty"`
+	}
+	type testSuite struct {
+		XMLName  xml.Name   `xml:"testsuite"`
+		Name     string     `xml:"name,attr"`
+		Tests    int        `xml:"tests,attr"`
+		Failures int        `xml:"failures,attr"`
+		Time     string     `xml:"time,attr"`
+		Cases    []testCase `xml:"testcase"`
+	}
+	seconds := func(d time.Duration) string { return fmt.Sprintf("%.3f", d.Seconds()) }
+
+	s := testSuite{Name: suite, Tests: len(r.Results), Failures: r.Failed}
+	var total time.Duration
+	for _, res := range r.Results {
+		total += res.Duration
+		c := testCase{Name: res.Name, Classname: suite, Time: seconds(res.Duration)}
+		if len(res.Failures) > 0 {
+			c.Failure = &failure{Message: res.Failures[0], Text: strings.Join(res.Failures, "\n")}
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
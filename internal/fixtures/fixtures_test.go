@@ -0,0 +1,201 @@
+package fixtures
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const testConfig = `
+upstreams:
+  - name: orders
+    targets:
+      - url: http://orders.internal:8080/base
+  - name: billing
+    targets:
+      - url: https://billing.internal
+    auth:
+      type: oauth2_client_credentials
+      token_url: https://auth.internal/token
+      client_id: gateway
+      client_secret_ref: s3cret
+routes:
+  - name: orders
+    path: /api/orders/:id
+    upstream: orders
+    strip_path: true
+    rewrite:
+      pattern: ^/(.*)$
+      replacement: /v2/orders/$1
+    headers:
+      X-Gateway: relaypoint
+    methods: [GET]
+  - name: billing
+    path: /billing/**
+    upstream: billing
+tests:
+  - name: orders are rewritten
+    request:
+      path: /api/orders/42?expand=items
+      headers:
+        Authorization: Bearer client
+    expect:
+      route: orders
+      upstream_url: http://orders.internal:8080/base/v2/orders/42?expand=items
+      upstream_headers:
+        X-Gateway: relaypoint
+        Authorization: Bearer client
+  - name: billing gets a token
+    request:
+      method: POST
+      path: /billing/invoices
+      body: "{}"
+    expect:
+      route: billing
+      upstream_url: https://billing.internal/billing/invoices
+      upstream_headers:
+        Authorization: Bearer fixture-token
+  - name: unknown paths are not found
+    request:
+      path: /nowhere
+    expect:
+      status: 404
+`
+
+func TestRun(t *testing.T) {
+	cfg, err := config.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 0 || len(report.Results) != 3 {
+		t.Fatalf("report = %+v, want 3 passing fixtures", report)
+	}
+}
+
+func TestRun_Mismatches(t *testing.T) {
+	cfg, err := config.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Tests = []config.Fixture{
+		{
+			Name:    "wrong upstream path",
+			Request: config.FixtureRequest{Path: "/api/orders/42"},
+			Expect: config.FixtureExpect{
+				Route:           "orders",
+				UpstreamURL:     "http://orders.internal:8080/base/orders/42",
+				UpstreamHeaders: map[string]string{"X-Gateway": "other", "Authorization": ""},
+			},
+		},
+		{
+			Name:    "method mismatch",
+			Request: config.FixtureRequest{Method: "DELETE", Path: "/api/orders/42"},
+			Expect:  config.FixtureExpect{Route: "orders", UpstreamURL: "http://orders.internal:8080/base/v2/orders/42"},
+		},
+		{
+			Name:    "passes",
+			Request: config.FixtureRequest{Method: "DELETE", Path: "/api/orders/42"},
+			Expect:  config.FixtureExpect{Status: 405},
+		},
+	}
+	report, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 2 {
+		t.Fatalf("failed = %d, want 2: %+v", report.Failed, report)
+	}
+	want := [][]string{
+		{
+			"upstream URL = http://orders.internal:8080/base/v2/orders/42, want http://orders.internal:8080/base/orders/42",
+			`upstream header X-Gateway = "relaypoint", want "other"`,
+		},
+		{
+			"no route matched, want orders",
+			"no upstream request was sent (status 405)",
+		},
+		nil,
+	}
+	for i, r := range report.Results {
+		if strings.Join(r.Failures, "\n") != strings.Join(want[i], "\n") {
+			t.Errorf("%s: failures = %q, want %q", r.Name, r.Failures, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJUnit(&buf, "relaypoint.yml"); err != nil {
+		t.Fatal(err)
+	}
+	var suite struct {
+		Tests    int `xml:"tests,attr"`
+		Failures int `xml:"failures,attr"`
+		Cases    []struct {
+			Name    string `xml:"name,attr"`
+			Failure *struct {
+				Message string `xml:"message,attr"`
+			} `xml:"failure"`
+		} `xml:"testcase"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("JUnit report does not parse: %v\n%s", err, buf.String())
+	}
+	if suite.Tests != 3 || suite.Failures != 2 || suite.Cases[0].Failure == nil || suite.Cases[2].Failure != nil {
+		t.Errorf("JUnit report:\n%s", buf.String())
+	}
+	if suite.Cases[1].Failure.Message != "no route matched, want orders" {
+		t.Errorf("failure message = %q", suite.Cases[1].Failure.Message)
+	}
+}
+
+func TestRun_BasePath(t *testing.T) {
+	cfg, err := config.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Server.BasePath = "/gateway/"
+	cfg.Tests = []config.Fixture{
+		{
+			Name:    "under the base path",
+			Request: config.FixtureRequest{Path: "/gateway/api/orders/42"},
+			Expect: config.FixtureExpect{
+				Route:           "orders",
+				UpstreamURL:     "http://orders.internal:8080/base/v2/orders/42",
+				UpstreamHeaders: map[string]string{"X-Forwarded-Prefix": "/gateway"},
+			},
+		},
+		{
+			Name:    "outside the base path",
+			Request: config.FixtureRequest{Path: "/api/orders/42"},
+			Expect:  config.FixtureExpect{Status: 404},
+		},
+	}
+	report, err := Run(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 0 {
+		t.Fatalf("report = %+v, want passing fixtures", report)
+	}
+}
+
+func TestRun_LeavesConfigAlone(t *testing.T) {
+	cfg, err := config.Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AccessLog.Enabled = true
+	cfg.Routes[0].Mirror = &config.Mirror{Upstream: "billing"}
+	if _, err := Run(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AccessLog.Enabled || cfg.Routes[0].Mirror == nil {
+		t.Error("Run modified the configuration")
+	}
+}
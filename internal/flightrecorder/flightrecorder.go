@@ -0,0 +1,131 @@
+// Package flightrecorder keeps timing breakdowns of recent requests in a
+// fixed-size ring buffer so slow requests can be inspected after the fact.
+package flightrecorder
+
+import (
+	"sync"
+	"time"
+)
+
+// Phases holds the time spent in each stage of a request, in milliseconds.
+// Upstream phases are zero when they did not happen, e.g. DNS and Connect
+// on a reused connection.
+type Phases struct {
+	RouteMatch float64 `json:"route_match_ms"`
+	RateLimit  float64 `json:"rate_limit_ms"`
+	DNS        float64 `json:"dns_ms"`
+	Connect    float64 `json:"connect_ms"`
+	TLS        float64 `json:"tls_ms"`
+	TTFB       float64 `json:"ttfb_ms"`
+	BodyCopy   float64 `json:"body_copy_ms"`
+}
+
+// Entry is one recorded request. It never holds bodies, header values or
+// query string values.
+type Entry struct {
+	RequestID  string    `json:"request_id"`
+	Time       time.Time `json:"time"`
+	Route      string    `json:"route"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Status     int       `json:"status"`
+	Duration   float64   `json:"duration_ms"`
+	ConnReused bool      `json:"conn_reused"`
+	Phases     Phases    `json:"phases"`
+}
+
+// Recorder is a bounded, concurrency-safe ring buffer of entries.
+type Recorder struct {
+	mu      sync.RWMutex
+	entries []Entry
+	next    int
+	full    bool
+	byID    map[string]int // request ID -> index in entries
+}
+
+// New creates a recorder holding at most size entries.
+func New(size int) *Recorder {
+	if size <= 0 {
+		size = 1
+	}
+	return &Recorder{
+		entries: make([]Entry, size),
+		byID:    make(map[string]int, size),
+	}
+}
+
+// Add stores e, evicting the oldest entry once the buffer is full.
+func (r *Recorder) Add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.full {
+		old := r.entries[r.next].RequestID
+		if idx, ok := r.byID[old]; ok && idx == r.next {
+			delete(r.byID, old)
+		}
+	}
+
+	r.entries[r.next] = e
+	r.byID[e.RequestID] = r.next
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Get returns the entry recorded for requestID.
+func (r *Recorder) Get(requestID string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, ok := r.byID[requestID]
+	if !ok {
+		return Entry{}, false
+	}
+	return r.entries[idx], true
+}
+
+// Filter selects entries in Query. Zero values match everything.
+type Filter struct {
+	Route       string
+	MinDuration time.Duration
+	Limit       int
+}
+
+// Query returns matching entries, newest first.
+func (r *Recorder) Query(f Filter) []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.entries)
+	}
+
+	minMs := float64(f.MinDuration) / float64(time.Millisecond)
+	result := []Entry{}
+	for i := 1; i <= n; i++ {
+		e := r.entries[(r.next-i+len(r.entries))%len(r.entries)]
+		if f.Route != "" && e.Route != f.Route {
+			continue
+		}
+		if e.Duration < minMs {
+			continue
+		}
+		result = append(result, e)
+		if f.Limit > 0 && len(result) == f.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// Millis converts d to fractional milliseconds as used in Entry.
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,66 @@
+package flightrecorder
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecorder_EvictsOldest(t *testing.T) {
+	r := New(3)
+	for i := 0; i < 5; i++ {
+		r.Add(Entry{RequestID: fmt.Sprintf("req-%d", i), Route: "api", Duration: float64(i)})
+	}
+
+	for _, id := range []string{"req-0", "req-1"} {
+		if _, ok := r.Get(id); ok {
+			t.Errorf("%s should have been evicted", id)
+		}
+	}
+	for _, id := range []string{"req-2", "req-3", "req-4"} {
+		if _, ok := r.Get(id); !ok {
+			t.Errorf("%s should still be recorded", id)
+		}
+	}
+
+	entries := r.Query(Filter{})
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(entries))
+	}
+	if entries[0].RequestID != "req-4" || entries[2].RequestID != "req-2" {
+		t.Errorf("entries not newest first: %s .. %s", entries[0].RequestID, entries[2].RequestID)
+	}
+}
+
+func TestRecorder_Query(t *testing.T) {
+	r := New(10)
+	r.Add(Entry{RequestID: "a", Route: "users", Duration: 50})
+	r.Add(Entry{RequestID: "b", Route: "users", Duration: 800})
+	r.Add(Entry{RequestID: "c", Route: "orders", Duration: 900})
+	r.Add(Entry{RequestID: "d", Route: "users", Duration: 1200})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"d", "c", "b", "a"}},
+		{"route", Filter{Route: "users"}, []string{"d", "b", "a"}},
+		{"min duration", Filter{Route: "users", MinDuration: 500 * time.Millisecond}, []string{"d", "b"}},
+		{"limit", Filter{Limit: 1}, []string{"d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Query(tt.filter)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, id := range tt.want {
+				if got[i].RequestID != id {
+					t.Errorf("entry %d = %s, want %s", i, got[i].RequestID, id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+// Package framing defends against request smuggling by checking how each
+// HTTP/1 request on a plaintext connection delimits its body. It reads the
+// raw bytes, before net/http normalizes them: by the time a handler runs,
+// a Content-Length sent next to Transfer-Encoding has been dropped and
+// folded header lines have been joined, so neither can be seen there.
+package framing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// Violations a request head can have.
+const (
+	// TEAndCL is a request with both Transfer-Encoding and Content-Length.
+	TEAndCL = "te_and_cl"
+	// ConflictingCL is a request with differing Content-Length values.
+	ConflictingCL = "conflicting_content_length"
+	// InvalidCL is a Content-Length that is not a decimal number.
+	InvalidCL = "invalid_content_length"
+	// ObsFold is a header line continued on the next line (RFC 9112
+	// section 5.2).
+	ObsFold = "obs_fold"
+	// TransferEncoding is a Transfer-Encoding other than a single chunked.
+	TransferEncoding = "transfer_encoding"
+	// HeaderWhitespace is whitespace between a header name and its colon.
+	HeaderWhitespace = "header_whitespace"
+)
+
+// maxHeadSize bounds the bytes buffered for one request head. It matches
+// http.DefaultMaxHeaderBytes plus the slack net/http allows; larger heads
+// are rejected by net/http, so scanning simply stops.
+const maxHeadSize = http.DefaultMaxHeaderBytes + 4096
+
+// Guard checks the framing of requests arriving through its listener.
+// Requests with a violation are logged and counted; unless the guard only
+// reports, they are answered with 400 and the connection is closed.
+type Guard struct {
+	reportOnly bool
+	metrics    *metrics.Metrics
+	logger     *slog.Logger
+}
+
+// New returns a guard. With reportOnly violations are logged and counted
+// but the requests are served.
+func New(reportOnly bool, m *metrics.Metrics, logger *slog.Logger) *Guard {
+	return &Guard{reportOnly: reportOnly, metrics: m, logger: logger}
+}
+
+// Listener wraps ln so the framing of every connection it accepts is
+// checked. It must be the listener the server reads requests from
+// directly; connections under TLS cannot be checked.
+func (g *Guard) Listener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, guard: g}
+}
+
+type contextKey struct{}
+
+// Install wraps server's handler so each request is matched with the
+// verdict on its framing. The server must serve a listener from Listener.
+func (g *Guard) Install(server *http.Server) {
+	prevConnContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if prevConnContext != nil {
+			ctx = prevConnContext(ctx, c)
+		}
+		if gc, ok := c.(*conn); ok {
+			return context.WithValue(ctx, contextKey{}, gc.scanner)
+		}
+		return ctx
+	}
+	server.Handler = g.handler(server.Handler)
+}
+
+func (g *Guard) handler(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := r.Context().Value(contextKey{}).(*scanner)
+		if !ok || r.ProtoMajor != 1 || len(s.next()) == 0 || g.reportOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The body cannot be told apart from a smuggled request, so nothing
+		// more is read from the connection.
+		w.Header().Set("Connection", "close")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{"code": http.StatusBadRequest, "message": "malformed request framing"},
+		})
+	})
+}
+
+// report logs and counts the violations of one request head.
+func (g *Guard) report(remoteAddr string, requestLine []byte, violations []string) {
+	for _, v := range violations {
+		g.metrics.RecordFramingViolation(v)
+	}
+	action := "rejected"
+	if g.reportOnly {
+		action = "reported"
+	}
+	g.logger.Warn("ambiguous request framing",
+		"security", "request_smuggling",
+		"violations", violations,
+		"action", action,
+		"remote_addr", remoteAddr,
+		"request_line", string(requestLine),
+	)
+}
+
+type listener struct {
+	net.Listener
+	guard *Guard
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, scanner: &scanner{guard: l.guard, remoteAddr: c.RemoteAddr().String()}}, nil
+}
+
+// conn feeds everything read from the connection to its scanner.
+type conn struct {
+	net.Conn
+	scanner *scanner
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.scanner.feed(p[:n])
+	}
+	return n, err
+}
+
+// NetConn returns the connection c wraps, like tls.Conn.NetConn.
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type scanState int
+
+const (
+	stateHead      scanState = iota
+	stateBody                // skipping a Content-Length body
+	stateChunkSize           // reading a chunk-size line
+	stateChunkData           // skipping chunk data
+	stateChunkEnd            // reading the CRLF after chunk data
+	stateTrailer             // reading trailer lines
+	stateOpaque              // no longer HTTP/1 requests, or unparseable
+)
+
+// scanner follows the HTTP/1 message boundaries of one connection's byte
+// stream. It records a verdict for every request head it completes, in
+// order, and the handler takes them in the same order as net/http parses
+// the requests. net/http reads a head in full before calling the handler,
+// so its verdict is always queued by then.
+type scanner struct {
+	guard      *Guard
+	remoteAddr string
+
+	// Only touched by Read, which net/http never calls concurrently.
+	state     scanState
+	line      []byte
+	head      [][]byte
+	headSize  int
+	remaining int64
+
+	mu       sync.Mutex
+	verdicts [][]string
+}
+
+// next returns the violations of the oldest request not yet handled.
+func (s *scanner) next() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.verdicts) == 0 {
+		return nil
+	}
+	v := s.verdicts[0]
+	s.verdicts = s.verdicts[1:]
+	return v
+}
+
+func (s *scanner) feed(p []byte) {
+	for len(p) > 0 {
+		switch s.state {
+		case stateOpaque:
+			return
+		case stateBody, stateChunkData:
+			n := int64(len(p))
+			if n > s.remaining {
+				n = s.remaining
+			}
+			s.remaining -= n
+			p = p[n:]
+			if s.remaining == 0 {
+				if s.state == stateBody {
+					s.state = stateHead
+				} else {
+					s.state = stateChunkEnd
+				}
+			}
+		default:
+			line, rest, ok := s.readLine(p)
+			p = rest
+			if ok {
+				s.handleLine(line)
+			}
+		}
+	}
+}
+
+// readLine appends p up to the next LF to the pending line. It returns the
+// complete line without its line ending once there is one.
+func (s *scanner) readLine(p []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(p, '\n')
+	if i < 0 {
+		s.appendLine(p)
+		return nil, nil, false
+	}
+	s.appendLine(p[:i])
+	line = bytes.TrimSuffix(s.line, []byte("\r"))
+	s.line = s.line[:0]
+	return line, p[i+1:], s.state != stateOpaque
+}
+
+func (s *scanner) appendLine(p []byte) {
+	if len(s.line)+len(p) > maxHeadSize {
+		s.stop()
+		return
+	}
+	s.line = append(s.line, p...)
+}
+
+// stop gives up on the connection: it is no longer HTTP/1, or it is
+// malformed in a way net/http rejects on its own.
+func (s *scanner) stop() {
+	s.state = stateOpaque
+	s.line = nil
+	s.head = nil
+}
+
+func (s *scanner) handleLine(line []byte) {
+	switch s.state {
+	case stateHead:
+		if len(line) > 0 {
+			s.headSize += len(line)
+			if s.headSize > maxHeadSize {
+				s.stop()
+				return
+			}
+			s.head = append(s.head, bytes.Clone(line))
+			return
+		}
+		if len(s.head) > 0 {
+			s.endHead()
+		}
+	case stateChunkSize:
+		size, ok := parseChunkSize(line)
+		switch {
+		case !ok:
+			s.stop()
+		case size == 0:
+			s.state = stateTrailer
+		default:
+			s.state = stateChunkData
+			s.remaining = size
+		}
+	case stateChunkEnd:
+		if len(line) > 0 {
+			s.stop()
+			return
+		}
+		s.state = stateChunkSize
+	case stateTrailer:
+		if len(line) == 0 {
+			s.state = stateHead
+		}
+	}
+}
+
+// endHead checks a complete request head and sets up skipping its body.
+func (s *scanner) endHead() {
+	head := s.head
+	s.head, s.headSize = nil, 0
+
+	requestLine := head[0]
+	if bytes.HasPrefix(requestLine, []byte("PRI * HTTP/2")) {
+		// Cleartext HTTP/2 with prior knowledge.
+		s.stop()
+		return
+	}
+	http10 := bytes.HasSuffix(requestLine, []byte("HTTP/1.0"))
+	connect := bytes.HasPrefix(requestLine, []byte("CONNECT "))
+
+	var violations, lengths, encodings []string
+	var upgrade bool
+	for _, line := range head[1:] {
+		if line[0] == ' ' || line[0] == '\t' {
+			violations = appendOnce(violations, ObsFold)
+			continue
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+		name := string(line[:colon])
+		if trimmed := strings.TrimRight(name, " \t"); trimmed != name {
+			violations = appendOnce(violations, HeaderWhitespace)
+			name = trimmed
+		}
+		value := strings.Trim(string(line[colon+1:]), " \t")
+		switch {
+		case strings.EqualFold(name, "Content-Length"):
+			lengths = append(lengths, strings.Split(value, ",")...)
+		case strings.EqualFold(name, "Transfer-Encoding"):
+			encodings = append(encodings, value)
+		case strings.EqualFold(name, "Upgrade"):
+			upgrade = true
+		}
+	}
+
+	length := int64(-1)
+	for _, v := range lengths {
+		n, err := strconv.ParseInt(strings.Trim(v, " \t"), 10, 64)
+		switch {
+		case err != nil || n < 0 || strings.ContainsAny(strings.Trim(v, " \t"), "+-"):
+			violations = appendOnce(violations, InvalidCL)
+		case length >= 0 && n != length:
+			violations = appendOnce(violations, ConflictingCL)
+		default:
+			length = n
+		}
+	}
+	if len(encodings) > 0 {
+		if len(lengths) > 0 {
+			violations = append(violations, TEAndCL)
+		}
+		if len(encodings) > 1 || !strings.EqualFold(encodings[0], "chunked") {
+			violations = append(violations, TransferEncoding)
+		}
+	}
+
+	s.mu.Lock()
+	s.verdicts = append(s.verdicts, violations)
+	s.mu.Unlock()
+	if len(violations) > 0 {
+		s.guard.report(s.remoteAddr, requestLine, violations)
+	}
+
+	// Follow the body the way net/http will, which ignores
+	// Transfer-Encoding on HTTP/1.0 requests.
+	switch {
+	case upgrade || connect:
+		// The connection may switch protocols after this request.
+		s.stop()
+	case len(encodings) > 0 && !http10:
+		if len(encodings) > 1 || !strings.EqualFold(encodings[0], "chunked") {
+			s.stop()
+			return
+		}
+		s.state = stateChunkSize
+	case slices.Contains(violations, InvalidCL) || slices.Contains(violations, ConflictingCL):
+		s.stop()
+	case length > 0:
+		s.state = stateBody
+		s.remaining = length
+	}
+}
+
+// parseChunkSize parses the hex size of a chunk-size line, ignoring chunk
+// extensions.
+func parseChunkSize(line []byte) (int64, bool) {
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 16 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(line), 16, 63)
+	if err != nil {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+func appendOnce(list []string, v string) []string {
+	if slices.Contains(list, v) {
+		return list
+	}
+	return append(list, v)
+}
@@ -0,0 +1,217 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+type guardedServer struct {
+	addr    string
+	metrics *metrics.Metrics
+	logs    *syncBuffer
+
+	mu      sync.Mutex
+	handled []string // paths that reached the handler
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newGuardedServer(t *testing.T, reportOnly bool) *guardedServer {
+	t.Helper()
+	gs := &guardedServer{metrics: metrics.New(metrics.Config{}), logs: &syncBuffer{}}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		gs.mu.Lock()
+		gs.handled = append(gs.handled, r.URL.Path)
+		gs.mu.Unlock()
+		_, _ = io.WriteString(w, "ok")
+	}))
+	g := New(reportOnly, gs.metrics, slog.New(slog.NewJSONHandler(gs.logs, nil)))
+	srv.Listener = g.Listener(srv.Listener)
+	g.Install(srv.Config)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	gs.addr = srv.Listener.Addr().String()
+	return gs
+}
+
+// send writes raw to a new connection and reads up to n responses.
+func (gs *guardedServer) send(t *testing.T, raw string, n int) []int {
+	t.Helper()
+	conn, err := net.Dial("tcp", gs.addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var statuses []int
+	br := bufio.NewReader(conn)
+	for range n {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			break
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+	return statuses
+}
+
+func (gs *guardedServer) handledPaths() []string {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return append([]string(nil), gs.handled...)
+}
+
+func (gs *guardedServer) counted(t *testing.T, violation string) bool {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	gs.metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return strings.Contains(rec.Body.String(), `gateway_request_framing_violations_total{violation="`+violation+`"} 1`)
+}
+
+func TestGuard_RejectsAmbiguousFraming(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		status    int
+		violation string
+	}{
+		{
+			name: "te and cl",
+			raw: "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"0\r\n\r\n",
+			status:    http.StatusBadRequest,
+			violation: TEAndCL,
+		},
+		{
+			name:      "obs-fold",
+			raw:       "GET /a HTTP/1.1\r\nHost: x\r\nX-Note: first\r\n second\r\n\r\n",
+			status:    http.StatusBadRequest,
+			violation: ObsFold,
+		},
+		{
+			// net/http rejects these itself; the guard still reports them.
+			name:      "conflicting content-length",
+			raw:       "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\nabcd",
+			status:    http.StatusBadRequest,
+			violation: ConflictingCL,
+		},
+		{
+			name:      "transfer-encoding other than chunked",
+			raw:       "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			status:    http.StatusNotImplemented,
+			violation: TransferEncoding,
+		},
+		{
+			name:      "whitespace before colon",
+			raw:       "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding : chunked\r\nContent-Length: 3\r\n\r\nabc",
+			status:    http.StatusBadRequest,
+			violation: HeaderWhitespace,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newGuardedServer(t, false)
+			statuses := gs.send(t, tt.raw, 2)
+			if len(statuses) != 1 || statuses[0] != tt.status {
+				t.Errorf("statuses = %v, want [%d]", statuses, tt.status)
+			}
+			if paths := gs.handledPaths(); len(paths) != 0 {
+				t.Errorf("request reached the handler: %v", paths)
+			}
+			if !gs.counted(t, tt.violation) {
+				t.Errorf("%s was not counted", tt.violation)
+			}
+			if logs := gs.logs.String(); !strings.Contains(logs, `"security":"request_smuggling"`) || !strings.Contains(logs, tt.violation) {
+				t.Errorf("violation not logged with the security marker:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestGuard_FollowsBodiesAcrossPipelinedRequests(t *testing.T) {
+	gs := newGuardedServer(t, false)
+	// The bodies look like request heads with violations; only the third
+	// request has one.
+	body := "GET /hidden HTTP/1.1\r\nX: a\r\n b\r\n\r\n"
+	raw := "POST /chunked HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		strconv.FormatInt(int64(len(body)), 16) + ";ext=1\r\n" + body + "\r\n0\r\nX-Trailer: t\r\n\r\n" +
+		"POST /length HTTP/1.1\r\nHost: x\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body +
+		"GET /folded HTTP/1.1\r\nHost: x\r\nX: a\r\n\tb\r\n\r\n" +
+		"GET /never HTTP/1.1\r\nHost: x\r\n\r\n"
+
+	statuses := gs.send(t, raw, 4)
+	want := []int{http.StatusOK, http.StatusOK, http.StatusBadRequest}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+	if paths := gs.handledPaths(); strings.Join(paths, ",") != "/chunked,/length" {
+		t.Errorf("handled paths = %v, want [/chunked /length]", paths)
+	}
+}
+
+func TestGuard_ReportOnly(t *testing.T) {
+	gs := newGuardedServer(t, true)
+	statuses := gs.send(t, "GET /a HTTP/1.1\r\nHost: x\r\nX-Note: first\r\n second\r\n\r\n", 1)
+	if len(statuses) != 1 || statuses[0] != http.StatusOK {
+		t.Errorf("statuses = %v, want [200]", statuses)
+	}
+	if !gs.counted(t, ObsFold) {
+		t.Error("reported violation was not counted")
+	}
+	if !strings.Contains(gs.logs.String(), `"action":"reported"`) {
+		t.Errorf("violation not logged as reported:\n%s", gs.logs.String())
+	}
+}
+
+func TestGuard_AllowsCleanRequests(t *testing.T) {
+	gs := newGuardedServer(t, false)
+	raw := "POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /b HTTP/1.0\r\nHost: x\r\n\r\n"
+	statuses := gs.send(t, raw, 2)
+	if len(statuses) != 2 || statuses[0] != http.StatusOK || statuses[1] != http.StatusOK {
+		t.Errorf("statuses = %v, want [200 200]", statuses)
+	}
+	if logs := gs.logs.String(); logs != "" {
+		t.Errorf("clean requests were logged:\n%s", logs)
+	}
+}
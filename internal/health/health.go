@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
 	"github.com/relaypoint/relaypoint/internal/loadbalancer"
 	"github.com/relaypoint/relaypoint/internal/metrics"
 )
@@ -18,6 +19,8 @@ type Checker struct {
 	upstreams map[string]loadbalancer.LoadBalancer
 	configs   map[string]*config.HealthCheck
 	metrics   *metrics.Metrics
+	events    *events.Bus
+	onInitial func(upstream string)
 	client    *http.Client
 	stop      chan struct{}
 	wg        sync.WaitGroup
@@ -37,6 +40,17 @@ func NewChecker(upstreams map[string]loadbalancer.LoadBalancer, configs map[stri
 	}
 }
 
+// SetEvents publishes target health transitions to bus. Call before Start.
+func (c *Checker) SetEvents(bus *events.Bus) {
+	c.events = bus
+}
+
+// SetInitialCheck calls fn once per upstream when its first check cycle has
+// completed. Call before Start.
+func (c *Checker) SetInitialCheck(fn func(upstream string)) {
+	c.onInitial = fn
+}
+
 func (c *Checker) Start() {
 	for name, lb := range c.upstreams {
 		cfg := c.configs[name]
@@ -66,6 +80,9 @@ func (c *Checker) checkLoop(name string, lb loadbalancer.LoadBalancer, cfg *conf
 	defer ticker.Stop()
 
 	c.checkAll(name, lb, cfg) // Initial check
+	if c.onInitial != nil {
+		c.onInitial(name)
+	}
 
 	for {
 		select {
@@ -82,8 +99,17 @@ func (c *Checker) checkAll(name string, lb loadbalancer.LoadBalancer, cfg *confi
 
 	for _, target := range targets {
 		healthy := c.checkTarget(target, cfg)
+		was := target.Healthy.Load()
 		lb.MarkHealthy(target, healthy)
 
+		if was != healthy {
+			c.events.Publish(events.TargetHealth, map[string]any{
+				"upstream": name,
+				"target":   target.URL.String(),
+				"healthy":  healthy,
+			})
+		}
+
 		if c.metrics != nil {
 			c.metrics.RecordUpstreamHealth(name, target.URL.String(), healthy)
 		}
@@ -94,10 +120,17 @@ func (c *Checker) checkAll(name string, lb loadbalancer.LoadBalancer, cfg *confi
 	}
 }
 
+// checkTarget probes target. Its expected round trip is added to the
+// timeout, so a distant target is not failed for being far away.
 func (c *Checker) checkTarget(target *loadbalancer.Target, cfg *config.HealthCheck) bool {
 	url := target.URL.ResolveReference(&url.URL{Path: cfg.Path})
 
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	timeout += target.RTT()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
@@ -105,10 +138,12 @@ func (c *Checker) checkTarget(target *loadbalancer.Target, cfg *config.HealthChe
 		return false
 	}
 
+	start := time.Now()
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return false
 	}
+	target.ObserveRTT(time.Since(start))
 	defer func() {
 		err := resp.Body.Close()
 		if err != nil {
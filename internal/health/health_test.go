@@ -0,0 +1,58 @@
+package health
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+)
+
+// distantBackend answers health checks after latency, like a target in
+// another region.
+func distantBackend(t *testing.T, latency time.Duration) *loadbalancer.Target {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return &loadbalancer.Target{URL: u}
+}
+
+func TestCheckTarget_ScalesTimeoutByRTT(t *testing.T) {
+	c := NewChecker(nil, nil, nil, slog.Default())
+	cfg := &config.HealthCheck{Path: "/health", Timeout: 50 * time.Millisecond}
+
+	target := distantBackend(t, 100*time.Millisecond)
+	if c.checkTarget(target, cfg) {
+		t.Error("target slower than the timeout was healthy")
+	}
+	target.ExpectedRTT = 100 * time.Millisecond
+	if !c.checkTarget(target, cfg) {
+		t.Error("target within timeout plus expected_rtt was unhealthy")
+	}
+	if rtt := target.MeasuredRTT(); rtt < 100*time.Millisecond {
+		t.Errorf("MeasuredRTT = %v, want at least the injected 100ms", rtt)
+	}
+}
+
+func TestCheckTarget_AutoRTT(t *testing.T) {
+	c := NewChecker(nil, nil, nil, slog.Default())
+	cfg := &config.HealthCheck{Path: "/health", Timeout: 50 * time.Millisecond}
+
+	target := distantBackend(t, 40*time.Millisecond)
+	target.AutoRTT = true
+	if !c.checkTarget(target, cfg) {
+		t.Fatal("first check failed")
+	}
+	// The measured round trip now extends the timeout past the latency.
+	target.URL = distantBackend(t, 70*time.Millisecond).URL
+	if !c.checkTarget(target, cfg) {
+		t.Error("target within timeout plus measured RTT was unhealthy")
+	}
+}
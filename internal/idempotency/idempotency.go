@@ -0,0 +1,142 @@
+// Package idempotency keeps the responses of requests sent with an
+// Idempotency-Key header, so a retry of a completed request can be answered
+// with the first response instead of being processed again.
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Response is a completed response stored under an idempotency key.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Request identifies the request that produced the response, so a key
+	// reused for a different request can be told from a retry.
+	Request string
+	Stored  time.Time
+}
+
+// Store keeps responses by key until their TTL passes. MemoryStore is the
+// only implementation so far; a shared store, such as Redis, would let
+// replicas replay each other's responses.
+type Store interface {
+	// Get returns the response stored under key, if it has not expired.
+	Get(key string) (*Response, bool)
+	// Put stores resp under key for ttl, replacing any response there.
+	Put(key string, resp *Response, ttl time.Duration)
+}
+
+// MemoryStore is a Store in process memory. It holds at most maxEntries
+// responses, dropping the oldest first.
+type MemoryStore struct {
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *memoryEntry, oldest first
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	resp    *Response
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore for up to maxEntries
+// responses.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if !s.now().Before(e.expires) {
+		s.remove(el)
+		return nil, false
+	}
+	return e.resp, true
+}
+
+func (s *MemoryStore) Put(key string, resp *Response, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	for s.order.Len() >= s.maxEntries {
+		s.remove(s.order.Front())
+	}
+	s.entries[key] = s.order.PushBack(&memoryEntry{key: key, resp: resp, expires: s.now().Add(ttl)})
+}
+
+// Len returns the number of responses held, expired ones included until
+// they are looked up or make room for others.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	delete(s.entries, s.order.Remove(el).(*memoryEntry).key)
+}
+
+// Locks lets one request at a time in the process hold a key, so that
+// concurrent requests with the same key wait for the first to finish
+// rather than all reaching the upstream. The zero value is ready to use.
+type Locks struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+// Lock waits until no other request holds key and takes it. It reports
+// whether it had to wait, and returns ctx's error if ctx ends first. The
+// caller must call unlock once its response is stored, or will not be.
+func (l *Locks) Lock(ctx context.Context, key string) (unlock func(), waited bool, err error) {
+	for {
+		l.mu.Lock()
+		released, busy := l.held[key]
+		if !busy {
+			if l.held == nil {
+				l.held = make(map[string]chan struct{})
+			}
+			released = make(chan struct{})
+			l.held[key] = released
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				delete(l.held, key)
+				l.mu.Unlock()
+				close(released)
+			}, waited, nil
+		}
+		l.mu.Unlock()
+
+		waited = true
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+}
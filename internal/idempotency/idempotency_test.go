@@ -0,0 +1,90 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(2)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	s.Put("a", &Response{Status: 201}, time.Minute)
+	s.Put("b", &Response{Status: 202}, time.Hour)
+	if resp, ok := s.Get("a"); !ok || resp.Status != 201 {
+		t.Fatalf("Get(a) = %+v, %v", resp, ok)
+	}
+
+	// A third response drops the oldest.
+	s.Put("c", &Response{Status: 203}, time.Hour)
+	if _, ok := s.Get("a"); ok {
+		t.Error("a was kept beyond max entries")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", s.Len())
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := s.Get("b"); ok {
+		t.Error("b was returned after its TTL")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Len() = %d after an expired lookup, want 1", s.Len())
+	}
+	s.Put("c", &Response{Status: 204}, time.Hour)
+	if resp, ok := s.Get("c"); !ok || resp.Status != 204 {
+		t.Errorf("Get(c) after replacing = %+v, %v", resp, ok)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	var locks Locks
+	ctx := context.Background()
+	unlock, waited, err := locks.Lock(ctx, "k")
+	if err != nil || waited {
+		t.Fatalf("first Lock: waited = %v, err = %v", waited, err)
+	}
+	other, _, err := locks.Lock(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	var holders, maxHolders atomic.Int32
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, waited, err := locks.Lock(ctx, "k")
+			if err != nil || !waited {
+				t.Errorf("waiting Lock: waited = %v, err = %v", waited, err)
+				return
+			}
+			if n := holders.Add(1); n > maxHolders.Load() {
+				maxHolders.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	unlock()
+	wg.Wait()
+	if maxHolders.Load() != 1 {
+		t.Errorf("%d requests held the key at once", maxHolders.Load())
+	}
+
+	unlock, _, _ = locks.Lock(ctx, "k")
+	defer unlock()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := locks.Lock(canceled, "k"); err != context.Canceled {
+		t.Errorf("Lock with a canceled context = %v, want context.Canceled", err)
+	}
+}
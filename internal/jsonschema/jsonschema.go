@@ -0,0 +1,652 @@
+// Package jsonschema validates decoded JSON documents against a JSON
+// Schema. It implements the validation keywords of draft 2020-12 that
+// request schemas commonly use; a schema using any other keyword is
+// rejected when it is compiled, so no constraint is silently ignored.
+// Regular expressions use Go's RE2 syntax.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxViolations bounds how many violations Validate reports, and with it
+// the work spent on a badly wrong document.
+const maxViolations = 20
+
+// maxDepth bounds how deeply schemas nest while validating, which only
+// references looping back without descending into the document reach.
+const maxDepth = 512
+
+// Violation is one way a document fails its schema.
+type Violation struct {
+	Path    string `json:"path"`    // JSON pointer into the document, "" for the root
+	Keyword string `json:"keyword"` // schema keyword that failed, e.g. required
+	Message string `json:"message"`
+}
+
+// Schema is a compiled schema. It is immutable and safe for concurrent use.
+type Schema struct {
+	root *node
+}
+
+// annotations are keywords that do not constrain a document.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "$anchor": true,
+	"title": true, "description": true, "default": true, "examples": true,
+	"deprecated": true, "readOnly": true, "writeOnly": true,
+	"format": true, "contentMediaType": true, "contentEncoding": true,
+}
+
+var typeNames = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+type node struct {
+	always *bool // set for the boolean schemas true and false
+	ref    *node
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	properties        map[string]*node
+	propertyNames     []string // sorted, for a stable violation order
+	patternProperties []patternProperty
+	additional        *node
+	required          []string
+	minProperties     *int
+	maxProperties     *int
+
+	prefixItems []*node
+	items       *node
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*node
+	anyOf []*node
+	oneOf []*node
+	not   *node
+}
+
+type patternProperty struct {
+	re     *regexp.Regexp
+	schema *node
+}
+
+// CompileFile reads and compiles the schema in the file at path.
+func CompileFile(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(data)
+}
+
+// Compile compiles a schema document.
+func Compile(data []byte) (*Schema, error) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	c := &compiler{doc: doc, nodes: make(map[string]*node)}
+	root, err := c.compile(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+type compiler struct {
+	doc   any
+	nodes map[string]*node // by JSON pointer into doc, so $ref cycles resolve
+}
+
+func (c *compiler) compile(v any, ptr string) (*node, error) {
+	if n, ok := c.nodes[ptr]; ok {
+		return n, nil
+	}
+	n := &node{}
+	c.nodes[ptr] = n
+
+	if b, ok := v.(bool); ok {
+		n.always = &b
+		return n, nil
+	}
+	s, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", at(ptr))
+	}
+
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := c.keyword(n, k, s[k], ptr+"/"+escape(k)); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (c *compiler) keyword(n *node, k string, v any, ptr string) error {
+	var err error
+	switch k {
+	case "$defs", "definitions":
+		defs, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", at(ptr))
+		}
+		for name, def := range defs {
+			if _, err := c.compile(def, ptr+"/"+escape(name)); err != nil {
+				return err
+			}
+		}
+	case "$ref":
+		ref, ok := v.(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return fmt.Errorf("%s: only references within the schema (#...) are supported", at(ptr))
+		}
+		target, ok := resolve(c.doc, ref[1:])
+		if !ok {
+			return fmt.Errorf("%s: reference %q not found", at(ptr), ref)
+		}
+		n.ref, err = c.compile(target, ref[1:])
+	case "type":
+		n.types, err = typeList(v, ptr)
+	case "enum":
+		values, ok := v.([]any)
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("%s: must be a non-empty array", at(ptr))
+		}
+		n.enum = values
+	case "const":
+		n.constant, n.hasConst = v, true
+	case "properties":
+		props, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", at(ptr))
+		}
+		n.properties = make(map[string]*node, len(props))
+		for name, sub := range props {
+			if n.properties[name], err = c.compile(sub, ptr+"/"+escape(name)); err != nil {
+				return err
+			}
+			n.propertyNames = append(n.propertyNames, name)
+		}
+		sort.Strings(n.propertyNames)
+	case "patternProperties":
+		props, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: must be an object", at(ptr))
+		}
+		patterns := make([]string, 0, len(props))
+		for p := range props {
+			patterns = append(patterns, p)
+		}
+		sort.Strings(patterns)
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("%s: %w", at(ptr), err)
+			}
+			sub, err := c.compile(props[p], ptr+"/"+escape(p))
+			if err != nil {
+				return err
+			}
+			n.patternProperties = append(n.patternProperties, patternProperty{re: re, schema: sub})
+		}
+	case "additionalProperties":
+		n.additional, err = c.compile(v, ptr)
+	case "required":
+		n.required, err = stringList(v, ptr)
+	case "minProperties":
+		n.minProperties, err = count(v, ptr)
+	case "maxProperties":
+		n.maxProperties, err = count(v, ptr)
+	case "prefixItems":
+		n.prefixItems, err = c.schemaList(v, ptr)
+	case "items":
+		if _, ok := v.([]any); ok {
+			return fmt.Errorf("%s: use prefixItems for tuples", at(ptr))
+		}
+		n.items, err = c.compile(v, ptr)
+	case "minItems":
+		n.minItems, err = count(v, ptr)
+	case "maxItems":
+		n.maxItems, err = count(v, ptr)
+	case "uniqueItems":
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("%s: must be a boolean", at(ptr))
+		}
+		n.uniqueItems = b
+	case "minLength":
+		n.minLength, err = count(v, ptr)
+	case "maxLength":
+		n.maxLength, err = count(v, ptr)
+	case "pattern":
+		p, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", at(ptr))
+		}
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("%s: %w", at(ptr), err)
+		}
+	case "minimum":
+		n.minimum, err = number(v, ptr)
+	case "maximum":
+		n.maximum, err = number(v, ptr)
+	case "exclusiveMinimum":
+		n.exclusiveMinimum, err = number(v, ptr)
+	case "exclusiveMaximum":
+		n.exclusiveMaximum, err = number(v, ptr)
+	case "multipleOf":
+		if n.multipleOf, err = number(v, ptr); err == nil && *n.multipleOf <= 0 {
+			return fmt.Errorf("%s: must be greater than 0", at(ptr))
+		}
+	case "allOf":
+		n.allOf, err = c.schemaList(v, ptr)
+	case "anyOf":
+		n.anyOf, err = c.schemaList(v, ptr)
+	case "oneOf":
+		n.oneOf, err = c.schemaList(v, ptr)
+	case "not":
+		n.not, err = c.compile(v, ptr)
+	default:
+		if !annotations[k] {
+			return fmt.Errorf("%s: unsupported keyword %q", at(ptr), k)
+		}
+	}
+	return err
+}
+
+func (c *compiler) schemaList(v any, ptr string) ([]*node, error) {
+	list, ok := v.([]any)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: must be a non-empty array of schemas", at(ptr))
+	}
+	nodes := make([]*node, len(list))
+	for i, sub := range list {
+		var err error
+		if nodes[i], err = c.compile(sub, ptr+"/"+strconv.Itoa(i)); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
+func typeList(v any, ptr string) ([]string, error) {
+	if s, ok := v.(string); ok {
+		v = []any{s}
+	}
+	names, err := stringList(v, ptr)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !typeNames[name] {
+			return nil, fmt.Errorf("%s: unknown type %q", at(ptr), name)
+		}
+	}
+	return names, nil
+}
+
+func stringList(v any, ptr string) ([]string, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of strings", at(ptr))
+	}
+	out := make([]string, len(list))
+	for i, item := range list {
+		if out[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("%s: must be an array of strings", at(ptr))
+		}
+	}
+	return out, nil
+}
+
+func count(v any, ptr string) (*int, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", at(ptr))
+	}
+	n := int(f)
+	return &n, nil
+}
+
+func number(v any, ptr string) (*float64, error) {
+	f, ok := v.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a number", at(ptr))
+	}
+	return &f, nil
+}
+
+// at names a schema location in compile errors.
+func at(ptr string) string {
+	return "schema #" + ptr
+}
+
+// escape encodes a JSON pointer reference token.
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// resolve returns the value at the JSON pointer ptr in doc.
+func resolve(doc any, ptr string) (any, bool) {
+	if ptr == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, false
+	}
+	cur := doc
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[tok]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []any:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// Validate checks doc, as decoded by encoding/json into an any, and returns
+// at most 20 violations. It returns nil when doc is valid.
+func (s *Schema) Validate(doc any) []Violation {
+	c := &collector{limit: maxViolations}
+	s.root.validate(doc, "", c)
+	return c.out
+}
+
+type collector struct {
+	out   []Violation
+	limit int
+	depth int
+}
+
+func (c *collector) full() bool {
+	return len(c.out) >= c.limit
+}
+
+func (c *collector) add(path, keyword, format string, args ...any) {
+	if !c.full() {
+		c.out = append(c.out, Violation{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// matches reports whether v is valid against n, stopping at the first
+// violation.
+func (n *node) matches(v any, path string, depth int) bool {
+	c := &collector{limit: 1, depth: depth}
+	n.validate(v, path, c)
+	return len(c.out) == 0
+}
+
+func (n *node) validate(v any, path string, c *collector) {
+	if c.full() {
+		return
+	}
+	c.depth++
+	defer func() { c.depth-- }()
+	if c.depth > maxDepth {
+		c.add(path, "$ref", "schema nests too deeply")
+		return
+	}
+	if n.always != nil {
+		if !*n.always {
+			c.add(path, "false", "is not allowed")
+		}
+		return
+	}
+	if n.ref != nil {
+		n.ref.validate(v, path, c)
+	}
+
+	if len(n.types) > 0 && !hasType(v, n.types) {
+		c.add(path, "type", "must be %s, got %s", strings.Join(n.types, " or "), typeOf(v))
+		return
+	}
+	if n.enum != nil && !contains(n.enum, v) {
+		c.add(path, "enum", "must be one of %s", encode(n.enum))
+	}
+	if n.hasConst && !equal(n.constant, v) {
+		c.add(path, "const", "must be %s", encode(n.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		n.validateObject(v, path, c)
+	case []any:
+		n.validateArray(v, path, c)
+	case string:
+		n.validateString(v, path, c)
+	case float64:
+		n.validateNumber(v, path, c)
+	}
+
+	for _, sub := range n.allOf {
+		sub.validate(v, path, c)
+	}
+	if n.anyOf != nil {
+		matched := false
+		for _, sub := range n.anyOf {
+			if sub.matches(v, path, c.depth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			c.add(path, "anyOf", "must match at least one of the allowed schemas")
+		}
+	}
+	if n.oneOf != nil {
+		matched := 0
+		for _, sub := range n.oneOf {
+			if sub.matches(v, path, c.depth) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			c.add(path, "oneOf", "must match exactly one of the allowed schemas, matched %d", matched)
+		}
+	}
+	if n.not != nil && n.not.matches(v, path, c.depth) {
+		c.add(path, "not", "must not match the disallowed schema")
+	}
+}
+
+func (n *node) validateObject(obj map[string]any, path string, c *collector) {
+	for _, name := range n.required {
+		if _, ok := obj[name]; !ok {
+			c.add(path+"/"+escape(name), "required", "is required")
+		}
+	}
+	if n.minProperties != nil && len(obj) < *n.minProperties {
+		c.add(path, "minProperties", "must have at least %d properties", *n.minProperties)
+	}
+	if n.maxProperties != nil && len(obj) > *n.maxProperties {
+		c.add(path, "maxProperties", "must have at most %d properties", *n.maxProperties)
+	}
+
+	for _, name := range n.propertyNames {
+		if value, ok := obj[name]; ok {
+			n.properties[name].validate(value, path+"/"+escape(name), c)
+		}
+	}
+	if n.patternProperties == nil && n.additional == nil {
+		return
+	}
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, declared := n.properties[name]
+		for _, pp := range n.patternProperties {
+			if pp.re.MatchString(name) {
+				declared = true
+				pp.schema.validate(obj[name], path+"/"+escape(name), c)
+			}
+		}
+		if !declared && n.additional != nil {
+			if n.additional.always != nil && !*n.additional.always {
+				c.add(path+"/"+escape(name), "additionalProperties", "is not allowed")
+				continue
+			}
+			n.additional.validate(obj[name], path+"/"+escape(name), c)
+		}
+	}
+}
+
+func (n *node) validateArray(arr []any, path string, c *collector) {
+	if n.minItems != nil && len(arr) < *n.minItems {
+		c.add(path, "minItems", "must have at least %d items", *n.minItems)
+	}
+	if n.maxItems != nil && len(arr) > *n.maxItems {
+		c.add(path, "maxItems", "must have at most %d items", *n.maxItems)
+	}
+	for i, item := range arr {
+		itemPath := path + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(n.prefixItems):
+			n.prefixItems[i].validate(item, itemPath, c)
+		case n.items != nil:
+			n.items.validate(item, itemPath, c)
+		}
+	}
+	if n.uniqueItems {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if equal(arr[i], arr[j]) {
+					c.add(path, "uniqueItems", "items %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (n *node) validateString(s, path string, c *collector) {
+	length := utf8.RuneCountInString(s)
+	if n.minLength != nil && length < *n.minLength {
+		c.add(path, "minLength", "must be at least %d characters long", *n.minLength)
+	}
+	if n.maxLength != nil && length > *n.maxLength {
+		c.add(path, "maxLength", "must be at most %d characters long", *n.maxLength)
+	}
+	if n.pattern != nil && !n.pattern.MatchString(s) {
+		c.add(path, "pattern", "must match %s", n.pattern)
+	}
+}
+
+func (n *node) validateNumber(f float64, path string, c *collector) {
+	if n.minimum != nil && f < *n.minimum {
+		c.add(path, "minimum", "must be at least %v", *n.minimum)
+	}
+	if n.maximum != nil && f > *n.maximum {
+		c.add(path, "maximum", "must be at most %v", *n.maximum)
+	}
+	if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+		c.add(path, "exclusiveMinimum", "must be greater than %v", *n.exclusiveMinimum)
+	}
+	if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+		c.add(path, "exclusiveMaximum", "must be less than %v", *n.exclusiveMaximum)
+	}
+	if n.multipleOf != nil {
+		q := f / *n.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			c.add(path, "multipleOf", "must be a multiple of %v", *n.multipleOf)
+		}
+	}
+}
+
+func hasType(v any, types []string) bool {
+	got := typeOf(v)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON type of v, with integral numbers as integer.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func contains(values []any, v any) bool {
+	for _, candidate := range values {
+		if equal(candidate, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func encode(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
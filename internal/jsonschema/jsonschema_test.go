@@ -0,0 +1,117 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const userSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["email", "name"],
+	"additionalProperties": false,
+	"properties": {
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+		"name": {"type": "string", "minLength": 1, "maxLength": 20},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"role": {"enum": ["admin", "member"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 3, "uniqueItems": true},
+		"address": {"$ref": "#/$defs/address"}
+	},
+	"$defs": {
+		"address": {
+			"type": "object",
+			"required": ["city"],
+			"properties": {"city": {"type": "string"}, "zip": {"oneOf": [{"type": "string"}, {"type": "integer"}]}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(userSchema))
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want []string // path:keyword of each violation, in order
+	}{
+		{"valid", `{"email":"a@example.com","name":"Ann","age":30,"role":"admin","tags":["x"],"address":{"city":"Oslo","zip":1234}}`, nil},
+		{"not an object", `[]`, []string{":type"}},
+		{"missing required", `{"name":"Ann"}`, []string{"/email:required"}},
+		{"unknown property", `{"email":"a@b","name":"Ann","nickname":"A"}`, []string{"/nickname:additionalProperties"}},
+		{"wrong types", `{"email":1,"name":"Ann","age":1.5}`, []string{"/age:type", "/email:type"}},
+		{"bounds", `{"email":"a@b","name":"","age":150}`, []string{"/age:exclusiveMaximum", "/name:minLength"}},
+		{"pattern", `{"email":"nope","name":"Ann"}`, []string{"/email:pattern"}},
+		{"enum", `{"email":"a@b","name":"Ann","role":"owner"}`, []string{"/role:enum"}},
+		{"array", `{"email":"a@b","name":"Ann","tags":["a","a",3,"b"]}`, []string{"/tags:maxItems", "/tags/2:type", "/tags:uniqueItems"}},
+		{"ref", `{"email":"a@b","name":"Ann","address":{"zip":true}}`, []string{"/address/city:required", "/address/zip:oneOf"}},
+		{"multibyte length", `{"email":"a@b","name":"ÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅÅ"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, v := range schema.Validate(doc) {
+				got = append(got, v.Path+":"+v.Keyword)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_BoundsViolations(t *testing.T) {
+	schema, err := Compile([]byte(`{"type":"array","items":{"type":"string"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := make([]any, 100)
+	for i := range doc {
+		doc[i] = float64(i)
+	}
+	if got := len(schema.Validate(doc)); got != maxViolations {
+		t.Errorf("got %d violations, want %d", got, maxViolations)
+	}
+}
+
+func TestValidate_SelfReference(t *testing.T) {
+	schema, err := Compile([]byte(`{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := schema.Validate("x"); len(v) != 1 {
+		t.Errorf("violations = %v, want one for the reference loop", v)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name, schema, want string
+	}{
+		{"not json", `{`, "not valid JSON"},
+		{"not a schema", `[1]`, "must be an object or a boolean"},
+		{"unsupported keyword", `{"properties":{"a":{"if":{}}}}`, `schema #/properties/a/if: unsupported keyword "if"`},
+		{"unknown type", `{"type":"text"}`, `unknown type "text"`},
+		{"bad pattern", `{"pattern":"("}`, "schema #/pattern"},
+		{"negative length", `{"minLength":-1}`, "non-negative integer"},
+		{"remote ref", `{"$ref":"other.json#/a"}`, "only references within the schema"},
+		{"missing ref", `{"$ref":"#/$defs/nope"}`, "not found"},
+		{"tuple items", `{"items":[{}]}`, "use prefixItems"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile([]byte(tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Compile() = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Package jwt verifies JSON Web Tokens signed with ES256 or RS256 against
+// the keys of a JSON Web Key Set (RFC 7517). Other algorithms, including
+// none and the HMAC ones, are rejected.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Signing algorithms a token may use.
+const (
+	ES256 = "ES256"
+	RS256 = "RS256"
+)
+
+// minRSABits is the smallest RSA modulus accepted in a key set.
+const minRSABits = 2048
+
+var (
+	ErrMalformed  = errors.New("malformed token")
+	ErrAlgorithm  = errors.New("unsupported signing algorithm")
+	ErrUnknownKey = errors.New("no key in the key set matches the token")
+	ErrSignature  = errors.New("invalid signature")
+	ErrExpired    = errors.New("token expired")
+	ErrNotYet     = errors.New("token not valid yet")
+)
+
+// KeySet holds the signing keys of a JSON Web Key Set.
+type KeySet struct {
+	keys []key
+}
+
+type key struct {
+	id  string
+	alg string // ES256 or RS256
+	pub crypto.PublicKey
+}
+
+// ParseKeySet parses a JSON Web Key Set. Keys it cannot use, such as
+// encryption keys or curves other than P-256, are skipped; a set without
+// any usable key is an error.
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("key set: %w", err)
+	}
+	set := &KeySet{}
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var k key
+		var err error
+		switch {
+		case jwk.Kty == "EC" && jwk.Crv == "P-256" && (jwk.Alg == "" || jwk.Alg == ES256):
+			k.alg = ES256
+			k.pub, err = ecKey(jwk.X, jwk.Y)
+		case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == RS256):
+			k.alg = RS256
+			k.pub, err = rsaKey(jwk.N, jwk.E)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", jwk.Kid, err)
+		}
+		k.id = jwk.Kid
+		set.keys = append(set.keys, k)
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("key set has no ES256 or RS256 signing keys")
+	}
+	return set, nil
+}
+
+func ecKey(x, y string) (*ecdsa.PublicKey, error) {
+	xb, err1 := base64.RawURLEncoding.DecodeString(x)
+	yb, err2 := base64.RawURLEncoding.DecodeString(y)
+	if err1 != nil || err2 != nil || len(xb) != 32 || len(yb) != 32 {
+		return nil, errors.New("invalid P-256 coordinates")
+	}
+	// ecdh checks the point is on the curve.
+	if _, err := ecdh.P256().NewPublicKey(slices.Concat([]byte{4}, xb, yb)); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xb), Y: new(big.Int).SetBytes(yb)}, nil
+}
+
+func rsaKey(n, e string) (*rsa.PublicKey, error) {
+	nb, err1 := base64.RawURLEncoding.DecodeString(n)
+	eb, err2 := base64.RawURLEncoding.DecodeString(e)
+	if err1 != nil || err2 != nil || len(eb) == 0 || len(eb) > 4 {
+		return nil, errors.New("invalid RSA modulus or exponent")
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(new(big.Int).SetBytes(eb).Int64())}
+	if pub.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("RSA key shorter than %d bits", minRSABits)
+	}
+	return pub, nil
+}
+
+// Len returns the number of keys in the set.
+func (s *KeySet) Len() int { return len(s.keys) }
+
+// Claims are the registered claims of a verified token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	Expiry    time.Time // zero when the token has no exp
+	NotBefore time.Time // zero when the token has no nbf
+}
+
+// Verify checks token's signature with the key its header names, or with
+// every key of its algorithm when it names none, and returns its claims.
+// The claims are not validated; see Claims.Validate.
+func (s *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+	if header.Alg != ES256 && header.Alg != RS256 {
+		return nil, fmt.Errorf("%w %q", ErrAlgorithm, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	found := false
+	for _, k := range s.keys {
+		if k.alg != header.Alg || header.Kid != "" && k.id != "" && k.id != header.Kid {
+			continue
+		}
+		found = true
+		if verifySignature(k, digest[:], sig) {
+			return decodeClaims(parts[1])
+		}
+	}
+	if !found {
+		return nil, ErrUnknownKey
+	}
+	return nil, ErrSignature
+}
+
+func verifySignature(k key, digest, sig []byte) bool {
+	switch pub := k.pub.(type) {
+	case *ecdsa.PublicKey:
+		// JWS carries r and s as two fixed size big-endian integers rather
+		// than ASN.1 (RFC 7518, section 3.4).
+		if len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	}
+	return false
+}
+
+func decodeClaims(segment string) (*Claims, error) {
+	var raw struct {
+		Iss string          `json:"iss"`
+		Sub string          `json:"sub"`
+		Aud json.RawMessage `json:"aud"`
+		Exp *float64        `json:"exp"`
+		Nbf *float64        `json:"nbf"`
+	}
+	if err := decodeSegment(segment, &raw); err != nil {
+		return nil, ErrMalformed
+	}
+	c := &Claims{Issuer: raw.Iss, Subject: raw.Sub}
+	// aud is a single string or an array of them (RFC 7519, section 4.1.3).
+	if len(raw.Aud) > 0 {
+		var one string
+		if err := json.Unmarshal(raw.Aud, &one); err == nil {
+			c.Audience = []string{one}
+		} else if err := json.Unmarshal(raw.Aud, &c.Audience); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	if raw.Exp != nil {
+		c.Expiry = numericDate(*raw.Exp)
+	}
+	if raw.Nbf != nil {
+		c.NotBefore = numericDate(*raw.Nbf)
+	}
+	return c, nil
+}
+
+// numericDate converts a NumericDate, seconds since the epoch that may
+// have a fraction, dropping the fraction.
+func numericDate(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Validate checks that the token is valid at now, allowing leeway for
+// clock skew, and, when they are not empty, that it was issued by issuer
+// for audience. A token without exp is rejected.
+func (c *Claims) Validate(now time.Time, issuer, audience string, leeway time.Duration) error {
+	switch {
+	case c.Expiry.IsZero():
+		return errors.New("token has no expiry")
+	case !now.Before(c.Expiry.Add(leeway)):
+		return ErrExpired
+	case !c.NotBefore.IsZero() && now.Add(leeway).Before(c.NotBefore):
+		return ErrNotYet
+	case issuer != "" && c.Issuer != issuer:
+		return fmt.Errorf("token issued by %q", c.Issuer)
+	case audience != "" && !slices.Contains(c.Audience, audience):
+		return errors.New("token not issued for this audience")
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+// sign returns a token with header and claims signed by priv.
+func sign(t *testing.T, priv crypto.Signer, header, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	input := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch priv := priv.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return input + "." + b64(sig)
+}
+
+func ecJWK(t *testing.T, kid string, priv *ecdsa.PrivateKey) map[string]any {
+	t.Helper()
+	pub, err := priv.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := pub.Bytes() // 0x04 || x || y
+	return map[string]any{"kty": "EC", "crv": "P-256", "kid": kid, "x": b64(point[1:33]), "y": b64(point[33:])}
+}
+
+func keySet(t *testing.T, jwks ...map[string]any) *KeySet {
+	t.Helper()
+	data, _ := json.Marshal(map[string]any{"keys": jwks})
+	set, err := ParseKeySet(data)
+	if err != nil {
+		t.Fatalf("ParseKeySet: %v", err)
+	}
+	return set
+}
+
+func TestKeySet_Verify(t *testing.T) {
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK := map[string]any{
+		"kty": "RSA", "kid": "rsa", "use": "sig",
+		"n": b64(rsaPriv.N.Bytes()), "e": b64(big.NewInt(int64(rsaPriv.E)).Bytes()),
+	}
+	set := keySet(t, ecJWK(t, "ec", ecPriv), rsaJWK,
+		map[string]any{"kty": "EC", "crv": "P-384", "kid": "p384", "x": "AA", "y": "AA"}, // skipped
+	)
+	if set.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", set.Len())
+	}
+	claims := map[string]any{"iss": "https://issuer", "sub": "alice", "aud": "api", "exp": 2000000000}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"ES256", sign(t, ecPriv, map[string]any{"alg": "ES256", "kid": "ec"}, claims), nil},
+		{"RS256", sign(t, rsaPriv, map[string]any{"alg": "RS256", "kid": "rsa"}, claims), nil},
+		{"no kid", sign(t, ecPriv, map[string]any{"alg": "ES256"}, claims), nil},
+		{"unknown kid", sign(t, ecPriv, map[string]any{"alg": "ES256", "kid": "gone"}, claims), ErrUnknownKey},
+		{"other key", sign(t, otherPriv, map[string]any{"alg": "ES256", "kid": "ec"}, claims), ErrSignature},
+		{"none", b64([]byte(`{"alg":"none"}`)) + "." + b64([]byte(`{"sub":"alice"}`)) + ".", ErrAlgorithm},
+		{"HS256", sign(t, ecPriv, map[string]any{"alg": "HS256", "kid": "ec"}, claims), ErrAlgorithm},
+		{"malformed", "not-a-token", ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := set.Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && (c.Subject != "alice" || c.Issuer != "https://issuer" || len(c.Audience) != 1 || c.Audience[0] != "api") {
+				t.Errorf("claims = %+v", c)
+			}
+		})
+	}
+
+	// A changed payload no longer matches the signature.
+	token := sign(t, ecPriv, map[string]any{"alg": "ES256", "kid": "ec"}, claims)
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(map[string]any{"sub": "mallory", "exp": 2000000000})
+	if _, err := set.Verify(parts[0] + "." + b64(forged) + "." + parts[2]); !errors.Is(err, ErrSignature) {
+		t.Errorf("forged payload: error = %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestParseKeySet_Errors(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"keys":[]}`,
+		`{"keys":[{"kty":"EC","crv":"P-256","kid":"k","x":"AAAA","y":"AAAA"}]}`,
+		`{"keys":[{"kty":"RSA","kid":"short","n":"AQAB","e":"AQAB"}]}`,
+	} {
+		if _, err := ParseKeySet([]byte(data)); err == nil {
+			t.Errorf("ParseKeySet(%s) succeeded", data)
+		}
+	}
+}
+
+func TestClaims_Validate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	valid := Claims{Issuer: "iss", Audience: []string{"a", "b"}, Expiry: now.Add(time.Minute), NotBefore: now.Add(-time.Minute)}
+
+	tests := []struct {
+		name   string
+		mutate func(c *Claims)
+		ok     bool
+	}{
+		{"valid", func(c *Claims) {}, true},
+		{"expired", func(c *Claims) { c.Expiry = now.Add(-time.Minute) }, false},
+		{"expired within leeway", func(c *Claims) { c.Expiry = now.Add(-time.Second) }, true},
+		{"no expiry", func(c *Claims) { c.Expiry = time.Time{} }, false},
+		{"not yet valid", func(c *Claims) { c.NotBefore = now.Add(time.Minute) }, false},
+		{"other issuer", func(c *Claims) { c.Issuer = "elsewhere" }, false},
+		{"other audience", func(c *Claims) { c.Audience = []string{"c"} }, false},
+	}
+	for _, tt := range tests {
+		c := valid
+		tt.mutate(&c)
+		if err := c.Validate(now, "iss", "b", 5*time.Second); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
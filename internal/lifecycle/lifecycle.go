@@ -0,0 +1,204 @@
+// Package lifecycle sequences a graceful shutdown so external load
+// balancers stop sending traffic before the gateway stops accepting it.
+package lifecycle
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// State is a step of the shutdown sequence.
+type State string
+
+const (
+	// Serving is normal operation; /ready succeeds.
+	Serving State = "serving"
+	// PreStop fails /ready but keeps serving for the pre-stop delay, so load
+	// balancers polling readiness take the gateway out of rotation.
+	PreStop State = "pre_stop"
+	// Draining waits for requests in flight to finish.
+	Draining State = "draining"
+	// Stopped means draining finished or timed out; the listeners can close.
+	Stopped State = "stopped"
+)
+
+// drainPollInterval is how often the in-flight count is checked while
+// draining.
+const drainPollInterval = 100 * time.Millisecond
+
+// Config configures a Coordinator.
+type Config struct {
+	PreStopDelay time.Duration
+	DrainTimeout time.Duration
+
+	// InFlight returns the number of requests still being served.
+	InFlight func() int64
+
+	// Healthy, if set, must also hold for the gateway to be ready while
+	// serving, e.g. synthetic probes passing.
+	Healthy func() bool
+
+	// Degraded, if set, describes parts of the gateway that did not start,
+	// e.g. upstreams disabled by partial_start. A non-nil result is
+	// reported by /ready but does not fail it.
+	Degraded func() map[string]any
+
+	// Mode, if set, returns the gateway's operating mode, reported by
+	// /ready. It does not fail the probe: a read-only gateway still serves.
+	Mode func() string
+
+	Metrics *metrics.Metrics // may be nil
+	Logger  *slog.Logger
+}
+
+// Coordinator runs the shutdown sequence once, whether it is started by a
+// signal or by the pre-stop endpoint.
+type Coordinator struct {
+	cfg Config
+
+	mu    sync.RWMutex
+	state State
+
+	once sync.Once
+	done chan struct{}
+
+	// now and after are the clock; tests replace them.
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+// NewCoordinator creates a coordinator in the Serving state.
+func NewCoordinator(cfg Config) *Coordinator {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if cfg.InFlight == nil {
+		cfg.InFlight = func() int64 { return 0 }
+	}
+	c := &Coordinator{
+		cfg:   cfg,
+		done:  make(chan struct{}),
+		now:   time.Now,
+		after: time.After,
+	}
+	c.setState(Serving)
+	return c
+}
+
+// State returns the current state.
+func (c *Coordinator) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// Ready reports whether the gateway should receive new traffic.
+func (c *Coordinator) Ready() bool {
+	return c.State() == Serving && (c.cfg.Healthy == nil || c.cfg.Healthy())
+}
+
+// Done is closed once the sequence has reached Stopped.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Coordinator) setState(s State) {
+	c.mu.Lock()
+	c.state = s
+	c.mu.Unlock()
+	if c.cfg.Metrics != nil {
+		c.cfg.Metrics.RecordLifecycleState(string(s))
+	}
+}
+
+// Shutdown starts the sequence if it is not running yet and returns once it
+// has reached Stopped. Concurrent and later calls wait for the same run.
+func (c *Coordinator) Shutdown() {
+	c.once.Do(func() { go c.run() })
+	<-c.done
+}
+
+func (c *Coordinator) run() {
+	defer close(c.done)
+	logger := c.cfg.Logger
+
+	start := c.now()
+	c.setState(PreStop)
+	logger.Info("shutdown phase started", "phase", PreStop, "delay", c.cfg.PreStopDelay)
+	if c.cfg.PreStopDelay > 0 {
+		<-c.after(c.cfg.PreStopDelay)
+	}
+	logger.Info("shutdown phase finished", "phase", PreStop, "duration", c.now().Sub(start))
+
+	start = c.now()
+	c.setState(Draining)
+	logger.Info("shutdown phase started", "phase", Draining, "in_flight", c.cfg.InFlight(), "timeout", c.cfg.DrainTimeout)
+	remaining := c.drain(start)
+	if remaining > 0 {
+		logger.Warn("drain timed out", "in_flight", remaining, "duration", c.now().Sub(start))
+	} else {
+		logger.Info("shutdown phase finished", "phase", Draining, "duration", c.now().Sub(start))
+	}
+
+	c.setState(Stopped)
+}
+
+// drain waits until no requests are in flight or the drain timeout passes,
+// and returns the requests still in flight.
+func (c *Coordinator) drain(start time.Time) int64 {
+	for {
+		n := c.cfg.InFlight()
+		if n == 0 {
+			return 0
+		}
+		if c.cfg.DrainTimeout > 0 && c.now().Sub(start) >= c.cfg.DrainTimeout {
+			return n
+		}
+		<-c.after(drainPollInterval)
+	}
+}
+
+// ReadyHandler serves the readiness probe: 200 while serving, 503 once
+// shutdown has started or while the Healthy check fails.
+func (c *Coordinator) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		state := c.State()
+		body := map[string]any{"status": state}
+		if state != Serving {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if c.cfg.Healthy != nil && !c.cfg.Healthy() {
+			body["healthy"] = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if c.cfg.Mode != nil {
+			body["mode"] = c.cfg.Mode()
+		}
+		if c.cfg.Degraded != nil {
+			if degraded := c.cfg.Degraded(); degraded != nil {
+				body["degraded"] = degraded
+			}
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// PreStopHandler serves POST requests from a preStop hook. It runs the
+// shutdown sequence and answers once draining has finished or timed out.
+func (c *Coordinator) PreStopHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.Shutdown()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]State{"status": c.State()})
+	})
+}
@@ -0,0 +1,235 @@
+package lifecycle
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// fakeClock hands every timer to the test, which advances the clock and
+// fires it.
+type fakeClock struct {
+	mu     sync.Mutex
+	t      time.Time
+	timers chan fakeTimer
+}
+
+type fakeTimer struct {
+	d  time.Duration
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1000, 0), timers: make(chan fakeTimer)}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.timers <- fakeTimer{d: d, ch: ch}
+	return ch
+}
+
+// next waits for the next timer and checks its duration. Calling the
+// returned function advances the clock and fires the timer.
+func (c *fakeClock) next(t *testing.T, want time.Duration) (fire func()) {
+	t.Helper()
+	select {
+	case timer := <-c.timers:
+		if timer.d != want {
+			t.Fatalf("timer for %v, want %v", timer.d, want)
+		}
+		return func() {
+			c.mu.Lock()
+			c.t = c.t.Add(timer.d)
+			now := c.t
+			c.mu.Unlock()
+			timer.ch <- now
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no timer started, want one for %v", want)
+		return nil
+	}
+}
+
+func newTestCoordinator(cfg Config) (*Coordinator, *fakeClock) {
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := NewCoordinator(cfg)
+	clock := newFakeClock()
+	c.now, c.after = clock.now, clock.after
+	return c, clock
+}
+
+func TestCoordinator_Sequence(t *testing.T) {
+	var inFlight atomic.Int64
+	inFlight.Store(2)
+	m := metrics.New(metrics.Config{})
+	c, clock := newTestCoordinator(Config{
+		PreStopDelay: 5 * time.Second,
+		DrainTimeout: 10 * time.Second,
+		InFlight:     inFlight.Load,
+		Metrics:      m,
+	})
+
+	if !c.Ready() {
+		t.Fatal("not ready before shutdown")
+	}
+
+	// The pre-stop endpoint blocks for the whole sequence.
+	answered := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		c.PreStopHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/prestop", nil))
+		answered <- rec
+	}()
+
+	// A signal arriving meanwhile joins the same run rather than starting
+	// a second one.
+	signalled := make(chan struct{})
+	go func() {
+		c.Shutdown()
+		close(signalled)
+	}()
+
+	fire := clock.next(t, 5*time.Second) // pre-stop delay
+	if c.Ready() {
+		t.Error("still ready after the pre-stop delay started")
+	}
+	ready := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(ready, httptest.NewRequest("GET", "/ready", nil))
+	if ready.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready = %d during shutdown, want 503", ready.Code)
+	}
+	if got := c.State(); got != PreStop {
+		t.Fatalf("state = %s during the pre-stop delay, want pre_stop", got)
+	}
+	fire()
+
+	// Requests are still in flight, so draining polls until they finish.
+	fire = clock.next(t, drainPollInterval)
+	if got := c.State(); got != Draining {
+		t.Fatalf("state = %s after the pre-stop delay, want draining", got)
+	}
+	fire()
+	fire = clock.next(t, drainPollInterval)
+	select {
+	case <-answered:
+		t.Fatal("prestop answered before draining finished")
+	default:
+	}
+	inFlight.Store(0)
+	fire()
+
+	select {
+	case rec := <-answered:
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"stopped"`) {
+			t.Errorf("prestop answered %d %s", rec.Code, rec.Body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("prestop did not return after draining")
+	}
+	<-signalled
+
+	body := httptest.NewRecorder()
+	m.Handler().ServeHTTP(body, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_lifecycle_state{state="stopped"} 1`,
+		`gateway_lifecycle_state{state="serving"} 0`,
+		`gateway_lifecycle_state{state="draining"} 0`,
+	} {
+		if !strings.Contains(body.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestCoordinator_DrainTimeout(t *testing.T) {
+	c, clock := newTestCoordinator(Config{
+		DrainTimeout: 250 * time.Millisecond,
+		InFlight:     func() int64 { return 1 },
+	})
+
+	go c.Shutdown()
+	for i := 0; i < 3; i++ {
+		clock.next(t, drainPollInterval)()
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not give up after its timeout")
+	}
+	if got := c.State(); got != Stopped {
+		t.Errorf("state = %s, want stopped", got)
+	}
+}
+
+func TestPreStopHandler_RequiresPost(t *testing.T) {
+	c, _ := newTestCoordinator(Config{})
+	rec := httptest.NewRecorder()
+	c.PreStopHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/prestop", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET answered %d, want 405", rec.Code)
+	}
+	if !c.Ready() {
+		t.Error("GET started the shutdown sequence")
+	}
+}
+
+func TestReadyHandler_Healthy(t *testing.T) {
+	var healthy atomic.Bool
+	c, _ := newTestCoordinator(Config{Healthy: healthy.Load})
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable || c.Ready() {
+		t.Errorf("/ready = %d with a failing check, want 503", rec.Code)
+	}
+
+	healthy.Store(true)
+	rec = httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK || !c.Ready() {
+		t.Errorf("/ready = %d with a passing check, want 200", rec.Code)
+	}
+}
+
+func TestReadyHandler_Degraded(t *testing.T) {
+	c, _ := newTestCoordinator(Config{Degraded: func() map[string]any {
+		return map[string]any{"upstreams": map[string]string{"bad": "no targets"}}
+	}})
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/ready = %d while degraded, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"degraded":{"upstreams":{"bad":"no targets"}}`) {
+		t.Errorf("/ready body = %q, want the degraded details", rec.Body.String())
+	}
+}
+
+func TestReadyHandler_Mode(t *testing.T) {
+	c, _ := newTestCoordinator(Config{Mode: func() string { return "read_only" }})
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/ready = %d while read-only, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"mode":"read_only"`) {
+		t.Errorf("/ready body = %q, want the mode", rec.Body.String())
+	}
+}
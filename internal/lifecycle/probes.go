@@ -0,0 +1,43 @@
+package lifecycle
+
+import (
+	"net/http"
+)
+
+// healthBody is the liveness answer. It is fixed: liveness only says the
+// process is serving HTTP, so it must not depend on anything that slows
+// down under load.
+var healthBody = []byte(`{"status":"healthy"}`)
+
+// HealthHandler serves the liveness probe. It always answers 200.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(healthBody)
+	})
+}
+
+// ProbeMux returns a mux with the probe endpoints: /health and /ready. More
+// can be registered on it. The pre-stop hook is not on it: it stops the
+// gateway, so it belongs only on a listener operators control.
+func (c *Coordinator) ProbeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/health", HealthHandler())
+	mux.Handle("/ready", c.ReadyHandler())
+	return mux
+}
+
+// Prioritize serves the requests probes has a pattern for itself and passes
+// the rest to next, so probes never reach the rate limiter, the router or
+// any other state the proxied requests contend for. A liveness probe that
+// queued behind them during an overload would get the gateway restarted
+// when it is needed most.
+func Prioritize(probes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h, pattern := probes.Handler(r); pattern != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
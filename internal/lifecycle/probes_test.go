@@ -0,0 +1,109 @@
+package lifecycle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/proxy"
+)
+
+func TestPrioritize_ProbesStayFastUnderOverload(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.DefaultRPS = 1
+	cfg.RateLimit.DefaultBurst = 1
+	cfg.RateLimit.CleanupInterval = 0
+	cfg.Upstreams = []config.Upstream{{Name: "backend", Targets: []config.Target{{URL: backend.URL}}}}
+	cfg.Routes = []config.Route{{
+		Name: "api", Path: "/**", Upstream: "backend",
+		RateLimit:        &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 10000, BurstSize: 10000},
+		MaxConcurrent:    8,
+		ConcurrencyQueue: &config.ConcurrencyQueue{Depth: 1000, Timeout: 10 * time.Second},
+	}}
+	p, err := proxy.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	c := NewCoordinator(Config{})
+	mux := http.NewServeMux()
+	mux.Handle("/", p)
+	gateway := httptest.NewServer(Prioritize(c.ProbeMux(), mux))
+	defer gateway.Close()
+
+	// Fill the route's slots and its queue with requests that hang.
+	const proxied = 300
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: proxied + 1}}
+	var wg sync.WaitGroup
+	for range proxied {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(gateway.URL + "/slow")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	defer func() {
+		close(release)
+		wg.Wait()
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	// Each probe opens a new connection, as a kubelet does.
+	probe := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	var slowest time.Duration
+	for range 20 {
+		for _, path := range []string{"/health", "/ready"} {
+			start := time.Now()
+			resp, err := probe.Get(gateway.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("%s: status = %d", path, resp.StatusCode)
+			}
+			slowest = max(slowest, time.Since(start))
+		}
+	}
+	if slowest > 50*time.Millisecond {
+		t.Errorf("slowest probe took %v under overload", slowest)
+	}
+}
+
+func TestPrioritize_PassesOtherRequests(t *testing.T) {
+	c := NewCoordinator(Config{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	h := Prioritize(c.ProbeMux(), next)
+	for path, want := range map[string]int{
+		"/health":         http.StatusOK,
+		"/ready":          http.StatusOK,
+		"/admin/prestop":  http.StatusTeapot,
+		"/health/extra":   http.StatusTeapot,
+		"/api/health":     http.StatusTeapot,
+		"/admin/requests": http.StatusTeapot,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
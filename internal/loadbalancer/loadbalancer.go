@@ -3,15 +3,129 @@ package loadbalancer
 import (
 	"math/rand"
 	"net/url"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Target struct {
 	URL         *url.URL
 	Weight      int
-	Healthy     atomic.Bool
+	Healthy     atomic.Bool // from health checks and cluster peers
 	Connections atomic.Int64
+
+	// Standby targets are only picked while no other target is available.
+	Standby bool
+	// ExpectedRTT is the configured extra round trip to the target; with
+	// AutoRTT the measured one is used instead.
+	ExpectedRTT time.Duration
+	AutoRTT     bool
+
+	report      atomic.Pointer[Report]
+	measuredRTT atomic.Int64 // EWMA of health check latency, in nanoseconds
+}
+
+// rttWeight is the weight of a new sample in the measured round trip.
+const rttWeight = 0.2
+
+// ObserveRTT adds the latency of a successful health check to the
+// measured round trip.
+func (t *Target) ObserveRTT(d time.Duration) {
+	for {
+		old := t.measuredRTT.Load()
+		next := int64(d)
+		if old > 0 {
+			next = old + int64(rttWeight*float64(int64(d)-old))
+		}
+		if t.measuredRTT.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// MeasuredRTT returns the average health check latency, or 0 before the
+// first successful check.
+func (t *Target) MeasuredRTT() time.Duration {
+	return time.Duration(t.measuredRTT.Load())
+}
+
+// RTT returns the round trip the target is expected to add: the
+// configured one, or the measured one with AutoRTT.
+func (t *Target) RTT() time.Duration {
+	if t.AutoRTT {
+		return t.MeasuredRTT()
+	}
+	return t.ExpectedRTT
+}
+
+// RTT classes, used to label metrics.
+const (
+	RTTLocal = "local" // under 10ms
+	RTTNear  = "near"  // under 50ms
+	RTTFar   = "far"
+)
+
+// RTTClass buckets the target's RTT.
+func (t *Target) RTTClass() string {
+	switch rtt := t.RTT(); {
+	case rtt < 10*time.Millisecond:
+		return RTTLocal
+	case rtt < 50*time.Millisecond:
+		return RTTNear
+	}
+	return RTTFar
+}
+
+// Report is state a target pushed about itself. Until it expires it takes
+// precedence over health checks.
+type Report struct {
+	Healthy *bool   // nil leaves health to the checks
+	Load    float64 // 0-1; scales the weight down
+	Drain   bool    // take no new requests
+	Expires time.Time
+}
+
+// SetReport replaces the target's pushed state.
+func (t *Target) SetReport(r *Report) {
+	t.report.Store(r)
+}
+
+// Report returns the target's pushed state, or nil if none was pushed or it
+// has expired.
+func (t *Target) Report() *Report {
+	r := t.report.Load()
+	if r == nil {
+		return nil
+	}
+	if !time.Now().Before(r.Expires) {
+		t.report.CompareAndSwap(r, nil)
+		return nil
+	}
+	return r
+}
+
+// Available reports whether new requests may be sent to t.
+func (t *Target) Available() bool {
+	if r := t.Report(); r != nil {
+		if r.Drain {
+			return false
+		}
+		if r.Healthy != nil {
+			return *r.Healthy
+		}
+	}
+	return t.Healthy.Load()
+}
+
+// EffectiveWeight is the configured weight, at least 1, scaled down by the
+// reported load.
+func (t *Target) EffectiveWeight() float64 {
+	w := float64(max(t.Weight, 1))
+	if r := t.Report(); r != nil {
+		w *= 1 - r.Load
+	}
+	return w
 }
 
 type LoadBalancer interface {
@@ -20,6 +134,69 @@ type LoadBalancer interface {
 	MarkHealthy(target *Target, healthy bool)
 }
 
+// NextExcept returns a target of lb other than those in except, for a
+// request that must not go to the same target twice. It takes lb's own
+// picks first and falls back to any other available target, standby ones
+// last; nil means there is none.
+func NextExcept(lb LoadBalancer, except []*Target) *Target {
+	targets := lb.Targets()
+	for range targets {
+		if t := lb.Next(); t != nil && t.Available() && !slices.Contains(except, t) {
+			return t
+		}
+	}
+	for _, standby := range []bool{false, true} {
+		for _, t := range targets {
+			if t.Standby == standby && t.Available() && !slices.Contains(except, t) {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// failedOver reports whether no target of lb other than standby ones is
+// available.
+func failedOver(lb LoadBalancer) bool {
+	for _, t := range lb.Targets() {
+		if !t.Standby && t.Available() {
+			return false
+		}
+	}
+	return true
+}
+
+// Standby balances over the primary targets of an upstream and fails over
+// to its standby targets only while none of the primary ones is available.
+type Standby struct {
+	primary LoadBalancer
+	standby LoadBalancer
+	targets []*Target
+}
+
+func NewStandby(primary, standby LoadBalancer) *Standby {
+	targets := append(slices.Clone(primary.Targets()), standby.Targets()...)
+	return &Standby{primary: primary, standby: standby, targets: targets}
+}
+
+func (s *Standby) Next() *Target {
+	if !failedOver(s.primary) {
+		return s.primary.Next()
+	}
+	if t := s.standby.Next(); t != nil && t.Available() {
+		return t
+	}
+	return s.primary.Next()
+}
+
+func (s *Standby) Targets() []*Target {
+	return s.targets
+}
+
+func (s *Standby) MarkHealthy(target *Target, healthy bool) {
+	target.Healthy.Store(healthy)
+}
+
 type RoundRobin struct {
 	targets []*Target
 	current atomic.Uint64
@@ -46,7 +223,7 @@ func (rr *RoundRobin) Next() *Target {
 	for i := 0; i < n; i++ {
 		idx := rr.current.Add(1) % uint64(n)
 		target := rr.targets[idx]
-		if target.Healthy.Load() {
+		if target.Available() {
 			return target
 		}
 	}
@@ -89,7 +266,7 @@ func (lc *LeastConn) Next() *Target {
 	var minConn int64 = -1
 
 	for _, t := range lc.targets {
-		if !t.Healthy.Load() {
+		if !t.Available() {
 			continue
 		}
 
@@ -138,7 +315,7 @@ func (r *Random) Next() *Target {
 
 	healthy := make([]*Target, 0, len(r.targets))
 	for _, t := range r.targets {
-		if t.Healthy.Load() {
+		if t.Available() {
 			healthy = append(healthy, t)
 		}
 	}
@@ -160,14 +337,12 @@ func (r *Random) MarkHealthy(target *Target, healthy bool) {
 	target.Healthy.Store(healthy)
 }
 
+// WeightedRoundRobin spreads requests in proportion to each target's
+// effective weight, interleaving them smoothly rather than in bursts.
 type WeightedRoundRobin struct {
-	targets       []*Target
-	weights       []int
-	currentWeight int
-	maxWeight     int
-	gcd           int
-	current       int
-	mu            sync.RWMutex
+	targets []*Target
+	current []float64
+	mu      sync.Mutex
 }
 
 func NewWeightedRoundRobin(targets []*Target) *WeightedRoundRobin {
@@ -175,26 +350,9 @@ func NewWeightedRoundRobin(targets []*Target) *WeightedRoundRobin {
 		t.Healthy.Store(true)
 	}
 
-	weights := make([]int, len(targets))
-	maxWeight := 0
-
-	for i, t := range targets {
-		w := t.Weight
-		if w <= 0 {
-			w = 1
-		}
-		weights[i] = w
-		if w > maxWeight {
-			maxWeight = w
-		}
-	}
-
 	return &WeightedRoundRobin{
-		targets:   targets,
-		weights:   weights,
-		maxWeight: maxWeight,
-		gcd:       gcdSlice(weights),
-		current:   -1,
+		targets: targets,
+		current: make([]float64, len(targets)),
 	}
 }
 
@@ -206,31 +364,30 @@ func (wrr *WeightedRoundRobin) Next() *Target {
 		return nil
 	}
 
-	for {
-		wrr.current = (wrr.current + 1) % len(wrr.targets)
-		if wrr.current == 0 {
-			wrr.currentWeight -= wrr.gcd
-			if wrr.currentWeight <= 0 {
-				wrr.currentWeight = wrr.maxWeight
-			}
+	best := -1
+	var total float64
+	for i, t := range wrr.targets {
+		w := t.EffectiveWeight()
+		if w <= 0 || !t.Available() {
+			continue
 		}
-
-		if wrr.weights[wrr.current] >= wrr.currentWeight {
-			target := wrr.targets[wrr.current]
-			if target.Healthy.Load() {
-				return target
-			}
+		wrr.current[i] += w
+		total += w
+		if best < 0 || wrr.current[i] > wrr.current[best] {
+			best = i
 		}
+	}
 
-		if wrr.current == 0 && wrr.currentWeight == wrr.maxWeight {
-			return wrr.targets[0]
-		}
+	if best < 0 {
+		return wrr.targets[0]
 	}
+	wrr.current[best] -= total
+	return wrr.targets[best]
 }
 
 func (wrr *WeightedRoundRobin) Targets() []*Target {
-	wrr.mu.RLock()
-	defer wrr.mu.RUnlock()
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
 	return wrr.targets
 }
 
@@ -238,25 +395,24 @@ func (wrr *WeightedRoundRobin) MarkHealthy(target *Target, healthy bool) {
 	target.Healthy.Store(healthy)
 }
 
-func gcdSlice(nums []int) int {
-	if len(nums) == 0 {
-		return 1
-	}
-	result := nums[0]
-	for _, num := range nums[1:] {
-		result = gcd(result, num)
+// New returns a load balancer for strategy. Standby targets get a second
+// one of the same strategy, used only when failing over.
+func New(strategy string, targets []*Target) LoadBalancer {
+	var primary, standby []*Target
+	for _, t := range targets {
+		if t.Standby {
+			standby = append(standby, t)
+		} else {
+			primary = append(primary, t)
+		}
 	}
-	return result
-}
-
-func gcd(a, b int) int {
-	for b != 0 {
-		a, b = b, a%b
+	if len(standby) > 0 && len(primary) > 0 {
+		return NewStandby(newStrategy(strategy, primary), newStrategy(strategy, standby))
 	}
-	return a
+	return newStrategy(strategy, targets)
 }
 
-func New(strategy string, targets []*Target) LoadBalancer {
+func newStrategy(strategy string, targets []*Target) LoadBalancer {
 	switch strategy {
 	case "least_conn":
 		return NewLeastConn(targets)
@@ -3,6 +3,7 @@ package loadbalancer
 import (
 	"net/url"
 	"testing"
+	"time"
 )
 
 func makeTargets(urls ...string) []*Target {
@@ -140,6 +141,25 @@ func TestEmptyTargets(t *testing.T) {
 	}
 }
 
+func TestNextExcept(t *testing.T) {
+	targets := makeTargets("http://a:8080", "http://b:8080", "http://c:8080")
+	for _, lb := range []LoadBalancer{NewRoundRobin(targets), NewLeastConn(targets), NewRandom(targets), NewWeightedRoundRobin(targets)} {
+		for i := 0; i < 10; i++ {
+			if got := NextExcept(lb, targets[:1]); got == nil || got == targets[0] {
+				t.Fatalf("%T: NextExcept = %v, want a target other than a", lb, got)
+			}
+		}
+		targets[2].Healthy.Store(false)
+		if got := NextExcept(lb, targets[:1]); got != targets[1] {
+			t.Errorf("%T: NextExcept = %v, want the only other available target b", lb, got)
+		}
+		if got := NextExcept(lb, targets[:2]); got != nil {
+			t.Errorf("%T: NextExcept = %v, want nil with no available target left", lb, got)
+		}
+		targets[2].Healthy.Store(true)
+	}
+}
+
 func BenchmarkRoundRobin_Next(b *testing.B) {
 	targets := makeTargets(
 		"http://a:8080", "http://b:8080", "http://c:8080",
@@ -165,3 +185,99 @@ func BenchmarkLeastConn_Next(b *testing.B) {
 		lb.Next()
 	}
 }
+
+func TestTarget_Report(t *testing.T) {
+	targets := makeTargets("http://a:8080", "http://b:8080")
+	lb := NewRoundRobin(targets)
+	a := targets[0]
+
+	healthy, unhealthy := true, false
+	a.SetReport(&Report{Drain: true, Expires: time.Now().Add(time.Minute)})
+	for i := 0; i < 4; i++ {
+		if lb.Next() == a {
+			t.Fatal("draining target was picked")
+		}
+	}
+
+	// A pushed healthy state wins over a failing health check until it
+	// expires.
+	lb.MarkHealthy(a, false)
+	a.SetReport(&Report{Healthy: &healthy, Expires: time.Now().Add(time.Minute)})
+	if !a.Available() {
+		t.Error("reported healthy target not available")
+	}
+	a.SetReport(&Report{Healthy: &healthy, Expires: time.Now().Add(-time.Second)})
+	if a.Available() || a.Report() != nil {
+		t.Error("expired report still applied")
+	}
+
+	lb.MarkHealthy(a, true)
+	a.SetReport(&Report{Healthy: &unhealthy, Expires: time.Now().Add(time.Minute)})
+	if a.Available() {
+		t.Error("reported unhealthy target available")
+	}
+}
+
+func TestWeightedRoundRobin_ReportedLoad(t *testing.T) {
+	targets := makeTargets("http://a:8080", "http://b:8080")
+	targets[0].Weight = 4
+	targets[1].Weight = 4
+	targets[0].SetReport(&Report{Load: 0.75, Expires: time.Now().Add(time.Minute)})
+	lb := NewWeightedRoundRobin(targets)
+
+	// a's effective weight is 1 against b's 4.
+	seen := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		seen[lb.Next().URL.Host]++
+	}
+	if seen["a:8080"] != 10 || seen["b:8080"] != 40 {
+		t.Errorf("picks = %v, want a=10 b=40", seen)
+	}
+}
+
+func TestNew_Standby(t *testing.T) {
+	targets := makeTargets("http://a:8080", "http://b:8080", "http://dr:8080")
+	targets[2].Standby = true
+	lb := New("round_robin", targets)
+	if len(lb.Targets()) != 3 {
+		t.Fatalf("Targets() = %d targets, want 3", len(lb.Targets()))
+	}
+
+	for range 4 {
+		if got := lb.Next(); got.Standby {
+			t.Fatal("standby target picked while primary targets are available")
+		}
+	}
+	if got := NextExcept(lb, targets[:1]); got != targets[1] {
+		t.Errorf("NextExcept = %v, want the other primary target", got.URL)
+	}
+
+	lb.MarkHealthy(targets[0], false)
+	lb.MarkHealthy(targets[1], false)
+	if got := lb.Next(); got != targets[2] {
+		t.Errorf("Next = %v, want the standby target once the primary ones are down", got.URL)
+	}
+
+	lb.MarkHealthy(targets[1], true)
+	if got := lb.Next(); got != targets[1] {
+		t.Errorf("Next = %v, want a primary target once one recovered", got.URL)
+	}
+}
+
+func TestTarget_RTT(t *testing.T) {
+	target := makeTargets("http://dr:8080")[0]
+	target.ExpectedRTT = 80 * time.Millisecond
+	target.ObserveRTT(20 * time.Millisecond)
+	if target.RTT() != 80*time.Millisecond || target.RTTClass() != RTTFar {
+		t.Errorf("RTT = %v (%s), want the expected 80ms (far)", target.RTT(), target.RTTClass())
+	}
+
+	target.AutoRTT = true
+	target.ObserveRTT(40 * time.Millisecond)
+	if got := target.RTT(); got != 24*time.Millisecond {
+		t.Errorf("RTT = %v, want the average 24ms", got)
+	}
+	if target.RTTClass() != RTTNear {
+		t.Errorf("RTTClass = %s, want near", target.RTTClass())
+	}
+}
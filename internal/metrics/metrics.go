@@ -13,18 +13,60 @@ import (
 
 type Metrics struct {
 	// Counters
-	requestsTotal  map[string]*atomic.Int64
-	errorsTotal    map[string]*atomic.Int64
-	rateLimitHits  map[string]*atomic.Int64
-	apiKeyRequests map[string]*atomic.Int64
+	requestsTotal     map[string]*atomic.Int64
+	errorsTotal       map[string]*atomic.Int64
+	rateLimitHits     map[string]*atomic.Int64
+	apiKeyRequests    map[string]*atomic.Int64
+	ownerRequests     map[string]*atomic.Int64 // by route owner and status
+	rttClassRequests  map[string]*atomic.Int64 // by upstream and target RTT class
+	panicsTotal       map[string]*atomic.Int64
+	bodyMatches       map[string]*atomic.Int64
+	schemaFailures    map[string]*atomic.Int64 // by route and failed schema keyword
+	maintenanceServed map[string]*atomic.Int64 // by route
+	requestsShed      map[string]*atomic.Int64 // by route and reason, over max_concurrent
+	contentTypes      map[string]*atomic.Int64 // disallowed response content types by route and type
+	framingViolations map[string]*atomic.Int64 // ambiguous request framing by violation
+	connectionsClosed map[string]*atomic.Int64 // connections refused or closed by connection_limits, by reason
+	slowRequests      map[string]*atomic.Int64 // by route, over slow_request_threshold
+	decompressions    map[string]*atomic.Int64 // request bodies that failed to decompress, by route
+	tokenFetches      map[string]*atomic.Int64 // upstream auth token fetches by upstream and outcome
+	hedges            map[string]*atomic.Int64 // hedged requests by route and outcome
+	mirrorRequests    map[string]*atomic.Int64
+	mirrorDropped     map[string]*atomic.Int64
+	tlsClients        map[string]*atomic.Int64
+	eventsDropped     map[string]*atomic.Int64
+	cacheOps          map[string]*atomic.Int64
+	idempotencyOps    map[string]*atomic.Int64 // by route and idempotency_keys operation
+	flagEvaluations   map[string]*atomic.Int64 // by feature flag and variant
+	validatorOps      map[string]*atomic.Int64 // by route and auto_validators operation
+	targetReports     map[string]*atomic.Int64
+	tracingSpans      map[string]*atomic.Int64
 
 	// Gauges
-	upstreamHealth   map[string]*atomic.Int64
-	requestsInFlight map[string]*atomic.Int64
+	upstreamHealth    map[string]*atomic.Int64
+	requestsInFlight  map[string]*atomic.Int64
+	clusterPeerUp     map[string]*atomic.Int64
+	clusterPeerSync   map[string]*atomic.Int64 // unix nanos of the last state received
+	lifecycleState    map[string]*atomic.Int64 // 1 for the current shutdown state
+	gatewayMode       map[string]*atomic.Int64 // 1 for the current operating mode
+	probeSuccess      map[string]*atomic.Int64 // by synthetic probe name
+	degradation       map[string]*atomic.Int64 // current level by route, or "global"
+	awaitingCheck     map[string]*atomic.Int64 // 1 while an upstream awaits its first health check
+	subsystemErrors   map[string]*atomic.Int64
+	learnedBodyLimit  map[string]*atomic.Int64 // bytes by route, 0 while none
+	subsystemDegraded map[string]*atomic.Int64 // 1 while a subsystem's store is failing
+	upstreamDisabled  map[string]*atomic.Int64 // 1 for upstreams left out by partial_start
+	routeMaintenance  map[string]*atomic.Int64 // 1 while a route is in maintenance
+	routeDisabled     map[string]*atomic.Int64 // 1 while a route is disabled
+	configFingerprint map[string]*atomic.Int64 // 1 for the fingerprint of the running configuration
+	adaptiveLimit     map[string]*atomic.Int64 // adaptive concurrency limit by route
+	adaptiveInFlight  map[string]*atomic.Int64 // requests holding an adaptive slot by route
 
 	// Histograms
 	requestDuration  map[string]*histogram
 	upstreamDuration map[string]*histogram
+	mirrorDuration   map[string]*histogram
+	probeDuration    map[string]*histogram
 
 	buckets []float64
 	mu      sync.RWMutex
@@ -67,15 +109,57 @@ func New(cfg Config) *Metrics {
 	}
 
 	return &Metrics{
-		requestsTotal:    make(map[string]*atomic.Int64),
-		errorsTotal:      make(map[string]*atomic.Int64),
-		rateLimitHits:    make(map[string]*atomic.Int64),
-		apiKeyRequests:   make(map[string]*atomic.Int64),
-		upstreamHealth:   make(map[string]*atomic.Int64),
-		requestsInFlight: make(map[string]*atomic.Int64),
-		requestDuration:  make(map[string]*histogram),
-		upstreamDuration: make(map[string]*histogram),
-		buckets:          cfg.LatencyBuckets,
+		requestsTotal:     make(map[string]*atomic.Int64),
+		errorsTotal:       make(map[string]*atomic.Int64),
+		rateLimitHits:     make(map[string]*atomic.Int64),
+		apiKeyRequests:    make(map[string]*atomic.Int64),
+		ownerRequests:     make(map[string]*atomic.Int64),
+		rttClassRequests:  make(map[string]*atomic.Int64),
+		panicsTotal:       make(map[string]*atomic.Int64),
+		bodyMatches:       make(map[string]*atomic.Int64),
+		schemaFailures:    make(map[string]*atomic.Int64),
+		mirrorRequests:    make(map[string]*atomic.Int64),
+		mirrorDropped:     make(map[string]*atomic.Int64),
+		tlsClients:        make(map[string]*atomic.Int64),
+		eventsDropped:     make(map[string]*atomic.Int64),
+		cacheOps:          make(map[string]*atomic.Int64),
+		idempotencyOps:    make(map[string]*atomic.Int64),
+		flagEvaluations:   make(map[string]*atomic.Int64),
+		validatorOps:      make(map[string]*atomic.Int64),
+		targetReports:     make(map[string]*atomic.Int64),
+		tracingSpans:      make(map[string]*atomic.Int64),
+		upstreamHealth:    make(map[string]*atomic.Int64),
+		requestsInFlight:  make(map[string]*atomic.Int64),
+		clusterPeerUp:     make(map[string]*atomic.Int64),
+		clusterPeerSync:   make(map[string]*atomic.Int64),
+		lifecycleState:    make(map[string]*atomic.Int64),
+		gatewayMode:       make(map[string]*atomic.Int64),
+		probeSuccess:      make(map[string]*atomic.Int64),
+		degradation:       make(map[string]*atomic.Int64),
+		awaitingCheck:     make(map[string]*atomic.Int64),
+		upstreamDisabled:  make(map[string]*atomic.Int64),
+		routeMaintenance:  make(map[string]*atomic.Int64),
+		routeDisabled:     make(map[string]*atomic.Int64),
+		configFingerprint: make(map[string]*atomic.Int64),
+		adaptiveLimit:     make(map[string]*atomic.Int64),
+		adaptiveInFlight:  make(map[string]*atomic.Int64),
+		maintenanceServed: make(map[string]*atomic.Int64),
+		requestsShed:      make(map[string]*atomic.Int64),
+		framingViolations: make(map[string]*atomic.Int64),
+		connectionsClosed: make(map[string]*atomic.Int64),
+		slowRequests:      make(map[string]*atomic.Int64),
+		decompressions:    make(map[string]*atomic.Int64),
+		tokenFetches:      make(map[string]*atomic.Int64),
+		hedges:            make(map[string]*atomic.Int64),
+		contentTypes:      make(map[string]*atomic.Int64),
+		subsystemErrors:   make(map[string]*atomic.Int64),
+		learnedBodyLimit:  make(map[string]*atomic.Int64),
+		subsystemDegraded: make(map[string]*atomic.Int64),
+		requestDuration:   make(map[string]*histogram),
+		upstreamDuration:  make(map[string]*histogram),
+		mirrorDuration:    make(map[string]*histogram),
+		probeDuration:     make(map[string]*histogram),
+		buckets:           cfg.LatencyBuckets,
 	}
 }
 
@@ -153,6 +237,208 @@ func (m *Metrics) writePrometheusMetrics(w http.ResponseWriter) {
 		_, _ = fmt.Fprintf(w, "gateway_api_key_requests_total{key=\"%s\"} %d\n", key, counter.Load())
 	}
 
+	// Write requests by route owner
+	_, _ = fmt.Fprintln(w, "# HELP gateway_owner_requests_total Requests per team owning the route")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_owner_requests_total counter")
+	for key, counter := range m.ownerRequests {
+		_, _ = fmt.Fprintf(w, "gateway_owner_requests_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	_, _ = fmt.Fprintln(w, "# HELP gateway_upstream_rtt_class_requests_total Requests per upstream and RTT class of the target")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_upstream_rtt_class_requests_total counter")
+	for key, counter := range m.rttClassRequests {
+		_, _ = fmt.Fprintf(w, "gateway_upstream_rtt_class_requests_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write panic counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_panics_total Total number of panics recovered while serving requests")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_panics_total counter")
+	for route, counter := range m.panicsTotal {
+		_, _ = fmt.Fprintf(w, "gateway_panics_total{route=\"%s\"} %d\n", route, counter.Load())
+	}
+
+	// Write requests shed by concurrency limits
+	_, _ = fmt.Fprintln(w, "# HELP gateway_requests_shed_total Requests shed because the route was at max_concurrent")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_requests_shed_total counter")
+	for key, counter := range m.requestsShed {
+		_, _ = fmt.Fprintf(w, "gateway_requests_shed_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write adaptive concurrency limits
+	_, _ = fmt.Fprintln(w, "# HELP gateway_adaptive_concurrency_limit Current adaptive concurrency limit of a route")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_adaptive_concurrency_limit gauge")
+	for key, gauge := range m.adaptiveLimit {
+		_, _ = fmt.Fprintf(w, "gateway_adaptive_concurrency_limit{route=\"%s\"} %d\n", key, gauge.Load())
+	}
+	_, _ = fmt.Fprintln(w, "# HELP gateway_adaptive_concurrency_in_flight Requests holding a slot of a route's adaptive concurrency limit")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_adaptive_concurrency_in_flight gauge")
+	for key, gauge := range m.adaptiveInFlight {
+		_, _ = fmt.Fprintf(w, "gateway_adaptive_concurrency_in_flight{route=\"%s\"} %d\n", key, gauge.Load())
+	}
+
+	// Write hedged requests
+	_, _ = fmt.Fprintln(w, "# HELP gateway_hedged_requests_total Requests hedged to a second target, by which attempt answered first")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_hedged_requests_total counter")
+	for key, counter := range m.hedges {
+		_, _ = fmt.Fprintf(w, "gateway_hedged_requests_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write response content type violations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_response_content_type_violations_total Upstream responses with a content type the route does not allow")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_response_content_type_violations_total counter")
+	for key, counter := range m.contentTypes {
+		_, _ = fmt.Fprintf(w, "gateway_response_content_type_violations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write request framing violations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_request_framing_violations_total Request heads with ambiguous body framing")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_request_framing_violations_total counter")
+	for violation, counter := range m.framingViolations {
+		_, _ = fmt.Fprintf(w, "gateway_request_framing_violations_total{violation=\"%s\"} %d\n", violation, counter.Load())
+	}
+
+	// Write connection limit closes
+	_, _ = fmt.Fprintln(w, "# HELP gateway_connections_closed_total Client connections refused or closed by connection limits")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_connections_closed_total counter")
+	for reason, counter := range m.connectionsClosed {
+		_, _ = fmt.Fprintf(w, "gateway_connections_closed_total{reason=\"%s\"} %d\n", reason, counter.Load())
+	}
+
+	// Write slow request counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_slow_requests_total Proxied requests over the slow request threshold")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_slow_requests_total counter")
+	for route, counter := range m.slowRequests {
+		_, _ = fmt.Fprintf(w, "gateway_slow_requests_total{route=\"%s\"} %d\n", route, counter.Load())
+	}
+
+	// Write request decompression failure counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_request_decompression_failures_total Request bodies that could not be decompressed")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_request_decompression_failures_total counter")
+	for route, counter := range m.decompressions {
+		_, _ = fmt.Fprintf(w, "gateway_request_decompression_failures_total{route=\"%s\"} %d\n", route, counter.Load())
+	}
+
+	// Write upstream token fetches
+	_, _ = fmt.Fprintln(w, "# HELP gateway_upstream_token_fetches_total Upstream auth token fetches by outcome")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_upstream_token_fetches_total counter")
+	for key, counter := range m.tokenFetches {
+		_, _ = fmt.Fprintf(w, "gateway_upstream_token_fetches_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write body match counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_body_match_total Upstream selections made by body matchers")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_body_match_total counter")
+	for key, counter := range m.bodyMatches {
+		_, _ = fmt.Fprintf(w, "gateway_body_match_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write request schema violations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_schema_violations_total Request body schema violations by route and keyword")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_schema_violations_total counter")
+	for key, counter := range m.schemaFailures {
+		_, _ = fmt.Fprintf(w, "gateway_schema_violations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write cache operations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_cache_operations_total Response cache lookups, stores and invalidations")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_cache_operations_total counter")
+	for key, counter := range m.cacheOps {
+		_, _ = fmt.Fprintf(w, "gateway_cache_operations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write idempotency key operations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_idempotency_operations_total Responses stored and replayed for idempotency keys")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_idempotency_operations_total counter")
+	for key, counter := range m.idempotencyOps {
+		_, _ = fmt.Fprintf(w, "gateway_idempotency_operations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write feature flag evaluations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_feature_flag_evaluations_total Feature flags evaluated at the edge, by variant")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_feature_flag_evaluations_total counter")
+	for key, counter := range m.flagEvaluations {
+		_, _ = fmt.Fprintf(w, "gateway_feature_flag_evaluations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write auto validator operations
+	_, _ = fmt.Fprintln(w, "# HELP gateway_validator_operations_total Gateway-computed ETags injected and revalidations answered with 304")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_validator_operations_total counter")
+	for key, counter := range m.validatorOps {
+		_, _ = fmt.Fprintf(w, "gateway_validator_operations_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write target reports
+	_, _ = fmt.Fprintln(w, "# HELP gateway_target_reports_total Health and load reports pushed by targets")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_target_reports_total counter")
+	for key, counter := range m.targetReports {
+		_, _ = fmt.Fprintf(w, "gateway_target_reports_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write tracing spans
+	_, _ = fmt.Fprintln(w, "# HELP gateway_tracing_spans_total Trace spans exported, dropped or failed to export")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_tracing_spans_total counter")
+	for key, counter := range m.tracingSpans {
+		_, _ = fmt.Fprintf(w, "gateway_tracing_spans_total{outcome=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write learned body limits
+	_, _ = fmt.Fprintln(w, "# HELP gateway_learned_body_limit_bytes Body size limit learned from upstream 413 responses, 0 while none")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_learned_body_limit_bytes gauge")
+	for key, gauge := range m.learnedBodyLimit {
+		_, _ = fmt.Fprintf(w, "gateway_learned_body_limit_bytes{route=\"%s\"} %d\n", key, gauge.Load())
+	}
+
+	// Write subsystem errors
+	_, _ = fmt.Fprintln(w, "# HELP gateway_subsystem_errors_total Failures of a subsystem's backing store")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_subsystem_errors_total counter")
+	for key, counter := range m.subsystemErrors {
+		_, _ = fmt.Fprintf(w, "gateway_subsystem_errors_total{subsystem=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	_, _ = fmt.Fprintln(w, "# HELP gateway_subsystem_degraded Whether the subsystem's on_error policy is in effect")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_subsystem_degraded gauge")
+	for key, gauge := range m.subsystemDegraded {
+		_, _ = fmt.Fprintf(w, "gateway_subsystem_degraded{subsystem=\"%s\"} %d\n", key, gauge.Load())
+	}
+
+	// Write upstreams awaiting their first health check
+	_, _ = fmt.Fprintln(w, "# HELP gateway_upstream_awaiting_initial_check Whether the upstream is still awaiting its first health check cycle")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_upstream_awaiting_initial_check gauge")
+	for key, gauge := range m.awaitingCheck {
+		_, _ = fmt.Fprintf(w, "gateway_upstream_awaiting_initial_check{upstream=\"%s\"} %d\n", key, gauge.Load())
+	}
+
+	// Write upstreams disabled at start
+	_, _ = fmt.Fprintln(w, "# HELP gateway_upstream_disabled Whether the upstream was disabled at start by partial_start")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_upstream_disabled gauge")
+	for key, gauge := range m.upstreamDisabled {
+		_, _ = fmt.Fprintf(w, "gateway_upstream_disabled{upstream=\"%s\"} %d\n", key, gauge.Load())
+	}
+
+	// Write configuration fingerprint
+	_, _ = fmt.Fprintln(w, "# HELP gateway_config_fingerprint_info Fingerprint of the running configuration")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_config_fingerprint_info gauge")
+	for fingerprint, gauge := range m.configFingerprint {
+		_, _ = fmt.Fprintf(w, "gateway_config_fingerprint_info{fingerprint=\"%s\"} %d\n", fingerprint, gauge.Load())
+	}
+
+	// Write route maintenance
+	_, _ = fmt.Fprintln(w, "# HELP gateway_route_maintenance Whether the route is in maintenance")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_route_maintenance gauge")
+	for route, gauge := range m.routeMaintenance {
+		_, _ = fmt.Fprintf(w, "gateway_route_maintenance{route=\"%s\"} %d\n", route, gauge.Load())
+	}
+	_, _ = fmt.Fprintln(w, "# HELP gateway_route_disabled Whether the route is disabled")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_route_disabled gauge")
+	for route, gauge := range m.routeDisabled {
+		_, _ = fmt.Fprintf(w, "gateway_route_disabled{route=\"%s\"} %d\n", route, gauge.Load())
+	}
+	_, _ = fmt.Fprintln(w, "# HELP gateway_maintenance_responses_total Requests answered with a route's maintenance response")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_maintenance_responses_total counter")
+	for route, counter := range m.maintenanceServed {
+		_, _ = fmt.Fprintf(w, "gateway_maintenance_responses_total{route=\"%s\"} %d\n", route, counter.Load())
+	}
+
 	// Write upstream health
 	_, _ = fmt.Fprintln(w, "# HELP gateway_upstream_healthy Whether upstream is healthy")
 	_, _ = fmt.Fprintln(w, "# TYPE gateway_upstream_healthy gauge")
@@ -167,20 +453,99 @@ func (m *Metrics) writePrometheusMetrics(w http.ResponseWriter) {
 		_, _ = fmt.Fprintf(w, "gateway_requests_in_flight{key=\"%s\"} %d\n", key, gauge.Load())
 	}
 
-	// Write request duration histogram
+	// Write lifecycle state
+	_, _ = fmt.Fprintln(w, "# HELP gateway_lifecycle_state Current shutdown state of the gateway")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_lifecycle_state gauge")
+	for state, gauge := range m.lifecycleState {
+		_, _ = fmt.Fprintf(w, "gateway_lifecycle_state{state=\"%s\"} %d\n", state, gauge.Load())
+	}
+
+	// Write gateway mode
+	_, _ = fmt.Fprintln(w, "# HELP gateway_mode Current operating mode of the gateway, active or read_only")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_mode gauge")
+	for mode, gauge := range m.gatewayMode {
+		_, _ = fmt.Fprintf(w, "gateway_mode{mode=\"%s\"} %d\n", mode, gauge.Load())
+	}
+
+	// Write degradation levels
+	_, _ = fmt.Fprintln(w, "# HELP gateway_degradation_level Current degradation level, 0 being normal operation")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_degradation_level gauge")
+	for scope, gauge := range m.degradation {
+		_, _ = fmt.Fprintf(w, "gateway_degradation_level{scope=\"%s\"} %d\n", scope, gauge.Load())
+	}
+
+	// Write synthetic probe results
+	_, _ = fmt.Fprintln(w, "# HELP gateway_synthetic_probe_success Whether the last run of a synthetic probe succeeded")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_synthetic_probe_success gauge")
+	for name, gauge := range m.probeSuccess {
+		_, _ = fmt.Fprintf(w, "gateway_synthetic_probe_success{name=\"%s\"} %d\n", name, gauge.Load())
+	}
+
+	// Write cluster peer state
+	_, _ = fmt.Fprintln(w, "# HELP gateway_cluster_peer_up Whether the last sync push to a peer succeeded")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_cluster_peer_up gauge")
+	for peer, gauge := range m.clusterPeerUp {
+		_, _ = fmt.Fprintf(w, "gateway_cluster_peer_up{peer=\"%s\"} %d\n", peer, gauge.Load())
+	}
+	_, _ = fmt.Fprintln(w, "# HELP gateway_cluster_sync_lag_seconds Age of the most recent state received from a peer")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_cluster_sync_lag_seconds gauge")
+	now := time.Now()
+	for peer, gauge := range m.clusterPeerSync {
+		lag := now.Sub(time.Unix(0, gauge.Load())).Seconds()
+		_, _ = fmt.Fprintf(w, "gateway_cluster_sync_lag_seconds{peer=\"%s\"} %f\n", peer, lag)
+	}
+
+	// Write mirror counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_mirror_requests_total Total number of mirrored requests completed")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_mirror_requests_total counter")
+	for key, counter := range m.mirrorRequests {
+		_, _ = fmt.Fprintf(w, "gateway_mirror_requests_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+	_, _ = fmt.Fprintln(w, "# HELP gateway_mirror_dropped_total Requests that were sampled for mirroring but not mirrored")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_mirror_dropped_total counter")
+	for key, counter := range m.mirrorDropped {
+		_, _ = fmt.Fprintf(w, "gateway_mirror_dropped_total{key=\"%s\"} %d\n", key, counter.Load())
+	}
+
+	// Write TLS fingerprint counters
+	_, _ = fmt.Fprintln(w, "# HELP gateway_tls_fingerprint_requests_total Requests by TLS client fingerprint")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_tls_fingerprint_requests_total counter")
+	for fp, counter := range m.tlsClients {
+		_, _ = fmt.Fprintf(w, "gateway_tls_fingerprint_requests_total{fingerprint=\"%s\"} %d\n", fp, counter.Load())
+	}
+
+	// Write dropped events
+	_, _ = fmt.Fprintln(w, "# HELP gateway_events_dropped_total Events not delivered to a slow event stream subscriber")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_events_dropped_total counter")
+	for typ, counter := range m.eventsDropped {
+		_, _ = fmt.Fprintf(w, "gateway_events_dropped_total{type=\"%s\"} %d\n", typ, counter.Load())
+	}
+
+	// Write duration histograms
 	_, _ = fmt.Fprintln(w, "# HELP gateway_request_duration_seconds Request duration in seconds")
 	_, _ = fmt.Fprintln(w, "# TYPE gateway_request_duration_seconds histogram")
-	for key, hist := range m.requestDuration {
+	writeHistograms(w, "gateway_request_duration_seconds", "key", m.requestDuration)
+
+	_, _ = fmt.Fprintln(w, "# HELP gateway_mirror_duration_seconds Mirrored request duration in seconds")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_mirror_duration_seconds histogram")
+	writeHistograms(w, "gateway_mirror_duration_seconds", "key", m.mirrorDuration)
+
+	_, _ = fmt.Fprintln(w, "# HELP gateway_synthetic_probe_duration_seconds Synthetic probe duration in seconds")
+	_, _ = fmt.Fprintln(w, "# TYPE gateway_synthetic_probe_duration_seconds histogram")
+	writeHistograms(w, "gateway_synthetic_probe_duration_seconds", "name", m.probeDuration)
+}
+
+func writeHistograms(w http.ResponseWriter, name, label string, histograms map[string]*histogram) {
+	for key, hist := range histograms {
 		var cumulative int64
 		for i, bucket := range hist.buckets {
 			cumulative += hist.counts[i].Load()
-			_, _ = fmt.Fprintf(w, "gateway_request_duration_seconds_bucket{key=\"%s\",le=\"%v\"} %d\n",
-				key, bucket, cumulative)
+			_, _ = fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"%v\"} %d\n", name, label, key, bucket, cumulative)
 		}
 		cumulative += hist.counts[len(hist.buckets)].Load()
-		_, _ = fmt.Fprintf(w, "gateway_request_duration_seconds_bucket{key=\"%s\",le=\"+Inf\"} %d\n", key, cumulative)
-		_, _ = fmt.Fprintf(w, "gateway_request_duration_seconds_sum{key=\"%s\"} %f\n", key, float64(hist.sum.Load())/1e6)
-		_, _ = fmt.Fprintf(w, "gateway_request_duration_seconds_count{key=\"%s\"} %d\n", key, hist.count.Load())
+		_, _ = fmt.Fprintf(w, "%s_bucket{%s=\"%s\",le=\"+Inf\"} %d\n", name, label, key, cumulative)
+		_, _ = fmt.Fprintf(w, "%s_sum{%s=\"%s\"} %f\n", name, label, key, float64(hist.sum.Load())/1e6)
+		_, _ = fmt.Fprintf(w, "%s_count{%s=\"%s\"} %d\n", name, label, key, hist.count.Load())
 	}
 }
 
@@ -202,6 +567,227 @@ func (m *Metrics) RecordRateLimitHit(route, limitType string) {
 	m.getOrCreateCounter(m.rateLimitHits, key).Add(1)
 }
 
+func (m *Metrics) RecordCacheOperation(route, op string) {
+	key := route + "_" + op
+	m.getOrCreateCounter(m.cacheOps, key).Add(1)
+}
+
+// RecordIdempotencyOperation counts an idempotency_keys operation on route:
+// store, replay, wait, mismatch or too_large.
+func (m *Metrics) RecordIdempotencyOperation(route, op string) {
+	m.getOrCreateCounter(m.idempotencyOps, route+"_"+op).Add(1)
+}
+
+// RecordFeatureFlagEvaluation counts a request for which flag evaluated to
+// variant.
+func (m *Metrics) RecordFeatureFlagEvaluation(flag, variant string) {
+	m.getOrCreateCounter(m.flagEvaluations, flag+"_"+variant).Add(1)
+}
+
+// RecordValidatorOperation counts an auto_validators operation on route:
+// inject, not_modified or too_large.
+func (m *Metrics) RecordValidatorOperation(route, op string) {
+	m.getOrCreateCounter(m.validatorOps, route+"_"+op).Add(1)
+}
+
+func (m *Metrics) RecordTargetReport(upstream, result string) {
+	m.getOrCreateCounter(m.targetReports, upstream+"_"+result).Add(1)
+}
+
+// RecordLearnedBodyLimit records the body limit learned for route.
+func (m *Metrics) RecordLearnedBodyLimit(route string, limit int64) {
+	m.getOrCreateCounter(m.learnedBodyLimit, route).Store(limit)
+}
+
+// RecordSubsystemError counts a failure of a subsystem's backing store.
+func (m *Metrics) RecordSubsystemError(subsystem string) {
+	m.getOrCreateCounter(m.subsystemErrors, subsystem).Add(1)
+}
+
+// RecordSubsystemDegraded records whether a subsystem's on_error policy is
+// in effect.
+func (m *Metrics) RecordSubsystemDegraded(subsystem string, degraded bool) {
+	var v int64
+	if degraded {
+		v = 1
+	}
+	m.getOrCreateCounter(m.subsystemDegraded, subsystem).Store(v)
+}
+
+// RecordAwaitingInitialCheck records whether upstream is still awaiting its
+// first health check cycle.
+func (m *Metrics) RecordAwaitingInitialCheck(upstream string, awaiting bool) {
+	var v int64
+	if awaiting {
+		v = 1
+	}
+	m.getOrCreateCounter(m.awaitingCheck, upstream).Store(v)
+}
+
+// RecordUpstreamDisabled records that upstream could not be built and was
+// left out by partial_start.
+func (m *Metrics) RecordUpstreamDisabled(upstream string) {
+	m.getOrCreateCounter(m.upstreamDisabled, upstream).Store(1)
+}
+
+// SetConfigFingerprint records the fingerprint of the configuration now
+// running, replacing the previous one.
+func (m *Metrics) SetConfigFingerprint(fingerprint string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.configFingerprint)
+	gauge := &atomic.Int64{}
+	gauge.Store(1)
+	m.configFingerprint[fingerprint] = gauge
+}
+
+// SetRouteMaintenance records whether route is in maintenance.
+func (m *Metrics) SetRouteMaintenance(route string, on bool) {
+	var v int64
+	if on {
+		v = 1
+	}
+	m.getOrCreateCounter(m.routeMaintenance, route).Store(v)
+}
+
+// SetRouteDisabled records whether route is disabled.
+func (m *Metrics) SetRouteDisabled(route string, disabled bool) {
+	var v int64
+	if disabled {
+		v = 1
+	}
+	m.getOrCreateCounter(m.routeDisabled, route).Store(v)
+}
+
+// RecordMaintenanceResponse counts a request answered with route's
+// maintenance response.
+func (m *Metrics) RecordMaintenanceResponse(route string) {
+	m.getOrCreateCounter(m.maintenanceServed, route).Add(1)
+}
+
+// RecordTracingSpans counts n spans by outcome: exported, dropped or failed.
+func (m *Metrics) RecordTracingSpans(outcome string, n int) {
+	m.getOrCreateCounter(m.tracingSpans, outcome).Add(int64(n))
+}
+
+func (m *Metrics) RecordBodyMatch(route, matcher string) {
+	key := route + "_" + matcher
+	m.getOrCreateCounter(m.bodyMatches, key).Add(1)
+}
+
+// RecordFramingViolation counts a request head whose framing had violation,
+// one of the framing package's violation names.
+func (m *Metrics) RecordFramingViolation(violation string) {
+	m.getOrCreateCounter(m.framingViolations, violation).Add(1)
+}
+
+// RecordConnectionClosed counts a client connection the gateway refused or
+// closed for reason, one of the connlimit package's reasons.
+func (m *Metrics) RecordConnectionClosed(reason string) {
+	m.getOrCreateCounter(m.connectionsClosed, reason).Add(1)
+}
+
+// RecordRequestDecompressionFailure counts a request body on route that was
+// not valid for its Content-Encoding.
+func (m *Metrics) RecordRequestDecompressionFailure(route string) {
+	m.getOrCreateCounter(m.decompressions, route).Add(1)
+}
+
+// RecordSlowRequest counts a proxied request on route that took at least
+// the slow request threshold.
+func (m *Metrics) RecordSlowRequest(route string) {
+	m.getOrCreateCounter(m.slowRequests, route).Add(1)
+}
+
+// RecordUpstreamTokenFetch counts a token fetch for upstream's auth by
+// outcome: ok or error.
+func (m *Metrics) RecordUpstreamTokenFetch(upstream, outcome string) {
+	m.getOrCreateCounter(m.tokenFetches, upstream+"_"+outcome).Add(1)
+}
+
+// RecordHedge counts a request on route that was hedged, by outcome:
+// primary_won, hedge_won, or no_target when a hedge was due but the
+// upstream had no other available target.
+func (m *Metrics) RecordHedge(route, outcome string) {
+	m.getOrCreateCounter(m.hedges, route+"_"+outcome).Add(1)
+}
+
+// RecordShed counts a request shed by route's max_concurrent for reason:
+// limit, queue_full, queue_timeout or canceled.
+func (m *Metrics) RecordShed(route, reason string) {
+	m.getOrCreateCounter(m.requestsShed, route+"_"+reason).Add(1)
+}
+
+// RecordAdaptiveConcurrency records route's adaptive concurrency limit and
+// the requests holding a slot of it.
+func (m *Metrics) RecordAdaptiveConcurrency(route string, limit, inflight int) {
+	m.getOrCreateCounter(m.adaptiveLimit, route).Store(int64(limit))
+	m.getOrCreateCounter(m.adaptiveInFlight, route).Store(int64(inflight))
+}
+
+// RecordContentTypeViolation counts a response on route whose media type
+// is not allowed; mediaType is none when missing and invalid when it does
+// not parse.
+func (m *Metrics) RecordContentTypeViolation(route, mediaType string) {
+	m.getOrCreateCounter(m.contentTypes, route+"_"+mediaType).Add(1)
+}
+
+// RecordSchemaViolation counts a request body failing keyword of its
+// route's request_schema.
+func (m *Metrics) RecordSchemaViolation(route, keyword string) {
+	m.getOrCreateCounter(m.schemaFailures, route+"_"+keyword).Add(1)
+}
+
+// RecordMirrorRequest records a completed shadow request. Status 0 means the
+// mirror upstream could not be reached.
+func (m *Metrics) RecordMirrorRequest(route, method string, status int, duration time.Duration) {
+	key := route + "_" + method + "_" + strconv.Itoa(status)
+	m.getOrCreateCounter(m.mirrorRequests, key).Add(1)
+
+	histKey := route + "_" + method
+	m.getOrCreateHistogram(m.mirrorDuration, histKey).observe(duration.Seconds())
+}
+
+func (m *Metrics) RecordMirrorDropped(route, reason string) {
+	key := route + "_" + reason
+	m.getOrCreateCounter(m.mirrorDropped, key).Add(1)
+}
+
+// maxFingerprintSeries caps the number of distinct fingerprint labels;
+// further fingerprints are counted under "other".
+const maxFingerprintSeries = 500
+
+func (m *Metrics) RecordTLSFingerprint(fingerprint string) {
+	m.mu.RLock()
+	_, known := m.tlsClients[fingerprint]
+	full := len(m.tlsClients) >= maxFingerprintSeries
+	m.mu.RUnlock()
+	if !known && full {
+		fingerprint = "other"
+	}
+	m.getOrCreateCounter(m.tlsClients, fingerprint).Add(1)
+}
+
+func (m *Metrics) RecordEventDropped(eventType string) {
+	m.getOrCreateCounter(m.eventsDropped, eventType).Add(1)
+}
+
+func (m *Metrics) RecordPanic(route string) {
+	m.getOrCreateCounter(m.panicsTotal, route).Add(1)
+}
+
+func (m *Metrics) RecordPeerUp(peer string, up bool) {
+	val := int64(0)
+	if up {
+		val = 1
+	}
+	m.getOrCreateCounter(m.clusterPeerUp, peer).Store(val)
+}
+
+func (m *Metrics) RecordPeerSync(peer string, sentAt time.Time) {
+	m.getOrCreateCounter(m.clusterPeerSync, peer).Store(sentAt.UnixNano())
+}
+
 func (m *Metrics) RecordUpstreamHealth(upstream, target string, healthy bool) {
 	key := upstream + "_" + target
 	val := int64(0)
@@ -221,6 +807,77 @@ func (m *Metrics) RecordAPIKeyRequest(keyName string, status int) {
 	m.getOrCreateCounter(m.apiKeyRequests, key).Add(1)
 }
 
+// RecordOwnerRequest counts a request answered with status on a route
+// owned by owner.
+func (m *Metrics) RecordOwnerRequest(owner string, status int) {
+	key := owner + "_" + strconv.Itoa(status)
+	m.getOrCreateCounter(m.ownerRequests, key).Add(1)
+}
+
+// RecordRTTClassRequest counts a request sent to a target of upstream in
+// the given RTT class.
+func (m *Metrics) RecordRTTClassRequest(upstream, class string) {
+	m.getOrCreateCounter(m.rttClassRequests, upstream+"_"+class).Add(1)
+}
+
+// RecordLifecycleState marks state as the current one and every state
+// recorded before as inactive.
+func (m *Metrics) RecordLifecycleState(state string) {
+	current := m.getOrCreateCounter(m.lifecycleState, state)
+
+	current.Store(1)
+	m.mu.RLock()
+	for _, gauge := range m.lifecycleState {
+		if gauge != current {
+			gauge.Store(0)
+		}
+	}
+	m.mu.RUnlock()
+}
+
+// RecordGatewayMode marks mode as the current operating mode and every mode
+// recorded before as inactive.
+func (m *Metrics) RecordGatewayMode(mode string) {
+	current := m.getOrCreateCounter(m.gatewayMode, mode)
+
+	current.Store(1)
+	m.mu.RLock()
+	for _, gauge := range m.gatewayMode {
+		if gauge != current {
+			gauge.Store(0)
+		}
+	}
+	m.mu.RUnlock()
+}
+
+// RecordDegradationLevel sets the current degradation level of a route, or
+// of the top-level ladder under "global".
+func (m *Metrics) RecordDegradationLevel(scope string, level int) {
+	m.getOrCreateCounter(m.degradation, scope).Store(int64(level))
+}
+
+// RecordSyntheticProbe records the result and duration of one probe run.
+func (m *Metrics) RecordSyntheticProbe(name string, success bool, duration time.Duration) {
+	val := int64(0)
+	if success {
+		val = 1
+	}
+	m.getOrCreateCounter(m.probeSuccess, name).Store(val)
+	m.getOrCreateHistogram(m.probeDuration, name).observe(duration.Seconds())
+}
+
+// TotalInFlight returns the number of requests in flight across all routes.
+func (m *Metrics) TotalInFlight() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var total int64
+	for _, gauge := range m.requestsInFlight {
+		total += gauge.Load()
+	}
+	return total
+}
+
 func (m *Metrics) InFlightRequests(route string) func() {
 	gauge := m.getOrCreateCounter(m.requestsInFlight, route)
 	gauge.Add(1)
@@ -358,12 +1015,48 @@ func (m *Metrics) JSONHandler() http.Handler {
 		defer m.mu.RUnlock()
 
 		stats := map[string]interface{}{
-			"requests_total":     counterMapToJSON(m.requestsTotal),
-			"errors_total":       counterMapToJSON(m.errorsTotal),
-			"rate_limit_hits":    counterMapToJSON(m.rateLimitHits),
-			"api_key_requests":   counterMapToJSON(m.apiKeyRequests),
-			"upstream_health":    counterMapToJSON(m.upstreamHealth),
-			"requests_in_flight": counterMapToJSON(m.requestsInFlight),
+			"requests_total":           counterMapToJSON(m.requestsTotal),
+			"errors_total":             counterMapToJSON(m.errorsTotal),
+			"rate_limit_hits":          counterMapToJSON(m.rateLimitHits),
+			"api_key_requests":         counterMapToJSON(m.apiKeyRequests),
+			"owner_requests":           counterMapToJSON(m.ownerRequests),
+			"rtt_class_requests":       counterMapToJSON(m.rttClassRequests),
+			"panics_total":             counterMapToJSON(m.panicsTotal),
+			"body_matches":             counterMapToJSON(m.bodyMatches),
+			"schema_violations":        counterMapToJSON(m.schemaFailures),
+			"requests_shed":            counterMapToJSON(m.requestsShed),
+			"adaptive_limit":           counterMapToJSON(m.adaptiveLimit),
+			"adaptive_in_flight":       counterMapToJSON(m.adaptiveInFlight),
+			"bad_content_types":        counterMapToJSON(m.contentTypes),
+			"framing_violations":       counterMapToJSON(m.framingViolations),
+			"connections_closed":       counterMapToJSON(m.connectionsClosed),
+			"slow_requests":            counterMapToJSON(m.slowRequests),
+			"decompression_failures":   counterMapToJSON(m.decompressions),
+			"upstream_token_fetches":   counterMapToJSON(m.tokenFetches),
+			"hedged_requests":          counterMapToJSON(m.hedges),
+			"mirror_requests":          counterMapToJSON(m.mirrorRequests),
+			"mirror_dropped":           counterMapToJSON(m.mirrorDropped),
+			"cache_operations":         counterMapToJSON(m.cacheOps),
+			"idempotency_operations":   counterMapToJSON(m.idempotencyOps),
+			"feature_flag_evaluations": counterMapToJSON(m.flagEvaluations),
+			"validator_operations":     counterMapToJSON(m.validatorOps),
+			"target_reports":           counterMapToJSON(m.targetReports),
+			"tracing_spans":            counterMapToJSON(m.tracingSpans),
+			"upstream_health":          counterMapToJSON(m.upstreamHealth),
+			"requests_in_flight":       counterMapToJSON(m.requestsInFlight),
+			"lifecycle_state":          counterMapToJSON(m.lifecycleState),
+			"gateway_mode":             counterMapToJSON(m.gatewayMode),
+			"synthetic_probes":         counterMapToJSON(m.probeSuccess),
+			"degradation_level":        counterMapToJSON(m.degradation),
+			"awaiting_initial_check":   counterMapToJSON(m.awaitingCheck),
+			"upstream_disabled":        counterMapToJSON(m.upstreamDisabled),
+			"route_maintenance":        counterMapToJSON(m.routeMaintenance),
+			"route_disabled":           counterMapToJSON(m.routeDisabled),
+			"config_fingerprint":       counterMapToJSON(m.configFingerprint),
+			"maintenance_responses":    counterMapToJSON(m.maintenanceServed),
+			"subsystem_errors":         counterMapToJSON(m.subsystemErrors),
+			"learned_body_limit":       counterMapToJSON(m.learnedBodyLimit),
+			"subsystem_degraded":       counterMapToJSON(m.subsystemDegraded),
 		}
 		_ = json.NewEncoder(w).Encode(stats)
 	})
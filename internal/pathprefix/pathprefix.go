@@ -0,0 +1,99 @@
+// Package pathprefix removes and adds the path prefixes requests pass
+// through: the gateway's server.base_path and the static prefix a
+// strip_path route removes before forwarding.
+package pathprefix
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Prefix is a cleaned path prefix: empty for none, otherwise a path that
+// starts with a slash and does not end with one. A prefix matches whole
+// segments only, so /gateway is not a prefix of /gateways.
+type Prefix string
+
+// New cleans p into a Prefix. A missing leading slash is added and a
+// trailing one dropped, so "gateway", "/gateway" and "/gateway/" are the
+// same prefix; "" and "/" are none.
+func New(p string) Prefix {
+	if p == "" {
+		return ""
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return Prefix(p)
+}
+
+// Strip removes p from the start of urlPath and reports whether urlPath was
+// under it. What is left always starts with a slash: /gateway and /gateway/
+// both strip to /. An empty prefix strips nothing from any path.
+func (p Prefix) Strip(urlPath string) (string, bool) {
+	if p == "" {
+		return urlPath, true
+	}
+	rest, ok := strings.CutPrefix(urlPath, string(p))
+	switch {
+	case !ok || (rest != "" && rest[0] != '/'):
+		return urlPath, false
+	case rest == "":
+		return "/", true
+	}
+	return rest, true
+}
+
+// StripURL removes p from u's path, and from its raw path when it has one,
+// and reports whether u was under p. u is left unchanged if it was not.
+func (p Prefix) StripURL(u *url.URL) bool {
+	if p == "" {
+		return true
+	}
+	stripped, ok := p.Strip(u.Path)
+	if !ok {
+		return false
+	}
+	if u.RawPath != "" {
+		raw, ok := p.Strip(u.RawPath)
+		if !ok {
+			return false
+		}
+		u.RawPath = raw
+	}
+	u.Path = stripped
+	return true
+}
+
+// Join puts ref, a path with an optional query or fragment, under p: /x
+// becomes /gateway/x and / becomes /gateway/. An empty path is p itself,
+// so ?page=2 becomes /gateway?page=2.
+func (p Prefix) Join(ref string) string {
+	if p == "" || ref == "" {
+		return string(p) + ref
+	}
+	switch ref[0] {
+	case '/', '?', '#':
+		return string(p) + ref
+	}
+	return string(p) + "/" + ref
+}
+
+// Location maps a Location header an upstream sent, in the paths it
+// serves, to the paths clients use: a path-absolute reference gets p in
+// front of it, unless it is already under p. Absolute and
+// scheme-relative URLs, and relative references, are returned unchanged.
+func (p Prefix) Location(loc string) string {
+	if p == "" || !strings.HasPrefix(loc, "/") || strings.HasPrefix(loc, "//") {
+		return loc
+	}
+	refPath := loc
+	if i := strings.IndexAny(refPath, "?#"); i >= 0 {
+		refPath = refPath[:i]
+	}
+	if _, ok := p.Strip(refPath); ok {
+		return loc
+	}
+	return p.Join(loc)
+}
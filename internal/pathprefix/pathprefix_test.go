@@ -0,0 +1,128 @@
+package pathprefix
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Prefix
+	}{
+		{"", ""},
+		{"/", ""},
+		{"//", ""},
+		{"/gateway", "/gateway"},
+		{"/gateway/", "/gateway"},
+		{"gateway", "/gateway"},
+		{"gateway/", "/gateway"},
+		{"/gateway//v1/", "/gateway/v1"},
+		{"/edge/gateway", "/edge/gateway"},
+	} {
+		if got := New(tt.in); got != tt.want {
+			t.Errorf("New(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPrefix_Strip(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, path string
+		want         string
+		ok           bool
+	}{
+		{"", "/api/users", "/api/users", true},
+		{"", "/", "/", true},
+		{"/gateway", "/gateway/api/users", "/api/users", true},
+		{"/gateway", "/gateway/", "/", true},
+		{"/gateway", "/gateway", "/", true},
+		{"/gateway", "/gateway/api/", "/api/", true},
+		{"/gateway", "/gateways/api", "/gateways/api", false},
+		{"/gateway", "/gatewayapi", "/gatewayapi", false},
+		{"/gateway", "/api/gateway", "/api/gateway", false},
+		{"/gateway", "/", "/", false},
+		{"/gateway", "", "", false},
+		{"/gateway", "/Gateway/api", "/Gateway/api", false},
+		// Nested prefixes only match in full.
+		{"/edge/gateway", "/edge/gateway/api", "/api", true},
+		{"/edge/gateway", "/edge/gateway", "/", true},
+		{"/edge/gateway", "/edge/api", "/edge/api", false},
+		{"/edge/gateway", "/edge", "/edge", false},
+		{"/edge", "/edge/gateway/api", "/gateway/api", true},
+	} {
+		got, ok := Prefix(tt.prefix).Strip(tt.path)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Prefix(%q).Strip(%q) = %q, %v, want %q, %v", tt.prefix, tt.path, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestPrefix_StripURL(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, url       string
+		wantPath, wantRaw string
+		ok                bool
+	}{
+		{"/gateway", "/gateway/files/a%2Fb", "/files/a/b", "/files/a%2Fb", true},
+		{"/gateway", "/gateway/users/1?x=1", "/users/1", "", true},
+		{"/gateway", "/gateway", "/", "", true},
+		{"/gateway", "/other/a%2Fb", "/other/a/b", "/other/a%2Fb", false},
+		{"/gateway", "/gate%77ay/x", "/gateway/x", "/gate%77ay/x", false},
+		{"", "/files/a%2Fb", "/files/a/b", "/files/a%2Fb", true},
+	} {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok := Prefix(tt.prefix).StripURL(u)
+		if ok != tt.ok || u.Path != tt.wantPath || u.RawPath != tt.wantRaw {
+			t.Errorf("Prefix(%q).StripURL(%q) = %v, path %q, raw path %q; want %v, %q, %q",
+				tt.prefix, tt.url, ok, u.Path, u.RawPath, tt.ok, tt.wantPath, tt.wantRaw)
+		}
+	}
+}
+
+func TestPrefix_Join(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, ref, want string
+	}{
+		{"", "/api", "/api"},
+		{"", "", ""},
+		{"/gateway", "/api/users", "/gateway/api/users"},
+		{"/gateway", "/", "/gateway/"},
+		{"/gateway", "", "/gateway"},
+		{"/gateway", "api", "/gateway/api"},
+		{"/gateway", "/api?page=2#top", "/gateway/api?page=2#top"},
+		{"/gateway", "?page=2", "/gateway?page=2"},
+		{"/gateway", "/admin/", "/gateway/admin/"},
+		{"/edge/gateway", "/metrics", "/edge/gateway/metrics"},
+	} {
+		if got := Prefix(tt.prefix).Join(tt.ref); got != tt.want {
+			t.Errorf("Prefix(%q).Join(%q) = %q, want %q", tt.prefix, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestPrefix_Location(t *testing.T) {
+	for _, tt := range []struct {
+		prefix, loc, want string
+	}{
+		{"", "/login", "/login"},
+		{"/gateway", "/login", "/gateway/login"},
+		{"/gateway", "/", "/gateway/"},
+		{"/gateway", "/login?next=/orders", "/gateway/login?next=/orders"},
+		{"/gateway", "/gateway/login", "/gateway/login"},
+		{"/gateway", "/gateway?x=1", "/gateway?x=1"},
+		{"/gateway", "/gateways", "/gateway/gateways"},
+		{"/gateway", "https://example.com/login", "https://example.com/login"},
+		{"/gateway", "//example.com/login", "//example.com/login"},
+		{"/gateway", "login", "login"},
+		{"/gateway", "../login", "../login"},
+		{"/edge/gateway", "/edge/login", "/edge/gateway/edge/login"},
+	} {
+		if got := Prefix(tt.prefix).Location(tt.loc); got != tt.want {
+			t.Errorf("Prefix(%q).Location(%q) = %q, want %q", tt.prefix, tt.loc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+// setupAccessLog opens the access log file if one is configured. Without a
+// file, access lines go through the gateway logger.
+func (p *Proxy) setupAccessLog() error {
+	cfg := p.config.AccessLog
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.File == "" {
+		p.accessLog = p.logger
+		return nil
+	}
+	f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("access_log: %w", err)
+	}
+	p.accessLogFile = f
+	p.accessLog = slog.New(slog.NewJSONHandler(f, nil))
+	return nil
+}
+
+// countingBody counts the request body bytes read from the client.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// logAccess writes the access line for req. It runs after the response has
+// been copied, so the byte counts are final. Every field is always present
+// so log pipelines can rely on the set, except mode, which is added while
+// the gateway is not active.
+func (p *Proxy) logAccess(req *pipelineRequest, body *countingBody) {
+	if req.probe || rand.Float64() >= p.config.AccessLog.SampleRate {
+		return
+	}
+	r := req.r
+	var bytesIn int64
+	if body != nil {
+		bytesIn = body.n.Load()
+	}
+	var upstream string
+	if req.route != nil {
+		upstream = req.route.Upstream
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", requestIDFrom(r.Context())),
+		slog.String("client_ip", p.clientIP(r)),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("route", req.routeName),
+		slog.String("upstream", upstream),
+		slog.String("target", req.targetURL),
+		slog.Int("status", req.w.status),
+		slog.Int64("bytes_in", bytesIn),
+		slog.Int64("bytes_out", req.w.written),
+		slog.Float64("duration_ms", flightrecorder.Millis(time.Since(req.start))),
+		slog.String("api_key", req.apiKeyName),
+		slog.String("request_fingerprint", req.requestHash),
+	}
+	if mode := p.Mode(); mode != config.ModeActive {
+		attrs = append(attrs, slog.String("mode", mode))
+	}
+	p.accessLog.LogAttrs(context.Background(), slog.LevelInfo, "access", attrs...)
+}
+
+// countRequestBody wraps the body of r so logAccess can report how much of it
+// was read. Requests without a body are left alone.
+func countRequestBody(r *http.Request) *countingBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &countingBody{ReadCloser: r.Body}
+	r.Body = b
+	return b
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_AccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(w, "hello, world")
+	}))
+	defer backend.Close()
+
+	file := filepath.Join(t.TempDir(), "access.log")
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.AccessLog = config.AccessLogConfig{Enabled: true, File: file, SampleRate: 1}
+		cfg.APIKeys = []config.APIKey{{Key: "k1", Name: "mobile", Enabled: true}}
+	})
+
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader("12345"))
+	r.Header.Set("X-API-Key", "k1")
+	p.ServeHTTP(httptest.NewRecorder(), r)
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	lines := readAccessLog(t, file)
+	if len(lines) != 2 {
+		t.Fatalf("got %d access lines, want 2", len(lines))
+	}
+	want := map[string]any{
+		"msg":       "access",
+		"method":    "POST",
+		"path":      "/orders",
+		"route":     "test",
+		"upstream":  "backend",
+		"target":    backend.URL,
+		"status":    float64(200),
+		"bytes_in":  float64(5),
+		"bytes_out": float64(12),
+		"api_key":   "mobile",
+		"client_ip": "192.0.2.1",
+	}
+	for k, v := range want {
+		if lines[0][k] != v {
+			t.Errorf("%s = %v, want %v", k, lines[0][k], v)
+		}
+	}
+	if lines[0]["request_id"] == "" || lines[0]["duration_ms"] == nil {
+		t.Errorf("request_id or duration_ms missing: %v", lines[0])
+	}
+
+	// Every line carries the same fields, also for requests no route took.
+	for k := range lines[0] {
+		if _, ok := lines[1][k]; !ok {
+			t.Errorf("field %s missing from the unrouted request line", k)
+		}
+	}
+}
+
+func TestProxy_AccessLogSampling(t *testing.T) {
+	backend, _ := countingBackend(t)
+	file := filepath.Join(t.TempDir(), "access.log")
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.AccessLog = config.AccessLogConfig{Enabled: true, File: file, SampleRate: 0}
+	})
+	for i := 0; i < 10; i++ {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if lines := readAccessLog(t, file); len(lines) != 0 {
+		t.Errorf("got %d access lines with sample_rate 0", len(lines))
+	}
+}
+
+func readAccessLog(t *testing.T, file string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("invalid access line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,429 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+const (
+	defaultActivityFlushInterval = time.Minute
+	defaultArchivedStatus        = http.StatusGone
+	defaultIdleSince             = 30 * 24 * time.Hour
+)
+
+var errRouteArchived = errors.New("route is already archived")
+
+// routeActivity is the traffic a configured route has served over its
+// lifetime. Requests to the route while it is archived are not counted.
+type routeActivity struct {
+	lastRequest atomic.Int64 // Unix nanoseconds, 0 if never
+	requests    atomic.Int64
+	errors      atomic.Int64 // 5xx responses
+	archived    atomic.Pointer[archivedRoute]
+}
+
+// routeTotals is a snapshot of a route's activity.
+type routeTotals struct {
+	LastRequest time.Time `json:"last_request,omitzero"`
+	Requests    int64     `json:"requests"`
+	Errors      int64     `json:"errors"`
+}
+
+func (a *routeActivity) totals() routeTotals {
+	t := routeTotals{Requests: a.requests.Load(), Errors: a.errors.Load()}
+	if ns := a.lastRequest.Load(); ns != 0 {
+		t.LastRequest = time.Unix(0, ns).UTC()
+	}
+	return t
+}
+
+// archivedRoute is an archived route with its totals frozen when it was
+// archived. It is not modified once published.
+type archivedRoute struct {
+	Route      string      `json:"route"`
+	Owner      string      `json:"owner,omitempty"`
+	ArchivedAt time.Time   `json:"archived_at"`
+	ArchivedBy string      `json:"archived_by,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	Successor  string      `json:"successor,omitempty"` // sent in Link
+	Totals     routeTotals `json:"totals"`
+}
+
+// activityState is the content of route_activity.state_file.
+type activityState struct {
+	Since    time.Time                 `json:"since"`
+	Routes   map[string]routeTotals    `json:"routes"`
+	Archived map[string]*archivedRoute `json:"archived,omitempty"`
+}
+
+// activityTracker records route activity and keeps the archived routes.
+type activityTracker struct {
+	routes map[string]*routeActivity // by route name, fixed after New
+	since  time.Time                 // when tracking started, kept in the state file
+	now    func() time.Time
+	dirty  atomic.Bool // activity changed since the last state file write
+	stop   chan struct{}
+
+	mu sync.Mutex // serializes archival and state file writes
+	// retired holds the archived routes the configuration no longer has,
+	// kept from the state file so their totals stay retrievable.
+	retired map[string]*archivedRoute
+}
+
+// setupActivity starts tracking every configured route, from the totals
+// and archived routes the state file kept. A state file that cannot be
+// read stops the start rather than bring archived routes back.
+func (p *Proxy) setupActivity() error {
+	a := &activityTracker{
+		routes:  make(map[string]*routeActivity),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		retired: make(map[string]*archivedRoute),
+	}
+	p.activity = a
+	for _, r := range p.config.Routes {
+		a.routes[configRouteName(r)] = &routeActivity{}
+	}
+	a.since = a.now().UTC()
+
+	cfg := p.config.RouteActivity
+	if cfg.StateFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(cfg.StateFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("route_activity state_file: %w", err)
+	default:
+		var kept activityState
+		if err := json.Unmarshal(data, &kept); err != nil {
+			return fmt.Errorf("route_activity state_file %s: %w", cfg.StateFile, err)
+		}
+		if !kept.Since.IsZero() {
+			a.since = kept.Since
+		}
+		for name, totals := range kept.Routes {
+			if ra := a.routes[name]; ra != nil {
+				if !totals.LastRequest.IsZero() {
+					ra.lastRequest.Store(totals.LastRequest.UnixNano())
+				}
+				ra.requests.Store(totals.Requests)
+				ra.errors.Store(totals.Errors)
+			}
+		}
+		for name, archived := range kept.Archived {
+			if ra := a.routes[name]; ra != nil {
+				ra.archived.Store(archived)
+			} else {
+				a.retired[name] = archived
+			}
+		}
+	}
+
+	interval := cfg.FlushInterval
+	if interval == 0 {
+		interval = defaultActivityFlushInterval
+	}
+	go p.flushActivityLoop(interval)
+	return nil
+}
+
+// record counts a request a route served with status.
+func (a *activityTracker) record(route string, status int) {
+	ra := a.routes[route]
+	if ra == nil || ra.archived.Load() != nil {
+		return
+	}
+	ra.lastRequest.Store(a.now().UnixNano())
+	ra.requests.Add(1)
+	if status >= 500 {
+		ra.errors.Add(1)
+	}
+	a.dirty.Store(true)
+}
+
+// state returns the activity as kept in the state file. a.mu must be held.
+func (a *activityTracker) state() *activityState {
+	s := &activityState{
+		Since:    a.since,
+		Routes:   make(map[string]routeTotals, len(a.routes)),
+		Archived: make(map[string]*archivedRoute, len(a.retired)),
+	}
+	for name, archived := range a.retired {
+		s.Archived[name] = archived
+	}
+	for name, ra := range a.routes {
+		s.Routes[name] = ra.totals()
+		if archived := ra.archived.Load(); archived != nil {
+			s.Archived[name] = archived
+		}
+	}
+	return s
+}
+
+func (p *Proxy) flushActivityLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if p.activity.dirty.Swap(false) {
+				p.saveActivity()
+			}
+		case <-p.activity.stop:
+			return
+		}
+	}
+}
+
+// saveActivity writes the activity to the state file, if there is one.
+func (p *Proxy) saveActivity() {
+	path := p.config.RouteActivity.StateFile
+	if path == "" {
+		return
+	}
+	p.activity.mu.Lock()
+	defer p.activity.mu.Unlock()
+	if err := writeStateFile(path, p.activity.state()); err != nil {
+		p.logger.Error("route activity not saved", "path", path, "error", err)
+	}
+}
+
+// stopActivity stops the flush loop and saves the activity a last time.
+func (p *Proxy) stopActivity() {
+	close(p.activity.stop)
+	p.saveActivity()
+}
+
+// setArchived archives route, or restores it when archived is nil,
+// writing the change to the state file first so a restart keeps it.
+func (p *Proxy) setArchived(route string, archived *archivedRoute) error {
+	a := p.activity
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ra := a.routes[route]
+	if path := p.config.RouteActivity.StateFile; path != "" {
+		state := a.state()
+		if archived != nil {
+			state.Archived[route] = archived
+		} else {
+			delete(state.Archived, route)
+		}
+		if err := writeStateFile(path, state); err != nil {
+			return err
+		}
+	}
+	ra.archived.Store(archived)
+	return nil
+}
+
+// serveArchived answers a request to an archived route with
+// route_activity.archived_status and a Link to the route's successor, if
+// it has one, rather than let the request fall through to another route.
+func (p *Proxy) serveArchived(req *pipelineRequest) bool {
+	ra := p.activity.routes[req.routeName]
+	if ra == nil {
+		return false
+	}
+	archived := ra.archived.Load()
+	if archived == nil {
+		return false
+	}
+	status := p.config.RouteActivity.ArchivedStatus
+	if status == 0 {
+		status = defaultArchivedStatus
+	}
+	p.metrics.RecordError(req.routeName, "route_archived")
+	if archived.Successor != "" {
+		req.w.Header().Set("Link", "<"+p.basePath.Location(archived.Successor)+`>; rel="successor-version"`)
+	}
+	p.writeError(req.w, status, "route_archived", "route archived")
+	return true
+}
+
+// parseAge parses a duration that may also be given in whole days, such
+// as 30d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// idleRoute is a route that served no request since the cutoff.
+type idleRoute struct {
+	Route string `json:"route"`
+	Owner string `json:"owner,omitempty"`
+	routeTotals
+}
+
+// handleIdleRoutes lists the routes that served no request within since,
+// default 30d, least recently used first. Routes that never served one are
+// only listed once tracking has run for that long. Archived routes are
+// left out.
+func (p *Proxy) handleIdleRoutes(w http.ResponseWriter, r *http.Request) {
+	since := defaultIdleSince
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := parseAge(v)
+		if err != nil || d <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "since must be a positive duration such as 30d or 12h", "")
+			return
+		}
+		since = d
+	}
+	a := p.activity
+	cutoff := a.now().UTC().Add(-since)
+
+	routes := []idleRoute{}
+	for _, cr := range p.config.Routes {
+		name := configRouteName(cr)
+		ra := a.routes[name]
+		if ra.archived.Load() != nil {
+			continue
+		}
+		totals := ra.totals()
+		if totals.LastRequest.IsZero() && a.since.After(cutoff) {
+			continue
+		}
+		if totals.LastRequest.After(cutoff) {
+			continue
+		}
+		routes = append(routes, idleRoute{Route: name, Owner: cr.Owner, routeTotals: totals})
+	}
+	slices.SortStableFunc(routes, func(x, y idleRoute) int {
+		return x.LastRequest.Compare(y.LastRequest)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cutoff":         cutoff,
+		"tracking_since": a.since,
+		"routes":         routes,
+	})
+}
+
+// handleArchiveRoute archives a route: it stops proxying and answers
+// route_activity.archived_status, with its totals frozen. The optional JSON
+// body sets a reason and a successor URL sent in Link.
+func (p *Proxy) handleArchiveRoute(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("name")
+	ra := p.activity.routes[route]
+	if ra == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	var body struct {
+		Reason    string `json:"reason"`
+		Successor string `json:"successor"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "invalid archive settings: "+err.Error(), "")
+		return
+	}
+	if body.Successor != "" && !validSuccessor(body.Successor) {
+		writeJSONError(w, http.StatusBadRequest, "successor must be an absolute URL or path", "")
+		return
+	}
+	if ra.archived.Load() != nil {
+		writeJSONError(w, http.StatusConflict, errRouteArchived.Error(), "")
+		return
+	}
+
+	by := p.clientIP(r)
+	archived := &archivedRoute{
+		Route:      route,
+		Owner:      p.routeOwner(route),
+		ArchivedAt: p.activity.now().UTC(),
+		ArchivedBy: by,
+		Reason:     body.Reason,
+		Successor:  body.Successor,
+		Totals:     ra.totals(),
+	}
+	if err := p.setArchived(route, archived); err != nil {
+		p.logger.Error("route not archived", "route", route, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "archival could not be persisted", "")
+		return
+	}
+	p.logger.Warn("route archived", "route", route, "by", by, "reason", body.Reason)
+	p.publishArchive(route, "archived", by)
+	writeJSON(w, http.StatusOK, archived)
+}
+
+// validSuccessor reports whether s can be sent as a Link target.
+func validSuccessor(s string) bool {
+	if strings.ContainsAny(s, "<> \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.IsAbs() || strings.HasPrefix(s, "/"))
+}
+
+// handleRestoreRoute puts an archived route back into service. Its totals
+// carry on from where they were frozen.
+func (p *Proxy) handleRestoreRoute(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("name")
+	ra := p.activity.routes[route]
+	if ra == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	if ra.archived.Load() == nil {
+		writeJSONError(w, http.StatusNotFound, "route is not archived", "")
+		return
+	}
+	if err := p.setArchived(route, nil); err != nil {
+		p.logger.Error("route not restored", "route", route, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "restore could not be persisted", "")
+		return
+	}
+	by := p.clientIP(r)
+	p.logger.Warn("route restored", "route", route, "by", by)
+	p.publishArchive(route, "restored", by)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Proxy) publishArchive(route, action, by string) {
+	data := map[string]any{"route": route, "action": action, "by": by}
+	if owner := p.routeOwner(route); owner != "" {
+		data["owner"] = owner
+	}
+	p.events.Publish(events.RouteArchive, data)
+}
+
+// handleArchivedRoutes lists the archived routes with their frozen totals,
+// including those the configuration no longer has.
+func (p *Proxy) handleArchivedRoutes(w http.ResponseWriter, r *http.Request) {
+	a := p.activity
+	a.mu.Lock()
+	archived := make([]*archivedRoute, 0, len(a.retired))
+	for _, ar := range a.retired {
+		archived = append(archived, ar)
+	}
+	a.mu.Unlock()
+	for _, ra := range a.routes {
+		if ar := ra.archived.Load(); ar != nil {
+			archived = append(archived, ar)
+		}
+	}
+	slices.SortFunc(archived, func(x, y *archivedRoute) int {
+		return strings.Compare(x.Route, y.Route)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"routes": archived})
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func newActivityProxy(t *testing.T, backendURL string, mutate func(cfg *config.Config)) *Proxy {
+	t.Helper()
+	return newTestProxy(t, backendURL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "orders", Path: "/orders/**", Upstream: "backend", Owner: "checkout"},
+			{Name: "legacy", Path: "/legacy/**", Upstream: "backend"},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+		if mutate != nil {
+			mutate(cfg)
+		}
+	})
+}
+
+// idleRoutes returns the names /admin/routes/idle lists for since.
+func idleRoutes(t *testing.T, p *Proxy, since string) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/idle?since="+since, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("idle routes: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Routes []idleRoute `json:"routes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(body.Routes))
+	for i, r := range body.Routes {
+		names[i] = r.Route
+	}
+	return names
+}
+
+func TestProxy_IdleRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer backend.Close()
+	p := newActivityProxy(t, backend.URL, nil)
+
+	var mu sync.Mutex
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.activity.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	p.activity.since = clock
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+	send := func(path string) {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	send("/orders/1")
+	send("/orders/fail")
+	advance(10 * 24 * time.Hour)
+	send("/home")
+	advance(30 * 24 * time.Hour)
+
+	// legacy never served a request in the 40 days tracked; orders last
+	// did 40 days ago and test 30 days ago.
+	if got := idleRoutes(t, p, "30d"); strings.Join(got, ",") != "legacy,orders,test" {
+		t.Errorf("idle for 30d = %v, want legacy, orders, test", got)
+	}
+	if got := idleRoutes(t, p, "35d"); strings.Join(got, ",") != "legacy,orders" {
+		t.Errorf("idle for 35d = %v, want legacy, orders", got)
+	}
+	// Tracking has not run for 60 days, so legacy may have been used.
+	if got := idleRoutes(t, p, "60d"); len(got) != 0 {
+		t.Errorf("idle for 60d = %v, want none", got)
+	}
+	send("/orders/2")
+	if got := idleRoutes(t, p, "720h"); strings.Join(got, ",") != "legacy,test" {
+		t.Errorf("idle for 720h after a request = %v, want legacy, test", got)
+	}
+
+	advance(2 * 24 * time.Hour)
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/idle?since=1d", nil))
+	var body struct {
+		Routes []idleRoute `json:"routes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	orders := body.Routes[len(body.Routes)-1]
+	if orders.Route != "orders" || orders.Owner != "checkout" || orders.Requests != 3 || orders.Errors != 1 || !orders.LastRequest.Equal(clock.Add(-2*24*time.Hour)) {
+		t.Errorf("orders = %+v", orders)
+	}
+
+	for _, since := range []string{"soon", "-1d", "0s"} {
+		rec := httptest.NewRecorder()
+		p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/idle?since="+since, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("since=%s: status = %d, want 400", since, rec.Code)
+		}
+	}
+}
+
+func TestProxy_ArchiveRoute(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer backend.Close()
+	stateFile := filepath.Join(t.TempDir(), "activity.json")
+	p := newActivityProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RouteActivity.StateFile = stateFile
+	})
+	admin := p.AdminHandler()
+
+	for range 3 {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/legacy/a", nil))
+	}
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/routes/legacy/archive",
+		strings.NewReader(`{"reason": "replaced by v2", "successor": "https://api.example.com/v2/"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("archive: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// The archived route answers 410 rather than falling through to the
+	// catch-all, and no longer counts requests.
+	hits.Store(0)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/legacy/a", nil))
+	if rec.Code != http.StatusGone || hits.Load() != 0 {
+		t.Fatalf("archived route: status = %d after %d upstream hits, want 410 and none", rec.Code, hits.Load())
+	}
+	if got := rec.Header().Get("Link"); got != `<https://api.example.com/v2/>; rel="successor-version"` {
+		t.Errorf("Link = %q", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"route archived"`) {
+		t.Errorf("body = %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/routes/legacy/archive", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("second archive: status = %d, want 409", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/routes/orders/archive", strings.NewReader(`{"successor": "v2 docs"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid successor: status = %d, want 400", rec.Code)
+	}
+
+	// The archival and the frozen totals survive a restart, even once the
+	// route is gone from the configuration.
+	restarted := newActivityProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RouteActivity.StateFile = stateFile
+		cfg.RouteActivity.ArchivedStatus = http.StatusNotFound
+	})
+	rec = httptest.NewRecorder()
+	restarted.ServeHTTP(rec, httptest.NewRequest("GET", "/legacy/a", nil))
+	if rec.Code != http.StatusNotFound || hits.Load() != 0 {
+		t.Errorf("after restart: status = %d, want the configured 404", rec.Code)
+	}
+	removed := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RouteActivity.StateFile = stateFile
+	})
+	for _, px := range []*Proxy{restarted, removed} {
+		rec = httptest.NewRecorder()
+		px.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/archived", nil))
+		var body struct {
+			Routes []archivedRoute `json:"routes"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Routes) != 1 {
+			t.Fatalf("archived routes = %+v, want legacy", body.Routes)
+		}
+		ar := body.Routes[0]
+		if ar.Route != "legacy" || ar.Reason != "replaced by v2" || ar.Totals.Requests != 3 || ar.Totals.LastRequest.IsZero() {
+			t.Errorf("archived = %+v", ar)
+		}
+	}
+
+	// Restoring the route proxies it again.
+	rec = httptest.NewRecorder()
+	restarted.AdminHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/routes/legacy/archive", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("restore: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	restarted.ServeHTTP(rec, httptest.NewRequest("GET", "/legacy/a", nil))
+	if rec.Code != http.StatusOK || hits.Load() != 1 {
+		t.Errorf("restored route: status = %d after %d upstream hits, want 200 after 1", rec.Code, hits.Load())
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"cmp"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const (
+	defaultAdaptiveMinLimit     = 1
+	defaultAdaptiveMaxLimit     = 1000
+	defaultAdaptiveInitialLimit = 20
+	defaultAdaptiveTolerance    = 1.5
+
+	// adaptiveWindow is the fewest latency samples behind a limit update.
+	// Windows are at least as large as the limit, so the limit changes
+	// about once per round of requests.
+	adaptiveWindow = 10
+	// adaptiveSmoothing is the weight of a gradient update against the
+	// current limit; aimdBackoff the factor AIMD shrinks the limit by.
+	adaptiveSmoothing = 0.2
+	aimdBackoff       = 0.9
+)
+
+// adaptiveLimiter bounds the requests a route serves at once with a limit
+// that follows its latency. After each window of completed requests the
+// window's mean latency is compared with the baseline, the lowest window
+// mean seen: the gradient algorithm scales the limit by tolerance/ratio
+// and adds sqrt(limit) of headroom, so it settles where latency is
+// slightly inflated; AIMD adds one while latency is within tolerance and
+// backs off by 10% otherwise. Neither grows a limit the route is not
+// using.
+type adaptiveLimiter struct {
+	aimd      bool
+	minLimit  float64
+	maxLimit  float64
+	tolerance float64
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	baseline float64 // seconds, zero until the first window
+
+	// The current window.
+	sum     float64 // seconds
+	samples int
+	peak    int  // most requests in flight at once
+	dropped bool // a request failed for lack of upstream capacity
+}
+
+func newAdaptiveLimiter(cfg *config.AdaptiveConcurrency) *adaptiveLimiter {
+	l := &adaptiveLimiter{
+		aimd:      cfg.Algorithm == config.AdaptiveAIMD,
+		minLimit:  float64(cmp.Or(cfg.MinLimit, defaultAdaptiveMinLimit)),
+		maxLimit:  float64(cmp.Or(cfg.MaxLimit, defaultAdaptiveMaxLimit)),
+		tolerance: cfg.Tolerance,
+	}
+	if l.tolerance == 0 {
+		l.tolerance = defaultAdaptiveTolerance
+	}
+	l.maxLimit = math.Max(l.maxLimit, l.minLimit)
+	l.limit = l.clamp(float64(cmp.Or(cfg.InitialLimit, defaultAdaptiveInitialLimit)))
+	return l
+}
+
+func (l *adaptiveLimiter) clamp(limit float64) float64 {
+	return math.Min(math.Max(limit, l.minLimit), l.maxLimit)
+}
+
+// acquire takes a slot if fewer requests than the limit are in flight.
+func (l *adaptiveLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	l.peak = max(l.peak, l.inflight)
+	return true
+}
+
+// release frees a slot held for latency. dropped marks a request the
+// upstream failed for lack of capacity, which AIMD backs off from like
+// from inflated latency.
+func (l *adaptiveLimiter) release(latency time.Duration, dropped bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	l.sum += latency.Seconds()
+	l.samples++
+	l.dropped = l.dropped || dropped
+	if l.samples < max(adaptiveWindow, int(l.limit)) {
+		return
+	}
+
+	l.update(l.sum / float64(l.samples))
+	l.sum, l.samples, l.peak, l.dropped = 0, 0, l.inflight, false
+}
+
+// update adjusts the limit to a window with mean latency short.
+func (l *adaptiveLimiter) update(short float64) {
+	// At the floor the latency is not caused by load, so it becomes the
+	// baseline: a backend that got slower for good is not treated as
+	// overloaded forever.
+	if l.baseline == 0 || short < l.baseline || l.limit <= l.minLimit {
+		l.baseline = short
+	}
+	ratio := 1.0
+	if l.baseline > 0 {
+		ratio = short / l.baseline
+	}
+	// A window that used less than half the limit says nothing about a
+	// larger one.
+	used := float64(l.peak)*2 >= l.limit
+
+	next := l.limit
+	switch {
+	case l.aimd && (l.dropped || ratio > l.tolerance):
+		next = l.limit * aimdBackoff
+	case l.aimd:
+		if used {
+			next = l.limit + 1
+		}
+	default:
+		gradient := math.Max(0.5, math.Min(1, l.tolerance/ratio))
+		target := l.limit*gradient + math.Sqrt(l.limit)
+		if target > l.limit && !used {
+			target = l.limit
+		}
+		next = l.limit*(1-adaptiveSmoothing) + target*adaptiveSmoothing
+	}
+	l.limit = l.clamp(next)
+}
+
+// state returns the current limit and the requests in flight.
+func (l *adaptiveLimiter) state() (limit, inflight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit), l.inflight
+}
+
+// setupAdaptiveConcurrency creates the limiters of routes with
+// adaptive_concurrency.
+func (p *Proxy) setupAdaptiveConcurrency() {
+	p.adaptive = make(map[string]*adaptiveLimiter)
+	for _, r := range p.config.Routes {
+		if r.AdaptiveConcurrency != nil {
+			name := configRouteName(r)
+			l := newAdaptiveLimiter(r.AdaptiveConcurrency)
+			p.adaptive[name] = l
+			p.recordAdaptiveConcurrency(name, l)
+		}
+	}
+}
+
+func (p *Proxy) recordAdaptiveConcurrency(route string, l *adaptiveLimiter) {
+	limit, inflight := l.state()
+	p.metrics.RecordAdaptiveConcurrency(route, limit, inflight)
+}
+
+// adaptiveConcurrencyStage holds a slot of the route's adaptive limit
+// until the request is answered, feeding its duration back into the
+// limit. Requests over the limit are shed like over max_concurrent.
+func (p *Proxy) adaptiveConcurrencyStage(req *pipelineRequest, l *adaptiveLimiter) bool {
+	if !l.acquire() {
+		p.shedConcurrency(req, "adaptive_limit")
+		return false
+	}
+	p.recordAdaptiveConcurrency(req.routeName, l)
+	req.onDone(func(status int) {
+		dropped := status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+		l.release(time.Since(req.start), dropped)
+		p.recordAdaptiveConcurrency(req.routeName, l)
+	})
+	return true
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// simulateLoad drives l with more demand than the backend can take for
+// ticks rounds. Each round fills the limit and completes every request with
+// a latency that grows once more than capacity requests are in flight, like
+// a backend queueing work. It returns the limit after each round.
+func simulateLoad(l *adaptiveLimiter, capacity func(tick int) int, ticks int) []int {
+	const base = 10 * time.Millisecond
+	limits := make([]int, ticks)
+	for tick := range ticks {
+		n := 0
+		for n < 500 && l.acquire() {
+			n++
+		}
+		latency := base
+		if c := capacity(tick); n > c {
+			latency = time.Duration(float64(base) * float64(n) / float64(c))
+		}
+		for range n {
+			l.release(latency, false)
+		}
+		limits[tick], _ = l.state()
+	}
+	return limits
+}
+
+func TestAdaptiveLimiter_Converges(t *testing.T) {
+	for _, algorithm := range []string{config.AdaptiveGradient, config.AdaptiveAIMD} {
+		t.Run(algorithm, func(t *testing.T) {
+			l := newAdaptiveLimiter(&config.AdaptiveConcurrency{Algorithm: algorithm})
+			// The backend handles 50 requests at once, then degrades to 20,
+			// then recovers.
+			capacity := func(tick int) int {
+				if tick >= 300 && tick < 600 {
+					return 20
+				}
+				return 50
+			}
+			limits := simulateLoad(l, capacity, 900)
+
+			for _, phase := range []struct {
+				name     string
+				end      int
+				capacity int
+			}{
+				{"healthy", 300, 50},
+				{"degraded", 600, 20},
+				{"recovered", 900, 50},
+			} {
+				// The last 50 rounds of each phase stay near the capacity:
+				// above it by at most the tolerance and headroom, and never
+				// down at the floor.
+				lo, hi := math.MaxInt, 0
+				for _, limit := range limits[phase.end-50 : phase.end] {
+					lo, hi = min(lo, limit), max(hi, limit)
+				}
+				if lo < phase.capacity/2 || hi > phase.capacity*2 {
+					t.Errorf("%s: limit ranged %d-%d, want it near the capacity of %d", phase.name, lo, hi, phase.capacity)
+				}
+			}
+		})
+	}
+}
+
+func TestAdaptiveLimiter_Bounds(t *testing.T) {
+	l := newAdaptiveLimiter(&config.AdaptiveConcurrency{MinLimit: 5, MaxLimit: 30, InitialLimit: 100})
+	if limit, _ := l.state(); limit != 30 {
+		t.Fatalf("initial limit = %d, want it clamped to 30", limit)
+	}
+
+	// Plenty of capacity: the limit probes up to the ceiling.
+	limits := simulateLoad(l, func(int) int { return 1000 }, 100)
+	if got := limits[len(limits)-1]; got != 30 {
+		t.Errorf("limit = %d with spare capacity, want the ceiling of 30", got)
+	}
+
+	// A backend slower at any load than the baseline: the limit drops to
+	// the floor, then recovers once the slower latency becomes the baseline.
+	for range adaptiveWindow * 20 {
+		l.acquire()
+		l.release(time.Second, false)
+	}
+	limits = simulateLoad(l, func(int) int { return 1000 }, 100)
+	if got := limits[len(limits)-1]; got != 30 {
+		t.Errorf("limit = %d after the backend settled, want the ceiling of 30", got)
+	}
+}
+
+func TestAdaptiveLimiter_IdleRouteDoesNotGrow(t *testing.T) {
+	l := newAdaptiveLimiter(&config.AdaptiveConcurrency{InitialLimit: 20})
+	for range adaptiveWindow * 50 {
+		l.acquire()
+		l.release(time.Millisecond, false)
+	}
+	if limit, _ := l.state(); limit != 20 {
+		t.Errorf("limit = %d after serving one request at a time, want 20", limit)
+	}
+}
+
+func TestAdaptiveLimiter_AIMDBacksOffOnDrops(t *testing.T) {
+	l := newAdaptiveLimiter(&config.AdaptiveConcurrency{Algorithm: config.AdaptiveAIMD, InitialLimit: adaptiveWindow})
+	for range adaptiveWindow {
+		l.acquire()
+	}
+	for range adaptiveWindow {
+		l.release(time.Millisecond, true)
+	}
+	if limit, _ := l.state(); limit != 9 {
+		t.Errorf("limit = %d after a window with upstream timeouts, want 9", limit)
+	}
+}
+
+func TestProxy_AdaptiveConcurrencySheds(t *testing.T) {
+	srv, arrived, release := blockingBackend(t)
+	defer close(release)
+	p := newTestProxy(t, srv.URL, func(cfg *config.Config) {
+		cfg.Routes[0].AdaptiveConcurrency = &config.AdaptiveConcurrency{MinLimit: 1, MaxLimit: 1}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-arrived
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("status = %d, Retry-After = %q, want 503 with Retry-After: 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_adaptive_concurrency_limit{route="test"} 1`,
+		`gateway_adaptive_concurrency_in_flight{route="test"} 1`,
+		`gateway_requests_shed_total{key="test_adaptive_limit"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	release <- struct{}{}
+	<-done
+}
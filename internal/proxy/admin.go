@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// eventBuffer is the per-subscriber buffer of the event stream. A
+// dashboard that falls further behind loses events rather than slowing
+// the gateway down.
+const eventBuffer = 64
+
+// eventKeepalive is how often an idle event stream sends a comment so
+// intermediaries don't close it.
+const eventKeepalive = 15 * time.Second
+
+// AdminHandler serves the admin API under /admin/.
+func (p *Proxy) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/requests", p.handleListRequests)
+	mux.HandleFunc("GET /admin/requests/{request_id}", p.handleGetRequest)
+	mux.HandleFunc("GET /admin/events", p.handleEvents)
+	mux.HandleFunc("GET /admin/descriptor", p.handleDescriptor)
+	mux.HandleFunc("GET /admin/routes/test", p.handleRouteTest)
+	mux.HandleFunc("GET /admin/routes/order", p.handleRouteOrder)
+	mux.HandleFunc("POST /admin/routes/{name}/debug", p.handleStartRouteDebug)
+	mux.HandleFunc("DELETE /admin/routes/{name}/debug", p.handleStopRouteDebug)
+	mux.HandleFunc("GET /admin/maintenance", p.handleListMaintenance)
+	mux.HandleFunc("PUT /admin/routes/{name}/maintenance", p.handleStartMaintenance)
+	mux.HandleFunc("DELETE /admin/routes/{name}/maintenance", p.handleStopMaintenance)
+	mux.HandleFunc("GET /admin/routes/idle", p.handleIdleRoutes)
+	mux.HandleFunc("GET /admin/routes/disabled", p.handleListDisabledRoutes)
+	mux.HandleFunc("POST /admin/routes/{name}/enable", p.handleEnableRoute)
+	mux.HandleFunc("POST /admin/routes/{name}/disable", p.handleDisableRoute)
+	mux.HandleFunc("GET /admin/routes/archived", p.handleArchivedRoutes)
+	mux.HandleFunc("POST /admin/routes/{name}/archive", p.handleArchiveRoute)
+	mux.HandleFunc("DELETE /admin/routes/{name}/archive", p.handleRestoreRoute)
+	mux.HandleFunc("GET /admin/mode", p.handleGetMode)
+	mux.HandleFunc("PUT /admin/mode", p.handleSetMode)
+	mux.HandleFunc("GET /admin/inflight", p.handleListInflight)
+	mux.HandleFunc("POST /admin/inflight/{id}/cancel", p.handleCancelInflight)
+	mux.HandleFunc("GET /admin/effective-limits", p.handleEffectiveLimits)
+	mux.HandleFunc("GET /admin/upstreams", p.handleUpstreams)
+	mux.HandleFunc("POST /admin/config/preview", p.handleConfigPreview)
+	mux.HandleFunc("GET /admin/config/history", p.handleConfigHistory)
+	mux.HandleFunc("GET /admin/body-limits", p.handleBodyLimits)
+	mux.HandleFunc("DELETE /admin/body-limits/{route}", p.handleResetBodyLimit)
+	mux.HandleFunc("POST /admin/upstreams/{name}/targets/{url}/report", p.handleTargetReport)
+	return mux
+}
+
+func (p *Proxy) handleGetRequest(w http.ResponseWriter, r *http.Request) {
+	if p.recorder == nil {
+		writeJSONError(w, http.StatusNotFound, "flight recorder is disabled", "")
+		return
+	}
+	entry, ok := p.recorder.Get(r.PathValue("request_id"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "request not recorded", "")
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func (p *Proxy) handleListRequests(w http.ResponseWriter, r *http.Request) {
+	if p.recorder == nil {
+		writeJSONError(w, http.StatusNotFound, "flight recorder is disabled", "")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := flightrecorder.Filter{Route: q.Get("route"), Limit: 100}
+	if v := q.Get("min_duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid min_duration", "")
+			return
+		}
+		filter.MinDuration = d
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit", "")
+			return
+		}
+		filter.Limit = n
+	}
+
+	writeJSON(w, http.StatusOK, p.recorder.Query(filter))
+}
+
+// handleEvents streams gateway events as Server-Sent Events. The types
+// query parameter takes a comma-separated list of event types to receive.
+func (p *Proxy) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types []string
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	sub := p.events.Subscribe(types, eventBuffer)
+	defer p.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, _ = fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-sub.C:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// RouteOrder lists the routes in the order requests try them.
+func (p *Proxy) RouteOrder() []router.Step {
+	return p.router.Order()
+}
+
+// RouteConflicts lists the routes that never match on a host because a
+// route tried before them takes all of their requests. New has already
+// rejected duplicates, so only shadowed routes remain.
+func (p *Proxy) RouteConflicts() []router.Conflict {
+	return p.router.Conflicts()
+}
+
+// handleRouteOrder lists the routes in the order requests try them, with
+// the explicit and computed priorities that put them there.
+func (p *Proxy) handleRouteOrder(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"routes": p.RouteOrder()})
+}
+
+// handleRouteTest reports how a request with the given method, host and
+// path would be routed, listing every route tried on the way.
+func (p *Proxy) handleRouteTest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	method := strings.ToUpper(q.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := q.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		writeJSONError(w, http.StatusBadRequest, "path must start with /", "")
+		return
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, path, nil)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request", "")
+		return
+	}
+	req.Host = q.Get("host")
+	switch err := p.routingPath(req.URL); {
+	case errors.Is(err, errOutsideBasePath):
+		status := p.config.Server.OutsideBasePathStatus
+		if status == 0 {
+			status = defaultOutsideBasePathStatus
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"method": method, "host": req.Host, "path": path, "status": status})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusOK, map[string]any{"method": method, "host": req.Host, "path": path, "status": http.StatusBadRequest})
+		return
+	}
+
+	match := p.router.Explain(req)
+	resp := map[string]any{
+		"method": method,
+		"host":   req.Host,
+		"path":   path,
+		"trace":  match.Trace,
+	}
+	switch {
+	case match.Route != nil && p.methodDenied(match.Route, mappedMethod(match.Route, match.Method)):
+		resp["status"] = http.StatusMethodNotAllowed
+		resp["route"] = routeNameOf(match.Route)
+		resp["allow"] = p.allowedMethods(match.Route)
+		resp["denied"] = true
+	case match.Route != nil:
+		resp["status"] = http.StatusOK
+		resp["route"] = routeNameOf(match.Route)
+		resp["upstream"] = match.Route.Upstream
+		if match.Route.Owner != "" {
+			resp["owner"] = match.Route.Owner
+		}
+	case match.MethodMismatch != nil:
+		resp["status"] = http.StatusMethodNotAllowed
+		resp["route"] = routeNameOf(match.MethodMismatch)
+		resp["allow"] = p.allowedMethods(match.MethodMismatch)
+	default:
+		resp["status"] = http.StatusNotFound
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
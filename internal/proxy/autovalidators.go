@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const (
+	defaultValidatorTTL         = 60 * time.Second
+	defaultValidatorMaxBodySize = 1 << 20
+	defaultValidatorMaxEntries  = 10000
+)
+
+// validatorCache remembers the validators the gateway computed for one
+// route's responses, so a client revalidating one can be answered with 304.
+// It holds hashes only, never bodies.
+type validatorCache struct {
+	ttl         time.Duration
+	maxBodySize int64
+	maxEntries  int
+	now         func() time.Time // replaced in tests
+
+	mu      sync.Mutex
+	lru     *list.List // of *validatorEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type validatorEntry struct {
+	key          string
+	etag         string
+	lastModified string
+	expires      time.Time
+}
+
+func newValidatorCache(cfg *config.AutoValidators) *validatorCache {
+	c := &validatorCache{
+		ttl:         cfg.TTL,
+		maxBodySize: cfg.MaxBodySize,
+		maxEntries:  cfg.MaxEntries,
+		now:         time.Now,
+		lru:         list.New(),
+		entries:     make(map[string]*list.Element),
+	}
+	if c.ttl == 0 {
+		c.ttl = defaultValidatorTTL
+	}
+	if c.maxBodySize == 0 {
+		c.maxBodySize = defaultValidatorMaxBodySize
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = defaultValidatorMaxEntries
+	}
+	return c
+}
+
+// validatorKey identifies the resource a validator belongs to.
+func validatorKey(r *http.Request, route *router.Route) string {
+	return cachePath(r, route) + "?" + r.URL.RawQuery
+}
+
+// get returns the fresh validator for key. Expired ones are dropped.
+func (c *validatorCache) get(key string) (*validatorEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*validatorEntry)
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *validatorCache) put(e *validatorEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		c.lru.Remove(el)
+	}
+	for c.lru.Len() >= c.maxEntries {
+		old := c.lru.Remove(c.lru.Back()).(*validatorEntry)
+		delete(c.entries, old.key)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+}
+
+// validatorsStage answers a revalidation with 304 when the client's
+// If-None-Match names the validator the gateway last computed for the
+// resource and it is still fresh. The upstream is not contacted.
+func (p *Proxy) validatorsStage(req *pipelineRequest) bool {
+	c, ok := p.autoValidators[req.route.AutoValidators]
+	r := req.r
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return true
+	}
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return true
+	}
+	e, ok := c.get(validatorKey(r, req.route))
+	if !ok || !etagMatches(ifNoneMatch, e.etag) {
+		return true
+	}
+
+	p.metrics.RecordValidatorOperation(req.routeName, "not_modified")
+	req.w.Header().Set("ETag", e.etag)
+	req.w.Header().Set("Last-Modified", e.lastModified)
+	req.w.WriteHeader(http.StatusNotModified)
+	p.metrics.RecordRequest(req.routeName, r.Method, http.StatusNotModified, time.Since(req.start))
+	return false
+}
+
+// etagMatches applies the weak comparison of RFC 9110 section 13.1.2 to an
+// If-None-Match list, since compression turns the gateway's strong ETags
+// into weak ones.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// injectValidators gives a 200 response to GET an ETag and Last-Modified
+// when the route has auto_validators and the upstream set no ETag. The body
+// is hashed as it is read, up to max_body_size, and then relayed from the
+// buffer; larger bodies are relayed unchanged without validators. It must
+// be called before anything else wraps resp.Body.
+func (p *Proxy) injectValidators(r *http.Request, route *router.Route, resp *http.Response) {
+	c, ok := p.autoValidators[route.AutoValidators]
+	if !ok || r.Method != http.MethodGet || resp.StatusCode != http.StatusOK {
+		return
+	}
+	// Validators of personalized or per-variant responses could answer one
+	// client's revalidation with another's, so those are left alone.
+	if resp.Header.Get("ETag") != "" || !storable(resp) || variesBeyondEncoding(resp.Header) {
+		return
+	}
+	routeName := routeNameOf(route)
+	if resp.ContentLength > c.maxBodySize {
+		p.metrics.RecordValidatorOperation(routeName, "too_large")
+		return
+	}
+
+	var buf bytes.Buffer
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(&buf, hash), io.LimitReader(resp.Body, c.maxBodySize+1))
+	if err != nil || n > c.maxBodySize {
+		// Relay what was read followed by the rest, or the read error.
+		resp.Body = &multiReadCloser{Reader: io.MultiReader(&buf, resp.Body), closer: resp.Body}
+		if err == nil {
+			p.metrics.RecordValidatorOperation(routeName, "too_large")
+		}
+		return
+	}
+	resp.Body = &multiReadCloser{Reader: &buf, closer: resp.Body}
+
+	now := c.now()
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	resp.Header.Set("ETag", etag)
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified == "" {
+		lastModified = now.UTC().Format(http.TimeFormat)
+		if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+			lastModified = date.UTC().Format(http.TimeFormat)
+		}
+		resp.Header.Set("Last-Modified", lastModified)
+	}
+
+	c.put(&validatorEntry{
+		key:          validatorKey(r, route),
+		etag:         etag,
+		lastModified: lastModified,
+		expires:      now.Add(c.ttl),
+	})
+	p.metrics.RecordValidatorOperation(routeName, "inject")
+}
+
+// variesBeyondEncoding reports whether the response differs by request
+// headers other than Accept-Encoding.
+func variesBeyondEncoding(h http.Header) bool {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.EqualFold(name, "Accept-Encoding") {
+				return true
+			}
+		}
+	}
+	return false
+}
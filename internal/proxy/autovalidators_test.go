@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_AutoValidators(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/tagged":
+			w.Header().Set("ETag", `"upstream"`)
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat("x", 128)))
+			return
+		}
+		w.Header().Set("Date", "Mon, 05 Jan 2026 10:00:00 GMT")
+		_, _ = w.Write([]byte("catalog"))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].AutoValidators = &config.AutoValidators{TTL: time.Minute, MaxBodySize: 64}
+	})
+	c := p.autoValidators[p.config.Routes[0].AutoValidators]
+	now := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("/items", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != "catalog" || etag == "" {
+		t.Fatalf("first response = %d %q, ETag %q", first.Code, first.Body.String(), etag)
+	}
+	if got := first.Header().Get("Last-Modified"); got != "Mon, 05 Jan 2026 10:00:00 GMT" {
+		t.Errorf("Last-Modified = %q, want the upstream Date", got)
+	}
+
+	rec := get("/items", `"other", W/`+etag)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("revalidation = %d %q, want an empty 304", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("ETag") != etag {
+		t.Errorf("304 ETag = %q, want %q", rec.Header().Get("ETag"), etag)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want the 304 answered by the gateway", hits.Load())
+	}
+
+	if rec := get("/items", `"other"`); rec.Code != http.StatusOK {
+		t.Errorf("mismatched If-None-Match: status = %d, want 200", rec.Code)
+	}
+	if rec := get("/items?page=2", etag); rec.Code != http.StatusOK {
+		t.Errorf("other query: status = %d, want 200", rec.Code)
+	}
+
+	// Once the TTL passes the request goes upstream again.
+	now = now.Add(2 * time.Minute)
+	hits.Store(0)
+	if rec := get("/items", etag); rec.Code != http.StatusOK || hits.Load() != 1 {
+		t.Errorf("after TTL: status = %d, upstream hits = %d, want 200 from upstream", rec.Code, hits.Load())
+	}
+
+	if rec := get("/tagged", ""); rec.Header().Get("ETag") != `"upstream"` || rec.Header().Get("Last-Modified") != "" {
+		t.Errorf("upstream validators were replaced: ETag %q, Last-Modified %q", rec.Header().Get("ETag"), rec.Header().Get("Last-Modified"))
+	}
+	if rec := get("/large", ""); rec.Body.Len() != 128 || rec.Header().Get("ETag") != "" {
+		t.Errorf("large body: %d bytes, ETag %q, want it relayed without validators", rec.Body.Len(), rec.Header().Get("ETag"))
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_validator_operations_total{key="test_not_modified"} 1`,
+		`gateway_validator_operations_total{key="test_too_large"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestValidatorCache_Eviction(t *testing.T) {
+	c := newValidatorCache(&config.AutoValidators{MaxEntries: 2})
+	for _, key := range []string{"a", "b", "c"} {
+		c.put(&validatorEntry{key: key, etag: `"` + key + `"`, expires: time.Now().Add(time.Minute)})
+	}
+	if _, ok := c.get("a"); ok {
+		t.Error("oldest validator was not evicted")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Error("newest validator missing")
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/relaypoint/relaypoint/internal/pathprefix"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// defaultOutsideBasePathStatus answers requests outside server.base_path
+// when outside_base_path_status is not set.
+const defaultOutsideBasePathStatus = http.StatusNotFound
+
+var errOutsideBasePath = errors.New("request path is outside the base path")
+
+// BasePathHandler serves next under server.base_path: the base path is
+// removed from requests before next sees them, and requests outside it are
+// answered with outside_base_path_status. Everything the gateway serves on
+// its listeners, the proxy, probes and the admin API, goes through it.
+// Without a base path, next is returned as is.
+func (p *Proxy) BasePathHandler(next http.Handler) http.Handler {
+	if p.basePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		switch err := p.routingPath(&u); {
+		case errors.Is(err, router.ErrPathEscapesRoot):
+			p.metrics.RecordError("unknown", "invalid_path")
+			p.writeError(w, http.StatusBadRequest, "invalid_path", "request path escapes the root")
+			return
+		case err != nil:
+			status := p.config.Server.OutsideBasePathStatus
+			if status == 0 {
+				status = defaultOutsideBasePathStatus
+			}
+			p.metrics.RecordError("unknown", "outside_base_path")
+			p.writeError(w, status, "outside_base_path", "request path is outside the gateway's base path")
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// routingPath turns u's path as a client sends it into the one routes are
+// matched against: normalized, then without the base path.
+func (p *Proxy) routingPath(u *url.URL) error {
+	if err := router.NormalizePath(u, p.rawPaths()); err != nil {
+		return err
+	}
+	if !p.basePath.StripURL(u) {
+		return errOutsideBasePath
+	}
+	return nil
+}
+
+// forwardedPrefix returns the X-Forwarded-Prefix for a request to route:
+// the base path and the prefix the route strips, which together are what
+// the upstream does not see of the client's path. The path a route
+// rewrites or builds from upstream_path has no such prefix, so only the
+// base path is sent for it. A trusted proxy's own prefix goes in front.
+func (p *Proxy) forwardedPrefix(r *http.Request, route *router.Route, trusted bool) string {
+	prefix := string(p.basePath)
+	if route.Rewrite == nil && route.UpstreamPath == "" {
+		prefix = p.basePath.Join(string(route.PathPrefix()))
+	}
+	if outer := r.Header.Get("X-Forwarded-Prefix"); trusted && outer != "" {
+		prefix = pathprefix.New(outer).Join(prefix)
+	}
+	return prefix
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_BasePath(t *testing.T) {
+	type seen struct{ path, prefix string }
+	upstream := make(chan seen, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream <- seen{r.URL.Path, r.Header.Get("X-Forwarded-Prefix")}
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/login?next=/orders", http.StatusFound)
+		case "/prefixed":
+			http.Redirect(w, r, "/gateway/login", http.StatusFound)
+		case "/elsewhere":
+			http.Redirect(w, r, "https://login.example.com/", http.StatusFound)
+		}
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.BasePath = "/gateway/"
+		cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+		cfg.Routes = []config.Route{
+			{Name: "api", Path: "/api/**", Upstream: "backend", StripPath: true},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+	})
+	h := p.BasePathHandler(p)
+
+	tests := []struct {
+		name, path   string
+		remote       string
+		outerPrefix  string
+		status       int
+		upstream     seen
+		wantLocation string
+	}{
+		{name: "strip_path route", path: "/gateway/api/users/1", status: 200, upstream: seen{"/users/1", "/gateway/api"}},
+		{name: "route prefix only", path: "/gateway/api/", status: 200, upstream: seen{"/", "/gateway/api"}},
+		{name: "base path without trailing slash", path: "/gateway", status: 200, upstream: seen{"/", "/gateway"}},
+		{name: "duplicate slashes", path: "/gateway//users", status: 200, upstream: seen{"/users", "/gateway"}},
+		{name: "trusted proxy prefix", path: "/gateway/api/users", remote: "10.0.0.2:1234", outerPrefix: "/edge/",
+			status: 200, upstream: seen{"/users", "/edge/gateway/api"}},
+		{name: "untrusted prefix", path: "/gateway/users", outerPrefix: "/evil", status: 200, upstream: seen{"/users", "/gateway"}},
+		{name: "redirect", path: "/gateway/redirect", status: 302, upstream: seen{"/redirect", "/gateway"},
+			wantLocation: "/gateway/login?next=/orders"},
+		{name: "redirect under the base path", path: "/gateway/prefixed", status: 302, upstream: seen{"/prefixed", "/gateway"},
+			wantLocation: "/gateway/login"},
+		{name: "absolute redirect", path: "/gateway/elsewhere", status: 302, upstream: seen{"/elsewhere", "/gateway"},
+			wantLocation: "https://login.example.com/"},
+		{name: "outside", path: "/users", status: 404},
+		{name: "sibling path", path: "/gateways/users", status: 404},
+		{name: "dot segments leaving the base path", path: "/gateway/../users", status: 404},
+		{name: "escaping the root", path: "/gateway/%2e%2e/%2e%2e/etc/passwd", status: 400},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.remote != "" {
+			req.RemoteAddr = tt.remote
+		}
+		if tt.outerPrefix != "" {
+			req.Header.Set("X-Forwarded-Prefix", tt.outerPrefix)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		if tt.upstream.path == "" {
+			select {
+			case got := <-upstream:
+				t.Errorf("%s: upstream saw %s", tt.name, got.path)
+			default:
+			}
+			continue
+		}
+		if got := <-upstream; got != tt.upstream {
+			t.Errorf("%s: upstream saw %+v, want %+v", tt.name, got, tt.upstream)
+		}
+		if got := rec.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("%s: Location = %q, want %q", tt.name, got, tt.wantLocation)
+		}
+	}
+}
+
+func TestProxy_BasePathEndpoints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.BasePath = "/edge/gateway"
+		cfg.Server.OutsideBasePathStatus = http.StatusMisdirectedRequest
+	})
+	admin := p.BasePathHandler(p.AdminHandler())
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/edge/gateway/admin/routes/test?path=/edge/gateway/users", nil))
+	var body struct {
+		Route  string `json:"route"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("route test: %v: %s", err, rec.Body)
+	}
+	if body.Route != "test" || body.Status != http.StatusOK {
+		t.Errorf("route test under the base path = %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/edge/gateway/admin/routes/test?path=/users", nil))
+	body.Route, body.Status = "", 0
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Route != "" || body.Status != http.StatusMisdirectedRequest {
+		t.Errorf("route test outside the base path = %+v", body)
+	}
+
+	// The admin API itself is only served under the base path.
+	for _, path := range []string{"/admin/routes/test?path=/users", "/edge/admin/routes/test?path=/users"} {
+		rec = httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusMisdirectedRequest {
+			t.Errorf("GET %s: status = %d, want 421", path, rec.Code)
+		}
+	}
+	if p.RouteFor(httptest.NewRequest("GET", "/edge/gateway/users", nil)) != "test" || p.RouteFor(httptest.NewRequest("GET", "/users", nil)) != "" {
+		t.Error("RouteFor does not take the base path into account")
+	}
+}
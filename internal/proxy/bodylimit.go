@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const (
+	defaultBodyLimitRejections = 3
+	defaultBodyLimitWindow     = 5 * time.Minute
+	defaultBodyLimitReprobe    = 10 * time.Minute
+)
+
+// bodyLimitLearner infers the largest body a route's upstream accepts from
+// the sizes it answered 413 to. Once enough larger bodies were rejected
+// within the window, larger requests are rejected at the gateway, except
+// for one per reprobe interval which is let through to see whether the
+// upstream changed.
+type bodyLimitLearner struct {
+	route      string
+	rejections int
+	window     time.Duration
+	reprobe    time.Duration
+	// ceiling is the route's max_body_size; a learned limit never exceeds
+	// it. Zero means none.
+	ceiling int64
+	metrics *metrics.Metrics
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	rejected  []rejectedBody // 413s within the window, oldest first
+	accepted  int64          // largest body the upstream accepted
+	limit     int64          // learned limit in bytes, 0 while none
+	learnedAt time.Time
+	lastProbe time.Time
+}
+
+type rejectedBody struct {
+	at   time.Time
+	size int64
+}
+
+func newBodyLimitLearner(route string, cfg *config.BodyLimitLearning, ceiling int64, m *metrics.Metrics, logger *slog.Logger) *bodyLimitLearner {
+	l := &bodyLimitLearner{
+		route:      route,
+		rejections: cfg.Rejections,
+		window:     cfg.Window,
+		reprobe:    cfg.Reprobe,
+		ceiling:    ceiling,
+		metrics:    m,
+		logger:     logger,
+	}
+	if l.rejections == 0 {
+		l.rejections = defaultBodyLimitRejections
+	}
+	if l.window == 0 {
+		l.window = defaultBodyLimitWindow
+	}
+	if l.reprobe == 0 {
+		l.reprobe = defaultBodyLimitReprobe
+	}
+	return l
+}
+
+// setupBodyLimits creates a learner for every route with learn_body_limit.
+func (p *Proxy) setupBodyLimits() {
+	p.bodyLimits = make(map[*config.BodyLimitLearning]*bodyLimitLearner)
+	for _, route := range p.config.Routes {
+		if route.LearnBodyLimit == nil {
+			continue
+		}
+		name, ceiling := route.Name, route.MaxBodySize
+		if name == "" {
+			name = route.Path
+		}
+		if ceiling == 0 {
+			ceiling = p.config.Server.MaxBodySize
+		}
+		p.bodyLimits[route.LearnBodyLimit] = newBodyLimitLearner(name, route.LearnBodyLimit, ceiling, p.metrics, p.logger)
+	}
+}
+
+// allow reports whether a body of size may be sent upstream, and otherwise
+// the learned limit it exceeds.
+func (l *bodyLimitLearner) allow(size int64, now time.Time) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit == 0 || size <= l.limit {
+		return 0, true
+	}
+	if now.Sub(l.lastProbe) >= l.reprobe {
+		l.lastProbe = now
+		return 0, true
+	}
+	return l.limit, false
+}
+
+// observe records how the upstream answered a body of size.
+func (l *bodyLimitLearner) observe(size int64, status int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if status != http.StatusRequestEntityTooLarge {
+		l.accepted = max(l.accepted, size)
+		// Rejections at or below an accepted size were not about size.
+		kept := l.rejected[:0]
+		for _, r := range l.rejected {
+			if r.size > size {
+				kept = append(kept, r)
+			}
+		}
+		l.rejected = kept
+		if l.limit > 0 && size > l.limit {
+			l.logger.Info("upstream body limit lifted", "route", l.route, "limit", l.limit, "accepted", size)
+			l.setLimit(0)
+		}
+		return
+	}
+
+	cutoff := now.Add(-l.window)
+	kept := l.rejected[:0]
+	for _, r := range l.rejected {
+		if r.at.After(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	l.rejected = append(kept, rejectedBody{at: now, size: size})
+
+	if l.limit > 0 {
+		// A re-probe was rejected again, or a body under the limit was.
+		l.learnedAt = now
+		if size <= l.limit {
+			l.setLimit(max(size-1, l.accepted))
+		}
+		return
+	}
+	if len(l.rejected) < l.rejections {
+		return
+	}
+	smallest := l.rejected[0].size
+	for _, r := range l.rejected[1:] {
+		smallest = min(smallest, r.size)
+	}
+	limit := max(smallest-1, l.accepted)
+	if l.ceiling > 0 {
+		limit = min(limit, l.ceiling)
+	}
+	l.learnedAt = now
+	l.lastProbe = now
+	l.setLimit(limit)
+	l.logger.Warn("learned upstream body limit", "route", l.route, "limit", limit, "rejections", len(l.rejected))
+}
+
+func (l *bodyLimitLearner) setLimit(limit int64) {
+	l.limit = limit
+	l.metrics.RecordLearnedBodyLimit(l.route, limit)
+}
+
+// reset forgets the learned limit and the responses it was learned from.
+func (l *bodyLimitLearner) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejected = nil
+	l.accepted = 0
+	l.setLimit(0)
+}
+
+type learnedBodyLimit struct {
+	Route      string     `json:"route"`
+	Limit      int64      `json:"limit"` // 0 while none is learned
+	LearnedAt  *time.Time `json:"learned_at,omitempty"`
+	Rejections int        `json:"rejections"` // 413s within the window
+	Accepted   int64      `json:"largest_accepted"`
+}
+
+func (l *bodyLimitLearner) snapshot() learnedBodyLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := learnedBodyLimit{Route: l.route, Limit: l.limit, Rejections: len(l.rejected), Accepted: l.accepted}
+	if l.limit > 0 {
+		learnedAt := l.learnedAt
+		s.LearnedAt = &learnedAt
+	}
+	return s
+}
+
+// checkLearnedBodyLimit rejects a request whose declared body is larger than
+// the limit learned for its route.
+func (p *Proxy) checkLearnedBodyLimit(req *pipelineRequest) bool {
+	l := p.bodyLimits[req.route.LearnBodyLimit]
+	if l == nil || req.r.ContentLength <= 0 {
+		return true
+	}
+	limit, ok := l.allow(req.r.ContentLength, time.Now())
+	if ok {
+		return true
+	}
+	p.metrics.RecordError(req.routeName, "body_limit_learned")
+	p.writeError(req.w, http.StatusRequestEntityTooLarge, "body_limit_learned",
+		fmt.Sprintf("request body too large: the upstream accepts at most %d bytes", limit))
+	return false
+}
+
+// observeBodySize feeds the upstream's answer to a request with a known body
+// size to its route's learner.
+func (p *Proxy) observeBodySize(r *http.Request, route *router.Route, status int) {
+	if l := p.bodyLimits[route.LearnBodyLimit]; l != nil && r.ContentLength > 0 {
+		l.observe(r.ContentLength, status, time.Now())
+	}
+}
+
+// handleBodyLimits lists the learned body limits of every route that learns
+// one.
+func (p *Proxy) handleBodyLimits(w http.ResponseWriter, r *http.Request) {
+	list := make([]learnedBodyLimit, 0, len(p.bodyLimits))
+	for _, l := range p.bodyLimits {
+		list = append(list, l.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Route < list[j].Route })
+	writeJSON(w, http.StatusOK, map[string]any{"body_limits": list})
+}
+
+// handleResetBodyLimit forgets what a route learned about its upstream's
+// body limit.
+func (p *Proxy) handleResetBodyLimit(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("route")
+	for _, l := range p.bodyLimits {
+		if l.route == name {
+			l.reset()
+			p.logger.Info("learned body limit reset", "route", name)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusNotFound, "route does not learn a body limit", "")
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_LearnBodyLimit(t *testing.T) {
+	const backendLimit = 1 << 20
+	var received atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		n, _ := io.Copy(io.Discard, r.Body)
+		if n > backendLimit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].LearnBodyLimit = &config.BodyLimitLearning{Rejections: 2, Reprobe: 200 * time.Millisecond}
+	})
+	admin := p.AdminHandler()
+	post := func(size int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", size))))
+		return rec
+	}
+	learned := func() int64 {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/body-limits", nil))
+		var resp struct {
+			BodyLimits []learnedBodyLimit `json:"body_limits"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.BodyLimits) != 1 {
+			t.Fatalf("GET /admin/body-limits: %q", rec.Body.String())
+		}
+		return resp.BodyLimits[0].Limit
+	}
+
+	// Converge from a few rejected sizes towards the backend's limit.
+	for _, size := range []int{3 << 20, 3 << 20, 2 << 20, 3 << 19, backendLimit / 2} {
+		post(size)
+	}
+	limit := learned()
+	if limit <= backendLimit/2 || limit >= 3<<19 {
+		t.Fatalf("learned limit = %d, want between %d and %d", limit, backendLimit/2, 3<<19)
+	}
+
+	before := received.Load()
+	rec := post(2 << 20)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized request: status = %d, want 413", rec.Code)
+	}
+	if received.Load() != before {
+		t.Error("oversized request was relayed upstream")
+	}
+	if !strings.Contains(rec.Body.String(), "at most") {
+		t.Errorf("rejection does not name the allowed size: %q", rec.Body.String())
+	}
+	if rec := post(backendLimit / 4); rec.Code != http.StatusOK {
+		t.Errorf("small request: status = %d", rec.Code)
+	}
+
+	// Once the reprobe interval passed, one request re-checks the limit.
+	time.Sleep(250 * time.Millisecond)
+	before = received.Load()
+	post(2 << 20)
+	post(2 << 20)
+	if got := received.Load() - before; got != 1 {
+		t.Errorf("%d oversized requests relayed after the reprobe interval, want 1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/body-limits/test", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /admin/body-limits/test: status = %d", rec.Code)
+	}
+	if limit := learned(); limit != 0 {
+		t.Errorf("limit after reset = %d", limit)
+	}
+	before = received.Load()
+	post(2 << 20)
+	if received.Load() == before {
+		t.Error("request not relayed after reset")
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// defaultBodyMatchSize caps how much of a body is buffered for inspection
+// when the route does not say otherwise.
+const defaultBodyMatchSize = 64 << 10
+
+// bodyMatcher selects an alternative upstream from a small JSON body.
+type bodyMatcher struct {
+	maxSize int64
+	rules   []bodyRule
+}
+
+type bodyRule struct {
+	name     string
+	pointer  []string
+	equals   *string
+	contains string
+	regex    *regexp.Regexp
+	upstream string
+}
+
+func newBodyMatcher(cfg *config.BodyMatch) (*bodyMatcher, error) {
+	m := &bodyMatcher{maxSize: cfg.MaxSize}
+	if m.maxSize <= 0 {
+		m.maxSize = defaultBodyMatchSize
+	}
+
+	for _, rc := range cfg.Rules {
+		rule := bodyRule{
+			name:     rc.Name,
+			pointer:  parseJSONPointer(rc.Pointer),
+			equals:   rc.Equals,
+			contains: rc.Contains,
+			upstream: rc.Upstream,
+		}
+		if rc.Regex != "" {
+			re, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				return nil, err
+			}
+			rule.regex = re
+		}
+		m.rules = append(m.rules, rule)
+	}
+	return m, nil
+}
+
+// selectUpstream inspects r's body and returns the upstream to use along with
+// the matcher label for metrics. The body is always left intact on r.
+func (m *bodyMatcher) selectUpstream(r *http.Request, defaultUpstream string) (string, string, error) {
+	if !isJSONRequest(r) {
+		return defaultUpstream, "fallback_not_json", nil
+	}
+
+	data, complete, err := bufferBody(r, m.maxSize)
+	if err != nil {
+		return defaultUpstream, "", err
+	}
+	if !complete {
+		return defaultUpstream, "fallback_too_large", nil
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return defaultUpstream, "fallback_not_json", nil
+	}
+
+	for _, rule := range m.rules {
+		value, ok := resolveJSONPointer(doc, rule.pointer)
+		if ok && rule.matches(value) {
+			return rule.upstream, rule.name, nil
+		}
+	}
+	return defaultUpstream, "fallback_no_match", nil
+}
+
+func (rule *bodyRule) matches(value string) bool {
+	switch {
+	case rule.equals != nil:
+		return value == *rule.equals
+	case rule.regex != nil:
+		return rule.regex.MatchString(value)
+	default:
+		return strings.Contains(value, rule.contains)
+	}
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into unescaped reference tokens.
+func parseJSONPointer(pointer string) []string {
+	if pointer == "" {
+		return nil
+	}
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~1", "/")
+		tokens[i] = strings.ReplaceAll(t, "~0", "~")
+	}
+	return tokens
+}
+
+// resolveJSONPointer walks doc and returns the referenced value as a string:
+// strings as-is, anything else in its JSON encoding.
+func resolveJSONPointer(doc any, tokens []string) (string, bool) {
+	cur := doc
+	for _, tok := range tokens {
+		switch v := cur.(type) {
+		case map[string]any:
+			next, ok := v[tok]
+			if !ok {
+				return "", false
+			}
+			cur = next
+		case []any:
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return "", false
+			}
+			cur = v[idx]
+		default:
+			return "", false
+		}
+	}
+
+	if s, ok := cur.(string); ok {
+		return s, true
+	}
+	encoded, err := json.Marshal(cur)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// applyBodyMatch points route at the upstream chosen by its body matcher.
+// It returns false if it already answered the request.
+func (p *Proxy) applyBodyMatch(w http.ResponseWriter, r *http.Request, route *router.Route, routeName string) bool {
+	m, ok := p.bodyMatchers[route.BodyMatch]
+	if !ok {
+		return true
+	}
+
+	upstream, label, err := m.selectUpstream(r, route.Upstream)
+	if err != nil {
+		p.writeBodyError(w, routeName, err)
+		return false
+	}
+
+	p.metrics.RecordBodyMatch(routeName, label)
+	route.Upstream = upstream
+	return true
+}
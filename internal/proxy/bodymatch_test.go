@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// namedBackend answers with its name and the body it received.
+func namedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, name+":"+string(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxy_BodyMatch(t *testing.T) {
+	primary := namedBackend(t, "primary")
+	writes := namedBackend(t, "writes")
+
+	mutation := "mutation"
+	p := newTestProxy(t, primary.URL, func(cfg *config.Config) {
+		cfg.Upstreams = append(cfg.Upstreams, config.Upstream{
+			Name: "writes", Targets: []config.Target{{URL: writes.URL}},
+		})
+		cfg.Routes[0].BodyMatch = &config.BodyMatch{
+			MaxSize: 64,
+			Rules: []config.BodyMatchRule{
+				{Name: "graphql_mutation", Pointer: "/operation/type", Equals: &mutation, Upstream: "writes"},
+				{Name: "bulk", Pointer: "/items/0", Regex: "^bulk-", Upstream: "writes"},
+			},
+		}
+	})
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		label       string
+	}{
+		{"equals", "application/json", `{"operation":{"type":"mutation"}}`, "writes", "graphql_mutation"},
+		{"array index regex", "application/json", `{"items":["bulk-1"]}`, "writes", "bulk"},
+		{"no match", "application/json", `{"operation":{"type":"query"}}`, "primary", "fallback_no_match"},
+		{"not json", "text/plain", `{"operation":{"type":"mutation"}}`, "primary", "fallback_not_json"},
+		{"invalid json", "application/json", `{"operation":`, "primary", "fallback_not_json"},
+		{"too large", "application/json", `{"operation":{"type":"mutation"},"pad":"` + strings.Repeat("x", 64) + `"}`, "primary", "fallback_too_large"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(gw.URL+"/graphql", tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if want := tt.want + ":" + tt.body; string(got) != want {
+				t.Errorf("response = %q, want %q", got, want)
+			}
+
+			metrics := httptest.NewRecorder()
+			p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+			if !strings.Contains(metrics.Body.String(), `gateway_body_match_total{key="test_`+tt.label+`"}`) {
+				t.Errorf("matcher %s was not recorded", tt.label)
+			}
+		})
+	}
+}
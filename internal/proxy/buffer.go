@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// bufferBody reads up to limit bytes of r's body into memory so it can be
+// inspected or replayed. When the body fits, r.Body is replaced with a
+// replayable reader over the buffered bytes and complete is true. When it is
+// larger, r.Body is rebuilt from the buffered prefix followed by the unread
+// remainder so the request is still forwarded intact, and complete is false.
+func bufferBody(r *http.Request, limit int64) (data []byte, complete bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true, nil
+	}
+	if r.ContentLength > limit {
+		return nil, false, nil
+	}
+
+	data, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(data)) > limit {
+		r.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(data), r.Body), closer: r.Body}
+		return nil, false, nil
+	}
+
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, true, nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (m *multiReadCloser) Close() error {
+	return m.closer.Close()
+}
+
+// writeBodyError answers a request whose body could not be buffered: 413 if
+// it ran past the body size limit, 400 otherwise, with decompression_failed
+// if it could not be decompressed.
+func (p *Proxy) writeBodyError(w http.ResponseWriter, routeName string, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		p.metrics.RecordError(routeName, "body_too_large")
+		p.writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+		return
+	}
+	if errors.Is(err, errRequestDecompression) {
+		p.writeDecompressionError(w, routeName)
+		return
+	}
+	p.metrics.RecordError(routeName, "body_read_error")
+	p.writeError(w, http.StatusBadRequest, "body_read_error", "request body could not be read")
+}
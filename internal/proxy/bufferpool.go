@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultCopyBufferSize is the size of the buffers response bodies are
+// copied through when server.copy_buffer_size is not set, the size io.Copy
+// would allocate.
+const defaultCopyBufferSize = 32 << 10
+
+// bufferPool hands out copy buffers of one size, so relaying a response
+// does not allocate a buffer of its own.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	b := &bufferPool{size: size}
+	b.pool.New = func() any {
+		buf := make([]byte, b.size)
+		return &buf
+	}
+	return b
+}
+
+// get returns a buffer for the caller's exclusive use until it calls put.
+func (b *bufferPool) get() *[]byte {
+	return b.pool.Get().(*[]byte)
+}
+
+// put returns buf to the pool. The caller must not keep any slice of it:
+// the next request reads its own body into the same memory.
+func (b *bufferPool) put(buf *[]byte) {
+	if cap(*buf) != b.size {
+		return
+	}
+	*buf = (*buf)[:b.size]
+	b.pool.Put(buf)
+}
+
+// copyBody copies src to dst through buf. dst may implement io.ReaderFrom
+// itself, in which case io.CopyBuffer would not use buf; none of the
+// gateway's writers do.
+func copyBody(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
+	return io.CopyBuffer(dst, src, buf)
+}
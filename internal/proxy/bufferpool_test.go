@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+func TestProxy_CopyBufferReuse(t *testing.T) {
+	full := strings.Repeat("abcdefghij", 1000)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/truncated" {
+			// Promise more than is sent, then drop the connection, so the
+			// copy fails halfway through the body.
+			w.Header().Set("Content-Length", "100000")
+			_, _ = io.WriteString(w, strings.Repeat("x", 5000))
+			w.(http.Flusher).Flush()
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		_, _ = io.WriteString(w, full)
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.CopyBufferSize = 512
+	})
+
+	for i := range 3 {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/truncated", nil))
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/full", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != full {
+			t.Fatalf("request %d after a failed copy: status = %d, body of %d bytes differs", i, rec.Code, rec.Body.Len())
+		}
+	}
+}
+
+// benchmarkBody hides bytes.Reader's WriteTo, as upstream bodies have none.
+type benchmarkBody struct{ io.Reader }
+
+func (benchmarkBody) Close() error { return nil }
+
+// discardResponseWriter accepts a response without keeping it.
+type discardResponseWriter struct{ header http.Header }
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(int)             {}
+
+func benchmarkCopyResponse(b *testing.B, copyBody func(w http.ResponseWriter, resp *http.Response)) {
+	body := bytes.NewReader(benchPayload)
+	resp := &http.Response{StatusCode: http.StatusOK, ContentLength: int64(len(benchPayload)), Header: http.Header{}}
+	w := &statusWriter{ResponseWriter: &discardResponseWriter{header: http.Header{}}}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchPayload)))
+	for i := 0; i < b.N; i++ {
+		body.Reset(benchPayload)
+		resp.Body = benchmarkBody{body}
+		copyBody(w, resp)
+	}
+}
+
+func BenchmarkCopyResponse(b *testing.B) {
+	pool := newBufferPool(0)
+	route := &router.Route{}
+	benchmarkCopyResponse(b, func(w http.ResponseWriter, resp *http.Response) {
+		buf := pool.get()
+		_ = copyResponse(w, resp, route, *buf)
+		pool.put(buf)
+	})
+}
+
+// BenchmarkCopyResponseUnpooled is the baseline BenchmarkCopyResponse is
+// compared against: io.Copy with a buffer per response.
+func BenchmarkCopyResponseUnpooled(b *testing.B) {
+	benchmarkCopyResponse(b, func(w http.ResponseWriter, resp *http.Response) {
+		_, _ = io.Copy(w, resp.Body)
+	})
+}
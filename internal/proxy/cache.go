@@ -0,0 +1,429 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const (
+	defaultCacheMaxEntries  = 1000
+	defaultCacheMaxBodySize = 1 << 20
+)
+
+// cachePopulateBudget is the least time a request must have left before its
+// deadline for the cache to be filled before the handler returns. With less,
+// the response is finished first and the entry stored in the background.
+var cachePopulateBudget = 10 * time.Millisecond
+
+// responseCache caches upstream responses for one route in its store,
+// following the route's cache settings.
+type responseCache struct {
+	ttl               time.Duration
+	negativeTTL       time.Duration
+	maxEntries        int
+	maxBodySize       int64
+	invalidateOnWrite bool
+	vary              []cacheKeyComponent
+	bypass            []cacheKeyComponent
+
+	// varyWarned is set once a response varying on a header the key does
+	// not cover has been logged.
+	varyWarned atomic.Bool
+
+	store  cacheStore
+	policy *errorPolicy // the cache's on_error, set by setupCaches
+}
+
+type cacheEntry struct {
+	path    string
+	variant string // see cacheVariantKey
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func newResponseCache(cfg *config.Cache) *responseCache {
+	c := &responseCache{
+		ttl:               cfg.TTL,
+		negativeTTL:       cfg.NegativeTTL,
+		maxEntries:        cfg.MaxEntries,
+		maxBodySize:       cfg.MaxBodySize,
+		invalidateOnWrite: cfg.InvalidateOnWrite == nil || *cfg.InvalidateOnWrite,
+		vary:              parseCacheKeyComponents(cfg.VaryOn),
+		bypass:            parseCacheKeyComponents(cfg.BypassWhen),
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = defaultCacheMaxEntries
+	}
+	if c.maxBodySize == 0 {
+		c.maxBodySize = defaultCacheMaxBodySize
+	}
+	c.store = newMemoryCacheStore(c.maxEntries)
+	return c
+}
+
+// setupCaches creates the cache of every route with one, each with its own
+// on_error policy.
+func (p *Proxy) setupCaches() {
+	p.caches = make(map[*config.Cache]*responseCache)
+	for _, route := range p.config.Routes {
+		if route.Cache == nil {
+			continue
+		}
+		c := newResponseCache(route.Cache)
+		c.policy = newErrorPolicy("cache:"+route.Name, route.Cache.OnError, p.metrics, p.logger)
+		p.caches[route.Cache] = c
+	}
+}
+
+// cachePath identifies a resource independent of its query string. The
+// upstream is included because body matching can change it per request.
+func cachePath(r *http.Request, route *router.Route) string {
+	return route.Upstream + " " + strings.ToLower(r.Host) + r.URL.Path
+}
+
+// refresh replaces old, if it is still cached, with a copy that is fresh
+// for another ttl and carries the headers of the upstream's 304 on top of
+// its own. The copy is returned either way, even if the store failed;
+// entries are never modified in place since they are served without a
+// lock.
+func (c *responseCache) refresh(old *cacheEntry, notModified http.Header, now time.Time) *cacheEntry {
+	e := *old
+	e.header = old.header.Clone()
+	for k, vv := range notModified {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		e.header[k] = append([]string(nil), vv...)
+	}
+	e.stored = now
+	e.expires = now.Add(c.ttl)
+
+	c.policy.observe(c.store.replace(old, &e))
+	return &e
+}
+
+// ttlFor returns how long a response with status may be cached, or 0.
+func (c *responseCache) ttlFor(status int) time.Duration {
+	switch status {
+	case http.StatusOK:
+		return c.ttl
+	case http.StatusNotFound, http.StatusGone:
+		return c.negativeTTL
+	}
+	return 0
+}
+
+// serveFromCache answers r from the route's cache if it can, and otherwise
+// invalidates the cached path when r is a write. HEAD requests are answered
+// from the cached GET. Requests matching bypass_when are neither answered
+// from the cache nor stored. When the store fails it follows the cache's
+// on_error. It reports whether a response was written and, when an expired
+// entry can be revalidated upstream instead of refetched, returns the
+// revalidation for the proxy stage.
+func (p *Proxy) serveFromCache(w http.ResponseWriter, r *http.Request, route *router.Route, routeName string) (bool, *cacheRevalidation) {
+	c, ok := p.caches[route.Cache]
+	if !ok {
+		return false, nil
+	}
+	path := cachePath(r, route)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		if !c.invalidateOnWrite {
+			return false, nil
+		}
+		invalidated, err := c.store.invalidate(path)
+		c.policy.observe(err)
+		if err != nil && c.policy.action == config.OnErrorDeny {
+			// The write would leave the cached responses stale.
+			p.cacheUnavailable(w, routeName)
+			return true, nil
+		}
+		if invalidated {
+			p.metrics.RecordCacheOperation(routeName, "invalidate")
+		}
+		return false, nil
+	}
+	if cacheBypassed(r, c.bypass) {
+		p.metrics.RecordCacheOperation(routeName, "bypass")
+		w.Header().Set("X-Cache", "BYPASS")
+		return false, nil
+	}
+
+	now := time.Now()
+	e, err := c.store.get(path, cacheVariantKey(r, c.vary))
+	c.policy.observe(err)
+	if err != nil {
+		switch c.policy.action {
+		case config.OnErrorDeny:
+			p.cacheUnavailable(w, routeName)
+			return true, nil
+		case config.OnErrorDegrade:
+			p.metrics.RecordCacheOperation(routeName, "bypass")
+			w.Header().Set("X-Cache", "BYPASS")
+			return false, nil
+		}
+		// allow: a miss, stored as usual.
+	}
+	ok = e != nil
+	expired := ok && !now.Before(e.expires)
+	if expired && !p.degraded(route, config.DegradeServeStale) {
+		if e.status == http.StatusOK && hasValidators(e.header) && !conditional(r) {
+			p.metrics.RecordCacheOperation(routeName, "revalidate")
+			w.Header().Set("X-Cache", "MISS")
+			return false, &cacheRevalidation{cache: c, entry: e, routeName: routeName}
+		}
+		ok = false
+	}
+	if !ok {
+		p.metrics.RecordCacheOperation(routeName, "miss")
+		w.Header().Set("X-Cache", "MISS")
+		return false, nil
+	}
+	xCache := "HIT"
+	switch {
+	case expired:
+		p.metrics.RecordCacheOperation(routeName, "stale_hit")
+		xCache = "STALE"
+	case e.status == http.StatusOK:
+		p.metrics.RecordCacheOperation(routeName, "hit")
+	default:
+		p.metrics.RecordCacheOperation(routeName, "negative_hit")
+	}
+	p.writeCached(w, r, route, e, xCache, now)
+	return true, nil
+}
+
+func (p *Proxy) cacheUnavailable(w http.ResponseWriter, routeName string) {
+	p.metrics.RecordError(routeName, "cache_unavailable")
+	p.writeError(w, http.StatusServiceUnavailable, "cache_unavailable", "cache unavailable")
+}
+
+// writeCached relays e to the client, without its body for HEAD requests.
+func (p *Proxy) writeCached(w http.ResponseWriter, r *http.Request, route *router.Route, e *cacheEntry, xCache string, now time.Time) {
+	header := e.header.Clone()
+	header.Set("X-Cache", xCache)
+	header.Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	var body io.ReadCloser = http.NoBody
+	if r.Method != http.MethodHead {
+		body = io.NopCloser(bytes.NewReader(e.body))
+	}
+	_ = p.writeResponse(w, r, route, &http.Response{
+		StatusCode:    e.status,
+		Header:        header,
+		Body:          body,
+		ContentLength: int64(len(e.body)),
+	})
+}
+
+// cacheRevalidation is an expired entry the proxy stage asks the upstream
+// about with If-None-Match and If-Modified-Since, carried in the request's
+// context.
+type cacheRevalidation struct {
+	cache     *responseCache
+	entry     *cacheEntry
+	routeName string
+}
+
+type cacheRevalidationKey struct{}
+
+func withCacheRevalidation(r *http.Request, rv *cacheRevalidation) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cacheRevalidationKey{}, rv))
+}
+
+func cacheRevalidationFrom(ctx context.Context) *cacheRevalidation {
+	rv, _ := ctx.Value(cacheRevalidationKey{}).(*cacheRevalidation)
+	return rv
+}
+
+// setConditions makes an upstream request conditional on the entry's
+// validators.
+func (rv *cacheRevalidation) setConditions(h http.Header) {
+	if etag := rv.entry.header.Get("ETag"); etag != "" {
+		h.Set("If-None-Match", etag)
+	}
+	if modified := rv.entry.header.Get("Last-Modified"); modified != "" {
+		h.Set("If-Modified-Since", modified)
+	}
+}
+
+// serveRevalidated answers a request whose revalidation the upstream
+// answered 304 with the cached response, refreshed for another ttl.
+func (p *Proxy) serveRevalidated(w http.ResponseWriter, r *http.Request, route *router.Route, rv *cacheRevalidation, resp *http.Response) {
+	now := time.Now()
+	e := rv.cache.refresh(rv.entry, resp.Header, now)
+	p.metrics.RecordCacheOperation(rv.routeName, "revalidated")
+	w.Header().Del("X-Cache") // the MISS set in case of a full response
+	p.writeCached(w, r, route, e, "REVALIDATED", now)
+}
+
+// hasValidators reports whether a cached response can be revalidated.
+func hasValidators(h http.Header) bool {
+	return h.Get("ETag") != "" || h.Get("Last-Modified") != ""
+}
+
+// conditional reports whether the client made r conditional itself, in
+// which case the upstream's answer is the client's to have.
+func conditional(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// cacheFill captures a response body as it streams to the client so it can
+// be stored once complete. A nil *cacheFill does nothing.
+type cacheFill struct {
+	p         *Proxy
+	cache     *responseCache
+	routeName string
+	ctx       context.Context
+	entry     *cacheEntry
+	ttl       time.Duration
+	body      *cacheBody
+}
+
+// cacheFill arranges for resp to be stored in the route's cache if it is
+// cacheable. It must be called before resp.Body is read.
+func (p *Proxy) cacheFill(r *http.Request, route *router.Route, resp *http.Response) *cacheFill {
+	c, ok := p.caches[route.Cache]
+	if !ok || r.Method != http.MethodGet || cacheBypassed(r, c.bypass) {
+		return nil
+	}
+	// While the store fails, degrade bypasses it for responses too.
+	if c.policy.failingAction() == config.OnErrorDegrade {
+		return nil
+	}
+	ttl := c.ttlFor(resp.StatusCode)
+	if ttl <= 0 || !storable(resp) || resp.ContentLength > c.maxBodySize {
+		return nil
+	}
+	// Neither the path nor the variant key tells callers apart, so a
+	// response to a request with credentials is only shared when the
+	// upstream says so (RFC 9111, section 3.5).
+	if r.Header.Get("Authorization") != "" && !sharedWithAuthorization(resp.Header) {
+		return nil
+	}
+	// A response that varies on a header the key leaves out would be
+	// served to requests with any value of it.
+	if header := unkeyedVary(resp.Header, c.vary); header != "" {
+		routeName := routeNameOf(route)
+		p.metrics.RecordCacheOperation(routeName, "vary_mismatch")
+		if c.varyWarned.CompareAndSwap(false, true) {
+			p.logger.Warn("cache skips responses that vary on a header not in vary_on",
+				"route", routeName, "header", header)
+		}
+		return nil
+	}
+
+	body := &cacheBody{ReadCloser: resp.Body, limit: c.maxBodySize}
+	resp.Body = body
+	return &cacheFill{
+		p:         p,
+		cache:     c,
+		routeName: routeNameOf(route),
+		ctx:       r.Context(),
+		ttl:       ttl,
+		body:      body,
+		entry: &cacheEntry{
+			path:    cachePath(r, route),
+			variant: cacheVariantKey(r, c.vary),
+			status:  resp.StatusCode,
+			header:  resp.Header.Clone(),
+		},
+	}
+}
+
+// finish stores the captured response if the whole body was read. When the
+// request is close to its deadline the entry is stored in the background so
+// the client is not kept waiting.
+func (f *cacheFill) finish() {
+	if f == nil || !f.body.complete() {
+		return
+	}
+	now := time.Now()
+	f.entry.body = f.body.buf.Bytes()
+	f.entry.stored = now
+	f.entry.expires = now.Add(f.ttl)
+
+	store := func() { f.cache.policy.observe(f.cache.store.put(f.entry)) }
+	if deadline, ok := f.ctx.Deadline(); ok && deadline.Sub(now) < cachePopulateBudget {
+		f.p.metrics.RecordCacheOperation(f.routeName, "store_async")
+		go store()
+		return
+	}
+	f.p.metrics.RecordCacheOperation(f.routeName, "store")
+	store()
+}
+
+// storable reports whether the upstream allows resp to be shared.
+func storable(resp *http.Response) bool {
+	if resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, v := range resp.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-store", "private":
+				return false
+			}
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType != "text/event-stream"
+}
+
+// sharedWithAuthorization reports whether a response to a request with an
+// Authorization header may be stored in a shared cache: only with public,
+// s-maxage or must-revalidate.
+func sharedWithAuthorization(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "public", "s-maxage", "must-revalidate":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheBody copies what is read through it into buf until limit is passed.
+type cacheBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	eof      bool
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *cacheBody) complete() bool {
+	return b.eof && !b.overflow
+}
@@ -0,0 +1,419 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// countingBackend answers 404 for /missing and 200 otherwise, counting the
+// requests it sees per method and path.
+func countingBackend(t *testing.T) (*httptest.Server, func(key string) int64) {
+	t.Helper()
+	var mu sync.Mutex
+	counts := make(map[string]*atomic.Int64)
+	counter := func(key string) *atomic.Int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		if counts[key] == nil {
+			counts[key] = new(atomic.Int64)
+		}
+		return counts[key]
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counter(r.Method + " " + r.URL.Path).Add(1)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	t.Cleanup(s.Close)
+	return s, func(key string) int64 { return counter(key).Load() }
+}
+
+func TestProxy_Cache(t *testing.T) {
+	do := func(t *testing.T, p *Proxy, method, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	t.Run("caches successes", func(t *testing.T) {
+		backend, count := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+		})
+
+		first := do(t, p, "GET", "/items")
+		second := do(t, p, "GET", "/items")
+		if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("X-Cache = %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+		}
+		if second.Body.String() != "hello /items" {
+			t.Errorf("cached body = %q", second.Body.String())
+		}
+		if n := count("GET /items"); n != 1 {
+			t.Errorf("backend saw %d requests, want 1", n)
+		}
+	})
+
+	t.Run("negative hits", func(t *testing.T) {
+		backend, count := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{NegativeTTL: time.Minute}
+		})
+
+		for i := 0; i < 3; i++ {
+			if rec := do(t, p, "GET", "/missing"); rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want 404", rec.Code)
+			}
+		}
+		if n := count("GET /missing"); n != 1 {
+			t.Errorf("backend saw %d requests for a missing resource, want 1", n)
+		}
+		// Without a positive ttl, successes are not cached.
+		do(t, p, "GET", "/items")
+		do(t, p, "GET", "/items")
+		if n := count("GET /items"); n != 2 {
+			t.Errorf("backend saw %d requests, want 2", n)
+		}
+
+		rec := httptest.NewRecorder()
+		p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if !strings.Contains(rec.Body.String(), `gateway_cache_operations_total{key="test_negative_hit"} 2`) {
+			t.Errorf("negative hits not counted:\n%s", rec.Body.String())
+		}
+	})
+
+	t.Run("invalidates on POST", func(t *testing.T) {
+		backend, count := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{NegativeTTL: time.Minute}
+		})
+
+		do(t, p, "GET", "/missing")
+		do(t, p, "GET", "/missing?page=2")
+		do(t, p, "POST", "/missing")
+		do(t, p, "GET", "/missing")
+		do(t, p, "GET", "/missing?page=2")
+		if n := count("GET /missing"); n != 4 {
+			t.Errorf("backend saw %d GETs, want 4 (every query variant refetched after the POST)", n)
+		}
+	})
+
+	t.Run("invalidation can be disabled", func(t *testing.T) {
+		backend, count := countingBackend(t)
+		off := false
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{NegativeTTL: time.Minute, InvalidateOnWrite: &off}
+		})
+
+		do(t, p, "GET", "/missing")
+		do(t, p, "POST", "/missing")
+		do(t, p, "GET", "/missing")
+		if n := count("GET /missing"); n != 1 {
+			t.Errorf("backend saw %d GETs, want 1", n)
+		}
+	})
+
+	t.Run("answers HEAD from the cached GET", func(t *testing.T) {
+		backend, count := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+		})
+
+		if rec := do(t, p, "HEAD", "/items"); rec.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("HEAD before any GET: X-Cache = %q, want MISS", rec.Header().Get("X-Cache"))
+		}
+		do(t, p, "GET", "/items")
+		rec := do(t, p, "HEAD", "/items")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("HEAD after GET: status = %d, X-Cache = %q, want a 200 hit", rec.Code, rec.Header().Get("X-Cache"))
+		}
+		if rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "12" {
+			t.Errorf("HEAD hit: body = %q, Content-Length = %q", rec.Body.String(), rec.Header().Get("Content-Length"))
+		}
+		if n := count("GET /items") + count("HEAD /items"); n != 2 {
+			t.Errorf("backend saw %d requests, want 2", n)
+		}
+	})
+
+	t.Run("populates asynchronously near the deadline", func(t *testing.T) {
+		defer func(d time.Duration) { cachePopulateBudget = d }(cachePopulateBudget)
+		cachePopulateBudget = time.Hour
+
+		backend, _ := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil).WithContext(ctx))
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello /items" {
+			t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+		}
+
+		metrics := httptest.NewRecorder()
+		p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+		if !strings.Contains(metrics.Body.String(), `gateway_cache_operations_total{key="test_store_async"} 1`) {
+			t.Errorf("expected an asynchronous store:\n%s", metrics.Body.String())
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for do(t, p, "GET", "/items").Header().Get("X-Cache") != "HIT" {
+			if time.Now().After(deadline) {
+				t.Fatal("entry never became visible")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestProxy_CacheRevalidation(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		conditions []string
+		version    = "v1"
+	)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditions = append(conditions, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		etag := `"` + version + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Mon, 05 Oct 2026 10:00:00 GMT")
+		if r.Header.Get("If-None-Match") == etag {
+			w.Header().Set("X-Refreshed", "yes")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = io.WriteString(w, "body "+version)
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Cache = &config.Cache{TTL: 50 * time.Millisecond}
+	})
+	do := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, "/doc", nil))
+		return rec
+	}
+
+	do("GET")
+	time.Sleep(60 * time.Millisecond)
+	rec := do("GET")
+	if rec.Code != http.StatusOK || rec.Body.String() != "body v1" || rec.Header().Get("X-Cache") != "REVALIDATED" {
+		t.Fatalf("revalidated: status = %d, body = %q, X-Cache = %q", rec.Code, rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+	if rec.Header().Get("X-Refreshed") != "yes" {
+		t.Error("headers of the 304 were not merged into the entry")
+	}
+	// The 304 made the entry fresh again.
+	if rec := do("HEAD"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("after revalidation: X-Cache = %q, want HIT", rec.Header().Get("X-Cache"))
+	}
+
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+	time.Sleep(60 * time.Millisecond)
+	if rec := do("GET"); rec.Body.String() != "body v2" || rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("changed resource: body = %q, X-Cache = %q, want the new body", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+	if rec := do("GET"); rec.Body.String() != "body v2" || rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("after refetch: body = %q, X-Cache = %q", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+
+	mu.Lock()
+	want := []string{"|", `"v1"|Mon, 05 Oct 2026 10:00:00 GMT`, `"v1"|Mon, 05 Oct 2026 10:00:00 GMT`}
+	if strings.Join(conditions, "\n") != strings.Join(want, "\n") {
+		t.Errorf("upstream conditions = %q, want %q", conditions, want)
+	}
+	mu.Unlock()
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_cache_operations_total{key="test_revalidate"} 2`,
+		`gateway_cache_operations_total{key="test_revalidated"} 1`,
+		`gateway_cache_operations_total{key="test_hit"} 2`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("missing %s:\n%s", want, metrics.Body.String())
+		}
+	}
+}
+
+func TestProxy_CacheAuthorization(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/shared" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		_, _ = io.WriteString(w, "profile of "+r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+	})
+	do := func(path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	alice := do("/profile", "Bearer alice")
+	bob := do("/profile", "Bearer bob")
+	if alice.Body.String() != "profile of Bearer alice" || bob.Body.String() != "profile of Bearer bob" {
+		t.Errorf("got %q and %q, want each caller's own profile", alice.Body.String(), bob.Body.String())
+	}
+	if got := bob.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("second caller: X-Cache = %q, want MISS", got)
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("backend saw %d requests, want one per caller", n)
+	}
+
+	// The upstream may mark such a response as shared.
+	do("/shared", "Bearer alice")
+	if rec := do("/shared", "Bearer bob"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("public response: X-Cache = %q, want HIT", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestProxy_CacheContentEncoding(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Vary", "Accept-Encoding")
+		if acceptsGzip(r.Header) {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = io.WriteString(w, "gzipped")
+			return
+		}
+		_, _ = io.WriteString(w, "plain")
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+	})
+	do := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/items", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do("gzip, br")
+	for _, tt := range []struct {
+		acceptEncoding string
+		xCache         string
+		body           string
+	}{
+		// A client that cannot decode gzip never gets the gzipped body.
+		{"", "MISS", "plain"},
+		{"", "HIT", "plain"},
+		{"br, GZIP", "HIT", "gzipped"},
+	} {
+		rec := do(tt.acceptEncoding)
+		if rec.Header().Get("X-Cache") != tt.xCache || rec.Body.String() != tt.body {
+			t.Errorf("Accept-Encoding %q: X-Cache %q, body %q, want %s and %q",
+				tt.acceptEncoding, rec.Header().Get("X-Cache"), rec.Body.String(), tt.xCache, tt.body)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("backend saw %d requests, want one per encoding", n)
+	}
+}
+
+func TestProxy_CacheVariation(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Vary", r.URL.Query().Get("vary"))
+		_, _ = io.WriteString(w, "price in "+r.Header.Get("X-Currency"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Cache = &config.Cache{
+			TTL:        time.Minute,
+			VaryOn:     []string{"header:X-Currency", "query:vary"},
+			BypassWhen: []string{"header:Authorization"},
+		}
+	})
+	do := func(target, currency, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if currency != "" {
+			req.Header.Set("X-Currency", currency)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do("/catalog?vary=X-Currency", "EUR", "")
+	do("/catalog?vary=X-Currency", "USD", "")
+	eur := do("/catalog?vary=X-Currency&page=2", "EUR", "")
+	usd := do("/catalog?vary=X-Currency", "USD", "")
+	if eur.Body.String() != "price in EUR" || usd.Body.String() != "price in USD" || eur.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("got %q (%s) and %q, want each currency's own cached response", eur.Body.String(), eur.Header().Get("X-Cache"), usd.Body.String())
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("backend saw %d requests, want one per currency", n)
+	}
+
+	// Authenticated requests never see or fill the cache.
+	hits.Store(0)
+	for range 2 {
+		if rec := do("/catalog?vary=X-Currency", "EUR", "Bearer token"); rec.Header().Get("X-Cache") != "BYPASS" {
+			t.Errorf("X-Cache = %q, want BYPASS", rec.Header().Get("X-Cache"))
+		}
+	}
+	if rec := do("/private?vary=X-Currency", "EUR", "Bearer token"); rec.Header().Get("X-Cache") != "BYPASS" {
+		t.Errorf("X-Cache = %q, want BYPASS", rec.Header().Get("X-Cache"))
+	}
+	if rec := do("/private?vary=X-Currency", "EUR", ""); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("authenticated response was stored: X-Cache = %q", rec.Header().Get("X-Cache"))
+	}
+	if n := hits.Load(); n != 4 {
+		t.Errorf("backend saw %d requests, want every bypassed request", n)
+	}
+
+	// Responses that vary on a header outside vary_on are not stored.
+	hits.Store(0)
+	for range 2 {
+		do("/catalog?vary=Accept-Language", "EUR", "")
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("backend saw %d requests, want 2 with the response not stored", n)
+	}
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{`gateway_cache_operations_total{key="test_bypass"} 3`, `gateway_cache_operations_total{key="test_vary_mismatch"} 2`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %s:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// cacheKeyComponent is one request component of a cache key or bypass
+// rule, such as the X-Currency header.
+type cacheKeyComponent struct {
+	kind string // config.CacheComponentHeader, Cookie or Query
+	name string // canonical for headers
+}
+
+// parseCacheKeyComponents parses vary_on or bypass_when. Validate has
+// already rejected malformed components.
+func parseCacheKeyComponents(specs []string) []cacheKeyComponent {
+	components := make([]cacheKeyComponent, 0, len(specs))
+	for _, spec := range specs {
+		kind, name, _ := config.ParseCacheComponent(spec)
+		if kind == config.CacheComponentHeader {
+			name = textproto.CanonicalMIMEHeaderKey(name)
+		}
+		components = append(components, cacheKeyComponent{kind: kind, name: name})
+	}
+	return components
+}
+
+// values returns the values of the component in r, and whether r has it
+// at all.
+func (c cacheKeyComponent) values(r *http.Request) ([]string, bool) {
+	switch c.kind {
+	case config.CacheComponentHeader:
+		v, ok := r.Header[c.name]
+		return v, ok
+	case config.CacheComponentCookie:
+		cookies := r.CookiesNamed(c.name)
+		v := make([]string, len(cookies))
+		for i, cookie := range cookies {
+			v[i] = cookie.Value
+		}
+		return v, len(cookies) > 0
+	default:
+		v, ok := r.URL.Query()[c.name]
+		return v, ok
+	}
+}
+
+// cacheVariantKey identifies the variant of a resource r asks for. Without
+// components it is the raw query string. Otherwise it encodes the query
+// string, or only the query components when there are any, followed by
+// each component's values. Every value is quoted and counted, and a
+// missing component differs from an empty one, so distinct values never
+// produce the same key. The content codings r accepts are appended, so an
+// encoded body is only served to clients that can decode it.
+func cacheVariantKey(r *http.Request, vary []cacheKeyComponent) string {
+	key := componentsKey(r, vary)
+	if codings := acceptedCodings(r.Header); codings != "" {
+		// Neither form of key has a space before this.
+		key += " encoding=" + codings
+	}
+	return key
+}
+
+func componentsKey(r *http.Request, vary []cacheKeyComponent) string {
+	if len(vary) == 0 {
+		return r.URL.RawQuery
+	}
+	var b strings.Builder
+	wholeQuery := true
+	for _, c := range vary {
+		if c.kind == config.CacheComponentQuery {
+			wholeQuery = false
+		}
+	}
+	if wholeQuery {
+		b.WriteString(strconv.Quote(r.URL.RawQuery))
+	}
+	for _, c := range vary {
+		b.WriteByte(' ')
+		values, ok := c.values(r)
+		if !ok {
+			b.WriteByte('-')
+			continue
+		}
+		b.WriteString(strconv.Itoa(len(values)))
+		for _, v := range values {
+			b.WriteByte(' ')
+			b.WriteString(strconv.Quote(v))
+		}
+	}
+	return b.String()
+}
+
+// acceptedCodings normalizes Accept-Encoding to the sorted, lowercased
+// codings it allows, so clients that accept the same codings share cache
+// entries however they list them.
+func acceptedCodings(h http.Header) string {
+	var codings []string
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if q, err := strconv.ParseFloat(qv, 64); err == nil && q <= 0 {
+					continue
+				}
+			}
+			codings = append(codings, coding)
+		}
+	}
+	slices.Sort(codings)
+	return strings.Join(slices.Compact(codings), ",")
+}
+
+// cacheBypassed reports whether r carries any of the bypass components.
+func cacheBypassed(r *http.Request, bypass []cacheKeyComponent) bool {
+	for _, c := range bypass {
+		if _, ok := c.values(r); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unkeyedVary returns the first request header the response varies on that
+// the cache key does not cover, or "" if there is none. Accept-Encoding is
+// always covered by the variant key; Vary: * is never covered.
+func unkeyedVary(h http.Header, vary []cacheKeyComponent) string {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			if name == "" || name == "Accept-Encoding" {
+				continue
+			}
+			if !hasHeaderComponent(vary, name) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func hasHeaderComponent(vary []cacheKeyComponent, name string) bool {
+	for _, c := range vary {
+		if c.kind == config.CacheComponentHeader && c.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func cacheKeyRequest(target string, header http.Header) *http.Request {
+	r := httptest.NewRequest("GET", target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	return r
+}
+
+func TestCacheVariantKey(t *testing.T) {
+	vary := parseCacheKeyComponents([]string{"header:x-currency", "cookie:session_present", "query:locale"})
+	key := func(target string, header http.Header) string {
+		return cacheVariantKey(cacheKeyRequest(target, header), vary)
+	}
+
+	base := key("/items?locale=de&page=2", http.Header{"X-Currency": {"EUR"}, "Cookie": {"session_present=1"}})
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		same   bool
+	}{
+		{"other unkeyed parameters", "/items?page=3&locale=de", http.Header{"X-Currency": {"EUR"}, "Cookie": {"a=b; session_present=1"}}, true},
+		{"other currency", "/items?locale=de", http.Header{"X-Currency": {"USD"}, "Cookie": {"session_present=1"}}, false},
+		{"no currency", "/items?locale=de", http.Header{"Cookie": {"session_present=1"}}, false},
+		{"empty currency", "/items?locale=de", http.Header{"X-Currency": {""}, "Cookie": {"session_present=1"}}, false},
+		{"no session cookie", "/items?locale=de", http.Header{"X-Currency": {"EUR"}}, false},
+		{"other locale", "/items?locale=fr", http.Header{"X-Currency": {"EUR"}, "Cookie": {"session_present=1"}}, false},
+		{"locale twice", "/items?locale=de&locale=de", http.Header{"X-Currency": {"EUR"}, "Cookie": {"session_present=1"}}, false},
+	}
+	for _, tt := range tests {
+		if got := key(tt.target, tt.header); (got == base) != tt.same {
+			t.Errorf("%s: key %q vs %q, want same=%v", tt.name, got, base, tt.same)
+		}
+	}
+
+	encodings := []struct {
+		accept string
+		same   string
+	}{
+		{"gzip, br", "br,gzip"},
+		{"BR;q=0.5, gzip, gzip", "br,gzip"},
+		{"gzip, br;q=0", "gzip"},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range encodings {
+		got := key("/items?locale=de", http.Header{"Accept-Encoding": {tt.accept}})
+		want := key("/items?locale=de", http.Header{"Accept-Encoding": {tt.same}})
+		if got != want {
+			t.Errorf("Accept-Encoding %q: key %q, want %q", tt.accept, got, want)
+		}
+	}
+	if key("/items", http.Header{"Accept-Encoding": {"gzip"}}) == key("/items", nil) {
+		t.Error("Accept-Encoding left out of the key")
+	}
+
+	if got := cacheVariantKey(cacheKeyRequest("/items?b=2&a=1", nil), nil); got != "b=2&a=1" {
+		t.Errorf("key without components = %q, want the raw query", got)
+	}
+	headerOnly := parseCacheKeyComponents([]string{"header:X-Currency"})
+	if cacheVariantKey(cacheKeyRequest("/items?page=1", nil), headerOnly) == cacheVariantKey(cacheKeyRequest("/items?page=2", nil), headerOnly) {
+		t.Error("header components dropped the query string from the key")
+	}
+}
+
+// FuzzCacheVariantKey checks that requests whose component values differ
+// never share a key, whatever the values contain.
+func FuzzCacheVariantKey(f *testing.F) {
+	f.Add("EUR", "de", "USD", "de", true, true)
+	f.Add("", "", "", "", true, false)
+	f.Add(`a" 1 "b`, "x", "a", `1 "b" x`, true, true)
+	f.Add("1 \"a\"", "", "1", "a", false, true)
+	f.Add(" ", "-", "", " -", true, true)
+
+	vary := parseCacheKeyComponents([]string{"header:X-A", "query:q", "header:X-B"})
+	request := func(a, q string, hasA bool) *http.Request {
+		r := httptest.NewRequest("GET", "/items?"+url.Values{"q": {q}}.Encode(), nil)
+		if hasA {
+			r.Header["X-A"] = []string{a}
+		}
+		r.Header["X-B"] = []string{q + a}
+		return r
+	}
+	f.Fuzz(func(t *testing.T, a1, q1, a2, q2 string, has1, has2 bool) {
+		k1 := cacheVariantKey(request(a1, q1, has1), vary)
+		k2 := cacheVariantKey(request(a2, q2, has2), vary)
+		same := has1 == has2 && (!has1 || a1 == a2) && q1 == q2 && q1+a1 == q2+a2
+		if (k1 == k2) != same {
+			t.Errorf("keys %q and %q for (%q, %q, %v) and (%q, %q, %v)", k1, k2, a1, q1, has1, a2, q2, has2)
+		}
+	})
+}
+
+func TestCacheBypassed(t *testing.T) {
+	bypass := parseCacheKeyComponents([]string{"header:Authorization", "cookie:session", "query:nocache"})
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   bool
+	}{
+		{"anonymous", "/items", nil, false},
+		{"authorization", "/items", http.Header{"Authorization": {"Bearer x"}}, true},
+		{"empty authorization", "/items", http.Header{"Authorization": {""}}, true},
+		{"session cookie", "/items", http.Header{"Cookie": {"theme=dark; session=abc"}}, true},
+		{"other cookie", "/items", http.Header{"Cookie": {"theme=dark"}}, false},
+		{"query parameter", "/items?nocache", nil, true},
+	}
+	for _, tt := range tests {
+		if got := cacheBypassed(cacheKeyRequest(tt.target, tt.header), bypass); got != tt.want {
+			t.Errorf("%s: cacheBypassed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnkeyedVary(t *testing.T) {
+	vary := parseCacheKeyComponents([]string{"header:X-Currency", "query:locale"})
+	tests := []struct {
+		vary string
+		want string
+	}{
+		{"", ""},
+		{"Accept-Encoding", ""},
+		{"x-currency, Accept-Encoding", ""},
+		{"X-Currency, Accept-Language", "Accept-Language"},
+		{"*", "*"},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.vary != "" {
+			h.Set("Vary", tt.vary)
+		}
+		if got := unkeyedVary(h, vary); got != tt.want {
+			t.Errorf("Vary %q: unkeyedVary = %q, want %q", tt.vary, got, tt.want)
+		}
+	}
+}
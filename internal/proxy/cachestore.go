@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cacheStore holds a route's cached responses. memoryCacheStore is the only
+// implementation so far and never fails; a store shared between replicas,
+// such as Redis, can, and the cache's on_error decides what requests do
+// then.
+type cacheStore interface {
+	// get returns the entry for path and variant, or nil. Expired entries
+	// are kept until they are replaced or evicted, and returned too, so
+	// they can still be served stale or revalidated.
+	get(path, variant string) (*cacheEntry, error)
+	// put stores e, replacing any entry for its path and variant.
+	put(e *cacheEntry) error
+	// replace swaps old for e if old is still cached.
+	replace(old, e *cacheEntry) error
+	// invalidate drops every entry for path and reports whether there were
+	// any.
+	invalidate(path string) (bool, error)
+}
+
+// memoryCacheStore is an LRU in process memory. Entries are grouped by path
+// so a write can drop every variant at once.
+type memoryCacheStore struct {
+	maxEntries int
+
+	mu    sync.Mutex
+	lru   *list.List // of *cacheEntry, most recently used first
+	paths map[string]map[string]*list.Element
+}
+
+func newMemoryCacheStore(maxEntries int) *memoryCacheStore {
+	return &memoryCacheStore{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		paths:      make(map[string]map[string]*list.Element),
+	}
+}
+
+func (s *memoryCacheStore) get(path, variant string) (*cacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.paths[path][variant]
+	if !ok {
+		return nil, nil
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry), nil
+}
+
+func (s *memoryCacheStore) put(e *cacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.paths[e.path][e.variant]; ok {
+		s.remove(el)
+	}
+	for s.lru.Len() >= s.maxEntries {
+		s.remove(s.lru.Back())
+	}
+
+	variants := s.paths[e.path]
+	if variants == nil {
+		variants = make(map[string]*list.Element)
+		s.paths[e.path] = variants
+	}
+	variants[e.variant] = s.lru.PushFront(e)
+	return nil
+}
+
+func (s *memoryCacheStore) replace(old, e *cacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.paths[e.path][e.variant]; ok && el.Value == old {
+		el.Value = e
+		s.lru.MoveToFront(el)
+	}
+	return nil
+}
+
+func (s *memoryCacheStore) invalidate(path string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	variants := s.paths[path]
+	for _, el := range variants {
+		s.remove(el)
+	}
+	return len(variants) > 0, nil
+}
+
+func (s *memoryCacheStore) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*cacheEntry)
+	delete(s.paths[e.path], e.variant)
+	if len(s.paths[e.path]) == 0 {
+		delete(s.paths, e.path)
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/capture"
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const defaultCaptureBodyBytes = 64 << 10
+
+// setupCapture opens the capture sink if one is configured.
+func (p *Proxy) setupCapture() error {
+	cfg := p.config.Capture
+	var sink capture.Sink
+	switch {
+	case cfg.File != "":
+		fs, err := capture.NewFileSink(cfg.File, cfg.MaxFileSize, cfg.MaxFiles)
+		if err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+		sink = fs
+	case cfg.URL != "":
+		sink = capture.NewHTTPSink(cfg.URL)
+	default:
+		return nil
+	}
+
+	p.capture = capture.NewCapturer(sink, cfg.MaxPerSecond)
+	p.redactors = make(map[*config.RouteCapture]*capture.Redactor)
+	for _, route := range p.config.Routes {
+		if route.Capture != nil {
+			p.redactors[route.Capture] = capture.NewRedactor(route.Capture.Redact)
+		}
+	}
+	return nil
+}
+
+// captureStage samples the request for the capture sink. The record is
+// completed with the response status once the request is done.
+func (p *Proxy) captureStage(req *pipelineRequest) bool {
+	rc := req.route.Capture
+	if rc == nil || p.capture == nil || rand.Float64() >= rc.SampleRate {
+		return true
+	}
+	now := time.Now()
+	if !p.capture.Allow(now) {
+		return true
+	}
+
+	r := req.r
+	redactor := p.redactors[rc]
+	rec := &capture.Record{
+		Time:   now.UTC(),
+		Route:  req.routeName,
+		Method: r.Method,
+		Host:   r.Host,
+		Path:   r.URL.Path,
+		Query:  redactor.Query(r.URL.RawQuery),
+		Header: redactor.Header(r.Header),
+	}
+
+	limit := rc.MaxBodyBytes
+	if limit == 0 {
+		limit = defaultCaptureBodyBytes
+	}
+	body, complete, err := bufferBody(r, limit)
+	if err != nil {
+		p.writeBodyError(req.w, req.routeName, err)
+		return false
+	}
+	switch {
+	case !complete:
+		rec.BodyTruncated = true
+	case len(body) > 0 && isJSONRequest(r):
+		if redacted, ok := redactor.JSON(body); ok {
+			rec.Body = redacted
+		} else {
+			rec.BodyOmitted = true
+		}
+	case isFormRequest(r):
+		rec.Body = []byte(redactor.Query(string(body)))
+	default:
+		rec.Body = body
+	}
+
+	req.onDone(func(status int) {
+		rec.Status = status
+		rec.Duration = float64(time.Since(req.start)) / float64(time.Millisecond)
+		p.capture.Add(rec)
+	})
+	return true
+}
+
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
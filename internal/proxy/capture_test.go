@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/capture"
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_Capture(t *testing.T) {
+	var gotBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Capture.File = path
+		cfg.Routes[0].Capture = &config.RouteCapture{SampleRate: 1, Redact: []string{"password"}}
+	})
+
+	const body = `{"user":"ann","password":"hunter2"}`
+	req := httptest.NewRequest("POST", "/users?api_key=k1&page=2", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if gotBody != body {
+		t.Errorf("backend received %q, want the original body", gotBody)
+	}
+
+	if err := p.capture.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []capture.Record
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var r capture.Record
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 1 {
+		t.Fatalf("captured %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.Route != "test" || r.Method != "POST" || r.Path != "/users" || r.Status != http.StatusCreated {
+		t.Errorf("record = %+v", r)
+	}
+	if r.Query != "api_key=REDACTED&page=2" {
+		t.Errorf("query = %q", r.Query)
+	}
+	if r.Header.Get("Authorization") != capture.Redacted {
+		t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+	}
+	if string(r.Body) != `{"password":"REDACTED","user":"ann"}` {
+		t.Errorf("body = %s", r.Body)
+	}
+}
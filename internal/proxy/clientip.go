@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the canonical address of the client. The peer address
+// is used unless the peer is a trusted proxy; then X-Forwarded-For is
+// walked from the right and the first hop that is not a trusted proxy is
+// the client, so entries a client prepends itself are never believed. A
+// trusted peer without X-Forwarded-For may name the client in X-Real-IP.
+func (p *Proxy) clientIP(r *http.Request) string {
+	peer, ok := parseClientIP(r.RemoteAddr)
+	if !ok {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return ip
+	}
+	if !p.trustedProxy(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedHops(r.Header)
+	if len(hops) == 0 {
+		if addr, ok := parseClientIP(r.Header.Get("X-Real-IP")); ok {
+			return addr.String()
+		}
+		return peer.String()
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseClientIP(hops[i])
+		if !ok {
+			// A malformed hop was not written by a proxy we trust, so
+			// the last address we could vouch for is the client.
+			break
+		}
+		client = addr
+		if !p.trustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedHops returns the X-Forwarded-For entries of every header line,
+// in order.
+func forwardedHops(h http.Header) []string {
+	var hops []string
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// trustedProxy reports whether addr is in server.trusted_proxies.
+func (p *Proxy) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range p.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClientIP parses an address as proxies write it: optionally bracketed,
+// with a port or an IPv6 zone. The zone is dropped and IPv4-mapped IPv6
+// addresses are unmapped, so each client has a single spelling.
+func parseClientIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		if host, _, splitErr := net.SplitHostPort(s); splitErr == nil {
+			s = host
+		} else if len(s) > 2 && s[0] == '[' && s[len(s)-1] == ']' {
+			s = s[1 : len(s)-1]
+		}
+		if addr, err = netip.ParseAddr(s); err != nil {
+			return netip.Addr{}, false
+		}
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// rateLimitIPKey maps a client IP to its per-IP rate limit bucket: the
+// address itself, or the network around it when rate_limit.ipv4_prefix or
+// ipv6_prefix is set.
+func (p *Proxy) rateLimitIPKey(clientIP string) string {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return clientIP
+	}
+	bits := p.config.RateLimit.IPv6Prefix
+	if addr.Is4() {
+		bits = p.config.RateLimit.IPv4Prefix
+	}
+	if bits <= 0 || bits >= addr.BitLen() {
+		return clientIP
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return clientIP
+	}
+	return prefix.String()
+}
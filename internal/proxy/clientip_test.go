@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_ClientIP(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "fd00::/8"}
+	})
+
+	tests := []struct {
+		name, xff, realIP, remote string
+		want                      string
+	}{
+		{"peer v4", "", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"peer v6 with zone", "", "", "[fe80::1%eth0]:1234", "fe80::1"},
+		{"peer v4-mapped", "", "", "[::ffff:192.0.2.1]:1234", "192.0.2.1"},
+		{"untrusted peer xff", "198.51.100.7", "", "192.0.2.1:1234", "192.0.2.1"},
+		{"untrusted peer real ip", "", "198.51.100.7", "192.0.2.1:1234", "192.0.2.1"},
+		{"trusted peer", "198.51.100.7", "", "10.0.0.2:1234", "198.51.100.7"},
+		{"right-most untrusted hop", "203.0.113.66, 198.51.100.7, 10.0.0.9", "", "10.0.0.2:1234", "198.51.100.7"},
+		{"only trusted hops", "10.0.0.7, 10.0.0.9", "", "10.0.0.2:1234", "10.0.0.7"},
+		{"trusted peer real ip", "", "198.51.100.8", "10.0.0.2:1234", "198.51.100.8"},
+		{"trusted v6 peer", "2001:db8::1", "", "[fd00::2]:1234", "2001:db8::1"},
+		{"xff bracketed v6", "[2001:DB8::1]", "", "10.0.0.2:1234", "2001:db8::1"},
+		{"xff v6 with port", "[2001:db8::1]:443", "", "10.0.0.2:1234", "2001:db8::1"},
+		{"xff v4 with port", "198.51.100.7:8080", "", "10.0.0.2:1234", "198.51.100.7"},
+		{"xff zone id", "fe80::1%25eth0", "", "10.0.0.2:1234", "fe80::1"},
+		{"xff expanded v6", "2001:0db8:0000:0000:0000:0000:0000:0001", "", "10.0.0.2:1234", "2001:db8::1"},
+		{"malformed hop", "198.51.100.7, unknown, 10.0.0.9", "", "10.0.0.2:1234", "10.0.0.9"},
+		{"malformed only hop", "[2001:db8::1", "", "10.0.0.2:1234", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			req.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := p.clientIP(req); got != tt.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProxy_ForwardedForSpoofing(t *testing.T) {
+	forwarded := make(chan http.Header, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = true
+		cfg.RateLimit.DefaultRPS = 1
+		cfg.RateLimit.DefaultBurst = 1
+	})
+	get := func(remote, xff string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", xff)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A direct client cannot pick its rate limit bucket by making up hops.
+	if code := get("192.0.2.1:1234", "198.51.100.1"); code != http.StatusOK {
+		t.Fatalf("first request: status = %d", code)
+	}
+	h := <-forwarded
+	if got := h.Get("X-Forwarded-For"); got != "192.0.2.1" {
+		t.Errorf("untrusted X-Forwarded-For = %q, want it replaced by the peer", got)
+	}
+	if h.Get("X-Real-IP") != "192.0.2.1" || h.Get("X-Forwarded-Proto") != "http" {
+		t.Errorf("untrusted X-Real-IP = %q, X-Forwarded-Proto = %q", h.Get("X-Real-IP"), h.Get("X-Forwarded-Proto"))
+	}
+	if code := get("192.0.2.1:1234", "198.51.100.2"); code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: status = %d, want 429", code)
+	}
+
+	// Behind a trusted proxy, the hop it saw is the client, whatever the
+	// client prepended.
+	if code := get("10.0.0.2:1234", "203.0.113.9, 198.51.100.3"); code != http.StatusOK {
+		t.Fatalf("via trusted proxy: status = %d", code)
+	}
+	h = <-forwarded
+	if got := h.Get("X-Forwarded-For"); got != "203.0.113.9, 198.51.100.3, 10.0.0.2" {
+		t.Errorf("trusted X-Forwarded-For = %q, want the chain extended with the peer", got)
+	}
+	if h.Get("X-Real-IP") != "198.51.100.3" || h.Get("X-Forwarded-Proto") != "https" {
+		t.Errorf("trusted X-Real-IP = %q, X-Forwarded-Proto = %q", h.Get("X-Real-IP"), h.Get("X-Forwarded-Proto"))
+	}
+	if code := get("10.0.0.2:1234", "203.0.113.10, 198.51.100.3"); code != http.StatusTooManyRequests {
+		t.Errorf("same client via trusted proxy: status = %d, want 429", code)
+	}
+}
+
+func TestProxy_RateLimitIPPrefix(t *testing.T) {
+	var realIP string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		realIP = r.Header.Get("X-Real-IP")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = true
+		cfg.RateLimit.DefaultRPS = 1
+		cfg.RateLimit.DefaultBurst = 1
+		cfg.RateLimit.IPv6Prefix = 64
+	})
+	get := func(remote string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("[2001:db8:1:2::1]:1234"); code != http.StatusOK {
+		t.Fatalf("first request: status = %d", code)
+	}
+	// The forwarded address is the client's own, not its /64.
+	if realIP != "2001:db8:1:2::1" {
+		t.Errorf("X-Real-IP = %q, want the client address", realIP)
+	}
+	if code := get("[2001:db8:1:2:ffff::9]:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("same /64: status = %d, want 429", code)
+	}
+	if code := get("[2001:db8:1:3::1]:1234"); code != http.StatusOK {
+		t.Errorf("other /64: status = %d, want 200", code)
+	}
+	// IPv4 clients are still limited per address.
+	if code := get("192.0.2.1:1234"); code != http.StatusOK {
+		t.Errorf("v4 client: status = %d", code)
+	}
+	if code := get("192.0.2.2:1234"); code != http.StatusOK {
+		t.Errorf("v4 neighbour: status = %d, want 200", code)
+	}
+}
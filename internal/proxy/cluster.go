@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/relaypoint/relaypoint/internal/cluster"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/ratelimit"
+)
+
+// ClusterProviders returns the state this proxy shares with peer gateways.
+func (p *Proxy) ClusterProviders() []cluster.Provider {
+	return p.clusterProviders
+}
+
+// rateLimitProvider exchanges token consumption so each replica charges its
+// buckets for requests admitted elsewhere.
+type rateLimitProvider struct {
+	limiter *ratelimit.RateLimiter
+}
+
+func (rp *rateLimitProvider) Name() string { return "ratelimit" }
+
+func (rp *rateLimitProvider) Snapshot() (json.RawMessage, error) {
+	return json.Marshal(rp.limiter.DrainUsage())
+}
+
+func (rp *rateLimitProvider) Apply(_ string, data json.RawMessage) error {
+	var usage map[string]float64
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return err
+	}
+	for key, tokens := range usage {
+		rp.limiter.ApplyUsage(key, tokens)
+	}
+	return nil
+}
+
+func (rp *rateLimitProvider) Forget(string) {}
+
+// healthProvider shares locally observed target health. A target reported
+// down by any live peer is taken out of rotation until every such peer
+// reports it healthy again or goes silent; local health checks still win on
+// their next run.
+type healthProvider struct {
+	upstreams map[string]loadbalancer.LoadBalancer
+
+	mu sync.Mutex
+	// down maps a target to the peers currently reporting it unhealthy.
+	down map[*loadbalancer.Target]map[string]bool
+}
+
+func newHealthProvider(upstreams map[string]loadbalancer.LoadBalancer) *healthProvider {
+	return &healthProvider{
+		upstreams: upstreams,
+		down:      make(map[*loadbalancer.Target]map[string]bool),
+	}
+}
+
+func (hp *healthProvider) Name() string { return "health" }
+
+func (hp *healthProvider) Snapshot() (json.RawMessage, error) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	view := make(map[string]map[string]bool, len(hp.upstreams))
+	for name, lb := range hp.upstreams {
+		targets := make(map[string]bool)
+		for _, t := range lb.Targets() {
+			// Only report our own observations, never what peers told us.
+			if len(hp.down[t]) > 0 {
+				continue
+			}
+			targets[t.URL.String()] = t.Healthy.Load()
+		}
+		view[name] = targets
+	}
+	return json.Marshal(view)
+}
+
+func (hp *healthProvider) Apply(peer string, data json.RawMessage) error {
+	var view map[string]map[string]bool
+	if err := json.Unmarshal(data, &view); err != nil {
+		return err
+	}
+
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	for name, observed := range view {
+		lb, ok := hp.upstreams[name]
+		if !ok {
+			continue
+		}
+		for _, t := range lb.Targets() {
+			healthy, ok := observed[t.URL.String()]
+			if !ok {
+				continue
+			}
+			if healthy {
+				hp.clear(lb, t, peer)
+				continue
+			}
+			if hp.down[t] == nil {
+				hp.down[t] = make(map[string]bool)
+			}
+			hp.down[t][peer] = true
+			lb.MarkHealthy(t, false)
+		}
+	}
+	return nil
+}
+
+func (hp *healthProvider) Forget(peer string) {
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+
+	for _, lb := range hp.upstreams {
+		for _, t := range lb.Targets() {
+			hp.clear(lb, t, peer)
+		}
+	}
+}
+
+// clear drops peer's unhealthy report for t and restores t once no peer
+// reports it down. Callers hold hp.mu.
+func (hp *healthProvider) clear(lb loadbalancer.LoadBalancer, t *loadbalancer.Target, peer string) {
+	peers, ok := hp.down[t]
+	if !ok || !peers[peer] {
+		return
+	}
+	delete(peers, peer)
+	if len(peers) == 0 {
+		delete(hp.down, t)
+		lb.MarkHealthy(t, true)
+	}
+}
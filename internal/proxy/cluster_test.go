@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestClusterProviders_ShareRateLimitUsage(t *testing.T) {
+	limited := func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.DefaultRPS = 1
+		cfg.RateLimit.DefaultBurst = 4
+	}
+	a := newTestProxy(t, "http://backend.internal", limited)
+	b := newTestProxy(t, "http://backend.internal", limited)
+
+	// Both replicas have seen the client once, so both hold its bucket.
+	a.rateLimiter.Allow("ip:10.0.0.1")
+	b.rateLimiter.Allow("ip:10.0.0.1")
+	a.rateLimiter.Allow("ip:10.0.0.1")
+	a.rateLimiter.Allow("ip:10.0.0.1")
+
+	exchange(t, a, b, "a")
+
+	// a used 3 tokens, b used 1 of its own; 4 of 4 are now spent on b.
+	if b.rateLimiter.Allow("ip:10.0.0.1") {
+		t.Error("b should charge the client for requests admitted by a")
+	}
+}
+
+func TestClusterProviders_ShareTargetHealth(t *testing.T) {
+	a := newTestProxy(t, "http://backend.internal", nil)
+	b := newTestProxy(t, "http://backend.internal", nil)
+
+	aTarget := a.upstreams["backend"].Targets()[0]
+	bTarget := b.upstreams["backend"].Targets()[0]
+
+	a.upstreams["backend"].MarkHealthy(aTarget, false)
+	exchange(t, a, b, "a")
+	if bTarget.Healthy.Load() {
+		t.Fatal("b should take the target out of rotation when a observes it down")
+	}
+
+	// b must not echo a's observation back as its own.
+	exchange(t, b, a, "b")
+	a.upstreams["backend"].MarkHealthy(aTarget, true)
+	exchange(t, a, b, "a")
+	if !bTarget.Healthy.Load() {
+		t.Error("b should restore the target once a reports it healthy")
+	}
+
+	a.upstreams["backend"].MarkHealthy(aTarget, false)
+	exchange(t, a, b, "a")
+	for _, provider := range b.clusterProviders {
+		provider.Forget("a")
+	}
+	if !bTarget.Healthy.Load() {
+		t.Error("b should restore the target when a is lost")
+	}
+}
+
+// exchange pushes every section of from's state into to, as if from were
+// the peer named peer.
+func exchange(t *testing.T, from, to *Proxy, peer string) {
+	t.Helper()
+	for i, provider := range from.clusterProviders {
+		data, err := provider.Snapshot()
+		if err != nil {
+			t.Fatalf("snapshot %s: %v", provider.Name(), err)
+		}
+		if err := to.clusterProviders[i].Apply(peer, data); err != nil {
+			t.Fatalf("apply %s: %v", provider.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const defaultCompressionMinSize = 1024
+
+// defaultCompressibleTypes is used when a route does not list its own.
+var defaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// gzipPools holds reusable writers, one pool per compression level from
+// gzip.HuffmanOnly to gzip.BestCompression.
+var gzipPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+func getGzipWriter(w io.Writer, level int) *gzip.Writer {
+	pool := &gzipPools[level-gzip.HuffmanOnly]
+	if gz, ok := pool.Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return gz
+	}
+	gz, _ := gzip.NewWriterLevel(w, level)
+	return gz
+}
+
+func putGzipWriter(gz *gzip.Writer, level int) {
+	gz.Reset(io.Discard)
+	gzipPools[level-gzip.HuffmanOnly].Put(gz)
+}
+
+// compressionLevel maps the configured level to a gzip level.
+func compressionLevel(cfg *config.Compression) int {
+	if cfg.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return cfg.Level
+}
+
+// isCompressible reports whether resp may be gzipped under cfg: a full,
+// identity-encoded body of an allowed type and at least the minimum size.
+func isCompressible(resp *http.Response, cfg *config.Compression) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNonAuthoritativeInfo:
+	default:
+		return false
+	}
+
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		return false
+	}
+
+	minSize := cfg.MinSize
+	if minSize == 0 {
+		minSize = defaultCompressionMinSize
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength < minSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "text/event-stream" {
+		return false
+	}
+	allowed := cfg.ContentTypes
+	if len(allowed) == 0 {
+		allowed = defaultCompressibleTypes
+	}
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client's Accept-Encoding allows gzip.
+func acceptsGzip(h http.Header) bool {
+	accepted := false
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding != "gzip" && coding != "*" {
+				continue
+			}
+			q := 1.0
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = parsed
+				}
+			}
+			if coding == "gzip" {
+				// An explicit gzip entry wins over any wildcard.
+				return q > 0
+			}
+			accepted = q > 0
+		}
+	}
+	return accepted
+}
+
+// prepareCompression adjusts the response headers in h and reports whether
+// the body should be gzipped. It must run before the header is written.
+func prepareCompression(h http.Header, r *http.Request, resp *http.Response, cfg *config.Compression) bool {
+	if cfg == nil || !isCompressible(resp, cfg) {
+		return false
+	}
+
+	// The representation depends on Accept-Encoding whether or not this
+	// particular client gets it compressed.
+	if !headerHasToken(h, "Vary", "Accept-Encoding") {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if r.Method == http.MethodHead || !acceptsGzip(r.Header) {
+		return false
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		// The compressed bytes differ from the upstream's, so the validator
+		// can only be weak.
+		h.Set("ETag", "W/"+etag)
+	}
+	return true
+}
+
+// gzipResponseWriter compresses everything written through it. Flushes push
+// the pending compressed bytes to the client before flushing the connection.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz    *gzip.Writer
+	level int
+}
+
+func newGzipResponseWriter(w http.ResponseWriter, level int) *gzipResponseWriter {
+	return &gzipResponseWriter{
+		ResponseWriter: w,
+		gz:             getGzipWriter(w, level),
+		level:          level,
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	return g.gz.Write(p)
+}
+
+func (g *gzipResponseWriter) FlushError() error {
+	if err := g.gz.Flush(); err != nil {
+		return err
+	}
+	return http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Close writes the gzip footer and returns the writer to its pool.
+func (g *gzipResponseWriter) Close() error {
+	err := g.gz.Close()
+	putGzipWriter(g.gz, g.level)
+	g.gz = nil
+	return err
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_Compression(t *testing.T) {
+	payload := strings.Repeat(`{"name":"relaypoint"},`, 100)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = io.WriteString(w, payload)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(w, payload)
+		case "/gzipped":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			_, _ = io.WriteString(gz, payload)
+			_ = gz.Close()
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Compression = &config.Compression{MinSize: 256}
+	})
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	get := func(t *testing.T, path, acceptEncoding string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("GET", gw.URL+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("compresses eligible response", func(t *testing.T) {
+		resp := get(t, "/json", "br, gzip")
+		if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Content-Encoding = %q, want gzip", got)
+		}
+		if resp.Header.Get("Content-Length") != "" {
+			t.Errorf("Content-Length should be dropped, got %q", resp.Header.Get("Content-Length"))
+		}
+		if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want Accept-Encoding", got)
+		}
+		if got := resp.Header.Get("ETag"); got != `W/"v1"` {
+			t.Errorf("ETag = %q, want weak validator", got)
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(gz)
+		if string(body) != payload {
+			t.Errorf("decompressed body does not match upstream payload")
+		}
+	})
+
+	t.Run("client without gzip", func(t *testing.T) {
+		resp := get(t, "/json", "")
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("unexpected Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+		}
+		if got := resp.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary = %q, want Accept-Encoding", got)
+		}
+	})
+
+	for _, path := range []string{"/small", "/image"} {
+		t.Run("skips "+path, func(t *testing.T) {
+			resp := get(t, path, "gzip")
+			if resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("unexpected Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+
+	t.Run("does not recompress", func(t *testing.T) {
+		resp := get(t, "/gzipped", "gzip")
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(gz)
+		if string(body) != payload {
+			t.Errorf("body was compressed twice")
+		}
+	})
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"br", false},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.header != "" {
+			h.Set("Accept-Encoding", tt.header)
+		}
+		if got := acceptsGzip(h); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+var benchPayload = []byte(strings.Repeat(`{"name":"relaypoint","kind":"gateway"},`, 256))
+
+func BenchmarkGzipResponseWriter(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		gz := newGzipResponseWriter(w, gzip.DefaultCompression)
+		_, _ = gz.Write(benchPayload)
+		_ = gz.Close()
+	}
+}
+
+// BenchmarkGzipWriterUnpooled is the baseline BenchmarkGzipResponseWriter is
+// compared against: a fresh gzip.Writer per response.
+func BenchmarkGzipWriterUnpooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write(benchPayload)
+		_ = gz.Close()
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const defaultConcurrencyQueueTimeout = time.Second
+
+// concurrencyLimiter bounds the requests a route serves at once. The slots
+// channel is the semaphore; waiting counts the requests queued for a slot.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	queueDepth   int64
+	queueTimeout time.Duration
+	waiting      atomic.Int64
+}
+
+func newConcurrencyLimiter(max int, queue *config.ConcurrencyQueue) *concurrencyLimiter {
+	l := &concurrencyLimiter{slots: make(chan struct{}, max)}
+	if queue != nil {
+		l.queueDepth = int64(queue.Depth)
+		l.queueTimeout = queue.Timeout
+		if l.queueTimeout == 0 {
+			l.queueTimeout = defaultConcurrencyQueueTimeout
+		}
+	}
+	return l
+}
+
+// acquire takes a slot, waiting in the queue if the route has one. It
+// returns why the request was shed, or "" once it holds a slot.
+func (l *concurrencyLimiter) acquire(r *http.Request) string {
+	select {
+	case l.slots <- struct{}{}:
+		return ""
+	default:
+	}
+	if l.queueDepth == 0 {
+		return "limit"
+	}
+	if l.waiting.Add(1) > l.queueDepth {
+		l.waiting.Add(-1)
+		return "queue_full"
+	}
+	defer l.waiting.Add(-1)
+	inflight := inflightFrom(r.Context())
+	inflight.setPhase(inflightQueued)
+	defer inflight.setPhase(inflightProcessing)
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return ""
+	case <-timer.C:
+		return "queue_timeout"
+	case <-r.Context().Done():
+		return "canceled"
+	}
+}
+
+func (l *concurrencyLimiter) release(int) {
+	<-l.slots
+}
+
+// setupConcurrency creates the limiters of routes with max_concurrent.
+func (p *Proxy) setupConcurrency() {
+	p.concurrency = make(map[string]*concurrencyLimiter)
+	for _, r := range p.config.Routes {
+		if r.MaxConcurrent > 0 {
+			p.concurrency[configRouteName(r)] = newConcurrencyLimiter(r.MaxConcurrent, r.ConcurrencyQueue)
+		}
+	}
+}
+
+// concurrencyStage holds one of the route's max_concurrent slots until the
+// request is answered, and sheds the request with 503 when none frees up.
+// Routes with adaptive_concurrency use their adaptive limit instead.
+func (p *Proxy) concurrencyStage(req *pipelineRequest) bool {
+	if a, ok := p.adaptive[req.routeName]; ok {
+		return p.adaptiveConcurrencyStage(req, a)
+	}
+	l, ok := p.concurrency[req.routeName]
+	if !ok {
+		return true
+	}
+	reason := l.acquire(req.r)
+	if reason == "" {
+		req.onDone(l.release)
+		return true
+	}
+	p.shedConcurrency(req, reason)
+	return false
+}
+
+// shedConcurrency answers a request over the route's concurrency limit
+// with 503.
+func (p *Proxy) shedConcurrency(req *pipelineRequest, reason string) {
+	p.metrics.RecordShed(req.routeName, reason)
+	p.metrics.RecordError(req.routeName, "concurrency_limited")
+	req.w.Header().Set("Retry-After", "1")
+	p.writeError(req.w, http.StatusServiceUnavailable, "concurrency_limited", "too many concurrent requests, retry later")
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// blockingBackend holds every request until release is closed, and reports
+// each arrival on arrived.
+func blockingBackend(t *testing.T) (srv *httptest.Server, arrived chan struct{}, release chan struct{}) {
+	t.Helper()
+	arrived = make(chan struct{}, 16)
+	release = make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, arrived, release
+}
+
+func TestProxy_MaxConcurrentSheds(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].MaxConcurrent = 2
+	})
+
+	var wg sync.WaitGroup
+	codes := make(chan int, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+			codes <- rec.Code
+		}()
+	}
+	<-arrived
+	<-arrived
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("request over the limit: status = %d, Retry-After = %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request within the limit: status = %d, want 200", code)
+		}
+	}
+
+	// The slots are free again.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after release: status = %d, want 200", rec.Code)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_requests_shed_total{key="test_limit"} 1`) {
+		t.Errorf("shed request was not counted:\n%s", metrics.Body.String())
+	}
+}
+
+func TestProxy_MaxConcurrentQueue(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].MaxConcurrent = 1
+		cfg.Routes[0].ConcurrencyQueue = &config.ConcurrencyQueue{Depth: 1, Timeout: 5 * time.Second}
+	})
+	limiter := p.concurrency["test"]
+
+	serve := func() <-chan int {
+		code := make(chan int, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+			code <- rec.Code
+		}()
+		return code
+	}
+
+	first := serve()
+	<-arrived
+	queued := serve()
+	for limiter.waiting.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request over the queue depth: status = %d, want 503", rec.Code)
+	}
+
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("first request: status = %d, want 200", code)
+	}
+	if code := <-queued; code != http.StatusOK {
+		t.Errorf("queued request: status = %d, want 200 once a slot freed", code)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_requests_shed_total{key="test_queue_full"} 1`) {
+		t.Errorf("queue_full shed was not counted")
+	}
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	l := newConcurrencyLimiter(1, &config.ConcurrencyQueue{Depth: 4, Timeout: 10 * time.Millisecond})
+	r := httptest.NewRequest("GET", "/", nil)
+	if reason := l.acquire(r); reason != "" {
+		t.Fatalf("first acquire shed: %s", reason)
+	}
+	if reason := l.acquire(r); reason != "queue_timeout" {
+		t.Errorf("acquire with the slot held = %q, want queue_timeout", reason)
+	}
+	l.release(0)
+	if reason := l.acquire(r); reason != "" {
+		t.Errorf("acquire after release shed: %s", reason)
+	}
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// configFingerprintHeader carries the configuration fingerprint on routes
+// with config_fingerprint_header.
+const configFingerprintHeader = "X-Config-Fingerprint"
+
+// configHistorySize is how many applied configurations are kept.
+const configHistorySize = 50
+
+// configSnapshot records a configuration the gateway applied.
+type configSnapshot struct {
+	Fingerprint string    `json:"fingerprint"`
+	Reason      string    `json:"reason"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// configHistory holds the most recently applied configurations, oldest
+// first.
+type configHistory struct {
+	mu        sync.Mutex
+	snapshots []configSnapshot
+	applied   *config.Config // in effect, as last recorded
+}
+
+// ConfigApplied records that cfg was applied in full, for reason such as
+// startup, and makes its fingerprint the one reported.
+func (p *Proxy) ConfigApplied(cfg *config.Config, reason string) {
+	p.ConfigUpdated(reason, func(*config.Config) *config.Config { return cfg })
+}
+
+// ConfigUpdated records a partial change, for reason such as sighup or
+// secret_rotation: update returns the configuration in effect given the
+// one in effect before, e.g. with only the settings a reload applied
+// replaced. Its fingerprint becomes the one reported, so settings that
+// were read but not applied do not show up in it.
+func (p *Proxy) ConfigUpdated(reason string, update func(applied *config.Config) *config.Config) {
+	h := &p.configHistory
+	h.mu.Lock()
+	h.applied = update(h.applied)
+	snapshot := configSnapshot{Fingerprint: h.applied.Fingerprint(), Reason: reason, AppliedAt: time.Now().UTC()}
+	h.snapshots = append(h.snapshots, snapshot)
+	if len(h.snapshots) > configHistorySize {
+		h.snapshots = h.snapshots[len(h.snapshots)-configHistorySize:]
+	}
+	h.mu.Unlock()
+	p.metrics.SetConfigFingerprint(snapshot.Fingerprint)
+}
+
+// ConfigFingerprint returns the fingerprint of the configuration applied
+// last.
+func (p *Proxy) ConfigFingerprint() string {
+	h := &p.configHistory
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.snapshots) == 0 {
+		return ""
+	}
+	return h.snapshots[len(h.snapshots)-1].Fingerprint
+}
+
+// handleConfigHistory lists the applied configurations, newest first.
+func (p *Proxy) handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	h := &p.configHistory
+	h.mu.Lock()
+	snapshots := make([]configSnapshot, len(h.snapshots))
+	for i, s := range h.snapshots {
+		snapshots[len(snapshots)-1-i] = s
+	}
+	h.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"fingerprint": p.ConfigFingerprint(),
+		"history":     snapshots,
+	})
+}
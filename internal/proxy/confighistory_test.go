@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_ConfigFingerprint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(configFingerprintHeader, "from-upstream")
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "internal", Path: "/_internal/**", Upstream: "backend", ConfigFingerprintHeader: true},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+	})
+	started := p.config.Fingerprint()
+	if got := p.ConfigFingerprint(); got != started {
+		t.Fatalf("ConfigFingerprint() = %q, want %q", got, started)
+	}
+
+	reloaded := *p.config
+	reloaded.Server.ReadTimeout += time.Second
+	p.ConfigApplied(&reloaded, "sighup")
+	if got := p.ConfigFingerprint(); got != reloaded.Fingerprint() || got == started {
+		t.Fatalf("after reload ConfigFingerprint() = %q", got)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/_internal/status", nil))
+	if got := rec.Header().Values(configFingerprintHeader); len(got) != 1 || got[0] != reloaded.Fingerprint() {
+		t.Errorf("%s = %q, want the running fingerprint", configFingerprintHeader, got)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if got := rec.Header().Get(configFingerprintHeader); got != "from-upstream" {
+		t.Errorf("other route: %s = %q, want the upstream's", configFingerprintHeader, got)
+	}
+
+	rec = httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/config/history", nil))
+	var body struct {
+		Fingerprint string           `json:"fingerprint"`
+		History     []configSnapshot `json:"history"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Fingerprint != reloaded.Fingerprint() || len(body.History) != 2 ||
+		body.History[0].Reason != "sighup" || body.History[0].Fingerprint != reloaded.Fingerprint() ||
+		body.History[1].Reason != "startup" || body.History[1].Fingerprint != started {
+		t.Errorf("history = %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `gateway_config_fingerprint_info{fingerprint="` + reloaded.Fingerprint() + `"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics missing %s", want)
+	}
+	if strings.Contains(rec.Body.String(), started) {
+		t.Error("metrics still report the startup fingerprint")
+	}
+}
+
+func TestProxy_ConfigHistoryIsBounded(t *testing.T) {
+	p := newTestProxy(t, "http://localhost:1", nil)
+	for range configHistorySize + 5 {
+		p.ConfigApplied(p.config, "sighup")
+	}
+	if n := len(p.configHistory.snapshots); n != configHistorySize {
+		t.Errorf("kept %d snapshots, want %d", n, configHistorySize)
+	}
+}
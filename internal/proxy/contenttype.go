@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// sniffLen is how much of a body http.DetectContentType looks at.
+const sniffLen = 512
+
+// errContentTypeRejected is returned by proxyRequest when the upstream's
+// response type is not allowed on the route and the response was replaced.
+var errContentTypeRejected = errors.New("response content type not allowed")
+
+// checkResponseContentType enforces the route's
+// allowed_response_content_types on resp. It reports false when the
+// response must be rejected; a sanitized response is relayed as an opaque
+// download instead. It must be called before anything else reads resp.Body.
+func (p *Proxy) checkResponseContentType(route *router.Route, resp *http.Response) bool {
+	policy := route.ResponseContentTypes
+	if policy == nil {
+		return true
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" && !bodyAllowed(resp) {
+		// Nothing a client could render.
+		return true
+	}
+
+	mediaType := "none"
+	if ct != "" {
+		mediaType = "invalid"
+		if parsed, _, err := mime.ParseMediaType(ct); err == nil && strings.Contains(parsed, "/") {
+			mediaType = parsed
+		}
+	} else if policy.Missing == "sniff" {
+		prefix := make([]byte, sniffLen)
+		n, err := io.ReadFull(resp.Body, prefix)
+		prefix = prefix[:n]
+		resp.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(prefix), resp.Body), closer: resp.Body}
+		if n > 0 || err == io.EOF || err == io.ErrUnexpectedEOF {
+			detected := http.DetectContentType(prefix)
+			mediaType, _, _ = mime.ParseMediaType(detected)
+			if mediaTypeAllowed(mediaType, policy.Types) {
+				// Name the detected type so clients do not sniff it again.
+				resp.Header.Set("Content-Type", detected)
+			}
+		}
+	}
+	if mediaTypeAllowed(mediaType, policy.Types) {
+		return true
+	}
+
+	routeName := routeNameOf(route)
+	p.metrics.RecordContentTypeViolation(routeName, mediaType)
+	if policy.Mode == "sanitize" {
+		resp.Header.Set("Content-Type", "application/octet-stream")
+		resp.Header.Set("X-Content-Type-Options", "nosniff")
+		return true
+	}
+	p.logger.Warn("upstream response content type rejected", "route", routeName, "content_type", mediaType)
+	return false
+}
+
+// bodyAllowed reports whether resp can carry a body.
+func bodyAllowed(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return resp.ContentLength != 0
+}
+
+// mediaTypeAllowed matches a parsed, lower-case media type against the
+// allowed list, where type/* allows a whole type.
+func mediaTypeAllowed(mediaType string, allowed []string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(a, mediaType) {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mediaType, strings.ToLower(prefix)+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// typedBackend answers each path with the Content-Type named by its query,
+// or none for ?type=, and a body starting with <html>.
+func typedBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.URL.Query().Get("type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		} else {
+			w.Header()["Content-Type"] = nil // keep net/http from sniffing
+		}
+		if r.URL.Query().Get("body") == "json" {
+			_, _ = w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		_, _ = w.Write([]byte("<html><script>alert(1)</script></html>"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxy_ResponseContentTypes(t *testing.T) {
+	backend := typedBackend(t)
+
+	tests := []struct {
+		name     string
+		policy   config.ResponseContentTypes
+		query    string
+		status   int
+		wantType string
+		nosniff  bool
+		metric   string
+	}{
+		{"allowed", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=application/json&body=json", 200, "application/json", false, ""},
+		{"charset parameter", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=application/json%3B+charset%3Dutf-8&body=json", 200, "application/json; charset=utf-8", false, ""},
+		{"case-insensitive", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=Application/JSON&body=json", 200, "Application/JSON", false, ""},
+		{"wildcard", config.ResponseContentTypes{Types: []string{"image/*"}}, "type=image/png", 200, "image/png", false, ""},
+		{"reject", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=text/html", 502, "application/json", false, "text/html"},
+		{"sanitize", config.ResponseContentTypes{Types: []string{"application/json"}, Mode: "sanitize"}, "type=text/html", 200, "application/octet-stream", true, "text/html"},
+		{"missing is a violation", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=&body=json", 502, "application/json", false, "none"},
+		{"missing sniffed allowed", config.ResponseContentTypes{Types: []string{"text/plain"}, Missing: "sniff"}, "type=&body=json", 200, "text/plain; charset=utf-8", false, ""},
+		{"missing sniffed html", config.ResponseContentTypes{Types: []string{"application/json"}, Missing: "sniff", Mode: "sanitize"}, "type=", 200, "application/octet-stream", true, "text/html"},
+		{"invalid", config.ResponseContentTypes{Types: []string{"application/json"}}, "type=json", 502, "application/json", false, "invalid"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := tt.policy
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.Routes[0].AllowedResponseContentTypes = &policy
+			})
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/api?"+tt.query, nil))
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := rec.Header().Get("X-Content-Type-Options") == "nosniff"; tt.status == http.StatusOK && got != tt.nosniff {
+				t.Errorf("nosniff = %v, want %v", got, tt.nosniff)
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), "ok") && !strings.Contains(rec.Body.String(), "html") {
+				t.Errorf("body was not relayed: %q", rec.Body.String())
+			}
+			if tt.status == http.StatusBadGateway && strings.Contains(rec.Body.String(), "script") {
+				t.Errorf("rejected body reached the client: %q", rec.Body.String())
+			}
+
+			metrics := httptest.NewRecorder()
+			p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+			recorded := strings.Contains(metrics.Body.String(), "gateway_response_content_type_violations_total{")
+			if tt.metric == "" && recorded {
+				t.Errorf("allowed response was counted as a violation")
+			}
+			if tt.metric != "" && !strings.Contains(metrics.Body.String(), `gateway_response_content_type_violations_total{key="test_`+tt.metric+`"} 1`) {
+				t.Errorf("violation %s was not counted", tt.metric)
+			}
+			if tt.status == http.StatusBadGateway && !strings.Contains(metrics.Body.String(), `gateway_errors_total{key="test_content_type_rejected"} 1`) {
+				t.Errorf("rejection was not counted as content_type_rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// apiKeyQueryParams returns the query parameters extractAPIKey reads a key
+// from, in order: api_key, then the ones strip_credentials adds.
+func apiKeyQueryParams(sc config.StripCredentials) []string {
+	return append([]string{"api_key"}, sc.QueryParams...)
+}
+
+// stripQueryParams removes every occurrence of the named parameters from
+// rawQuery. The other parameters are kept as sent, in order.
+func stripQueryParams(rawQuery string, names []string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	kept := parts[:0]
+	for _, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if name, err := url.QueryUnescape(key); err == nil && slices.Contains(names, name) {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, "&")
+}
+
+// stripCredentialHeaders removes the headers extractAPIKey reads a key
+// from. Authorization is kept when it holds another scheme, such as Basic.
+func stripCredentialHeaders(h http.Header) {
+	h.Del("X-API-Key")
+	auth := h.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") || strings.HasPrefix(auth, "ApiKey ") {
+		h.Del("Authorization")
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestStripQueryParams(t *testing.T) {
+	names := []string{"api_key", "token"}
+	tests := []struct {
+		query, want string
+	}{
+		{"", ""},
+		{"api_key=s3cret", ""},
+		{"page=2&api_key=s3cret&sort=desc", "page=2&sort=desc"},
+		{"api%5Fkey=s3cret&token&q=a%20b", "q=a%20b"},
+		{"api_keys=x&page=2", "api_keys=x&page=2"},
+	}
+	for _, tt := range tests {
+		if got := stripQueryParams(tt.query, names); got != tt.want {
+			t.Errorf("stripQueryParams(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// recordingBackend answers every request and keeps the last one it saw.
+func recordingBackend(t *testing.T) (*httptest.Server, func() *http.Request) {
+	t.Helper()
+	last := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-last:
+		default:
+		}
+		last <- r
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() *http.Request { return <-last }
+}
+
+func TestProxy_StripCredentials(t *testing.T) {
+	backend, received := recordingBackend(t)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.RequireAPIKey = true
+		cfg.Server.StripCredentials.QueryParams = []string{"access_token"}
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = false
+		cfg.RateLimit.PerAPIKey = true
+		cfg.APIKeys = []config.APIKey{{Key: "s3cret", Name: "partner", RequestsPerSecond: 1, BurstSize: 1, Enabled: true}}
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items?page=2&api_key=s3cret", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 for a key in the query", rec.Code)
+	}
+	if got := received().URL.RawQuery; got != "page=2" {
+		t.Errorf("upstream query = %q, want page=2", got)
+	}
+
+	// The key is still what the rate limit counts.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items?access_token=s3cret", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429 from the key's rate limit", rec.Code)
+	}
+}
+
+func TestProxy_StripCredentialHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		strip      config.StripCredentials
+		header     string
+		value      string
+		wantHeader bool
+		wantQuery  string
+	}{
+		{"query only by default", config.StripCredentials{Enabled: true}, "X-API-Key", "s3cret", true, ""},
+		{"api key header", config.StripCredentials{Enabled: true, Headers: true}, "X-API-Key", "s3cret", false, ""},
+		{"bearer", config.StripCredentials{Enabled: true, Headers: true}, "Authorization", "Bearer s3cret", false, ""},
+		{"basic auth is kept", config.StripCredentials{Enabled: true, Headers: true}, "Authorization", "Basic dXNlcjpwdw==", true, ""},
+		{"disabled", config.StripCredentials{Headers: true}, "X-API-Key", "s3cret", true, "api_key=s3cret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, received := recordingBackend(t)
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.Server.StripCredentials = tt.strip
+				cfg.APIKeys = []config.APIKey{{Key: "s3cret", Name: "partner", Enabled: true}}
+			})
+
+			req := httptest.NewRequest("GET", "/items?api_key=s3cret", nil)
+			req.Header.Set(tt.header, tt.value)
+			p.ServeHTTP(httptest.NewRecorder(), req)
+			got := received()
+			if (got.Header.Get(tt.header) != "") != tt.wantHeader {
+				t.Errorf("upstream %s = %q, want present=%v", tt.header, got.Header.Get(tt.header), tt.wantHeader)
+			}
+			if got.URL.RawQuery != tt.wantQuery {
+				t.Errorf("upstream query = %q, want %q", got.URL.RawQuery, tt.wantQuery)
+			}
+		})
+	}
+}
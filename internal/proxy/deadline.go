@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const defaultDeadlineHeader = "X-Request-Timeout"
+
+// errDeadlineExhausted is returned instead of sending an upstream attempt
+// when less than min_remaining of the request's budget is left.
+var errDeadlineExhausted = errors.New("request deadline exhausted")
+
+// withClientDeadline bounds r by the budget the client sent in the route's
+// client_header, counted from start and capped at max_client_timeout. The
+// route's own timeout still applies on top. Missing or invalid values leave
+// r as it is, as are WebSocket upgrades, which no timeout applies to.
+func withClientDeadline(r *http.Request, route *router.Route, start time.Time) (*http.Request, context.CancelFunc) {
+	d := route.DeadlinePropagation
+	if d == nil || d.ClientHeader == "" || isWebSocketRequest(r) {
+		return r, func() {}
+	}
+	ms, err := strconv.ParseInt(r.Header.Get(d.ClientHeader), 10, 64)
+	if err != nil || ms <= 0 {
+		return r, func() {}
+	}
+	budget := time.Duration(ms) * time.Millisecond
+	if d.MaxClientTimeout > 0 && budget > d.MaxClientTimeout {
+		budget = d.MaxClientTimeout
+	}
+	ctx, cancel := context.WithDeadline(r.Context(), start.Add(budget))
+	return r.WithContext(ctx), cancel
+}
+
+// stampDeadline sets the route's deadline header on an upstream attempt to
+// the milliseconds left until its context's deadline, computed as it is
+// about to be sent, so each later attempt carries a smaller budget.
+// Requests without a deadline are left alone.
+func stampDeadline(upstreamReq *http.Request, route *router.Route) error {
+	d := route.DeadlinePropagation
+	if d == nil {
+		return nil
+	}
+	deadline, ok := upstreamReq.Context().Deadline()
+	if !ok {
+		return nil
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 || remaining < d.MinRemaining {
+		return errDeadlineExhausted
+	}
+	header := d.Header
+	if header == "" {
+		header = defaultDeadlineHeader
+	}
+	upstreamReq.Header.Set(header, strconv.FormatInt(remaining.Milliseconds(), 10))
+	return nil
+}
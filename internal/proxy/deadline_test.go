@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// budgetBackend records the X-Request-Timeout of every request it gets.
+func budgetBackend(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("X-Request-Timeout"))
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), seen...)
+	}
+}
+
+func TestProxy_DeadlinePropagation(t *testing.T) {
+	backend, seen := budgetBackend(t)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Timeout = 2 * time.Second
+		cfg.Routes[0].DeadlinePropagation = &config.DeadlinePropagation{
+			ClientHeader:     "X-Client-Timeout",
+			MaxClientTimeout: 500 * time.Millisecond,
+			MinRemaining:     50 * time.Millisecond,
+		}
+	})
+
+	tests := []struct {
+		name     string
+		client   string
+		min, max int64
+	}{
+		{"route timeout", "", 1500, 2000},
+		{"client budget", "300", 200, 300},
+		{"capped client budget", "60000", 400, 500},
+		{"invalid client budget", "soon", 1500, 2000},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.client != "" {
+				req.Header.Set("X-Client-Timeout", tt.client)
+			}
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			got := seen()
+			if len(got) != i+1 {
+				t.Fatalf("upstream got %d requests, want %d", len(got), i+1)
+			}
+			ms, err := strconv.ParseInt(got[i], 10, 64)
+			if err != nil || ms < tt.min || ms > tt.max {
+				t.Errorf("X-Request-Timeout = %q, want between %d and %d", got[i], tt.min, tt.max)
+			}
+		})
+	}
+
+	t.Run("below min_remaining", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/items", nil)
+		req.Header.Set("X-Client-Timeout", "20")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want 504", rec.Code)
+		}
+		if n := len(seen()); n != len(tests) {
+			t.Errorf("upstream got %d requests, want the attempt skipped", n)
+		}
+	})
+}
+
+func TestProxy_DeadlinePropagation_NoDeadline(t *testing.T) {
+	backend, seen := budgetBackend(t)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].DeadlinePropagation = &config.DeadlinePropagation{Header: "X-Budget"}
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("X-Request-Timeout", "100")
+	p.ServeHTTP(rec, req)
+	// The client's header is not read without client_header and passes
+	// through as any other header.
+	if got := seen(); len(got) != 1 || got[0] != "100" {
+		t.Errorf("upstream X-Request-Timeout = %q", got)
+	}
+}
+
+func TestProxy_DeadlinePropagation_Hedge(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		budgets []int64
+	)
+	var urls []string
+	for range 2 {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ms, _ := strconv.ParseInt(r.Header.Get("X-Request-Timeout"), 10, 64)
+			mu.Lock()
+			budgets = append(budgets, ms)
+			first := len(budgets) == 1
+			mu.Unlock()
+			if first {
+				<-r.Context().Done()
+			}
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	p := newTestProxy(t, urls[0], func(cfg *config.Config) {
+		cfg.Upstreams[0].Targets = append(cfg.Upstreams[0].Targets, config.Target{URL: urls[1]})
+		cfg.Routes[0].Hedging = &config.Hedging{Delay: 100 * time.Millisecond}
+		cfg.Routes[0].Timeout = time.Second
+		cfg.Routes[0].DeadlinePropagation = &config.DeadlinePropagation{}
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(budgets) != 2 || budgets[1] > budgets[0]-100 {
+		t.Errorf("budgets = %v, want the hedge to carry at least 100ms less", budgets)
+	}
+}
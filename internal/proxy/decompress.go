@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// errRequestDecompression is wrapped by errors reading a request body the
+// gateway is decompressing, when the body is not valid for its encoding.
+var errRequestDecompression = errors.New("request body could not be decompressed")
+
+// decompressStage replaces a gzip or deflate request body with the
+// identity-encoded stream on routes with decompress_request, so upstreams
+// that cannot decode it receive plain bytes. The route's max body size is
+// enforced on the decompressed output. Other encodings pass through.
+func (p *Proxy) decompressStage(req *pipelineRequest) bool {
+	r := req.r
+	if !req.route.DecompressRequest || r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	// Only a single coding is decoded; stacked ones are left to the upstream.
+	codings := r.Header.Values("Content-Encoding")
+	if len(codings) != 1 {
+		return true
+	}
+	encoding := strings.ToLower(strings.TrimSpace(codings[0]))
+	src := &sourceBody{ReadCloser: r.Body}
+	var (
+		zr  io.ReadCloser
+		err error
+	)
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err = gzip.NewReader(src)
+	case "deflate":
+		zr, err = zlib.NewReader(src)
+	default:
+		return true
+	}
+	if err != nil {
+		if src.err != nil {
+			p.writeBodyError(req.w, req.routeName, src.err)
+		} else {
+			p.writeDecompressionError(req.w, req.routeName)
+		}
+		return false
+	}
+
+	var body io.ReadCloser = &decompressedBody{zr: zr, src: src}
+	if limit := p.maxBodySize(req.route); limit > 0 {
+		body = http.MaxBytesReader(req.w, body, limit)
+	}
+	r.Body = body
+	r.GetBody = nil
+	r.ContentLength = -1
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	return true
+}
+
+// writeDecompressionError answers a request whose body is not valid for its
+// Content-Encoding with 400.
+func (p *Proxy) writeDecompressionError(w http.ResponseWriter, routeName string) {
+	p.metrics.RecordRequestDecompressionFailure(routeName)
+	p.metrics.RecordError(routeName, "decompression_failed")
+	p.writeError(w, http.StatusBadRequest, "decompression_failed", "request body could not be decompressed")
+}
+
+// decompressedBody reads the decoded stream of src, marking corrupt input
+// with errRequestDecompression while leaving errors reading src itself,
+// such as an exceeded limit, as they are.
+type decompressedBody struct {
+	zr  io.ReadCloser
+	src *sourceBody
+}
+
+func (d *decompressedBody) Read(b []byte) (int, error) {
+	n, err := d.zr.Read(b)
+	if err != nil && err != io.EOF && (d.src.err == nil || !errors.Is(err, d.src.err)) {
+		err = fmt.Errorf("%w: %v", errRequestDecompression, err)
+	}
+	return n, err
+}
+
+func (d *decompressedBody) Close() error {
+	_ = d.zr.Close()
+	return d.src.Close()
+}
+
+// sourceBody remembers the last error reading the compressed body.
+type sourceBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (s *sourceBody) Read(b []byte) (int, error) {
+	n, err := s.ReadCloser.Read(b)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
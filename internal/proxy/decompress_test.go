@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProxy_DecompressRequest(t *testing.T) {
+	type received struct {
+		encoding string
+		body     string
+	}
+	got := make(chan received, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		got <- received{r.Header.Get("Content-Encoding"), string(body)}
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].DecompressRequest = true
+		cfg.Routes[0].MaxBodySize = 1024
+	})
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	_, _ = zw.Write([]byte("deflated body"))
+	_ = zw.Close()
+	gzipped := gzipBytes(t, []byte("gzipped body"))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		want     int
+		upstream *received
+	}{
+		{"gzip", "gzip", gzipped, http.StatusOK, &received{"", "gzipped body"}},
+		{"x-gzip", "X-Gzip", gzipped, http.StatusOK, &received{"", "gzipped body"}},
+		{"deflate", "deflate", deflated.Bytes(), http.StatusOK, &received{"", "deflated body"}},
+		{"unknown encoding", "br", []byte("opaque"), http.StatusOK, &received{"br", "opaque"}},
+		{"stacked encodings", "gzip, br", []byte("opaque"), http.StatusOK, &received{"gzip, br", "opaque"}},
+		{"identity", "", []byte("plain"), http.StatusOK, &received{"", "plain"}},
+		{"bad header", "gzip", []byte("not gzip at all"), http.StatusBadRequest, nil},
+		{"zip bomb", "gzip", gzipBytes(t, make([]byte, 1<<20)), http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", gw.URL+"/upload", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if tt.upstream == nil {
+				select {
+				case r := <-got:
+					if tt.want == http.StatusBadRequest {
+						t.Errorf("upstream received %+v", r)
+					}
+				default:
+				}
+				return
+			}
+			if r := <-got; r != *tt.upstream {
+				t.Errorf("upstream received %+v, want %+v", r, *tt.upstream)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gateway_request_decompression_failures_total{route="test"} 1`) {
+		t.Error("decompression failure not counted")
+	}
+}
+
+func TestProxy_DecompressRequest_CorruptStream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].DecompressRequest = true
+	})
+
+	// A valid header followed by a truncated stream fails only once the
+	// body is read on its way upstream.
+	body := gzipBytes(t, bytes.Repeat([]byte("relaypoint "), 1000))
+	body = body[:len(body)/2]
+	req := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
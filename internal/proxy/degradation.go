@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/degradation"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// globalLadderScope names the top-level degradation ladder in logs and
+// metrics.
+const globalLadderScope = "global"
+
+// setupDegradation creates a ladder for the top-level degradation block and
+// for each route with its own, and starts evaluating them.
+func (p *Proxy) setupDegradation() {
+	p.ladders = make(map[*config.Degradation]*degradation.Ladder)
+	if cfg := p.config.Degradation; cfg != nil {
+		p.ladders[cfg] = degradation.New(globalLadderScope, cfg, p.metrics, p.logger)
+	}
+	for _, route := range p.config.Routes {
+		if route.Degradation != nil {
+			name := route.Name
+			if name == "" {
+				name = route.Path
+			}
+			p.ladders[route.Degradation] = degradation.New(name, route.Degradation, p.metrics, p.logger)
+		}
+	}
+
+	p.ladderStop = make(chan struct{})
+	for _, ladder := range p.ladders {
+		go p.evaluateLoop(ladder)
+	}
+}
+
+func (p *Proxy) evaluateLoop(ladder *degradation.Ladder) {
+	ticker := time.NewTicker(ladder.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ladder.Evaluate()
+		case <-p.ladderStop:
+			return
+		}
+	}
+}
+
+// ladderFor returns the ladder governing route, or nil.
+func (p *Proxy) ladderFor(route *router.Route) *degradation.Ladder {
+	if route.Degradation != nil {
+		return p.ladders[route.Degradation]
+	}
+	return p.ladders[p.config.Degradation]
+}
+
+// degraded reports whether a degradation level with action is in effect
+// for route.
+func (p *Proxy) degraded(route *router.Route, action string) bool {
+	ladder := p.ladderFor(route)
+	return ladder != nil && ladder.Active(action)
+}
+
+// admitDegraded sheds or rejects the request if the route's ladder calls
+// for it, and otherwise counts it towards the ladder's load.
+func (p *Proxy) admitDegraded(req *pipelineRequest) bool {
+	ladder := p.ladderFor(req.route)
+	if ladder == nil {
+		return true
+	}
+	shed := ladder.Active(config.DegradeReject)
+	if pct := ladder.ShedPercent(); !shed && pct > 0 {
+		shed = rand.Float64()*100 < pct
+	}
+	if shed {
+		p.metrics.RecordError(req.routeName, "load_shed")
+		req.w.Header().Set("Retry-After", "1")
+		p.writeError(req.w, http.StatusServiceUnavailable, "load_shed", "overloaded, retry later")
+		return false
+	}
+	req.onDone(ladder.Begin())
+	return true
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_Degradation(t *testing.T) {
+	backend, count := countingBackend(t)
+	ladder := &config.Degradation{
+		Interval: time.Hour, // evaluated by hand below
+		Levels: []config.DegradationLevel{
+			{Name: "no-transforms", Action: config.DegradeDisableTransforms, Enter: config.DegradationThresholds{InFlight: 10}},
+			{Name: "stale", Action: config.DegradeServeStale, Enter: config.DegradationThresholds{InFlight: 10}},
+			{Name: "reject", Action: config.DegradeReject, Enter: config.DegradationThresholds{InFlight: 10}},
+		},
+	}
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Compression = &config.Compression{MinSize: 1}
+		cfg.Routes[0].Cache = &config.Cache{TTL: time.Nanosecond}
+		cfg.Routes[0].ResponseHeaders = &config.HeaderRules{Set: map[string]string{"X-Transformed": "yes"}}
+		cfg.Routes[0].Degradation = ladder
+	})
+	l := p.ladders[ladder]
+
+	get := func(path string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Normal operation: transforms apply, and the expired entry is refetched.
+	rec := get("/items")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("X-Transformed") != "yes" {
+		t.Fatalf("transforms not applied at level 0: %v", rec.Header())
+	}
+	get("/items")
+	if n := count("GET /items"); n != 2 {
+		t.Fatalf("backend saw %d requests at level 0, want 2", n)
+	}
+
+	// Requests stuck upstream push the ladder up one level per evaluation.
+	for i := 0; i < 10; i++ {
+		defer l.Begin()(http.StatusOK)
+	}
+
+	l.Evaluate()
+	rec = get("/other")
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("X-Transformed") != "" {
+		t.Errorf("transforms applied at level 1: %v", rec.Header())
+	}
+
+	l.Evaluate()
+	rec = get("/items")
+	if rec.Header().Get("X-Cache") != "STALE" || rec.Body.String() != "hello /items" {
+		t.Errorf("level 2: X-Cache = %q, body %q, want a stale hit", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if n := count("GET /items"); n != 2 {
+		t.Errorf("stale hit reached the backend")
+	}
+
+	l.Evaluate()
+	rec = get("/items")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("level 3: status = %d, want 503 with Retry-After", rec.Code)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_degradation_level{scope="test"} 3`,
+		`gateway_errors_total{key="test_load_shed"} 1`,
+		`gateway_cache_operations_total{key="test_stale_hit"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// anyMethod is what Allow lists for a route that accepts every method.
+var anyMethod = []string{
+	http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodOptions,
+	http.MethodPatch, http.MethodPost, http.MethodPut,
+}
+
+// methodDenied reports whether server.denied_methods or the route's
+// denied_methods name method.
+func (p *Proxy) methodDenied(route *router.Route, method string) bool {
+	if method == http.MethodOptions && route.OptionsPassthrough {
+		return false
+	}
+	return p.deniedMethods[method] || route.DeniedMethods[method]
+}
+
+// allowedMethods returns the methods listed in Allow for a 405 from route:
+// those it accepts, less the denied ones.
+func (p *Proxy) allowedMethods(route *router.Route) []string {
+	methods := route.AllowedMethods()
+	if methods == nil {
+		methods = anyMethod
+	}
+	allowed := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if !p.methodDenied(route, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
+// rejectDeniedMethod answers 405 to a request whose method, after any
+// override or method_map, is denied. Unlike a method mismatch this never
+// falls through to another route.
+func (p *Proxy) rejectDeniedMethod(req *pipelineRequest) bool {
+	if !p.methodDenied(req.route, req.r.Method) {
+		return false
+	}
+	p.metrics.RecordError(req.routeName, "method_denied")
+	req.w.Header().Set("Allow", strings.Join(p.allowedMethods(req.route), ", "))
+	p.writeError(req.w, http.StatusMethodNotAllowed, "method_denied", "method not allowed")
+	return true
+}
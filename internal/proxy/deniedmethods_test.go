@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_DeniedMethods(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.DeniedMethods = []string{"TRACE", "TRACK", "OPTIONS"}
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = false
+		cfg.RateLimit.PerAPIKey = false
+		cfg.Routes = []config.Route{
+			{
+				Name: "public", Path: "/public/**", Upstream: "backend",
+				Methods:       []string{"GET", "POST", "DELETE", "OPTIONS"},
+				DeniedMethods: []string{"delete"},
+				RateLimit:     &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 1, BurstSize: 1},
+			},
+			{Name: "items", Path: "/items/*", Upstream: "backend", HonorMethodOverride: true, DeniedMethods: []string{"DELETE"}},
+			{Name: "cors", Path: "/cors/**", Upstream: "backend", OptionsPassthrough: true},
+		}
+	})
+
+	send := func(method, path, override string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, nil)
+		if override != "" {
+			req.Header.Set("X-HTTP-Method-Override", override)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		method, path, override string
+		want                   int
+		allow                  string
+	}{
+		{"TRACE", "/public/a", "", http.StatusMethodNotAllowed, "GET, POST"},
+		{"TRACK", "/public/a", "", http.StatusMethodNotAllowed, "GET, POST"},
+		{"DELETE", "/public/a", "", http.StatusMethodNotAllowed, "GET, POST"},
+		{"OPTIONS", "/public/a", "", http.StatusMethodNotAllowed, "GET, POST"},
+		// The check applies to the overridden method.
+		{"POST", "/items/1", "DELETE", http.StatusMethodNotAllowed, "GET, HEAD, PATCH, POST, PUT"},
+		{"TRACE", "/cors/a", "", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, OPTIONS, PATCH, POST, PUT"},
+		{"OPTIONS", "/cors/a", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := send(tt.method, tt.path, tt.override)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			continue
+		}
+		if got := rec.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+
+	// Denied requests are rejected before rate limiting, so the single
+	// token of the public route is still there.
+	if rec := send("GET", "/public/a", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET after denied requests: status = %d, want 200", rec.Code)
+	}
+
+	test := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(test, httptest.NewRequest("GET", "/admin/routes/test?method=delete&path=/public/a", nil))
+	for _, want := range []string{`"status":405`, `"route":"public"`, `"denied":true`, `"allow":["GET","POST"]`} {
+		if !strings.Contains(test.Body.String(), want) {
+			t.Errorf("route test missing %s: %s", want, test.Body.String())
+		}
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_errors_total{key="public_method_denied"} 2`,
+		`gateway_errors_total{key="items_method_denied"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
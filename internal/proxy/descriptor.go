@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// descriptorVersion is bumped whenever a field of the descriptor changes
+// meaning or is removed. Adding fields does not bump it.
+const descriptorVersion = 1
+
+// descriptor is the machine-readable public contract of the gateway served
+// at /admin/descriptor, meant for client generators. It is derived only
+// from the resolved config and the constants below, and its ordering is
+// stable so it can be committed and diffed.
+type descriptor struct {
+	Version          int                  `json:"version"`
+	RequestIDHeader  string               `json:"request_id_header"`
+	Auth             descriptorAuth       `json:"auth"`
+	RateLimits       descriptorRateLimits `json:"rate_limits"`
+	Errors           descriptorErrors     `json:"errors"`
+	ContentEncodings []string             `json:"content_encodings"`
+	Routes           []descriptorRoute    `json:"routes"`
+}
+
+type descriptorAuth struct {
+	// Credentials lists where the gateway looks for an API key, in order.
+	Credentials []string `json:"credentials"`
+}
+
+type descriptorRateLimits struct {
+	Enabled      bool     `json:"enabled"`
+	Scopes       []string `json:"scopes"`
+	DefaultRPS   int      `json:"default_rps,omitempty"`
+	DefaultBurst int      `json:"default_burst,omitempty"`
+	Status       int      `json:"status"`
+	Headers      []string `json:"headers"`
+}
+
+type descriptorErrors struct {
+	// Envelope is the JSON shape of errors with format "json".
+	Envelope map[string]string `json:"envelope"`
+	Codes    []gatewayError    `json:"codes"`
+}
+
+type descriptorRoute struct {
+	Name             string               `json:"name"`
+	Host             string               `json:"host,omitempty"`
+	Hosts            []string             `json:"hosts,omitempty"`
+	Path             string               `json:"path"`
+	Methods          []string             `json:"methods"`
+	Auth             string               `json:"auth"`
+	RateLimit        *descriptorRateLimit `json:"rate_limit,omitempty"`
+	MaxBodySize      int64                `json:"max_body_size,omitempty"`
+	ContentEncodings []string             `json:"content_encodings,omitempty"`
+}
+
+type descriptorRateLimit struct {
+	RequestsPerSecond int `json:"requests_per_second"`
+	Burst             int `json:"burst"`
+}
+
+// gatewayError is a response the gateway generates itself rather than
+// relaying from an upstream. Type matches the gateway_errors_total label
+// where one is recorded.
+type gatewayError struct {
+	Type   string `json:"type"`
+	Status int    `json:"status"`
+	// Format is "json" for the standard envelope, "text" or "template"
+	// as set by error_responses.
+	Format string `json:"format"`
+}
+
+// gatewayErrors enumerates every gateway-generated error, sorted by type.
+var gatewayErrors = []gatewayError{
+	{Type: "auth_failed", Status: http.StatusUnauthorized},
+	{Type: "auth_failed", Status: http.StatusForbidden},
+	{Type: "auth_unavailable", Status: http.StatusServiceUnavailable},
+	{Type: "awaiting_health_check", Status: http.StatusServiceUnavailable},
+	{Type: "body_read_error", Status: http.StatusBadRequest},
+	{Type: "body_limit_learned", Status: http.StatusRequestEntityTooLarge},
+	{Type: "body_too_large", Status: http.StatusRequestEntityTooLarge},
+	{Type: "cache_unavailable", Status: http.StatusServiceUnavailable},
+	{Type: "concurrency_limited", Status: http.StatusServiceUnavailable},
+	{Type: "content_type_rejected", Status: http.StatusBadGateway},
+	{Type: "deadline_exhausted", Status: http.StatusGatewayTimeout},
+	{Type: "decompression_failed", Status: http.StatusBadRequest},
+	{Type: "idempotency_key_reused", Status: http.StatusUnprocessableEntity},
+	{Type: "internal_error", Status: http.StatusInternalServerError},
+	{Type: "invalid_idempotency_key", Status: http.StatusBadRequest},
+	{Type: "invalid_path", Status: http.StatusBadRequest},
+	{Type: "ip_denied", Status: http.StatusForbidden},
+	{Type: "load_shed", Status: http.StatusServiceUnavailable},
+	{Type: "maintenance", Status: http.StatusServiceUnavailable},
+	{Type: "method_denied", Status: http.StatusMethodNotAllowed},
+	{Type: "method_not_allowed", Status: http.StatusMethodNotAllowed},
+	{Type: "method_override_denied", Status: http.StatusBadRequest},
+	{Type: "no_healthy_upstream", Status: http.StatusServiceUnavailable},
+	{Type: "not_found", Status: http.StatusNotFound},
+	{Type: "outside_base_path", Status: http.StatusNotFound},
+	{Type: "proxy_error", Status: http.StatusBadGateway},
+	{Type: "quota_exceeded", Status: http.StatusTooManyRequests},
+	{Type: "quota_unavailable", Status: http.StatusServiceUnavailable},
+	{Type: "rate_limit_unavailable", Status: http.StatusServiceUnavailable},
+	{Type: "rate_limited", Status: http.StatusTooManyRequests},
+	{Type: "read_only", Status: http.StatusServiceUnavailable},
+	{Type: "route_archived", Status: http.StatusGone},
+	{Type: "route_tripped", Status: http.StatusServiceUnavailable},
+	{Type: "schema_violation", Status: http.StatusBadRequest},
+	{Type: "tls_fingerprint_denied", Status: http.StatusForbidden},
+	{Type: "unsupported_media_type", Status: http.StatusUnsupportedMediaType},
+	{Type: "upstream_auth_unavailable", Status: http.StatusServiceUnavailable},
+	{Type: "upstream_disabled", Status: http.StatusServiceUnavailable},
+	{Type: "upstream_not_found", Status: http.StatusBadGateway},
+	{Type: "upstream_stalled", Status: http.StatusGatewayTimeout},
+}
+
+// apiKeyCredentials lists where extractAPIKey looks for a key, in order.
+var apiKeyCredentials = []string{
+	"header:Authorization:Bearer",
+	"header:Authorization:ApiKey",
+	"header:X-API-Key",
+	"query:api_key",
+}
+
+// handleDescriptor serves the descriptor indented, so a committed copy
+// diffs line by line.
+func (p *Proxy) handleDescriptor(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(buildDescriptor(p.config))
+}
+
+func buildDescriptor(cfg *config.Config) descriptor {
+	d := descriptor{
+		Version:         descriptorVersion,
+		RequestIDHeader: requestIDHeader,
+		Auth:            descriptorAuth{Credentials: slices.Clone(apiKeyCredentials)},
+		RateLimits: descriptorRateLimits{
+			Enabled: cfg.RateLimit.Enabled,
+			Scopes:  []string{},
+			Status:  http.StatusTooManyRequests,
+			Headers: []string{"Retry-After"},
+		},
+		Errors: descriptorErrors{
+			Envelope: map[string]string{
+				"error.code":       "integer, the HTTP status",
+				"error.message":    "string",
+				"error.request_id": "string, omitted when unknown",
+			},
+			Codes: descriptorErrorCodes(cfg.ErrorResponses),
+		},
+		ContentEncodings: []string{},
+		Routes:           make([]descriptorRoute, 0, len(cfg.Routes)),
+	}
+	for _, param := range cfg.Server.StripCredentials.QueryParams {
+		d.Auth.Credentials = append(d.Auth.Credentials, "query:"+param)
+	}
+
+	if cfg.RateLimit.Enabled {
+		d.RateLimits.DefaultRPS = cfg.RateLimit.DefaultRPS
+		d.RateLimits.DefaultBurst = cfg.RateLimit.DefaultBurst
+		if cfg.RateLimit.PerAPIKey {
+			d.RateLimits.Scopes = append(d.RateLimits.Scopes, "api_key")
+		}
+		if cfg.RateLimit.PerIP {
+			d.RateLimits.Scopes = append(d.RateLimits.Scopes, "ip")
+		}
+		if cfg.RateLimit.PerTLSFingerprint {
+			d.RateLimits.Scopes = append(d.RateLimits.Scopes, "tls_fingerprint")
+		}
+	}
+
+	gzip := false
+	for _, route := range cfg.Routes {
+		dr := descriptorRoute{
+			Name:        route.Name,
+			Host:        route.Host,
+			Hosts:       route.Hosts,
+			Path:        route.Path,
+			Methods:     descriptorMethods(route.Methods),
+			Auth:        "none",
+			MaxBodySize: route.MaxBodySize,
+		}
+		if dr.MaxBodySize == 0 {
+			dr.MaxBodySize = cfg.Server.MaxBodySize
+		}
+		require := cfg.Server.RequireAPIKey
+		if route.RequireAPIKey != nil {
+			require = *route.RequireAPIKey
+		}
+		if require {
+			dr.Auth = "api_key_required"
+		} else if len(cfg.APIKeys) > 0 {
+			dr.Auth = "api_key_optional"
+		}
+		if rl := route.RateLimit; rl != nil && rl.Enabled && cfg.RateLimit.Enabled {
+			dr.RateLimit = &descriptorRateLimit{
+				RequestsPerSecond: rl.RequestsPerSecond,
+				Burst:             rl.BurstSize,
+			}
+		}
+		if route.Compression != nil {
+			dr.ContentEncodings = []string{"gzip"}
+			gzip = true
+		}
+		d.Routes = append(d.Routes, dr)
+	}
+	if gzip {
+		d.ContentEncodings = append(d.ContentEncodings, "gzip")
+	}
+
+	sort.SliceStable(d.Routes, func(i, j int) bool {
+		a, b := d.Routes[i], d.Routes[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Path < b.Path
+	})
+
+	return d
+}
+
+// descriptorErrorCodes returns gatewayErrors with the format each is
+// answered in.
+func descriptorErrorCodes(cfg config.ErrorResponses) []gatewayError {
+	codes := make([]gatewayError, len(gatewayErrors))
+	for i, e := range gatewayErrors {
+		switch {
+		case cfg.Templates[e.Status].Body != "":
+			e.Format = "template"
+		case cfg.Format == config.ErrorFormatText:
+			e.Format = config.ErrorFormatText
+		default:
+			e.Format = config.ErrorFormatJSON
+		}
+		codes[i] = e
+	}
+	return codes
+}
+
+// descriptorMethods returns the sorted, upper-cased methods of a route, or
+// ["*"] when it accepts any method.
+func descriptorMethods(methods []string) []string {
+	if len(methods) == 0 {
+		return []string{"*"}
+	}
+	seen := make(map[string]bool, len(methods))
+	out := make([]string, 0, len(methods))
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
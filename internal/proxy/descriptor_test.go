@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func TestAdmin_Descriptor(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(cfg *config.Config)
+	}{
+		{name: "minimal"},
+		{name: "full", mutate: func(cfg *config.Config) {
+			cfg.RateLimit.Enabled = true
+			cfg.RateLimit.DefaultRPS = 100
+			cfg.RateLimit.DefaultBurst = 200
+			cfg.RateLimit.PerIP = true
+			cfg.RateLimit.PerAPIKey = true
+			cfg.Server.MaxBodySize = 1 << 20
+			cfg.APIKeys = []config.APIKey{{Key: "k1", Name: "partner", Enabled: true}}
+			required := true
+			// Declared out of order to check the output is sorted.
+			cfg.Routes = []config.Route{
+				{Name: "users", Path: "/api/users/{id}", Methods: []string{"put", "GET"}, Upstream: "backend",
+					RateLimit:     &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 10, BurstSize: 20},
+					RequireAPIKey: &required},
+				{Name: "assets", Host: "cdn.example.com", Path: "/static/**", Upstream: "backend",
+					Compression: &config.Compression{}, MaxBodySize: 1024},
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, "http://127.0.0.1:1", tt.mutate)
+			admin := httptest.NewServer(p.AdminHandler())
+			defer admin.Close()
+
+			var first []byte
+			for i := 0; i < 2; i++ {
+				resp, err := http.Get(admin.URL + "/admin/descriptor")
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status = %d", resp.StatusCode)
+				}
+				if first != nil && !bytes.Equal(first, body) {
+					t.Fatal("descriptor is not deterministic")
+				}
+				first = body
+			}
+
+			golden := filepath.Join("testdata", "descriptor_"+tt.name+".golden.json")
+			if *updateGolden {
+				if err := os.WriteFile(golden, first, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(first, want) {
+				t.Errorf("descriptor differs from %s (run with -update to accept):\n%s", golden, first)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// Placeholders for the parts of a limiter key that depend on the client,
+// when effective limits are resolved without one.
+const (
+	clientIPPlaceholder    = "{client_ip}"
+	fingerprintPlaceholder = "{tls_fingerprint}"
+)
+
+// rateLimit is one token bucket a request is checked against.
+type rateLimit struct {
+	Scope             string `json:"scope"` // route, apikey, ip or tls_fp
+	Key               string `json:"key"`
+	RequestsPerSecond int    `json:"requests_per_second"`
+	BurstSize         int    `json:"burst_size"`
+}
+
+// resolveRateLimits returns the limiters a request on a route is checked
+// against, in the order they are evaluated: the route's own limit, then
+// the API key's, the client IP's and the TLS fingerprint's. It only reads
+// the configuration, so GET /admin/effective-limits reports exactly what
+// the rate limit stage enforces.
+func (p *Proxy) resolveRateLimits(routeLimit *config.RouteRateLimit, routeName, apiKeyName, clientIP, fingerprint string) []rateLimit {
+	var limits []rateLimit
+	rl := p.config.RateLimit
+	if routeLimit != nil && routeLimit.Enabled {
+		limits = append(limits, rateLimit{
+			Scope: "route", Key: "route:" + routeName,
+			RequestsPerSecond: routeLimit.RequestsPerSecond, BurstSize: routeLimit.BurstSize,
+		})
+	}
+	if rl.PerAPIKey && apiKeyName != "" {
+		l := rateLimit{Scope: "apikey", Key: "apikey:" + apiKeyName, RequestsPerSecond: rl.DefaultRPS, BurstSize: rl.DefaultBurst}
+		p.apiKeysMu.RLock()
+		key := p.apiKeyNames[apiKeyName]
+		p.apiKeysMu.RUnlock()
+		if key != nil {
+			l.RequestsPerSecond, l.BurstSize = key.RequestsPerSecond, key.BurstSize
+		}
+		limits = append(limits, l)
+	}
+	if rl.PerIP && clientIP != "" {
+		limits = append(limits, rateLimit{
+			Scope: "ip", Key: "ip:" + p.rateLimitIPKey(clientIP),
+			RequestsPerSecond: rl.DefaultRPS, BurstSize: rl.DefaultBurst,
+		})
+	}
+	if rl.PerTLSFingerprint && fingerprint != "" {
+		limits = append(limits, rateLimit{
+			Scope: "tls_fp", Key: "tls_fp:" + fingerprint,
+			RequestsPerSecond: rl.DefaultRPS, BurstSize: rl.DefaultBurst,
+		})
+	}
+	return limits
+}
+
+// effectiveLimits is the limit chain of one API key on one route.
+type effectiveLimits struct {
+	Route      string `json:"route"`
+	APIKeyName string `json:"api_key_name,omitempty"`
+	// Enabled is false when rate limiting is off or the route's pipeline
+	// leaves out the ratelimit stage; Limits then lists what would apply.
+	Enabled bool        `json:"enabled"`
+	Limits  []rateLimit `json:"limits"`
+}
+
+func (p *Proxy) effectiveLimits(route config.Route, apiKeyName, clientIP, fingerprint string) effectiveLimits {
+	name := configRouteName(route)
+	pipeline := route.Pipeline
+	if len(pipeline) == 0 {
+		pipeline = config.DefaultPipeline
+	}
+	limits := p.resolveRateLimits(route.RateLimit, name, apiKeyName, clientIP, fingerprint)
+	if limits == nil {
+		limits = []rateLimit{}
+	}
+	return effectiveLimits{
+		Route:      name,
+		APIKeyName: apiKeyName,
+		Enabled:    p.config.RateLimit.Enabled && slices.Contains(pipeline, "ratelimit"),
+		Limits:     limits,
+	}
+}
+
+// handleEffectiveLimits resolves the rate limits an API key meets on a
+// route. Query parameters: route, api_key_name (none for anonymous
+// requests), and optionally client_ip and tls_fingerprint to fill in the
+// keys of the per-client limiters. With format=csv it returns the matrix
+// of every enabled key on every route, narrowed by route or api_key_name
+// if given.
+func (p *Proxy) handleEffectiveLimits(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	clientIP := q.Get("client_ip")
+	if clientIP == "" {
+		clientIP = clientIPPlaceholder
+	}
+	fingerprint := q.Get("tls_fingerprint")
+	if fingerprint == "" {
+		fingerprint = fingerprintPlaceholder
+	}
+
+	var routes []config.Route
+	for _, route := range p.config.Routes {
+		if q.Get("route") == "" || configRouteName(route) == q.Get("route") {
+			routes = append(routes, route)
+		}
+	}
+	if len(routes) == 0 {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	p.apiKeysMu.RLock()
+	var keys []string
+	for name := range p.apiKeyNames {
+		if q.Get("api_key_name") == "" || name == q.Get("api_key_name") {
+			keys = append(keys, name)
+		}
+	}
+	p.apiKeysMu.RUnlock()
+	if q.Get("api_key_name") != "" && len(keys) == 0 {
+		writeJSONError(w, http.StatusNotFound, "unknown API key", "")
+		return
+	}
+	sort.Strings(keys)
+
+	switch q.Get("format") {
+	case "", "json":
+	case "csv":
+		p.writeEffectiveLimitsCSV(w, routes, keys, clientIP, fingerprint)
+		return
+	default:
+		writeJSONError(w, http.StatusBadRequest, "format must be json or csv", "")
+		return
+	}
+	if q.Get("route") == "" {
+		writeJSONError(w, http.StatusBadRequest, "route is required", "")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.effectiveLimits(routes[0], q.Get("api_key_name"), clientIP, fingerprint))
+}
+
+// writeEffectiveLimitsCSV writes one row per limiter of every key on every
+// route, in evaluation order. Combinations without any limiter get a row
+// with only the key, the route and enabled.
+func (p *Proxy) writeEffectiveLimitsCSV(w http.ResponseWriter, routes []config.Route, keys []string, clientIP, fingerprint string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"api_key_name", "route", "enabled", "order", "scope", "key", "requests_per_second", "burst_size"})
+	for _, key := range keys {
+		for _, route := range routes {
+			e := p.effectiveLimits(route, key, clientIP, fingerprint)
+			enabled := strconv.FormatBool(e.Enabled)
+			if len(e.Limits) == 0 {
+				_ = cw.Write([]string{key, e.Route, enabled, "", "", "", "", ""})
+			}
+			for i, l := range e.Limits {
+				_ = cw.Write([]string{
+					key, e.Route, enabled, strconv.Itoa(i + 1), l.Scope, l.Key,
+					strconv.Itoa(l.RequestsPerSecond), strconv.Itoa(l.BurstSize),
+				})
+			}
+		}
+	}
+	cw.Flush()
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// TestProxy_EffectiveLimits checks the reported limit chain against what
+// the gateway enforces: the request that gets the first 429 is the one
+// after the smallest burst, and the hit is recorded for the first limiter
+// with that burst.
+func TestProxy_EffectiveLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		name   string
+		mutate func(cfg *config.Config)
+		scopes []string
+	}{
+		{
+			name: "route before key",
+			mutate: func(cfg *config.Config) {
+				cfg.RateLimit.PerAPIKey = true
+				cfg.Routes[0].RateLimit = &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 1, BurstSize: 3}
+			},
+			scopes: []string{"route", "apikey"},
+		},
+		{
+			name: "key tighter than ip",
+			mutate: func(cfg *config.Config) {
+				cfg.RateLimit.PerAPIKey = true
+				cfg.RateLimit.PerIP = true
+				cfg.APIKeys[0].BurstSize = 2
+			},
+			scopes: []string{"apikey", "ip"},
+		},
+		{
+			name: "ip tighter than key",
+			mutate: func(cfg *config.Config) {
+				cfg.RateLimit.PerAPIKey = true
+				cfg.RateLimit.PerIP = true
+				cfg.RateLimit.DefaultBurst = 4
+			},
+			scopes: []string{"apikey", "ip"},
+		},
+		{
+			name: "rate limiting off",
+			mutate: func(cfg *config.Config) {
+				cfg.RateLimit.Enabled = false
+				cfg.RateLimit.PerIP = true
+			},
+			scopes: []string{"ip"},
+		},
+		{
+			name: "stage left out",
+			mutate: func(cfg *config.Config) {
+				cfg.RateLimit.PerIP = true
+				cfg.Routes[0].Pipeline = []string{"proxy"}
+			},
+			scopes: []string{"ip"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.RateLimit.Enabled = true
+				cfg.RateLimit.PerIP = false
+				cfg.RateLimit.PerAPIKey = false
+				cfg.RateLimit.DefaultRPS = 1
+				cfg.RateLimit.DefaultBurst = 10
+				cfg.APIKeys = []config.APIKey{{Key: "k1", Name: "acme", Enabled: true, RequestsPerSecond: 1, BurstSize: 8}}
+				tt.mutate(cfg)
+			})
+
+			rec := httptest.NewRecorder()
+			p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/effective-limits?route=test&api_key_name=acme&client_ip=192.0.2.1", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %q", rec.Code, rec.Body.String())
+			}
+			var got effectiveLimits
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var scopes []string
+			for _, l := range got.Limits {
+				scopes = append(scopes, l.Scope)
+			}
+			if strings.Join(scopes, ",") != strings.Join(tt.scopes, ",") {
+				t.Fatalf("scopes = %v, want %v", scopes, tt.scopes)
+			}
+
+			// The first limiter with the smallest burst trips first.
+			allowed, trips := 20, ""
+			if got.Enabled {
+				allowed = got.Limits[0].BurstSize
+				trips = got.Limits[0].Scope
+				for _, l := range got.Limits[1:] {
+					if l.BurstSize < allowed {
+						allowed, trips = l.BurstSize, l.Scope
+					}
+				}
+			}
+			for i := range 20 {
+				r := httptest.NewRequest("GET", "/x", nil)
+				r.Header.Set("X-API-Key", "k1")
+				rec := httptest.NewRecorder()
+				p.ServeHTTP(rec, r)
+				if want := i < allowed; (rec.Code == http.StatusOK) != want {
+					t.Fatalf("request %d: status = %d, reported limits allow %d", i+1, rec.Code, allowed)
+				}
+				if rec.Code == http.StatusTooManyRequests {
+					break
+				}
+			}
+			if trips != "" {
+				metrics := httptest.NewRecorder()
+				p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+				if want := `gateway_rate_limit_hits_total{key="test_` + trips + `"} 1`; !strings.Contains(metrics.Body.String(), want) {
+					t.Errorf("metrics missing %s", want)
+				}
+			}
+
+			// The reported keys are the buckets the requests used.
+			if got.Enabled {
+				buckets := p.rateLimiter.Stats()
+				for _, l := range got.Limits {
+					if _, ok := buckets[l.Key]; !ok {
+						t.Errorf("no bucket %s, have %v", l.Key, buckets)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProxy_EffectiveLimitsCSV(t *testing.T) {
+	p := newTestProxy(t, "http://localhost:1", func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerAPIKey = true
+		cfg.RateLimit.PerIP = true
+		cfg.APIKeys = []config.APIKey{
+			{Key: "k1", Name: "acme", Enabled: true, RequestsPerSecond: 5, BurstSize: 10},
+			{Key: "k2", Name: "globex", Enabled: true, RequestsPerSecond: 50, BurstSize: 100},
+		}
+		cfg.Routes = append(cfg.Routes, config.Route{
+			Name: "orders", Path: "/orders/**", Upstream: "backend",
+			RateLimit: &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 1, BurstSize: 2},
+		})
+	})
+
+	admin := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/effective-limits"+query, nil))
+		return rec
+	}
+
+	rec := admin("?format=csv")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A header, then per key: two limiters on test and three on orders.
+	if len(rows) != 11 {
+		t.Fatalf("got %d rows, want 11:\n%v", len(rows), rows)
+	}
+	want := []string{"acme", "orders", "true", "2", "apikey", "apikey:acme", "5", "10"}
+	if strings.Join(rows[4], ",") != strings.Join(want, ",") {
+		t.Errorf("row 4 = %v, want %v", rows[4], want)
+	}
+	if rows[5][5] != "ip:{client_ip}" || rows[6][0] != "globex" {
+		t.Errorf("rows = %v", rows)
+	}
+
+	if rec := admin("?route=missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown route: status = %d, want 404", rec.Code)
+	}
+	if rec := admin("?route=orders&api_key_name=initech"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown key: status = %d, want 404", rec.Code)
+	}
+	if rec := admin("?api_key_name=acme"); rec.Code != http.StatusBadRequest {
+		t.Errorf("JSON without route: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/health"
+)
+
+func TestAdmin_EventStreamReportsHealthTransition(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	admin := httptest.NewServer(p.AdminHandler())
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/admin/events?types=" + events.TargetHealth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// The stream is subscribed once its headers arrive; now flip the target.
+	checker := health.NewChecker(p.Upstreams(), map[string]*config.HealthCheck{
+		"backend": {Path: "/health", Interval: time.Hour, Timeout: time.Second},
+	}, p.Metrics(), slog.Default())
+	checker.SetEvents(p.Events())
+	checker.Start()
+	defer checker.Stop()
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+
+	var eventLine string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before the event arrived")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				eventLine = line
+				continue
+			}
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			if eventLine != "event: "+events.TargetHealth {
+				t.Fatalf("event line = %q", eventLine)
+			}
+			var e events.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Data["upstream"] != "backend" || e.Data["target"] != backend.URL || e.Data["healthy"] != false {
+				t.Errorf("unexpected event data %v", e.Data)
+			}
+			if e.Time.IsZero() {
+				t.Error("event has no timestamp")
+			}
+			return
+		case <-timeout:
+			t.Fatal("no health event received")
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// requestTimeout returns how long a request on route may take with
+// target, or 0 for no limit beyond the client's. On routes with
+// compensate_rtt, a request failed over to a standby target gets the
+// round trip that target adds over the primary ones on top.
+func (p *Proxy) requestTimeout(route *router.Route, target *loadbalancer.Target) time.Duration {
+	if route.Timeout <= 0 || !route.CompensateRTT || !target.Standby {
+		return route.Timeout
+	}
+	lb, ok := p.upstreams[route.Upstream]
+	if !ok {
+		return route.Timeout
+	}
+	return route.Timeout + rttDelta(lb, target)
+}
+
+// rttDelta is how much longer the round trip to target is than to the
+// closest primary target of lb.
+func rttDelta(lb loadbalancer.LoadBalancer, target *loadbalancer.Target) time.Duration {
+	base := time.Duration(-1)
+	for _, t := range lb.Targets() {
+		if !t.Standby && (base < 0 || t.RTT() < base) {
+			base = t.RTT()
+		}
+	}
+	return max(target.RTT()-max(base, 0), 0)
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// failoverProxy routes to a primary target that is down and a standby one
+// that answers after 80ms, on a route with a 50ms timeout.
+func failoverProxy(t *testing.T, compensate bool) *Proxy {
+	t.Helper()
+	standby := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(80 * time.Millisecond)
+		_, _ = w.Write([]byte("dr"))
+	}))
+	t.Cleanup(standby.Close)
+
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.Upstreams[0].Targets = append(cfg.Upstreams[0].Targets, config.Target{
+			URL:         standby.URL,
+			Standby:     true,
+			ExpectedRTT: config.ExpectedRTT{Duration: 80 * time.Millisecond},
+		})
+		cfg.Routes[0].Timeout = 50 * time.Millisecond
+		cfg.Routes[0].CompensateRTT = compensate
+	})
+	primary := p.upstreams["backend"].Targets()[0]
+	primary.Healthy.Store(false)
+	return p
+}
+
+func TestProxy_FailoverCompensatesRTT(t *testing.T) {
+	p := failoverProxy(t, true)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "dr" {
+		t.Fatalf("status = %d, body = %q, want 200 from the standby target", rec.Code, rec.Body.String())
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_upstream_rtt_class_requests_total{key="backend_far"} 1`) {
+		t.Errorf("request was not counted in the far RTT class:\n%s", metrics.Body.String())
+	}
+
+	admin := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(admin, httptest.NewRequest("GET", "/admin/upstreams", nil))
+	for _, want := range []string{`"standby":true`, `"expected_rtt":"80ms"`, `"rtt_class":"far"`} {
+		if !strings.Contains(admin.Body.String(), want) {
+			t.Errorf("upstream listing has no %s: %s", want, admin.Body.String())
+		}
+	}
+}
+
+func TestProxy_FailoverWithoutCompensationTimesOut(t *testing.T) {
+	p := failoverProxy(t, false)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504 once the route timeout passes", rec.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const (
+	featureFlagsHeader = "X-Feature-Flags"
+
+	defaultFlagVariant = "off"
+
+	// flagBuckets is how finely percentage rules split bucket keys: 0.01%.
+	flagBuckets = 10000
+
+	// flagCookieMaxAge is how long an assigned bucketing cookie lasts, in
+	// seconds: a year, so rollouts stay stable for returning clients.
+	flagCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// featureFlag is a feature flag definition ready to evaluate.
+type featureFlag struct {
+	name     string
+	fallback string
+	rules    []config.FlagRule
+	header   string
+
+	// bucketKind and bucketName locate the bucket key: api_key or
+	// client_ip with no name, or a header, cookie or query component.
+	bucketKind, bucketName string
+}
+
+// featureFlags is the set of flag definitions in effect, by name. It is
+// swapped whole when the configuration is reloaded.
+type featureFlags map[string]*featureFlag
+
+func newFeatureFlags(flags []config.FeatureFlag) featureFlags {
+	set := make(featureFlags, len(flags))
+	for _, f := range flags {
+		ff := &featureFlag{name: f.Name, fallback: f.Default, rules: f.Rules, header: f.Header, bucketKind: f.BucketBy}
+		if ff.fallback == "" {
+			ff.fallback = defaultFlagVariant
+		}
+		switch f.BucketBy {
+		case "":
+			ff.bucketKind = config.FlagBucketAPIKey
+		case config.FlagBucketAPIKey, config.FlagBucketClientIP:
+		default:
+			ff.bucketKind, ff.bucketName, _ = config.ParseCacheComponent(f.BucketBy) // validated
+		}
+		set[f.Name] = ff
+	}
+	return set
+}
+
+// SetFeatureFlags replaces the feature flag definitions, e.g. after a
+// configuration reload. Which routes evaluate which flags is fixed at
+// startup; a route's flag that is no longer defined is skipped.
+func (p *Proxy) SetFeatureFlags(flags []config.FeatureFlag) {
+	set := newFeatureFlags(flags)
+	p.featureFlags.Store(&set)
+}
+
+// featureFlagsStage evaluates the route's feature flags and sends the
+// decisions upstream as X-Feature-Flags: name=variant pairs, comma
+// separated, in the order the route lists the flags. Flags with a header
+// of their own also get it. Client-sent values of these headers are
+// replaced, so clients cannot pick their own variants.
+func (p *Proxy) featureFlagsStage(req *pipelineRequest) bool {
+	if len(req.route.FeatureFlags) == 0 {
+		return true
+	}
+	flags := *p.featureFlags.Load()
+	h := req.r.Header
+	h.Del(featureFlagsHeader)
+
+	// Cookies assigned to this request, so flags bucketing on the same
+	// cookie agree and it is set only once.
+	var assigned map[string]string
+	decisions := make([]string, 0, len(req.route.FeatureFlags))
+	for _, name := range req.route.FeatureFlags {
+		f, ok := flags[name]
+		if !ok {
+			continue
+		}
+		key := f.bucketKey(req)
+		if key == "" && f.bucketKind == config.CacheComponentCookie {
+			if key = assigned[f.bucketName]; key == "" {
+				key = newRequestID()
+				if assigned == nil {
+					assigned = make(map[string]string)
+				}
+				assigned[f.bucketName] = key
+				http.SetCookie(req.w, &http.Cookie{
+					Name:     f.bucketName,
+					Value:    key,
+					Path:     "/",
+					MaxAge:   flagCookieMaxAge,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+		}
+		variant := f.evaluate(req, key)
+		p.metrics.RecordFeatureFlagEvaluation(name, variant)
+		decisions = append(decisions, name+"="+variant)
+		if f.header != "" {
+			h.Set(f.header, variant)
+		}
+	}
+	h.Set(featureFlagsHeader, strings.Join(decisions, ","))
+	return true
+}
+
+// bucketKey returns the request's stable key for percentage rules, or ""
+// if the request has none.
+func (f *featureFlag) bucketKey(req *pipelineRequest) string {
+	switch f.bucketKind {
+	case config.FlagBucketAPIKey:
+		return req.apiKeyName
+	case config.FlagBucketClientIP:
+		return req.clientIP
+	case config.CacheComponentHeader:
+		return req.r.Header.Get(f.bucketName)
+	case config.CacheComponentCookie:
+		if c, err := req.r.Cookie(f.bucketName); err == nil {
+			return c.Value
+		}
+		return ""
+	case config.CacheComponentQuery:
+		return req.r.URL.Query().Get(f.bucketName)
+	}
+	return ""
+}
+
+// evaluate returns the variant of the first rule the request matches, or
+// the flag's default.
+func (f *featureFlag) evaluate(req *pipelineRequest, key string) string {
+	for i := range f.rules {
+		if f.matches(&f.rules[i], req, key) {
+			return f.rules[i].Variant
+		}
+	}
+	return f.fallback
+}
+
+func (f *featureFlag) matches(rule *config.FlagRule, req *pipelineRequest, key string) bool {
+	if len(rule.APIKeys) > 0 && !slices.Contains(rule.APIKeys, req.apiKeyName) {
+		return false
+	}
+	for name, want := range rule.Headers {
+		values := req.r.Header.Values(name)
+		if len(values) == 0 || (want != "*" && !slices.Contains(values, want)) {
+			return false
+		}
+	}
+	if rule.Percent != nil {
+		// Requests without a bucket key cannot be bucketed stably, so
+		// they are never in a rollout.
+		if key == "" || float64(flagBucket(f.name, key)) >= *rule.Percent*flagBuckets/100 {
+			return false
+		}
+	}
+	return true
+}
+
+// flagBucket hashes key into one of flagBuckets buckets. The flag's name
+// goes into the hash so that rollouts of different flags at the same
+// percentage do not pick the same clients.
+func flagBucket(flag, key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(flag))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(key))
+	return h.Sum64() % flagBuckets
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func percent(p float64) *float64 { return &p }
+
+// flagHeaders returns the X-Feature-Flags and X-Checkout headers the
+// upstream received for r.
+func flagHeaders(t *testing.T, p *Proxy, r *http.Request, seen <-chan http.Header) (string, string, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	h := <-seen
+	return h.Get("X-Feature-Flags"), h.Get("X-Checkout"), rec
+}
+
+func newFlagProxy(t *testing.T, flags []config.FeatureFlag) (*Proxy, <-chan http.Header) {
+	t.Helper()
+	seen := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+	}))
+	t.Cleanup(backend.Close)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.APIKeys = []config.APIKey{
+			{Key: "k1", Name: "beta-partner", Enabled: true},
+			{Key: "k2", Name: "mobile", Enabled: true},
+		}
+		cfg.FeatureFlags = flags
+		for _, f := range flags {
+			cfg.Routes[0].FeatureFlags = append(cfg.Routes[0].FeatureFlags, f.Name)
+		}
+	})
+	return p, seen
+}
+
+func TestProxy_FeatureFlagRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    config.FeatureFlag
+		apiKey  string
+		headers map[string]string
+		want    string
+	}{
+		{"no rules", config.FeatureFlag{Name: "checkout"}, "", nil, "checkout=off"},
+		{"default variant", config.FeatureFlag{Name: "checkout", Default: "legacy"}, "", nil, "checkout=legacy"},
+		{"unconditional rule", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on"}}}, "", nil, "checkout=on"},
+		{"allow-listed api key", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", APIKeys: []string{"beta-partner"}}}}, "k1", nil, "checkout=on"},
+		{"other api key", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", APIKeys: []string{"beta-partner"}}}}, "k2", nil, "checkout=off"},
+		{"no api key", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", APIKeys: []string{"beta-partner"}}}}, "", nil, "checkout=off"},
+		{"header value", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Headers: map[string]string{"X-Beta": "yes"}}}}, "", map[string]string{"X-Beta": "yes"}, "checkout=on"},
+		{"other header value", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Headers: map[string]string{"X-Beta": "yes"}}}}, "", map[string]string{"X-Beta": "no"}, "checkout=off"},
+		{"any header value", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Headers: map[string]string{"X-Beta": "*"}}}}, "", map[string]string{"X-Beta": "no"}, "checkout=on"},
+		{"missing header", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Headers: map[string]string{"X-Beta": "*"}}}}, "", nil, "checkout=off"},
+		{"full rollout", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Percent: percent(100)}}}, "k2", nil, "checkout=on"},
+		{"zero rollout", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Percent: percent(0)}}}, "k2", nil, "checkout=off"},
+		{"rollout without bucket key", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", Percent: percent(100)}}}, "", nil, "checkout=off"},
+		{"all conditions", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{{Variant: "on", APIKeys: []string{"mobile"}, Headers: map[string]string{"X-Beta": "yes"}}}}, "k2", nil, "checkout=off"},
+		{"first match wins", config.FeatureFlag{Name: "checkout", Rules: []config.FlagRule{
+			{Variant: "partner", APIKeys: []string{"beta-partner"}},
+			{Variant: "on"},
+		}}, "k1", nil, "checkout=partner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, seen := newFlagProxy(t, []config.FeatureFlag{tt.flag})
+			r := httptest.NewRequest("GET", "/orders", nil)
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got, _, _ := flagHeaders(t, p, r, seen); got != tt.want {
+				t.Errorf("X-Feature-Flags = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_FeatureFlagHeaders(t *testing.T) {
+	p, seen := newFlagProxy(t, []config.FeatureFlag{
+		{Name: "checkout", Header: "X-Checkout", Rules: []config.FlagRule{{Variant: "v2", APIKeys: []string{"mobile"}}}},
+		{Name: "dark-mode", Default: "on"},
+	})
+
+	// Clients cannot choose their own variants.
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.Header.Set("X-API-Key", "k2")
+	r.Header.Set("X-Feature-Flags", "checkout=v3")
+	r.Header.Set("X-Checkout", "v3")
+	flags, checkout, _ := flagHeaders(t, p, r, seen)
+	if flags != "checkout=v2,dark-mode=on" || checkout != "v2" {
+		t.Errorf("X-Feature-Flags = %q, X-Checkout = %q", flags, checkout)
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_feature_flag_evaluations_total{key="checkout_v2"} 1`,
+		`gateway_feature_flag_evaluations_total{key="dark-mode_on"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+
+	// A reload changes the definitions the route's flags evaluate to, and
+	// skips flags that are gone.
+	p.SetFeatureFlags([]config.FeatureFlag{{Name: "checkout", Default: "v3"}})
+	flags, checkout, _ = flagHeaders(t, p, httptest.NewRequest("GET", "/orders", nil), seen)
+	if flags != "checkout=v3" || checkout != "" {
+		t.Errorf("after reload: X-Feature-Flags = %q, X-Checkout = %q", flags, checkout)
+	}
+}
+
+func TestProxy_FeatureFlagBucketing(t *testing.T) {
+	rollout := []config.FlagRule{{Variant: "on", Percent: percent(30)}}
+	p, seen := newFlagProxy(t, []config.FeatureFlag{
+		{Name: "checkout", BucketBy: "header:X-User", Rules: rollout},
+		{Name: "search", BucketBy: "header:X-User", Rules: rollout},
+	})
+
+	on := map[string]int{}
+	for i := range 1000 {
+		user := fmt.Sprintf("user-%d", i)
+		first := ""
+		for attempt := range 2 {
+			r := httptest.NewRequest("GET", "/orders", nil)
+			r.Header.Set("X-User", user)
+			flags, _, _ := flagHeaders(t, p, r, seen)
+			if attempt == 0 {
+				first = flags
+			} else if flags != first {
+				t.Fatalf("%s: %q, then %q", user, first, flags)
+			}
+		}
+		for _, decision := range strings.Split(first, ",") {
+			on[decision]++
+		}
+	}
+	// A 30% rollout of 1000 keys lands close to 300, and two flags at the
+	// same percentage do not pick the same keys.
+	for _, flag := range []string{"checkout", "search"} {
+		if n := on[flag+"=on"]; n < 250 || n > 350 {
+			t.Errorf("%s: %d of 1000 keys on, want about 300", flag, n)
+		}
+	}
+
+	// Buckets depend on the flag and key only, so every replica agrees;
+	// they must not change between releases either, or rollouts would
+	// reshuffle clients on upgrade.
+	for _, tt := range []struct {
+		flag, key string
+		want      uint64
+	}{
+		{"checkout", "user-1", 9386},
+		{"checkout", "user-2", 1175},
+		{"search", "user-1", 3354},
+	} {
+		if got := flagBucket(tt.flag, tt.key); got != tt.want {
+			t.Errorf("flagBucket(%s, %s) = %d, want %d", tt.flag, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestProxy_FeatureFlagCookie(t *testing.T) {
+	p, seen := newFlagProxy(t, []config.FeatureFlag{
+		{Name: "checkout", BucketBy: "cookie:rp_bucket", Rules: []config.FlagRule{{Variant: "on", Percent: percent(50)}}},
+		{Name: "search", BucketBy: "cookie:rp_bucket", Rules: []config.FlagRule{{Variant: "on", Percent: percent(100)}}},
+	})
+
+	flags, _, rec := flagHeaders(t, p, httptest.NewRequest("GET", "/orders", nil), seen)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "rp_bucket" || cookies[0].Value == "" || !cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v, want one rp_bucket", cookies)
+	}
+	if !strings.HasSuffix(flags, ",search=on") {
+		t.Errorf("X-Feature-Flags = %q, want search bucketed on the assigned cookie", flags)
+	}
+
+	// The client sends the cookie back and keeps its variants.
+	for range 3 {
+		r := httptest.NewRequest("GET", "/orders", nil)
+		r.AddCookie(cookies[0])
+		again, _, rec := flagHeaders(t, p, r, seen)
+		if again != flags || len(rec.Result().Cookies()) != 0 {
+			t.Errorf("X-Feature-Flags = %q with cookies %v, want %q and none set", again, rec.Result().Cookies(), flags)
+		}
+	}
+}
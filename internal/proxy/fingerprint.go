@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const (
+	requestFingerprintHeader = "X-Request-Fingerprint"
+
+	defaultFingerprintBodyBytes = 64 << 10
+	defaultFingerprintWindow    = time.Minute
+)
+
+// fingerprintStage hashes the request content, forwards the hash upstream
+// and counts how often it was seen recently. It never rejects a request
+// other than for an unreadable body.
+func (p *Proxy) fingerprintStage(req *pipelineRequest) bool {
+	cfg := req.route.RequestFingerprint
+	if cfg == nil {
+		return true
+	}
+	limit := cfg.MaxBodyBytes
+	if limit == 0 {
+		limit = defaultFingerprintBodyBytes
+	}
+	body, complete, err := bufferBody(req.r, limit)
+	if err != nil {
+		p.writeBodyError(req.w, req.routeName, err)
+		return false
+	}
+
+	r := req.r
+	req.requestHash = requestFingerprint(r, cfg.Headers, body, complete)
+	req.repeats = p.repeats[cfg].add(req.requestHash, time.Now())
+	r.Header.Set(requestFingerprintHeader, req.requestHash)
+
+	if req.repeats > 1 {
+		p.logger.Info("repeated request",
+			"route", req.routeName,
+			"request_id", requestIDFrom(r.Context()),
+			"fingerprint", req.requestHash,
+			"seen", req.repeats,
+			"window", p.repeats[cfg].window,
+		)
+	}
+	return true
+}
+
+// requestFingerprint hashes the method, host, path and query, the selected
+// headers and the body. A body over the limit counts by its length only.
+func requestFingerprint(r *http.Request, headers []string, body []byte, complete bool) string {
+	h := sha256.New()
+	field := func(b []byte) {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+
+	field([]byte(r.Method))
+	field([]byte(r.Host))
+	field([]byte(r.URL.Path))
+	field([]byte(r.URL.RawQuery))
+
+	names := make([]string, len(headers))
+	for i, name := range headers {
+		names[i] = http.CanonicalHeaderKey(name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field([]byte(name))
+		for _, v := range r.Header.Values(name) {
+			field([]byte(v))
+		}
+	}
+
+	if complete {
+		field(body)
+	} else {
+		field([]byte("length:" + strconv.FormatInt(r.ContentLength, 10)))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// repeatCounter counts fingerprints seen within a window of their first
+// occurrence.
+type repeatCounter struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]*repeat
+	lastSweep time.Time
+}
+
+type repeat struct {
+	first time.Time
+	count int
+}
+
+func newRepeatCounter(cfg *config.RequestFingerprint) *repeatCounter {
+	window := cfg.Window
+	if window == 0 {
+		window = defaultFingerprintWindow
+	}
+	return &repeatCounter{window: window, seen: make(map[string]*repeat)}
+}
+
+// add records one occurrence of fp and returns how often it has been seen
+// in the current window, including this one.
+func (c *repeatCounter) add(fp string, now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired entries once per window so the map only holds recent
+	// fingerprints.
+	if now.Sub(c.lastSweep) >= c.window {
+		for k, e := range c.seen {
+			if now.Sub(e.first) >= c.window {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	e, ok := c.seen[fp]
+	if !ok || now.Sub(e.first) >= c.window {
+		e = &repeat{first: now}
+		c.seen[fp] = e
+	}
+	e.count++
+	return e.count
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_RequestFingerprint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get(requestFingerprintHeader))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].RequestFingerprint = &config.RequestFingerprint{Headers: []string{"X-Tenant"}}
+	})
+
+	send := func(body string, headers map[string]string) string {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/orders?page=1", strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	first := send(`{"id":1}`, map[string]string{"X-Tenant": "acme", "Date": "Mon, 01 Jan 2024 00:00:00 GMT", "Traceparent": "00-aaaa-01"})
+	if len(first) != 32 {
+		t.Fatalf("fingerprint %q is not 16 hex bytes", first)
+	}
+	// Volatile headers are not hashed, so a retry with a new date and trace
+	// is the same request.
+	if got := send(`{"id":1}`, map[string]string{"X-Tenant": "acme", "Date": "Tue, 02 Jan 2024 00:00:00 GMT", "Traceparent": "00-bbbb-01"}); got != first {
+		t.Errorf("retry fingerprint = %q, want %q", got, first)
+	}
+	if got := send(`{"id":2}`, map[string]string{"X-Tenant": "acme"}); got == first {
+		t.Error("different body produced the same fingerprint")
+	}
+	if got := send(`{"id":1}`, map[string]string{"X-Tenant": "other"}); got == first {
+		t.Error("different selected header produced the same fingerprint")
+	}
+
+	if got := p.repeats[p.config.Routes[0].RequestFingerprint].add(first, time.Now()); got != 3 {
+		t.Errorf("seen = %d after two identical requests, want 3", got)
+	}
+}
+
+func TestProxy_RequestFingerprintOff(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get(requestFingerprintHeader))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Body.String() != "" {
+		t.Errorf("fingerprint %q sent without request_fingerprint", rec.Body.String())
+	}
+}
+
+func TestRepeatCounter(t *testing.T) {
+	c := newRepeatCounter(&config.RequestFingerprint{Window: time.Minute})
+	now := time.Now()
+
+	for i, want := range []int{1, 2, 3} {
+		if got := c.add("a", now.Add(time.Duration(i)*time.Second)); got != want {
+			t.Errorf("add %d = %d, want %d", i, got, want)
+		}
+	}
+	if got := c.add("b", now); got != 1 {
+		t.Errorf("other fingerprint = %d, want 1", got)
+	}
+	// The window starts at the first occurrence.
+	if got := c.add("a", now.Add(time.Minute)); got != 1 {
+		t.Errorf("after window = %d, want 1", got)
+	}
+	if _, ok := c.seen["b"]; ok {
+		t.Error("expired fingerprint was not swept")
+	}
+}
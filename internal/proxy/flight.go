@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+type timingKey struct{}
+
+// requestTiming collects phase durations for the flight recorder. httptrace
+// hooks may fire from the transport's dial goroutines, hence the mutex.
+// observe and traceRequest are no-ops on a nil receiver.
+type requestTiming struct {
+	mu sync.Mutex
+
+	routeMatch time.Duration
+	rateLimit  time.Duration
+	dns        time.Duration
+	connect    time.Duration
+	tls        time.Duration
+	ttfb       time.Duration
+	bodyCopy   time.Duration
+	connReused bool
+
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+}
+
+// withTiming returns r with a fresh requestTiming attached to its context.
+func withTiming(r *http.Request) (*http.Request, *requestTiming) {
+	t := &requestTiming{}
+	return r.WithContext(context.WithValue(r.Context(), timingKey{}, t)), t
+}
+
+// timingFrom returns the requestTiming attached by withTiming, or nil.
+func timingFrom(ctx context.Context) *requestTiming {
+	t, _ := ctx.Value(timingKey{}).(*requestTiming)
+	return t
+}
+
+// gatewayPhase names the phases timed by the proxy itself rather than by
+// httptrace.
+type gatewayPhase int
+
+const (
+	phaseRouteMatch gatewayPhase = iota
+	phaseRateLimit
+	phaseBodyCopy
+)
+
+func (t *requestTiming) observe(phase gatewayPhase, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch phase {
+	case phaseRouteMatch:
+		t.routeMatch = d
+	case phaseRateLimit:
+		t.rateLimit = d
+	case phaseBodyCopy:
+		t.bodyCopy = d
+	}
+}
+
+// traceRequest attaches client trace hooks feeding t to req's context.
+func (t *requestTiming) traceRequest(req *http.Request) *http.Request {
+	if t == nil {
+		return req
+	}
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.connReused = info.Reused
+			t.mu.Unlock()
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			t.connect = time.Since(t.connectStart)
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tls = time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wroteRequest = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			if !t.wroteRequest.IsZero() {
+				t.ttfb = time.Since(t.wroteRequest)
+			}
+			t.mu.Unlock()
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func (t *requestTiming) phases() (flightrecorder.Phases, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return flightrecorder.Phases{
+		RouteMatch: flightrecorder.Millis(t.routeMatch),
+		RateLimit:  flightrecorder.Millis(t.rateLimit),
+		DNS:        flightrecorder.Millis(t.dns),
+		Connect:    flightrecorder.Millis(t.connect),
+		TLS:        flightrecorder.Millis(t.tls),
+		TTFB:       flightrecorder.Millis(t.ttfb),
+		BodyCopy:   flightrecorder.Millis(t.bodyCopy),
+	}, t.connReused
+}
+
+// recordFlight stores the request in the flight recorder if it was slow,
+// its route is being debugged or it falls in the sample.
+func (p *Proxy) recordFlight(r *http.Request, status int, start time.Time, t *requestTiming, route, upstream, target string, debug bool) {
+	duration := time.Since(start)
+	cfg := p.config.FlightRecorder
+	slow := cfg.SlowThreshold > 0 && duration >= cfg.SlowThreshold
+	if !slow && !debug && rand.Float64() >= cfg.SampleRate {
+		return
+	}
+
+	phases, reused := t.phases()
+	p.recorder.Add(flightrecorder.Entry{
+		RequestID:  requestIDFrom(r.Context()),
+		Time:       start,
+		Route:      route,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      redactQuery(r.URL.RawQuery),
+		Upstream:   upstream,
+		Target:     target,
+		Status:     status,
+		Duration:   flightrecorder.Millis(duration),
+		ConnReused: reused,
+		Phases:     phases,
+	})
+}
+
+// redactQuery keeps the parameter names of a query string and drops their
+// values, e.g. "token=abc&page=2" becomes "page=REDACTED&token=REDACTED".
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "REDACTED"
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, url.QueryEscape(k)+"=REDACTED")
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "&")
+}
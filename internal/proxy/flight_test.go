@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+func TestProxy_FlightRecorderCapturesSlowRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.FlightRecorder = config.FlightRecorderConfig{
+			Enabled:       true,
+			Size:          16,
+			SlowThreshold: 50 * time.Millisecond,
+		}
+	})
+
+	req := httptest.NewRequest("GET", "/slow?token=secret&page=2", nil)
+	req.Header.Set("X-Request-ID", "slow-1")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	admin := p.AdminHandler()
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/requests/slow-1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lookup status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	var entry flightrecorder.Entry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Route != "test" || entry.Status != http.StatusOK || entry.Upstream != "backend" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.Duration < 50 {
+		t.Errorf("duration = %.1fms, want >= 50ms", entry.Duration)
+	}
+	if entry.Phases.TTFB < 50 {
+		t.Errorf("ttfb = %.1fms, want >= 50ms", entry.Phases.TTFB)
+	}
+	if entry.Phases.Connect == 0 {
+		t.Errorf("connect phase was not captured")
+	}
+	if entry.Query != "page=REDACTED&token=REDACTED" {
+		t.Errorf("query = %q, want values redacted", entry.Query)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/requests?route=test&min_duration=50ms", nil))
+	var entries []flightrecorder.Entry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].RequestID != "slow-1" {
+		t.Errorf("query returned %+v", entries)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/requests?route=other", nil))
+	if rec.Body.String() != "[]\n" {
+		t.Errorf("expected no entries for another route, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/requests/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing request status = %d, want 404", rec.Code)
+	}
+}
+
+func TestProxy_FlightRecorderSkipsFastUnsampledRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.FlightRecorder = config.FlightRecorderConfig{
+			Enabled:       true,
+			Size:          16,
+			SlowThreshold: time.Minute,
+		}
+	})
+
+	req := httptest.NewRequest("GET", "/fast", nil)
+	req.Header.Set("X-Request-ID", "fast-1")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if _, ok := p.recorder.Get("fast-1"); ok {
+		t.Error("fast request should not have been recorded")
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// config.ForwardedHeaders are dropped from requests sent with only the
+// Forwarded header, and those a route omits from any request, so upstreams
+// cannot be handed client-made values.
+
+// forwardedFor returns the forwarding header settings of route, falling
+// back to server.forwarded.
+func (p *Proxy) forwardedFor(route *router.Route) config.Forwarded {
+	fwd := route.Forwarded
+	if fwd == nil {
+		fwd = p.config.Server.Forwarded
+	}
+	if fwd == nil {
+		return config.Forwarded{Mode: config.ForwardedLegacy}
+	}
+	return *fwd
+}
+
+// forwardedPort returns the port the client sent the request to: the one a
+// trusted proxy reports, else the gateway listener's, unless a trusted
+// proxy set the scheme, in which case the client talked to the proxy and
+// the port comes from Host or the scheme's default.
+func forwardedPort(r *http.Request, scheme string, trusted bool) string {
+	if trusted {
+		first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Port"), ",")
+		if port := strings.TrimSpace(first); validPort(port) {
+			return port
+		}
+	}
+	if !trusted || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "" {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			if _, port, err := net.SplitHostPort(addr.String()); err == nil && validPort(port) {
+				return port
+			}
+		}
+	}
+	if _, port, err := net.SplitHostPort(r.Host); err == nil && validPort(port) {
+		return port
+	}
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func validPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535 && s[0] != '+'
+}
+
+// setForwarded appends the gateway's element to the RFC 7239 Forwarded
+// header. A trusted peer's elements are kept; anyone else's are dropped.
+func setForwarded(h http.Header, r *http.Request, peer netip.Addr, trusted, obfuscate bool) {
+	node := "_hidden"
+	if !obfuscate {
+		node = forwardedNode(peer)
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	element := "for=" + forwardedValue(node) + ";host=" + forwardedValue(r.Host) + ";proto=" + proto
+
+	var prior []string
+	if trusted {
+		prior = r.Header.Values("Forwarded")
+	}
+	h.Set("Forwarded", strings.Join(append(prior, element), ", "))
+}
+
+// forwardedNode formats addr as an RFC 7239 node name. IPv6 addresses are
+// bracketed.
+func forwardedNode(addr netip.Addr) string {
+	if !addr.IsValid() {
+		return "unknown"
+	}
+	if addr.Is6() {
+		return "[" + addr.String() + "]"
+	}
+	return addr.String()
+}
+
+// forwardedValue returns v as a token, or as a quoted string when it holds
+// characters a token cannot, such as the colons of an IPv6 node or a port.
+func forwardedValue(v string) string {
+	if v != "" && strings.IndexFunc(v, func(c rune) bool { return !isTokenChar(c) }) == -1 {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range v {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isTokenChar reports whether c is an RFC 7230 tchar.
+func isTokenChar(c rune) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
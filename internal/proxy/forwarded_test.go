@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_ForwardedHeader(t *testing.T) {
+	forwarded := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name    string
+		fwd     *config.Forwarded
+		remote  string
+		prior   string
+		want    string
+		wantXFF string
+	}{
+		{"legacy default", nil, "192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"both", &config.Forwarded{Mode: config.ForwardedBoth}, "192.0.2.1:1234", "",
+			`for=192.0.2.1;host=example.com;proto=http`, "192.0.2.1"},
+		{"ipv6 quoted", &config.Forwarded{Mode: config.ForwardedStandard}, "[2001:db8::1]:1234", "",
+			`for="[2001:db8::1]";host=example.com;proto=http`, ""},
+		{"obfuscated", &config.Forwarded{Mode: config.ForwardedStandard, ObfuscateFor: true}, "192.0.2.1:1234", "",
+			`for=_hidden;host=example.com;proto=http`, ""},
+		{"trusted chain extended", &config.Forwarded{Mode: config.ForwardedStandard}, "10.0.0.2:1234", "for=198.51.100.7;proto=https",
+			`for=198.51.100.7;proto=https, for=10.0.0.2;host=example.com;proto=http`, ""},
+		{"untrusted chain replaced", &config.Forwarded{Mode: config.ForwardedStandard}, "192.0.2.1:1234", "for=198.51.100.7",
+			`for=192.0.2.1;host=example.com;proto=http`, ""},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+			cfg.Routes[0].Forwarded = tt.fwd
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		if tt.prior != "" {
+			req.Header.Set("Forwarded", tt.prior)
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+
+		h := <-forwarded
+		if tt.want != "" && h.Get("Forwarded") != tt.want {
+			t.Errorf("%s: Forwarded = %q, want %q", tt.name, h.Get("Forwarded"), tt.want)
+		}
+		if tt.want == "" && h.Get("Forwarded") != "" {
+			t.Errorf("%s: unexpected Forwarded %q", tt.name, h.Get("Forwarded"))
+		}
+		if got := h.Get("X-Forwarded-For"); got != tt.wantXFF {
+			t.Errorf("%s: X-Forwarded-For = %q, want %q", tt.name, got, tt.wantXFF)
+		}
+	}
+}
+
+func TestProxy_ForwardedPortAndScheme(t *testing.T) {
+	forwarded := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name      string
+		target    string
+		listener  int
+		remote    string
+		headers   map[string]string
+		omit      []string
+		wantProto string
+		wantPort  string
+		wantHost  string
+	}{
+		{"gateway terminates TLS", "https://api.example.com/", 8443, "192.0.2.1:1234",
+			map[string]string{"X-Forwarded-Proto": "http", "X-Forwarded-Port": "1", "X-Forwarded-Host": "evil.example"},
+			nil, "https", "8443", "api.example.com"},
+		{"plain HTTP behind an ELB", "http://api.example.com/", 8080, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Port": "443"},
+			nil, "https", "443", "api.example.com"},
+		{"trusted proxy without a port", "http://api.example.com/", 8080, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "shop.example.com"},
+			nil, "https", "443", "shop.example.com"},
+		{"trusted proxy with an invalid port", "http://api.example.com:9000/", 9000, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-Port": "https"},
+			nil, "http", "9000", "api.example.com:9000"},
+		{"direct client", "http://api.example.com/", 8080, "192.0.2.1:1234",
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Port": "443"},
+			nil, "http", "8080", "api.example.com"},
+		{"omitted", "http://api.example.com/", 8080, "192.0.2.1:1234",
+			map[string]string{"X-Real-IP": "203.0.113.9", "X-Forwarded-Port": "443"},
+			[]string{"X-Forwarded-Port", "x-real-ip"}, "http", "", "api.example.com"},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+			if tt.omit != nil {
+				cfg.Routes[0].Forwarded = &config.Forwarded{Omit: tt.omit}
+			}
+		})
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.RemoteAddr = tt.remote
+		listener := &net.TCPAddr{IP: net.IPv4(10, 1, 0, 1), Port: tt.listener}
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, listener))
+		for name, value := range tt.headers {
+			req.Header.Set(name, value)
+		}
+		p.ServeHTTP(httptest.NewRecorder(), req)
+
+		h := <-forwarded
+		if got := h.Get("X-Forwarded-Proto"); got != tt.wantProto {
+			t.Errorf("%s: X-Forwarded-Proto = %q, want %q", tt.name, got, tt.wantProto)
+		}
+		if got := h.Get("X-Forwarded-Port"); got != tt.wantPort {
+			t.Errorf("%s: X-Forwarded-Port = %q, want %q", tt.name, got, tt.wantPort)
+		}
+		if got := h.Get("X-Forwarded-Host"); got != tt.wantHost {
+			t.Errorf("%s: X-Forwarded-Host = %q, want %q", tt.name, got, tt.wantHost)
+		}
+		if tt.omit != nil && h.Get("X-Real-IP") != "" {
+			t.Errorf("%s: omitted X-Real-IP sent as %q", tt.name, h.Get("X-Real-IP"))
+		}
+	}
+}
+
+func TestForwardedValue(t *testing.T) {
+	for in, want := range map[string]string{
+		"192.0.2.1":        "192.0.2.1",
+		"_hidden":          "_hidden",
+		"[2001:db8::1]":    `"[2001:db8::1]"`,
+		"example.com:8080": `"example.com:8080"`,
+		`a"b\c`:            `"a\"b\\c"`,
+		"":                 `""`,
+	} {
+		if got := forwardedValue(in); got != want {
+			t.Errorf("forwardedValue(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
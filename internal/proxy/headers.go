@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// applyHeaderRules removes, sets and adds headers in h, in that order.
+// Removal matches names case-insensitively, since copied headers keep the
+// case they arrived with; a trailing * matches any name with that prefix.
+func applyHeaderRules(h http.Header, rules *config.HeaderRules) {
+	if rules == nil {
+		return
+	}
+
+	for _, pattern := range rules.Remove {
+		prefix, wildcard := strings.CutSuffix(pattern, "*")
+		for name := range h {
+			if wildcard && len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				delete(h, name)
+			} else if !wildcard && strings.EqualFold(name, pattern) {
+				delete(h, name)
+			}
+		}
+	}
+	for k, v := range rules.Set {
+		h.Set(k, v)
+	}
+	for k, v := range rules.Add {
+		h.Add(k, v)
+	}
+}
+
+// setUpstreamAuth sets the route's upstream credential on h, after removing
+// the client's Authorization header when strip_client_auth is set. Any
+// header the credential replaces is removed in every case it was sent in.
+func setUpstreamAuth(h http.Header, auth *config.UpstreamAuth) {
+	if auth == nil {
+		return
+	}
+	if auth.StripClientAuth {
+		deleteHeader(h, "Authorization")
+	}
+	switch {
+	case auth.BearerToken != "":
+		deleteHeader(h, "Authorization")
+		h.Set("Authorization", "Bearer "+auth.BearerToken)
+	case auth.Basic != nil:
+		deleteHeader(h, "Authorization")
+		credentials := auth.Basic.Username + ":" + auth.Basic.Password
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case auth.Header != nil:
+		deleteHeader(h, auth.Header.Name)
+		h.Set(auth.Header.Name, auth.Header.Value)
+	}
+}
+
+// deleteHeader removes name from h case-insensitively.
+func deleteHeader(h http.Header, name string) {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			delete(h, k)
+		}
+	}
+}
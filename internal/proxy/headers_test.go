@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestApplyHeaderRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    http.Header
+		rules *config.HeaderRules
+		want  http.Header
+	}{
+		{
+			name:  "nil rules",
+			in:    http.Header{"X-A": {"1"}},
+			rules: nil,
+			want:  http.Header{"X-A": {"1"}},
+		},
+		{
+			name:  "remove exact is case-insensitive",
+			in:    http.Header{"x-internal-debug": {"1"}, "X-Keep": {"1"}},
+			rules: &config.HeaderRules{Remove: []string{"X-Internal-Debug"}},
+			want:  http.Header{"X-Keep": {"1"}},
+		},
+		{
+			name:  "remove suffix wildcard",
+			in:    http.Header{"X-Internal-Debug": {"1"}, "X-Internal-Trace": {"1"}, "X-Internalish": {"1"}, "X-Public": {"1"}},
+			rules: &config.HeaderRules{Remove: []string{"X-Internal-*"}},
+			want:  http.Header{"X-Internalish": {"1"}, "X-Public": {"1"}},
+		},
+		{
+			name:  "set replaces, add appends",
+			in:    http.Header{"Cache-Control": {"no-store"}, "Via": {"1.1 edge"}},
+			rules: &config.HeaderRules{Set: map[string]string{"Cache-Control": "max-age=60"}, Add: map[string]string{"Via": "1.1 relaypoint"}},
+			want:  http.Header{"Cache-Control": {"max-age=60"}, "Via": {"1.1 edge", "1.1 relaypoint"}},
+		},
+		{
+			name: "remove runs before set and add",
+			in:   http.Header{"X-Internal-Route": {"upstream-value"}, "X-Internal-Debug": {"1"}},
+			rules: &config.HeaderRules{
+				Remove: []string{"X-Internal-*"},
+				Set:    map[string]string{"X-Internal-Route": "gateway"},
+				Add:    map[string]string{"X-Internal-Tag": "a"},
+			},
+			want: http.Header{"X-Internal-Route": {"gateway"}, "X-Internal-Tag": {"a"}},
+		},
+		{
+			name:  "set then add on the same header",
+			in:    http.Header{},
+			rules: &config.HeaderRules{Set: map[string]string{"X-Tag": "one"}, Add: map[string]string{"X-Tag": "two"}},
+			want:  http.Header{"X-Tag": {"one", "two"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applyHeaderRules(tt.in, tt.rules)
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Errorf("got %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_HeaderRules(t *testing.T) {
+	var upstreamHeaders http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header.Clone()
+		w.Header().Set("X-Internal-Debug", "stack")
+		w.Header().Set("X-Internal-Node", "db-3")
+		w.Header().Set("Content-Type", "text/plain")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].RequestHeaders = &config.HeaderRules{
+			Remove: []string{"Cookie"},
+			Set:    map[string]string{"X-Gateway": "relaypoint"},
+			Add:    map[string]string{"X-Tenant": "acme"},
+		}
+		cfg.Routes[0].ResponseHeaders = &config.HeaderRules{
+			Remove: []string{"X-Internal-*"},
+			Set:    map[string]string{"Cache-Control": "public, max-age=300"},
+		}
+	})
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("X-Tenant", "client-supplied")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if upstreamHeaders.Get("Cookie") != "" {
+		t.Error("Cookie should have been removed before reaching the upstream")
+	}
+	if got := upstreamHeaders.Get("X-Gateway"); got != "relaypoint" {
+		t.Errorf("X-Gateway = %q, want relaypoint", got)
+	}
+	if got := upstreamHeaders.Values("X-Tenant"); !reflect.DeepEqual(got, []string{"client-supplied", "acme"}) {
+		t.Errorf("X-Tenant = %v, want client value plus added value", got)
+	}
+
+	if rec.Header().Get("X-Internal-Debug") != "" || rec.Header().Get("X-Internal-Node") != "" {
+		t.Errorf("internal headers leaked to the client: %v", rec.Header())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=300" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+}
+
+func TestProxy_UpstreamAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       *config.UpstreamAuth
+		wantAuth   string
+		wantHeader string // X-Backend-Key
+	}{
+		{"bearer", &config.UpstreamAuth{BearerToken: "backend-token"}, "Bearer backend-token", ""},
+		{"basic", &config.UpstreamAuth{Basic: &config.UpstreamBasicAuth{Username: "gw", Password: "s3cret"}}, "Basic Z3c6czNjcmV0", ""},
+		{"header with strip", &config.UpstreamAuth{Header: &config.UpstreamAuthHeader{Name: "X-Backend-Key", Value: "k1"}, StripClientAuth: true}, "", "k1"},
+		{"strip only", &config.UpstreamAuth{StripClientAuth: true}, "", ""},
+		{"header without strip", &config.UpstreamAuth{Header: &config.UpstreamAuthHeader{Name: "X-Backend-Key", Value: "k1"}}, "Bearer client-key", "k1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upstreamHeaders http.Header
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				upstreamHeaders = r.Header.Clone()
+			}))
+			defer backend.Close()
+
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.Routes[0].UpstreamAuth = tt.auth
+			})
+
+			req := httptest.NewRequest("GET", "/items", nil)
+			req.Header.Set("Authorization", "Bearer client-key")
+			req.Header.Set("X-Backend-Key", "client-forged")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := upstreamHeaders.Values("Authorization"); strings.Join(got, ",") != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+			}
+			if tt.wantHeader != "" {
+				if got := upstreamHeaders.Values("X-Backend-Key"); strings.Join(got, ",") != tt.wantHeader {
+					t.Errorf("X-Backend-Key = %q, want %q", got, tt.wantHeader)
+				}
+			}
+		})
+	}
+}
+
+func TestSetUpstreamAuth_NonCanonicalClientHeader(t *testing.T) {
+	h := http.Header{"authorization": {"Bearer client-key"}}
+	setUpstreamAuth(h, &config.UpstreamAuth{BearerToken: "backend-token"})
+	if !reflect.DeepEqual(h, http.Header{"Authorization": {"Bearer backend-token"}}) {
+		t.Errorf("got %v, want only the upstream credential", h)
+	}
+}
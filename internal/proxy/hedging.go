@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const defaultHedgeAttempts = 2
+
+// hedgeable reports whether r may be sent more than once on route: the
+// route hedges, the method is idempotent and there is no body to replay.
+func hedgeable(r *http.Request, route *router.Route) bool {
+	if route.Hedging == nil || (r.Body != nil && r.Body != http.NoBody) {
+		return false
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+type hedgeResult struct {
+	attempt int
+	resp    *http.Response
+	err     error
+}
+
+// doHedged sends upstreamReq to target and, each time the hedge delay
+// passes without a response, a copy to another target of the upstream, up
+// to max_attempts requests. The first response wins and the other
+// attempts are canceled; a failed attempt starts the next one right away.
+// Only when every attempt fails is the last error returned.
+func (p *Proxy) doHedged(r *http.Request, route *router.Route, target *loadbalancer.Target, upstreamReq *http.Request) (*http.Response, error) {
+	maxAttempts := route.Hedging.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultHedgeAttempts
+	}
+	client := p.clientFor(route.Upstream)
+	routeName := routeNameOf(route)
+
+	results := make(chan hedgeResult, maxAttempts)
+	var cancels []context.CancelFunc
+	send := func(req *http.Request, t *loadbalancer.Target) {
+		ctx, cancel := context.WithCancel(req.Context())
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+		if attempt > 0 {
+			t.Connections.Add(1)
+		}
+		go func() {
+			resp, err := client.Do(req.WithContext(ctx))
+			if attempt > 0 {
+				t.Connections.Add(-1)
+			}
+			results <- hedgeResult{attempt: attempt, resp: resp, err: err}
+		}()
+	}
+	// hedge starts the next attempt on a target not used yet. It reports
+	// false when there is none.
+	used := []*loadbalancer.Target{target}
+	hedge := func() bool {
+		lb, ok := p.upstreams[route.Upstream]
+		if !ok || len(cancels) >= maxAttempts {
+			return false
+		}
+		t := loadbalancer.NextExcept(lb, used)
+		if t == nil {
+			p.metrics.RecordHedge(routeName, "no_target")
+			return false
+		}
+		req, err := p.newAuthorizedRequest(r, route, t, route.Upstream)
+		if err != nil || stampDeadline(req, route) != nil {
+			return false
+		}
+		used = append(used, t)
+		send(req, t)
+		return true
+	}
+
+	send(upstreamReq, target)
+	pending := 1
+	timer := time.NewTimer(route.Hedging.Delay)
+	defer timer.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if hedge() {
+				pending++
+				timer.Reset(route.Hedging.Delay)
+			}
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				cancels[res.attempt]()
+				lastErr = res.err
+				if r.Context().Err() == nil && hedge() {
+					pending++
+				}
+				if pending == 0 {
+					return nil, lastErr
+				}
+				continue
+			}
+
+			for i, cancel := range cancels {
+				if i != res.attempt {
+					cancel()
+				}
+			}
+			go discardHedges(results, pending)
+			if len(cancels) > 1 {
+				outcome := "primary_won"
+				if res.attempt > 0 {
+					outcome = "hedge_won"
+				}
+				p.metrics.RecordHedge(routeName, outcome)
+			}
+			res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: cancels[res.attempt]}
+			return res.resp, nil
+		}
+	}
+}
+
+// discardHedges closes the responses of the n attempts that lost.
+func discardHedges(results <-chan hedgeResult, n int) {
+	for range n {
+		if res := <-results; res.err == nil {
+			_ = res.resp.Body.Close()
+		}
+	}
+}
+
+// cancelOnClose ends the winning attempt's context once its body is done.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// stallFirstBackends starts two targets sharing one behavior: the first
+// request to reach either stalls until it is canceled, reported on
+// canceled; every later one answers with the target's name.
+func stallFirstBackends(t *testing.T) (urls []string, hits []*atomic.Int64, canceled chan struct{}) {
+	t.Helper()
+	var stalled atomic.Bool
+	canceled = make(chan struct{}, 1)
+	for _, name := range []string{"a", "b"} {
+		n := new(atomic.Int64)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n.Add(1)
+			if stalled.CompareAndSwap(false, true) {
+				<-r.Context().Done()
+				canceled <- struct{}{}
+				return
+			}
+			_, _ = w.Write([]byte(name))
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+		hits = append(hits, n)
+	}
+	return urls, hits, canceled
+}
+
+func hedgingProxy(t *testing.T, urls []string) *Proxy {
+	t.Helper()
+	return newTestProxy(t, urls[0], func(cfg *config.Config) {
+		for _, u := range urls[1:] {
+			cfg.Upstreams[0].Targets = append(cfg.Upstreams[0].Targets, config.Target{URL: u})
+		}
+		cfg.Routes[0].Hedging = &config.Hedging{Delay: 20 * time.Millisecond}
+	})
+}
+
+func hedgeMetrics(p *Proxy) string {
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestProxy_HedgeWins(t *testing.T) {
+	urls, hits, canceled := stallFirstBackends(t)
+	p := hedgingProxy(t, urls)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Code != http.StatusOK || (rec.Body.String() != "a" && rec.Body.String() != "b") {
+		t.Fatalf("status = %d, body = %q, want 200 from the hedge", rec.Code, rec.Body.String())
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the losing attempt was not canceled")
+	}
+	if hits[0].Load() != 1 || hits[1].Load() != 1 {
+		t.Errorf("hits = %d, %d, want one request on each target", hits[0].Load(), hits[1].Load())
+	}
+	if m := hedgeMetrics(p); !strings.Contains(m, `gateway_hedged_requests_total{key="test_hedge_won"} 1`) {
+		t.Errorf("hedge win was not counted:\n%s", m)
+	}
+}
+
+func TestProxy_HedgeNotNeeded(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := hedgingProxy(t, []string{backend.URL, backend.URL})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	time.Sleep(50 * time.Millisecond)
+	if rec.Code != http.StatusOK || hits.Load() != 1 {
+		t.Errorf("status = %d, hits = %d, want 200 from a single request", rec.Code, hits.Load())
+	}
+	if m := hedgeMetrics(p); strings.Contains(m, "gateway_hedged_requests_total{") {
+		t.Errorf("request answered in time was counted as hedged:\n%s", m)
+	}
+}
+
+func TestProxy_HedgeSkipsBodies(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(60 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := hedgingProxy(t, []string{backend.URL, backend.URL})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/items", nil),
+		httptest.NewRequest("PUT", "/items", strings.NewReader(`{"n":1}`)),
+	} {
+		hits.Store(0)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || hits.Load() != 1 {
+			t.Errorf("%s: status = %d, hits = %d, want 200 from a single request", req.Method, rec.Code, hits.Load())
+		}
+	}
+}
+
+func TestProxy_HedgeNeedsAnotherTarget(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(60 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := hedgingProxy(t, []string{backend.URL})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	if m := hedgeMetrics(p); !strings.Contains(m, `gateway_hedged_requests_total{key="test_no_target"} 1`) {
+		t.Errorf("missing hedge target was not counted:\n%s", m)
+	}
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/idempotency"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotentReplayHeader  = "X-Idempotent-Replay"
+	maxIdempotencyKeyLength = 255
+
+	defaultIdempotencyTTL         = time.Hour
+	defaultIdempotencyMaxBodySize = 64 << 10
+	defaultIdempotencyMaxEntries  = 10000
+)
+
+// idempotencyKeys holds the stored responses of one route's
+// idempotency_keys.
+type idempotencyKeys struct {
+	store       idempotency.Store
+	locks       idempotency.Locks
+	ttl         time.Duration
+	maxBodySize int64
+}
+
+func newIdempotencyKeys(cfg *config.IdempotencyKeys) *idempotencyKeys {
+	k := &idempotencyKeys{ttl: cfg.TTL, maxBodySize: cfg.MaxBodySize}
+	if k.ttl == 0 {
+		k.ttl = defaultIdempotencyTTL
+	}
+	if k.maxBodySize == 0 {
+		k.maxBodySize = defaultIdempotencyMaxBodySize
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = defaultIdempotencyMaxEntries
+	}
+	k.store = idempotency.NewMemoryStore(maxEntries)
+	return k
+}
+
+// idempotentRequest is the first request seen with a key, whose response
+// is stored for the retries.
+type idempotentRequest struct {
+	keys      *idempotencyKeys
+	key       string // store key, scoped to the route and caller
+	request   string // see idempotentRequestID
+	routeName string
+}
+
+type idempotentRequestKey struct{}
+
+func idempotentRequestFrom(ctx context.Context) *idempotentRequest {
+	ir, _ := ctx.Value(idempotentRequestKey{}).(*idempotentRequest)
+	return ir
+}
+
+// idempotencyStage answers a request whose Idempotency-Key was already
+// used with the stored response. Requests with the same key wait here for
+// the one being proxied to finish; the first of them then goes through, with
+// its response stored on the way back to the client.
+func (p *Proxy) idempotencyStage(req *pipelineRequest) bool {
+	keys, ok := p.idempotency[req.route.IdempotencyKeys]
+	if !ok {
+		return true
+	}
+	switch req.r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	key := req.r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return true
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		p.metrics.RecordError(req.routeName, "invalid_idempotency_key")
+		p.writeError(req.w, http.StatusBadRequest, "invalid_idempotency_key", "idempotency key too long")
+		return false
+	}
+
+	// Keys are scoped to the caller, so nobody can replay another tenant's
+	// response by guessing its key.
+	scope := "ip:" + req.clientIP
+	if req.apiKeyName != "" {
+		scope = "key:" + req.apiKeyName
+	}
+	storeKey := req.routeName + "\x00" + scope + "\x00" + key
+	unlock, waited, err := keys.locks.Lock(req.r.Context(), storeKey)
+	if err != nil {
+		req.w.status = 499 // Client Closed Request
+		return false
+	}
+	req.onDone(func(int) { unlock() })
+	if waited {
+		p.metrics.RecordIdempotencyOperation(req.routeName, "wait")
+	}
+
+	request := idempotentRequestID(req.r)
+	if stored, ok := keys.store.Get(storeKey); ok {
+		if stored.Request != request {
+			p.metrics.RecordIdempotencyOperation(req.routeName, "mismatch")
+			p.metrics.RecordError(req.routeName, "idempotency_key_reused")
+			p.writeError(req.w, http.StatusUnprocessableEntity, "idempotency_key_reused", "idempotency key was used for a different request")
+			return false
+		}
+		p.metrics.RecordIdempotencyOperation(req.routeName, "replay")
+		header := stored.Header.Clone()
+		header.Set(idempotentReplayHeader, "true")
+		_ = p.writeResponse(req.w, req.r, req.route, &http.Response{
+			StatusCode:    stored.Status,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(stored.Body)),
+			ContentLength: int64(len(stored.Body)),
+		})
+		p.metrics.RecordRequest(req.routeName, req.r.Method, req.w.status, time.Since(req.start))
+		return false
+	}
+
+	req.r = req.r.WithContext(context.WithValue(req.r.Context(), idempotentRequestKey{}, &idempotentRequest{
+		keys:      keys,
+		key:       storeKey,
+		request:   request,
+		routeName: req.routeName,
+	}))
+	return true
+}
+
+// idempotentRequestID is what a retry must share with the first request
+// to be answered with its response: the method and the URL.
+func idempotentRequestID(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI()
+}
+
+// idempotencyFill is a cacheFill for the response to an idempotent
+// request. A nil *idempotencyFill does nothing.
+type idempotencyFill struct {
+	p      *Proxy
+	ir     *idempotentRequest
+	status int
+	header http.Header
+	body   *cacheBody
+}
+
+// idempotencyFill arranges for resp to be stored under the request's
+// idempotency key, unless it is an error retries should not be stuck
+// with. It must be called before resp.Body is read.
+func (p *Proxy) idempotencyFill(r *http.Request, resp *http.Response) *idempotencyFill {
+	ir := idempotentRequestFrom(r.Context())
+	if ir == nil || resp.StatusCode >= 500 {
+		return nil
+	}
+	if resp.ContentLength > ir.keys.maxBodySize {
+		p.metrics.RecordIdempotencyOperation(ir.routeName, "too_large")
+		return nil
+	}
+	body := &cacheBody{ReadCloser: resp.Body, limit: ir.keys.maxBodySize}
+	resp.Body = body
+	return &idempotencyFill{p: p, ir: ir, status: resp.StatusCode, header: resp.Header.Clone(), body: body}
+}
+
+// finish stores the response if its whole body was read.
+func (f *idempotencyFill) finish() {
+	if f == nil {
+		return
+	}
+	if f.body.overflow {
+		f.p.metrics.RecordIdempotencyOperation(f.ir.routeName, "too_large")
+		return
+	}
+	if !f.body.complete() {
+		return
+	}
+	f.ir.keys.store.Put(f.ir.key, &idempotency.Response{
+		Status:  f.status,
+		Header:  f.header,
+		Body:    bytes.Clone(f.body.buf.Bytes()),
+		Request: f.ir.request,
+		Stored:  time.Now(),
+	}, f.ir.keys.ttl)
+	f.p.metrics.RecordIdempotencyOperation(f.ir.routeName, "store")
+}
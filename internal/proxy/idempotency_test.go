@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_IdempotencyKeys(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Charge", fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "charge %d", n)
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].IdempotencyKeys = &config.IdempotencyKeys{}
+		cfg.APIKeys = []config.APIKey{
+			{Key: "k1", Name: "alice", Enabled: true},
+			{Key: "k2", Name: "bob", Enabled: true},
+		}
+	})
+	send := func(method, path, apiKey, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		if apiKey != "" {
+			r.Header.Set("X-API-Key", apiKey)
+		}
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		return rec
+	}
+
+	first := send("POST", "/charges", "k1", "abc")
+	if first.Code != http.StatusCreated || first.Header().Get("X-Idempotent-Replay") != "" {
+		t.Fatalf("first: status = %d, replay = %q", first.Code, first.Header().Get("X-Idempotent-Replay"))
+	}
+	retry := send("POST", "/charges", "k1", "abc")
+	if retry.Code != http.StatusCreated || retry.Body.String() != "charge 1" || retry.Header().Get("X-Charge") != "1" {
+		t.Errorf("retry: status = %d, body = %q, X-Charge = %q", retry.Code, retry.Body, retry.Header().Get("X-Charge"))
+	}
+	if retry.Header().Get("X-Idempotent-Replay") != "true" || hits.Load() != 1 {
+		t.Errorf("retry: replay = %q after %d upstream hits, want true after 1", retry.Header().Get("X-Idempotent-Replay"), hits.Load())
+	}
+
+	// Keys are scoped to the API key, or the client IP without one.
+	if rec := send("POST", "/charges", "k2", "abc"); rec.Body.String() != "charge 2" {
+		t.Errorf("other API key: body = %q, want a new charge", rec.Body)
+	}
+	if rec := send("POST", "/charges", "", "abc"); rec.Body.String() != "charge 3" {
+		t.Errorf("no API key: body = %q, want a new charge", rec.Body)
+	}
+	if rec := send("POST", "/charges", "", "abc"); rec.Body.String() != "charge 3" {
+		t.Errorf("no API key, retried: body = %q, want the stored charge", rec.Body)
+	}
+
+	hits.Store(10)
+	if rec := send("POST", "/charges", "k1", ""); rec.Body.String() != "charge 11" {
+		t.Errorf("no idempotency key: body = %q", rec.Body)
+	}
+	if rec := send("GET", "/charges", "k1", "abc"); rec.Body.String() != "charge 12" {
+		t.Errorf("GET: body = %q, want it proxied", rec.Body)
+	}
+	if rec := send("PUT", "/charges", "k1", "abc"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused for another request: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := send("POST", "/charges", "k1", strings.Repeat("x", 256)); rec.Code != http.StatusBadRequest {
+		t.Errorf("long key: status = %d, want 400", rec.Code)
+	}
+
+	// Server errors are not stored, so the request can be retried.
+	hits.Store(0)
+	send("POST", "/fail", "k1", "retry-me")
+	if rec := send("POST", "/fail", "k1", "retry-me"); rec.Header().Get("X-Idempotent-Replay") != "" || hits.Load() != 2 {
+		t.Errorf("after a 503: replay = %q after %d upstream hits, want none after 2", rec.Header().Get("X-Idempotent-Replay"), hits.Load())
+	}
+
+	rec := httptest.NewRecorder()
+	p.metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_idempotency_operations_total{key="test_store"} 3`,
+		`gateway_idempotency_operations_total{key="test_replay"} 2`,
+		`gateway_idempotency_operations_total{key="test_mismatch"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestProxy_IdempotencyKeysConcurrent(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		<-release
+		_, _ = fmt.Fprintf(w, "charge %d", n)
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].IdempotencyKeys = &config.IdempotencyKeys{}
+	})
+
+	const n = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/charges", nil)
+			r.Header.Set("Idempotency-Key", "abc")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, r)
+			bodies[i] = rec.Body.String()
+		}()
+	}
+	// Let the others queue up behind the first before it answers.
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if hits.Load() != 1 {
+		t.Errorf("upstream hits = %d, want 1", hits.Load())
+	}
+	for i, body := range bodies {
+		if body != "charge 1" {
+			t.Errorf("request %d: body = %q, want charge 1", i, body)
+		}
+	}
+}
+
+func TestProxy_IdempotencyKeysMaxBodySize(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].IdempotencyKeys = &config.IdempotencyKeys{MaxBodySize: 50}
+	})
+
+	for range 2 {
+		r := httptest.NewRequest("POST", "/report", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		if rec.Body.Len() != 100 || rec.Header().Get("X-Idempotent-Replay") != "" {
+			t.Errorf("body = %d bytes, replay = %q; want the whole body, proxied", rec.Body.Len(), rec.Header().Get("X-Idempotent-Replay"))
+		}
+	}
+	if hits.Load() != 2 {
+		t.Errorf("upstream hits = %d, want 2", hits.Load())
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// primeSize is how much of the body is read before the response head is
+// written, on routes with response_idle_timeout.
+const primeSize = 32 << 10
+
+// errUpstreamStalled is returned by proxyRequest when the upstream sent
+// nothing for the route's response_idle_timeout while its body was read.
+// errResponseTruncated is the same once part of the response was relayed,
+// too late to answer 504.
+var (
+	errUpstreamStalled   = errors.New("upstream stalled")
+	errResponseTruncated = fmt.Errorf("%w after the response started", errUpstreamStalled)
+)
+
+// idleTimeoutBody aborts the upstream request when a Read waits longer than
+// timeout for the upstream. Time spent between reads, writing to a slow
+// client, does not count.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	stalled atomic.Bool
+
+	// pending holds what prime read, and err how that read ended.
+	pending []byte
+	err     error
+}
+
+// newIdleTimeoutBody wraps body, calling abort to cancel the upstream
+// request, which closes its connection, when it stalls.
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, abort context.CancelCauseFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.stalled.Store(true)
+		abort(errUpstreamStalled)
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if len(b.pending) > 0 {
+		n := copy(p, b.pending)
+		b.pending = b.pending[n:]
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.stalled.Load() {
+		err = errUpstreamStalled
+	}
+	return n, err
+}
+
+// prime reads the start of the body before anything is written to the
+// client, so an upstream that stalls right after its headers can still be
+// answered with 504. It returns errUpstreamStalled if it did; other read
+// errors are left for the copy to find.
+func (b *idleTimeoutBody) prime() error {
+	buf := make([]byte, primeSize)
+	n, err := b.Read(buf)
+	b.pending, b.err = buf[:n], err
+	if errors.Is(err, errUpstreamStalled) {
+		return err
+	}
+	return nil
+}
+
+// Close closes the body. After a stall the request was canceled, and the
+// error that leaves on the body is expected.
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	if b.stalled.Load() {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// stallingBackend writes body, flushes, then stalls until the client goes
+// away, which it reports on closed.
+func stallingBackend(t *testing.T, closed chan<- string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/trickle" {
+			for range 8 {
+				_, _ = io.WriteString(w, "x")
+				w.(http.Flusher).Flush()
+				time.Sleep(30 * time.Millisecond)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, strings.TrimPrefix(r.URL.Path, "/stall/"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			closed <- r.URL.Path
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestProxy_ResponseIdleTimeout(t *testing.T) {
+	closed := make(chan string, 4)
+	backend := stallingBackend(t, closed)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].ResponseIdleTimeout = 100 * time.Millisecond
+	})
+
+	// Stalled before any body byte: nothing was relayed, so 504.
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/stall/", nil))
+	if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "gateway timeout") {
+		t.Fatalf("status = %d, body = %q, want 504", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stall detected after %v", elapsed)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("upstream connection was not closed")
+	}
+
+	// Bytes arriving more often than the timeout keep the response going.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/trickle", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "xxxxxxxx" {
+		t.Errorf("trickle: status = %d, body = %q", rec.Code, rec.Body)
+	}
+
+	// Stalled mid-body: the client sees a broken response.
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+	resp, err := http.Get(gateway.URL + "/stall/partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "partial" || err == nil {
+		t.Errorf("mid-body stall: status = %d, body = %q, err = %v, want a truncated 200", resp.StatusCode, body, err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("upstream connection was not closed after a mid-body stall")
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `gateway_errors_total{key="test_upstream_stalled"} 2`; !strings.Contains(metrics.Body.String(), want) {
+		t.Errorf("metrics missing %s", want)
+	}
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+// Phases of an in-flight request, as listed by GET /admin/inflight.
+const (
+	inflightProcessing      int32 = iota // running the stages before the upstream
+	inflightQueued                       // waiting for a max_concurrent slot
+	inflightAwaitingHeaders              // sent upstream, no response yet
+	inflightCopyingBody                  // relaying the response body
+)
+
+var inflightPhases = [...]string{
+	inflightProcessing:      "processing",
+	inflightQueued:          "queued",
+	inflightAwaitingHeaders: "awaiting_upstream_headers",
+	inflightCopyingBody:     "copying_body",
+}
+
+// errRequestCanceled is the cause of requests canceled through
+// POST /admin/inflight/{id}/cancel.
+var errRequestCanceled = errors.New("request canceled by an operator")
+
+// inflightRequest is one request being served. Only phase, target and
+// upstream change once it is registered.
+type inflightRequest struct {
+	id        string
+	requestID string
+	route     string
+	method    string
+	path      string
+	query     string
+	clientIP  string
+	start     time.Time
+	cancel    context.CancelCauseFunc
+
+	phase  atomic.Int32
+	target atomic.Pointer[string]
+	// upstream is the time spent waiting on and relaying the upstream
+	// response, once it is known.
+	upstream atomic.Int64
+}
+
+// setPhase, setTarget and setUpstreamDuration are no-ops on nil, for
+// requests served outside ServeHTTP.
+func (e *inflightRequest) setPhase(phase int32) {
+	if e != nil {
+		e.phase.Store(phase)
+	}
+}
+
+func (e *inflightRequest) setTarget(target string) {
+	if e != nil {
+		e.target.Store(&target)
+	}
+}
+
+func (e *inflightRequest) setUpstreamDuration(d time.Duration) {
+	if e != nil {
+		e.upstream.Store(int64(d))
+	}
+}
+
+type inflightKey struct{}
+
+func inflightFrom(ctx context.Context) *inflightRequest {
+	e, _ := ctx.Value(inflightKey{}).(*inflightRequest)
+	return e
+}
+
+// inflightRegistry tracks the requests being served, so stuck ones can be
+// found and canceled. Adding and removing a request each take one map
+// operation; listing walks the map without blocking either.
+type inflightRegistry struct {
+	requests sync.Map // by id
+	nextID   atomic.Uint64
+}
+
+// add registers r on route, received at start, and returns it with a
+// context the registry can cancel. The caller must remove the entry once
+// the request is answered.
+func (reg *inflightRegistry) add(r *http.Request, route, clientIP string, start time.Time) (*http.Request, *inflightRequest) {
+	ctx, cancel := context.WithCancelCause(r.Context())
+	e := &inflightRequest{
+		id:        strconv.FormatUint(reg.nextID.Add(1), 10),
+		requestID: requestIDFrom(r.Context()),
+		route:     route,
+		method:    r.Method,
+		path:      r.URL.Path,
+		query:     r.URL.RawQuery,
+		clientIP:  clientIP,
+		start:     start,
+		cancel:    cancel,
+	}
+	reg.requests.Store(e.id, e)
+	return r.WithContext(context.WithValue(ctx, inflightKey{}, e)), e
+}
+
+func (reg *inflightRegistry) remove(e *inflightRequest) {
+	reg.requests.Delete(e.id)
+	e.cancel(nil)
+}
+
+// cancel aborts the request with id and returns it, or nil if it is no
+// longer in flight.
+func (reg *inflightRegistry) cancel(id string) *inflightRequest {
+	v, ok := reg.requests.Load(id)
+	if !ok {
+		return nil
+	}
+	e := v.(*inflightRequest)
+	e.cancel(errRequestCanceled)
+	return e
+}
+
+type inflightStatus struct {
+	ID        string    `json:"id"`
+	RequestID string    `json:"request_id"`
+	Route     string    `json:"route"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Query     string    `json:"query,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	Target    string    `json:"target,omitempty"`
+	Started   time.Time `json:"started"`
+	Age       float64   `json:"age_ms"`
+	Phase     string    `json:"phase"`
+}
+
+// list returns the requests on route (all routes when empty) in flight for
+// at least minAge, oldest first unless newest is set.
+func (reg *inflightRegistry) list(route string, minAge time.Duration, newest bool, limit int) []inflightStatus {
+	now := time.Now()
+	list := []inflightStatus{}
+	reg.requests.Range(func(_, v any) bool {
+		e := v.(*inflightRequest)
+		age := now.Sub(e.start)
+		if (route != "" && e.route != route) || age < minAge {
+			return true
+		}
+		s := inflightStatus{
+			ID:        e.id,
+			RequestID: e.requestID,
+			Route:     e.route,
+			Method:    e.method,
+			Path:      e.path,
+			Query:     redactQuery(e.query),
+			ClientIP:  e.clientIP,
+			Started:   e.start,
+			Age:       flightrecorder.Millis(age),
+			Phase:     inflightPhases[e.phase.Load()],
+		}
+		if t := e.target.Load(); t != nil {
+			s.Target = *t
+		}
+		list = append(list, s)
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		if newest {
+			return list[i].Started.After(list[j].Started)
+		}
+		return list[i].Started.Before(list[j].Started)
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// handleListInflight lists the requests being served. Query parameters:
+// route, min_age, sort (oldest, the default, or newest) and limit.
+func (p *Proxy) handleListInflight(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var minAge time.Duration
+	if v := q.Get("min_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid min_age", "")
+			return
+		}
+		minAge = d
+	}
+	var newest bool
+	switch q.Get("sort") {
+	case "", "oldest":
+	case "newest":
+		newest = true
+	default:
+		writeJSONError(w, http.StatusBadRequest, "sort must be oldest or newest", "")
+		return
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit", "")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"requests": p.inflight.list(q.Get("route"), minAge, newest, limit)})
+}
+
+// handleCancelInflight aborts one in-flight request. A request still
+// waiting for the upstream is answered with 503; one whose response has
+// started is cut off.
+func (p *Proxy) handleCancelInflight(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	e := p.inflight.cancel(id)
+	if e == nil {
+		writeJSONError(w, http.StatusNotFound, "request not in flight", "")
+		return
+	}
+	p.logger.Warn("in-flight request canceled",
+		"id", id, "request_id", e.requestID, "route", e.route, "path", e.path,
+		"age", time.Since(e.start), "by", p.clientIP(r))
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "request_id": e.requestID, "canceled": true})
+}
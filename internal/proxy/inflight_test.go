@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func listInflight(t *testing.T, p *Proxy, query string) []inflightStatus {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/inflight"+query, nil))
+	var resp struct {
+		Requests []inflightStatus `json:"requests"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return resp.Requests
+}
+
+// waitInflight polls the listing until it has n requests.
+func waitInflight(t *testing.T, p *Proxy, n int) []inflightStatus {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		list := listInflight(t, p, "")
+		if len(list) == n {
+			return list
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("in flight = %v, want %d requests", list, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAdmin_InflightListsAndCancelsStuckRequest(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	defer close(release)
+	p := newTestProxy(t, backend.URL, nil)
+
+	codes := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders/42?token=secret", nil))
+		codes <- rec.Code
+	}()
+	<-arrived
+
+	list := waitInflight(t, p, 1)
+	got := list[0]
+	if got.Route != "test" || got.Method != "GET" || got.Path != "/orders/42" || got.Query != "token=REDACTED" ||
+		got.Target != backend.URL || got.Phase != "awaiting_upstream_headers" || got.RequestID == "" {
+		t.Errorf("in-flight request = %+v", got)
+	}
+	if list := listInflight(t, p, "?route=other"); len(list) != 0 {
+		t.Errorf("route filter listed %v", list)
+	}
+	if list := listInflight(t, p, "?min_age=1h"); len(list) != 0 {
+		t.Errorf("min_age filter listed %v", list)
+	}
+
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/inflight/"+got.ID+"/cancel", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	select {
+	case code := <-codes:
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("canceled request status = %d, want 503", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("canceled request did not finish")
+	}
+	waitInflight(t, p, 0)
+
+	rec = httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/inflight/"+got.ID+"/cancel", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second cancel status = %d, want 404", rec.Code)
+	}
+}
+
+func TestAdmin_InflightSortsByAge(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	defer close(release)
+	p := newTestProxy(t, backend.URL, nil)
+
+	for _, path := range []string{"/first", "/second"} {
+		go p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		<-arrived
+	}
+	waitInflight(t, p, 2)
+
+	if list := listInflight(t, p, ""); list[0].Path != "/first" {
+		t.Errorf("default order = %s, %s, want oldest first", list[0].Path, list[1].Path)
+	}
+	if list := listInflight(t, p, "?sort=newest&limit=1"); len(list) != 1 || list[0].Path != "/second" {
+		t.Errorf("sort=newest&limit=1 = %v, want the second request", list)
+	}
+}
+
+func TestProxy_InflightRemovedOnClientDisconnect(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	defer close(release)
+	p := newTestProxy(t, backend.URL, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+		close(finished)
+	}()
+	<-arrived
+	waitInflight(t, p, 1)
+
+	cancel()
+	<-finished
+	if list := listInflight(t, p, ""); len(list) != 0 {
+		t.Errorf("disconnected request still listed: %v", list)
+	}
+}
+
+func TestProxy_InflightRemovedOnPanic(t *testing.T) {
+	p, _ := panickingProxy(t, 0)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	if list := listInflight(t, p, ""); len(list) != 0 {
+		t.Errorf("request that panicked still listed: %v", list)
+	}
+}
+
+func TestAdmin_InflightShowsQueuedRequests(t *testing.T) {
+	backend, arrived, release := blockingBackend(t)
+	defer close(release)
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].MaxConcurrent = 1
+		cfg.Routes[0].ConcurrencyQueue = &config.ConcurrencyQueue{Depth: 1, Timeout: 5 * time.Second}
+	})
+
+	go p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/first", nil))
+	<-arrived
+	go p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/second", nil))
+
+	list := waitInflight(t, p, 2)
+	for i := 0; list[1].Phase != "queued" && i < 400; i++ {
+		time.Sleep(5 * time.Millisecond)
+		list = listInflight(t, p, "")
+	}
+	if list[1].Phase != "queued" {
+		t.Errorf("second request phase = %s, want queued", list[1].Phase)
+	}
+	if list[0].Phase != "awaiting_upstream_headers" {
+		t.Errorf("first request phase = %s, want awaiting_upstream_headers", list[0].Phase)
+	}
+}
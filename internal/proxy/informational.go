@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+)
+
+// continueBody is the body of a request whose client expects 100 Continue.
+// It records whether anything has read it: net/http answers 100 Continue
+// itself on the first read, after which the upstream's is not needed.
+type continueBody struct {
+	io.ReadCloser
+	read atomic.Bool
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.ReadCloser.Read(p)
+}
+
+type continueKey struct{}
+
+// withExpectContinue wraps the body of r when its client waits for 100
+// Continue before sending it.
+func withExpectContinue(r *http.Request) *http.Request {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 || !r.ProtoAtLeast(1, 1) ||
+		!headerHasToken(r.Header, "Expect", "100-continue") {
+		return r
+	}
+	b := &continueBody{ReadCloser: r.Body}
+	r = r.WithContext(context.WithValue(r.Context(), continueKey{}, b))
+	r.Body = b
+	return r
+}
+
+// awaitsContinue reports whether the client of r still waits for 100
+// Continue: it asked for one and no stage has read the body yet. Only then
+// is the expectation passed upstream, so the upstream decides whether the
+// body is sent at all.
+func awaitsContinue(r *http.Request) bool {
+	b, _ := r.Context().Value(continueKey{}).(*continueBody)
+	return b != nil && !b.read.Load()
+}
+
+// relayInformational forwards the informational responses the upstream
+// sends before its final one, such as 100 Continue and 103 Early Hints, to
+// the client. stop must be called once the final response has arrived;
+// informational responses after it are dropped.
+func relayInformational(w http.ResponseWriter, upstreamReq *http.Request) (req *http.Request, stop func()) {
+	var mu sync.Mutex
+	stopped := false
+	relay := func(code int, h http.Header) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		// The 1xx carries the upstream's headers only; the ones already
+		// set for the final response are put back.
+		header := w.Header()
+		saved := header.Clone()
+		clear(header)
+		copyEndToEndHeaders(header, h)
+		w.WriteHeader(code)
+		clear(header)
+		copyHeaders(header, saved)
+	}
+	trace := &httptrace.ClientTrace{
+		// The transport sends the body once this returns, so the 100 goes
+		// out before net/http would answer one of its own.
+		Got100Continue: func() {
+			relay(http.StatusContinue, nil)
+		},
+		Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+			if code != http.StatusContinue {
+				relay(code, http.Header(h))
+			}
+			return nil
+		},
+	}
+	req = upstreamReq.WithContext(httptrace.WithClientTrace(upstreamReq.Context(), trace))
+	return req, func() {
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendExpectContinue posts body the way curl does for large uploads: it
+// sends the head with Expect: 100-continue and holds the body back until
+// the gateway answers 100. It returns the status lines received, the
+// interim ones first.
+func sendExpectContinue(t *testing.T, addr, body string) []string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: gateway\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", len(body))
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	var statuses []string
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			t.Fatalf("read status: %v (got %q)", err, statuses)
+		}
+		if _, err := tp.ReadMIMEHeader(); err != nil {
+			t.Fatalf("read header: %v", err)
+		}
+		statuses = append(statuses, line)
+		if !strings.HasPrefix(line, "HTTP/1.1 1") {
+			return statuses
+		}
+		if line == "HTTP/1.1 100 Continue" {
+			_, _ = io.WriteString(conn, body)
+		}
+	}
+}
+
+func TestProxy_ExpectContinue(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Reject") != "" {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "got %s", data)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	statuses := sendExpectContinue(t, gw.Listener.Addr().String(), "hello")
+	if len(statuses) != 2 || statuses[0] != "HTTP/1.1 100 Continue" || statuses[1] != "HTTP/1.1 200 OK" {
+		t.Errorf("statuses = %q, want a single 100 Continue, then 200", statuses)
+	}
+}
+
+func TestProxy_ExpectContinueUpstreamRejects(t *testing.T) {
+	bodySent := make(chan bool, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer without reading the body, so no 100 is sent.
+		bodySent <- r.Header.Get("Expect") == ""
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	statuses := sendExpectContinue(t, gw.Listener.Addr().String(), "too large")
+	if len(statuses) != 1 || statuses[0] != "HTTP/1.1 413 Request Entity Too Large" {
+		t.Errorf("statuses = %q, want 413 without 100 Continue", statuses)
+	}
+	if <-bodySent {
+		t.Error("the expectation was not passed upstream")
+	}
+}
+
+func TestProxy_EarlyHints(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = io.WriteString(w, "<html>")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	var hints []string
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, h textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprintf("%d %s", code, h.Get("Link")))
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", gw.URL+"/page", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(hints) != 1 || hints[0] != "103 </app.css>; rel=preload; as=style" {
+		t.Errorf("informational responses = %q, want the 103 with its Link", hints)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(requestIDHeader) == "" {
+		t.Errorf("final response: status %d, headers %v, want 200 with the gateway's headers", resp.StatusCode, resp.Header)
+	}
+}
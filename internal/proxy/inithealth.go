@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+)
+
+const defaultInitialHealthTimeout = 30 * time.Second
+
+// Upstream states reported by the admin API.
+const (
+	upstreamAwaitingInitialCheck = "awaiting_initial_check"
+	upstreamReady                = "ready"
+)
+
+// setupInitialHealth marks the upstreams that wait for their first health
+// check cycle and bounds the wait with server.initial_health_timeout.
+func (p *Proxy) setupInitialHealth() {
+	p.awaitingHealth = make(map[string]*atomic.Bool)
+	for _, u := range p.config.Upstreams {
+		if !p.config.WaitsForInitialHealth(u) || p.disabledUpstreams[u.Name] != nil {
+			continue
+		}
+		awaiting := &atomic.Bool{}
+		awaiting.Store(true)
+		p.awaitingHealth[u.Name] = awaiting
+		p.metrics.RecordAwaitingInitialCheck(u.Name, true)
+	}
+	if len(p.awaitingHealth) == 0 {
+		return
+	}
+
+	timeout := p.config.Server.InitialHealthTimeout
+	if timeout <= 0 {
+		timeout = defaultInitialHealthTimeout
+	}
+	p.initialHealthTimer = time.AfterFunc(timeout, func() {
+		for name, awaiting := range p.awaitingHealth {
+			if awaiting.CompareAndSwap(true, false) {
+				p.metrics.RecordAwaitingInitialCheck(name, false)
+				p.logger.Warn("initial health check did not complete in time", "upstream", name, "timeout", timeout)
+			}
+		}
+	})
+}
+
+// InitialHealthChecked records that the first health check cycle of
+// upstream has completed. It is the health checker's initial check hook.
+func (p *Proxy) InitialHealthChecked(upstream string) {
+	if awaiting, ok := p.awaitingHealth[upstream]; ok && awaiting.CompareAndSwap(true, false) {
+		p.metrics.RecordAwaitingInitialCheck(upstream, false)
+		p.logger.Info("initial health check completed", "upstream", upstream)
+	}
+}
+
+// InitialHealthReady reports whether no upstream is still awaiting its
+// first health check cycle.
+func (p *Proxy) InitialHealthReady() bool {
+	for _, awaiting := range p.awaitingHealth {
+		if awaiting.Load() {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Proxy) awaitingInitialHealth(upstream string) bool {
+	awaiting, ok := p.awaitingHealth[upstream]
+	return ok && awaiting.Load()
+}
+
+type upstreamStatus struct {
+	Name    string         `json:"name"`
+	Owner   string         `json:"owner,omitempty"`
+	State   string         `json:"state"`
+	Error   string         `json:"error,omitempty"` // why a disabled upstream could not be built
+	Targets []targetStatus `json:"targets"`
+}
+
+type targetStatus struct {
+	URL       string `json:"url"`
+	Healthy   bool   `json:"healthy"`
+	Available bool   `json:"available"`
+	Standby   bool   `json:"standby,omitempty"`
+
+	// ExpectedRTT is the configured round trip, or "auto"; MeasuredRTT the
+	// average health check latency, once there is one.
+	ExpectedRTT string  `json:"expected_rtt,omitempty"`
+	MeasuredRTT float64 `json:"measured_rtt_ms,omitempty"`
+	RTTClass    string  `json:"rtt_class,omitempty"`
+}
+
+func expectedRTT(t *loadbalancer.Target) string {
+	switch {
+	case t.AutoRTT:
+		return "auto"
+	case t.ExpectedRTT > 0:
+		return t.ExpectedRTT.String()
+	}
+	return ""
+}
+
+// handleUpstreams lists the upstreams with their state and targets.
+func (p *Proxy) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	list := make([]upstreamStatus, 0, len(p.upstreams))
+	for name, lb := range p.upstreams {
+		status := upstreamStatus{Name: name, Owner: p.upstreamOwner(name), State: upstreamReady, Targets: []targetStatus{}}
+		if p.awaitingInitialHealth(name) {
+			status.State = upstreamAwaitingInitialCheck
+		}
+		for _, t := range lb.Targets() {
+			status.Targets = append(status.Targets, targetStatus{
+				URL:         t.URL.String(),
+				Healthy:     t.Healthy.Load(),
+				Available:   t.Available(),
+				Standby:     t.Standby,
+				ExpectedRTT: expectedRTT(t),
+				MeasuredRTT: flightrecorder.Millis(t.MeasuredRTT()),
+				RTTClass:    t.RTTClass(),
+			})
+		}
+		list = append(list, status)
+	}
+	for _, u := range p.config.Upstreams {
+		if err := p.disabledUpstreams[u.Name]; err != nil {
+			list = append(list, p.disabledStatus(u, err))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, http.StatusOK, map[string]any{"upstreams": list})
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/health"
+)
+
+func TestProxy_WaitForInitialHealth(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			<-release // a slow first probe
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	var once sync.Once
+	finishProbe := func() { once.Do(func() { close(release) }) }
+	defer finishProbe()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.WaitForInitialHealth = true
+		cfg.Server.InitialHealthReject = true
+		cfg.Upstreams[0].HealthCheck = &config.HealthCheck{Path: "/healthz", Interval: time.Hour}
+	})
+	checks := map[string]*config.HealthCheck{"backend": {Path: "/healthz", Interval: time.Hour}}
+	checker := health.NewChecker(p.Upstreams(), checks, p.Metrics(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	checker.SetInitialCheck(p.InitialHealthChecked)
+	checker.Start()
+	defer checker.Stop()
+
+	if p.InitialHealthReady() {
+		t.Fatal("ready before the first health check completed")
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "not health checked yet") {
+		t.Fatalf("before first check: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("rejection has no Retry-After")
+	}
+	if state := upstreamState(t, p); state != "awaiting_initial_check" {
+		t.Errorf("upstream state = %q, want awaiting_initial_check", state)
+	}
+
+	finishProbe()
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.InitialHealthReady() {
+		if time.Now().After(deadline) {
+			t.Fatal("not ready after the first health check completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after first check: status = %d", rec.Code)
+	}
+	if state := upstreamState(t, p); state != "ready" {
+		t.Errorf("upstream state = %q, want ready", state)
+	}
+	rec = httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gateway_upstream_awaiting_initial_check{upstream="backend"} 0`) {
+		t.Errorf("awaiting gauge not cleared:\n%s", rec.Body.String())
+	}
+}
+
+func TestProxy_InitialHealthTimeout(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.Server.InitialHealthTimeout = 50 * time.Millisecond
+		yes := true
+		cfg.Upstreams[0].WaitForInitialHealth = &yes
+		cfg.Upstreams[0].HealthCheck = &config.HealthCheck{Path: "/healthz"}
+	})
+	if p.InitialHealthReady() {
+		t.Fatal("ready before the first health check completed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.InitialHealthReady() {
+		if time.Now().After(deadline) {
+			t.Fatal("still waiting after initial_health_timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func upstreamState(t *testing.T, p *Proxy) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/upstreams", nil))
+	var resp struct {
+		Upstreams []upstreamStatus `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Upstreams) != 1 {
+		t.Fatalf("GET /admin/upstreams: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	return resp.Upstreams[0].State
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// ipAllowed applies an allow and a deny list to a client address. A deny
+// match wins; a non-empty allow list rejects addresses outside it. A
+// client whose address cannot be parsed only passes when both lists are
+// empty.
+func ipAllowed(addr netip.Addr, allow, deny []netip.Prefix) bool {
+	if len(allow) == 0 && len(deny) == 0 {
+		return true
+	}
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClientIP applies server.allow_ips/deny_ips and then the route's own
+// lists to the client IP, answering 403 on denial.
+func (p *Proxy) checkClientIP(w http.ResponseWriter, req *pipelineRequest) bool {
+	route := req.route
+	if len(p.allowIPs) == 0 && len(p.denyIPs) == 0 && len(route.AllowIPs) == 0 && len(route.DenyIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(req.clientIP)
+	if err == nil {
+		addr = addr.Unmap()
+	}
+	if ipAllowed(addr, p.allowIPs, p.denyIPs) && ipAllowed(addr, route.AllowIPs, route.DenyIPs) {
+		return true
+	}
+	p.metrics.RecordError(req.routeName, "ip_denied")
+	p.writeError(w, http.StatusForbidden, "ip_denied", "forbidden")
+	return false
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_IPFilter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.DenyIPs = []string{"198.51.100.7"}
+		cfg.Server.TrustedProxies = []string{"203.0.113.20"}
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.DefaultRPS = 1
+		cfg.RateLimit.DefaultBurst = 1
+		cfg.Routes = []config.Route{
+			{
+				Name:     "admin",
+				Path:     "/admin/**",
+				Upstream: "backend",
+				AllowIPs: []string{"203.0.113.0/24", "2001:db8:cafe::/48"},
+				DenyIPs:  []string{"203.0.113.66/32"},
+			},
+			{Name: "public", Path: "/**", Upstream: "backend"},
+		}
+	})
+
+	tests := []struct {
+		name, path, remote string
+		want               int
+	}{
+		{"office v4", "/admin/users", "203.0.113.10:1234", http.StatusOK},
+		{"office v6", "/admin/users", "[2001:db8:cafe:1::5]:1234", http.StatusOK},
+		{"v4-mapped v6", "/admin/users", "[::ffff:203.0.113.11]:1234", http.StatusOK},
+		{"outside allow list", "/admin/users", "192.0.2.1:1234", http.StatusForbidden},
+		{"outside v6 allow list", "/admin/users", "[2001:db8:beef::1]:1234", http.StatusForbidden},
+		{"deny wins over allow", "/admin/users", "203.0.113.66:1234", http.StatusForbidden},
+		{"global deny", "/public", "198.51.100.7:1234", http.StatusForbidden},
+		{"public", "/public", "192.0.2.1:1234", http.StatusOK},
+		// The rejection above must not have used up the office client's token.
+		{"denied before rate limiting", "/admin/users", "203.0.113.12:1234", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.remote
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	// Behind a trusted proxy, X-Forwarded-For is the client IP the lists
+	// are matched against.
+	req := httptest.NewRequest("GET", "/admin/users", nil)
+	req.RemoteAddr = "203.0.113.20:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.9, 203.0.113.20")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("forwarded client outside allow list: status = %d", rec.Code)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_errors_total{key="admin_ip_denied"} 4`,
+		`gateway_errors_total{key="public_ip_denied"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
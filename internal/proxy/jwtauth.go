@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/jwt"
+)
+
+const (
+	defaultJWKSRefreshInterval = 5 * time.Minute
+	jwksFetchTimeout           = 10 * time.Second
+	// jwksRetryInterval spaces out fetches while the key set cannot be
+	// fetched, so requests do not hammer the identity provider.
+	jwksRetryInterval = time.Second
+	// jwtLeeway allows for clock skew between the gateway and the token
+	// issuer when checking exp and nbf.
+	jwtLeeway = 30 * time.Second
+)
+
+// jwks fetches and caches the key set of auth.jwt. At most one fetch runs
+// at a time; requests arriving once the keys are due for a refresh wait
+// for it.
+type jwks struct {
+	cfg    *config.JWTAuth
+	client *http.Client
+	policy *errorPolicy
+	now    func() time.Time
+
+	mu       sync.Mutex
+	keys     *jwt.KeySet // nil until a fetch succeeds
+	fetched  time.Time
+	fetching chan struct{} // closed when the fetch in progress ends, nil if none
+	lastErr  error         // of the last fetch, nil once one succeeds
+	retryAt  time.Time     // no new fetch before this after a failure
+}
+
+// setupJWT creates the key set cache when auth.jwt is configured.
+func (p *Proxy) setupJWT() {
+	cfg := p.config.Auth.JWT
+	if cfg == nil {
+		return
+	}
+	p.jwks = &jwks{
+		cfg:    cfg,
+		client: p.httpClient,
+		policy: newErrorPolicy("auth.jwt", cfg.OnError, p.metrics, p.logger),
+		now:    time.Now,
+	}
+}
+
+// get returns the cached keys, fetching them first when there are none or
+// they are older than refresh_interval. Along with the keys fetched
+// before, if any, it returns the error of the last fetch while fetches
+// fail.
+func (k *jwks) get(ctx context.Context) (*jwt.KeySet, error) {
+	interval := k.cfg.RefreshInterval
+	if interval == 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+
+	k.mu.Lock()
+	now := k.now()
+	var done chan struct{}
+	if k.keys == nil || now.Sub(k.fetched) >= interval {
+		done = k.startFetch(now)
+	}
+	k.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keys, k.lastErr
+}
+
+// startFetch starts a fetch unless one is running or the last failure was
+// too recent, and returns the channel closed when the running fetch ends,
+// or nil if none runs. k.mu must be held.
+func (k *jwks) startFetch(now time.Time) chan struct{} {
+	if k.fetching == nil && !now.Before(k.retryAt) {
+		k.fetching = make(chan struct{})
+		go k.fetch(k.fetching)
+	}
+	return k.fetching
+}
+
+func (k *jwks) fetch(done chan struct{}) {
+	keys, err := k.request()
+	k.policy.observe(err)
+
+	k.mu.Lock()
+	now := k.now()
+	if err != nil {
+		k.lastErr = err
+		k.retryAt = now.Add(jwksRetryInterval)
+	} else {
+		k.keys, k.fetched, k.lastErr = keys, now, nil
+	}
+	k.fetching = nil
+	k.mu.Unlock()
+	close(done)
+}
+
+func (k *jwks) request() (*jwt.KeySet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.cfg.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("jwks request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint answered %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("jwks response: %w", err)
+	}
+	return jwt.ParseKeySet(data)
+}
+
+// checkJWT answers 401 unless the request carries a bearer token signed
+// with a key of the auth.jwt key set, unexpired and, when configured,
+// issued by its issuer for its audience. While the key set cannot be
+// fetched it follows auth.jwt.on_error, which for degrade verifies tokens
+// with the keys fetched before.
+func (p *Proxy) checkJWT(w http.ResponseWriter, req *pipelineRequest) bool {
+	if p.jwks == nil {
+		p.jwtUnavailable(w, req.routeName)
+		return false
+	}
+	keys, err := p.jwks.get(req.r.Context())
+	if err != nil {
+		switch p.jwks.policy.action {
+		case config.OnErrorAllow:
+			return true
+		case config.OnErrorDeny:
+			p.jwtUnavailable(w, req.routeName)
+			return false
+		}
+	}
+	if keys == nil {
+		p.jwtUnavailable(w, req.routeName)
+		return false
+	}
+
+	token, ok := bearerToken(req.r)
+	if !ok {
+		p.metrics.RecordError(req.routeName, "auth_failed")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="relaypoint"`)
+		p.writeError(w, http.StatusUnauthorized, "auth_failed", "missing bearer token")
+		return false
+	}
+	claims, err := keys.Verify(token)
+	if err == nil {
+		err = claims.Validate(time.Now(), p.jwks.cfg.Issuer, p.jwks.cfg.Audience, jwtLeeway)
+	}
+	if err != nil {
+		p.metrics.RecordError(req.routeName, "auth_failed")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="relaypoint", error="invalid_token"`)
+		p.writeError(w, http.StatusUnauthorized, "auth_failed", "invalid token")
+		return false
+	}
+	return true
+}
+
+func (p *Proxy) jwtUnavailable(w http.ResponseWriter, routeName string) {
+	p.metrics.RecordError(routeName, "auth_unavailable")
+	p.writeError(w, http.StatusServiceUnavailable, "auth_unavailable", "authentication unavailable")
+}
+
+// bearerToken returns the token of r's Authorization header, if it has the
+// Bearer scheme, which is case-insensitive (RFC 9110, section 11.1).
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// jwksServer serves the key set of one P-256 key, or 503 while failing is
+// set.
+type jwksServer struct {
+	*httptest.Server
+	key     *ecdsa.PrivateKey
+	failing atomic.Bool
+	fetches atomic.Int64
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := pub.Bytes() // 0x04 || x || y
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "EC", "crv": "P-256", "kid": "k1", "use": "sig",
+		"x": base64.RawURLEncoding.EncodeToString(point[1:33]),
+		"y": base64.RawURLEncoding.EncodeToString(point[33:]),
+	}}})
+
+	s := &jwksServer{key: key}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(jwks)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// token returns an ES256 token with claims signed by the server's key.
+func (s *jwksServer) token(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := enc(map[string]string{"alg": "ES256", "kid": "k1", "typ": "JWT"}) + "." + enc(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, sv, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	sv.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// validClaims are accepted by the auth.jwt of newJWTProxy.
+func validClaims() map[string]any {
+	return map[string]any{"iss": "https://idp", "aud": "api", "sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func newJWTProxy(t *testing.T, jwks *jwksServer, onError string) *Proxy {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(backend.Close)
+	return newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Auth.JWT = &config.JWTAuth{
+			JWKSURL:         jwks.URL,
+			Issuer:          "https://idp",
+			Audience:        "api",
+			RefreshInterval: time.Minute,
+			OnError:         onError,
+		}
+		cfg.Routes[0].RequireJWT = true
+	})
+}
+
+// getWithToken serves a request with token as its bearer token, if any.
+func getWithToken(p *Proxy, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestProxy_JWT(t *testing.T) {
+	jwks := newJWKSServer(t)
+	p := newJWTProxy(t, jwks, "")
+
+	claims := func(mutate func(c map[string]any)) map[string]any {
+		c := validClaims()
+		mutate(c)
+		return c
+	}
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"valid", jwks.token(t, validClaims()), http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"garbage", "not-a-token", http.StatusUnauthorized},
+		{"expired", jwks.token(t, claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), http.StatusUnauthorized},
+		{"other issuer", jwks.token(t, claims(func(c map[string]any) { c["iss"] = "https://elsewhere" })), http.StatusUnauthorized},
+		{"other audience", jwks.token(t, claims(func(c map[string]any) { c["aud"] = []string{"billing"} })), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		rec := getWithToken(p, tt.token)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+	if n := jwks.fetches.Load(); n != 1 {
+		t.Errorf("key set fetched %d times, want once", n)
+	}
+
+	// Keys older than refresh_interval are fetched again.
+	p.jwks.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if rec := getWithToken(p, jwks.token(t, validClaims())); rec.Code != http.StatusOK {
+		t.Errorf("after refresh: status = %d", rec.Code)
+	}
+	if n := jwks.fetches.Load(); n != 2 {
+		t.Errorf("key set fetched %d times, want twice", n)
+	}
+}
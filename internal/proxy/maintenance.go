@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+const defaultMaintenanceStatus = http.StatusServiceUnavailable
+
+// maintenanceMode is the static response of a route in maintenance. It is
+// not modified once published.
+type maintenanceMode struct {
+	Status      int    `json:"status"`
+	Body        string `json:"body,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	RetryAfter  int    `json:"retry_after,omitempty"`
+}
+
+func newMaintenanceMode(m *config.Maintenance) *maintenanceMode {
+	if m == nil || !m.Enabled {
+		return nil
+	}
+	mode := &maintenanceMode{Status: m.Status, Body: m.Body, ContentType: m.ContentType, RetryAfter: m.RetryAfter}
+	mode.setDefaults()
+	return mode
+}
+
+func (m *maintenanceMode) setDefaults() {
+	if m.Status == 0 {
+		m.Status = defaultMaintenanceStatus
+	}
+	if m.Body != "" && m.ContentType == "" {
+		m.ContentType = "text/plain; charset=utf-8"
+	}
+}
+
+func (m *maintenanceMode) validate() error {
+	if m.Status < 400 || m.Status > 599 {
+		return errors.New("status must be between 400 and 599")
+	}
+	if m.RetryAfter < 0 {
+		return errors.New("retry_after cannot be negative")
+	}
+	return nil
+}
+
+// setupMaintenance puts the routes whose maintenance block is enabled into
+// maintenance. The slots are fixed after New; only their contents change.
+func (p *Proxy) setupMaintenance() {
+	p.maintenance = make(map[string]*atomic.Pointer[maintenanceMode])
+	for _, r := range p.config.Routes {
+		slot := &atomic.Pointer[maintenanceMode]{}
+		p.maintenance[configRouteName(r)] = slot
+		if mode := newMaintenanceMode(r.Maintenance); mode != nil {
+			slot.Store(mode)
+			p.metrics.SetRouteMaintenance(configRouteName(r), true)
+		}
+	}
+}
+
+// configRouteName is the name a configured route is reported under, the
+// same as routeNameOf for the route built from it.
+func configRouteName(r config.Route) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Path
+}
+
+// setMaintenance switches a route in or out of maintenance; mode nil takes
+// it out. It reports whether anything changed.
+func (p *Proxy) setMaintenance(route string, mode *maintenanceMode, by string) bool {
+	old := p.maintenance[route].Swap(mode)
+	if old == nil && mode == nil {
+		return false
+	}
+	if old != nil && mode != nil && *old == *mode {
+		return false
+	}
+
+	p.metrics.SetRouteMaintenance(route, mode != nil)
+	data := map[string]any{"route": route, "by": by}
+	if owner := p.routeOwner(route); owner != "" {
+		data["owner"] = owner
+	}
+	if mode != nil {
+		data["action"] = "started"
+		data["status"] = mode.Status
+		p.logger.Warn("route maintenance started", "route", route, "status", mode.Status, "by", by)
+	} else {
+		data["action"] = "ended"
+		p.logger.Warn("route maintenance ended", "route", route, "by", by)
+	}
+	p.events.Publish(events.RouteMaintenance, data)
+	return true
+}
+
+// SetMaintenance applies the maintenance blocks of a reloaded configuration.
+// Routes are matched by name; routes that are not running are ignored, and
+// running routes without an enabled block leave maintenance.
+func (p *Proxy) SetMaintenance(routes []config.Route) {
+	for _, r := range routes {
+		name := configRouteName(r)
+		if _, ok := p.maintenance[name]; ok {
+			p.setMaintenance(name, newMaintenanceMode(r.Maintenance), "config_reload")
+		}
+	}
+}
+
+// serveMaintenance answers the request with the route's static response if
+// the route is in maintenance.
+func (p *Proxy) serveMaintenance(req *pipelineRequest) bool {
+	slot := p.maintenance[req.routeName]
+	if slot == nil {
+		return false
+	}
+	mode := slot.Load()
+	if mode == nil {
+		return false
+	}
+
+	p.metrics.RecordMaintenanceResponse(req.routeName)
+	if mode.RetryAfter > 0 {
+		req.w.Header().Set("Retry-After", strconv.Itoa(mode.RetryAfter))
+	}
+	if mode.Body == "" {
+		p.writeError(req.w, mode.Status, "maintenance", "route under maintenance")
+		return true
+	}
+	req.w.Header().Set("Content-Type", mode.ContentType)
+	req.w.Header().Set("Content-Length", strconv.Itoa(len(mode.Body)))
+	req.w.WriteHeader(mode.Status)
+	if req.r.Method != http.MethodHead {
+		_, _ = io.WriteString(req.w, mode.Body)
+	}
+	return true
+}
+
+// handleStartMaintenance puts a route into maintenance. The optional JSON
+// body sets status, body, content_type and retry_after.
+func (p *Proxy) handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("name")
+	if _, ok := p.maintenance[route]; !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	var mode maintenanceMode
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&mode); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, http.StatusBadRequest, "invalid maintenance settings: "+err.Error(), "")
+		return
+	}
+	mode.setDefaults()
+	if err := mode.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+
+	p.setMaintenance(route, &mode, p.clientIP(r))
+	writeJSON(w, http.StatusOK, map[string]any{"route": route, "maintenance": mode})
+}
+
+// handleStopMaintenance takes a route out of maintenance.
+func (p *Proxy) handleStopMaintenance(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("name")
+	if _, ok := p.maintenance[route]; !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	if !p.setMaintenance(route, nil, p.clientIP(r)) {
+		writeJSONError(w, http.StatusNotFound, "route is not in maintenance", "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListMaintenance lists the routes in maintenance.
+func (p *Proxy) handleListMaintenance(w http.ResponseWriter, r *http.Request) {
+	routes := make(map[string]*maintenanceMode)
+	for name, slot := range p.maintenance {
+		if mode := slot.Load(); mode != nil {
+			routes[name] = mode
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"routes": routes})
+}
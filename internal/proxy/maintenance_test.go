@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+func TestProxy_Maintenance(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "orders", Path: "/orders/**", Upstream: "backend", Maintenance: &config.Maintenance{
+				Enabled: true, Body: "back soon", RetryAfter: 120,
+			}},
+			{Name: "users", Path: "/users/**", Upstream: "backend"},
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders/1", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "back soon" {
+		t.Fatalf("maintenance response = %d %q, want 503 %q", rec.Code, rec.Body.String(), "back soon")
+	}
+	if got := rec.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Retry-After = %q, want 120", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/users/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("other route: status = %d, want 200", rec.Code)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("backend hits = %d, want only the other route's", hits.Load())
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_maintenance_responses_total{route="orders"} 1`,
+		`gateway_route_maintenance{route="orders"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+
+	// A reload without the block ends maintenance.
+	p.SetMaintenance([]config.Route{{Name: "orders", Path: "/orders/**", Upstream: "backend"}})
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders/1", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("after reload: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestProxy_MaintenanceAdmin(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, nil)
+	sub := p.Events().Subscribe([]string{events.RouteMaintenance}, 8)
+	defer p.Events().Unsubscribe(sub)
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/x", nil))
+		return rec
+	}
+
+	if rec := admin("PUT", "/admin/routes/test/maintenance", `{"status": 200}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with status 200: status = %d, want 400", rec.Code)
+	}
+	if rec := admin("PUT", "/admin/routes/missing/maintenance", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("PUT unknown route: status = %d, want 404", rec.Code)
+	}
+
+	if rec := admin("PUT", "/admin/routes/test/maintenance", ""); rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	rec := get()
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("during maintenance: %d %q, want the 503 error envelope", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "" {
+		t.Errorf("Retry-After set without retry_after")
+	}
+
+	list := admin("GET", "/admin/maintenance", "")
+	if !strings.Contains(list.Body.String(), `"test":{"status":503}`) {
+		t.Errorf("GET /admin/maintenance = %s", list.Body.String())
+	}
+
+	if rec := admin("DELETE", "/admin/routes/test/maintenance", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: status = %d", rec.Code)
+	}
+	if rec := admin("DELETE", "/admin/routes/test/maintenance", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: status = %d, want 404", rec.Code)
+	}
+	if rec := get(); rec.Code != http.StatusOK {
+		t.Errorf("after DELETE: status = %d, want 200", rec.Code)
+	}
+
+	for _, action := range []string{"started", "ended"} {
+		ev := <-sub.C
+		if ev.Data["action"] != action {
+			t.Errorf("event = %+v, want action %s", ev, action)
+		}
+	}
+}
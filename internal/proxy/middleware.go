@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// Middleware wraps the handling of routed requests with custom logic, for
+// programs that embed the gateway. A middleware may answer the request
+// itself instead of calling next.
+type Middleware func(next http.Handler) http.Handler
+
+// Use appends middlewares to the chain requests pass through after they
+// are routed, client IP filtering and API key authentication, and before
+// the route's pipeline. They run in the order added. The matched route is
+// available through router.RouteFromContext.
+//
+// Use must be called before the proxy serves requests.
+func (p *Proxy) Use(mw ...Middleware) {
+	p.middlewares = append(p.middlewares, mw...)
+	var h http.Handler = http.HandlerFunc(p.servePipeline)
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		h = p.middlewares[i](h)
+	}
+	p.chain = h
+}
+
+type pipelineRequestKey struct{}
+
+// runChain passes req through the middleware chain, which ends in the
+// route's pipeline.
+func (p *Proxy) runChain(req *pipelineRequest) {
+	if p.chain == nil {
+		p.runPipeline(req, routePipeline(req.route))
+		return
+	}
+	ctx := context.WithValue(req.r.Context(), pipelineRequestKey{}, req)
+	p.chain.ServeHTTP(req.w, req.r.WithContext(ctx))
+}
+
+// servePipeline is the end of the middleware chain. Middlewares may have
+// replaced the request, e.g. to add context values, or wrapped the writer.
+func (p *Proxy) servePipeline(w http.ResponseWriter, r *http.Request) {
+	req := r.Context().Value(pipelineRequestKey{}).(*pipelineRequest)
+	req.r = r
+	if w != http.ResponseWriter(req.w) {
+		// Stages need a statusWriter; the status also reaches the outer
+		// one through the middleware's writer, unless a stage sets it
+		// without writing, as for hijacked connections.
+		outer := req.w
+		req.w = &statusWriter{ResponseWriter: w}
+		defer func() {
+			if outer.status == 0 {
+				outer.status = req.w.status
+			}
+			req.w = outer
+		}()
+	}
+	p.runPipeline(req, routePipeline(req.route))
+}
+
+// routePipeline returns the stages route's requests pass through.
+func routePipeline(route *router.Route) []string {
+	if len(route.Pipeline) == 0 {
+		return config.DefaultPipeline
+	}
+	return route.Pipeline
+}
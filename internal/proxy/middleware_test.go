@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// statusRecorder is what a middleware might wrap the writer in.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func TestProxy_Middleware(t *testing.T) {
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("X-Tenant-Seen", r.Header.Get("X-Tenant"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{{Name: "tenants", Path: "/tenants/:tenant/**", Upstream: "backend"}}
+	})
+
+	var order []string
+	var statuses []int
+	p.Use(
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, "tenant")
+				route := router.RouteFromContext(r.Context())
+				if route == nil || route.Name != "tenants" {
+					t.Errorf("route = %+v, want tenants", route)
+					return
+				}
+				tenant := route.PathParams["tenant"]
+				if tenant == "suspended" {
+					http.Error(w, "tenant suspended", http.StatusForbidden)
+					return
+				}
+				r.Header.Set("X-Tenant", tenant)
+				next.ServeHTTP(w, r)
+			})
+		},
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, "status")
+				rec := &statusRecorder{ResponseWriter: w}
+				next.ServeHTTP(rec, r)
+				statuses = append(statuses, rec.status)
+			})
+		},
+	)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/tenants/acme/orders", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Tenant-Seen") != "acme" {
+		t.Errorf("acme: status = %d, X-Tenant-Seen = %q", rec.Code, rec.Header().Get("X-Tenant-Seen"))
+	}
+	if strings.Join(order, ",") != "tenant,status" || len(statuses) != 1 || statuses[0] != http.StatusOK {
+		t.Errorf("order = %v, statuses = %v", order, statuses)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/tenants/suspended/orders", nil))
+	if rec.Code != http.StatusForbidden || hits.Load() != 1 {
+		t.Errorf("suspended: status = %d after %d upstream hits, want 403 after 1", rec.Code, hits.Load())
+	}
+
+	// Requests that are not routed never reach the chain.
+	order = nil
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/elsewhere", nil))
+	if rec.Code != http.StatusNotFound || len(order) != 0 {
+		t.Errorf("unrouted: status = %d, middlewares run = %v", rec.Code, order)
+	}
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const (
+	defaultMirrorConcurrency = 64
+
+	// maxMirrorBodySize bounds how much of a request body is held in memory
+	// to replay it to the mirror. Larger requests are not mirrored.
+	maxMirrorBodySize = 1 << 20
+
+	mirrorTimeout = 30 * time.Second
+)
+
+// mirror holds the sampling and concurrency state for one route's shadow
+// upstream.
+type mirror struct {
+	upstream string
+	percent  float64
+	slots    chan struct{}
+}
+
+func newMirror(cfg *config.Mirror) *mirror {
+	percent := cfg.Percent
+	if percent == 0 {
+		percent = 100
+	}
+	concurrency := cfg.MaxConcurrent
+	if concurrency <= 0 {
+		concurrency = defaultMirrorConcurrency
+	}
+	return &mirror{
+		upstream: cfg.Upstream,
+		percent:  percent,
+		slots:    make(chan struct{}, concurrency),
+	}
+}
+
+func (m *mirror) sampled() bool {
+	return m.percent >= 100 || rand.Float64()*100 < m.percent
+}
+
+// mirrorRequest fires a copy of r at the route's mirror upstream in the
+// background. The client's request is left intact for the primary. It
+// returns false if it already answered the request.
+func (p *Proxy) mirrorRequest(w http.ResponseWriter, r *http.Request, route *router.Route, routeName string) bool {
+	m, ok := p.mirrors[route.Mirror]
+	if !ok || isWebSocketRequest(r) || !m.sampled() {
+		return true
+	}
+
+	data, complete, err := bufferBody(r, maxMirrorBodySize)
+	if err != nil {
+		p.writeBodyError(w, routeName, err)
+		return false
+	}
+	if !complete {
+		p.metrics.RecordMirrorDropped(routeName, "body_too_large")
+		return true
+	}
+
+	lb, ok := p.upstreams[m.upstream]
+	if !ok {
+		p.metrics.RecordMirrorDropped(routeName, "upstream_not_found")
+		return true
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		p.metrics.RecordMirrorDropped(routeName, "concurrency")
+		return true
+	}
+
+	target := lb.Next()
+	if target == nil {
+		<-m.slots
+		p.metrics.RecordMirrorDropped(routeName, "no_healthy_upstream")
+		return true
+	}
+
+	// The shadow request must outlive the client's.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), mirrorTimeout)
+	shadow := r.Clone(ctx)
+	shadow.Body = http.NoBody
+	if len(data) > 0 {
+		shadow.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	shadow.ContentLength = int64(len(data))
+
+	upstreamReq, err := p.newAuthorizedRequest(shadow, route, target, m.upstream)
+	if err != nil {
+		cancel()
+		<-m.slots
+		p.metrics.RecordMirrorDropped(routeName, "invalid_request")
+		return true
+	}
+
+	go func() {
+		defer func() { <-m.slots }()
+		defer cancel()
+		p.sendMirror(upstreamReq, route, m.upstream, routeName, target)
+	}()
+	return true
+}
+
+// sendMirror performs the shadow request and discards the response.
+func (p *Proxy) sendMirror(req *http.Request, route *router.Route, upstream, routeName string, target *loadbalancer.Target) {
+	target.Connections.Add(1)
+	defer target.Connections.Add(-1)
+
+	start := time.Now()
+	status := 0
+	resp, err := p.clientFor(upstream).Do(req)
+	if err == nil {
+		resp, err = p.retryUnauthorized(req, route, upstream, resp)
+	}
+	if err == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		status = resp.StatusCode
+	}
+	p.metrics.RecordMirrorRequest(routeName, req.Method, status, time.Since(start))
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+type mirroredRequest struct {
+	method, path, body string
+}
+
+func TestProxy_Mirror(t *testing.T) {
+	primary := namedBackend(t, "primary")
+
+	received := make(chan mirroredRequest, 1)
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- mirroredRequest{r.Method, r.URL.Path, string(body)}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	p := newTestProxy(t, primary.URL, func(cfg *config.Config) {
+		cfg.Upstreams = append(cfg.Upstreams, config.Upstream{
+			Name: "shadow", Targets: []config.Target{{URL: shadow.URL}},
+		})
+		cfg.Routes[0].Mirror = &config.Mirror{Upstream: "shadow", MaxConcurrent: 1}
+	})
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	start := time.Now()
+	resp, err := http.Post(gw.URL+"/orders", "application/json", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != `primary:{"id":1}` {
+		t.Errorf("client got %q, want the primary response", body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("client waited %v for a stalled mirror", elapsed)
+	}
+
+	select {
+	case got := <-received:
+		want := mirroredRequest{"POST", "/orders", `{"id":1}`}
+		if got != want {
+			t.Errorf("mirror received %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("mirror never received the request")
+	}
+
+	// The single mirror slot is still held by the stalled request.
+	resp, err = http.Post(gw.URL+"/orders", "application/json", strings.NewReader(`{"id":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	release <- struct{}{}
+	deadline := time.Now().Add(2 * time.Second)
+	var out string
+	for time.Now().Before(deadline) {
+		rec := httptest.NewRecorder()
+		p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		out = rec.Body.String()
+		if strings.Contains(out, `gateway_mirror_requests_total{key="test_POST_500"} 1`) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, want := range []string{
+		`gateway_mirror_requests_total{key="test_POST_500"} 1`,
+		`gateway_mirror_dropped_total{key="test_concurrency"} 1`,
+		`gateway_requests_total{key="test_POST_200"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
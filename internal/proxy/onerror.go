@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+// subsystemErrorLogInterval bounds how often a failing subsystem is logged,
+// so a store that is down does not flood the log.
+const subsystemErrorLogInterval = 30 * time.Second
+
+// errorPolicy tracks whether a subsystem's backing store is failing and
+// applies its on_error setting to requests in the meantime. Every failure
+// is counted; the degraded gauge is 1 while the last call failed.
+type errorPolicy struct {
+	subsystem string
+	action    string
+	metrics   *metrics.Metrics
+	logger    *slog.Logger
+
+	// healthy is set while failing is not, so observe and failingAction
+	// can skip the lock while the subsystem works, which they are called
+	// for on every request for some subsystems.
+	healthy atomic.Bool
+
+	mu         sync.Mutex
+	failing    bool
+	lastLog    time.Time
+	suppressed int
+}
+
+func newErrorPolicy(subsystem, action string, m *metrics.Metrics, logger *slog.Logger) *errorPolicy {
+	if action == "" {
+		action = config.OnErrorDegrade
+	}
+	m.RecordSubsystemDegraded(subsystem, false)
+	e := &errorPolicy{subsystem: subsystem, action: action, metrics: m, logger: logger}
+	e.healthy.Store(true)
+	return e
+}
+
+// observe records the outcome of a call to the subsystem's store.
+func (e *errorPolicy) observe(err error) {
+	if err == nil && e.healthy.Load() {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		if e.failing {
+			e.failing = false
+			e.healthy.Store(true)
+			e.metrics.RecordSubsystemDegraded(e.subsystem, false)
+			e.logger.Info("subsystem recovered", "subsystem", e.subsystem)
+		}
+		return
+	}
+
+	e.metrics.RecordSubsystemError(e.subsystem)
+	if !e.failing {
+		e.failing = true
+		e.healthy.Store(false)
+		e.metrics.RecordSubsystemDegraded(e.subsystem, true)
+	}
+	now := time.Now()
+	if now.Sub(e.lastLog) < subsystemErrorLogInterval {
+		e.suppressed++
+		return
+	}
+	e.logger.Warn("subsystem error", "subsystem", e.subsystem, "on_error", e.action, "suppressed", e.suppressed, "error", err)
+	e.lastLog = now
+	e.suppressed = 0
+}
+
+// failingAction returns the on_error action while the last call failed, and ""
+// while the subsystem is working.
+func (e *errorPolicy) failingAction() string {
+	if e.healthy.Load() {
+		return ""
+	}
+	return e.action
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/quota"
+)
+
+func TestProxy_SecretsOnError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		onError            string
+		withKey, anonymous int // status while the secret store is failing
+	}{
+		{"", http.StatusOK, http.StatusUnauthorized},
+		{config.OnErrorDegrade, http.StatusOK, http.StatusUnauthorized},
+		{config.OnErrorDeny, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{config.OnErrorAllow, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Server.RequireAPIKey = true
+			cfg.APIKeys = []config.APIKey{{Key: "k1", Name: "billing", Enabled: true}}
+			cfg.Secrets.OnError = tt.onError
+		})
+		get := func(key string) int {
+			r := httptest.NewRequest("GET", "/", nil)
+			if key != "" {
+				r.Header.Set("X-API-Key", key)
+			}
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, r)
+			return rec.Code
+		}
+		metrics := func() string {
+			rec := httptest.NewRecorder()
+			p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			return rec.Body.String()
+		}
+
+		p.SecretsRefreshed(errors.New("vault: connection refused"))
+		p.SecretsRefreshed(errors.New("vault: connection refused"))
+		if got := get("k1"); got != tt.withKey {
+			t.Errorf("on_error %q: valid key while failing: status = %d, want %d", tt.onError, got, tt.withKey)
+		}
+		if got := get(""); got != tt.anonymous {
+			t.Errorf("on_error %q: no key while failing: status = %d, want %d", tt.onError, got, tt.anonymous)
+		}
+		m := metrics()
+		if !strings.Contains(m, `gateway_subsystem_errors_total{subsystem="secrets"} 2`) ||
+			!strings.Contains(m, `gateway_subsystem_degraded{subsystem="secrets"} 1`) {
+			t.Errorf("on_error %q: failures not recorded:\n%s", tt.onError, m)
+		}
+
+		// A successful refresh restores normal key checks.
+		p.SecretsRefreshed(nil)
+		if got := get("k1"); got != http.StatusOK {
+			t.Errorf("on_error %q: valid key after recovery: status = %d", tt.onError, got)
+		}
+		if got := get(""); got != http.StatusUnauthorized {
+			t.Errorf("on_error %q: no key after recovery: status = %d", tt.onError, got)
+		}
+		if m := metrics(); !strings.Contains(m, `gateway_subsystem_degraded{subsystem="secrets"} 0`) {
+			t.Errorf("on_error %q: degraded gauge not cleared", tt.onError)
+		}
+	}
+}
+
+// errStoreDown is the failure the injected stores report.
+var errStoreDown = errors.New("redis: connection refused")
+
+// failingRateLimits is a rate limit store that is down.
+type failingRateLimits struct{}
+
+func (failingRateLimits) Take(string, int, int) (bool, error) { return false, errStoreDown }
+
+// subsystemMetrics returns the proxy's metrics exposition.
+func subsystemMetrics(p *Proxy) string {
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestProxy_RateLimitOnError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		onError       string
+		first, second int // statuses while the store is failing
+	}{
+		// The limits are still applied, by this process alone.
+		{"", http.StatusOK, http.StatusTooManyRequests},
+		{config.OnErrorDegrade, http.StatusOK, http.StatusTooManyRequests},
+		{config.OnErrorDeny, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{config.OnErrorAllow, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.RateLimit = config.RateLimitConfig{Enabled: true, PerIP: true, DefaultRPS: 1, DefaultBurst: 1, OnError: tt.onError}
+		})
+		get := func() int {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			return rec.Code
+		}
+
+		p.rateLimits = failingRateLimits{}
+		if first, second := get(), get(); first != tt.first || second != tt.second {
+			t.Errorf("on_error %q: statuses while failing = %d, %d, want %d, %d", tt.onError, first, second, tt.first, tt.second)
+		}
+		m := subsystemMetrics(p)
+		if !strings.Contains(m, `gateway_subsystem_errors_total{subsystem="rate_limit"} 2`) ||
+			!strings.Contains(m, `gateway_subsystem_degraded{subsystem="rate_limit"} 1`) {
+			t.Errorf("on_error %q: failures not recorded:\n%s", tt.onError, m)
+		}
+
+		// Once the store answers again, so does the gauge.
+		p.rateLimits = p.rateLimiter
+		get()
+		if m := subsystemMetrics(p); !strings.Contains(m, `gateway_subsystem_degraded{subsystem="rate_limit"} 0`) {
+			t.Errorf("on_error %q: degraded gauge not cleared", tt.onError)
+		}
+	}
+}
+
+type failingQuotas struct{}
+
+func (failingQuotas) Increment(string, time.Duration) (int64, time.Time, error) {
+	return 0, time.Time{}, errStoreDown
+}
+
+func TestProxy_QuotaOnError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		onError       string
+		first, second int // statuses while the store is failing
+	}{
+		// The quota is still applied, counted by this process alone.
+		{"", http.StatusOK, http.StatusTooManyRequests},
+		{config.OnErrorDegrade, http.StatusOK, http.StatusTooManyRequests},
+		{config.OnErrorDeny, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{config.OnErrorAllow, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.APIKeys = []config.APIKey{{Key: "k1", Name: "billing", Enabled: true}}
+			cfg.Quota = config.QuotaConfig{Enabled: true, Requests: 1, Period: time.Hour, OnError: tt.onError}
+		})
+		get := func() int {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-API-Key", "k1")
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, r)
+			return rec.Code
+		}
+
+		p.quotas = failingQuotas{}
+		if first, second := get(), get(); first != tt.first || second != tt.second {
+			t.Errorf("on_error %q: statuses while failing = %d, %d, want %d, %d", tt.onError, first, second, tt.first, tt.second)
+		}
+		m := subsystemMetrics(p)
+		if !strings.Contains(m, `gateway_subsystem_errors_total{subsystem="quota"} 2`) ||
+			!strings.Contains(m, `gateway_subsystem_degraded{subsystem="quota"} 1`) {
+			t.Errorf("on_error %q: failures not recorded:\n%s", tt.onError, m)
+		}
+
+		// Once the store answers again, it does the counting.
+		p.quotas = quota.NewMemoryStore()
+		if got := get(); got != http.StatusOK {
+			t.Errorf("on_error %q: status after recovery = %d", tt.onError, got)
+		}
+		if m := subsystemMetrics(p); !strings.Contains(m, `gateway_subsystem_degraded{subsystem="quota"} 0`) {
+			t.Errorf("on_error %q: degraded gauge not cleared", tt.onError)
+		}
+	}
+}
+
+// failingCacheStore is a cache store that is down.
+type failingCacheStore struct{}
+
+func (failingCacheStore) get(string, string) (*cacheEntry, error) { return nil, errStoreDown }
+func (failingCacheStore) put(*cacheEntry) error                   { return errStoreDown }
+func (failingCacheStore) replace(_, _ *cacheEntry) error          { return errStoreDown }
+func (failingCacheStore) invalidate(string) (bool, error)         { return false, errStoreDown }
+
+func TestProxy_CacheOnError(t *testing.T) {
+	tests := []struct {
+		onError     string
+		status      int    // of reads and writes while the store is failing
+		xCache      string // of reads while the store is failing
+		backendHits int64  // of the reads
+	}{
+		// The cache is bypassed.
+		{"", http.StatusOK, "BYPASS", 2},
+		{config.OnErrorDegrade, http.StatusOK, "BYPASS", 2},
+		{config.OnErrorDeny, http.StatusServiceUnavailable, "", 0},
+		{config.OnErrorAllow, http.StatusOK, "MISS", 2},
+	}
+	for _, tt := range tests {
+		backend, count := countingBackend(t)
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute, OnError: tt.onError}
+		})
+		do := func(method string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest(method, "/items", nil))
+			return rec
+		}
+		c := p.caches[p.config.Routes[0].Cache]
+
+		c.store = failingCacheStore{}
+		for i := 0; i < 2; i++ {
+			rec := do("GET")
+			if rec.Code != tt.status || rec.Header().Get("X-Cache") != tt.xCache {
+				t.Errorf("on_error %q: read %d = %d with X-Cache %q, want %d with %q",
+					tt.onError, i, rec.Code, rec.Header().Get("X-Cache"), tt.status, tt.xCache)
+			}
+		}
+		if n := count("GET /items"); n != tt.backendHits {
+			t.Errorf("on_error %q: backend saw %d reads, want %d", tt.onError, n, tt.backendHits)
+		}
+		// A write whose cached responses cannot be dropped is refused
+		// only by deny.
+		if rec := do("POST"); rec.Code != tt.status {
+			t.Errorf("on_error %q: write = %d, want %d", tt.onError, rec.Code, tt.status)
+		}
+		m := subsystemMetrics(p)
+		if !strings.Contains(m, `gateway_subsystem_errors_total{subsystem="cache:test"}`) ||
+			!strings.Contains(m, `gateway_subsystem_degraded{subsystem="cache:test"} 1`) {
+			t.Errorf("on_error %q: failures not recorded:\n%s", tt.onError, m)
+		}
+
+		// Once the store answers again, responses are cached again.
+		c.store = newMemoryCacheStore(10)
+		if first, second := do("GET"), do("GET"); first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("on_error %q: X-Cache after recovery = %q then %q, want MISS then HIT",
+				tt.onError, first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+		}
+		if m := subsystemMetrics(p); !strings.Contains(m, `gateway_subsystem_degraded{subsystem="cache:test"} 0`) {
+			t.Errorf("on_error %q: degraded gauge not cleared", tt.onError)
+		}
+	}
+}
+
+func TestProxy_JWTOnError(t *testing.T) {
+	tests := []struct {
+		onError string
+		valid   int // statuses while the key set cannot be fetched
+		invalid int
+	}{
+		// Tokens are still verified, with the keys fetched before.
+		{"", http.StatusOK, http.StatusUnauthorized},
+		{config.OnErrorDegrade, http.StatusOK, http.StatusUnauthorized},
+		{config.OnErrorDeny, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{config.OnErrorAllow, http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		jwks := newJWKSServer(t)
+		p := newJWTProxy(t, jwks, tt.onError)
+		token := jwks.token(t, validClaims())
+		if rec := getWithToken(p, token); rec.Code != http.StatusOK {
+			t.Fatalf("on_error %q: status before the failure = %d", tt.onError, rec.Code)
+		}
+
+		// The keys are due for a refresh, which fails.
+		jwks.failing.Store(true)
+		later := time.Now().Add(2 * time.Minute)
+		p.jwks.now = func() time.Time { return later }
+		if got := getWithToken(p, token).Code; got != tt.valid {
+			t.Errorf("on_error %q: valid token while failing: status = %d, want %d", tt.onError, got, tt.valid)
+		}
+		if got := getWithToken(p, "not-a-token").Code; got != tt.invalid {
+			t.Errorf("on_error %q: invalid token while failing: status = %d, want %d", tt.onError, got, tt.invalid)
+		}
+		m := subsystemMetrics(p)
+		if !strings.Contains(m, `gateway_subsystem_errors_total{subsystem="auth.jwt"} 1`) ||
+			!strings.Contains(m, `gateway_subsystem_degraded{subsystem="auth.jwt"} 1`) {
+			t.Errorf("on_error %q: failures not recorded:\n%s", tt.onError, m)
+		}
+
+		// Once the key set can be fetched again, tokens are verified as
+		// before.
+		jwks.failing.Store(false)
+		later = later.Add(jwksRetryInterval)
+		if got := getWithToken(p, "not-a-token").Code; got != http.StatusUnauthorized {
+			t.Errorf("on_error %q: invalid token after recovery: status = %d", tt.onError, got)
+		}
+		if m := subsystemMetrics(p); !strings.Contains(m, `gateway_subsystem_degraded{subsystem="auth.jwt"} 0`) {
+			t.Errorf("on_error %q: degraded gauge not cleared", tt.onError)
+		}
+	}
+
+	// Without keys fetched before, degrade cannot verify anything.
+	jwks := newJWKSServer(t)
+	jwks.failing.Store(true)
+	p := newJWTProxy(t, jwks, config.OnErrorDegrade)
+	if got := getWithToken(p, jwks.token(t, validClaims())).Code; got != http.StatusServiceUnavailable {
+		t.Errorf("degrade without cached keys: status = %d, want 503", got)
+	}
+}
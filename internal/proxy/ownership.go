@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// maxPreviewConfigSize bounds the configuration accepted for a preview.
+const maxPreviewConfigSize = 4 << 20
+
+// routeOwner returns the team owning the named route, or "".
+func (p *Proxy) routeOwner(name string) string {
+	for _, r := range p.config.Routes {
+		if configRouteName(r) == name {
+			return r.Owner
+		}
+	}
+	return ""
+}
+
+// upstreamOwner returns the team owning the named upstream, or "".
+func (p *Proxy) upstreamOwner(name string) string {
+	for _, u := range p.config.Upstreams {
+		if u.Name == name {
+			return u.Owner
+		}
+	}
+	return ""
+}
+
+// handleConfigPreview validates the YAML configuration in the body and
+// lists the routes, upstreams and API keys it would change compared with
+// the running configuration. With changed_by naming a team, changes to
+// other teams' entities are flagged, and answered with 409 when the
+// running configuration sets enforce_ownership. Nothing is applied.
+func (p *Proxy) handleConfigPreview(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPreviewConfigSize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "configuration too large", "")
+		return
+	}
+	next, err := config.Parse(data)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"valid": false, "error": err.Error()})
+		return
+	}
+
+	report := config.CheckOwnership(p.config, next, r.URL.Query().Get("changed_by"))
+	status := http.StatusOK
+	if report.Rejected {
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, struct {
+		Valid bool `json:"valid"`
+		config.OwnershipReport
+	}{true, report})
+}
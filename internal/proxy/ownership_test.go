@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const previewConfig = `
+upstreams:
+  - name: backend
+    targets:
+      - url: http://backend.internal:8080
+routes:
+  - name: test
+    owner: team-b
+    path: /v2/**
+    upstream: backend
+  - name: reports
+    owner: team-a
+    path: /reports/**
+    upstream: backend
+`
+
+func previewRequest(t *testing.T, p *Proxy, body, changedBy string) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/config/preview?changed_by="+changedBy, strings.NewReader(body))
+	p.AdminHandler().ServeHTTP(rec, req)
+	var resp map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, resp
+}
+
+func TestAdmin_ConfigPreviewFlagsForeignChanges(t *testing.T) {
+	p := newTestProxy(t, "http://backend.internal:8080", func(cfg *config.Config) {
+		cfg.Routes[0].Owner = "team-b"
+	})
+
+	code, resp := previewRequest(t, p, previewConfig, "team-a")
+	if code != http.StatusOK || resp["valid"] != true {
+		t.Fatalf("status = %d, response = %v, want a valid preview", code, resp)
+	}
+	changes, _ := resp["changes"].([]any)
+	foreign, _ := resp["foreign_changes"].([]any)
+	if len(changes) != 2 || len(foreign) != 1 {
+		t.Fatalf("changes = %v, foreign = %v, want the added route and the modified one of team-b", changes, foreign)
+	}
+	if c := foreign[0].(map[string]any); c["name"] != "test" || c["change"] != "modified" || c["owner"] != "team-b" {
+		t.Errorf("foreign change = %v, want route test modified, owned by team-b", c)
+	}
+
+	_, resp = previewRequest(t, p, previewConfig, "team-b")
+	if foreign, _ := resp["foreign_changes"].([]any); len(foreign) != 1 || foreign[0].(map[string]any)["name"] != "reports" {
+		t.Errorf("owning team: foreign = %v, want only the route added for team-a", resp["foreign_changes"])
+	}
+}
+
+func TestAdmin_ConfigPreviewEnforcesOwnership(t *testing.T) {
+	p := newTestProxy(t, "http://backend.internal:8080", func(cfg *config.Config) {
+		cfg.Routes[0].Owner = "team-b"
+		cfg.EnforceOwnership = true
+	})
+
+	if code, resp := previewRequest(t, p, previewConfig, "team-a"); code != http.StatusConflict || resp["rejected"] != true {
+		t.Errorf("status = %d, response = %v, want 409 rejected", code, resp)
+	}
+	if code, resp := previewRequest(t, p, "routes: [{path: /x, upstream: missing}]", "team-a"); code != http.StatusUnprocessableEntity || resp["valid"] != false {
+		t.Errorf("invalid config: status = %d, response = %v, want 422", code, resp)
+	}
+}
+
+func TestProxy_OwnerAttribution(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Owner = "team-b"
+		cfg.Upstreams[0].Owner = "team-c"
+	})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items", nil))
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_owner_requests_total{key="team-b_200"} 1`) {
+		t.Errorf("request was not attributed to the route owner:\n%s", metrics.Body.String())
+	}
+
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/upstreams", nil))
+	if !strings.Contains(rec.Body.String(), `"owner":"team-c"`) {
+		t.Errorf("upstream listing has no owner: %s", rec.Body.String())
+	}
+}
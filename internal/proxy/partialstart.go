@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+)
+
+// upstreamDisabled is the admin API state of an upstream partial_start left
+// out.
+const upstreamDisabled = "disabled"
+
+// newUpstream builds the load balancer of u. It reports every invalid
+// target, not just the first.
+func newUpstream(u config.Upstream) (loadbalancer.LoadBalancer, error) {
+	if len(u.Targets) == 0 {
+		return nil, errors.New("no targets")
+	}
+	var errs []error
+	targets := make([]*loadbalancer.Target, 0, len(u.Targets))
+	for _, t := range u.Targets {
+		parsed, err := url.Parse(t.URL)
+		if err == nil && (parsed.Scheme == "" || parsed.Host == "") {
+			err = errors.New("missing scheme or host")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid upstream URL %s: %w", t.URL, err))
+			continue
+		}
+		weight := t.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		targets = append(targets, &loadbalancer.Target{
+			URL:         parsed,
+			Weight:      weight,
+			Standby:     t.Standby,
+			ExpectedRTT: t.ExpectedRTT.Duration,
+			AutoRTT:     t.ExpectedRTT.Auto,
+		})
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return loadbalancer.New(u.LoadBalance, targets), nil
+}
+
+// setupPartialStart records the upstreams that could not be built. New has
+// already failed for them unless server.partial_start is set.
+func (p *Proxy) setupPartialStart(errs map[string]error) {
+	p.disabledUpstreams = errs
+	for _, u := range p.config.Upstreams {
+		err := errs[u.Name]
+		if err == nil {
+			continue
+		}
+		p.metrics.RecordUpstreamDisabled(u.Name)
+		p.logger.Error("upstream disabled", "upstream", u.Name, "routes", p.disabledRoutes(u.Name), "error", err)
+	}
+}
+
+// disabledRoutes returns the routes sending to upstream.
+func (p *Proxy) disabledRoutes(upstream string) []string {
+	var routes []string
+	for _, r := range p.config.Routes {
+		if r.Upstream == upstream {
+			routes = append(routes, r.Name)
+		}
+	}
+	return routes
+}
+
+// Degraded describes what partial_start left out, for /ready. It returns
+// nil when everything started.
+func (p *Proxy) Degraded() map[string]any {
+	if len(p.disabledUpstreams) == 0 {
+		return nil
+	}
+	upstreams := make(map[string]string, len(p.disabledUpstreams))
+	routes := []string{}
+	for name, err := range p.disabledUpstreams {
+		upstreams[name] = err.Error()
+		routes = append(routes, p.disabledRoutes(name)...)
+	}
+	sort.Strings(routes)
+	return map[string]any{"upstreams": upstreams, "routes": routes}
+}
+
+// disabledStatus reports a disabled upstream with its configured targets,
+// none of which is in use.
+func (p *Proxy) disabledStatus(u config.Upstream, err error) upstreamStatus {
+	status := upstreamStatus{Name: u.Name, Owner: u.Owner, State: upstreamDisabled, Error: err.Error(), Targets: []targetStatus{}}
+	for _, t := range u.Targets {
+		status.Targets = append(status.Targets, targetStatus{URL: t.URL})
+	}
+	return status
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func mixedUpstreams(backendURL string, partial bool) *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.Enabled = false
+	cfg.Server.PartialStart = partial
+	cfg.Upstreams = []config.Upstream{
+		{Name: "good", Targets: []config.Target{{URL: backendURL}}},
+		{Name: "bad", Targets: []config.Target{{URL: "http://[::1"}, {URL: "localhost"}}},
+		{Name: "empty"},
+	}
+	cfg.Routes = []config.Route{
+		{Name: "good", Path: "/good", Upstream: "good"},
+		{Name: "bad", Path: "/bad", Upstream: "bad"},
+		{Name: "empty", Path: "/empty", Upstream: "empty"},
+	}
+	return cfg
+}
+
+func TestNew_FailFastReportsEveryError(t *testing.T) {
+	_, err := New(mixedUpstreams("http://localhost:1", false))
+	if err == nil {
+		t.Fatal("New succeeded with invalid upstreams")
+	}
+	for _, want := range []string{"upstream bad", `http://[::1`, "localhost: missing scheme or host", "upstream empty: no targets"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "upstream good") {
+		t.Errorf("error %q mentions the valid upstream", err)
+	}
+}
+
+func TestNew_PartialStart(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	p, err := New(mixedUpstreams(backend.URL, true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Stop()
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/good", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid route: status = %d, want 200", rec.Code)
+	}
+	for _, path := range []string{"/bad", "/empty"} {
+		rec = httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "disabled at start") {
+			t.Errorf("%s: status = %d, body = %q", path, rec.Code, rec.Body.String())
+		}
+	}
+
+	degraded := p.Degraded()
+	if routes := degraded["routes"].([]string); strings.Join(routes, ",") != "bad,empty" {
+		t.Errorf("degraded routes = %v, want [bad empty]", routes)
+	}
+	if upstreams := degraded["upstreams"].(map[string]string); len(upstreams) != 2 || upstreams["good"] != "" {
+		t.Errorf("degraded upstreams = %v", upstreams)
+	}
+
+	rec = httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/upstreams", nil))
+	var resp struct {
+		Upstreams []upstreamStatus `json:"upstreams"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Upstreams) != 3 {
+		t.Fatalf("GET /admin/upstreams: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	for _, u := range resp.Upstreams {
+		disabled := u.Name != "good"
+		if (u.State == "disabled") != disabled || (u.Error != "") != disabled {
+			t.Errorf("upstream %s: state = %q, error = %q", u.Name, u.State, u.Error)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gateway_upstream_disabled{upstream="bad"} 1`) {
+		t.Error("metrics do not report the disabled upstream")
+	}
+}
+
+func TestNew_HealthyUpstreamsUnaffected(t *testing.T) {
+	p := newTestProxy(t, "http://localhost:1", func(cfg *config.Config) {
+		cfg.Server.PartialStart = true
+	})
+	if d := p.Degraded(); d != nil {
+		t.Errorf("Degraded() = %v with every upstream valid, want nil", d)
+	}
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// pipelineRequest is the per-request state handed from stage to stage.
+type pipelineRequest struct {
+	w         *statusWriter
+	r         *http.Request
+	route     *router.Route
+	routeName string
+	start     time.Time
+	// probe marks synthetic probe traffic, which is not rate limited or
+	// counted in usage stats.
+	probe bool
+
+	clientIP    string
+	apiKey      string
+	apiKeyName  string
+	fingerprint string
+
+	// requestHash is the content fingerprint of the request and repeats how
+	// often it was seen recently, when the route sets request_fingerprint.
+	requestHash string
+	repeats     int
+
+	// targetURL is set by the proxy stage once a target is picked.
+	targetURL string
+
+	// debug is the route's debug session, if one is running.
+	debug *debugSession
+
+	// inflight is the request's entry in the in-flight registry.
+	inflight *inflightRequest
+
+	done []func(status int)
+}
+
+// onDone registers fn to run with the final status once the request has
+// been answered, including by a panic.
+func (req *pipelineRequest) onDone(fn func(status int)) {
+	req.done = append(req.done, fn)
+}
+
+func (req *pipelineRequest) finish() {
+	for _, fn := range req.done {
+		fn(req.w.status)
+	}
+}
+
+// stage is one named step of the request pipeline. serve reports whether the
+// request should continue; a stage returning false has written the response.
+type stage interface {
+	serve(req *pipelineRequest) bool
+}
+
+type stageFunc func(req *pipelineRequest) bool
+
+func (f stageFunc) serve(req *pipelineRequest) bool { return f(req) }
+
+// newStages maps the stage names of config.DefaultPipeline to their
+// implementations.
+func (p *Proxy) newStages() map[string]stage {
+	return map[string]stage{
+		"tls_deny":      stageFunc(p.tlsDenyStage),
+		"ratelimit":     stageFunc(p.rateLimitStage),
+		"concurrency":   stageFunc(p.concurrencyStage),
+		"capture":       stageFunc(p.captureStage),
+		"body_limit":    stageFunc(p.bodyLimitStage),
+		"decompress":    stageFunc(p.decompressStage),
+		"schema":        stageFunc(p.schemaStage),
+		"idempotency":   stageFunc(p.idempotencyStage),
+		"fingerprint":   stageFunc(p.fingerprintStage),
+		"body_match":    stageFunc(p.bodyMatchStage),
+		"feature_flags": stageFunc(p.featureFlagsStage),
+		"mirror":        stageFunc(p.mirrorStage),
+		"validators":    stageFunc(p.validatorsStage),
+		"cache":         stageFunc(p.cacheStage),
+		"proxy":         stageFunc(p.proxyStage),
+	}
+}
+
+// runPipeline runs the route's stages in order until one of them answers.
+func (p *Proxy) runPipeline(req *pipelineRequest, names []string) {
+	for _, name := range names {
+		if !p.stages[name].serve(req) {
+			return
+		}
+	}
+}
+
+func (p *Proxy) tlsDenyStage(req *pipelineRequest) bool {
+	if req.fingerprint == "" || !p.deniedFingerprints[req.fingerprint] {
+		return true
+	}
+	p.metrics.RecordError(req.routeName, "tls_fingerprint_denied")
+	p.writeError(req.w, http.StatusForbidden, "tls_fingerprint_denied", "forbidden")
+	return false
+}
+
+// rateLimitStage applies the rate limits and then the quota.
+func (p *Proxy) rateLimitStage(req *pipelineRequest) bool {
+	if req.probe {
+		return true
+	}
+	if p.config.RateLimit.Enabled {
+		start := time.Now()
+		allowed := p.checkRateLimits(req.w, req.route, req.clientIP, req.apiKeyName, req.fingerprint, req.routeName)
+		timingFrom(req.r.Context()).observe(phaseRateLimit, time.Since(start))
+		if !allowed {
+			return false
+		}
+	}
+	return p.checkQuota(req.w, req.apiKeyName, req.routeName)
+}
+
+func (p *Proxy) bodyLimitStage(req *pipelineRequest) bool {
+	if !p.checkLearnedBodyLimit(req) {
+		return false
+	}
+	limit := p.maxBodySize(req.route)
+	if limit <= 0 {
+		return true
+	}
+	if req.r.ContentLength > limit {
+		p.metrics.RecordError(req.routeName, "body_too_large")
+		p.writeError(req.w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+		return false
+	}
+	// Bodies without a Content-Length are cut off once they pass the limit.
+	req.r.Body = http.MaxBytesReader(req.w, req.r.Body, limit)
+	return true
+}
+
+func (p *Proxy) bodyMatchStage(req *pipelineRequest) bool {
+	return req.route.BodyMatch == nil || p.applyBodyMatch(req.w, req.r, req.route, req.routeName)
+}
+
+func (p *Proxy) mirrorStage(req *pipelineRequest) bool {
+	return req.route.Mirror == nil || p.mirrorRequest(req.w, req.r, req.route, req.routeName)
+}
+
+func (p *Proxy) cacheStage(req *pipelineRequest) bool {
+	if req.route.Cache == nil {
+		return true
+	}
+	served, revalidation := p.serveFromCache(req.w, req.r, req.route, req.routeName)
+	if revalidation != nil {
+		req.r = withCacheRevalidation(req.r, revalidation)
+	}
+	if !served {
+		return true
+	}
+	p.metrics.RecordRequest(req.routeName, req.r.Method, req.w.status, time.Since(req.start))
+	return false
+}
+
+// proxyStage forwards the request to a target of the route's upstream. It
+// always answers.
+func (p *Proxy) proxyStage(req *pipelineRequest) bool {
+	r, route, routeName := req.r, req.route, req.routeName
+
+	if p.config.Server.InitialHealthReject && p.awaitingInitialHealth(route.Upstream) {
+		p.metrics.RecordError(routeName, "awaiting_health_check")
+		req.w.Header().Set("Retry-After", "1")
+		p.writeError(req.w, http.StatusServiceUnavailable, "awaiting_health_check", "upstream not health checked yet")
+		return false
+	}
+
+	if p.disabledUpstreams[route.Upstream] != nil {
+		p.metrics.RecordError(routeName, "upstream_disabled")
+		p.writeError(req.w, http.StatusServiceUnavailable, "upstream_disabled", "upstream disabled at start")
+		return false
+	}
+
+	lb, ok := p.upstreams[route.Upstream]
+	if !ok {
+		p.metrics.RecordError(routeName, "upstream_not_found")
+		p.writeError(req.w, http.StatusBadGateway, "upstream_not_found", "upstream not configured")
+		return false
+	}
+
+	target := lb.Next()
+	if target == nil {
+		p.metrics.RecordError(routeName, "no_healthy_upstream")
+		p.writeError(req.w, http.StatusServiceUnavailable, "no_healthy_upstream", "no healthy upstream")
+		return false
+	}
+
+	target.Connections.Add(1)
+	defer target.Connections.Add(-1)
+	p.metrics.RecordRTTClassRequest(route.Upstream, target.RTTClass())
+	req.targetURL = target.URL.String()
+	req.inflight.setTarget(req.targetURL)
+	setDebugHeaders(req)
+
+	r, cancel := withClientDeadline(r, route, req.start)
+	defer cancel()
+	statusCode, err := p.proxyRequest(req.w, r, route, target)
+	if req.w.status == 0 {
+		// Upgraded connections are hijacked before a status is written.
+		req.w.status = statusCode
+	}
+	duration := time.Since(req.start)
+	isError := statusCode >= 400
+
+	p.metrics.RecordRequest(routeName, r.Method, statusCode, duration)
+	p.observeSlowRequest(req, statusCode, duration, err)
+	if !req.probe {
+		p.usageTracker.RecordRequest(routeName, duration, isError)
+	}
+
+	if req.apiKeyName != "" {
+		p.metrics.RecordAPIKeyRequest(req.apiKeyName, statusCode)
+		if !req.probe {
+			p.usageTracker.RecordRequest("apikey:"+req.apiKeyName, duration, isError)
+		}
+	}
+
+	if err != nil {
+		p.metrics.RecordError(routeName, proxyErrorType(err))
+		if errors.Is(err, errRequestDecompression) {
+			p.metrics.RecordRequestDecompressionFailure(routeName)
+		}
+	} else {
+		p.observeBodySize(r, route, statusCode)
+	}
+	if errors.Is(err, errResponseTruncated) {
+		// The response looks complete to the client unless the
+		// connection is torn down.
+		panic(http.ErrAbortHandler)
+	}
+	return false
+}
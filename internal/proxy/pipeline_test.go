@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestBodyLimitStage(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.Routes[0].MaxBodySize = 4
+	})
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader("too long"))
+	rec := httptest.NewRecorder()
+	req := &pipelineRequest{w: &statusWriter{ResponseWriter: rec}, r: r, route: p.router.Match(r), routeName: "test"}
+	if p.bodyLimitStage(req) {
+		t.Fatal("oversized body passed the stage")
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+
+	r = httptest.NewRequest("POST", "/", strings.NewReader("ok"))
+	req = &pipelineRequest{w: &statusWriter{ResponseWriter: httptest.NewRecorder()}, r: r, route: p.router.Match(r), routeName: "test"}
+	if !p.bodyLimitStage(req) {
+		t.Fatal("small body stopped by the stage")
+	}
+}
+
+func TestTLSDenyStage(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", nil)
+	p.deniedFingerprints = map[string]bool{"bad": true}
+
+	for fp, want := range map[string]bool{"": true, "good": true, "bad": false} {
+		rec := httptest.NewRecorder()
+		req := &pipelineRequest{w: &statusWriter{ResponseWriter: rec}, r: httptest.NewRequest("GET", "/", nil), routeName: "test", fingerprint: fp}
+		if got := p.tlsDenyStage(req); got != want {
+			t.Errorf("fingerprint %q: continue = %v, want %v", fp, got, want)
+		}
+	}
+}
+
+// TestProxy_PipelineOrder locks in the observable order of the default
+// pipeline and checks that a route's pipeline reorders or skips stages.
+func TestProxy_PipelineOrder(t *testing.T) {
+	backend, count := countingBackend(t)
+
+	newProxy := func(t *testing.T, pipeline ...string) *Proxy {
+		return newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.RateLimit.Enabled = true
+			cfg.RateLimit.PerIP = false
+			cfg.RateLimit.PerAPIKey = false
+			cfg.Routes[0].RateLimit = &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 1, BurstSize: 1}
+			cfg.Routes[0].MaxBodySize = 4
+			cfg.Routes[0].Cache = &config.Cache{TTL: time.Minute}
+			cfg.Routes[0].Pipeline = pipeline
+		})
+	}
+	do := func(p *Proxy, method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	metricsOf := func(p *Proxy) string {
+		rec := httptest.NewRecorder()
+		p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	t.Run("rate limit runs before body limit", func(t *testing.T) {
+		p := newProxy(t)
+		do(p, "GET", "/a", "")
+		if rec := do(p, "POST", "/a", "far too long"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want 429", rec.Code)
+		}
+		if strings.Contains(metricsOf(p), `key="test_body_too_large"`) {
+			t.Error("rate-limited request was counted as body_too_large")
+		}
+	})
+
+	t.Run("cache hit does not reach the upstream", func(t *testing.T) {
+		p := newProxy(t)
+		do(p, "GET", "/b", "")
+		for _, target := range p.upstreams["backend"].Targets() {
+			target.Healthy.Store(false)
+		}
+		p.rateLimiter.SetLimits("route:test", 100, 100)
+
+		rec := do(p, "GET", "/b", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+			t.Fatalf("got %d with X-Cache %q, want a cached 200", rec.Code, rec.Header().Get("X-Cache"))
+		}
+		if n := count("GET /b"); n != 1 {
+			t.Errorf("backend saw %d requests, want 1", n)
+		}
+		if strings.Contains(metricsOf(p), `key="test_no_healthy_upstream"`) {
+			t.Error("cache hit went through target selection")
+		}
+	})
+
+	t.Run("cache hits are rate limited by default", func(t *testing.T) {
+		p := newProxy(t)
+		do(p, "GET", "/c", "")
+		if rec := do(p, "GET", "/c", ""); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d, want 429", rec.Code)
+		}
+	})
+
+	t.Run("custom order", func(t *testing.T) {
+		p := newProxy(t, "cache", "ratelimit", "proxy")
+		do(p, "GET", "/d", "")
+		if rec := do(p, "GET", "/d", ""); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("got %d with X-Cache %q, want a cache hit ahead of the rate limit", rec.Code, rec.Header().Get("X-Cache"))
+		}
+	})
+
+	t.Run("skipped stages", func(t *testing.T) {
+		p := newProxy(t, "proxy")
+		for i := 0; i < 3; i++ {
+			if rec := do(p, "POST", "/e", "far too long"); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200 with rate and body limits skipped", rec.Code)
+			}
+		}
+	})
+}
@@ -2,20 +2,37 @@ package proxy
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"net/netip"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/relaypoint/relaypoint/internal/capture"
+	"github.com/relaypoint/relaypoint/internal/cluster"
 	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/degradation"
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+	"github.com/relaypoint/relaypoint/internal/jsonschema"
 	"github.com/relaypoint/relaypoint/internal/loadbalancer"
 	"github.com/relaypoint/relaypoint/internal/metrics"
+	"github.com/relaypoint/relaypoint/internal/pathprefix"
+	"github.com/relaypoint/relaypoint/internal/quota"
 	"github.com/relaypoint/relaypoint/internal/ratelimit"
 	"github.com/relaypoint/relaypoint/internal/router"
+	"github.com/relaypoint/relaypoint/internal/synthetic"
+	"github.com/relaypoint/relaypoint/internal/tlsfp"
+	"github.com/relaypoint/relaypoint/internal/tracing"
 )
 
 type Proxy struct {
@@ -24,32 +41,182 @@ type Proxy struct {
 	rateLimiter  *ratelimit.RateLimiter
 	metrics      *metrics.Metrics
 	usageTracker *metrics.UsageTracker
-	apiKeys      map[string]*config.APIKey
+	apiKeys      []apiKeyDigest
+	apiKeyNames  map[string]*config.APIKey // by name, for their rate limits
+	apiKeysMu    sync.RWMutex
+	apiKeyParams []string // query parameters that may carry an API key
 	config       *config.Config
 	httpClient   *http.Client
+	clients      map[string]*http.Client // per-upstream overrides of httpClient
+	upstreamTLS  map[string]*tls.Config  // per-upstream tls blocks, for WebSocket dials
+	clientCerts  []*clientCertificate
+	logger       *slog.Logger
+	panics       *panicGuard
+	events       *events.Bus
+
+	clusterProviders []cluster.Provider
+	bodyMatchers     map[*config.BodyMatch]*bodyMatcher
+	mirrors          map[*config.Mirror]*mirror
+	rewrites         map[*config.Rewrite]*pathRewrite
+	validators       map[*config.RequestSchema]*requestValidator
+	caches           map[*config.Cache]*responseCache
+	idempotency      map[*config.IdempotencyKeys]*idempotencyKeys
+	autoValidators   map[*config.AutoValidators]*validatorCache
+	stages           map[string]stage
+	recorder         *flightrecorder.Recorder // nil unless flight_recorder is enabled
+	capture          *capture.Capturer        // nil unless a capture sink is configured
+	accessLog        *slog.Logger             // nil unless access_log is enabled
+	accessLogFile    *os.File
+	tracer           *tracing.Tracer // nil unless tracing is enabled
+	errorTemplates   map[int]*errorTemplate
+	secretsPolicy    *errorPolicy
+	// rateLimits is where tokens are taken: rateLimiter, unless a test
+	// injects a failing store.
+	rateLimits      ratelimit.Store
+	rateLimitPolicy *errorPolicy
+	// quotas is where quota requests are counted: localQuotas, unless a
+	// test injects a failing store.
+	quotas      quota.Store
+	localQuotas *quota.MemoryStore
+	quotaPolicy *errorPolicy
+	jwks        *jwks // nil unless auth.jwt is configured
+	// awaitingHealth holds the upstreams that wait for their first health
+	// check cycle; it is not modified after New.
+	awaitingHealth     map[string]*atomic.Bool
+	initialHealthTimer *time.Timer
+	redactors          map[*config.RouteCapture]*capture.Redactor
+	ladders            map[*config.Degradation]*degradation.Ladder
+	repeats            map[*config.RequestFingerprint]*repeatCounter
+	bodyLimits         map[*config.BodyLimitLearning]*bodyLimitLearner
+	debug              *routeDebugger
+	securityHeaders    map[*config.SecurityHeaders]*securityHeaders
+	upstreamTokens     map[string]*upstreamToken // by upstream name, for upstreams with auth
+	configHistory      configHistory
+	inflight           inflightRegistry
+	maintenance        map[string]*atomic.Pointer[maintenanceMode] // by route name, fixed after New
+	concurrency        map[string]*concurrencyLimiter              // by route name, for routes with max_concurrent
+	adaptive           map[string]*adaptiveLimiter                 // by route name, for routes with adaptive_concurrency
+	activity           *activityTracker
+	copyBuffers        *bufferPool
+	basePath           pathprefix.Prefix            // server.base_path, removed by BasePathHandler
+	featureFlags       atomic.Pointer[featureFlags] // swapped by SetFeatureFlags
+	ladderStop         chan struct{}
+
+	mode               atomic.Pointer[gatewayMode]
+	modeMu             sync.Mutex // serializes mode changes and state file writes
+	readOnlyMethods    map[string]bool
+	readOnlyRetryAfter int
+
+	deniedFingerprints map[string]bool
+	allowIPs           []netip.Prefix  // server.allow_ips
+	denyIPs            []netip.Prefix  // server.deny_ips
+	deniedMethods      map[string]bool // server.denied_methods
+	trustedProxies     []netip.Prefix  // server.trusted_proxies
+
+	// disabledUpstreams holds the upstreams partial_start left out, with
+	// the reason; it is not modified after New.
+	disabledUpstreams map[string]error
+
+	// middlewares are added with Use; chain runs them, ending in the
+	// pipeline.
+	middlewares []Middleware
+	chain       http.Handler
 }
 
 func New(cfg *config.Config) (*Proxy, error) {
 	r := router.New(cfg.Routes)
+	if cfg.Server.PathMatching == config.PathMatchingRaw {
+		r.MatchRawPath()
+	}
+	if cfg.Server.CaseSensitivePaths {
+		r.MatchCaseSensitivePaths()
+	}
 
+	// Construction errors are collected so a bad config is reported in
+	// full. Upstream errors are tolerated with server.partial_start.
 	upstreams := make(map[string]loadbalancer.LoadBalancer)
+	upstreamErrs := make(map[string]error)
 	for _, u := range cfg.Upstreams {
-		targets := make([]*loadbalancer.Target, len(u.Targets))
-		for i, t := range u.Targets {
-			parsed, err := url.Parse(t.URL)
-			if err != nil {
-				return nil, fmt.Errorf("invalid upstream URL %s: %w", t.URL, err)
-			}
-			weight := t.Weight
-			if weight <= 0 {
-				weight = 1
-			}
-			targets[i] = &loadbalancer.Target{
-				URL:    parsed,
-				Weight: weight,
-			}
+		lb, err := newUpstream(u)
+		if err != nil {
+			upstreamErrs[u.Name] = err
+			continue
+		}
+		upstreams[u.Name] = lb
+	}
+	var routeErrs []error
+	for _, c := range r.Conflicts() {
+		if c.Duplicate {
+			routeErrs = append(routeErrs, errors.New(c.String()))
+		}
+	}
+
+	bodyMatchers := make(map[*config.BodyMatch]*bodyMatcher)
+	for _, route := range cfg.Routes {
+		if route.BodyMatch == nil {
+			continue
+		}
+		m, err := newBodyMatcher(route.BodyMatch)
+		if err != nil {
+			routeErrs = append(routeErrs, fmt.Errorf("route %s body_match: %w", route.Name, err))
+			continue
+		}
+		bodyMatchers[route.BodyMatch] = m
+	}
+
+	rewrites := make(map[*config.Rewrite]*pathRewrite)
+	for _, route := range cfg.Routes {
+		if route.Rewrite == nil {
+			continue
+		}
+		rw, err := newPathRewrite(route.Rewrite)
+		if err != nil {
+			routeErrs = append(routeErrs, fmt.Errorf("route %s rewrite: %w", route.Name, err))
+			continue
+		}
+		rewrites[route.Rewrite] = rw
+	}
+
+	validators := make(map[*config.RequestSchema]*requestValidator)
+	schemas := make(map[string]*jsonschema.Schema)
+	for _, route := range cfg.Routes {
+		if route.RequestSchema == nil {
+			continue
+		}
+		v, err := newRequestValidator(route.RequestSchema, schemas)
+		if err != nil {
+			routeErrs = append(routeErrs, fmt.Errorf("route %s request_schema: %w", route.Name, err))
+			continue
+		}
+		validators[route.RequestSchema] = v
+	}
+
+	repeats := make(map[*config.RequestFingerprint]*repeatCounter)
+	for _, route := range cfg.Routes {
+		if route.RequestFingerprint != nil {
+			repeats[route.RequestFingerprint] = newRepeatCounter(route.RequestFingerprint)
+		}
+	}
+
+	idempotencyKeys := make(map[*config.IdempotencyKeys]*idempotencyKeys)
+	for _, route := range cfg.Routes {
+		if route.IdempotencyKeys != nil {
+			idempotencyKeys[route.IdempotencyKeys] = newIdempotencyKeys(route.IdempotencyKeys)
+		}
+	}
+
+	autoValidators := make(map[*config.AutoValidators]*validatorCache)
+	for _, route := range cfg.Routes {
+		if route.AutoValidators.On() {
+			autoValidators[route.AutoValidators] = newValidatorCache(route.AutoValidators)
+		}
+	}
+
+	mirrors := make(map[*config.Mirror]*mirror)
+	for _, route := range cfg.Routes {
+		if route.Mirror != nil {
+			mirrors[route.Mirror] = newMirror(route.Mirror)
 		}
-		upstreams[u.Name] = loadbalancer.New(u.LoadBalance, targets)
 	}
 
 	rl := ratelimit.NewRateLimiter(ratelimit.Config{
@@ -62,174 +229,586 @@ func New(cfg *config.Config) (*Proxy, error) {
 		LatencyBuckets: cfg.Metrics.LatencyBuckets,
 	})
 
-	apiKeys := make(map[string]*config.APIKey)
-	for i := range cfg.APIKeys {
-		key := &cfg.APIKeys[i]
-		if key.Enabled {
-			apiKeys[key.Key] = key
-			rl.SetLimits("apikey:"+key.Key, key.RequestsPerSecond, key.BurstSize)
-		}
+	apiKeys := indexAPIKeys(cfg.APIKeys)
+	for _, k := range apiKeys {
+		rl.SetLimits("apikey:"+k.key.Name, k.key.RequestsPerSecond, k.key.BurstSize)
 	}
 
 	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			DialContext: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-		},
-	}
-
-	return &Proxy{
-		router:       r,
-		upstreams:    upstreams,
-		rateLimiter:  rl,
-		metrics:      m,
-		usageTracker: metrics.NewUsageTracker(),
-		apiKeys:      apiKeys,
-		config:       cfg,
-		httpClient:   httpClient,
-	}, nil
-}
-
-func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+		Timeout:       30 * time.Second,
+		Transport:     newTransport(""),
+		CheckRedirect: relayRedirects,
+	}
+
+	// Upstreams that need a different protocol, TLS or transport settings
+	// get their own client.
+	clients := make(map[string]*http.Client)
+	upstreamTLS := make(map[string]*tls.Config)
+	var clientCerts []*clientCertificate
+	for _, u := range cfg.Upstreams {
+		if (u.Protocol == "" || u.Protocol == "http1") && u.TLS == nil && u.Transport == nil {
+			continue
+		}
+		if upstreamErrs[u.Name] != nil {
+			continue
+		}
+		transport := newTransport(u.Protocol)
+		if u.Transport != nil {
+			tuneTransport(transport, u.Transport)
+		}
+		if u.TLS != nil {
+			tlsConfig, cert, err := upstreamTLSConfig(u.Name, u.TLS, slog.Default())
+			if err != nil {
+				upstreamErrs[u.Name] = fmt.Errorf("tls: %w", err)
+				delete(upstreams, u.Name)
+				continue
+			}
+			transport.TLSClientConfig = tlsConfig
+			upstreamTLS[u.Name] = tlsConfig
+			if cert != nil {
+				clientCerts = append(clientCerts, cert)
+			}
+		}
+		clients[u.Name] = &http.Client{
+			Timeout:       30 * time.Second,
+			Transport:     transport,
+			CheckRedirect: relayRedirects,
+		}
+	}
+
+	var errs []error
+	for _, u := range cfg.Upstreams {
+		if err := upstreamErrs[u.Name]; err != nil && !cfg.Server.PartialStart {
+			errs = append(errs, fmt.Errorf("upstream %s: %w", u.Name, err))
+		}
+	}
+	if err := errors.Join(append(errs, routeErrs...)...); err != nil {
+		return nil, err
+	}
+
+	errorTemplates, err := newErrorTemplates(cfg.ErrorResponses)
+	if err != nil {
+		return nil, err
+	}
+
+	allowIPs, err := config.ParseIPPrefixes(cfg.Server.AllowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("server allow_ips: %w", err)
+	}
+	denyIPs, err := config.ParseIPPrefixes(cfg.Server.DenyIPs)
+	if err != nil {
+		return nil, fmt.Errorf("server deny_ips: %w", err)
+	}
+	trustedProxies, err := config.ParseIPPrefixes(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("server trusted_proxies: %w", err)
+	}
+
+	p := &Proxy{
+		router:         r,
+		upstreams:      upstreams,
+		rateLimiter:    rl,
+		rateLimits:     rl,
+		metrics:        m,
+		usageTracker:   metrics.NewUsageTracker(),
+		apiKeys:        apiKeys,
+		apiKeyNames:    apiKeysByName(apiKeys),
+		apiKeyParams:   apiKeyQueryParams(cfg.Server.StripCredentials),
+		config:         cfg,
+		httpClient:     httpClient,
+		clients:        clients,
+		upstreamTLS:    upstreamTLS,
+		clientCerts:    clientCerts,
+		logger:         slog.Default(),
+		panics:         newPanicGuard(cfg.Server.PanicThreshold),
+		events:         events.NewBus(m),
+		bodyMatchers:   bodyMatchers,
+		mirrors:        mirrors,
+		rewrites:       rewrites,
+		validators:     validators,
+		idempotency:    idempotencyKeys,
+		autoValidators: autoValidators,
+		repeats:        repeats,
+		errorTemplates: errorTemplates,
+		allowIPs:       allowIPs,
+		trustedProxies: trustedProxies,
+		denyIPs:        denyIPs,
+		copyBuffers:    newBufferPool(cfg.Server.CopyBufferSize),
+		basePath:       pathprefix.New(cfg.Server.BasePath),
+	}
+	p.stages = p.newStages()
+	if len(cfg.Server.DeniedMethods) > 0 {
+		p.deniedMethods = make(map[string]bool)
+		for _, m := range cfg.Server.DeniedMethods {
+			p.deniedMethods[strings.ToUpper(m)] = true
+		}
+	}
+	if cfg.Server.TLS != nil {
+		p.deniedFingerprints = make(map[string]bool)
+		for _, fp := range cfg.Server.TLS.DenyFingerprints {
+			p.deniedFingerprints[strings.ToLower(fp)] = true
+		}
+	}
+	if cfg.FlightRecorder.Enabled {
+		p.recorder = flightrecorder.New(cfg.FlightRecorder.Size)
+	}
+	if err := p.setupCapture(); err != nil {
+		return nil, err
+	}
+	if err := p.setupAccessLog(); err != nil {
+		return nil, err
+	}
+	if err := p.setupMode(); err != nil {
+		return nil, err
+	}
+	if err := p.setupActivity(); err != nil {
+		return nil, err
+	}
+	if cfg.Tracing.Enabled {
+		p.tracer = tracing.New(tracing.Config{
+			Endpoint:    cfg.Tracing.Endpoint,
+			SampleRatio: cfg.Tracing.SampleRatio,
+			ServiceName: cfg.Tracing.ServiceName,
+			Metrics:     m,
+			Logger:      p.logger,
+		})
+	}
+	p.setupDegradation()
+	p.setupPartialStart(upstreamErrs)
+	p.setupInitialHealth()
+	p.setupBodyLimits()
+	p.setupRouteDebug()
+	p.setupMaintenance()
+	p.setupRouteEnabled()
+	p.SetFeatureFlags(cfg.FeatureFlags)
+	p.setupConcurrency()
+	p.setupAdaptiveConcurrency()
+	p.setupSecurityHeaders()
+	p.setupUpstreamTokens()
+	p.setupCaches()
+	p.setupJWT()
+	p.ConfigApplied(cfg, "startup")
+	p.secretsPolicy = newErrorPolicy("secrets", cfg.Secrets.OnError, p.metrics, p.logger)
+	p.rateLimitPolicy = newErrorPolicy("rate_limit", cfg.RateLimit.OnError, p.metrics, p.logger)
+	p.localQuotas = quota.NewMemoryStore()
+	p.quotas = p.localQuotas
+	p.quotaPolicy = newErrorPolicy("quota", cfg.Quota.OnError, p.metrics, p.logger)
+	p.clusterProviders = []cluster.Provider{
+		&rateLimitProvider{limiter: rl},
+		newHealthProvider(upstreams),
+	}
+
+	return p, nil
+}
+
+func (p *Proxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
-	route := p.router.Match(r)
+	r, requestID := withRequestID(r)
+	rw.Header().Set(requestIDHeader, requestID)
+	w := &statusWriter{ResponseWriter: rw, security: p.securityHeadersFor(nil)}
+
+	var timing *requestTiming
+	if p.recorder != nil {
+		r, timing = withTiming(r)
+	}
+	var span *tracing.Span
+	if p.tracer != nil {
+		r, span = p.tracer.StartServer(r)
+		span.SetAttributes(
+			tracing.Attr{Key: "http.request.method", Value: r.Method},
+			tracing.Attr{Key: "url.path", Value: r.URL.Path},
+		)
+		defer func() { span.End(w.status) }()
+	}
+
+	// The access line is written last, once the response has been copied
+	// and any panic answered.
+	req := &pipelineRequest{
+		w:     w,
+		r:     r,
+		start: start,
+		probe: synthetic.IsProbe(r),
+	}
+	if p.accessLog != nil {
+		body := countRequestBody(r)
+		defer p.logAccess(req, body)
+	}
+	r = withExpectContinue(r)
+	req.r = r
+	// Panics once the request is routed are handled below, with the route;
+	// this catches those raised before.
+	defer func() {
+		if v := recover(); v != nil {
+			p.handlePanic(w, r, v, "unknown", "")
+		}
+	}()
+
+	if err := router.NormalizePath(r.URL, p.rawPaths()); err != nil {
+		p.metrics.RecordError("unknown", "invalid_path")
+		p.writeError(w, http.StatusBadRequest, "invalid_path", "request path escapes the root")
+		return
+	}
+	match := p.router.Resolve(r)
+	route := match.Route
+	if route == nil && match.MethodMismatch != nil {
+		p.metrics.RecordError(routeNameOf(match.MethodMismatch), "method_not_allowed")
+		w.Header().Set("Allow", strings.Join(p.allowedMethods(match.MethodMismatch), ", "))
+		p.writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	if match.OverrideDenied != nil {
+		p.metrics.RecordError(routeNameOf(match.OverrideDenied), "method_override_denied")
+		p.writeError(w, http.StatusBadRequest, "method_override_denied", "method override not allowed")
+		return
+	}
 	if route == nil {
 		p.metrics.RecordError("unknown", "not_found")
-		http.Error(w, "Not Found", http.StatusNotFound)
+		p.writeError(w, http.StatusNotFound, "not_found", "no route matches the request")
 		return
 	}
+	timing.observe(phaseRouteMatch, time.Since(start))
 
-	routeName := route.Name
-	if routeName == "" {
-		routeName = route.Pattern
+	routeName := routeNameOf(route)
+	w.security = p.securityHeadersFor(route)
+	if route.TrailingSlash == config.TrailingSlashRedirect {
+		if canonical, changed := route.CanonicalPath(r.URL.EscapedPath()); changed {
+			p.redirectTrailingSlash(w, r, canonical)
+			return
+		}
+	}
+	applyMethodChanges(r, route, match.Method)
+	r = r.WithContext(router.WithRoute(r.Context(), route))
+	req.r = r
+	if span != nil {
+		traceRoute(span, r, route, routeName)
 	}
 
 	done := p.metrics.InFlightRequests(routeName)
 	defer done()
+	req.clientIP = p.clientIP(r)
+	r, req.inflight = p.inflight.add(r, routeName, req.clientIP, start)
+	req.r = r
+	defer p.inflight.remove(req.inflight)
+	if route.Owner != "" {
+		defer func() { p.metrics.RecordOwnerRequest(route.Owner, w.status) }()
+	}
+	defer func() { p.activity.record(routeName, w.status) }()
 
-	clientIP := getClientIP(r)
-	apiKey, apiKeyName := p.extractAPIKey(r)
-
-	if p.config.RateLimit.Enabled {
-		if !p.checkRateLimits(w, r, route, clientIP, apiKey, routeName) {
-			return
+	req.route, req.routeName = route, routeName
+	if req.debug = p.debug.session(routeName); req.debug != nil {
+		if timing == nil {
+			r, timing = withTiming(r)
+			req.r = r
 		}
+		defer p.logDebugRequest(req, timing)
+	}
+	if p.recorder != nil {
+		defer func() {
+			p.recordFlight(r, w.status, start, timing, routeName, route.Upstream, req.targetURL, req.debug != nil)
+		}()
 	}
+	defer req.finish()
+	defer func() {
+		if v := recover(); v != nil {
+			p.handlePanic(w, r, v, routeName, req.targetURL)
+		}
+	}()
 
-	lb, ok := p.upstreams[route.Upstream]
-	if !ok {
-		p.metrics.RecordError(routeName, "upstream_not_found")
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	// Archived routes answer before anything else, so their clients learn
+	// the route is gone rather than why it failed.
+	if p.serveArchived(req) {
+		return
+	}
+	if p.panics.isTripped(routeName) {
+		p.metrics.RecordError(routeName, "route_tripped")
+		p.writeError(w, http.StatusServiceUnavailable, "route_tripped", "route disabled after repeated failures")
 		return
 	}
 
-	target := lb.Next()
-	if target == nil {
-		p.metrics.RecordError(routeName, "no_healthy_upstream")
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	// Routes in maintenance answer before the load balancer, and before
+	// rate limits and authentication, so clients get the notice whatever
+	// they send.
+	if p.serveMaintenance(req) {
+		return
+	}
+	if p.rejectDeniedMethod(req) {
+		return
+	}
+	if p.rejectReadOnly(req) {
 		return
 	}
 
-	target.Connections.Add(1)
-	defer target.Connections.Add(-1)
+	if !p.admitDegraded(req) {
+		return
+	}
 
-	statusCode, err := p.proxyRequest(w, r, route, target)
-	duration := time.Since(start)
-	isError := statusCode >= 400
+	req.fingerprint = tlsfp.FromContext(r.Context())
+	if req.fingerprint != "" {
+		p.metrics.RecordTLSFingerprint(req.fingerprint)
+	}
+	req.apiKey, req.apiKeyName = p.extractAPIKey(r)
 
-	p.metrics.RecordRequest(routeName, r.Method, statusCode, duration)
-	p.metrics.RecordUpstreamDuration(route.Upstream, duration)
-	p.usageTracker.RecordRequest(routeName, duration, isError)
+	// Client IP filtering and authentication run ahead of every stage, so
+	// requests that will be rejected never draw on a rate limit bucket.
+	if !p.checkClientIP(w, req) {
+		return
+	}
+	if p.requiresAPIKey(route) && !p.checkAPIKey(w, req) {
+		return
+	}
+	if route.RequireJWT && !p.checkJWT(w, req) {
+		return
+	}
 
-	if apiKeyName != "" {
-		p.metrics.RecordAPIKeyRequest(apiKeyName, statusCode)
-		p.usageTracker.RecordRequest("apikey:"+apiKeyName, duration, isError)
+	p.runChain(req)
+}
+
+// RouteFor returns the name of the route r is served by, or "" if none
+// matches. It does not serve r.
+func (p *Proxy) RouteFor(r *http.Request) string {
+	r = r.Clone(r.Context())
+	if err := p.routingPath(r.URL); err != nil {
+		return ""
+	}
+	if route := p.router.Resolve(r).Route; route != nil {
+		return routeNameOf(route)
 	}
+	return ""
+}
 
-	if err != nil {
-		p.metrics.RecordError(routeName, "proxy_error")
+// applyMethodChanges gives r the method it was routed with, after a method
+// override, and then the route's method_map, so stages, metrics and the
+// upstream all see the effective method.
+func applyMethodChanges(r *http.Request, route *router.Route, method string) {
+	if method != r.Method {
+		r.Method = method
+		r.Header.Del(router.MethodOverrideHeader)
 	}
+	r.Method = mappedMethod(route, r.Method)
 }
 
-func (p *Proxy) checkRateLimits(w http.ResponseWriter, r *http.Request, route *router.Route, clientIP, apiKey, routeName string) bool {
-	if route.RateLimit != nil && route.RateLimit.Enabled {
-		key := "route:" + routeName
-		if !p.rateLimiter.AllowWithLimits(key, route.RateLimit.RequestsPerSecond, route.RateLimit.BurstSize) {
-			p.metrics.RecordRateLimitHit(routeName, "route")
-			w.Header().Set("Retry-After", "1")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return false
-		}
+// mappedMethod returns the method route's method_map turns method into.
+func mappedMethod(route *router.Route, method string) string {
+	if mapped, ok := route.MethodMap[method]; ok {
+		return mapped
 	}
+	return method
+}
 
-	if p.config.RateLimit.PerAPIKey && apiKey != "" {
-		key := "apikey:" + apiKey
-		if !p.rateLimiter.Allow(key) {
-			p.metrics.RecordRateLimitHit(routeName, "apikey")
-			w.Header().Set("Retry-After", "1")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return false
-		}
+// redirectTrailingSlash sends the client to path, the canonical form of the
+// request's path under a trailing_slash: redirect route, keeping the query.
+// Methods other than GET and HEAD get a 308 so that they are not turned
+// into GETs.
+func (p *Proxy) redirectTrailingSlash(w http.ResponseWriter, r *http.Request, path string) {
+	location := p.basePath.Join(path)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(status)
+}
+
+// rawPaths reports whether routes match the request path as encoded.
+func (p *Proxy) rawPaths() bool {
+	return p.config.Server.PathMatching == config.PathMatchingRaw
+}
+
+// routeNameOf returns the name route is reported under in metrics and logs.
+func routeNameOf(route *router.Route) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return route.Pattern
+}
+
+// proxyErrorType classifies an error returned by proxyRequest for metrics.
+func proxyErrorType(err error) string {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return "body_too_large"
+	}
+	if errors.Is(err, errRequestDecompression) {
+		return "decompression_failed"
+	}
+	if errors.Is(err, errDeadlineExhausted) {
+		return "deadline_exhausted"
+	}
+	if errors.Is(err, errContentTypeRejected) {
+		return "content_type_rejected"
+	}
+	if errors.Is(err, errUpstreamAuthUnavailable) {
+		return "upstream_auth_unavailable"
 	}
+	if errors.Is(err, errUpstreamStalled) {
+		return "upstream_stalled"
+	}
+	return "proxy_error"
+}
+
+// maxBodySize returns the request body limit for route, falling back to the
+// server-wide limit. Zero means unlimited.
+func (p *Proxy) maxBodySize(route *router.Route) int64 {
+	if route.MaxBodySize > 0 {
+		return route.MaxBodySize
+	}
+	return p.config.Server.MaxBodySize
+}
 
-	if p.config.RateLimit.PerIP && clientIP != "" {
-		key := "ip:" + clientIP
-		if !p.rateLimiter.Allow(key) {
-			p.metrics.RecordRateLimitHit(routeName, "ip")
+// requiresAPIKey reports whether route only serves requests with an enabled
+// API key, falling back to the server-wide setting.
+func (p *Proxy) requiresAPIKey(route *router.Route) bool {
+	if route.RequireAPIKey != nil {
+		return *route.RequireAPIKey
+	}
+	return p.config.Server.RequireAPIKey
+}
+
+// checkAPIKey answers 401 when the request carries no API key and 403 when
+// the key is unknown or disabled. While secret refreshes fail it follows
+// secrets.on_error instead.
+func (p *Proxy) checkAPIKey(w http.ResponseWriter, req *pipelineRequest) bool {
+	switch p.secretsPolicy.failingAction() {
+	case config.OnErrorAllow:
+		return true
+	case config.OnErrorDeny:
+		p.metrics.RecordError(req.routeName, "auth_unavailable")
+		p.writeError(w, http.StatusServiceUnavailable, "auth_unavailable", "authentication unavailable")
+		return false
+	}
+	if req.apiKeyName != "" {
+		return true
+	}
+	p.metrics.RecordError(req.routeName, "auth_failed")
+	if req.apiKey == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="relaypoint"`)
+		p.writeError(w, http.StatusUnauthorized, "auth_failed", "missing API key")
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="relaypoint", error="invalid_token"`)
+	p.writeError(w, http.StatusForbidden, "auth_failed", "invalid API key")
+	return false
+}
+
+// checkRateLimits takes a token from every limiter resolveRateLimits
+// lists for the request, in order, and answers 429 at the first one that
+// is empty. When the store fails it follows rate_limit.on_error.
+func (p *Proxy) checkRateLimits(w http.ResponseWriter, route *router.Route, clientIP, apiKeyName, fingerprint, routeName string) bool {
+	for _, l := range p.resolveRateLimits(route.RateLimit, routeName, apiKeyName, clientIP, fingerprint) {
+		allowed, err := p.rateLimits.Take(l.Key, l.RequestsPerSecond, l.BurstSize)
+		p.rateLimitPolicy.observe(err)
+		if err != nil {
+			switch p.rateLimitPolicy.action {
+			case config.OnErrorAllow:
+				continue
+			case config.OnErrorDeny:
+				p.metrics.RecordError(routeName, "rate_limit_unavailable")
+				p.writeError(w, http.StatusServiceUnavailable, "rate_limit_unavailable", "rate limiting unavailable")
+				return false
+			}
+			// Best effort: the limits this process enforces on its own.
+			allowed = p.rateLimiter.AllowWithLimits(l.Key, l.RequestsPerSecond, l.BurstSize)
+		}
+		if !allowed {
+			p.metrics.RecordRateLimitHit(routeName, l.Scope)
 			w.Header().Set("Retry-After", "1")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			p.writeError(w, http.StatusTooManyRequests, "rate_limited", "rate limited")
 			return false
 		}
 	}
-
 	return true
 }
 
 func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, route *router.Route, target *loadbalancer.Target) (int, error) {
-	upstreamURL := *target.URL
-	path := route.StripPrefix(r.URL.Path)
-	upstreamURL.Path = singleJoiningSlash(upstreamURL.Path, path)
-	upstreamURL.RawQuery = r.URL.RawQuery
+	if isWebSocketRequest(r) {
+		return p.proxyWebSocket(w, r, route, target)
+	}
 
 	ctx := r.Context()
-	upstreamReq, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL.String(), r.Body)
+	if timeout := p.requestTimeout(route, target); timeout > 0 {
+		timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		r = r.WithContext(timeoutCtx)
+	}
+	var abortStalled context.CancelCauseFunc
+	if route.ResponseIdleTimeout > 0 {
+		stallCtx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		r, abortStalled = r.WithContext(stallCtx), cancel
+	}
+	upstreamReq, err := p.newAuthorizedRequest(r, route, target, route.Upstream)
 	if err != nil {
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		switch {
+		case ctx.Err() == context.Canceled:
+			return 499, err // Client Closed Request
+		case errors.Is(err, errUpstreamAuthUnavailable) || r.Context().Err() != nil:
+			p.writeError(w, http.StatusServiceUnavailable, "upstream_auth_unavailable", "upstream authentication unavailable")
+			return http.StatusServiceUnavailable, err
+		}
+		p.writeError(w, http.StatusBadGateway, "proxy_error", "bad gateway")
 		return http.StatusBadGateway, err
 	}
+	if err := stampDeadline(upstreamReq, route); err != nil {
+		p.writeError(w, http.StatusGatewayTimeout, "deadline_exhausted", "gateway timeout")
+		return http.StatusGatewayTimeout, err
+	}
 
-	copyHeaders(upstreamReq.Header, r.Header)
+	timing := timingFrom(ctx)
+	upstreamReq = timing.traceRequest(upstreamReq)
+	upstreamReq, stopRelay := relayInformational(w, upstreamReq)
+	span := p.startUpstreamSpan(upstreamReq, route, target)
 
-	for k, v := range route.Headers {
-		upstreamReq.Header.Set(k, v)
+	inflight := inflightFrom(ctx)
+	inflight.setPhase(inflightAwaitingHeaders)
+	observeUpstream := func(d time.Duration) {
+		p.metrics.RecordUpstreamDuration(route.Upstream, d)
+		inflight.setUpstreamDuration(d)
 	}
-
-	clientIP := getClientIP(r)
-	if prior := upstreamReq.Header.Get("X-Forwarded-For"); prior != "" {
-		upstreamReq.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+	var resp *http.Response
+	upstreamStart := time.Now()
+	if hedgeable(r, route) {
+		resp, err = p.doHedged(r, route, target, upstreamReq)
 	} else {
-		upstreamReq.Header.Set("X-Forwarded-For", clientIP)
+		resp, err = p.clientFor(route.Upstream).Do(upstreamReq)
 	}
-
-	upstreamReq.Header.Set("X-Forwarded-Host", r.Host)
-	upstreamReq.Header.Set("X-Forwarded-Proto", getScheme(r))
-	upstreamReq.Header.Set("X-Real-IP", clientIP)
-
-	removeHopHeaders(upstreamReq.Header)
-
-	resp, err := p.httpClient.Do(upstreamReq)
+	if err == nil {
+		resp, err = p.retryUnauthorized(upstreamReq, route, route.Upstream, resp)
+	}
+	stopRelay()
+	roundTrip := time.Since(upstreamStart)
 	if err != nil {
+		observeUpstream(roundTrip)
+		span.SetError(err)
+		span.End(0)
+		if context.Cause(ctx) == errRequestCanceled {
+			p.writeError(w, http.StatusServiceUnavailable, "request_canceled", "request canceled")
+			return http.StatusServiceUnavailable, err
+		}
 		if ctx.Err() == context.Canceled {
 			return 499, err // Client Closed Request
 		}
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		if r.Context().Err() == context.DeadlineExceeded {
+			p.writeError(w, http.StatusGatewayTimeout, "upstream_timeout", "gateway timeout")
+			return http.StatusGatewayTimeout, err
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			p.writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large")
+			return http.StatusRequestEntityTooLarge, err
+		}
+		if errors.Is(err, errDeadlineExhausted) {
+			p.writeError(w, http.StatusGatewayTimeout, "deadline_exhausted", "gateway timeout")
+			return http.StatusGatewayTimeout, err
+		}
+		if errors.Is(err, errRequestDecompression) {
+			p.writeError(w, http.StatusBadRequest, "decompression_failed", "request body could not be decompressed")
+			return http.StatusBadRequest, err
+		}
+		p.writeError(w, http.StatusBadGateway, "proxy_error", "bad gateway")
 		return http.StatusBadGateway, err
 	}
 	defer func() {
@@ -239,13 +818,184 @@ func (p *Proxy) proxyRequest(w http.ResponseWriter, r *http.Request, route *rout
 		}
 	}()
 
-	copyHeaders(w.Header(), resp.Header)
-	removeHopHeaders(w.Header())
+	if rv := cacheRevalidationFrom(ctx); rv != nil && resp.StatusCode == http.StatusNotModified {
+		observeUpstream(roundTrip)
+		span.End(resp.StatusCode)
+		p.serveRevalidated(w, r, route, rv, resp)
+		return http.StatusOK, nil
+	}
+	if !p.checkResponseContentType(route, resp) {
+		observeUpstream(roundTrip)
+		span.End(resp.StatusCode)
+		p.writeError(w, http.StatusBadGateway, "content_type_rejected", "bad gateway")
+		return http.StatusBadGateway, errContentTypeRejected
+	}
+	if abortStalled != nil {
+		body := newIdleTimeoutBody(resp.Body, route.ResponseIdleTimeout, abortStalled)
+		resp.Body = body
+		if err := body.prime(); err != nil {
+			observeUpstream(time.Since(upstreamStart))
+			span.SetError(err)
+			span.End(http.StatusGatewayTimeout)
+			p.writeError(w, http.StatusGatewayTimeout, "upstream_stalled", "gateway timeout")
+			return http.StatusGatewayTimeout, err
+		}
+	}
+	p.injectValidators(r, route, resp)
+	fill := p.cacheFill(r, route, resp)
+	idempotent := p.idempotencyFill(r, resp)
+	if route.ServerTiming && inflight != nil {
+		// After the fills, so stored copies don't carry this request's
+		// timings.
+		addServerTiming(resp.Header, inflight.start, roundTrip)
+	}
+	inflight.setPhase(inflightCopyingBody)
+	copyStart := time.Now()
+	err = p.writeResponse(w, r, route, resp)
+	observeUpstream(roundTrip + time.Since(copyStart))
+	fill.finish()
+	idempotent.finish()
+	if errors.Is(err, errUpstreamStalled) {
+		span.SetError(err)
+		span.End(resp.StatusCode)
+		return resp.StatusCode, errResponseTruncated
+	}
+	span.End(resp.StatusCode)
+
+	return resp.StatusCode, nil
+}
+
+// writeResponse relays resp to the client through the route's response
+// header rules and compression. It returns the error that ended the body
+// copy early, if any.
+func (p *Proxy) writeResponse(w http.ResponseWriter, r *http.Request, route *router.Route, resp *http.Response) error {
+	copyEndToEndHeaders(w.Header(), resp.Header)
+	if loc := w.Header().Get("Location"); loc != "" && p.basePath != "" {
+		w.Header().Set("Location", p.basePath.Location(loc))
+	}
+	// Under overload, the first degradation level to give up is response
+	// transforms.
+	transform := !p.degraded(route, config.DegradeDisableTransforms)
+	if transform {
+		applyHeaderRules(w.Header(), route.ResponseHeaders)
+	}
+	if route.ConfigFingerprintHeader {
+		w.Header().Set(configFingerprintHeader, p.ConfigFingerprint())
+	}
+	announceTrailers(w.Header(), resp.Trailer)
+	compress := transform && prepareCompression(w.Header(), r, resp, route.Compression)
 
 	w.WriteHeader(resp.StatusCode)
-	_, _ = io.Copy(w, resp.Body)
+	copyStart := time.Now()
+	// The buffer goes back to the pool however the copy ends; nothing the
+	// body passes through keeps a slice of it.
+	buf := p.copyBuffers.get()
+	defer p.copyBuffers.put(buf)
+	var err error
+	if compress {
+		gz := newGzipResponseWriter(w, compressionLevel(route.Compression))
+		err = copyResponse(gz, resp, route, *buf)
+		_ = gz.Close()
+	} else {
+		err = copyResponse(w, resp, route, *buf)
+	}
+	timingFrom(r.Context()).observe(phaseBodyCopy, time.Since(copyStart))
+	copyTrailers(w.Header(), resp.Trailer)
+	return err
+}
 
-	return resp.StatusCode, nil
+// newUpstreamRequest builds the outbound request for target, carrying over the
+// client headers, route headers and the X-Forwarded-* set.
+func (p *Proxy) newUpstreamRequest(r *http.Request, route *router.Route, target *loadbalancer.Target) (*http.Request, error) {
+	upstreamURL := *target.URL
+	path := route.StripPrefix(r.URL.Path)
+	if route.UpstreamPath != "" {
+		// Built from the whole path, whether the route strips it or not.
+		path = upstreamPath(route, r.URL.Path)
+	} else if rw, ok := p.rewrites[route.Rewrite]; ok {
+		path = rw.apply(path, route.PathParams)
+	}
+	upstreamURL.Path = singleJoiningSlash(upstreamURL.Path, path)
+	if p.rawPaths() && route.Rewrite == nil && route.UpstreamPath == "" {
+		// Forward the client's encoding, which the route matched; if it
+		// does not survive stripping, the path is encoded afresh.
+		upstreamURL.RawPath = singleJoiningSlash(target.URL.EscapedPath(), route.StripPrefix(r.URL.EscapedPath()))
+	}
+	upstreamURL.RawQuery = r.URL.RawQuery
+	strip := p.config.Server.StripCredentials
+	if strip.Enabled {
+		upstreamURL.RawQuery = stripQueryParams(upstreamURL.RawQuery, p.apiKeyParams)
+	}
+
+	upstreamReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL.String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the client's framing: a known length stays a Content-Length body
+	// rather than being re-sent chunked.
+	upstreamReq.ContentLength = r.ContentLength
+	// The server fills in the client's trailers as the body is read, which
+	// the transport finishes before writing them.
+	if len(r.Trailer) > 0 {
+		upstreamReq.Trailer = r.Trailer
+	}
+
+	switch {
+	case route.PreserveHost:
+		upstreamReq.Host = r.Host
+	case route.UpstreamHost != "":
+		upstreamReq.Host = route.UpstreamHost
+	}
+
+	// Hop-by-hop headers are dropped before anything is added, so a
+	// Connection token cannot remove a header the gateway sets.
+	copyEndToEndHeaders(upstreamReq.Header, r.Header)
+	// Before the route's own headers and upstream_auth, which may set
+	// Authorization deliberately.
+	if strip.Enabled && strip.Headers {
+		stripCredentialHeaders(upstreamReq.Header)
+	}
+
+	for k, v := range route.Headers {
+		upstreamReq.Header.Set(k, substituteParams(v, route.PathParams, nil))
+	}
+	applyHeaderRules(upstreamReq.Header, route.RequestHeaders)
+	setUpstreamAuth(upstreamReq.Header, route.UpstreamAuth)
+
+	if route.WireFidelity {
+		// Keep net/http from adding a User-Agent the client never sent.
+		if _, ok := upstreamReq.Header["User-Agent"]; !ok {
+			upstreamReq.Header["User-Agent"] = []string{""}
+		}
+		// Only a trusted proxy's forwarding headers are passed on as
+		// received; anyone else's are replaced as on any other route.
+		if peer, _ := parseClientIP(r.RemoteAddr); hasForwardedHeaders(r.Header) && !p.trustedProxy(peer) {
+			p.setForwardedHeaders(upstreamReq, r, route)
+		}
+	} else {
+		p.setForwardedHeaders(upstreamReq, r, route)
+	}
+
+	// Hop-by-hop headers the route's own settings added are dropped too.
+	removeHopHeaders(upstreamReq.Header)
+	if rv := cacheRevalidationFrom(r.Context()); rv != nil {
+		rv.setConditions(upstreamReq.Header)
+	}
+	// The body is framed by the transport alone, from ContentLength: a
+	// known length as Content-Length, otherwise chunked, never both.
+	upstreamReq.Header.Del("Content-Length")
+	upstreamReq.TransferEncoding = nil
+	// The upstream decides on 100 Continue only while the client still
+	// waits for it; once a stage has read the body it has had its 100.
+	if !awaitsContinue(r) {
+		upstreamReq.Header.Del("Expect")
+	}
+	if headerHasToken(r.Header, "Te", "trailers") {
+		// The only TE value allowed over HTTP/2, and required by gRPC.
+		upstreamReq.Header.Set("Te", "trailers")
+	}
+
+	return upstreamReq, nil
 }
 
 func (p *Proxy) extractAPIKey(r *http.Request) (key string, name string) {
@@ -260,12 +1010,29 @@ func (p *Proxy) extractAPIKey(r *http.Request) (key string, name string) {
 		key = r.Header.Get("X-API-Key")
 	}
 
-	if key == "" {
-		key = r.URL.Query().Get("api_key")
+	if key == "" && r.URL.RawQuery != "" {
+		query := r.URL.Query()
+		for _, param := range p.apiKeyParams {
+			if key = query.Get(param); key != "" {
+				break
+			}
+		}
 	}
 
 	if key != "" {
-		if apiKey, ok := p.apiKeys[key]; ok {
+		// The digest is compared with every configured one in constant
+		// time, so the time taken reveals nothing about the configured
+		// keys, not even how much of one matched.
+		digest := sha256.Sum256([]byte(key))
+		var apiKey *config.APIKey
+		p.apiKeysMu.RLock()
+		for _, k := range p.apiKeys {
+			if subtle.ConstantTimeCompare(k.digest[:], digest[:]) == 1 && apiKey == nil {
+				apiKey = k.key
+			}
+		}
+		p.apiKeysMu.RUnlock()
+		if apiKey != nil {
 			return key, apiKey.Name
 		}
 	}
@@ -273,38 +1040,110 @@ func (p *Proxy) extractAPIKey(r *http.Request) (key string, name string) {
 	return key, ""
 }
 
-func getClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
+// apiKeyDigest is an enabled API key with the digest it is matched by.
+type apiKeyDigest struct {
+	digest [sha256.Size]byte
+	key    *config.APIKey
+}
 
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// indexAPIKeys lists the enabled keys with their digests. Keys are
+// validated with the configuration, so malformed hashes are skipped.
+func indexAPIKeys(keys []config.APIKey) []apiKeyDigest {
+	var index []apiKeyDigest
+	for i := range keys {
+		key := &keys[i]
+		if !key.Enabled {
+			continue
+		}
+		if digest, err := key.Digest(); err == nil {
+			index = append(index, apiKeyDigest{digest: digest, key: key})
+		}
 	}
+	return index
+}
 
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+func apiKeysByName(index []apiKeyDigest) map[string]*config.APIKey {
+	names := make(map[string]*config.APIKey, len(index))
+	for _, k := range index {
+		names[k.key.Name] = k.key
 	}
-	return ip
+	return names
 }
 
-func getScheme(r *http.Request) string {
+// setForwardedHeaders adds the optional headers the gateway injects for
+// upstreams: the X-Forwarded-* set and X-Real-IP or the Forwarded header,
+// as the route's forwarded mode asks, less the headers it omits, and the
+// request ID. The forwarded chain of a trusted proxy is extended with it
+// and its host, scheme and port are kept; anyone else's are replaced, so
+// upstreams never see values a client made up.
+func (p *Proxy) setForwardedHeaders(upstreamReq, r *http.Request, route *router.Route) {
+	clientIP := p.clientIP(r)
+	peer, _ := parseClientIP(r.RemoteAddr)
+	trusted := p.trustedProxy(peer)
+	upstreamReq.Header.Set(requestIDHeader, requestIDFrom(r.Context()))
+
+	fwd := p.forwardedFor(route)
+	if fwd.Mode == config.ForwardedStandard || fwd.Mode == config.ForwardedBoth {
+		setForwarded(upstreamReq.Header, r, peer, trusted, fwd.ObfuscateFor)
+	}
+	if fwd.Mode == config.ForwardedStandard {
+		for _, h := range config.ForwardedHeaders {
+			upstreamReq.Header.Del(h)
+		}
+		return
+	}
+
+	hops := forwardedHops(r.Header)
+	if trusted && len(hops) > 0 {
+		upstreamReq.Header.Set("X-Forwarded-For", strings.Join(hops, ", ")+", "+peer.String())
+	} else {
+		upstreamReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	scheme := "http"
 	if r.TLS != nil {
-		return "https"
+		scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); trusted && proto != "" {
+		scheme = proto
 	}
-	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
-		return proto
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); trusted && forwardedHost != "" {
+		host = forwardedHost
+	}
+	upstreamReq.Header.Set("X-Forwarded-Host", host)
+	upstreamReq.Header.Set("X-Forwarded-Proto", scheme)
+	upstreamReq.Header.Set("X-Forwarded-Port", forwardedPort(r, scheme, trusted))
+	if prefix := p.forwardedPrefix(r, route, trusted); prefix != "" {
+		upstreamReq.Header.Set("X-Forwarded-Prefix", prefix)
+	} else {
+		upstreamReq.Header.Del("X-Forwarded-Prefix")
+	}
+	upstreamReq.Header.Set("X-Real-IP", clientIP)
+	for _, h := range fwd.Omit {
+		upstreamReq.Header.Del(h)
 	}
-	return "http"
 }
 
+// hasForwardedHeaders reports whether h carries any of the headers
+// setForwardedHeaders sets from the client's address.
+func hasForwardedHeaders(h http.Header) bool {
+	if _, ok := h["Forwarded"]; ok {
+		return true
+	}
+	for _, name := range config.ForwardedHeaders {
+		if _, ok := h[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyHeaders appends every value in src to dst under the same key, exactly
+// as received: keys are not re-canonicalized and repeated fields keep their
+// relative order.
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
-		for _, v := range vv {
-			dst.Add(k, v)
-		}
+		dst[k] = append(dst[k], vv...)
 	}
 }
 
@@ -319,10 +1158,49 @@ var hopHeaders = []string{
 	"Upgrade",
 }
 
+// copyEndToEndHeaders is copyHeaders without the hop-by-hop headers of
+// src, which apply to a single connection and are never forwarded.
+func copyEndToEndHeaders(dst, src http.Header) {
+	hop := hopHeaderNames(src)
+	for k, vv := range src {
+		if !hop[strings.ToLower(k)] {
+			dst[k] = append(dst[k], vv...)
+		}
+	}
+}
+
+// removeHopHeaders deletes the hop-by-hop headers of h.
 func removeHopHeaders(h http.Header) {
+	hop := hopHeaderNames(h)
+	for k := range h {
+		if hop[strings.ToLower(k)] {
+			delete(h, k)
+		}
+	}
+}
+
+// hopHeaderNames returns the lower-cased names of h's hop-by-hop headers:
+// the standard ones and every header its Connection header lists (RFC
+// 7230, section 6.1). Keys are compared case-insensitively, since copied
+// headers keep the case they were received in.
+func hopHeaderNames(h http.Header) map[string]bool {
+	names := make(map[string]bool, len(hopHeaders))
 	for _, hdr := range hopHeaders {
-		h.Del(hdr)
+		names[strings.ToLower(hdr)] = true
 	}
+	for k, vv := range h {
+		if !strings.EqualFold(k, "Connection") {
+			continue
+		}
+		for _, v := range vv {
+			for _, token := range strings.Split(v, ",") {
+				if token = strings.TrimSpace(token); token != "" {
+					names[strings.ToLower(token)] = true
+				}
+			}
+		}
+	}
+	return names
 }
 
 func singleJoiningSlash(a, b string) string {
@@ -337,14 +1215,70 @@ func singleJoiningSlash(a, b string) string {
 	return a + b
 }
 
+// SecretsRefreshed reports the outcome of a secret refresh, so API key
+// checks follow secrets.on_error while the secret store is failing.
+func (p *Proxy) SecretsRefreshed(err error) {
+	p.secretsPolicy.observe(err)
+}
+
+// SetAPIKeys replaces the accepted API keys, e.g. after a secret rotation.
+// Rate limit buckets belong to key names, so a rotated key keeps the state
+// of the key it replaces.
+func (p *Proxy) SetAPIKeys(keys []config.APIKey) {
+	apiKeys := indexAPIKeys(keys)
+
+	p.apiKeysMu.Lock()
+	previous := p.apiKeys
+	p.apiKeys = apiKeys
+	p.apiKeyNames = apiKeysByName(apiKeys)
+	p.apiKeysMu.Unlock()
+
+	known := make(map[string]bool, len(previous))
+	for _, k := range previous {
+		known[k.key.Name] = true
+	}
+	for _, k := range apiKeys {
+		if !known[k.key.Name] {
+			known[k.key.Name] = true
+			p.rateLimiter.SetLimits("apikey:"+k.key.Name, k.key.RequestsPerSecond, k.key.BurstSize)
+		}
+	}
+}
+
 func (p *Proxy) Metrics() *metrics.Metrics {
 	return p.metrics
 }
 
+// Events returns the bus gateway state changes are published on.
+func (p *Proxy) Events() *events.Bus {
+	return p.events
+}
+
+// Upstreams returns the load balancers by upstream name, for the health
+// checker.
+func (p *Proxy) Upstreams() map[string]loadbalancer.LoadBalancer {
+	return p.upstreams
+}
+
 func (p *Proxy) UsageStats() []metrics.Stats {
 	return p.usageTracker.GetStats()
 }
 
 func (p *Proxy) Stop() {
 	p.rateLimiter.Stop()
+	close(p.ladderStop)
+	if p.capture != nil {
+		_ = p.capture.Close()
+	}
+	if p.accessLogFile != nil {
+		_ = p.accessLogFile.Close()
+	}
+	if p.tracer != nil {
+		p.tracer.Stop()
+	}
+	if p.initialHealthTimer != nil {
+		p.initialHealthTimer.Stop()
+	}
+	p.debug.stopAll()
+	p.stopActivity()
 }
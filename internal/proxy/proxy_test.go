@@ -0,0 +1,1031 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// newTestProxy builds a proxy with a single route and upstream pointing at
+// backendURL. mutate may adjust the config before the proxy is created.
+func newTestProxy(t *testing.T, backendURL string, mutate func(cfg *config.Config)) *Proxy {
+	t.Helper()
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.Enabled = false
+	cfg.RateLimit.CleanupInterval = 0
+	cfg.Upstreams = []config.Upstream{
+		{Name: "backend", Targets: []config.Target{{URL: backendURL}}},
+	}
+	cfg.Routes = []config.Route{
+		{Name: "test", Path: "/**", Upstream: "backend"},
+	}
+	if mutate != nil {
+		mutate(cfg)
+	}
+
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+// echoWebSocketBackend accepts an upgrade and echoes every byte it receives.
+func echoWebSocketBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(echoWebSocket)
+}
+
+// echoWebSocket is the handler of echoWebSocketBackend, for servers that
+// need more setup.
+var echoWebSocket = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if !isWebSocketRequest(r) {
+		http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	_ = buf.Flush()
+	_, _ = io.Copy(conn, buf)
+})
+
+func dialUpgrade(t *testing.T, gatewayURL string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gatewayURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	return conn, br
+}
+
+func TestProxy_WebSocketTunnel(t *testing.T) {
+	backend := echoWebSocketBackend(t)
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	conn, br := dialUpgrade(t, gateway.URL)
+
+	for _, msg := range []string{"hello", "world"} {
+		_, _ = io.WriteString(conn, msg)
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatalf("read echo: %v", err)
+		}
+		if string(got) != msg {
+			t.Errorf("expected echo %q, got %q", msg, got)
+		}
+	}
+}
+
+func TestProxy_WebSocketIdleTimeout(t *testing.T) {
+	backend := echoWebSocketBackend(t)
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].WebSocketIdleTimeout = 100 * time.Millisecond
+	})
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	conn, br := dialUpgrade(t, gateway.URL)
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("expected tunnel to close after idle timeout, got %v", err)
+	}
+}
+
+func TestProxy_WebSocketRejectedUpgrade(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected upstream 403 to be relayed, got %d", rec.Code)
+	}
+}
+
+func TestProxy_StreamsServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "data: second\n\n")
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	p := newTestProxy(t, backend.URL, nil)
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL + "/events")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := readLineWithin(bufio.NewReader(resp.Body), 2*time.Second)
+	if err != nil {
+		t.Fatalf("first event was not flushed: %v", err)
+	}
+	if line != "data: first\n" {
+		t.Errorf("unexpected first line %q", line)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *http.Response
+		route    time.Duration
+		expected time.Duration
+	}{
+		{"sse", &http.Response{Header: http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}, ContentLength: 10}, 0, -1},
+		{"chunked", &http.Response{Header: http.Header{}, TransferEncoding: []string{"chunked"}, ContentLength: 10}, 0, -1},
+		{"unknown length", &http.Response{Header: http.Header{}, ContentLength: -1}, 0, -1},
+		{"fixed length", &http.Response{Header: http.Header{}, ContentLength: 10}, 0, 0},
+		{"route override", &http.Response{Header: http.Header{}, ContentLength: 10}, 50 * time.Millisecond, 50 * time.Millisecond},
+	}
+
+	for _, tc := range tests {
+		route := &router.Route{FlushInterval: tc.route}
+		if got := flushInterval(tc.resp, route); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func readLineWithin(br *bufio.Reader, d time.Duration) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := br.ReadString('\n')
+		ch <- result{line, err}
+	}()
+	select {
+	case res := <-ch:
+		return res.line, res.err
+	case <-time.After(d):
+		return "", context.DeadlineExceeded
+	}
+}
+
+// rawBackend accepts one connection, records the request head byte for byte
+// and answers with an empty 200.
+func rawBackend(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	heads := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		var head strings.Builder
+		for {
+			line, err := br.ReadString('\n')
+			head.WriteString(line)
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		heads <- head.String()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	}()
+
+	return "http://" + ln.Addr().String(), heads
+}
+
+func TestProxy_WireFidelity(t *testing.T) {
+	backendURL, heads := rawBackend(t)
+	p := newTestProxy(t, backendURL, func(cfg *config.Config) {
+		cfg.Routes[0].WireFidelity = true
+	})
+
+	req := httptest.NewRequest("GET", "/orders?id=1", nil)
+	req.Header = http.Header{
+		"X-Trace":         {"first", "second"},
+		"Accept":          {"application/json"},
+		"X-Lower-Example": {"b", "a"},
+		"Connection":      {"keep-alive"},
+	}
+	req.Host = "api.example.com"
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	backendHost := strings.TrimPrefix(backendURL, "http://")
+	expected := "GET /orders?id=1 HTTP/1.1\r\n" +
+		"Host: " + backendHost + "\r\n" +
+		"Accept: application/json\r\n" +
+		"X-Lower-Example: b\r\n" +
+		"X-Lower-Example: a\r\n" +
+		"X-Trace: first\r\n" +
+		"X-Trace: second\r\n" +
+		"\r\n"
+
+	select {
+	case head := <-heads:
+		if head != expected {
+			t.Errorf("unexpected header block:\n%s\nexpected:\n%s", head, expected)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("backend never received the request")
+	}
+}
+
+func TestProxy_WireFidelityUntrustedForwardedHeaders(t *testing.T) {
+	forwarded := http.Header{
+		"X-Forwarded-For": {"10.0.0.1"},
+		"X-Real-Ip":       {"10.0.0.1"},
+	}
+	for _, tt := range []struct {
+		name   string
+		peer   string
+		want   []string
+		absent []string
+	}{
+		// A client's made-up addresses are replaced with its own.
+		{"untrusted", "192.0.2.1:1234", []string{"X-Forwarded-For: 192.0.2.1", "X-Real-Ip: 192.0.2.1"}, []string{"10.0.0.1"}},
+		// A trusted proxy's are forwarded as received.
+		{"trusted", "198.51.100.7:1234", []string{"X-Forwarded-For: 10.0.0.1", "X-Real-Ip: 10.0.0.1"}, []string{"X-Forwarded-Host", "X-Request-Id"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backendURL, heads := rawBackend(t)
+			p := newTestProxy(t, backendURL, func(cfg *config.Config) {
+				cfg.Routes[0].WireFidelity = true
+				cfg.Server.TrustedProxies = []string{"198.51.100.0/24"}
+			})
+
+			req := httptest.NewRequest("GET", "/orders", nil)
+			req.RemoteAddr = tt.peer
+			req.Header = forwarded.Clone()
+			p.ServeHTTP(httptest.NewRecorder(), req)
+
+			head := <-heads
+			for _, h := range tt.want {
+				if !strings.Contains(head, h+"\r\n") {
+					t.Errorf("expected %q in header block:\n%s", h, head)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(head, s) {
+					t.Errorf("unexpected %q in header block:\n%s", s, head)
+				}
+			}
+		})
+	}
+}
+
+func TestProxy_ForwardedHeadersWithoutWireFidelity(t *testing.T) {
+	backendURL, heads := rawBackend(t)
+	p := newTestProxy(t, backendURL, nil)
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	head := <-heads
+	for _, h := range []string{"X-Forwarded-For: 192.0.2.1", "X-Forwarded-Host: example.com", "X-Real-Ip: 192.0.2.1", "X-Request-Id: req-1"} {
+		if !strings.Contains(head, h+"\r\n") {
+			t.Errorf("expected %q in header block:\n%s", h, head)
+		}
+	}
+}
+
+func TestProxy_UpstreamFramingHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		want          string
+		absent        string
+	}{
+		{"known length", 5, "Content-Length: 5\r\n", "Transfer-Encoding"},
+		{"unknown length", -1, "Transfer-Encoding: chunked\r\n", "Content-Length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendURL, heads := rawBackend(t)
+			p := newTestProxy(t, backendURL, func(cfg *config.Config) {
+				// Header rules must not be able to add a second framing.
+				cfg.Routes[0].Headers = map[string]string{"Content-Length": "99", "Transfer-Encoding": "gzip, chunked"}
+			})
+
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader("hello"))
+			req.ContentLength = tt.contentLength
+			req.Header.Set("Content-Length", "7")
+			req.Header.Set("Transfer-Encoding", "identity")
+			p.ServeHTTP(httptest.NewRecorder(), req)
+
+			head := <-heads
+			if strings.Count(head, "Content-Length")+strings.Count(head, "Transfer-Encoding") != 1 {
+				t.Errorf("expected exactly one framing header:\n%s", head)
+			}
+			if !strings.Contains(head, tt.want) || strings.Contains(head, tt.absent) {
+				t.Errorf("expected %q and no %s in header block:\n%s", tt.want, tt.absent, head)
+			}
+		})
+	}
+}
+
+func TestProxy_ConnectionTokens(t *testing.T) {
+	backendURL, heads := rawBackend(t)
+	p := newTestProxy(t, backendURL, func(cfg *config.Config) {
+		cfg.Routes[0].WireFidelity = true
+	})
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header = http.Header{
+		"Connection":          {"X-FOO, keep-alive", "x-bar"},
+		"x-foo":               {"1"},
+		"X-Bar":               {"2"},
+		"keep-alive":          {"timeout=5"},
+		"PROXY-AUTHORIZATION": {"Basic Zm9vOmJhcg=="},
+		"X-Keep":              {"3"},
+	}
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	head := strings.ToLower(<-heads)
+	for _, h := range []string{"x-foo", "x-bar", "keep-alive", "proxy-authorization", "connection: x-foo"} {
+		if strings.Contains(head, h) {
+			t.Errorf("hop-by-hop header %s was forwarded:\n%s", h, head)
+		}
+	}
+	if !strings.Contains(head, "x-keep: 3\r\n") {
+		t.Errorf("end-to-end header was dropped:\n%s", head)
+	}
+}
+
+func TestProxy_ConnectionTokensDoNotRemoveGatewayHeaders(t *testing.T) {
+	backendURL, heads := rawBackend(t)
+	p := newTestProxy(t, backendURL, func(cfg *config.Config) {
+		cfg.Routes[0].Headers = map[string]string{"X-Gateway": "relaypoint"}
+	})
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Add("Connection", "x-gateway")
+	req.Header.Add("Connection", "X-Forwarded-For")
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	head := <-heads
+	for _, h := range []string{"X-Gateway: relaypoint", "X-Forwarded-For: 192.0.2.1"} {
+		if !strings.Contains(head, h+"\r\n") {
+			t.Errorf("expected %q in header block:\n%s", h, head)
+		}
+	}
+}
+
+func TestProxy_ResponseConnectionTokens(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Connection"] = []string{"X-Secret", "x-request-id, Keep-Alive"}
+		w.Header()["x-secret"] = []string{"internal"}
+		w.Header()["X-Request-Id"] = []string{"upstream"}
+		w.Header()["Keep-Alive"] = []string{"timeout=5"}
+		w.Header()["X-Public"] = []string{"yes"}
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, nil)
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	h := rec.Header()
+	for _, name := range []string{"Connection", "X-Secret", "Keep-Alive"} {
+		if v := h.Values(name); len(v) > 0 {
+			t.Errorf("hop-by-hop response header %s = %q was relayed", name, v)
+		}
+	}
+	if got := h.Values(requestIDHeader); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("%s = %q, want the gateway's own", requestIDHeader, got)
+	}
+	if h.Get("X-Public") != "yes" {
+		t.Error("end-to-end response header was dropped")
+	}
+}
+
+func TestProxy_MaxBodySize(t *testing.T) {
+	var received int
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = len(body)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.MaxBodySize = 1024
+		cfg.Routes = append([]config.Route{
+			{Name: "uploads", Path: "/uploads", Upstream: "backend", MaxBodySize: 16},
+		}, cfg.Routes...)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		body     io.Reader
+		length   int64
+		expected int
+	}{
+		{"within global limit", "/other", strings.NewReader(strings.Repeat("a", 1000)), 1000, http.StatusOK},
+		{"over global limit", "/other", strings.NewReader(strings.Repeat("a", 2000)), 2000, http.StatusRequestEntityTooLarge},
+		{"within route limit", "/uploads", strings.NewReader("0123456789"), 10, http.StatusOK},
+		{"over route limit", "/uploads", strings.NewReader(strings.Repeat("a", 17)), 17, http.StatusRequestEntityTooLarge},
+		{"streamed over route limit", "/uploads", io.MultiReader(strings.NewReader(strings.Repeat("a", 64))), -1, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tests {
+		received = 0
+		req := httptest.NewRequest("POST", tc.path, tc.body)
+		req.ContentLength = tc.length
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expected {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.expected, rec.Code)
+		}
+		if tc.expected == http.StatusRequestEntityTooLarge && tc.length > 0 && received != 0 {
+			t.Errorf("%s: body should be rejected before reaching the backend", tc.name)
+		}
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_errors_total{key="uploads_body_too_large"} 2`) {
+		t.Errorf("expected body_too_large errors for uploads, got:\n%s", metrics.Body.String())
+	}
+}
+
+func TestProxy_SetAPIKeysRotation(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.APIKeys = []config.APIKey{{Key: "old-key", Name: "billing", RequestsPerSecond: 10, Enabled: true}}
+	})
+
+	nameFor := func(key string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Key", key)
+		_, name := p.extractAPIKey(r)
+		return name
+	}
+
+	if got := nameFor("old-key"); got != "billing" {
+		t.Fatalf("old key resolved to %q", got)
+	}
+
+	p.SetAPIKeys([]config.APIKey{{Key: "new-key", Name: "billing", RequestsPerSecond: 10, Enabled: true}})
+
+	if got := nameFor("old-key"); got != "" {
+		t.Errorf("rotated-out key still accepted as %q", got)
+	}
+	if got := nameFor("new-key"); got != "billing" {
+		t.Errorf("new key resolved to %q, want billing", got)
+	}
+}
+
+func TestProxy_HashedAPIKeys(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	digest := sha256.Sum256([]byte("hashed-secret"))
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = false
+		cfg.RateLimit.PerAPIKey = true
+		cfg.APIKeys = []config.APIKey{
+			{KeyHash: "sha256:" + hex.EncodeToString(digest[:]), Name: "partner", RequestsPerSecond: 1, BurstSize: 1, Enabled: true},
+			{Key: "plain-secret", Name: "legacy", RequestsPerSecond: 1, BurstSize: 1, Enabled: true},
+		}
+	})
+
+	for key, name := range map[string]string{"hashed-secret": "partner", "plain-secret": "legacy", "sha256:nope": ""} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-API-Key", key)
+		if _, got := p.extractAPIKey(r); got != name {
+			t.Errorf("key %q resolved to %q, want %q", key, got, name)
+		}
+	}
+
+	// The bucket belongs to the key name: its single token is spent by the
+	// first request.
+	statuses := make([]int, 2)
+	for i := range statuses {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", "hashed-secret")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		statuses[i] = rec.Code
+	}
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want [200 429]", statuses)
+	}
+	buckets := p.rateLimiter.Stats()
+	if _, ok := buckets["apikey:partner"]; !ok {
+		t.Error("rate limit bucket not keyed by the key name")
+	}
+	for key := range buckets {
+		if strings.Contains(key, "secret") {
+			t.Errorf("rate limit bucket %q contains the key itself", key)
+		}
+	}
+}
+
+func TestProxy_UpstreamHost(t *testing.T) {
+	hosts := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host
+	}))
+	defer backend.Close()
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+
+	tests := []struct {
+		name  string
+		route func(r *config.Route)
+		want  string
+	}{
+		{name: "default", route: func(r *config.Route) {}, want: backendHost},
+		{name: "preserve_host", route: func(r *config.Route) { r.PreserveHost = true }, want: "api.example.com"},
+		{name: "upstream_host", route: func(r *config.Route) { r.UpstreamHost = "internal.example.com" }, want: "internal.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				tt.route(&cfg.Routes[0])
+			})
+			req := httptest.NewRequest("GET", "http://api.example.com/users", nil)
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := <-hosts; got != tt.want {
+				t.Errorf("upstream saw Host %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_PathNormalization(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Path", r.URL.EscapedPath())
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		matching string
+		path     string
+		status   int
+		route    string
+		seen     string
+	}{
+		{config.PathMatchingDecoded, "/api/v1//users/../admin", http.StatusOK, "test", "/api/v1/admin"},
+		{config.PathMatchingDecoded, "/static/../admin//keys", http.StatusOK, "admin", "/admin/keys"},
+		{config.PathMatchingDecoded, "/api/%2e%2e/admin/keys", http.StatusOK, "admin", "/admin/keys"},
+		{config.PathMatchingDecoded, "/files/a%2Fb", http.StatusOK, "test", "/files/a/b"},
+		{config.PathMatchingDecoded, "/api/../../etc/passwd", http.StatusBadRequest, "", ""},
+		{config.PathMatchingRaw, "/files//a%2Fb", http.StatusOK, "files", "/a%2Fb"},
+		{config.PathMatchingRaw, "/files/..%2F..%2Fetc", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Server.PathMatching = tt.matching
+			cfg.Routes = []config.Route{
+				{Name: "admin", Path: "/admin/**", Upstream: "backend"},
+				{Name: "files", Path: "/files/:name", Upstream: "backend", StripPath: true},
+				{Name: "test", Path: "/**", Upstream: "backend"},
+			}
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		p.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.matching, tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if route := p.router.Match(req); route == nil || routeNameOf(route) != tt.route {
+			t.Errorf("%s %s: routed to %v, want %s", tt.matching, tt.path, route, tt.route)
+		}
+		if got := rec.Header().Get("X-Seen-Path"); got != tt.seen {
+			t.Errorf("%s %s: upstream saw %q, want %q", tt.matching, tt.path, got, tt.seen)
+		}
+	}
+}
+
+func TestProxy_RequireAPIKey(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	public := false
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.RequireAPIKey = true
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = true
+		cfg.RateLimit.DefaultRPS = 1
+		cfg.RateLimit.DefaultBurst = 1
+		cfg.APIKeys = []config.APIKey{
+			{Key: "live-key", Name: "billing", RequestsPerSecond: 10, BurstSize: 10, Enabled: true},
+			{Key: "revoked-key", Name: "old", RequestsPerSecond: 10, BurstSize: 10},
+		}
+		cfg.Routes = append([]config.Route{
+			{Name: "public", Path: "/public", Upstream: "backend", RequireAPIKey: &public},
+		}, cfg.Routes...)
+	})
+
+	tests := []struct {
+		name, path, key string
+		want            int
+		challenge       string
+	}{
+		{"no key", "/orders", "", http.StatusUnauthorized, `Bearer realm="relaypoint"`},
+		{"unknown key", "/orders", "guess", http.StatusForbidden, `Bearer realm="relaypoint", error="invalid_token"`},
+		{"disabled key", "/orders", "revoked-key", http.StatusForbidden, `Bearer realm="relaypoint", error="invalid_token"`},
+		// Rejected requests above must not have used up the IP's single token.
+		{"valid key", "/orders", "live-key", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); got != tt.challenge {
+			t.Errorf("%s: WWW-Authenticate = %q, want %q", tt.name, got, tt.challenge)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/public", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("route opting out of require_api_key: status = %d", rec.Code)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_errors_total{key="test_auth_failed"} 3`) {
+		t.Errorf("expected 3 auth_failed errors, got:\n%s", metrics.Body.String())
+	}
+}
+
+func TestProxy_ExclusiveRoute(t *testing.T) {
+	backend := func(name string) string {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		return s.URL
+	}
+	tenant, fallback := backend("tenant"), backend("default")
+
+	p := newTestProxy(t, fallback, func(cfg *config.Config) {
+		cfg.Upstreams = append(cfg.Upstreams, config.Upstream{Name: "tenant", Targets: []config.Target{{URL: tenant}}})
+		cfg.Routes = []config.Route{
+			{Name: "tenant-read", Host: "*.example.com", Path: "/api/**", Methods: []string{"GET"}, Upstream: "tenant", Exclusive: true},
+			{Name: "catchall", Path: "/**", Upstream: "backend"},
+		}
+	})
+
+	req := httptest.NewRequest("POST", "/api/orders", strings.NewReader("{}"))
+	req.Host = "acme.example.com"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: status = %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET" {
+		t.Errorf("Allow = %q, want GET", got)
+	}
+	if strings.Contains(rec.Body.String(), "default") {
+		t.Fatal("POST fell through to the catch-all")
+	}
+
+	req = httptest.NewRequest("GET", "/api/orders", nil)
+	req.Host = "acme.example.com"
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if got := rec.Body.String(); got != "tenant" {
+		t.Errorf("GET reached %q, want tenant", got)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_errors_total{key="tenant-read_method_not_allowed"} 1`) {
+		t.Errorf("expected a method_not_allowed error, got:\n%s", metrics.Body.String())
+	}
+
+	test := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(test, httptest.NewRequest("GET", "/admin/routes/test?method=post&host=acme.example.com&path=/api/orders", nil))
+	for _, want := range []string{`"status":405`, `"route":"tenant-read"`, `"outcome":"exclusive"`} {
+		if !strings.Contains(test.Body.String(), want) {
+			t.Errorf("route test missing %s: %s", want, test.Body.String())
+		}
+	}
+}
+
+func TestAdmin_RouteOrder(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "users", Path: "/api/users", Upstream: "backend"},
+			{Name: "legacy", Path: "/api/**", Priority: 5, Upstream: "backend"},
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/order", nil))
+	body := rec.Body.String()
+	legacy, users := strings.Index(body, `"route":"legacy"`), strings.Index(body, `"route":"users"`)
+	if rec.Code != http.StatusOK || legacy < 0 || users < legacy || !strings.Contains(body, `"config_priority":5`) {
+		t.Errorf("route order = %d %s", rec.Code, body)
+	}
+}
+
+func TestNew_DuplicateRoutes(t *testing.T) {
+	for _, sensitive := range []bool{false, true} {
+		cfg := config.DefaultConfig()
+		cfg.Server.CaseSensitivePaths = sensitive
+		cfg.Upstreams = []config.Upstream{{Name: "backend", Targets: []config.Target{{URL: "http://127.0.0.1:1"}}}}
+		cfg.Routes = []config.Route{
+			{Name: "files", Path: "/files/:id", Upstream: "backend"},
+			{Name: "Files", Path: "/Files/{name}", Upstream: "backend"},
+		}
+		p, err := New(cfg)
+		if sensitive {
+			// Matched case-sensitively the paths differ.
+			if err != nil {
+				t.Fatalf("sensitive: New: %v", err)
+			}
+			p.Stop()
+			continue
+		}
+		if err == nil {
+			p.Stop()
+			t.Fatal("New accepted duplicate routes")
+		}
+		if want := "route Files duplicates route files"; !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestProxy_CaseSensitivePaths(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	for _, sensitive := range []bool{false, true} {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Server.CaseSensitivePaths = sensitive
+			cfg.Routes = []config.Route{{Name: "test", Path: "/Files/**", Upstream: "backend"}}
+		})
+
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/Files/Report.PDF", nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "/Files/Report.PDF" {
+			t.Errorf("sensitive=%v: got %d %q, want the path forwarded as sent", sensitive, rec.Code, rec.Body.String())
+		}
+
+		rec = httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/files/Report.PDF", nil))
+		switch {
+		case sensitive && rec.Code != http.StatusNotFound:
+			t.Errorf("sensitive: /files matched with %d", rec.Code)
+		case !sensitive && rec.Body.String() != "/files/Report.PDF":
+			t.Errorf("insensitive: got %d %q, want the path forwarded as sent", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestProxy_TrailingSlash(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.BasePath = "/gateway"
+		cfg.Routes = []config.Route{
+			{Name: "merge", Path: "/merge", Upstream: "backend"},
+			{Name: "strict", Path: "/strict", TrailingSlash: config.TrailingSlashStrict, Upstream: "backend"},
+			{Name: "redirect", Path: "/redirect", TrailingSlash: config.TrailingSlashRedirect, Upstream: "backend"},
+			{Name: "docs", Path: "/docs/**", TrailingSlash: config.TrailingSlashRedirect, Upstream: "backend"},
+			{Name: "root", Path: "/", TrailingSlash: config.TrailingSlashRedirect, Upstream: "backend"},
+		}
+	})
+
+	tests := []struct {
+		method, path string
+		status       int
+		want         string // forwarded path, or Location
+	}{
+		{"GET", "/gateway/merge/", http.StatusOK, "/merge/"},
+		{"GET", "/gateway/merge", http.StatusOK, "/merge"},
+		{"GET", "/gateway/strict", http.StatusOK, "/strict"},
+		{"GET", "/gateway/strict/", http.StatusNotFound, ""},
+		{"GET", "/gateway/redirect", http.StatusOK, "/redirect"},
+		{"GET", "/gateway/redirect/?page=2", http.StatusMovedPermanently, "/gateway/redirect?page=2"},
+		{"POST", "/gateway/redirect/", http.StatusPermanentRedirect, "/gateway/redirect"},
+		{"GET", "/gateway/docs/a%20b/", http.StatusMovedPermanently, "/gateway/docs/a%20b"},
+		{"GET", "/gateway/", http.StatusOK, "/"},
+		{"GET", "/gateway", http.StatusOK, "/"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.BasePathHandler(p).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+			continue
+		}
+		switch tt.status {
+		case http.StatusOK:
+			if rec.Body.String() != tt.want {
+				t.Errorf("%s %s: forwarded %q, want %q", tt.method, tt.path, rec.Body.String(), tt.want)
+			}
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			if got := rec.Header().Get("Location"); got != tt.want {
+				t.Errorf("%s %s: Location = %q, want %q", tt.method, tt.path, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestProxy_MethodOverrideAndMap(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.Header.Get("X-HTTP-Method-Override"))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "items", Path: "/items/*", Methods: []string{"PUT", "DELETE"}, Upstream: "backend", HonorMethodOverride: true},
+			{Name: "legacy", Path: "/legacy/**", Upstream: "backend", MethodMap: map[string]string{"POST": "PUT"}},
+		}
+	})
+
+	send := func(method, path, override string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		if override != "" {
+			req.Header.Set("X-HTTP-Method-Override", override)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("POST", "/items/1", "DELETE"); rec.Code != http.StatusOK || rec.Body.String() != "DELETE " {
+		t.Errorf("override: %d %q, want DELETE without the override header", rec.Code, rec.Body.String())
+	}
+	if rec := send("POST", "/items/1", "TRACE"); rec.Code != http.StatusBadRequest {
+		t.Errorf("override outside the allow-list: status = %d, want 400", rec.Code)
+	}
+	// The 405 check uses the overridden method.
+	if rec := send("POST", "/items/1", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST without override: status = %d, want 405", rec.Code)
+	}
+	if rec := send("POST", "/legacy/orders", ""); rec.Body.String() != "PUT " {
+		t.Errorf("method_map: upstream saw %q, want PUT", rec.Body.String())
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_requests_total{key="items_DELETE_200"} 1`,
+		`gateway_requests_total{key="legacy_PUT_200"} 1`,
+		`gateway_errors_total{key="items_method_override_denied"} 1`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestProxy_ErrorResponses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, "<h1>upstream broke</h1>")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Path = "/api/**"
+		cfg.Routes[0].RateLimit = &config.RouteRateLimit{Enabled: true, RequestsPerSecond: 1, BurstSize: 1}
+		cfg.RateLimit.Enabled = true
+		cfg.RateLimit.PerIP = false
+		cfg.RateLimit.PerAPIKey = false
+		cfg.ErrorResponses.Templates = map[int]config.ErrorTemplate{
+			http.StatusTooManyRequests: {
+				ContentType: "application/problem+json",
+				Body:        `{"type":{{json .Type}},"status":{{.Code}},"request_id":{{json .RequestID}}}`,
+			},
+		}
+	})
+
+	// Route misses use the default JSON envelope.
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("404 body %q is not JSON: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != "application/json" ||
+		body.Error.Code != http.StatusNotFound || body.Error.RequestID != rec.Header().Get(requestIDHeader) {
+		t.Errorf("404: status %d, content type %q, body %+v", rec.Code, rec.Header().Get("Content-Type"), body)
+	}
+
+	// Upstream errors are relayed untouched.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/api/x", nil))
+	if rec.Code != http.StatusInternalServerError || rec.Body.String() != "<h1>upstream broke</h1>" || rec.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("upstream 500 changed: %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	// A template replaces the body for its status.
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/api/x", nil))
+	want := `{"type":"rate_limited","status":429,"request_id":"` + rec.Header().Get(requestIDHeader) + `"}`
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != want || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("429: %d %q %q, want %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String(), want)
+	}
+}
+
+func TestProxy_TextErrorResponses(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+		cfg.ErrorResponses.Format = config.ErrorFormatText
+		cfg.Routes[0].Path = "/api/**"
+	})
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/missing", nil))
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "Not Found\n" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("text 404: %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// checkQuota counts the request against its API key's quota and answers
+// 429 once the period's requests are used up. Requests without an API key
+// are not counted. When the store fails it follows quota.on_error.
+func (p *Proxy) checkQuota(w http.ResponseWriter, apiKeyName, routeName string) bool {
+	q := p.config.Quota
+	if !q.Enabled || apiKeyName == "" {
+		return true
+	}
+	count, reset, err := p.quotas.Increment(apiKeyName, q.Period)
+	p.quotaPolicy.observe(err)
+	if err != nil {
+		switch p.quotaPolicy.action {
+		case config.OnErrorAllow:
+			return true
+		case config.OnErrorDeny:
+			p.metrics.RecordError(routeName, "quota_unavailable")
+			p.writeError(w, http.StatusServiceUnavailable, "quota_unavailable", "quota unavailable")
+			return false
+		}
+		// Best effort: the requests this process has seen.
+		count, reset, _ = p.localQuotas.Increment(apiKeyName, q.Period)
+	}
+	if count <= q.Requests {
+		return true
+	}
+	p.metrics.RecordError(routeName, "quota_exceeded")
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset)/time.Second)+1))
+	p.writeError(w, http.StatusTooManyRequests, "quota_exceeded", "quota exceeded")
+	return false
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_Quota(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.APIKeys = []config.APIKey{
+			{Key: "k1", Name: "billing", Enabled: true},
+			{Key: "k2", Name: "search", Enabled: true},
+		}
+		cfg.Quota = config.QuotaConfig{Enabled: true, Requests: 2, Period: time.Hour}
+	})
+	get := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := get("k1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the quota: status = %d", i, rec.Code)
+		}
+	}
+	rec := get("k1")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the quota: status = %d, want 429", rec.Code)
+	}
+	if s, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || s < 1 || s > 3600 {
+		t.Errorf("Retry-After = %q, want the seconds left in the hour", rec.Header().Get("Retry-After"))
+	}
+
+	// Other keys have quotas of their own, and requests without a key are
+	// not counted.
+	if rec := get("k2"); rec.Code != http.StatusOK {
+		t.Errorf("another key: status = %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := get(""); rec.Code != http.StatusOK {
+			t.Errorf("request %d without a key: status = %d", i, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+const defaultReadOnlyRetryAfter = 30
+
+var defaultReadOnlyMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// gatewayMode is the operating mode and the change that set it; it is also
+// the content of the state file. It is not modified once published.
+type gatewayMode struct {
+	Mode      string    `json:"mode"`
+	ChangedAt time.Time `json:"changed_at,omitzero"`
+	ChangedBy string    `json:"changed_by,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// setupMode starts in the configured mode, or in the one the state file
+// kept from the admin API. A state file that cannot be read stops the
+// start rather than risk serving writes at a read-only site.
+func (p *Proxy) setupMode() error {
+	ro := p.config.ReadOnly
+	p.readOnlyMethods = make(map[string]bool)
+	methods := ro.Methods
+	if len(methods) == 0 {
+		methods = defaultReadOnlyMethods
+	}
+	for _, m := range methods {
+		p.readOnlyMethods[m] = true
+	}
+	p.readOnlyRetryAfter = ro.RetryAfter
+	if p.readOnlyRetryAfter == 0 {
+		p.readOnlyRetryAfter = defaultReadOnlyRetryAfter
+	}
+
+	mode := &gatewayMode{Mode: p.config.Mode}
+	if mode.Mode == "" {
+		mode.Mode = config.ModeActive
+	}
+	if ro.StateFile != "" {
+		data, err := os.ReadFile(ro.StateFile)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return fmt.Errorf("read_only state_file: %w", err)
+		default:
+			var kept gatewayMode
+			if err := json.Unmarshal(data, &kept); err != nil || !validMode(kept.Mode) {
+				return fmt.Errorf("read_only state_file %s does not hold a valid mode", ro.StateFile)
+			}
+			mode = &kept
+		}
+	}
+	p.mode.Store(mode)
+	p.metrics.RecordGatewayMode(mode.Mode)
+	return nil
+}
+
+func validMode(mode string) bool {
+	return mode == config.ModeActive || mode == config.ModeReadOnly
+}
+
+// Mode returns the gateway's operating mode, for /ready.
+func (p *Proxy) Mode() string {
+	return p.mode.Load().Mode
+}
+
+// readOnly reports whether the gateway is in read_only mode.
+func (p *Proxy) readOnly() bool {
+	return p.mode.Load().Mode == config.ModeReadOnly
+}
+
+// setMode switches the operating mode, writing it to the state file first
+// so a restart keeps it. It reports whether anything changed.
+func (p *Proxy) setMode(mode, by, reason string) (bool, error) {
+	p.modeMu.Lock()
+	defer p.modeMu.Unlock()
+	old := p.mode.Load()
+	if old.Mode == mode {
+		return false, nil
+	}
+	next := &gatewayMode{Mode: mode, ChangedAt: time.Now().UTC(), ChangedBy: by, Reason: reason}
+	if path := p.config.ReadOnly.StateFile; path != "" {
+		if err := writeStateFile(path, next); err != nil {
+			return false, err
+		}
+	}
+	p.mode.Store(next)
+
+	p.metrics.RecordGatewayMode(mode)
+	p.logger.Warn("gateway mode changed", "mode", mode, "previous", old.Mode, "by", by, "reason", reason)
+	p.events.Publish(events.GatewayMode, map[string]any{
+		"mode": mode, "previous": old.Mode, "by": by, "reason": reason,
+	})
+	return true, nil
+}
+
+// writeStateFile replaces a state file with v in JSON atomically, so a
+// crash never leaves a torn file behind.
+func writeStateFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// rejectReadOnly answers 503 to a mutating request while the gateway is
+// read-only, unless its route sets allow_in_read_only.
+func (p *Proxy) rejectReadOnly(req *pipelineRequest) bool {
+	if !p.readOnly() || req.route.AllowInReadOnly || !p.readOnlyMethods[req.r.Method] {
+		return false
+	}
+	p.metrics.RecordError(req.routeName, "read_only")
+	req.w.Header().Set("Retry-After", strconv.Itoa(p.readOnlyRetryAfter))
+	p.writeError(req.w, http.StatusServiceUnavailable, "read_only", "gateway is read-only")
+	return true
+}
+
+// handleGetMode reports the operating mode and the change that set it.
+func (p *Proxy) handleGetMode(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, p.mode.Load())
+}
+
+// handleSetMode switches the operating mode. The JSON body sets mode and an
+// optional reason, recorded with the change.
+func (p *Proxy) handleSetMode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid mode settings: "+err.Error(), "")
+		return
+	}
+	if !validMode(body.Mode) {
+		writeJSONError(w, http.StatusBadRequest, "mode must be active or read_only", "")
+		return
+	}
+	if _, err := p.setMode(body.Mode, p.clientIP(r), body.Reason); err != nil {
+		p.logger.Error("gateway mode not changed", "mode", body.Mode, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "mode could not be persisted", "")
+		return
+	}
+	writeJSON(w, http.StatusOK, p.mode.Load())
+}
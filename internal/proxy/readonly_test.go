@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+func TestProxy_ReadOnly(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Mode = config.ModeReadOnly
+		cfg.Routes = []config.Route{
+			{Name: "replication", Path: "/replication/**", Upstream: "backend", AllowInReadOnly: true},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+	})
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{"GET", "/orders", http.StatusOK},
+		{"HEAD", "/orders", http.StatusOK},
+		{"OPTIONS", "/orders", http.StatusOK},
+		{"POST", "/orders", http.StatusServiceUnavailable},
+		{"PUT", "/orders/1", http.StatusServiceUnavailable},
+		{"PATCH", "/orders/1", http.StatusServiceUnavailable},
+		{"DELETE", "/orders/1", http.StatusServiceUnavailable},
+		{"POST", "/replication/apply", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusServiceUnavailable {
+			continue
+		}
+		if got := rec.Header().Get("Retry-After"); got != "30" {
+			t.Errorf("%s %s: Retry-After = %q, want 30", tt.method, tt.path, got)
+		}
+		var body errorBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Message != "gateway is read-only" {
+			t.Errorf("%s %s: body = %q, want the read-only error", tt.method, tt.path, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_mode{mode="read_only"} 1`,
+		`gateway_errors_total{key="test_read_only"} 4`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestProxy_ReadOnlyMethods(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Mode = config.ModeReadOnly
+		cfg.ReadOnly = config.ReadOnlyConfig{Methods: []string{"POST"}, RetryAfter: 120}
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("DELETE", "/x", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("DELETE: status = %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("POST", "/x", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "120" {
+		t.Errorf("POST: status = %d, Retry-After = %q, want 503 and 120", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestProxy_ModeAdmin(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	stateFile := filepath.Join(t.TempDir(), "mode.json")
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	mutate := func(cfg *config.Config) {
+		cfg.ReadOnly.StateFile = stateFile
+		cfg.AccessLog = config.AccessLogConfig{Enabled: true, File: logFile, SampleRate: 1}
+	}
+	p := newTestProxy(t, backend.URL, mutate)
+	sub := p.Events().Subscribe([]string{events.GatewayMode}, 8)
+	defer p.Events().Unsubscribe(sub)
+
+	admin := func(p *Proxy, method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, "/admin/mode", strings.NewReader(body)))
+		return rec
+	}
+	post := func() int {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("POST", "/x", nil))
+		return rec.Code
+	}
+
+	if rec := admin(p, "GET", ""); !strings.Contains(rec.Body.String(), `"mode":"active"`) {
+		t.Errorf("GET /admin/mode = %s, want active", rec.Body.String())
+	}
+	if rec := admin(p, "PUT", `{"mode": "standby"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT with mode standby: status = %d, want 400", rec.Code)
+	}
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("POST while active: status = %d, want 200", code)
+	}
+
+	rec := admin(p, "PUT", `{"mode": "read_only", "reason": "failover drill"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Errorf("POST while read-only: status = %d, want 503", code)
+	}
+	ev := <-sub.C
+	if ev.Data["mode"] != config.ModeReadOnly || ev.Data["previous"] != config.ModeActive || ev.Data["reason"] != "failover drill" {
+		t.Errorf("event = %+v", ev)
+	}
+
+	// A restart keeps the mode set through the API.
+	restarted := newTestProxy(t, backend.URL, mutate)
+	var mode gatewayMode
+	if err := json.Unmarshal(admin(restarted, "GET", "").Body.Bytes(), &mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode.Mode != config.ModeReadOnly || mode.Reason != "failover drill" || mode.ChangedBy == "" || mode.ChangedAt.IsZero() {
+		t.Errorf("mode after restart = %+v", mode)
+	}
+
+	if rec := admin(p, "PUT", `{"mode": "active"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT active: status = %d", rec.Code)
+	}
+	if code := post(); code != http.StatusOK {
+		t.Errorf("POST after switching back: status = %d, want 200", code)
+	}
+
+	entries := readAccessLog(t, logFile)
+	if len(entries) != 3 {
+		t.Fatalf("got %d access log entries, want 3", len(entries))
+	}
+	for i, want := range []any{nil, "read_only", nil} {
+		if entries[i]["mode"] != want {
+			t.Errorf("entry %d: mode = %v, want %v", i, entries[i]["mode"], want)
+		}
+	}
+}
+
+func TestProxy_ModeStateFileCorrupt(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "mode.json")
+	if err := os.WriteFile(stateFile, []byte(`{"mode": "standby"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.ReadOnly.StateFile = stateFile
+	if p, err := New(cfg); err == nil {
+		p.Stop()
+		t.Error("New accepted a state file without a valid mode")
+	}
+}
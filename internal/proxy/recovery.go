@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+// handlePanic turns a panic raised while serving r into a structured log
+// record, a metric and, if nothing was written yet and the client is still
+// there, a 500 response. routeName is "unknown" for panics raised before
+// the request was routed.
+func (p *Proxy) handlePanic(w *statusWriter, r *http.Request, v any, routeName, targetURL string) {
+	if v == http.ErrAbortHandler {
+		// Deliberate abort; let net/http tear the connection down quietly.
+		panic(v)
+	}
+
+	requestID := requestIDFrom(r.Context())
+	p.metrics.RecordPanic(routeName)
+	p.logger.Error("panic while serving request",
+		"route", routeName,
+		"target", targetURL,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"request_id", requestID,
+		"panic", fmt.Sprint(v),
+		"stack", string(debug.Stack()),
+	)
+
+	if p.panics.record(routeName, time.Now()) {
+		p.logger.Error("route disabled after repeated panics",
+			"route", routeName,
+			"threshold", p.panics.threshold,
+		)
+		p.events.Publish(events.RouteTripped, map[string]any{
+			"route":     routeName,
+			"threshold": p.panics.threshold,
+		})
+	}
+
+	if w.wroteHeader {
+		// Too late for a clean error; abort so the client sees a broken response.
+		panic(http.ErrAbortHandler)
+	}
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		// Nobody is left to read a 500; the request is recorded as closed
+		// by the client, as it would have been without the panic.
+		w.status = 499
+		return
+	}
+	p.writeError(w, http.StatusInternalServerError, "internal_error", "internal gateway error")
+}
+
+// panicGuard trips a route once it panics threshold times within a minute.
+// Tripped routes stay disabled until the gateway restarts.
+type panicGuard struct {
+	threshold int
+
+	mu      sync.RWMutex
+	recent  map[string][]time.Time
+	tripped map[string]bool
+}
+
+func newPanicGuard(threshold int) *panicGuard {
+	return &panicGuard{
+		threshold: threshold,
+		recent:    make(map[string][]time.Time),
+		tripped:   make(map[string]bool),
+	}
+}
+
+// record notes a panic on route and reports whether it just tripped.
+func (g *panicGuard) record(route string, now time.Time) bool {
+	if g.threshold <= 0 {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.tripped[route] {
+		return false
+	}
+
+	cutoff := now.Add(-time.Minute)
+	kept := g.recent[route][:0]
+	for _, t := range g.recent[route] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	kept = append(kept, now)
+	g.recent[route] = kept
+
+	if len(kept) >= g.threshold {
+		g.tripped[route] = true
+		delete(g.recent, route)
+		return true
+	}
+	return false
+}
+
+func (g *panicGuard) isTripped(route string) bool {
+	if g.threshold <= 0 {
+		return false
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.tripped[route]
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func panickingProxy(t *testing.T, threshold int) (*Proxy, *bytes.Buffer) {
+	t.Helper()
+
+	p := newTestProxy(t, "http://backend.internal:8080", nil)
+	p.panics = newPanicGuard(threshold)
+	p.httpClient.Transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		var headers map[string]string
+		headers["boom"] = "nil map write"
+		return nil, nil
+	})
+
+	var logs bytes.Buffer
+	p.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	return p, &logs
+}
+
+func TestProxy_RecoversPanic(t *testing.T) {
+	p, logs := panickingProxy(t, 0)
+
+	req := httptest.NewRequest("GET", "/users", nil)
+	req.Header.Set(requestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON content type, got %q", ct)
+	}
+
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error envelope: %v", err)
+	}
+	if body.Error.Code != 500 || body.Error.RequestID != "req-123" {
+		t.Errorf("unexpected envelope: %+v", body.Error)
+	}
+
+	var record map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("expected one structured log record, got %q", logs.String())
+	}
+	if record["route"] != "test" || record["target"] != "http://backend.internal:8080" || record["request_id"] != "req-123" {
+		t.Errorf("missing context in log record: %v", record)
+	}
+	if stack, _ := record["stack"].(string); !strings.Contains(stack, "goroutine") {
+		t.Errorf("expected stack trace in log record, got %q", stack)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `gateway_panics_total{route="test"} 1`) {
+		t.Errorf("expected panic counter, got:\n%s", metrics.Body.String())
+	}
+}
+
+func TestProxy_PanicThresholdTripsRoute(t *testing.T) {
+	p, _ := panickingProxy(t, 2)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: expected 500, got %d", i+1, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected tripped route to return 503, got %d", rec.Code)
+	}
+}
+
+func TestProxy_AbortHandlerIsNotRecovered(t *testing.T) {
+	p := newTestProxy(t, "http://backend.internal:8080", nil)
+	p.httpClient.Transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected ErrAbortHandler to propagate, got %v", v)
+		}
+	}()
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+}
+
+func TestProxy_PanicAfterClientCancelKeeps499(t *testing.T) {
+	p, logs := panickingProxy(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/users", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Body.Len() != 0 {
+		t.Errorf("expected no response for a gone client, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), "panic while serving request") {
+		t.Errorf("expected the panic to be logged, got %q", logs.String())
+	}
+}
+
+func TestProxy_RecoversPanicBeforeRouting(t *testing.T) {
+	p, logs := panickingProxy(t, 0)
+	p.router = nil
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/users", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), `"route":"unknown"`) {
+		t.Errorf("expected the panic to be logged without a route, got %q", logs.String())
+	}
+}
+
+func TestProxy_PanicWhileCopyingResponseAborts(t *testing.T) {
+	p := newTestProxy(t, "http://backend.internal:8080", nil)
+	p.httpClient.Transport = roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(panicReader{}),
+		}, nil
+	})
+	p.logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected ErrAbortHandler once the response started, got %v", v)
+		}
+		metrics := httptest.NewRecorder()
+		p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+		if !strings.Contains(metrics.Body.String(), `gateway_panics_total{route="test"} 1`) {
+			t.Errorf("expected one panic counted, got:\n%s", metrics.Body.String())
+		}
+	}()
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users", nil))
+}
+
+// panicReader panics on read, like a faulty transform of the upstream body.
+type panicReader struct{}
+
+func (panicReader) Read([]byte) (int, error) {
+	var m map[string]int
+	m["boom"]++
+	return 0, nil
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+)
+
+const (
+	defaultReportTTL = 30 * time.Second
+	// maxReportTTL bounds how long a single push can override health
+	// checks, so a target that dies after reporting healthy is noticed.
+	maxReportTTL = 10 * time.Minute
+
+	maxReportBody = 64 << 10
+)
+
+// targetReport is the body of a push to the report endpoint.
+type targetReport struct {
+	Healthy *bool   `json:"healthy"`
+	Load    float64 `json:"load"`
+	Drain   bool    `json:"drain"`
+	TTL     string  `json:"ttl"`
+}
+
+// handleTargetReport lets a target, or a sidecar next to it, push its own
+// health and load. The state applies until its TTL runs out; afterwards the
+// target reverts to what the health checks observed.
+func (p *Proxy) handleTargetReport(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	lb, ok := p.upstreams[name]
+	secret := ""
+	for _, u := range p.config.Upstreams {
+		if u.Name == name {
+			secret = u.ReportSecret
+		}
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown upstream", "")
+		return
+	}
+	if secret == "" {
+		p.metrics.RecordTargetReport(name, "disabled")
+		writeJSONError(w, http.StatusForbidden, "reports are not enabled for this upstream", "")
+		return
+	}
+	// Digests of equal length are compared, so the time taken reveals
+	// neither the secret nor its length.
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	got, want := sha256.Sum256([]byte(token)), sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+		p.metrics.RecordTargetReport(name, "unauthorized")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="relaypoint"`)
+		writeJSONError(w, http.StatusUnauthorized, "invalid report secret", "")
+		return
+	}
+
+	target := findTarget(lb, r.PathValue("url"))
+	if target == nil {
+		p.metrics.RecordTargetReport(name, "unknown_target")
+		writeJSONError(w, http.StatusNotFound, "unknown target", "")
+		return
+	}
+
+	var report targetReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportBody)).Decode(&report); err != nil {
+		p.metrics.RecordTargetReport(name, "invalid")
+		writeJSONError(w, http.StatusBadRequest, "invalid report body", "")
+		return
+	}
+	if report.Load < 0 || report.Load > 1 {
+		p.metrics.RecordTargetReport(name, "invalid")
+		writeJSONError(w, http.StatusBadRequest, "load must be between 0 and 1", "")
+		return
+	}
+	ttl := defaultReportTTL
+	if report.TTL != "" {
+		d, err := time.ParseDuration(report.TTL)
+		if err != nil || d <= 0 || d > maxReportTTL {
+			p.metrics.RecordTargetReport(name, "invalid")
+			writeJSONError(w, http.StatusBadRequest, "ttl must be a duration between 0s and 10m", "")
+			return
+		}
+		ttl = d
+	}
+
+	expires := time.Now().Add(ttl)
+	target.SetReport(&loadbalancer.Report{
+		Healthy: report.Healthy,
+		Load:    report.Load,
+		Drain:   report.Drain,
+		Expires: expires,
+	})
+
+	p.metrics.RecordTargetReport(name, "accepted")
+	data := map[string]any{
+		"upstream": name,
+		"target":   target.URL.String(),
+		"load":     report.Load,
+		"drain":    report.Drain,
+		"ttl":      ttl.String(),
+	}
+	attrs := []any{"upstream", name, "target", target.URL.String(), "load", report.Load, "drain", report.Drain, "ttl", ttl, "remote_addr", r.RemoteAddr}
+	if report.Healthy != nil {
+		data["healthy"] = *report.Healthy
+		attrs = append(attrs, "healthy", *report.Healthy)
+	}
+	p.events.Publish(events.TargetReport, data)
+	p.logger.Info("target report accepted", attrs...)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"upstream":  name,
+		"target":    target.URL.String(),
+		"available": target.Available(),
+		"expires":   expires.UTC(),
+	})
+}
+
+func findTarget(lb loadbalancer.LoadBalancer, rawURL string) *loadbalancer.Target {
+	rawURL = strings.TrimSuffix(rawURL, "/")
+	for _, t := range lb.Targets() {
+		if strings.TrimSuffix(t.URL.String(), "/") == rawURL {
+			return t
+		}
+	}
+	return nil
+}
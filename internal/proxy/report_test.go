@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestAdmin_TargetReport(t *testing.T) {
+	named := func(name string) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	a, b := named("a"), named("b")
+
+	p := newTestProxy(t, a.URL, func(cfg *config.Config) {
+		cfg.Upstreams[0].Targets = append(cfg.Upstreams[0].Targets, config.Target{URL: b.URL})
+		cfg.Upstreams[0].ReportSecret = "s3cret"
+	})
+	admin := httptest.NewServer(p.AdminHandler())
+	defer admin.Close()
+
+	push := func(target, secret, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", admin.URL+"/admin/upstreams/backend/targets/"+url.PathEscape(target)+"/report", strings.NewReader(body))
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// served returns which targets answered n requests.
+	served := func(n int) map[string]int {
+		t.Helper()
+		seen := make(map[string]int)
+		for i := 0; i < n; i++ {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			seen[rec.Body.String()]++
+		}
+		return seen
+	}
+
+	for _, tt := range []struct {
+		name, target, secret, body string
+		want                       int
+	}{
+		{"no secret", a.URL, "", `{"drain":true}`, http.StatusUnauthorized},
+		{"wrong secret", a.URL, "guess", `{"drain":true}`, http.StatusUnauthorized},
+		{"unknown target", "http://127.0.0.1:1", "s3cret", `{"drain":true}`, http.StatusNotFound},
+		{"load out of range", a.URL, "s3cret", `{"load":1.5}`, http.StatusBadRequest},
+		{"ttl too long", a.URL, "s3cret", `{"drain":true,"ttl":"1h"}`, http.StatusBadRequest},
+	} {
+		if got := push(tt.target, tt.secret, tt.body); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if seen := served(4); seen["a"] != 2 {
+		t.Fatalf("rejected pushes changed routing: %v", seen)
+	}
+
+	if got := push(a.URL, "s3cret", `{"drain":true,"ttl":"1m"}`); got != http.StatusOK {
+		t.Fatalf("drain push: status = %d", got)
+	}
+	if seen := served(4); seen["a"] != 0 {
+		t.Errorf("draining target still served requests: %v", seen)
+	}
+
+	// A pushed healthy state overrides a failing health check until its TTL
+	// runs out, then the checked state applies again.
+	lb := p.upstreams["backend"]
+	lb.MarkHealthy(lb.Targets()[0], false)
+	if got := push(a.URL, "s3cret", `{"healthy":true,"ttl":"100ms"}`); got != http.StatusOK {
+		t.Fatalf("healthy push: status = %d", got)
+	}
+	if seen := served(4); seen["a"] != 2 {
+		t.Errorf("reported healthy target not served: %v", seen)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if seen := served(4); seen["a"] != 0 {
+		t.Errorf("expired report still overrides the health check: %v", seen)
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_target_reports_total{key="backend_accepted"} 2`,
+		`gateway_target_reports_total{key="backend_unauthorized"} 2`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestAdmin_TargetReportDisabled(t *testing.T) {
+	p := newTestProxy(t, "http://127.0.0.1:1", nil)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/upstreams/backend/targets/"+url.PathEscape("http://127.0.0.1:1")+"/report", strings.NewReader(`{"drain":true}`))
+	req.Header.Set("Authorization", "Bearer ")
+	p.AdminHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 without report_secret", rec.Code)
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/jsonschema"
+)
+
+// statusWriter records the status code and body size written through it.
+// Optional interfaces (Flusher, Hijacker) are reached via Unwrap and
+// http.ResponseController.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+	// security is added to the final response head; it is switched to the
+	// route's once the request is routed.
+	security *securityHeaders
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader && code >= 200 {
+		sw.status = code
+		sw.wroteHeader = true
+		sw.security.apply(sw.Header())
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.written += int64(n)
+	return n, err
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors lists what was wrong with the request body, for schema
+	// violations.
+	Errors []jsonschema.Violation `json:"errors,omitempty"`
+}
+
+// writeJSONError writes a gateway-generated error in the standard envelope.
+func writeJSONError(w http.ResponseWriter, status int, message, requestID string) {
+	writeJSONErrorDetail(w, errorDetail{Code: status, Message: message, RequestID: requestID})
+}
+
+func writeJSONErrorDetail(w http.ResponseWriter, detail errorDetail) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(detail.Code)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: detail})
+}
+
+// errorTemplate is a parsed error_responses template.
+type errorTemplate struct {
+	contentType string
+	tmpl        *template.Template
+}
+
+// newErrorTemplates parses the configured error_responses templates.
+func newErrorTemplates(cfg config.ErrorResponses) (map[int]*errorTemplate, error) {
+	templates := make(map[int]*errorTemplate, len(cfg.Templates))
+	for status, t := range cfg.Templates {
+		tmpl, err := config.ParseErrorTemplate(t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error_responses template for %d: %w", status, err)
+		}
+		contentType := t.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		templates[status] = &errorTemplate{contentType: contentType, tmpl: tmpl}
+	}
+	return templates, nil
+}
+
+// writeError answers a request with an error the gateway generated itself,
+// in the format set by error_responses. errType is the gateway_errors_total
+// label of the error. Every proxied-traffic error goes through here;
+// upstream error responses are relayed untouched.
+func (p *Proxy) writeError(w http.ResponseWriter, status int, errType, message string) {
+	p.writeErrorDetail(w, status, errType, message, nil)
+}
+
+// writeErrorDetail is writeError with the list of problems found in the
+// request body, sent in the JSON envelope and given to templates as .Errors.
+func (p *Proxy) writeErrorDetail(w http.ResponseWriter, status int, errType, message string, violations []jsonschema.Violation) {
+	requestID := w.Header().Get(requestIDHeader)
+	if t, ok := p.errorTemplates[status]; ok {
+		var body bytes.Buffer
+		err := t.tmpl.Execute(&body, struct {
+			Code      int
+			Message   string
+			RequestID string
+			Type      string
+			Errors    []jsonschema.Violation
+		}{status, message, requestID, errType, violations})
+		if err == nil {
+			h := w.Header()
+			h.Del("Content-Length")
+			h.Set("Content-Type", t.contentType)
+			h.Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(status)
+			_, _ = w.Write(body.Bytes())
+			return
+		}
+		p.logger.Warn("error response template failed", "status", status, "error", err)
+	}
+	if p.config.ErrorResponses.Format == config.ErrorFormatText {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	writeJSONErrorDetail(w, errorDetail{Code: status, Message: message, RequestID: requestID, Errors: violations})
+}
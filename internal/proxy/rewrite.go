@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// paramPlaceholder matches {name} references to route path parameters.
+var paramPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// pathRewrite is a compiled config.Rewrite.
+type pathRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func newPathRewrite(cfg *config.Rewrite) (*pathRewrite, error) {
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &pathRewrite{pattern: re, replacement: cfg.Replacement}, nil
+}
+
+// apply rewrites path, substituting path parameters into the replacement
+// before expanding capture groups. Paths the pattern does not match are
+// returned unchanged.
+func (rw *pathRewrite) apply(path string, params map[string]string) string {
+	if !rw.pattern.MatchString(path) {
+		return path
+	}
+
+	template := expandParams(rw.replacement, params)
+	rewritten := rw.pattern.ReplaceAllString(path, template)
+	if !strings.HasPrefix(rewritten, "/") {
+		rewritten = "/" + rewritten
+	}
+	return rewritten
+}
+
+// expandParams replaces {name} in template with the path parameter of that
+// name, escaped for use in a regexp replacement. ${name} is left alone as a
+// capture group reference, as are unknown names.
+func expandParams(template string, params map[string]string) string {
+	return substituteParams(template, params, func(v string) string {
+		return strings.ReplaceAll(v, "$", "$$")
+	})
+}
+
+// substituteParams replaces {name} in template with the path parameter of
+// that name, passed through escape if it is set. ${name} and unknown names
+// are left alone.
+func substituteParams(template string, params map[string]string, escape func(string) string) string {
+	if len(params) == 0 || !strings.Contains(template, "{") {
+		return template
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range paramPlaceholder.FindAllStringSubmatchIndex(template, -1) {
+		start, end := m[0], m[1]
+		if start > 0 && template[start-1] == '$' {
+			continue
+		}
+		value, ok := params[template[m[2]:m[3]]]
+		if !ok {
+			continue
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		b.WriteString(template[last:start])
+		b.WriteString(value)
+		last = end
+	}
+	b.WriteString(template[last:])
+	return b.String()
+}
+
+// upstreamPath fills in the upstream_path of route for a request to path.
+// {**} is what the route's trailing wildcard matched; when that is nothing,
+// the slash in front of it goes too, so /users/{**} becomes /users.
+func upstreamPath(route *router.Route, path string) string {
+	template := route.UpstreamPath
+	if !strings.Contains(template, "{**}") {
+		return substituteParams(template, route.PathParams, nil)
+	}
+
+	var rest string
+	switch {
+	case strings.HasSuffix(route.Pattern, "**"):
+		rest = route.PathParams["**"]
+	case strings.HasSuffix(route.Pattern, "*"):
+		trimmed := strings.Trim(path, "/")
+		rest = trimmed[strings.LastIndex(trimmed, "/")+1:]
+	}
+	if rest == "" {
+		template = strings.ReplaceAll(template, "/{**}", "")
+	}
+	params := maps.Clone(route.PathParams)
+	if params == nil {
+		params = make(map[string]string, 1)
+	}
+	params["**"] = rest
+	if built := substituteParams(template, params, nil); built != "" {
+		return built
+	}
+	return "/"
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestPathRewrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		replacement string
+		path        string
+		params      map[string]string
+		want        string
+	}{
+		{"capture group", `^/api/v1/users/(.*)$`, "/internal/users/$1", "/api/v1/users/42/orders", nil, "/internal/users/42/orders"},
+		{"named group", `^/v2/(?P<rest>.*)$`, "/${rest}", "/v2/items", nil, "/items"},
+		{"path param", `^/users/[^/]+$`, "/accounts/{id}/profile", "/users/42", map[string]string{"id": "42"}, "/accounts/42/profile"},
+		{"param and group", `^/users/[^/]+/(.*)$`, "/u/{id}/$1", "/users/7/settings", map[string]string{"id": "7"}, "/u/7/settings"},
+		{"dollar in param is literal", `^/files/.*$`, "/blob/{name}", "/files/x", map[string]string{"name": "a$1b"}, "/blob/a$1b"},
+		{"unknown param kept", `^/a$`, "/b/{missing}", "/a", nil, "/b/{missing}"},
+		{"no match unchanged", `^/api/(.*)$`, "/internal/$1", "/other", nil, "/other"},
+		{"leading slash added", `^/api/(.*)$`, "$1", "/api/ping", nil, "/ping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := newPathRewrite(&config.Rewrite{Pattern: tt.pattern, Replacement: tt.replacement})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rw.apply(tt.path, tt.params); got != tt.want {
+				t.Errorf("apply(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_Rewrite(t *testing.T) {
+	var gotPath, gotQuery string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery = r.URL.Path, r.URL.RawQuery
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Path = "/api/v1/users/:id/**"
+		cfg.Routes[0].Rewrite = &config.Rewrite{
+			Pattern:     `^/api/v1/users/[^/]+/(.*)$`,
+			Replacement: "/internal/users/{id}/$1",
+		}
+	})
+
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/users/42/orders/9?expand=items", nil))
+
+	if gotPath != "/internal/users/42/orders/9" {
+		t.Errorf("upstream path = %q", gotPath)
+	}
+	if gotQuery != "expand=items" {
+		t.Errorf("upstream query = %q, want it preserved", gotQuery)
+	}
+}
+
+func TestProxy_HostParam(t *testing.T) {
+	var gotPath, gotTenant string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotTenant = r.URL.Path, r.Header.Get("X-Tenant")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].Hosts = []string{"api.example.com", "*.tenant.example.com"}
+		cfg.Routes[0].Headers = map[string]string{"X-Tenant": "{host.tenant}"}
+		cfg.Routes[0].Rewrite = &config.Rewrite{Pattern: `^/(.*)$`, Replacement: "/tenants/{host.tenant}/$1"}
+	})
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Host = "acme.tenant.example.com"
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if gotTenant != "acme" || gotPath != "/tenants/acme/orders" {
+		t.Errorf("X-Tenant = %q, path = %q, want acme and /tenants/acme/orders", gotTenant, gotPath)
+	}
+
+	// An exact host captures nothing, so the placeholder is left alone.
+	req = httptest.NewRequest("GET", "/orders", nil)
+	req.Host = "api.example.com"
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if gotTenant != "{host.tenant}" {
+		t.Errorf("X-Tenant = %q for an exact host", gotTenant)
+	}
+}
+
+func TestProxy_UpstreamPath(t *testing.T) {
+	var gotPath, gotQuery, gotPrefix string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery, gotPrefix = r.URL.Path, r.URL.RawQuery, r.Header.Get("X-Forwarded-Prefix")
+	}))
+	defer backend.Close()
+
+	for _, tt := range []struct {
+		name, path, upstreamPath string
+		strip                    bool
+		request, want            string
+	}{
+		{"remainder", "/tenants/:tenant/users/**", "/internal/{tenant}/users/{**}", false, "/tenants/acme/users/42/orders?x=1", "/internal/acme/users/42/orders"},
+		{"empty remainder", "/tenants/:tenant/users/**", "/internal/{tenant}/users/{**}", false, "/tenants/acme/users", "/internal/acme/users"},
+		{"single wildcard", "/tenants/{tenant}/users/*", "/users/{**}", false, "/tenants/acme/users/42", "/users/42"},
+		// upstream_path takes precedence over strip_path.
+		{"strip_path", "/tenants/:tenant/users/**", "/internal/{tenant}/users/{**}", true, "/tenants/acme/users/42", "/internal/acme/users/42"},
+		{"root", "/legacy/**", "/{**}", true, "/legacy", "/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.Routes[0].Path = tt.path
+				cfg.Routes[0].UpstreamPath = tt.upstreamPath
+				cfg.Routes[0].StripPath = tt.strip
+			})
+			gotPath, gotQuery, gotPrefix = "", "", ""
+			p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.request, nil))
+			if gotPath != tt.want {
+				t.Errorf("upstream path = %q, want %q", gotPath, tt.want)
+			}
+			if strings.Contains(tt.request, "?") && gotQuery != "x=1" {
+				t.Errorf("upstream query = %q, want it preserved", gotQuery)
+			}
+			if gotPrefix != "" {
+				t.Errorf("X-Forwarded-Prefix = %q, want none for a built path", gotPrefix)
+			}
+		})
+	}
+}
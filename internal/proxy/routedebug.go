@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/capture"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+const (
+	defaultDebugDuration = 5 * time.Minute
+	// maxDebugDuration bounds a session, so a forgotten one does not keep
+	// logging headers for days.
+	maxDebugDuration = time.Hour
+	// maxDebugSessions caps the routes debugged at once across the
+	// gateway, since every debugged request is logged in full.
+	maxDebugSessions = 4
+
+	debugRouteHeader  = "X-Debug-Route"
+	debugTargetHeader = "X-Debug-Target"
+)
+
+var (
+	errDebugSessionLimit = errors.New("too many debug sessions")
+	errDebugActive       = errors.New("route is already being debugged")
+)
+
+// debugSession is one time-boxed debug capture of a route. It is not
+// modified once published.
+type debugSession struct {
+	route   string
+	started time.Time
+	until   time.Time
+	headers bool // add debug headers to responses
+}
+
+// routeDebugger holds the debug session of each route. The request path
+// only loads an atomic pointer; sessions expire on the first request after
+// their end or when their timer fires, whichever comes first.
+type routeDebugger struct {
+	sessions map[string]*atomic.Pointer[debugSession] // by route name, fixed after New
+	owners   map[string]string                        // route owners by route name
+
+	mu     sync.Mutex // serializes starts against the session cap
+	active int
+	timers map[*debugSession]*time.Timer
+
+	now      func() time.Time // replaced in tests
+	redactor *capture.Redactor
+	logger   *slog.Logger
+	events   *events.Bus
+}
+
+func (p *Proxy) setupRouteDebug() {
+	d := &routeDebugger{
+		sessions: make(map[string]*atomic.Pointer[debugSession]),
+		timers:   make(map[*debugSession]*time.Timer),
+		owners:   make(map[string]string),
+		now:      time.Now,
+		redactor: capture.NewRedactor([]string{"Set-Cookie"}),
+		logger:   p.logger,
+		events:   p.events,
+	}
+	for _, r := range p.config.Routes {
+		name := r.Name
+		if name == "" {
+			name = r.Path
+		}
+		d.sessions[name] = &atomic.Pointer[debugSession]{}
+		if r.Owner != "" {
+			d.owners[name] = r.Owner
+		}
+	}
+	p.debug = d
+}
+
+// session returns the route's debug session, or nil when it is not being
+// debugged.
+func (d *routeDebugger) session(route string) *debugSession {
+	slot, ok := d.sessions[route]
+	if !ok {
+		return nil
+	}
+	s := slot.Load()
+	if s == nil {
+		return nil
+	}
+	if !d.now().Before(s.until) {
+		d.end(s, "expired")
+		return nil
+	}
+	return s
+}
+
+// start begins a session on route lasting duration.
+func (d *routeDebugger) start(route string, duration time.Duration, headers bool, by string) (*debugSession, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	slot := d.sessions[route]
+	if old := slot.Load(); old != nil {
+		if d.now().Before(old.until) {
+			return nil, errDebugActive
+		}
+		d.endLocked(old, "expired")
+	}
+	if d.active >= maxDebugSessions {
+		return nil, errDebugSessionLimit
+	}
+
+	now := d.now()
+	s := &debugSession{route: route, started: now, until: now.Add(duration), headers: headers}
+	slot.Store(s)
+	d.active++
+	d.timers[s] = time.AfterFunc(duration, func() { d.end(s, "expired") })
+
+	d.logger.Warn("route debug started", "route", route, "duration", duration, "debug_headers", headers, "by", by)
+	data := map[string]any{
+		"route":         route,
+		"action":        "started",
+		"until":         s.until,
+		"debug_headers": headers,
+		"by":            by,
+	}
+	if owner := d.owners[route]; owner != "" {
+		data["owner"] = owner
+	}
+	d.events.Publish(events.RouteDebug, data)
+	return s, nil
+}
+
+// end finishes s unless it already ended. reason is expired or stopped.
+func (d *routeDebugger) end(s *debugSession, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endLocked(s, reason)
+}
+
+func (d *routeDebugger) endLocked(s *debugSession, reason string) {
+	if !d.sessions[s.route].CompareAndSwap(s, nil) {
+		return
+	}
+	d.active--
+	if t := d.timers[s]; t != nil {
+		t.Stop()
+		delete(d.timers, s)
+	}
+
+	d.logger.Warn("route debug ended", "route", s.route, "reason", reason, "duration", d.now().Sub(s.started))
+	data := map[string]any{
+		"route":  s.route,
+		"action": "ended",
+		"reason": reason,
+	}
+	if owner := d.owners[s.route]; owner != "" {
+		data["owner"] = owner
+	}
+	d.events.Publish(events.RouteDebug, data)
+}
+
+// stop ends the route's session early. It reports whether one was active.
+func (d *routeDebugger) stop(route string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.sessions[route].Load()
+	if s == nil {
+		return false
+	}
+	d.endLocked(s, "stopped")
+	return true
+}
+
+// stopAll ends every session, when the proxy stops.
+func (d *routeDebugger) stopAll() {
+	for route := range d.sessions {
+		d.stop(route)
+	}
+}
+
+// logDebugRequest writes everything known about a request on a debugged
+// route. Credentials are redacted from the headers, along with any names
+// the route's capture block redacts, and query values are dropped.
+func (p *Proxy) logDebugRequest(req *pipelineRequest, timing *requestTiming) {
+	requestHeaders := p.debug.redactor.Header(req.r.Header)
+	responseHeaders := p.debug.redactor.Header(req.w.Header())
+	if c := req.route.Capture; c != nil && p.redactors[c] != nil {
+		requestHeaders = p.redactors[c].Header(requestHeaders)
+		responseHeaders = p.redactors[c].Header(responseHeaders)
+	}
+	phases, reused := timing.phases()
+	p.logger.Info("route debug request",
+		"route", req.routeName,
+		"request_id", requestIDFrom(req.r.Context()),
+		"method", req.r.Method,
+		"path", req.r.URL.Path,
+		"query", redactQuery(req.r.URL.RawQuery),
+		"client_ip", req.clientIP,
+		"api_key", req.apiKeyName,
+		"request_headers", requestHeaders,
+		"upstream", req.route.Upstream,
+		"target", req.targetURL,
+		"status", req.w.status,
+		"response_headers", responseHeaders,
+		"duration", time.Since(req.start),
+		"conn_reused", reused,
+		"phases", phases,
+	)
+}
+
+// setDebugHeaders tells the client which route and target served it, when
+// the route's debug session asks for it.
+func setDebugHeaders(req *pipelineRequest) {
+	if req.debug == nil || !req.debug.headers {
+		return
+	}
+	req.w.Header().Set(debugRouteHeader, req.routeName)
+	req.w.Header().Set(debugTargetHeader, req.targetURL)
+}
+
+// handleStartRouteDebug starts a debug session on a route for the duration
+// query parameter (default 5m, at most 1h). With headers=true responses
+// carry debug headers.
+func (p *Proxy) handleStartRouteDebug(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("name")
+	if _, ok := p.debug.sessions[route]; !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	duration := defaultDebugDuration
+	if v := r.URL.Query().Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxDebugDuration {
+			writeJSONError(w, http.StatusBadRequest, "duration must be positive and at most 1h", "")
+			return
+		}
+		duration = d
+	}
+	headers := r.URL.Query().Get("headers") == "true"
+
+	s, err := p.debug.start(route, duration, headers, p.clientIP(r))
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error(), "")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"route":         s.route,
+		"until":         s.until,
+		"debug_headers": s.headers,
+	})
+}
+
+// handleStopRouteDebug ends a route's debug session early.
+func (p *Proxy) handleStopRouteDebug(w http.ResponseWriter, r *http.Request) {
+	if _, ok := p.debug.sessions[r.PathValue("name")]; !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	if !p.debug.stop(r.PathValue("name")) {
+		writeJSONError(w, http.StatusNotFound, "route is not being debugged", "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+func startRouteDebug(p *Proxy, route, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/routes/"+route+"/debug"+query, nil))
+	return rec
+}
+
+func TestProxy_RouteDebug(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=upstream-secret")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.FlightRecorder = config.FlightRecorderConfig{Enabled: true, Size: 10, SampleRate: 0}
+	})
+	var logs bytes.Buffer
+	p.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	p.debug.logger = p.logger
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.debug.now = func() time.Time { return now }
+
+	send := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/orders?token=abc&page=2", nil)
+		req.Header.Set(requestIDHeader, id)
+		req.Header.Set("Authorization", "Bearer client-secret")
+		req.Header.Set("X-Tenant", "acme")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	send("before")
+	if logs.Len() != 0 {
+		t.Fatalf("request logged before debugging started: %s", logs.String())
+	}
+
+	if rec := startRouteDebug(p, "test", "?duration=5m&headers=true"); rec.Code != http.StatusOK {
+		t.Fatalf("POST debug: status = %d, body = %q", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(logs.String(), `"msg":"route debug started"`) {
+		t.Errorf("no audit entry for the start: %s", logs.String())
+	}
+
+	logs.Reset()
+	rec := send("during")
+	out := logs.String()
+	for _, want := range []string{`"msg":"route debug request"`, `"X-Tenant":["acme"]`, `"status":200`, `"ttfb_ms"`, `"target":"` + backend.URL} {
+		if !strings.Contains(out, want) {
+			t.Errorf("debug log lacks %s: %s", want, out)
+		}
+	}
+	for _, secret := range []string{"client-secret", "upstream-secret", "token=abc"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("debug log leaks %q: %s", secret, out)
+		}
+	}
+	if rec.Header().Get(debugRouteHeader) != "test" || rec.Header().Get(debugTargetHeader) != backend.URL {
+		t.Errorf("debug headers = %q, %q", rec.Header().Get(debugRouteHeader), rec.Header().Get(debugTargetHeader))
+	}
+	if _, ok := p.recorder.Get("during"); !ok {
+		t.Error("debugged request was not flight recorded despite sample_rate 0")
+	}
+
+	now = now.Add(5 * time.Minute)
+	logs.Reset()
+	rec = send("after")
+	if !strings.Contains(logs.String(), `"msg":"route debug ended"`) || !strings.Contains(logs.String(), `"reason":"expired"`) {
+		t.Errorf("no audit entry for the expiry: %s", logs.String())
+	}
+	if strings.Contains(logs.String(), "route debug request") {
+		t.Errorf("request logged after the session expired: %s", logs.String())
+	}
+	if rec.Header().Get(debugRouteHeader) != "" {
+		t.Error("debug headers sent after the session expired")
+	}
+	if entries := p.recorder.Query(flightrecorder.Filter{Limit: 10}); len(entries) != 1 {
+		t.Errorf("flight recorder has %d entries, want only the debugged one", len(entries))
+	}
+}
+
+func TestProxy_RouteDebugLimits(t *testing.T) {
+	p := newTestProxy(t, "http://localhost:1", func(cfg *config.Config) {
+		cfg.Routes = nil
+		for i := range maxDebugSessions + 1 {
+			cfg.Routes = append(cfg.Routes, config.Route{Name: fmt.Sprintf("r%d", i), Path: fmt.Sprintf("/r%d", i), Upstream: "backend"})
+		}
+	})
+	p.debug.logger = slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		route, query string
+		want         int
+	}{
+		{"missing", "", http.StatusNotFound},
+		{"r0", "?duration=2h", http.StatusBadRequest},
+		{"r0", "?duration=soon", http.StatusBadRequest},
+		{"r0", "", http.StatusOK},
+		{"r0", "", http.StatusConflict}, // already running
+	}
+	for _, tt := range tests {
+		if rec := startRouteDebug(p, tt.route, tt.query); rec.Code != tt.want {
+			t.Errorf("POST %s%s: status = %d, want %d", tt.route, tt.query, rec.Code, tt.want)
+		}
+	}
+
+	for i := 1; i < maxDebugSessions; i++ {
+		if rec := startRouteDebug(p, fmt.Sprintf("r%d", i), ""); rec.Code != http.StatusOK {
+			t.Fatalf("session %d: status = %d", i, rec.Code)
+		}
+	}
+	last := fmt.Sprintf("r%d", maxDebugSessions)
+	if rec := startRouteDebug(p, last, ""); rec.Code != http.StatusConflict {
+		t.Errorf("session over the cap: status = %d, want 409", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	p.AdminHandler().ServeHTTP(rec, httptest.NewRequest("DELETE", "/admin/routes/r0/debug", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE debug: status = %d", rec.Code)
+	}
+	if rec := startRouteDebug(p, last, ""); rec.Code != http.StatusOK {
+		t.Errorf("session after one stopped: status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+// setupRouteEnabled reports the routes configured with enabled: false.
+func (p *Proxy) setupRouteEnabled() {
+	for _, name := range p.router.Disabled() {
+		p.metrics.SetRouteDisabled(name, true)
+	}
+}
+
+// setRouteEnabled switches a route on or off. It reports whether anything
+// changed and whether there is such a route.
+func (p *Proxy) setRouteEnabled(route string, enabled bool, by string) (changed, ok bool) {
+	changed, ok = p.router.SetEnabled(route, enabled)
+	if !changed {
+		return changed, ok
+	}
+
+	p.metrics.SetRouteDisabled(route, !enabled)
+	var totals routeTotals
+	if ra := p.activity.routes[route]; ra != nil {
+		totals = ra.totals()
+	}
+	msg := "route disabled"
+	if enabled {
+		msg = "route enabled"
+	}
+	p.logger.Warn(msg, "route", route, "by", by, "requests", totals.Requests, "errors", totals.Errors)
+	data := map[string]any{"route": route, "enabled": enabled, "by": by}
+	if owner := p.routeOwner(route); owner != "" {
+		data["owner"] = owner
+	}
+	p.events.Publish(events.RouteEnabled, data)
+	return true, true
+}
+
+// SetRoutesEnabled applies the enabled settings of a reloaded
+// configuration. Routes are matched by name; routes that are not running
+// are ignored.
+func (p *Proxy) SetRoutesEnabled(routes []config.Route) {
+	for _, r := range routes {
+		p.setRouteEnabled(configRouteName(r), r.Enabled == nil || *r.Enabled, "config_reload")
+	}
+}
+
+// handleEnableRoute switches a route on.
+func (p *Proxy) handleEnableRoute(w http.ResponseWriter, r *http.Request) {
+	p.toggleRoute(w, r, true)
+}
+
+// handleDisableRoute switches a route off, so that its requests fall
+// through to the routes after it.
+func (p *Proxy) handleDisableRoute(w http.ResponseWriter, r *http.Request) {
+	p.toggleRoute(w, r, false)
+}
+
+func (p *Proxy) toggleRoute(w http.ResponseWriter, r *http.Request, enabled bool) {
+	route := r.PathValue("name")
+	changed, ok := p.setRouteEnabled(route, enabled, p.clientIP(r))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown route", "")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"route": route, "enabled": enabled, "changed": changed})
+}
+
+// handleListDisabledRoutes lists the disabled routes.
+func (p *Proxy) handleListDisabledRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"routes": p.router.Disabled()})
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+)
+
+func TestProxy_RouteEnable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Route")))
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes = []config.Route{
+			{Name: "users", Path: "/users/**", Upstream: "backend", Headers: map[string]string{"X-Route": "users"}},
+			{Name: "fallback", Path: "/**", Upstream: "backend", Headers: map[string]string{"X-Route": "fallback"}},
+		}
+	})
+	sub := p.Events().Subscribe([]string{events.RouteEnabled}, 8)
+	defer p.Events().Unsubscribe(sub)
+
+	admin := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	get := func() string {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/users/1", nil))
+		return rec.Body.String()
+	}
+
+	if got := get(); got != "users" {
+		t.Fatalf("before disable: routed to %q, want users", got)
+	}
+	if rec := admin("POST", "/admin/routes/missing/disable"); rec.Code != http.StatusNotFound {
+		t.Errorf("disable unknown route: status = %d, want 404", rec.Code)
+	}
+
+	rec := admin("POST", "/admin/routes/users/disable")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"changed":true`) {
+		t.Fatalf("disable: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := get(); got != "fallback" {
+		t.Errorf("while disabled: routed to %q, want fallback", got)
+	}
+	if list := admin("GET", "/admin/routes/disabled"); !strings.Contains(list.Body.String(), `"routes":["users"]`) {
+		t.Errorf("GET /admin/routes/disabled = %s", list.Body.String())
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `gateway_route_disabled{route="users"} 1`; !strings.Contains(metrics.Body.String(), want) {
+		t.Errorf("metrics missing %s", want)
+	}
+
+	if rec := admin("POST", "/admin/routes/users/enable"); rec.Code != http.StatusOK {
+		t.Fatalf("enable: status = %d", rec.Code)
+	}
+	if got := get(); got != "users" {
+		t.Errorf("after enable: routed to %q, want users", got)
+	}
+
+	// A reload that sets enabled: false takes the route dark again.
+	disabled := false
+	p.SetRoutesEnabled([]config.Route{{Name: "users", Path: "/users/**", Upstream: "backend", Enabled: &disabled}})
+	if got := get(); got != "fallback" {
+		t.Errorf("after reload: routed to %q, want fallback", got)
+	}
+
+	for _, want := range []struct {
+		enabled bool
+		by      string
+	}{{false, "192.0.2.1"}, {true, "192.0.2.1"}, {false, "config_reload"}} {
+		ev := <-sub.C
+		if ev.Data["enabled"] != want.enabled || ev.Data["by"] != want.by {
+			t.Errorf("event = %+v, want enabled %v by %s", ev.Data, want.enabled, want.by)
+		}
+	}
+}
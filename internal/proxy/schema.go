@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/jsonschema"
+)
+
+// defaultSchemaMaxSize is the largest body validated against a route's
+// request_schema when it does not set max_size.
+const defaultSchemaMaxSize = 1 << 20
+
+// requestValidator checks request bodies against a route's schema.
+type requestValidator struct {
+	schema  *jsonschema.Schema
+	maxSize int64
+	skip    map[string]bool
+}
+
+// newRequestValidator compiles the schema of cfg. Routes naming the same
+// file share one compiled schema through compiled.
+func newRequestValidator(cfg *config.RequestSchema, compiled map[string]*jsonschema.Schema) (*requestValidator, error) {
+	schema, ok := compiled[cfg.File]
+	if !ok {
+		var err error
+		if schema, err = jsonschema.CompileFile(cfg.File); err != nil {
+			return nil, err
+		}
+		compiled[cfg.File] = schema
+	}
+	v := &requestValidator{schema: schema, maxSize: cfg.MaxSize, skip: make(map[string]bool)}
+	if v.maxSize <= 0 {
+		v.maxSize = defaultSchemaMaxSize
+	}
+	for _, m := range cfg.SkipMethods {
+		v.skip[m] = true
+	}
+	return v, nil
+}
+
+func (p *Proxy) schemaStage(req *pipelineRequest) bool {
+	v, ok := p.validators[req.route.RequestSchema]
+	r := req.r
+	// Requests without a body have nothing to validate.
+	if !ok || v.skip[r.Method] || r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return true
+	}
+
+	if !isJSONRequest(r) {
+		p.rejectBody(req, http.StatusUnsupportedMediaType, "unsupported_media_type", "request body must be JSON", "content_type", nil)
+		return false
+	}
+	data, complete, err := bufferBody(r, v.maxSize)
+	if err != nil {
+		p.writeBodyError(req.w, req.routeName, err)
+		return false
+	}
+	if !complete {
+		p.rejectBody(req, http.StatusRequestEntityTooLarge, "body_too_large", "request body too large to validate", "max_size", nil)
+		return false
+	}
+
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		violations := []jsonschema.Violation{{Keyword: "json", Message: "invalid JSON"}}
+		p.rejectBody(req, http.StatusBadRequest, "schema_violation", "request body is not valid JSON", "json", violations)
+		return false
+	}
+	if violations := v.schema.Validate(doc); len(violations) > 0 {
+		for _, violation := range violations[1:] {
+			p.metrics.RecordSchemaViolation(req.routeName, violation.Keyword)
+		}
+		p.rejectBody(req, http.StatusBadRequest, "schema_violation", "request body does not match the schema", violations[0].Keyword, violations)
+		return false
+	}
+	return true
+}
+
+// rejectBody answers a request whose body failed validation and counts the
+// failed keyword.
+func (p *Proxy) rejectBody(req *pipelineRequest, status int, errType, message, keyword string, violations []jsonschema.Violation) {
+	p.metrics.RecordSchemaViolation(req.routeName, keyword)
+	p.metrics.RecordError(req.routeName, errType)
+	p.writeErrorDetail(req.w, status, errType, message, violations)
+}
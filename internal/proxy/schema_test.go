@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+const orderSchema = `{
+	"type": "object",
+	"required": ["sku", "quantity"],
+	"properties": {
+		"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+		"quantity": {"type": "integer", "minimum": 1}
+	},
+	"additionalProperties": false
+}`
+
+func writeSchema(t *testing.T, schema string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProxy_RequestSchema(t *testing.T) {
+	backend := namedBackend(t, "backend")
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].RequestSchema = &config.RequestSchema{
+			File:        writeSchema(t, orderSchema),
+			MaxSize:     64,
+			SkipMethods: []string{"PATCH"},
+		}
+	})
+	gw := httptest.NewServer(p)
+	defer gw.Close()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        int
+		keywords    []string
+	}{
+		{"valid", "POST", "application/json", `{"sku":"ABC-1","quantity":2}`, http.StatusOK, nil},
+		{"violations", "POST", "application/json", `{"sku":"abc","quantity":0,"note":"x"}`, http.StatusBadRequest, []string{"minimum", "pattern", "additionalProperties"}},
+		{"invalid json", "POST", "application/json", `{"sku":`, http.StatusBadRequest, []string{"json"}},
+		{"not json", "POST", "text/plain", `sku=ABC-1`, http.StatusUnsupportedMediaType, nil},
+		{"too large", "POST", "application/json", `{"sku":"ABC-1","quantity":2,"pad":"` + strings.Repeat("x", 64) + `"}`, http.StatusRequestEntityTooLarge, nil},
+		{"skipped method", "PATCH", "application/json", `{"quantity":"many"}`, http.StatusOK, nil},
+		{"no body", "GET", "", "", http.StatusOK, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req, _ := http.NewRequest(tt.method, gw.URL+"/orders", body)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", resp.StatusCode, tt.want, got)
+			}
+			if tt.want == http.StatusOK {
+				if want := "backend:" + tt.body; string(got) != want {
+					t.Errorf("response = %q, want %q", got, want)
+				}
+				return
+			}
+
+			var envelope struct {
+				Error struct {
+					Code   int `json:"code"`
+					Errors []struct {
+						Path    string `json:"path"`
+						Keyword string `json:"keyword"`
+					} `json:"errors"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(got, &envelope); err != nil {
+				t.Fatalf("decoding %s: %v", got, err)
+			}
+			if envelope.Error.Code != tt.want {
+				t.Errorf("error code = %d, want %d", envelope.Error.Code, tt.want)
+			}
+			var keywords []string
+			for _, e := range envelope.Error.Errors {
+				keywords = append(keywords, e.Keyword)
+			}
+			if strings.Join(keywords, ",") != strings.Join(tt.keywords, ",") {
+				t.Errorf("violated keywords = %v, want %v", keywords, tt.keywords)
+			}
+		})
+	}
+
+	metrics := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+	for _, key := range []string{"test_pattern", "test_minimum", "test_json", "test_content_type", "test_max_size"} {
+		if !strings.Contains(metrics.Body.String(), `gateway_schema_violations_total{key="`+key+`"} 1`) {
+			t.Errorf("schema violation %s was not recorded", key)
+		}
+	}
+}
+
+func TestNew_InvalidRequestSchema(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.CleanupInterval = 0
+	cfg.Upstreams = []config.Upstream{{Name: "backend", Targets: []config.Target{{URL: "http://localhost:1"}}}}
+	cfg.Routes = []config.Route{{
+		Name:          "orders",
+		Path:          "/**",
+		Upstream:      "backend",
+		RequestSchema: &config.RequestSchema{File: writeSchema(t, `{"type": "object", "dependentRequired": {}}`)},
+	}}
+
+	_, err := New(cfg)
+	if err == nil || !strings.Contains(err.Error(), "route orders request_schema") {
+		t.Fatalf("New() error = %v, want one naming the route", err)
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// Default security header values, used unless configured otherwise.
+const (
+	defaultStrictTransportSecurity = "max-age=31536000; includeSubDomains"
+	defaultContentTypeOptions      = "nosniff"
+	defaultFrameOptions            = "DENY"
+	defaultReferrerPolicy          = "strict-origin-when-cross-origin"
+)
+
+// serverTokenHeaders name the software behind a response.
+var serverTokenHeaders = []string{"Server", "X-Powered-By"}
+
+// securityHeaders is a resolved security_headers block.
+type securityHeaders struct {
+	headers          [][2]string // name and value, in a fixed order
+	override         bool
+	hideServerTokens bool
+}
+
+// setupSecurityHeaders resolves the top-level block and every route's
+// override of it, keyed like the other per-route settings by the route's
+// block; the nil key holds the top-level one.
+func (p *Proxy) setupSecurityHeaders() {
+	p.securityHeaders = map[*config.SecurityHeaders]*securityHeaders{
+		nil: resolveSecurityHeaders(p.config.SecurityHeaders, nil),
+	}
+	for _, route := range p.config.Routes {
+		if route.SecurityHeaders != nil {
+			p.securityHeaders[route.SecurityHeaders] = resolveSecurityHeaders(p.config.SecurityHeaders, route.SecurityHeaders)
+		}
+	}
+}
+
+// resolveSecurityHeaders merges a route's block over the top-level one. It
+// returns nil when there is nothing to do.
+func resolveSecurityHeaders(global config.SecurityHeaders, route *config.SecurityHeaders) *securityHeaders {
+	if route == nil {
+		route = &config.SecurityHeaders{}
+	}
+	flag := func(routeValue, globalValue *bool) bool {
+		if routeValue != nil {
+			return *routeValue
+		}
+		return globalValue != nil && *globalValue
+	}
+	s := &securityHeaders{
+		override:         flag(route.Override, global.Override),
+		hideServerTokens: flag(route.HideServerTokens, global.HideServerTokens),
+	}
+	if flag(route.Enabled, global.Enabled) {
+		for _, h := range []struct {
+			name                    string
+			route, global, fallback string
+		}{
+			{"Strict-Transport-Security", route.StrictTransportSecurity, global.StrictTransportSecurity, defaultStrictTransportSecurity},
+			{"X-Content-Type-Options", route.ContentTypeOptions, global.ContentTypeOptions, defaultContentTypeOptions},
+			{"X-Frame-Options", route.FrameOptions, global.FrameOptions, defaultFrameOptions},
+			{"Referrer-Policy", route.ReferrerPolicy, global.ReferrerPolicy, defaultReferrerPolicy},
+			{"Content-Security-Policy", route.ContentSecurityPolicy, global.ContentSecurityPolicy, ""},
+		} {
+			value := h.route
+			if value == "" {
+				value = h.global
+			}
+			if value == "" {
+				value = h.fallback
+			}
+			if value != "" && !strings.EqualFold(value, config.SecurityHeaderOff) {
+				s.headers = append(s.headers, [2]string{h.name, value})
+			}
+		}
+	}
+	if len(s.headers) == 0 && !s.hideServerTokens {
+		return nil
+	}
+	return s
+}
+
+// securityHeadersFor returns the resolved block that applies on route, or
+// the top-level one before a route is known.
+func (p *Proxy) securityHeadersFor(route *router.Route) *securityHeaders {
+	if route != nil {
+		if s, ok := p.securityHeaders[route.SecurityHeaders]; ok {
+			return s
+		}
+	}
+	return p.securityHeaders[nil]
+}
+
+// apply sets the security headers on a response about to be written.
+// Headers the upstream or a response_headers rule already set are kept
+// unless override is on.
+func (s *securityHeaders) apply(h http.Header) {
+	if s == nil {
+		return
+	}
+	if s.hideServerTokens {
+		for _, name := range serverTokenHeaders {
+			deleteHeader(h, name)
+		}
+	}
+	for _, kv := range s.headers {
+		if !s.override && hasHeader(h, kv[0]) {
+			continue
+		}
+		deleteHeader(h, kv[0])
+		h.Set(kv[0], kv[1])
+	}
+}
+
+// hasHeader reports whether h has name, matched case-insensitively.
+func hasHeader(h http.Header, name string) bool {
+	for k := range h {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
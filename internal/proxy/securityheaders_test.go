@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_SecurityHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25.3")
+		w.Header().Set("X-Powered-By", "PHP/8.3")
+		if r.URL.Path == "/embeddable" {
+			w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		}
+	}))
+	defer backend.Close()
+
+	on, off := true, false
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.SecurityHeaders = config.SecurityHeaders{
+			Enabled:               &on,
+			ContentSecurityPolicy: "default-src 'self'",
+			HideServerTokens:      &on,
+		}
+		cfg.Routes = []config.Route{
+			{Name: "legacy", Path: "/legacy/**", Upstream: "backend", SecurityHeaders: &config.SecurityHeaders{Enabled: &off}},
+			{Name: "docs", Path: "/docs/**", Upstream: "backend", SecurityHeaders: &config.SecurityHeaders{
+				FrameOptions:          "off",
+				ContentSecurityPolicy: "default-src 'self' cdn.example.com",
+				HideServerTokens:      &off,
+			}},
+			{Name: "forced", Path: "/forced/**", Upstream: "backend", SecurityHeaders: &config.SecurityHeaders{Override: &on}},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+	})
+
+	const absent = "<absent>"
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{"/orders", map[string]string{
+			"Strict-Transport-Security": defaultStrictTransportSecurity,
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           defaultReferrerPolicy,
+			"Content-Security-Policy":   "default-src 'self'",
+			"Server":                    absent,
+			"X-Powered-By":              absent,
+		}},
+		// The upstream's own header wins.
+		{"/embeddable", map[string]string{"X-Frame-Options": "SAMEORIGIN", "X-Content-Type-Options": "nosniff"}},
+		{"/forced/embeddable", map[string]string{"X-Frame-Options": "DENY"}},
+		// Turning the headers off on a route keeps hiding server tokens.
+		{"/legacy/page", map[string]string{
+			"Strict-Transport-Security": absent,
+			"Content-Security-Policy":   absent,
+			"Server":                    absent,
+		}},
+		{"/docs/page", map[string]string{
+			"X-Frame-Options":         absent,
+			"Referrer-Policy":         defaultReferrerPolicy,
+			"Content-Security-Policy": "default-src 'self' cdn.example.com",
+			"Server":                  "nginx/1.25.3",
+			"X-Powered-By":            "PHP/8.3",
+		}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", tt.path, rec.Code)
+		}
+		for name, want := range tt.want {
+			got := rec.Header().Get(name)
+			if want == absent {
+				if _, ok := rec.Header()[name]; ok {
+					t.Errorf("%s: %s = %q, want none", tt.path, name, got)
+				}
+			} else if got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.path, name, got, want)
+			}
+		}
+	}
+}
+
+func TestProxy_SecurityHeadersGatewayResponses(t *testing.T) {
+	on := true
+	p := newTestProxy(t, "http://localhost:1", func(cfg *config.Config) {
+		cfg.SecurityHeaders.Enabled = &on
+		cfg.Routes[0].Path = "/api/**"
+	})
+
+	// Errors answered before and after routing carry the headers too.
+	for _, path := range []string{"/missing", "/api/orders"} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code < 400 {
+			t.Fatalf("%s: status = %d, want an error", path, rec.Code)
+		}
+		if got := rec.Header().Get("Strict-Transport-Security"); got != defaultStrictTransportSecurity {
+			t.Errorf("%s: Strict-Transport-Security = %q", path, got)
+		}
+	}
+}
+
+func TestProxy_SecurityHeadersOffByDefault(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, nil)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/x", nil))
+	if got := rec.Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("X-Frame-Options = %q, want none", got)
+	}
+	if got := rec.Header().Get("Server"); got != "nginx" {
+		t.Errorf("Server = %q, want the upstream's", got)
+	}
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+)
+
+// addServerTiming appends the time spent in the gateway before the
+// response headers, and the upstream round trip, to the Server-Timing
+// metrics in h, after any the upstream sent:
+//
+//	Server-Timing: db;dur=12, gateway;dur=2.1, upstream;dur=145.3
+func addServerTiming(h http.Header, start time.Time, upstream time.Duration) {
+	gateway := time.Since(start) - upstream
+	metrics := append(h.Values("Server-Timing"),
+		"gateway;dur="+formatMillis(gateway),
+		"upstream;dur="+formatMillis(upstream),
+	)
+	h.Set("Server-Timing", strings.Join(metrics, ", "))
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(flightrecorder.Millis(d), 'f', 1, 64)
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// serverTimingDurations parses the dur of each metric in a Server-Timing
+// header.
+func serverTimingDurations(t *testing.T, header string) map[string]float64 {
+	t.Helper()
+	durations := make(map[string]float64)
+	for _, metric := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(metric), ";")
+		dur, ok := strings.CutPrefix(params, "dur=")
+		if !ok {
+			t.Fatalf("metric %q has no duration in %q", name, header)
+		}
+		d, err := strconv.ParseFloat(dur, 64)
+		if err != nil {
+			t.Fatalf("metric %q: %v", name, err)
+		}
+		durations[name] = d
+	}
+	return durations
+}
+
+func TestProxy_ServerTiming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Server-Timing", "db;dur=12")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Routes[0].ServerTiming = true
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if values := rec.Header().Values("Server-Timing"); len(values) != 1 {
+		t.Fatalf("Server-Timing = %q, want one merged header", values)
+	}
+	durations := serverTimingDurations(t, rec.Header().Get("Server-Timing"))
+	if durations["db"] != 12 {
+		t.Errorf("upstream metric lost: %v", durations)
+	}
+	if durations["upstream"] < 50 {
+		t.Errorf("upstream;dur = %v, want at least the backend's 50ms", durations["upstream"])
+	}
+	if g, ok := durations["gateway"]; !ok || g < 0 || g >= 50 {
+		t.Errorf("gateway;dur = %v, want the gateway's own time only", g)
+	}
+}
+
+func TestProxy_ServerTimingIsOptIn(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server-Timing", "db;dur=12")
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, nil)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/items", nil))
+	if got := rec.Header().Get("Server-Timing"); got != "db;dur=12" {
+		t.Errorf("Server-Timing = %q, want the upstream's unchanged", got)
+	}
+}
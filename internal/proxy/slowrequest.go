@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/flightrecorder"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// slowRequestThreshold returns the duration from which requests on route
+// are logged as slow, falling back to the server-wide threshold. Zero means
+// never.
+func (p *Proxy) slowRequestThreshold(route *router.Route) time.Duration {
+	if route.SlowRequestThreshold > 0 {
+		return route.SlowRequestThreshold
+	}
+	return p.config.Server.SlowRequestThreshold
+}
+
+// observeSlowRequest logs and counts a proxied request that took at least
+// its route's slow request threshold, splitting the duration into the time
+// spent on the upstream and the time the gateway added. Requests that hit
+// the upstream timeout are left out: they are counted as upstream_timeout
+// errors already. WebSocket connections are left out too, since their
+// duration is the life of the connection.
+func (p *Proxy) observeSlowRequest(req *pipelineRequest, status int, duration time.Duration, err error) {
+	threshold := p.slowRequestThreshold(req.route)
+	if threshold <= 0 || duration < threshold || isWebSocketRequest(req.r) {
+		return
+	}
+	if err != nil && status == http.StatusGatewayTimeout {
+		return
+	}
+	var upstream time.Duration
+	if req.inflight != nil {
+		upstream = time.Duration(req.inflight.upstream.Load())
+	}
+	p.metrics.RecordSlowRequest(req.routeName)
+	p.logger.Warn("slow request",
+		"request_id", requestIDFrom(req.r.Context()),
+		"route", req.routeName,
+		"method", req.r.Method,
+		"path", req.r.URL.Path,
+		"target", req.targetURL,
+		"status", status,
+		"duration_ms", flightrecorder.Millis(duration),
+		"upstream_ms", flightrecorder.Millis(upstream),
+		"gateway_ms", flightrecorder.Millis(duration-upstream),
+		"threshold_ms", flightrecorder.Millis(threshold))
+}
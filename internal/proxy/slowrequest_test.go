@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func TestProxy_SlowRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, err := time.ParseDuration(r.URL.Query().Get("sleep")); err == nil {
+			time.Sleep(d)
+		}
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.SlowRequestThreshold = 200 * time.Millisecond
+		cfg.Routes = []config.Route{
+			{Name: "strict", Path: "/strict/**", Upstream: "backend", SlowRequestThreshold: 50 * time.Millisecond},
+			{Name: "bounded", Path: "/bounded/**", Upstream: "backend", Timeout: 50 * time.Millisecond},
+			{Name: "test", Path: "/**", Upstream: "backend"},
+		}
+	})
+	var logs bytes.Buffer
+	p.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/strict/a?sleep=80ms", http.StatusOK}, // slow for its route
+		{"/test/a?sleep=80ms", http.StatusOK},   // under the server threshold
+		{"/test/b?sleep=250ms", http.StatusOK},  // slow
+		{"/bounded/a?sleep=300ms", http.StatusGatewayTimeout},
+		{"/strict/b", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set(requestIDHeader, "req-"+strings.NewReplacer("/", "-", "?", "-").Replace(tt.path))
+		p.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(&logs)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == "slow request" {
+			records = append(records, record)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d slow request logs, want 2: %v", len(records), records)
+	}
+	if records[0]["route"] != "strict" || records[1]["route"] != "test" {
+		t.Errorf("slow routes = %v, %v, want strict and test", records[0]["route"], records[1]["route"])
+	}
+	r := records[1]
+	if r["level"] != "WARN" || r["target"] != backend.URL || r["status"] != float64(200) || r["request_id"] != "req--test-b-sleep=250ms" {
+		t.Errorf("log = %v", r)
+	}
+	duration, upstream, gateway := r["duration_ms"].(float64), r["upstream_ms"].(float64), r["gateway_ms"].(float64)
+	if upstream < 250 || gateway < 0 || duration < upstream+gateway-0.01 {
+		t.Errorf("duration_ms = %v, upstream_ms = %v, gateway_ms = %v", duration, upstream, gateway)
+	}
+
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_slow_requests_total{route="strict"} 1`,
+		`gateway_slow_requests_total{route="test"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+	if strings.Contains(rec.Body.String(), `gateway_slow_requests_total{route="bounded"}`) {
+		t.Error("timed out request counted as slow")
+	}
+}
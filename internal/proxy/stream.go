@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// copyResponse copies the upstream body to the client through buf,
+// flushing as it goes for streaming responses or routes with a
+// flush_interval.
+func copyResponse(w http.ResponseWriter, resp *http.Response, route *router.Route, buf []byte) error {
+	interval := flushInterval(resp, route)
+	if interval == 0 {
+		_, err := copyBody(w, resp.Body, buf)
+		return err
+	}
+
+	rc := http.NewResponseController(w)
+	// Long-lived streams must not be cut off by the server's WriteTimeout.
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	fw := &flushWriter{w: w, rc: rc, interval: interval}
+	defer fw.stop()
+
+	// Flush the headers right away so clients see the stream open.
+	_ = rc.Flush()
+
+	_, err := io.CopyBuffer(fw, resp.Body, buf)
+	return err
+}
+
+// flushInterval returns how often the response body should be flushed: 0 for
+// never (buffered by net/http), negative for after every write.
+func flushInterval(resp *http.Response, route *router.Route) time.Duration {
+	if isStreamingResponse(resp) {
+		return -1
+	}
+	return route.FlushInterval
+}
+
+// isStreamingResponse reports whether resp looks like a stream: an SSE body,
+// a chunked transfer, or a body of unknown length.
+func isStreamingResponse(resp *http.Response) bool {
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType == "text/event-stream" {
+		return true
+	}
+	for _, te := range resp.TransferEncoding {
+		if te == "chunked" {
+			return true
+		}
+	}
+	return resp.ContentLength == -1
+}
+
+// flushWriter flushes after every write when interval is negative, otherwise
+// at most interval after the first unflushed write.
+type flushWriter struct {
+	w        io.Writer
+	rc       *http.ResponseController
+	interval time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	pending bool
+	stopped bool
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if fw.interval < 0 {
+		_ = fw.rc.Flush()
+		return n, nil
+	}
+
+	if fw.pending {
+		return n, nil
+	}
+	fw.pending = true
+	if fw.timer == nil {
+		fw.timer = time.AfterFunc(fw.interval, fw.delayedFlush)
+	} else {
+		fw.timer.Reset(fw.interval)
+	}
+	return n, nil
+}
+
+func (fw *flushWriter) delayedFlush() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if !fw.pending || fw.stopped {
+		return
+	}
+	_ = fw.rc.Flush()
+	fw.pending = false
+}
+
+func (fw *flushWriter) stop() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.stopped = true
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+	if fw.pending {
+		_ = fw.rc.Flush()
+		fw.pending = false
+	}
+}
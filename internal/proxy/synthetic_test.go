@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/synthetic"
+)
+
+func TestProxy_SyntheticProbe(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"orders":[]}`)
+	}))
+	defer backend.Close()
+
+	probes := []config.SyntheticProbe{
+		{Name: "orders", Path: "/api/orders", ExpectBody: `"orders"`, Ready: true},
+	}
+	newProber := func(stripPath bool) (*Proxy, *synthetic.Prober) {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.RateLimit.Enabled = true
+			cfg.RateLimit.DefaultRPS = 1
+			cfg.RateLimit.DefaultBurst = 1
+			cfg.Routes = []config.Route{
+				{Name: "orders", Path: "/api/**", Upstream: "backend", StripPath: stripPath},
+			}
+		})
+		return p, synthetic.New(synthetic.Config{
+			Probes:  probes,
+			Handler: p,
+			Metrics: p.Metrics(),
+			Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+	}
+
+	p, prober := newProber(true)
+	for i := 0; i < 3; i++ {
+		prober.RunOnce()
+		if !prober.Ready() {
+			t.Fatalf("run %d: probe failed against a working route", i)
+		}
+	}
+	if stats := p.UsageStats(); len(stats) != 0 {
+		t.Errorf("probe traffic counted in usage stats: %+v", stats)
+	}
+	// Probes did not use up the loopback client's single token.
+	req := httptest.NewRequest("GET", "/api/orders", nil)
+	req.RemoteAddr = "127.0.0.1:0"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("client request after probes: status = %d", rec.Code)
+	}
+
+	// Dropping strip_path sends /api/orders upstream, which the backend
+	// does not serve.
+	_, prober = newProber(false)
+	prober.RunOnce()
+	if prober.Ready() {
+		t.Error("probe passed against a broken route")
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/tlsfp"
+)
+
+// startTLSGateway serves h over TLS with fingerprint capture installed.
+func startTLSGateway(t *testing.T, h http.Handler) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(h)
+	srv.TLS = &tls.Config{}
+	srv.Config.TLSConfig = srv.TLS
+	new(tlsfp.Capture).Install(srv.Config)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxy_RateLimitPerTLSFingerprint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.RateLimit = config.RateLimitConfig{
+			Enabled:           true,
+			DefaultRPS:        1,
+			DefaultBurst:      1,
+			PerTLSFingerprint: true,
+		}
+		cfg.Server.TLS = &config.ServerTLS{CertFile: "unused", KeyFile: "unused"}
+	})
+	gw := startTLSGateway(t, p)
+
+	var codes []int
+	for i := 0; i < 2; i++ {
+		// A fresh connection each time: the limit follows the fingerprint,
+		// not the connection.
+		client := gw.Client()
+		client.Transport.(*http.Transport).DisableKeepAlives = true
+		resp, err := client.Get(gw.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		codes = append(codes, resp.StatusCode)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("status codes = %v, want [200 429]", codes)
+	}
+
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `gateway_rate_limit_hits_total{key="test_tls_fp"} 1`) {
+		t.Errorf("expected a tls_fp rate limit hit, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestProxy_DenyTLSFingerprint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	// Learn the fingerprint of Go's TLS client first.
+	probe := startTLSGateway(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(tlsfp.FromContext(r.Context())))
+	}))
+	resp, err := probe.Client().Get(probe.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	fingerprint := string(body)
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Server.TLS = &config.ServerTLS{CertFile: "unused", KeyFile: "unused", DenyFingerprints: []string{strings.ToUpper(fingerprint)}}
+	})
+	gw := startTLSGateway(t, p)
+
+	resp, err = gw.Client().Get(gw.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("status = %d, want 403 for a denied fingerprint", resp.StatusCode)
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/router"
+	"github.com/relaypoint/relaypoint/internal/tracing"
+)
+
+// traceRoute names the server span after the matched route and records the
+// route attributes. It is only called with tracing enabled.
+func traceRoute(span *tracing.Span, r *http.Request, route *router.Route, routeName string) {
+	span.SetName(r.Method + " " + route.Pattern)
+	span.SetAttributes(
+		tracing.Attr{Key: "http.route", Value: route.Pattern},
+		tracing.Attr{Key: "relaypoint.route", Value: routeName},
+		tracing.Attr{Key: "relaypoint.upstream", Value: route.Upstream},
+	)
+}
+
+// startUpstreamSpan starts the client span for a call to target and passes
+// its trace context upstream. Routes with wire_fidelity forward the client's
+// traceparent unchanged. It returns nil with tracing disabled.
+func (p *Proxy) startUpstreamSpan(upstreamReq *http.Request, route *router.Route, target *loadbalancer.Target) *tracing.Span {
+	if p.tracer == nil {
+		return nil
+	}
+	span := p.tracer.StartClient(upstreamReq.Context(), upstreamReq.Method)
+	if span == nil {
+		return nil
+	}
+	span.SetAttributes(
+		tracing.Attr{Key: "relaypoint.route", Value: routeNameOf(route)},
+		tracing.Attr{Key: "relaypoint.upstream", Value: route.Upstream},
+		tracing.Attr{Key: "relaypoint.target", Value: target.URL.String()},
+		tracing.Attr{Key: "server.address", Value: upstreamReq.URL.Host},
+	)
+	if !route.WireFidelity {
+		span.Inject(upstreamReq.Header)
+	}
+	return span
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/tracing"
+)
+
+func TestProxy_Tracing(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		export []byte
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		export = append(export, body...)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Traceparent"))
+	}))
+	defer backend.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.Enabled = false
+	cfg.Tracing = config.TracingConfig{Enabled: true, Endpoint: collector.URL, SampleRatio: 1}
+	cfg.Upstreams = []config.Upstream{{Name: "backend", Targets: []config.Target{{URL: backend.URL}}}}
+	cfg.Routes = []config.Route{{Name: "orders", Path: "/orders/**", Upstream: "backend"}}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest("GET", "/orders/1", nil)
+	r.Header.Set("Traceparent", incoming)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, r)
+
+	upstream, ok := tracing.ParseTraceparent(rec.Body.String())
+	if !ok {
+		t.Fatalf("upstream got traceparent %q", rec.Body.String())
+	}
+	parent, _ := tracing.ParseTraceparent(incoming)
+	if upstream.TraceID != parent.TraceID || upstream.SpanID == parent.SpanID || !upstream.Sampled {
+		t.Errorf("upstream traceparent %q does not continue %q under a new span", rec.Body.String(), incoming)
+	}
+
+	// Stop flushes the queued spans.
+	p.Stop()
+	mu.Lock()
+	body := string(export)
+	mu.Unlock()
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+		t.Fatalf("export is not JSON: %v", err)
+	}
+	for _, want := range []string{
+		`"name":"GET /orders/**"`,
+		`"kind":2`,
+		`"kind":3`,
+		`"key":"relaypoint.route","value":{"stringValue":"orders"}`,
+		`"key":"relaypoint.target","value":{"stringValue":"` + backend.URL + `"}`,
+		`"key":"http.response.status_code","value":{"intValue":"200"}`,
+		`"parentSpanId":"00f067aa0ba902b7"`,
+		// The upstream continues under the client span.
+		`"spanId":"` + hex.EncodeToString(upstream.SpanID[:]) + `"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("export missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// newTransport builds an upstream transport speaking protocol: "" or "http1"
+// for HTTP/1.1, "h2" for HTTP/2 over TLS (falling back to HTTP/1.1), and
+// "h2c" for cleartext HTTP/2 with prior knowledge.
+func newTransport(protocol string) *http.Transport {
+	t := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		// Pass the client's Accept-Encoding through untouched instead of
+		// negotiating gzip on its behalf.
+		DisableCompression: true,
+		// Requests expecting 100 Continue wait this long for the
+		// upstream's before sending the body anyway.
+		ExpectContinueTimeout: time.Second,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+	}
+
+	switch protocol {
+	case "h2":
+		t.ForceAttemptHTTP2 = true
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetHTTP1(true)
+		t.Protocols.SetHTTP2(true)
+	case "h2c":
+		t.Protocols = new(http.Protocols)
+		t.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return t
+}
+
+// tuneTransport applies an upstream's transport block to t. Zero values keep
+// the defaults of newTransport.
+func tuneTransport(t *http.Transport, cfg *config.UpstreamTransport) {
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+}
+
+// certExpiryWarning is how long before expiry loading a client certificate
+// starts logging a warning.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// clientCertificate is an upstream client certificate that can be reloaded
+// from disk while connections keep using the previous one.
+type clientCertificate struct {
+	upstream string
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+// load reads the key pair from disk and swaps it in.
+func (c *clientCertificate) load(logger *slog.Logger) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load client certificate: %w", err)
+	}
+	if cert.Leaf != nil {
+		if remaining := time.Until(cert.Leaf.NotAfter); remaining < certExpiryWarning {
+			logger.Warn("upstream client certificate expires soon",
+				"upstream", c.upstream,
+				"cert_file", c.certFile,
+				"not_after", cert.Leaf.NotAfter,
+			)
+		}
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *clientCertificate) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// ReloadCertificates rereads every upstream client certificate from disk.
+// A certificate that fails to load keeps its previous value.
+func (p *Proxy) ReloadCertificates() error {
+	var errs []error
+	for _, c := range p.clientCerts {
+		if err := c.load(p.logger); err != nil {
+			errs = append(errs, fmt.Errorf("upstream %s: %w", c.upstream, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// upstreamTLSConfig builds the client TLS config for an upstream's tls block.
+// cert is non-nil when the block configures a client certificate.
+func upstreamTLSConfig(name string, cfg *config.UpstreamTLS, logger *slog.Logger) (*tls.Config, *clientCertificate, error) {
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("ca_file %s contains no PEM certificates", cfg.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+
+	var cert *clientCertificate
+	if cfg.CertFile != "" {
+		cert = &clientCertificate{upstream: name, certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if err := cert.load(logger); err != nil {
+			return nil, nil, err
+		}
+		tc.GetClientCertificate = cert.get
+	}
+	return tc, cert, nil
+}
+
+// SetTransport sends every upstream request, token fetches included,
+// through rt instead of the transports built from the configuration, e.g.
+// to run fixtures without a network. It must be called before the proxy
+// serves requests. WebSocket upgrades still dial the upstream.
+func (p *Proxy) SetTransport(rt http.RoundTripper) {
+	p.httpClient.Transport = rt
+	for _, c := range p.clients {
+		c.Transport = rt
+	}
+}
+
+// relayRedirects stops upstream clients from following redirects, so they
+// reach the client, with their Location under the base path, as the
+// upstream sent them.
+func relayRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// clientFor returns the HTTP client used to reach upstream.
+func (p *Proxy) clientFor(upstream string) *http.Client {
+	if c, ok := p.clients[upstream]; ok {
+		return c
+	}
+	return p.httpClient
+}
+
+// announceTrailers declares the upstream's trailer keys on the downstream
+// response so HTTP/1.1 clients know to expect them.
+func announceTrailers(h http.Header, trailer http.Header) {
+	h.Del("Trailer")
+	for k := range trailer {
+		h.Add("Trailer", k)
+	}
+}
+
+// copyTrailers sends the upstream trailers once the body has been copied.
+// Keys are written with http.TrailerPrefix so trailers that were not
+// announced up front (common with gRPC over HTTP/2) still reach the client.
+func copyTrailers(h http.Header, trailer http.Header) {
+	for k, vv := range trailer {
+		for _, v := range vv {
+			h.Add(http.TrailerPrefix+k, v)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+func newH2CServer(h http.Handler) *httptest.Server {
+	s := httptest.NewUnstartedServer(h)
+	s.Config.Protocols = new(http.Protocols)
+	s.Config.Protocols.SetHTTP1(true)
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	return s
+}
+
+// TestProxy_H2CRoundTrip exercises a gRPC-shaped exchange: a cleartext
+// HTTP/2 client calls the gateway, which forwards over h2c to a backend
+// that reports its status in trailers.
+func TestProxy_H2CRoundTrip(t *testing.T) {
+	backend := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("backend expected HTTP/2, got %s", r.Proto)
+		}
+		if r.Header.Get("Te") != "trailers" {
+			t.Errorf("backend expected TE: trailers, got %q", r.Header.Get("Te"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "ok")
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Upstreams[0].Protocol = "h2c"
+	})
+	gateway := newH2CServer(p)
+	defer gateway.Close()
+
+	client := &http.Client{Transport: newTransport("h2c")}
+	req, _ := http.NewRequest("POST", gateway.URL+"/helloworld.Greeter/SayHello", strings.NewReader("\x00\x00\x00\x00\x02hi"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2 from gateway, got %s", resp.Proto)
+	}
+	if string(body) != "\x00\x00\x00\x00\x02hi" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected Grpc-Status trailer 0, got %q (trailers %v)", got, resp.Trailer)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); got != "ok" {
+		t.Errorf("expected Grpc-Message trailer, got %q", got)
+	}
+}
+
+// TestProxy_Trailers relays trailers both ways over HTTP/1.1: the client's
+// to the upstream and the upstream's back to the client.
+func TestProxy_Trailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Trailer.Get("X-Checksum"); got != "abc123" {
+			t.Errorf("backend got X-Checksum trailer %q, want abc123", got)
+		}
+		w.Header().Set("Trailer", "X-Status")
+		_, _ = w.Write(body)
+		w.Header().Set("X-Status", "done")
+	}))
+	defer backend.Close()
+	p := newTestProxy(t, backend.URL, nil)
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	req, _ := http.NewRequest("POST", gateway.URL+"/upload", strings.NewReader("payload"))
+	req.ContentLength = -1
+	req.Trailer = http.Header{"X-Checksum": {"abc123"}}
+	req.Header.Set("Te", "trailers")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if _, ok := resp.Trailer["X-Status"]; !ok {
+		t.Errorf("X-Status trailer not announced (trailers %v)", resp.Trailer)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	if string(body) != "payload" {
+		t.Errorf("body = %q, want payload", body)
+	}
+	if got := resp.Trailer.Get("X-Status"); got != "done" {
+		t.Errorf("X-Status trailer = %q, want done (trailers %v)", got, resp.Trailer)
+	}
+}
+
+func TestProxy_DefaultTransportIsHTTP1(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 1 {
+			t.Errorf("expected HTTP/1.x, got %s", r.Proto)
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, nil)
+	if _, ok := p.clients["backend"]; ok {
+		t.Error("default protocol should use the shared client")
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+}
+
+// writeServerCA saves the certificate of an httptest TLS server as a PEM file.
+func writeServerCA(t *testing.T, s *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProxy_UpstreamTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure")
+	}))
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	tests := []struct {
+		name string
+		tls  *config.UpstreamTLS
+		want int
+	}{
+		{name: "system roots", tls: nil, want: http.StatusBadGateway},
+		{name: "ca_file", tls: &config.UpstreamTLS{CAFile: caFile}, want: http.StatusOK},
+		{name: "server_name in certificate", tls: &config.UpstreamTLS{CAFile: caFile, ServerName: "example.com"}, want: http.StatusOK},
+		{name: "server_name not in certificate", tls: &config.UpstreamTLS{CAFile: caFile, ServerName: "other.internal"}, want: http.StatusBadGateway},
+		{name: "insecure_skip_verify", tls: &config.UpstreamTLS{InsecureSkipVerify: true}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+				cfg.Upstreams[0].TLS = tt.tls
+			})
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_WebSocketUpstreamTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(echoWebSocket)
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	// Without the upstream's CA the upgrade cannot be dialed.
+	p := newTestProxy(t, backend.URL, nil)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("without ca_file: status = %d, want 502", rec.Code)
+	}
+
+	p = newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Upstreams[0].TLS = &config.UpstreamTLS{CAFile: caFile, ServerName: "example.com"}
+	})
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	conn, br := dialUpgrade(t, gateway.URL)
+	_, _ = io.WriteString(conn, "hello")
+	got := make([]byte, len("hello"))
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != "hello" {
+		t.Errorf("echo = %q, %v, want hello", got, err)
+	}
+}
+
+func TestNew_UnreadableCAFile(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RateLimit.CleanupInterval = 0
+	cfg.Upstreams = []config.Upstream{{
+		Name:    "billing",
+		Targets: []config.Target{{URL: "https://billing.internal"}},
+		TLS:     &config.UpstreamTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+	}}
+	cfg.Routes = []config.Route{{Name: "billing", Path: "/**", Upstream: "billing"}}
+
+	_, err := New(cfg)
+	if err == nil || !strings.Contains(err.Error(), "upstream billing") {
+		t.Fatalf("New() error = %v, want one naming the upstream", err)
+	}
+}
+
+// writeClientCert creates a self-signed client certificate with the given
+// common name, writes it and its key as PEM files and returns their paths
+// and the certificate.
+func writeClientCert(t *testing.T, dir, commonName string, notAfter time.Time) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+func TestProxy_UpstreamMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeClientCert(t, dir, "gateway-1", time.Now().Add(365*24*time.Hour))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(first)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	get := func(p *Proxy) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	t.Run("without a client certificate", func(t *testing.T) {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Upstreams[0].TLS = &config.UpstreamTLS{CAFile: caFile}
+		})
+		if rec := get(p); rec.Code != http.StatusBadGateway {
+			t.Errorf("status = %d, want 502", rec.Code)
+		}
+	})
+
+	t.Run("presents and reloads the client certificate", func(t *testing.T) {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Upstreams[0].TLS = &config.UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+		})
+		rec := get(p)
+		if rec.Code != http.StatusOK || rec.Body.String() != "gateway-1" {
+			t.Fatalf("got %d %q, want 200 from gateway-1", rec.Code, rec.Body.String())
+		}
+
+		// Rotate: the backend trusts the new certificate too, and the
+		// gateway must pick it up for new connections.
+		_, _, second := writeClientCert(t, dir, "gateway-2", time.Now().Add(365*24*time.Hour))
+		clientCAs.AddCert(second)
+		if err := p.ReloadCertificates(); err != nil {
+			t.Fatal(err)
+		}
+		p.clientFor("backend").CloseIdleConnections()
+
+		rec = get(p)
+		if rec.Code != http.StatusOK || rec.Body.String() != "gateway-2" {
+			t.Errorf("after reload got %d %q, want 200 from gateway-2", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("failed reload keeps the previous certificate", func(t *testing.T) {
+		p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+			cfg.Upstreams[0].TLS = &config.UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+		})
+		if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.ReloadCertificates(); err == nil || !strings.Contains(err.Error(), "upstream backend") {
+			t.Fatalf("ReloadCertificates() = %v, want an error naming the upstream", err)
+		}
+		if rec := get(p); rec.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200 with the previous certificate", rec.Code)
+		}
+	})
+}
+
+func TestProxy_WebSocketUpstreamMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeClientCert(t, dir, "gateway-1", time.Now().Add(365*24*time.Hour))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(first)
+	var want atomic.Value
+	want.Store("gateway-1")
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cn := r.TLS.PeerCertificates[0].Subject.CommonName; cn != want.Load() {
+			http.Error(w, cn, http.StatusForbidden)
+			return
+		}
+		echoWebSocket(w, r)
+	}))
+	backend.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	backend.StartTLS()
+	defer backend.Close()
+	caFile := writeServerCA(t, backend)
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Upstreams[0].TLS = &config.UpstreamTLS{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}
+	})
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	echo := func() {
+		t.Helper()
+		conn, br := dialUpgrade(t, gateway.URL)
+		_, _ = io.WriteString(conn, "hello")
+		got := make([]byte, len("hello"))
+		if _, err := io.ReadFull(br, got); err != nil || string(got) != "hello" {
+			t.Errorf("echo = %q, %v, want hello", got, err)
+		}
+	}
+	echo()
+
+	// Every upgrade dials anew, so a reloaded certificate is presented on
+	// the next one.
+	_, _, second := writeClientCert(t, dir, "gateway-2", time.Now().Add(365*24*time.Hour))
+	clientCAs.AddCert(second)
+	want.Store("gateway-2")
+	if err := p.ReloadCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	echo()
+}
+
+func TestProxy_UpstreamTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	p := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+		cfg.Upstreams[0].Transport = &config.UpstreamTransport{
+			MaxIdleConnsPerHost:   256,
+			MaxConnsPerHost:       512,
+			ResponseHeaderTimeout: 50 * time.Millisecond,
+		}
+		cfg.Upstreams = append(cfg.Upstreams, config.Upstream{Name: "other", Targets: []config.Target{{URL: backend.URL}}})
+	})
+
+	client, ok := p.clients["backend"]
+	if !ok {
+		t.Fatal("upstream with a transport block should get its own client")
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 256 || transport.MaxConnsPerHost != 512 {
+		t.Errorf("pool = %d idle/%d max per host, want 256/512", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.MaxIdleConns != 100 || transport.IdleConnTimeout != 90*time.Second {
+		t.Errorf("unset fields changed: max idle %d, idle timeout %v", transport.MaxIdleConns, transport.IdleConnTimeout)
+	}
+	if _, ok := p.clients["other"]; ok {
+		t.Error("upstream without a transport block should use the shared client")
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/fast", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("fast response: status = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/slow", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("response past response_header_timeout: status = %d, want 502", rec.Code)
+	}
+}
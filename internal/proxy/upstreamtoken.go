@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+const (
+	defaultTokenRefreshMargin = 60 * time.Second
+	tokenFetchTimeout         = 10 * time.Second
+	// tokenRetryInterval spaces out fetches while the token endpoint
+	// fails, so requests without a token do not hammer it.
+	tokenRetryInterval = time.Second
+)
+
+// errUpstreamAuthUnavailable is returned by proxyRequest when the upstream
+// needs a token and none could be obtained.
+var errUpstreamAuthUnavailable = errors.New("upstream token unavailable")
+
+// upstreamToken fetches, caches and refreshes the OAuth2 client
+// credentials token of one upstream. At most one fetch runs at a time;
+// requests arriving meanwhile wait for it or, while the cached token is
+// still valid, keep using that.
+type upstreamToken struct {
+	upstream string
+	cfg      *config.UpstreamTokenAuth
+	client   *http.Client
+	policy   *errorPolicy
+	metrics  *metrics.Metrics
+	now      func() time.Time
+
+	mu       sync.Mutex
+	token    string
+	expiry   time.Time     // zero when the endpoint sent no expires_in
+	fetching chan struct{} // closed when the fetch in progress ends, nil if none
+	lastErr  error
+	retryAt  time.Time // no new fetch before this after a failure
+}
+
+func newUpstreamToken(upstream string, cfg *config.UpstreamTokenAuth, client *http.Client, m *metrics.Metrics, policy *errorPolicy) *upstreamToken {
+	return &upstreamToken{upstream: upstream, cfg: cfg, client: client, policy: policy, metrics: m, now: time.Now}
+}
+
+// setupUpstreamTokens creates a token source for every upstream with auth.
+func (p *Proxy) setupUpstreamTokens() {
+	p.upstreamTokens = make(map[string]*upstreamToken)
+	for _, u := range p.config.Upstreams {
+		if u.Auth == nil {
+			continue
+		}
+		policy := newErrorPolicy("upstream_auth:"+u.Name, u.Auth.OnError, p.metrics, p.logger)
+		p.upstreamTokens[u.Name] = newUpstreamToken(u.Name, u.Auth, p.httpClient, p.metrics, policy)
+	}
+}
+
+// valid reports whether the cached token may still be sent.
+func (t *upstreamToken) valid(now time.Time) bool {
+	return t.token != "" && (t.expiry.IsZero() || now.Before(t.expiry))
+}
+
+// get returns the token to send. A token within its refresh margin is
+// returned while a fetch replaces it in the background; without a valid
+// token get waits for a fetch. It fails when the fetch did.
+func (t *upstreamToken) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	now := t.now()
+	if t.valid(now) {
+		margin := t.cfg.RefreshMargin
+		if margin == 0 {
+			margin = defaultTokenRefreshMargin
+		}
+		if !t.expiry.IsZero() && !now.Before(t.expiry.Add(-margin)) {
+			t.startFetch(now)
+		}
+		token := t.token
+		t.mu.Unlock()
+		return token, nil
+	}
+	done := t.startFetch(now)
+	t.mu.Unlock()
+
+	if done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.valid(t.now()) {
+		return "", t.lastErr
+	}
+	return t.token, nil
+}
+
+// startFetch starts a fetch unless one is running or the last failure was
+// too recent, and returns the channel closed when the running fetch ends,
+// or nil if none runs. t.mu must be held.
+func (t *upstreamToken) startFetch(now time.Time) chan struct{} {
+	if t.fetching == nil && !now.Before(t.retryAt) {
+		t.fetching = make(chan struct{})
+		go t.fetch(t.fetching)
+	}
+	return t.fetching
+}
+
+func (t *upstreamToken) fetch(done chan struct{}) {
+	token, expiresIn, err := t.request()
+	t.policy.observe(err)
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	t.metrics.RecordUpstreamTokenFetch(t.upstream, outcome)
+
+	t.mu.Lock()
+	now := t.now()
+	if err != nil {
+		t.lastErr = err
+		t.retryAt = now.Add(tokenRetryInterval)
+	} else {
+		t.token, t.expiry, t.lastErr = token, time.Time{}, nil
+		if expiresIn > 0 {
+			t.expiry = now.Add(expiresIn)
+		}
+	}
+	t.fetching = nil
+	t.mu.Unlock()
+	close(done)
+}
+
+// request asks the token endpoint for a token with the client credentials
+// grant, authenticating with HTTP Basic as RFC 6749 section 2.3.1 requires
+// every server to support.
+func (t *upstreamToken) request() (string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenFetchTimeout)
+	defer cancel()
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.cfg.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(t.cfg.ClientID), url.QueryEscape(t.cfg.ClientSecret))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	switch {
+	case resp.StatusCode != http.StatusOK && body.Error != "":
+		return "", 0, fmt.Errorf("token endpoint answered %d: %s", resp.StatusCode, body.Error)
+	case resp.StatusCode != http.StatusOK:
+		return "", 0, fmt.Errorf("token endpoint answered %d", resp.StatusCode)
+	case decodeErr != nil:
+		return "", 0, fmt.Errorf("token response: %w", decodeErr)
+	case body.AccessToken == "":
+		return "", 0, errors.New("token response has no access_token")
+	case body.TokenType != "" && !strings.EqualFold(body.TokenType, "bearer"):
+		return "", 0, fmt.Errorf("token endpoint issued a %s token, want bearer", body.TokenType)
+	}
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
+}
+
+// invalidate drops token if it is still the cached one, so the next
+// request fetches a new one.
+func (t *upstreamToken) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token, t.expiry = "", time.Time{}
+	}
+}
+
+// newAuthorizedRequest is newUpstreamRequest for a target of upstream,
+// with the upstream's token set by authorizeUpstream. Every request sent
+// upstream is built with it, so none goes out without the token.
+func (p *Proxy) newAuthorizedRequest(r *http.Request, route *router.Route, target *loadbalancer.Target, upstream string) (*http.Request, error) {
+	req, err := p.newUpstreamRequest(r, route, target)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.authorizeUpstream(req, upstream); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// authorizeUpstream sets the upstream's token as Authorization on req,
+// following the upstream's auth.on_error while tokens cannot be fetched.
+func (p *Proxy) authorizeUpstream(req *http.Request, upstream string) error {
+	t := p.upstreamTokens[upstream]
+	if t == nil {
+		return nil
+	}
+	token, err := t.get(req.Context())
+	if err != nil && req.Context().Err() != nil {
+		return err
+	}
+	switch t.policy.failingAction() {
+	case config.OnErrorDeny:
+		return fmt.Errorf("%w: %w", errUpstreamAuthUnavailable, t.lastFailure())
+	case config.OnErrorAllow:
+	default:
+		if err != nil {
+			return fmt.Errorf("%w: %w", errUpstreamAuthUnavailable, err)
+		}
+	}
+	deleteHeader(req.Header, "Authorization")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+func (t *upstreamToken) lastFailure() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastErr
+}
+
+// reauthorized returns a copy of sent, which upstream answered 401, with a
+// freshly fetched token and ctx as its context. It returns nil when there
+// is no new token to try: the upstream has no auth, sent did not carry its
+// token, or no new one can be had.
+func (p *Proxy) reauthorized(ctx context.Context, sent *http.Request, upstream string) *http.Request {
+	t := p.upstreamTokens[upstream]
+	if t == nil {
+		return nil
+	}
+	token, ok := strings.CutPrefix(sent.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil
+	}
+	t.invalidate(token)
+	retry := sent.Clone(ctx)
+	if err := p.authorizeUpstream(retry, upstream); err != nil || retry.Header.Get("Authorization") == "" {
+		return nil
+	}
+	return retry
+}
+
+// retryUnauthorized resends a request upstream answered 401 with a freshly
+// fetched token, once. It only applies to upstreams with auth and requests
+// without a body, which can be sent again; otherwise, and when no new
+// token can be had, the 401 is returned as is. The retry goes where the
+// answered request went, which for a hedged request may be a hedge, and
+// carries the deadline budget left at the time it is sent.
+func (p *Proxy) retryUnauthorized(upstreamReq *http.Request, route *router.Route, upstream string, resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusUnauthorized || (upstreamReq.Body != nil && upstreamReq.Body != http.NoBody) {
+		return resp, nil
+	}
+	sent := upstreamReq
+	if resp.Request != nil {
+		sent = resp.Request
+	}
+	retry := p.reauthorized(upstreamReq.Context(), sent, upstream)
+	if retry == nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+	if err := stampDeadline(retry, route); err != nil {
+		return nil, err
+	}
+	return p.clientFor(upstream).Do(retry)
+}
@@ -0,0 +1,400 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// tokenEndpoint is a mock OAuth2 token endpoint issuing tok-1, tok-2, ...
+// that expire after expiresIn seconds.
+type tokenEndpoint struct {
+	*httptest.Server
+	fetches   atomic.Int64
+	expiresIn int
+	fail      atomic.Bool
+}
+
+func newTokenEndpoint(t *testing.T, expiresIn int) *tokenEndpoint {
+	t.Helper()
+	e := &tokenEndpoint{expiresIn: expiresIn}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if e.fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprint(w, `{"error":"server_error"}`)
+			return
+		}
+		id, secret, _ := r.BasicAuth()
+		if id != "gateway" || secret != "s3cret%2F" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if r.Method != http.MethodPost || r.PostFormValue("grant_type") != "client_credentials" || r.PostFormValue("scope") != "orders:read orders:write" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		time.Sleep(20 * time.Millisecond) // let concurrent requests pile up
+		n := e.fetches.Add(1)
+		_, _ = fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"Bearer","expires_in":%d}`, n, e.expiresIn)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func newTokenProxy(t *testing.T, backendURL string, endpoint *tokenEndpoint, onError string) *Proxy {
+	t.Helper()
+	return newTestProxy(t, backendURL, func(cfg *config.Config) {
+		cfg.Upstreams[0].Auth = endpoint.auth(onError)
+	})
+}
+
+// auth is the upstream auth block fetching tokens from e.
+func (e *tokenEndpoint) auth(onError string) *config.UpstreamTokenAuth {
+	return &config.UpstreamTokenAuth{
+		Type:         config.AuthOAuth2ClientCredentials,
+		TokenURL:     e.URL,
+		ClientID:     "gateway",
+		ClientSecret: "s3cret/",
+		Scopes:       []string{"orders:read", "orders:write"},
+		OnError:      onError,
+	}
+}
+
+// revokedFirstToken wraps next so that tok-1 is answered 401, as if it were
+// revoked upstream before it expired, and reports every Authorization it
+// sees on seen.
+func revokedFirstToken(seen chan<- string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("Authorization")
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// expectAuthorizations checks that seen reports want, in order.
+func expectAuthorizations(t *testing.T, seen <-chan string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case got := <-seen:
+			if got != w {
+				t.Errorf("upstream saw Authorization %q, want %q", got, w)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("upstream never saw Authorization %q", w)
+		}
+	}
+}
+
+// authEchoBackend answers with the Authorization it received.
+func authEchoBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Authorization", r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// waitForTokenFetch waits for the fetch in progress, if any, to end.
+func waitForTokenFetch(t *testing.T, token *upstreamToken) {
+	t.Helper()
+	token.mu.Lock()
+	done := token.fetching
+	token.mu.Unlock()
+	if done == nil {
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("token fetch did not end")
+	}
+}
+
+func TestProxy_UpstreamTokenInjection(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 300)
+	backend := authEchoBackend(t)
+	p := newTokenProxy(t, backend.URL, endpoint, "")
+
+	var mu sync.Mutex
+	clock := time.Now()
+	p.upstreamTokens["backend"].now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return clock
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		clock = clock.Add(d)
+		mu.Unlock()
+	}
+	send := func() string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.Header.Set("Authorization", "Bearer client-token")
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		return rec.Header().Get("X-Seen-Authorization")
+	}
+
+	// Concurrent requests share a single fetch.
+	var wg sync.WaitGroup
+	seen := make([]string, 10)
+	for i := range seen {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen[i] = send()
+		}()
+	}
+	wg.Wait()
+	for _, got := range seen {
+		if got != "Bearer tok-1" {
+			t.Fatalf("upstream saw Authorization %q, want Bearer tok-1", got)
+		}
+	}
+	if n := endpoint.fetches.Load(); n != 1 {
+		t.Fatalf("fetches = %d, want 1", n)
+	}
+
+	// Within the refresh margin the old token is sent while a new one is
+	// fetched.
+	advance(250 * time.Second)
+	if got := send(); got != "Bearer tok-1" {
+		t.Errorf("within refresh margin: Authorization = %q, want Bearer tok-1", got)
+	}
+	waitForTokenFetch(t, p.upstreamTokens["backend"])
+	if got := send(); got != "Bearer tok-2" {
+		t.Errorf("after refresh: Authorization = %q, want Bearer tok-2", got)
+	}
+
+	// An expired token is never sent.
+	advance(time.Hour)
+	if got := send(); got != "Bearer tok-3" {
+		t.Errorf("after expiry: Authorization = %q, want Bearer tok-3", got)
+	}
+
+	rec := httptest.NewRecorder()
+	p.Metrics().Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `gateway_upstream_token_fetches_total{key="backend_ok"} 3`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics missing %s", want)
+	}
+}
+
+func TestProxy_UpstreamTokenRetryOnUnauthorized(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	var hits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		// tok-1 is revoked upstream before it expires.
+		if r.Header.Get("Authorization") != "Bearer tok-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+	p := newTokenProxy(t, backend.URL, endpoint, "")
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Fatalf("status = %d, body = %q, want 200 after a retry", rec.Code, rec.Body)
+	}
+	if hits.Load() != 2 || endpoint.fetches.Load() != 2 {
+		t.Errorf("upstream hits = %d, fetches = %d, want 2 and 2", hits.Load(), endpoint.fetches.Load())
+	}
+
+	// A 401 with the fresh token is relayed after a single retry.
+	rec = httptest.NewRecorder()
+	p.upstreamTokens["backend"].invalidate("tok-2")
+	hits.Store(0)
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	if rec.Code != http.StatusUnauthorized || hits.Load() != 2 {
+		t.Errorf("status = %d after %d upstream hits, want 401 after 2", rec.Code, hits.Load())
+	}
+
+	// Requests with a body are not resent.
+	hits.Store(0)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("POST", "/orders", strings.NewReader("{}")))
+	if rec.Code != http.StatusUnauthorized || hits.Load() != 1 {
+		t.Errorf("POST: status = %d after %d upstream hits, want 401 after 1", rec.Code, hits.Load())
+	}
+}
+
+func TestProxy_UpstreamTokenFetchFailure(t *testing.T) {
+	tests := []struct {
+		onError string
+		status  int
+	}{
+		{config.OnErrorDegrade, http.StatusServiceUnavailable},
+		{config.OnErrorDeny, http.StatusServiceUnavailable},
+		{config.OnErrorAllow, http.StatusOK}, // without the client's token
+	}
+	for _, tt := range tests {
+		t.Run(tt.onError, func(t *testing.T) {
+			endpoint := newTokenEndpoint(t, 300)
+			endpoint.fail.Store(true)
+			backend := authEchoBackend(t)
+			p := newTokenProxy(t, backend.URL, endpoint, tt.onError)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/orders", nil)
+			req.Header.Set("Authorization", "Bearer client-token")
+			p.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusOK && rec.Header().Get("X-Seen-Authorization") != "" {
+				t.Errorf("upstream saw Authorization %q", rec.Header().Get("X-Seen-Authorization"))
+			}
+
+			metrics := httptest.NewRecorder()
+			p.Metrics().Handler().ServeHTTP(metrics, httptest.NewRequest("GET", "/metrics", nil))
+			for _, want := range []string{
+				`gateway_upstream_token_fetches_total{key="backend_error"} 1`,
+				`gateway_subsystem_degraded{subsystem="upstream_auth:backend"} 1`,
+			} {
+				if !strings.Contains(metrics.Body.String(), want) {
+					t.Errorf("metrics missing %s", want)
+				}
+			}
+		})
+	}
+}
+
+func TestProxy_UpstreamTokenDegradeKeepsValidToken(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 30) // always within the 60s refresh margin
+	backend := authEchoBackend(t)
+
+	for _, tt := range []struct {
+		onError string
+		status  int
+	}{
+		{config.OnErrorDegrade, http.StatusOK},
+		{config.OnErrorDeny, http.StatusServiceUnavailable},
+	} {
+		p := newTokenProxy(t, backend.URL, endpoint, tt.onError)
+		endpoint.fail.Store(false)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", tt.onError, rec.Code)
+		}
+
+		// The refresh this request starts fails.
+		endpoint.fail.Store(true)
+		token := p.upstreamTokens["backend"]
+		p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/orders", nil))
+		waitForTokenFetch(t, token)
+		if token.lastFailure() == nil {
+			t.Fatalf("%s: refresh did not fail", tt.onError)
+		}
+
+		rec = httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status while refresh fails = %d, want %d", tt.onError, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestProxy_UpstreamTokenHedge(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	// The first request to reach either target stalls, so the hedge wins.
+	var stalled atomic.Bool
+	var urls []string
+	seen := map[string]chan string{}
+	for _, name := range []string{"a", "b"} {
+		seen[name] = make(chan string, 4)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if stalled.CompareAndSwap(false, true) {
+				<-r.Context().Done()
+				return
+			}
+			revokedFirstToken(seen[name], http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = fmt.Fprint(w, name)
+			})).ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		urls = append(urls, srv.URL)
+	}
+	p := newTestProxy(t, urls[0], func(cfg *config.Config) {
+		cfg.Upstreams[0].Targets = append(cfg.Upstreams[0].Targets, config.Target{URL: urls[1]})
+		cfg.Upstreams[0].Auth = endpoint.auth("")
+		cfg.Routes[0].Hedging = &config.Hedging{Delay: 20 * time.Millisecond}
+	})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+	hedge := rec.Body.String()
+	if rec.Code != http.StatusOK || seen[hedge] == nil {
+		t.Fatalf("status = %d, body = %q, want 200 from the hedge", rec.Code, hedge)
+	}
+	// The hedge carries the token, and its 401 is retried on the hedge's
+	// target.
+	expectAuthorizations(t, seen[hedge], "Bearer tok-1", "Bearer tok-2")
+}
+
+func TestProxy_UpstreamTokenMirror(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	primary := namedBackend(t, "primary")
+	seen := make(chan string, 4)
+	shadow := httptest.NewServer(revokedFirstToken(seen, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	defer shadow.Close()
+	p := newTestProxy(t, primary.URL, func(cfg *config.Config) {
+		cfg.Upstreams = append(cfg.Upstreams, config.Upstream{
+			Name: "shadow", Targets: []config.Target{{URL: shadow.URL}}, Auth: endpoint.auth(""),
+		})
+		cfg.Routes[0].Mirror = &config.Mirror{Upstream: "shadow"}
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set("Authorization", "Bearer client-token")
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	// The shadow upstream gets its own token, never the client's, and a
+	// 401 is retried with a fresh one.
+	expectAuthorizations(t, seen, "Bearer tok-1", "Bearer tok-2")
+}
+
+func TestProxy_UpstreamTokenWebSocket(t *testing.T) {
+	endpoint := newTokenEndpoint(t, 3600)
+	seen := make(chan string, 4)
+	backend := httptest.NewServer(revokedFirstToken(seen, echoWebSocket))
+	defer backend.Close()
+	p := newTokenProxy(t, backend.URL, endpoint, "")
+	gateway := httptest.NewServer(p)
+	defer gateway.Close()
+
+	// The handshake is sent with the token, and resent after a 401.
+	conn, br := dialUpgrade(t, gateway.URL)
+	expectAuthorizations(t, seen, "Bearer tok-1", "Bearer tok-2")
+	_, _ = io.WriteString(conn, "ping")
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != "ping" {
+		t.Errorf("echo = %q, %v", got, err)
+	}
+}
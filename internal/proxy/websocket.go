@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/loadbalancer"
+	"github.com/relaypoint/relaypoint/internal/router"
+)
+
+// isWebSocketRequest reports whether r asks for a WebSocket upgrade.
+func isWebSocketRequest(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerHasToken reports whether any comma-separated value of header key
+// contains token, compared case-insensitively.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// proxyWebSocket dials target directly, replays the upgrade handshake and,
+// once the upstream answers 101, tunnels bytes between the hijacked client
+// connection and the upstream until either side closes.
+func (p *Proxy) proxyWebSocket(w http.ResponseWriter, r *http.Request, route *router.Route, target *loadbalancer.Target) (int, error) {
+	upstreamReq, err := p.newAuthorizedRequest(r, route, target, route.Upstream)
+	if errors.Is(err, errUpstreamAuthUnavailable) {
+		p.writeError(w, http.StatusServiceUnavailable, "upstream_auth_unavailable", "upstream authentication unavailable")
+		return http.StatusServiceUnavailable, err
+	}
+	if err != nil {
+		p.writeError(w, http.StatusBadGateway, "proxy_error", "bad gateway")
+		return http.StatusBadGateway, err
+	}
+	upstreamReq.Header.Set("Connection", "Upgrade")
+	upstreamReq.Header.Set("Upgrade", r.Header.Get("Upgrade"))
+
+	upstreamConn, upstreamReader, resp, err := p.sendUpgrade(r, route, target, upstreamReq)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// Like any other request, the handshake is resent once with a
+		// fresh token, on a new connection.
+		if retry := p.reauthorized(r.Context(), upstreamReq, route.Upstream); retry != nil {
+			_ = upstreamConn.Close()
+			upstreamConn, upstreamReader, resp, err = p.sendUpgrade(r, route, target, retry)
+		}
+	}
+	if err != nil {
+		p.writeError(w, http.StatusBadGateway, "proxy_error", "bad gateway")
+		return http.StatusBadGateway, err
+	}
+	defer upstreamConn.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The upstream declined the upgrade; relay its answer as a normal response.
+		defer resp.Body.Close()
+		copyEndToEndHeaders(w.Header(), resp.Header)
+		applyHeaderRules(w.Header(), route.ResponseHeaders)
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		p.writeError(w, http.StatusBadGateway, "proxy_error", "bad gateway")
+		return http.StatusBadGateway, err
+	}
+	defer clientConn.Close()
+
+	// The server may have left read/write deadlines on the connection.
+	_ = clientConn.SetDeadline(time.Time{})
+
+	if err := resp.Write(clientConn); err != nil {
+		return http.StatusSwitchingProtocols, err
+	}
+
+	t := &tunnel{idleTimeout: route.WebSocketIdleTimeout}
+	t.touch()
+	t.run(
+		clientConn, io.MultiReader(bufferedReader(clientBuf.Reader), clientConn),
+		upstreamConn, io.MultiReader(bufferedReader(upstreamReader), upstreamConn),
+	)
+
+	return http.StatusSwitchingProtocols, nil
+}
+
+// sendUpgrade dials target and writes the handshake upstreamReq to it,
+// returning the connection and the upstream's answer.
+func (p *Proxy) sendUpgrade(r *http.Request, route *router.Route, target *loadbalancer.Target, upstreamReq *http.Request) (net.Conn, *bufio.Reader, *http.Response, error) {
+	conn, err := dialTarget(r, target, p.upstreamTLS[route.Upstream])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := upstreamReq.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, upstreamReq)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, nil, err
+	}
+	return conn, br, resp, nil
+}
+
+// dialTarget opens a raw connection to target, using TLS for https and wss
+// with the upstream's tls block, if it has one. Its client certificate is
+// read at each handshake, so upgrades present the one last reloaded.
+func dialTarget(r *http.Request, target *loadbalancer.Target, tlsConfig *tls.Config) (net.Conn, error) {
+	host := target.URL.Host
+	tlsTarget := target.URL.Scheme == "https" || target.URL.Scheme == "wss"
+	if target.URL.Port() == "" {
+		if tlsTarget {
+			host = net.JoinHostPort(target.URL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(target.URL.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if tlsTarget {
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			// The transport may have added h2 to the shared config; the
+			// upgrade is only spoken over HTTP/1.1.
+			config = tlsConfig.Clone()
+			config.NextProtos = nil
+		}
+		if config.ServerName == "" {
+			config.ServerName = target.URL.Hostname()
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+		return tlsDialer.DialContext(r.Context(), "tcp", host)
+	}
+	return dialer.DialContext(r.Context(), "tcp", host)
+}
+
+// bufferedReader returns a reader over whatever br has already buffered so
+// those bytes are not lost when switching to the raw connection.
+func bufferedReader(br *bufio.Reader) io.Reader {
+	n := br.Buffered()
+	if n == 0 {
+		return bytes.NewReader(nil)
+	}
+	data, _ := br.Peek(n)
+	return bytes.NewReader(bytes.Clone(data))
+}
+
+// tunnel copies bytes in both directions and tears the connections down when
+// either side closes or the tunnel has been idle for idleTimeout.
+type tunnel struct {
+	idleTimeout  time.Duration
+	lastActivity atomic.Int64
+}
+
+func (t *tunnel) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (t *tunnel) run(clientConn net.Conn, clientSrc io.Reader, upstreamConn net.Conn, upstreamSrc io.Reader) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		t.copy(upstreamConn, clientSrc, clientConn)
+		closeWrite(upstreamConn)
+	}()
+	go func() {
+		defer wg.Done()
+		t.copy(clientConn, upstreamSrc, upstreamConn)
+		closeWrite(clientConn)
+	}()
+
+	wg.Wait()
+}
+
+// copy moves bytes from src (backed by conn) to dst until EOF, an error, or
+// the idle timeout elapses with no traffic in either direction.
+func (t *tunnel) copy(dst io.Writer, src io.Reader, conn net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		if t.idleTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && !t.idle() {
+				// The other direction is still active; keep waiting.
+				continue
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				// Unblock the opposite reader as well.
+				_ = conn.SetDeadline(time.Now())
+				if c, ok := dst.(net.Conn); ok {
+					_ = c.SetDeadline(time.Now())
+				}
+			}
+			return
+		}
+	}
+}
+
+func (t *tunnel) idle() bool {
+	last := time.Unix(0, t.lastActivity.Load())
+	return time.Since(last) >= t.idleTimeout
+}
+
+func closeWrite(conn net.Conn) {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+	if cw, ok := conn.(closeWriter); ok {
+		_ = cw.CloseWrite()
+		return
+	}
+	_ = conn.Close()
+}
@@ -0,0 +1,58 @@
+// Package quota counts requests per key over fixed periods, such as a day,
+// for usage quotas that outlast the bursts rate limiting smooths out.
+package quota
+
+import (
+	"sync"
+	"time"
+)
+
+// Store counts requests per key in fixed windows of a period, aligned to
+// the Unix epoch so every replica agrees on them. MemoryStore is the only
+// implementation so far and never fails; a store shared between replicas,
+// such as Redis, can, and quota.on_error decides what requests do then.
+type Store interface {
+	// Increment counts a request for key in the current window of period
+	// and returns the count so far, this request included, and when the
+	// window ends.
+	Increment(key string, period time.Duration) (count int64, reset time.Time, err error)
+}
+
+// MemoryStore is a Store in process memory. It keeps one counter per key,
+// started over when a request falls in a later window. Counters are never
+// dropped, which suits a bounded set of keys such as API key names.
+type MemoryStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*counter
+}
+
+type counter struct {
+	window time.Time
+	count  int64
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, counters: make(map[string]*counter)}
+}
+
+func (s *MemoryStore) Increment(key string, period time.Duration) (int64, time.Time, error) {
+	window := s.now().Truncate(period)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.counters[key]
+	if c == nil {
+		c = &counter{}
+		s.counters[key] = c
+	}
+	if !c.window.Equal(window) {
+		c.window = window
+		c.count = 0
+	}
+	c.count++
+	return c.count, window.Add(period), nil
+}
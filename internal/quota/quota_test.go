@@ -0,0 +1,31 @@
+package quota
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	for want := int64(1); want <= 3; want++ {
+		count, reset, err := s.Increment("a", 24*time.Hour)
+		if err != nil || count != want {
+			t.Fatalf("Increment(a) = %d, %v, want %d", count, err, want)
+		}
+		if wantReset := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC); !reset.Equal(wantReset) {
+			t.Errorf("reset = %v, want %v", reset, wantReset)
+		}
+	}
+	if count, _, _ := s.Increment("b", 24*time.Hour); count != 1 {
+		t.Errorf("Increment(b) = %d, want a count of its own", count)
+	}
+
+	// The next window starts over, however little time has passed.
+	now = now.Add(time.Hour)
+	if count, _, _ := s.Increment("a", 24*time.Hour); count != 1 {
+		t.Errorf("Increment(a) in the next window = %d, want 1", count)
+	}
+}
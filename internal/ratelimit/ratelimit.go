@@ -11,6 +11,7 @@ type TokenBucket struct {
 	maxTokens  float64
 	refillRate float64 // tokens per second
 	lastRefill time.Time
+	consumed   float64 // tokens taken locally since the last DrainUsage
 	mu         sync.Mutex
 }
 
@@ -33,11 +34,25 @@ func (tb *TokenBucket) Allow() bool {
 
 	if tb.tokens >= 1 {
 		tb.tokens--
+		tb.consumed++
 		return true
 	}
 	return false
 }
 
+// consume removes n tokens without checking availability. The balance may go
+// negative, down to -maxTokens, so usage reported by peers is paid back.
+func (tb *TokenBucket) consume(n float64) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill()
+	tb.tokens -= n
+	if tb.tokens < -tb.maxTokens {
+		tb.tokens = -tb.maxTokens
+	}
+}
+
 // refill adds tokens based on elapsed time
 func (tb *TokenBucket) refill() {
 	now := time.Now()
@@ -49,6 +64,15 @@ func (tb *TokenBucket) refill() {
 	tb.lastRefill = now
 }
 
+// Store takes tokens from named buckets. RateLimiter is the only
+// implementation so far and never fails; a store shared between replicas,
+// such as Redis, can, and callers decide what a request does then.
+type Store interface {
+	// Take takes a token from the bucket for key, created with rps and
+	// burst if there is none yet, and reports whether there was one.
+	Take(key string, rps, burst int) (bool, error)
+}
+
 // RateLimiter manages rate limiting for multiple keys
 type RateLimiter struct {
 	buckets       map[string]*TokenBucket
@@ -107,6 +131,11 @@ func (rl *RateLimiter) AllowWithLimits(key string, rps, burst int) bool {
 	return bucket.Allow()
 }
 
+// Take implements Store with AllowWithLimits.
+func (rl *RateLimiter) Take(key string, rps, burst int) (bool, error) {
+	return rl.AllowWithLimits(key, rps, burst), nil
+}
+
 // SetLimits updates or creates a bucket with specific limits
 func (rl *RateLimiter) SetLimits(key string, rps, burst int) {
 	rl.mu.Lock()
@@ -144,6 +173,37 @@ func (rl *RateLimiter) Stop() {
 	}
 }
 
+// DrainUsage returns the tokens consumed locally per key since the previous
+// call and resets the counters. Keys without usage are omitted.
+func (rl *RateLimiter) DrainUsage() map[string]float64 {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	usage := make(map[string]float64)
+	for key, bucket := range rl.buckets {
+		bucket.mu.Lock()
+		if bucket.consumed > 0 {
+			usage[key] = bucket.consumed
+			bucket.consumed = 0
+		}
+		bucket.mu.Unlock()
+	}
+	return usage
+}
+
+// ApplyUsage deducts tokens consumed elsewhere (e.g. on a peer gateway) from
+// the bucket for key. Unknown keys are ignored since their limits are not
+// known until a local request creates the bucket.
+func (rl *RateLimiter) ApplyUsage(key string, tokens float64) {
+	rl.mu.RLock()
+	bucket, ok := rl.buckets[key]
+	rl.mu.RUnlock()
+
+	if ok && tokens > 0 {
+		bucket.consume(tokens)
+	}
+}
+
 // Stats returns current statistics
 func (rl *RateLimiter) Stats() map[string]float64 {
 	rl.mu.RLock()
@@ -132,3 +132,32 @@ func TestRateLimiter_Concurrent(t *testing.T) {
 		t.Errorf("Expected ~1000 allowed requests, got %d", count)
 	}
 }
+
+func TestRateLimiter_UsageExchange(t *testing.T) {
+	rl := NewRateLimiter(Config{
+		DefaultRPS:   1,
+		DefaultBurst: 10,
+	})
+	defer rl.Stop()
+
+	for i := 0; i < 3; i++ {
+		rl.Allow("shared")
+	}
+
+	usage := rl.DrainUsage()
+	if usage["shared"] != 3 {
+		t.Errorf("Expected 3 tokens of usage, got %v", usage["shared"])
+	}
+	if len(rl.DrainUsage()) != 0 {
+		t.Error("DrainUsage should reset counters")
+	}
+
+	// A peer consumed the remaining 7 tokens
+	rl.ApplyUsage("shared", 7)
+	if rl.Allow("shared") {
+		t.Error("Request should be denied after peer usage is applied")
+	}
+	if len(rl.DrainUsage()) != 0 {
+		t.Error("Peer usage must not be reported back as local usage")
+	}
+}
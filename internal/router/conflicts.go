@@ -0,0 +1,169 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
+
+// Conflict is a route that never matches on a host because a route tried
+// before it takes every request it would match.
+type Conflict struct {
+	Route string `json:"route"`
+	// By is the route that takes the requests.
+	By   string `json:"by"`
+	Host string `json:"host,omitempty"`
+	// Duplicate is set when both routes match the same host, path, methods
+	// and conditions with the same priority, so which of them serves a
+	// request is undefined.
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+func (c Conflict) String() string {
+	on := ""
+	if c.Host != "" {
+		on = " on host " + c.Host
+	}
+	if c.Duplicate {
+		return fmt.Sprintf("route %s duplicates route %s%s", c.Route, c.By, on)
+	}
+	return fmt.Sprintf("route %s is shadowed by route %s%s, which is tried first for every request it matches", c.Route, c.By, on)
+}
+
+// Conflicts lists the duplicate and shadowed routes, comparing each route
+// with the routes tried before it. Disabled routes are compared too, as
+// they can be enabled at runtime. A route with several hosts is reported
+// for each host it never matches on. The analysis is conservative: a
+// route is only reported when the patterns and conditions of the other
+// prove it, so a route that overlaps in ways it cannot decide, such as
+// two different parameter constraints, is left alone.
+func (r *Router) Conflicts() []Conflict {
+	var conflicts []Conflict
+	for i, entry := range r.routes {
+		for _, before := range r.routes[:i] {
+			if before.route == entry.route || !before.covers(entry) {
+				continue
+			}
+			c := Conflict{Route: routeName(entry.route), By: routeName(before.route), Host: entry.host}
+			if before.configPriority == entry.configPriority && entry.covers(before) &&
+				maps.Equal(before.route.Methods, entry.route.Methods) {
+				c.Duplicate = true
+				// Their order is arbitrary; name the later one in the
+				// configuration as the duplicate.
+				if entry.index < before.index {
+					c.Route, c.By = c.By, c.Route
+				}
+			}
+			conflicts = append(conflicts, c)
+			break
+		}
+	}
+	return conflicts
+}
+
+// covers reports whether every request other matches is matched, or
+// stopped, by e.
+func (e *routeEntry) covers(other *routeEntry) bool {
+	return coversHost(e.host, other.host) &&
+		coversPath(e.segments, other.segments, e.route.CaseSensitivePaths, other.route.CaseSensitivePaths) &&
+		coversTrailingSlash(e.route, other.route) &&
+		coversConditions(e.route.Query, other.route.Query, false) &&
+		coversConditions(e.route.MatchHeaders, other.route.MatchHeaders, true) &&
+		coversMethods(e.route, other.route)
+}
+
+func coversHost(host, other string) bool {
+	if host == "" || host == other {
+		return true
+	}
+	_, ok := matchWildcardHost(host, other)
+	return ok && other != ""
+}
+
+// coversPath reports whether segments match every path other does. Each
+// segment other than "**" takes exactly one path segment, so the patterns
+// are compared position by position until a "**" takes the rest.
+func coversPath(segments, other []segment, caseSensitive, otherCaseSensitive bool) bool {
+	for i, seg := range segments {
+		if seg.value == "**" && seg.isWild {
+			return true
+		}
+		if i >= len(other) || other[i].isWild && other[i].value == "**" {
+			return false
+		}
+		if !coversSegment(seg, other[i], caseSensitive, otherCaseSensitive) {
+			return false
+		}
+	}
+	return len(other) == len(segments)
+}
+
+func coversSegment(seg, other segment, caseSensitive, otherCaseSensitive bool) bool {
+	switch {
+	case seg.isWild || seg.isParam && seg.constraint == nil:
+		return true
+	case seg.isParam:
+		if other.isParam {
+			return other.pattern == seg.pattern
+		}
+		// A literal matched case-insensitively stands for every casing of
+		// it, which the constraint need not accept.
+		hasLetters := strings.ToLower(other.value) != strings.ToUpper(other.value)
+		return !other.isWild && (otherCaseSensitive || !hasLetters) && seg.constraint.MatchString(other.value)
+	case other.isWild || other.isParam:
+		return false
+	case caseSensitive:
+		return otherCaseSensitive && seg.value == other.value
+	}
+	return strings.EqualFold(seg.value, other.value)
+}
+
+// coversTrailingSlash reports whether route accepts every trailing slash
+// form other does: a strict route only takes its own form.
+func coversTrailingSlash(route, other *Route) bool {
+	if route.TrailingSlash != config.TrailingSlashStrict {
+		return true
+	}
+	return other.TrailingSlash == config.TrailingSlashStrict &&
+		hasTrailingSlash(route.Pattern) == hasTrailingSlash(other.Pattern)
+}
+
+// coversConditions reports whether every request meeting other's query or
+// header conditions meets want's. Only header values support prefixes.
+func coversConditions(want, other map[string]string, prefixes bool) bool {
+	for name, value := range want {
+		otherValue, ok := other[name]
+		switch {
+		case !ok:
+			return false
+		case value == "*":
+		case prefixes && strings.HasSuffix(value, "*"):
+			if otherValue == "*" || !strings.HasPrefix(strings.TrimSuffix(otherValue, "*"), strings.TrimSuffix(value, "*")) {
+				return false
+			}
+		case otherValue != value:
+			return false
+		}
+	}
+	return true
+}
+
+// coversMethods reports whether route matches, or stops routing for, every
+// method other matches. Method overrides change the method a route sees,
+// so with them only a route for all methods is known to cover.
+func coversMethods(route, other *Route) bool {
+	if route.Methods["*"] || route.Exclusive {
+		return true
+	}
+	if other.Methods["*"] || route.MethodOverrides != nil || other.MethodOverrides != nil {
+		return false
+	}
+	for method := range other.Methods {
+		if !route.Methods[method] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+package router
+
+import "context"
+
+type routeKey struct{}
+
+// WithRoute returns a copy of ctx that carries route.
+func WithRoute(ctx context.Context, route *Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route a request was matched to, with its
+// PathParams, or nil if ctx carries none.
+func RouteFromContext(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey{}).(*Route)
+	return route
+}
@@ -1,20 +1,26 @@
 package router
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
 	"sort"
 	"strings"
+	"sync/atomic"
 
 	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/pathprefix"
 )
 
 // New creates a new router from configuration
 func New(routes []config.Route) *Router {
 	r := &Router{
-		routes: make([]*routeEntry, 0, len(routes)),
+		routes:  make([]*routeEntry, 0, len(routes)),
+		enabled: make(map[string][]*atomic.Bool),
 	}
 
-	for _, cfg := range routes {
+	for i, cfg := range routes {
 		methods := make(map[string]bool)
 		if len(cfg.Methods) == 0 {
 			// Allow all methods by default
@@ -25,33 +31,149 @@ func New(routes []config.Route) *Router {
 			}
 		}
 
-		route := &Route{
-			Name:      cfg.Name,
-			Host:      strings.ToLower(cfg.Host),
-			Path:      cfg.Path,
-			Pattern:   cfg.Path,
-			Methods:   methods,
-			Upstream:  cfg.Upstream,
-			StripPath: cfg.StripPath,
-			Headers:   cfg.Headers,
-			RateLimit: cfg.RateLimit,
+		var overrides map[string]bool
+		if cfg.HonorMethodOverride {
+			allowed := cfg.MethodOverrideMethods
+			if len(allowed) == 0 {
+				allowed = DefaultMethodOverrides
+			}
+			overrides = make(map[string]bool, len(allowed))
+			for _, m := range allowed {
+				overrides[strings.ToUpper(m)] = true
+			}
+		}
+		var methodMap map[string]string
+		if len(cfg.MethodMap) > 0 {
+			methodMap = make(map[string]string, len(cfg.MethodMap))
+			for from, to := range cfg.MethodMap {
+				methodMap[strings.ToUpper(from)] = strings.ToUpper(to)
+			}
 		}
 
-		entry := &routeEntry{
-			route:    route,
-			segments: parseSegments(cfg.Path),
+		var denied map[string]bool
+		if len(cfg.DeniedMethods) > 0 {
+			denied = make(map[string]bool, len(cfg.DeniedMethods))
+			for _, m := range cfg.DeniedMethods {
+				denied[strings.ToUpper(m)] = true
+			}
 		}
 
-		// Calculate priority (more specific = higher priority)
-		entry.priority = calculatePriority(entry.segments)
-		entry.isWildcard = hasWildcard(entry.segments)
+		// Validate has already rejected invalid lists.
+		allowIPs, _ := config.ParseIPPrefixes(cfg.AllowIPs)
+		denyIPs, _ := config.ParseIPPrefixes(cfg.DenyIPs)
+
+		route := &Route{
+			Name:         cfg.Name,
+			Owner:        cfg.Owner,
+			Hosts:        routeHosts(cfg),
+			Path:         cfg.Path,
+			Pattern:      cfg.Path,
+			Methods:      methods,
+			Query:        cfg.Query,
+			MatchHeaders: canonicalHeaderKeys(cfg.MatchHeaders),
+			Upstream:     cfg.Upstream,
+			StripPath:    cfg.StripPath,
+			Headers:      cfg.Headers,
+			RateLimit:    cfg.RateLimit,
+
+			WebSocketIdleTimeout:    cfg.WebSocketIdleTimeout,
+			FlushInterval:           cfg.FlushInterval,
+			ResponseIdleTimeout:     cfg.ResponseIdleTimeout,
+			WireFidelity:            cfg.WireFidelity,
+			MaxBodySize:             cfg.MaxBodySize,
+			DecompressRequest:       cfg.DecompressRequest,
+			MaxConcurrent:           cfg.MaxConcurrent,
+			ConcurrencyQueue:        cfg.ConcurrencyQueue,
+			Hedging:                 cfg.Hedging,
+			BodyMatch:               cfg.BodyMatch,
+			Compression:             cfg.Compression,
+			Mirror:                  cfg.Mirror,
+			RequestHeaders:          cfg.RequestHeaders,
+			ResponseHeaders:         cfg.ResponseHeaders,
+			Rewrite:                 cfg.Rewrite,
+			CaseSensitivePaths:      cfg.CaseSensitivePaths != nil && *cfg.CaseSensitivePaths,
+			TrailingSlash:           cfg.TrailingSlash,
+			UpstreamPath:            cfg.UpstreamPath,
+			PreserveHost:            cfg.PreserveHost,
+			UpstreamHost:            cfg.UpstreamHost,
+			RequireAPIKey:           cfg.RequireAPIKey,
+			RequireJWT:              cfg.RequireJWT,
+			Exclusive:               cfg.Exclusive,
+			MethodOverrides:         overrides,
+			MethodMap:               methodMap,
+			AllowIPs:                allowIPs,
+			DenyIPs:                 denyIPs,
+			Cache:                   cfg.Cache,
+			IdempotencyKeys:         cfg.IdempotencyKeys,
+			FeatureFlags:            cfg.FeatureFlags,
+			AutoValidators:          cfg.AutoValidators,
+			ResponseContentTypes:    cfg.AllowedResponseContentTypes,
+			Degradation:             cfg.Degradation,
+			RequestFingerprint:      cfg.RequestFingerprint,
+			LearnBodyLimit:          cfg.LearnBodyLimit,
+			Forwarded:               cfg.Forwarded,
+			UpstreamAuth:            cfg.UpstreamAuth,
+			RequestSchema:           cfg.RequestSchema,
+			Pipeline:                cfg.Pipeline,
+			Capture:                 cfg.Capture,
+			Timeout:                 cfg.Timeout,
+			CompensateRTT:           cfg.CompensateRTT,
+			DeadlinePropagation:     cfg.DeadlinePropagation,
+			ServerTiming:            cfg.ServerTiming,
+			AllowInReadOnly:         cfg.AllowInReadOnly,
+			DeniedMethods:           denied,
+			OptionsPassthrough:      cfg.OptionsPassthrough,
+			SecurityHeaders:         cfg.SecurityHeaders,
+			SlowRequestThreshold:    cfg.SlowRequestThreshold,
+			ConfigFingerprintHeader: cfg.ConfigFingerprintHeader,
+		}
+
+		segments := parseSegments(cfg.Path)
+		compileConstraints(segments)
 
-		r.routes = append(r.routes, entry)
+		// Calculate priority (more specific = higher priority)
+		priority := calculatePriority(segments)
+		conditionPriority := calculateConditionPriority(cfg.Query, false) + calculateConditionPriority(cfg.MatchHeaders, true)
+		isWildcard := hasWildcard(segments)
+
+		enabled := &atomic.Bool{}
+		enabled.Store(cfg.Enabled == nil || *cfg.Enabled)
+		name := routeName(route)
+		r.enabled[name] = append(r.enabled[name], enabled)
+
+		hosts := route.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		for _, host := range hosts {
+			r.routes = append(r.routes, &routeEntry{
+				route:             route,
+				index:             i,
+				enabled:           enabled,
+				host:              host,
+				segments:          segments,
+				isWildcard:        isWildcard,
+				caseSet:           cfg.CaseSensitivePaths != nil,
+				configPriority:    cfg.Priority,
+				priority:          priority,
+				hostPriority:      calculateHostPriority(host),
+				conditionPriority: conditionPriority,
+			})
+		}
 	}
 
 	// Sort routes by priority (higher priority first)
 	sort.Slice(r.routes, func(i, j int) bool {
-		return r.routes[i].priority > r.routes[j].priority
+		if r.routes[i].configPriority != r.routes[j].configPriority {
+			return r.routes[i].configPriority > r.routes[j].configPriority
+		}
+		if r.routes[i].priority != r.routes[j].priority {
+			return r.routes[i].priority > r.routes[j].priority
+		}
+		if r.routes[i].hostPriority != r.routes[j].hostPriority {
+			return r.routes[i].hostPriority > r.routes[j].hostPriority
+		}
+		return r.routes[i].conditionPriority > r.routes[j].conditionPriority
 	})
 
 	return r
@@ -68,21 +190,32 @@ func parseSegments(path string) []segment {
 	segments := make([]segment, len(parts))
 
 	for i, part := range parts {
+		name, pattern, isParam := config.ParsePathParam(part)
 		switch {
 		case part == "*" || part == "**":
 			segments[i] = segment{value: part, isWild: true}
-		case strings.HasPrefix(part, ":"):
-			segments[i] = segment{value: part[1:], isParam: true}
-		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
-			segments[i] = segment{value: part[1 : len(part)-1], isParam: true}
+		case isParam:
+			segments[i] = segment{value: name, isParam: true, pattern: pattern}
 		default:
-			segments[i] = segment{value: strings.ToLower(part)}
+			segments[i] = segment{value: part}
 		}
 	}
 
 	return segments
 }
 
+// compileConstraints compiles the patterns of constrained parameters once,
+// at construction; matching an unconstrained parameter runs no regular
+// expression. An invalid pattern fails config validation; here it leaves
+// the parameter unconstrained.
+func compileConstraints(segments []segment) {
+	for i := range segments {
+		if segments[i].pattern != "" {
+			segments[i].constraint, _ = config.CompilePathPattern(segments[i].pattern)
+		}
+	}
+}
+
 // calculatePriority calculates route priority
 func calculatePriority(segments []segment) int {
 	priority := len(segments) * 10
@@ -90,6 +223,8 @@ func calculatePriority(segments []segment) int {
 	for _, seg := range segments {
 		if seg.isWild {
 			priority -= 5
+		} else if seg.isParam && seg.pattern != "" {
+			priority--
 		} else if seg.isParam {
 			priority -= 2
 		} else {
@@ -100,6 +235,65 @@ func calculatePriority(segments []segment) int {
 	return priority
 }
 
+// calculateHostPriority counts the labels of a host pattern other than a
+// wildcard; it is zero for a route that matches any host.
+func calculateHostPriority(host string) int {
+	if host == "" {
+		return 0
+	}
+	labels := strings.Count(host, ".") + 1
+	if strings.HasPrefix(host, "*.") {
+		labels--
+	}
+	return labels
+}
+
+// calculateConditionPriority ranks a route's query or header conditions:
+// three for each exact value, two for each prefix and one for each
+// presence check. Only headers have prefixes; a query value ending in "*"
+// is matched, and ranked, as an exact value.
+func calculateConditionPriority(conditions map[string]string, prefixes bool) int {
+	priority := 0
+	for _, value := range conditions {
+		switch {
+		case value == "*":
+			priority++
+		case prefixes && strings.HasSuffix(value, "*"):
+			priority += 2
+		default:
+			priority += 3
+		}
+	}
+	return priority
+}
+
+// routeHosts lists the hosts a route matches, lowercased.
+func routeHosts(cfg config.Route) []string {
+	hosts := cfg.Hosts
+	if cfg.Host != "" {
+		hosts = append([]string{cfg.Host}, hosts...)
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	lowered := make([]string, len(hosts))
+	for i, host := range hosts {
+		lowered[i] = strings.ToLower(host)
+	}
+	return lowered
+}
+
+func canonicalHeaderKeys(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	canonical := make(map[string]string, len(headers))
+	for name, value := range headers {
+		canonical[http.CanonicalHeaderKey(name)] = value
+	}
+	return canonical
+}
+
 func hasWildcard(segments []segment) bool {
 	for _, seg := range segments {
 		if seg.isWild {
@@ -111,6 +305,81 @@ func hasWildcard(segments []segment) bool {
 
 // Match finds a route matching the request
 func (r *Router) Match(req *http.Request) *Route {
+	return r.resolve(req, false).Route
+}
+
+// Resolve routes the request and also reports a route that matched its host
+// and path but not its method.
+func (r *Router) Resolve(req *http.Request) Result {
+	return r.resolve(req, false)
+}
+
+// Explain is Resolve with a trace of every route tried, for the admin
+// route tester.
+func (r *Router) Explain(req *http.Request) Result {
+	return r.resolve(req, true)
+}
+
+// Order lists the routes in the order requests try them, a route with
+// several hosts once for each, so operators can check how priorities
+// worked out.
+func (r *Router) Order() []Step {
+	steps := make([]Step, 0, len(r.routes))
+	for _, entry := range r.routes {
+		if entry.enabled.Load() {
+			steps = append(steps, entry.step(""))
+		}
+	}
+	return steps
+}
+
+// SetEnabled switches the route called name on or off, taking effect for
+// the next request routed. A disabled route is passed over, so requests
+// fall through to the routes after it. It reports whether the state
+// changed and whether there is such a route.
+func (r *Router) SetEnabled(name string, enabled bool) (changed, ok bool) {
+	flags, ok := r.enabled[name]
+	for _, flag := range flags {
+		if flag.Swap(enabled) != enabled {
+			changed = true
+		}
+	}
+	return changed, ok
+}
+
+// Disabled lists the names of the disabled routes, sorted.
+func (r *Router) Disabled() []string {
+	names := []string{}
+	for name, flags := range r.enabled {
+		if slices.ContainsFunc(flags, func(flag *atomic.Bool) bool { return !flag.Load() }) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routeName is the name a route is reported under in traces and known by
+// to SetEnabled: its name, or its path pattern if it has none.
+func routeName(route *Route) string {
+	if route.Name != "" {
+		return route.Name
+	}
+	return route.Pattern
+}
+
+func (e *routeEntry) step(outcome string) Step {
+	return Step{
+		Route:          routeName(e.route),
+		Host:           e.host,
+		Path:           e.route.Pattern,
+		Priority:       e.priority,
+		ConfigPriority: e.configPriority,
+		Outcome:        outcome,
+	}
+}
+
+func (r *Router) resolve(req *http.Request, trace bool) Result {
 	host := strings.ToLower(req.Host)
 	// Remove port if present
 	if idx := strings.Index(host, ":"); idx != -1 {
@@ -118,48 +387,292 @@ func (r *Router) Match(req *http.Request) *Route {
 	}
 
 	path := req.URL.Path
-	method := req.Method
+	if r.rawPath {
+		path = req.URL.EscapedPath()
+	}
+
+	var query url.Values // parsed when a route first needs it
+	var res Result
+	step := func(entry *routeEntry, outcome string) {
+		if trace {
+			res.Trace = append(res.Trace, entry.step(outcome))
+		}
+	}
 
 	for _, entry := range r.routes {
+		if !entry.enabled.Load() {
+			step(entry, OutcomeDisabled)
+			continue
+		}
+
 		// Check host match
-		if entry.route.Host != "" && entry.route.Host != host {
+		var hostLabel string
+		if entry.host != "" && entry.host != host {
 			// Support wildcard host matching (*.example.com)
-			if !matchWildcardHost(entry.route.Host, host) {
+			label, ok := matchWildcardHost(entry.host, host)
+			if !ok {
+				step(entry, OutcomeHostMismatch)
 				continue
 			}
+			hostLabel = label
 		}
 
-		// Check method match
-		if !entry.route.Methods["*"] && !entry.route.Methods[method] {
+		// Check path match
+		params, ok := matchPath(entry.segments, path, entry.route.CaseSensitivePaths)
+		if !ok {
+			step(entry, OutcomePathMismatch)
 			continue
 		}
+		if entry.route.TrailingSlash == config.TrailingSlashStrict {
+			if _, changed := entry.route.CanonicalPath(path); changed {
+				step(entry, OutcomeSlashMismatch)
+				continue
+			}
+		}
 
-		// Check path match
-		params, ok := matchPath(entry.segments, path)
-		if !ok {
+		if len(entry.route.Query) > 0 {
+			if query == nil {
+				query = req.URL.Query()
+			}
+			if !matchQuery(entry.route.Query, query) {
+				step(entry, OutcomeQueryMismatch)
+				continue
+			}
+		}
+		if !matchHeaders(entry.route.MatchHeaders, req.Header) {
+			step(entry, OutcomeHeaderMismatch)
+			continue
+		}
+
+		method := req.Method
+		if override, ok := methodOverride(entry.route, req); ok {
+			if !entry.route.MethodOverrides[override] {
+				step(entry, OutcomeOverrideDenied)
+				res.OverrideDenied = entry.route
+				res.MethodMismatch = nil
+				return res
+			}
+			method = override
+		}
+
+		// Check method match. The first route that only misses on the
+		// method is kept so the caller can answer 405 instead of 404.
+		if !entry.route.Methods["*"] && !entry.route.Methods[method] {
+			if entry.route.Exclusive {
+				step(entry, OutcomeExclusive)
+				res.MethodMismatch = entry.route
+				return res
+			}
+			step(entry, OutcomeMethodMismatch)
+			if res.MethodMismatch == nil {
+				res.MethodMismatch = entry.route
+			}
 			continue
 		}
 
 		// Clone route with path params
 		matched := *entry.route
+		if hostLabel != "" {
+			if params == nil {
+				params = make(map[string]string, 1)
+			}
+			params[hostParam(entry.host)] = hostLabel
+		}
 		matched.PathParams = params
-		return &matched
+		step(entry, OutcomeMatched)
+		res.Route = &matched
+		res.Method = method
+		res.MethodMismatch = nil
+		return res
 	}
 
-	return nil
+	return res
 }
 
-// matchWildcardHost matches patterns like *.example.com
-func matchWildcardHost(pattern, host string) bool {
+// matchQuery reports whether query has every parameter of want. A repeated
+// parameter is compared by its first value; "*" only requires the
+// parameter to be present, with any value or none.
+func matchQuery(want map[string]string, query url.Values) bool {
+	for name, value := range want {
+		if !query.Has(name) {
+			return false
+		}
+		if value != "*" && query.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHeaders reports whether header has every header of want, compared
+// by its first value: exactly, by prefix for a value ending in "*", or only
+// for presence for "*" alone.
+func matchHeaders(want map[string]string, header http.Header) bool {
+	for name, value := range want {
+		values := header[name] // want's keys are canonical
+		if len(values) == 0 {
+			return false
+		}
+		switch {
+		case value == "*":
+		case strings.HasSuffix(value, "*"):
+			if !strings.HasPrefix(values[0], strings.TrimSuffix(value, "*")) {
+				return false
+			}
+		default:
+			if values[0] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MethodOverrideHeader names the method a POST request stands for on routes
+// with honor_method_override.
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// DefaultMethodOverrides are the methods a client may override to when the
+// route does not list its own.
+var DefaultMethodOverrides = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// methodOverride returns the override a POST request asks for on route.
+func methodOverride(route *Route, req *http.Request) (string, bool) {
+	if route.MethodOverrides == nil || req.Method != http.MethodPost {
+		return "", false
+	}
+	override := strings.ToUpper(strings.TrimSpace(req.Header.Get(MethodOverrideHeader)))
+	return override, override != ""
+}
+
+// AllowedMethods returns the methods the route accepts, sorted, or nil if
+// it accepts all of them.
+func (r *Route) AllowedMethods() []string {
+	if r.Methods["*"] {
+		return nil
+	}
+	methods := make([]string, 0, len(r.Methods))
+	for m := range r.Methods {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// matchWildcardHost matches patterns like *.example.com, returning the part
+// of host the wildcard matched.
+func matchWildcardHost(pattern, host string) (string, bool) {
 	if !strings.HasPrefix(pattern, "*.") {
-		return false
+		return "", false
 	}
 	suffix := pattern[1:] // ".example.com"
-	return strings.HasSuffix(host, suffix)
+	if len(host) <= len(suffix) || !strings.HasSuffix(host, suffix) {
+		return "", false
+	}
+	return host[:len(host)-len(suffix)], true
+}
+
+// hostParam names the path parameter holding what the wildcard of a host
+// pattern matched after the label that follows it: host.tenant for
+// *.tenant.example.com.
+func hostParam(pattern string) string {
+	label, _, _ := strings.Cut(pattern[len("*."):], ".")
+	return "host." + label
+}
+
+// MatchCaseSensitivePaths makes routes that do not set case_sensitive_paths
+// themselves match literal path segments case-sensitively.
+func (r *Router) MatchCaseSensitivePaths() {
+	for _, entry := range r.routes {
+		if !entry.caseSet {
+			entry.route.CaseSensitivePaths = true
+		}
+	}
+}
+
+// MatchRawPath makes the router match the request path as the client
+// encoded it rather than decoded, so an encoded slash stays within its
+// segment.
+func (r *Router) MatchRawPath() {
+	r.rawPath = true
+}
+
+// ErrPathEscapesRoot is returned by NormalizePath for a path with more
+// ".." segments than it has segments to remove.
+var ErrPathEscapesRoot = errors.New("path escapes the root")
+
+// NormalizePath collapses duplicate slashes in u's path and resolves its
+// "." and ".." segments, percent-encoded or not, so that routes match and
+// upstreams receive a single spelling of each path. With raw the encoding
+// the client chose is kept; otherwise the path is left decoded, for the
+// upstream request to encode again. Paths that do not start with a slash,
+// such as the "*" of OPTIONS, are left alone.
+func NormalizePath(u *url.URL, raw bool) error {
+	if !strings.HasPrefix(u.Path, "/") {
+		return nil
+	}
+	if !raw {
+		path, err := cleanPath(u.Path, false)
+		if err != nil {
+			return err
+		}
+		u.Path, u.RawPath = path, ""
+		return nil
+	}
+
+	rawPath, err := cleanPath(u.EscapedPath(), true)
+	if err != nil {
+		return err
+	}
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return err
+	}
+	// An encoded slash hides a ".." from the segments above, but not from
+	// an upstream that decodes the path before resolving it.
+	if _, err := cleanPath(path, false); err != nil {
+		return err
+	}
+	u.Path, u.RawPath = path, rawPath
+	return nil
 }
 
-// matchPath matches a path against segments
-func matchPath(segments []segment, path string) (map[string]string, bool) {
+// cleanPath removes empty, "." and ".." segments from path, comparing the
+// segments decoded if unescape is set. A trailing slash is kept, and one is
+// added where a dot segment ended the path, as RFC 3986 section 5.2.4 does.
+func cleanPath(path string, unescape bool) (string, error) {
+	parts := strings.Split(path, "/")
+	kept := parts[:0]
+	trailing := false
+	for _, part := range parts {
+		name := part
+		if unescape {
+			if decoded, err := url.PathUnescape(part); err == nil {
+				name = decoded
+			}
+		}
+		trailing = name == "" || name == "." || name == ".."
+		switch name {
+		case "", ".":
+		case "..":
+			if len(kept) == 0 {
+				return "", ErrPathEscapesRoot
+			}
+			kept = kept[:len(kept)-1]
+		default:
+			kept = append(kept, part)
+		}
+	}
+	cleaned := "/" + strings.Join(kept, "/")
+	if trailing && len(kept) > 0 {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// matchPath matches a path against segments, comparing literal segments
+// case-insensitively unless caseSensitive is set.
+func matchPath(segments []segment, path string, caseSensitive bool) (map[string]string, bool) {
 	path = strings.Trim(path, "/")
 
 	if len(segments) == 0 {
@@ -201,6 +714,9 @@ func matchPath(segments []segment, path string) (map[string]string, bool) {
 			if pi >= len(pathParts) {
 				return nil, false
 			}
+			if seg.constraint != nil && !seg.constraint.MatchString(pathParts[pi]) {
+				return nil, false
+			}
 			params[seg.value] = pathParts[pi]
 			pi++
 			si++
@@ -211,7 +727,8 @@ func matchPath(segments []segment, path string) (map[string]string, bool) {
 		if pi >= len(pathParts) {
 			return nil, false
 		}
-		if strings.ToLower(pathParts[pi]) != seg.value {
+		if caseSensitive && pathParts[pi] != seg.value ||
+			!caseSensitive && !strings.EqualFold(pathParts[pi], seg.value) {
 			return nil, false
 		}
 		pi++
@@ -222,26 +739,47 @@ func matchPath(segments []segment, path string) (map[string]string, bool) {
 	return params, pi == len(pathParts)
 }
 
+// CanonicalPath returns path with a trailing slash if the route's pattern
+// has one and without one otherwise, and whether that changed it. The root
+// path is left as it is.
+func (r *Route) CanonicalPath(path string) (string, bool) {
+	want := hasTrailingSlash(r.Pattern)
+	switch {
+	case path == "/" || hasTrailingSlash(path) == want:
+		return path, false
+	case want:
+		return path + "/", true
+	}
+	return strings.TrimSuffix(path, "/"), true
+}
+
+func hasTrailingSlash(path string) bool {
+	return len(path) > 1 && strings.HasSuffix(path, "/")
+}
+
 // StripPrefix removes the matched prefix from the path
 func (r *Route) StripPrefix(path string) string {
+	stripped, _ := r.PathPrefix().Strip(path)
+	return stripped
+}
+
+// PathPrefix returns the static prefix strip_path removes from the route's
+// paths: the segments of its pattern before the first parameter or
+// wildcard. It is empty when the route does not strip its path.
+func (r *Route) PathPrefix() pathprefix.Prefix {
 	if !r.StripPath {
-		return path
+		return ""
 	}
-
-	// Find the static prefix to strip
-	segments := parseSegments(r.Pattern)
-	prefix := "/"
-	for _, seg := range segments {
+	var prefix strings.Builder
+	for _, seg := range parseSegments(r.Pattern) {
 		if seg.isWild || seg.isParam {
 			break
 		}
-		prefix += seg.value + "/"
-	}
-
-	prefix = strings.TrimSuffix(prefix, "/")
-	if prefix == "/" {
-		return path
+		if r.CaseSensitivePaths {
+			prefix.WriteString("/" + seg.value)
+		} else {
+			prefix.WriteString("/" + strings.ToLower(seg.value))
+		}
 	}
-
-	return strings.TrimPrefix(path, prefix)
+	return pathprefix.New(prefix.String())
 }
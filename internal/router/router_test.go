@@ -1,7 +1,10 @@
 package router
 
 import (
+	"errors"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/relaypoint/relaypoint/internal/config"
@@ -146,6 +149,24 @@ func TestRouter_StripPath(t *testing.T) {
 	if result != "/users/123" {
 		t.Errorf("Expected /users/123, got %s", result)
 	}
+
+	for _, tt := range []struct {
+		pattern    string
+		strip      bool
+		path, want string
+	}{
+		{"/api/v1/**", true, "/api/v1", "/"},
+		{"/api/v1/**", true, "/api/v1/", "/"},
+		{"/api/v1/**", false, "/api/v1/users", "/api/v1/users"},
+		{"/api/:version/users", true, "/api/v2/users", "/v2/users"},
+		{"/**", true, "/users", "/users"},
+		{"/:tenant/orders", true, "/acme/orders", "/acme/orders"},
+	} {
+		route := &Route{Pattern: tt.pattern, StripPath: tt.strip}
+		if got := route.StripPrefix(tt.path); got != tt.want {
+			t.Errorf("pattern %s: StripPrefix(%q) = %q, want %q", tt.pattern, tt.path, got, tt.want)
+		}
+	}
 }
 
 func TestRouter_NoMatch(t *testing.T) {
@@ -197,6 +218,344 @@ func TestRouter_Priority(t *testing.T) {
 	}
 }
 
+func TestRouter_MultipleHosts(t *testing.T) {
+	routes := []config.Route{
+		{Name: "tenants", Hosts: []string{"*.tenant.example.com", "*.tenant.example.org"}, Path: "/**", Upstream: "tenants"},
+		{Name: "wildcard", Host: "*.example.com", Path: "/**", Upstream: "wildcard"},
+		{Name: "api", Hosts: []string{"api.example.com", "API.example.org"}, Path: "/**", Upstream: "api"},
+		{Name: "admin", Host: "admin.tenant.example.com", Path: "/**", Upstream: "admin"},
+		{Name: "default", Path: "/**", Upstream: "default"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		host     string
+		expected string
+		tenant   string
+	}{
+		{"api.example.com", "api", ""},
+		{"api.example.org", "api", ""},
+		{"api.example.org:8443", "api", ""},
+		{"acme.tenant.example.com", "tenants", "acme"},
+		{"Acme.Tenant.Example.org", "tenants", "acme"},
+		{"eu.acme.tenant.example.com", "tenants", "eu.acme"},
+		// Exact hosts beat the wildcards that also match them, and a
+		// longer wildcard beats a shorter one, whatever the route order.
+		{"admin.tenant.example.com", "admin", ""},
+		{"tenant.example.com", "wildcard", ""},
+		{"www.example.com", "wildcard", ""},
+		{"example.com", "default", ""},
+		{"other.org", "default", ""},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Host = tc.host
+		route := r.Match(req)
+		if route == nil {
+			t.Errorf("Host %s should match", tc.host)
+			continue
+		}
+		if route.Upstream != tc.expected {
+			t.Errorf("Host %s: expected upstream %s, got %s", tc.host, tc.expected, route.Upstream)
+			continue
+		}
+		if got := route.PathParams["host.tenant"]; got != tc.tenant {
+			t.Errorf("Host %s: host.tenant = %q, want %q", tc.host, got, tc.tenant)
+		}
+	}
+}
+
+func TestRouter_ExplicitPriority(t *testing.T) {
+	routes := []config.Route{
+		{Name: "users", Path: "/api/users/:id", Upstream: "users"},
+		{Name: "legacy", Path: "/api/**", Priority: 10, Upstream: "legacy"},
+		{Name: "legacy-users", Path: "/api/users/*", Priority: 10, Upstream: "legacy-users"},
+		{Name: "health", Path: "/health", Priority: -1, Upstream: "health"},
+		{Name: "catchall", Path: "/**", Upstream: "catchall"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		// The explicit priority beats a more specific computed one.
+		{"/api/users/42", "legacy-users"},
+		// Between equal explicit priorities the computed one decides.
+		{"/api/orders", "legacy"},
+		// A negative priority falls behind routes that set none.
+		{"/health", "catchall"},
+	}
+	for _, tc := range tests {
+		route := r.Match(httptest.NewRequest("GET", tc.path, nil))
+		if route == nil || route.Upstream != tc.expected {
+			t.Errorf("Path %s: got %v, want %s", tc.path, route, tc.expected)
+		}
+	}
+
+	var order []string
+	for _, step := range r.Order() {
+		order = append(order, step.Route)
+	}
+	want := []string{"legacy-users", "legacy", "users", "catchall", "health"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("Order() = %v, want %v", order, want)
+	}
+}
+
+func TestRouter_CaseSensitivePaths(t *testing.T) {
+	sensitive, insensitive := true, false
+	routes := []config.Route{
+		{Name: "upper", Path: "/Users/*", CaseSensitivePaths: &sensitive, Upstream: "upper"},
+		{Name: "lower", Path: "/users/*", Upstream: "lower"},
+		{Name: "legacy", Path: "/Legacy/**", CaseSensitivePaths: &insensitive, Upstream: "legacy"},
+	}
+
+	match := func(r *Router, path string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "API.example.com"
+		if route := r.Match(req); route != nil {
+			return route.Upstream
+		}
+		return ""
+	}
+
+	r := New(routes)
+	for path, want := range map[string]string{
+		"/Users/1":  "upper",
+		"/users/1":  "lower",
+		"/USERS/1":  "lower",
+		"/legacy/x": "legacy",
+	} {
+		if got := match(r, path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+
+	r = New(routes)
+	r.MatchCaseSensitivePaths()
+	for path, want := range map[string]string{
+		"/Users/1":  "upper",
+		"/users/1":  "lower",
+		"/USERS/1":  "",
+		"/LEGACY/x": "legacy",
+	} {
+		if got := match(r, path); got != want {
+			t.Errorf("case-sensitive %s: got %q, want %q", path, got, want)
+		}
+	}
+
+	route := &Route{Pattern: "/API/v1/**", StripPath: true, CaseSensitivePaths: true}
+	if got := route.StripPrefix("/API/v1/users"); got != "/users" {
+		t.Errorf("StripPrefix = %q, want /users", got)
+	}
+}
+
+func TestRouter_TrailingSlash(t *testing.T) {
+	routes := []config.Route{
+		{Name: "merge", Path: "/merge", Upstream: "merge"},
+		{Name: "strict", Path: "/strict", TrailingSlash: config.TrailingSlashStrict, Upstream: "strict"},
+		{Name: "strict-dir", Path: "/strict-dir/", TrailingSlash: config.TrailingSlashStrict, Upstream: "strict-dir"},
+		{Name: "redirect", Path: "/redirect", TrailingSlash: config.TrailingSlashRedirect, Upstream: "redirect"},
+		{Name: "root", Path: "/", TrailingSlash: config.TrailingSlashStrict, Upstream: "root"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/merge", "merge"},
+		{"/merge/", "merge"},
+		{"/strict", "strict"},
+		{"/strict/", ""},
+		{"/strict-dir/", "strict-dir"},
+		{"/strict-dir", ""},
+		// A redirect route matches both forms; the proxy redirects.
+		{"/redirect", "redirect"},
+		{"/redirect/", "redirect"},
+		{"/", "root"},
+	}
+	for _, tc := range tests {
+		var got string
+		if route := r.Match(httptest.NewRequest("GET", tc.path, nil)); route != nil {
+			got = route.Upstream
+		}
+		if got != tc.expected {
+			t.Errorf("%s: got %q, want %q", tc.path, got, tc.expected)
+		}
+	}
+
+	for _, step := range r.Explain(httptest.NewRequest("GET", "/strict/", nil)).Trace {
+		if step.Route == "strict" && step.Outcome != OutcomeSlashMismatch {
+			t.Errorf("strict outcome = %s, want %s", step.Outcome, OutcomeSlashMismatch)
+		}
+	}
+
+	for _, tt := range []struct {
+		pattern, path, want string
+		changed             bool
+	}{
+		{"/users", "/users", "/users", false},
+		{"/users", "/users/", "/users", true},
+		{"/users/", "/users", "/users/", true},
+		{"/users/**", "/users/42/", "/users/42", true},
+		{"/", "/", "/", false},
+		{"/**", "/", "/", false},
+	} {
+		route := &Route{Pattern: tt.pattern}
+		if got, changed := route.CanonicalPath(tt.path); got != tt.want || changed != tt.changed {
+			t.Errorf("pattern %s: CanonicalPath(%q) = %q, %v, want %q, %v", tt.pattern, tt.path, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestRouter_EnabledRoutes(t *testing.T) {
+	disabled := false
+	routes := []config.Route{
+		{Name: "users", Path: "/api/users", Enabled: &disabled, Upstream: "users"},
+		{Name: "api", Path: "/api/**", Upstream: "api"},
+	}
+	r := New(routes)
+
+	match := func() string {
+		if route := r.Match(httptest.NewRequest("GET", "/api/users", nil)); route != nil {
+			return route.Upstream
+		}
+		return ""
+	}
+
+	// A disabled route is skipped, so the request falls through.
+	if got := match(); got != "api" {
+		t.Errorf("disabled: got %q, want api", got)
+	}
+	for _, step := range r.Explain(httptest.NewRequest("GET", "/api/users", nil)).Trace {
+		if step.Route == "users" && step.Outcome != OutcomeDisabled {
+			t.Errorf("users outcome = %s, want %s", step.Outcome, OutcomeDisabled)
+		}
+	}
+	if got := r.Disabled(); len(got) != 1 || got[0] != "users" {
+		t.Errorf("Disabled() = %v, want [users]", got)
+	}
+	for _, step := range r.Order() {
+		if step.Route == "users" {
+			t.Errorf("Order() includes the disabled route")
+		}
+	}
+
+	if changed, ok := r.SetEnabled("users", true); !changed || !ok {
+		t.Errorf("SetEnabled(users, true) = %v, %v, want true, true", changed, ok)
+	}
+	if changed, ok := r.SetEnabled("users", true); changed || !ok {
+		t.Errorf("repeated SetEnabled = %v, %v, want false, true", changed, ok)
+	}
+	if _, ok := r.SetEnabled("missing", false); ok {
+		t.Errorf("SetEnabled(missing) reported a route")
+	}
+	if got := match(); got != "users" {
+		t.Errorf("enabled: got %q, want users", got)
+	}
+	if got := r.Disabled(); len(got) != 0 {
+		t.Errorf("Disabled() = %v, want none", got)
+	}
+}
+
+func TestRouter_Conflicts(t *testing.T) {
+	sensitive, strict := true, config.TrailingSlashStrict
+	tests := []struct {
+		name   string
+		routes []config.Route
+		want   string // "" for no conflict
+	}{
+		{"catch-all first", []config.Route{
+			{Name: "api", Path: "/api/**", Priority: 1},
+			{Name: "users", Path: "/api/users/:id"},
+		}, "route users is shadowed by route api"},
+		{"more specific first", []config.Route{
+			{Name: "api", Path: "/api/**"},
+			{Name: "users", Path: "/api/users/:id"},
+		}, ""},
+		{"parameter names differ", []config.Route{
+			{Name: "a", Path: "/users/:id", Methods: []string{"GET"}},
+			{Name: "b", Path: "/Users/{name}", Methods: []string{"get"}},
+		}, "route b duplicates route a"},
+		{"methods differ", []config.Route{
+			{Name: "a", Path: "/users/:id", Methods: []string{"GET"}},
+			{Name: "b", Path: "/users/:id", Methods: []string{"POST"}},
+		}, ""},
+		{"methods contained", []config.Route{
+			{Name: "a", Path: "/users/:id", Methods: []string{"GET", "POST"}, Priority: 1},
+			{Name: "b", Path: "/users/:id", Methods: []string{"GET"}},
+		}, "route b is shadowed by route a"},
+		{"exclusive route stops routing", []config.Route{
+			{Name: "a", Path: "/users/*", Methods: []string{"GET"}, Exclusive: true, Priority: 1},
+			{Name: "b", Path: "/users/:id", Methods: []string{"POST"}},
+		}, "route b is shadowed by route a"},
+		{"method overrides", []config.Route{
+			{Name: "a", Path: "/users/*", Methods: []string{"GET", "POST"}, Priority: 1},
+			{Name: "b", Path: "/users/:id", Methods: []string{"GET"}, HonorMethodOverride: true},
+		}, ""},
+		{"wildcard segment", []config.Route{
+			{Name: "a", Path: "/users/*/posts", Priority: 1},
+			{Name: "b", Path: "/users/:id/posts"},
+		}, "route b is shadowed by route a"},
+		{"double wildcard is not covered by a segment", []config.Route{
+			{Name: "a", Path: "/users/*", Priority: 1},
+			{Name: "b", Path: "/users/**"},
+		}, ""},
+		{"constraint admits literal", []config.Route{
+			{Name: "a", Path: "/v/:n(\\d+)", Priority: 1},
+			{Name: "b", Path: "/v/42"},
+		}, "route b is shadowed by route a"},
+		{"constraint rejects literal", []config.Route{
+			{Name: "a", Path: "/v/:n(\\d+)", Priority: 1},
+			{Name: "b", Path: "/v/latest"},
+		}, ""},
+		{"constraints differ", []config.Route{
+			{Name: "a", Path: "/v/:n(\\d+)", Priority: 1},
+			{Name: "b", Path: "/v/:n([0-9]+)"},
+		}, ""},
+		{"case-sensitive literal", []config.Route{
+			{Name: "a", Path: "/Users/**", CaseSensitivePaths: &sensitive, Priority: 1},
+			{Name: "b", Path: "/users/:id"},
+		}, ""},
+		{"host", []config.Route{
+			{Name: "a", Path: "/**", Hosts: []string{"*.example.com"}, Priority: 1},
+			{Name: "b", Path: "/users", Hosts: []string{"api.example.com", "other.test"}},
+		}, "route b is shadowed by route a on host api.example.com"},
+		{"route for any host", []config.Route{
+			{Name: "a", Path: "/**", Host: "api.example.com", Priority: 1},
+			{Name: "b", Path: "/users"},
+		}, ""},
+		{"conditions", []config.Route{
+			{Name: "a", Path: "/search", MatchHeaders: map[string]string{"X-Version": "2*"}, Priority: 1},
+			{Name: "b", Path: "/search", MatchHeaders: map[string]string{"x-version": "2.1"}, Query: map[string]string{"q": "*"}},
+		}, "route b is shadowed by route a"},
+		{"narrower conditions first", []config.Route{
+			{Name: "a", Path: "/search", Query: map[string]string{"v": "2"}},
+			{Name: "b", Path: "/search"},
+		}, ""},
+		{"strict trailing slash", []config.Route{
+			{Name: "a", Path: "/docs/**", TrailingSlash: strict, Priority: 1},
+			{Name: "b", Path: "/docs/guide"},
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, c := range New(tt.routes).Conflicts() {
+			got = append(got, c.String())
+		}
+		switch {
+		case tt.want == "" && len(got) > 0:
+			t.Errorf("%s: unexpected conflicts %q", tt.name, got)
+		case tt.want != "" && (len(got) != 1 || !strings.HasPrefix(got[0], tt.want)):
+			t.Errorf("%s: conflicts = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
 func BenchmarkRouter_Match(b *testing.B) {
 	routes := []config.Route{
 		{Host: "api.example.com", Path: "/v1/users/*", Upstream: "users"},
@@ -215,3 +574,359 @@ func BenchmarkRouter_Match(b *testing.B) {
 		r.Match(req)
 	}
 }
+
+func BenchmarkRouter_MatchParam(b *testing.B) {
+	r := New([]config.Route{{Path: "/orders/:id/items", Upstream: "orders"}})
+	req := httptest.NewRequest("GET", "/orders/123/items", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Match(req)
+	}
+}
+
+func BenchmarkRouter_MatchConstrainedParam(b *testing.B) {
+	r := New([]config.Route{{Path: `/orders/:id(\d+)/items`, Upstream: "orders"}})
+	req := httptest.NewRequest("GET", "/orders/123/items", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Match(req)
+	}
+}
+
+func TestRouter_ParamConstraints(t *testing.T) {
+	routes := []config.Route{
+		{Name: "catchall", Path: "/orders/**", Upstream: "catchall"},
+		{Name: "by-slug", Path: "/orders/:slug", Upstream: "slugs"},
+		{Name: "by-id", Path: `/orders/:id(\d+)`, Upstream: "ids"},
+		{Name: "by-sku", Path: "/orders/{sku:[A-Z]{3}-[0-9]+}/items", Upstream: "skus"},
+		{Name: "export", Path: "/orders/export", Upstream: "export"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		path     string
+		expected string
+		param    string
+		value    string
+	}{
+		{"/orders/123", "ids", "id", "123"},
+		{"/orders/export", "export", "", ""},
+		// Values the pattern does not match fall through to other routes.
+		{"/orders/12a", "slugs", "slug", "12a"},
+		{"/orders/x123", "slugs", "slug", "x123"},
+		{"/orders/ABC-42/items", "skus", "sku", "ABC-42"},
+		{"/orders/abc-42/items", "catchall", "", ""},
+		{"/orders/ABC-42x/items", "catchall", "", ""},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		route := r.Match(req)
+		if route == nil {
+			t.Errorf("%s: no route matched", tc.path)
+			continue
+		}
+		if route.Upstream != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.path, tc.expected, route.Upstream)
+			continue
+		}
+		if tc.param != "" && route.PathParams[tc.param] != tc.value {
+			t.Errorf("%s: %s = %q, want %q", tc.path, tc.param, route.PathParams[tc.param], tc.value)
+		}
+	}
+}
+
+func TestRouter_QueryMatching(t *testing.T) {
+	routes := []config.Route{
+		{Name: "search", Path: "/search", Upstream: "search-v1"},
+		{Name: "search-v2", Path: "/search", Query: map[string]string{"engine": "v2"}, Upstream: "search-v2"},
+		{Name: "search-v2-debug", Path: "/search", Query: map[string]string{"engine": "v2", "debug": "*"}, Upstream: "search-debug"},
+		{Name: "search-beta", Path: "/search", Query: map[string]string{"beta": "*"}, Upstream: "search-beta"},
+		{Name: "items", Path: "/items/*", Query: map[string]string{"format": "csv"}, Upstream: "export"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/search", "search-v1"},
+		{"/search?engine=v1", "search-v1"},
+		{"/search?engine=v2", "search-v2"},
+		{"/search?q=shoes&engine=v2", "search-v2"},
+		{"/search?engine=v2&debug", "search-debug"},
+		{"/search?engine=v2&debug=", "search-debug"},
+		{"/search?beta", "search-beta"},
+		// An exact value outranks a presence check.
+		{"/search?engine=v2&beta=1", "search-v2"},
+		// Repeated parameters are compared by their first value.
+		{"/search?engine=v2&engine=v1", "search-v2"},
+		{"/search?engine=v1&engine=v2", "search-v1"},
+		// Parameters are parsed, not matched as substrings.
+		{"/search?xengine=v2", "search-v1"},
+		{"/search?engine=v2x", "search-v1"},
+		{"/search?engine=v%32", "search-v2"},
+		{"/items/1?format=csv", "export"},
+		{"/items/1?format=json", ""},
+	}
+	for _, tc := range tests {
+		route := r.Match(httptest.NewRequest("GET", tc.target, nil))
+		got := ""
+		if route != nil {
+			got = route.Upstream
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected upstream %q, got %q", tc.target, tc.expected, got)
+		}
+	}
+
+	res := r.Explain(httptest.NewRequest("GET", "/items/1", nil))
+	if res.Route != nil || len(res.Trace) == 0 || res.Trace[0].Outcome != OutcomeQueryMismatch {
+		t.Errorf("trace = %+v, want the items route to miss on its query", res.Trace)
+	}
+}
+
+func TestRouter_QueryTrailingStar(t *testing.T) {
+	// Query values have no prefixes: "2*" is an exact value, ranked as
+	// one above the two presence checks of "any".
+	r := New([]config.Route{
+		{Name: "any", Path: "/search", Query: map[string]string{"v": "*", "debug": "*"}, Upstream: "any"},
+		{Name: "star", Path: "/search", Query: map[string]string{"v": "2*"}, Upstream: "star"},
+		{Name: "presence", Path: "/search", Query: map[string]string{"v": "*"}, Upstream: "presence"},
+	})
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/search?v=2x", "presence"},
+		{"/search?v=2", "presence"},
+		{"/search?v=2*", "star"},
+		{"/search?v=2*&debug", "star"},
+		{"/search?v=2x&debug", "any"},
+	}
+	for _, tc := range tests {
+		got := ""
+		if route := r.Match(httptest.NewRequest("GET", tc.target, nil)); route != nil {
+			got = route.Upstream
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected upstream %q, got %q", tc.target, tc.expected, got)
+		}
+	}
+
+	if got := calculateConditionPriority(map[string]string{"v": "2*"}, false); got != 3 {
+		t.Errorf("query priority of \"2*\" = %d, want 3 as an exact value", got)
+	}
+	if got := calculateConditionPriority(map[string]string{"X-V": "2*"}, true); got != 2 {
+		t.Errorf("header priority of \"2*\" = %d, want 2 as a prefix", got)
+	}
+}
+
+func TestRouter_HeaderMatching(t *testing.T) {
+	routes := []config.Route{
+		// Listed first, so only the header constraint can put v2 ahead.
+		{Name: "orders", Path: "/orders/*", Upstream: "orders-v1"},
+		{Name: "orders-v2", Path: "/orders/*", MatchHeaders: map[string]string{"x-api-version": "2"}, Upstream: "orders-v2"},
+		{Name: "orders-beta", Path: "/orders/*", MatchHeaders: map[string]string{"X-Beta": "*"}, Upstream: "orders-beta"},
+		{Name: "orders-partner", Path: "/orders/*", MatchHeaders: map[string]string{"Authorization": "Partner *"}, Upstream: "orders-partner"},
+	}
+	r := New(routes)
+
+	tests := []struct {
+		name     string
+		headers  map[string][]string
+		expected string
+	}{
+		{"no header", nil, "orders-v1"},
+		{"version 2", map[string][]string{"X-Api-Version": {"2"}}, "orders-v2"},
+		{"version 1", map[string][]string{"X-Api-Version": {"1"}}, "orders-v1"},
+		{"version 2 lower-case name", map[string][]string{"x-api-version": {"2"}}, "orders-v2"},
+		{"repeated header", map[string][]string{"X-Api-Version": {"1", "2"}}, "orders-v1"},
+		{"presence", map[string][]string{"X-Beta": {""}}, "orders-beta"},
+		{"exact beats presence", map[string][]string{"X-Api-Version": {"2"}, "X-Beta": {"1"}}, "orders-v2"},
+		{"prefix", map[string][]string{"Authorization": {"Partner abc"}}, "orders-partner"},
+		{"prefix mismatch", map[string][]string{"Authorization": {"Bearer abc"}}, "orders-v1"},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest("GET", "/orders/1", nil)
+		for name, values := range tc.headers {
+			req.Header[name] = values
+		}
+		// Names are canonicalized when the server parses the request.
+		for name, values := range req.Header {
+			delete(req.Header, name)
+			req.Header[http.CanonicalHeaderKey(name)] = values
+		}
+		route := r.Match(req)
+		if route == nil || route.Upstream != tc.expected {
+			t.Errorf("%s: expected upstream %s, got %+v", tc.name, tc.expected, route)
+		}
+	}
+
+	res := r.Explain(httptest.NewRequest("GET", "/orders/1", nil))
+	if res.Trace[0].Outcome != OutcomeHeaderMismatch || res.Route == nil || res.Route.Name != "orders" {
+		t.Errorf("trace = %+v, want header mismatches before the unconstrained route", res.Trace)
+	}
+}
+
+func TestRouter_Exclusive(t *testing.T) {
+	// A GET-only route on a wildcard host above a catch-all on another
+	// upstream: without exclusive a POST falls through to the catch-all.
+	routes := func(exclusive bool) []config.Route {
+		return []config.Route{
+			{Name: "tenant-read", Host: "*.example.com", Path: "/api/**", Methods: []string{"GET"}, Upstream: "read", Exclusive: exclusive},
+			{Name: "catchall", Path: "/**", Upstream: "default"},
+		}
+	}
+	post := httptest.NewRequest("POST", "/api/orders", nil)
+	post.Host = "acme.example.com"
+
+	res := New(routes(false)).Resolve(post)
+	if res.Route == nil || res.Route.Name != "catchall" {
+		t.Fatalf("non-exclusive: want fall-through to catchall, got %+v", res.Route)
+	}
+
+	res = New(routes(true)).Explain(post)
+	if res.Route != nil {
+		t.Fatalf("exclusive: POST matched %s", res.Route.Name)
+	}
+	if res.MethodMismatch == nil || res.MethodMismatch.Name != "tenant-read" {
+		t.Fatalf("exclusive: MethodMismatch = %+v, want tenant-read", res.MethodMismatch)
+	}
+	if got := res.MethodMismatch.AllowedMethods(); len(got) != 1 || got[0] != "GET" {
+		t.Errorf("AllowedMethods = %v", got)
+	}
+	if len(res.Trace) != 1 || res.Trace[0].Outcome != OutcomeExclusive {
+		t.Errorf("trace = %+v, want a single exclusive step", res.Trace)
+	}
+
+	// Other hosts and paths are unaffected.
+	other := httptest.NewRequest("POST", "/api/orders", nil)
+	other.Host = "example.org"
+	if route := New(routes(true)).Match(other); route == nil || route.Name != "catchall" {
+		t.Errorf("other host: got %+v, want catchall", route)
+	}
+	get := httptest.NewRequest("GET", "/api/orders", nil)
+	get.Host = "acme.example.com"
+	if route := New(routes(true)).Match(get); route == nil || route.Name != "tenant-read" {
+		t.Errorf("GET: got %+v, want tenant-read", route)
+	}
+}
+
+func TestRouter_MethodMismatch(t *testing.T) {
+	r := New([]config.Route{
+		{Name: "read", Path: "/api/read", Methods: []string{"GET", "HEAD"}, Upstream: "read"},
+		{Name: "other", Path: "/other", Upstream: "other"},
+	})
+
+	res := r.Explain(httptest.NewRequest("DELETE", "/api/read", nil))
+	if res.Route != nil || res.MethodMismatch == nil || res.MethodMismatch.Name != "read" {
+		t.Fatalf("got route %+v, mismatch %+v", res.Route, res.MethodMismatch)
+	}
+	want := []string{OutcomeMethodMismatch, OutcomePathMismatch}
+	if len(res.Trace) != len(want) {
+		t.Fatalf("trace = %+v", res.Trace)
+	}
+	for i, step := range res.Trace {
+		if step.Outcome != want[i] {
+			t.Errorf("step %d (%s) = %s, want %s", i, step.Route, step.Outcome, want[i])
+		}
+	}
+
+	if res := r.Resolve(httptest.NewRequest("DELETE", "/missing", nil)); res.Route != nil || res.MethodMismatch != nil {
+		t.Errorf("unknown path: got %+v", res)
+	}
+}
+
+func TestRouter_MethodOverride(t *testing.T) {
+	r := New([]config.Route{
+		{Name: "items", Path: "/items/*", Methods: []string{"PUT", "DELETE"}, Upstream: "items", HonorMethodOverride: true, MethodOverrideMethods: []string{"put", "delete"}},
+		{Name: "other", Path: "/**", Upstream: "other"},
+	})
+
+	override := func(method, value string) Result {
+		req := httptest.NewRequest(method, "/items/1", nil)
+		if value != "" {
+			req.Header.Set(MethodOverrideHeader, value)
+		}
+		return r.Explain(req)
+	}
+
+	if res := override("POST", "delete"); res.Route == nil || res.Route.Name != "items" || res.Method != "DELETE" {
+		t.Errorf("POST overridden to DELETE: got route %+v, method %q", res.Route, res.Method)
+	}
+	// The override is checked against the allow-list before the route's
+	// methods, and a denied override stops routing.
+	res := override("POST", "PATCH")
+	if res.Route != nil || res.OverrideDenied == nil || res.OverrideDenied.Name != "items" {
+		t.Errorf("PATCH override: got route %+v, denied %+v", res.Route, res.OverrideDenied)
+	}
+	if last := res.Trace[len(res.Trace)-1]; last.Outcome != OutcomeOverrideDenied {
+		t.Errorf("trace ends with %s, want %s", last.Outcome, OutcomeOverrideDenied)
+	}
+	// Only POST requests are overridden.
+	if res := override("GET", "DELETE"); res.Route == nil || res.Route.Name != "other" || res.Method != "GET" {
+		t.Errorf("GET with override header: got route %+v, method %q", res.Route, res.Method)
+	}
+	if res := override("POST", ""); res.Route == nil || res.Route.Name != "other" {
+		t.Errorf("POST without override: got route %+v", res.Route)
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		target  string
+		raw     bool
+		path    string
+		escaped string
+		err     error
+	}{
+		{"/api/v1//users/../admin", false, "/api/v1/admin", "/api/v1/admin", nil},
+		{"/api/./v1/", false, "/api/v1/", "/api/v1/", nil},
+		{"/api/v1/..", false, "/api/", "/api/", nil},
+		{"//api///v1", false, "/api/v1", "/api/v1", nil},
+		{"/api/%2e%2e/admin", false, "/admin", "/admin", nil},
+		{"/api/a%2Fb", false, "/api/a/b", "/api/a/b", nil},
+		{"/api/../../etc/passwd", false, "", "", ErrPathEscapesRoot},
+		{"/%2e%2e/etc", false, "", "", ErrPathEscapesRoot},
+
+		{"/api//files/a%2Fb", true, "/api/files/a/b", "/api/files/a%2Fb", nil},
+		{"/api/%2e%2e/admin", true, "/admin", "/admin", nil},
+		{"/api/%2E/v1", true, "/api/v1", "/api/v1", nil},
+		{"/%2e%2e/etc", true, "", "", ErrPathEscapesRoot},
+		{"/api/..%2F..%2Fetc", true, "", "", ErrPathEscapesRoot},
+	}
+
+	for _, tt := range tests {
+		u := httptest.NewRequest("GET", tt.target, nil).URL
+		err := NormalizePath(u, tt.raw)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s (raw %v): error = %v, want %v", tt.target, tt.raw, err, tt.err)
+			continue
+		}
+		if err == nil && (u.Path != tt.path || u.EscapedPath() != tt.escaped) {
+			t.Errorf("%s (raw %v): path = %q, escaped %q, want %q, %q", tt.target, tt.raw, u.Path, u.EscapedPath(), tt.path, tt.escaped)
+		}
+	}
+}
+
+func TestRouter_MatchRawPath(t *testing.T) {
+	routes := []config.Route{
+		{Path: "/files/:name", Upstream: "files"},
+		{Path: "/**", Upstream: "catchall"},
+	}
+	decoded, raw := New(routes), New(routes)
+	raw.MatchRawPath()
+
+	req := httptest.NewRequest("GET", "/files/a%2Fb", nil)
+	if route := decoded.Match(req); route == nil || route.Upstream != "catchall" {
+		t.Errorf("decoded: matched %v, want catchall", route)
+	}
+	route := raw.Match(req)
+	if route == nil || route.Upstream != "files" || route.PathParams["name"] != "a%2Fb" {
+		t.Errorf("raw: matched %v, want files with name a%%2Fb", route)
+	}
+}
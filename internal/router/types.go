@@ -1,33 +1,168 @@
 package router
 
-import "github.com/relaypoint/relaypoint/internal/config"
+import (
+	"net/netip"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+)
 
 type Route struct {
-	Name       string
-	Host       string
-	Path       string
-	Pattern    string
-	Methods    map[string]bool
-	Upstream   string
-	StripPath  bool
-	Headers    map[string]string
-	RateLimit  *config.RouteRateLimit
-	PathParams map[string]string
+	Name         string
+	Owner        string
+	Hosts        []string // lowercased; empty matches any host
+	Path         string
+	Pattern      string
+	Methods      map[string]bool
+	Query        map[string]string // parameter to value, or "*" for presence
+	MatchHeaders map[string]string // canonical name to value, "*" for presence, or prefix ending in "*"
+	Upstream     string
+	StripPath    bool
+	Headers      map[string]string
+	RateLimit    *config.RouteRateLimit
+	PathParams   map[string]string
+
+	WebSocketIdleTimeout    time.Duration
+	FlushInterval           time.Duration
+	ResponseIdleTimeout     time.Duration
+	WireFidelity            bool
+	MaxBodySize             int64
+	DecompressRequest       bool
+	MaxConcurrent           int
+	ConcurrencyQueue        *config.ConcurrencyQueue
+	Hedging                 *config.Hedging
+	BodyMatch               *config.BodyMatch
+	Compression             *config.Compression
+	Mirror                  *config.Mirror
+	RequestHeaders          *config.HeaderRules
+	ResponseHeaders         *config.HeaderRules
+	Rewrite                 *config.Rewrite
+	UpstreamPath            string
+	PreserveHost            bool
+	UpstreamHost            string
+	RequireAPIKey           *bool
+	RequireJWT              bool
+	CaseSensitivePaths      bool // after MatchCaseSensitivePaths
+	TrailingSlash           string
+	Exclusive               bool
+	MethodOverrides         map[string]bool // nil unless honor_method_override is set
+	MethodMap               map[string]string
+	AllowIPs                []netip.Prefix
+	DenyIPs                 []netip.Prefix
+	Cache                   *config.Cache
+	IdempotencyKeys         *config.IdempotencyKeys
+	FeatureFlags            []string
+	AutoValidators          *config.AutoValidators
+	ResponseContentTypes    *config.ResponseContentTypes
+	Degradation             *config.Degradation
+	RequestFingerprint      *config.RequestFingerprint
+	LearnBodyLimit          *config.BodyLimitLearning
+	Forwarded               *config.Forwarded
+	UpstreamAuth            *config.UpstreamAuth
+	RequestSchema           *config.RequestSchema
+	Pipeline                []string
+	Capture                 *config.RouteCapture
+	Timeout                 time.Duration
+	CompensateRTT           bool
+	DeadlinePropagation     *config.DeadlinePropagation
+	ServerTiming            bool
+	AllowInReadOnly         bool
+	DeniedMethods           map[string]bool // nil unless denied_methods is set
+	OptionsPassthrough      bool
+	SecurityHeaders         *config.SecurityHeaders
+	SlowRequestThreshold    time.Duration
+	ConfigFingerprintHeader bool
+}
+
+// Result is the outcome of routing a request.
+type Result struct {
+	// Route is the matched route, or nil.
+	Route *Route
+	// MethodMismatch is the first route whose host and path matched but
+	// whose methods did not, or the exclusive route that stopped routing.
+	// When Route is nil the request should be answered with 405 on its
+	// behalf rather than 404.
+	MethodMismatch *Route
+	// OverrideDenied is the route that stopped routing because the
+	// request's method override is not on its allow-list.
+	OverrideDenied *Route
+	// Method is the method Route matched with, after any override.
+	Method string
+	// Trace lists the routes tried in order. It is only filled by Explain.
+	Trace []Step
 }
 
+// Step is one route tried while routing a request.
+type Step struct {
+	Route    string `json:"route"`
+	Host     string `json:"host,omitempty"`
+	Path     string `json:"path"`
+	Priority int    `json:"priority"`
+	// ConfigPriority is the route's explicit priority, which orders
+	// routes before Priority does.
+	ConfigPriority int `json:"config_priority,omitempty"`
+	// Outcome is one of the Outcome constants; Order leaves it empty.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Outcomes of a Step.
+const (
+	// OutcomeDisabled is a route switched off with enabled: false or
+	// SetEnabled; it is passed over as if it did not exist.
+	OutcomeDisabled       = "disabled"
+	OutcomeHostMismatch   = "host_mismatch"
+	OutcomePathMismatch   = "path_mismatch"
+	OutcomeQueryMismatch  = "query_mismatch"
+	OutcomeHeaderMismatch = "header_mismatch"
+	// OutcomeSlashMismatch is a path that only matched once its trailing
+	// slash was ignored, on a route with a strict trailing slash policy.
+	OutcomeSlashMismatch  = "trailing_slash_mismatch"
+	OutcomeMethodMismatch = "method_mismatch"
+	// OutcomeExclusive is a method mismatch on an exclusive route; routing
+	// stops there.
+	OutcomeExclusive      = "exclusive"
+	OutcomeOverrideDenied = "override_denied"
+	OutcomeMatched        = "matched"
+)
+
 type Router struct {
-	routes []*routeEntry
+	routes  []*routeEntry
+	rawPath bool // match the escaped path, see MatchRawPath
+	// enabled holds the enabled state of the routes by name; SetEnabled
+	// flips it while requests are being routed.
+	enabled map[string][]*atomic.Bool
 }
 
+// routeEntry is a route as matched for one of its hosts: a route with
+// several hosts has an entry for each.
 type routeEntry struct {
 	route      *Route
+	index      int          // position of the route in the configuration
+	enabled    *atomic.Bool // shared by the entries of a route
+	host       string       // empty for any host
 	segments   []segment
 	isWildcard bool
-	priority   int
+	// caseSet is whether the route sets case_sensitive_paths itself.
+	caseSet bool
+	// configPriority is the route's explicit priority; it orders routes
+	// before the computed ones below.
+	configPriority int
+	priority       int
+	// hostPriority orders routes of equal priority by the number of host
+	// labels they fix, so api.example.com is tried before *.example.com
+	// and both before a route for any host.
+	hostPriority int
+	// conditionPriority orders routes of equal priority: the more query
+	// and header conditions, and the more exact they are, the earlier.
+	conditionPriority int
 }
 
 type segment struct {
-	value   string
-	isParam bool
-	isWild  bool
+	value      string
+	isParam    bool
+	isWild     bool
+	pattern    string         // a parameter's constraint, if any
+	constraint *regexp.Regexp // pattern compiled by New
 }
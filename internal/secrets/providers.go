@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envResolver reads secrets from environment variables.
+type envResolver struct{}
+
+func (envResolver) Resolve(_ context.Context, path, field string) (string, error) {
+	if field != "" {
+		return "", fmt.Errorf("env secrets do not support fields")
+	}
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return "", fmt.Errorf("environment variable is not set")
+	}
+	return value, nil
+}
+
+// fileResolver reads secrets from files, e.g. mounted Kubernetes secrets.
+// With a field, the file must hold a JSON object.
+type fileResolver struct{}
+
+func (fileResolver) Resolve(_ context.Context, path, field string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if field == "" {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return lookupField(data, field)
+}
+
+// lookupField returns a string field from a JSON object. Parse errors are
+// reported without echoing the document.
+func lookupField(data []byte, field string) (string, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object")
+	}
+	return Field(doc, field)
+}
+
+// Field returns the named string field of a decoded secret document.
+func Field(doc map[string]any, field string) (string, error) {
+	v, ok := doc[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found", field)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", field)
+	}
+	return s, nil
+}
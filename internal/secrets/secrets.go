@@ -0,0 +1,148 @@
+// Package secrets resolves "secretref:" references in configuration values
+// through pluggable providers.
+//
+// A reference has the form secretref:<provider>:<path>[#field], e.g.
+// secretref:env:RELAYPOINT_CLUSTER_SECRET or secretref:vault:secret/data/gateway#api_key.
+// The environment and file providers are always available; others are
+// registered by optional packages so the default binary stays lean.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Prefix marks a configuration value as a secret reference.
+const Prefix = "secretref:"
+
+// Resolver fetches secret values from one provider.
+type Resolver interface {
+	// Resolve returns the secret at path, or the named field of it when
+	// field is non-empty. Errors must not contain the secret value.
+	Resolve(ctx context.Context, path, field string) (string, error)
+}
+
+// Ref identifies a secret.
+type Ref struct {
+	Provider string
+	Path     string
+	Field    string
+}
+
+func (r Ref) String() string {
+	s := Prefix + r.Provider + ":" + r.Path
+	if r.Field != "" {
+		s += "#" + r.Field
+	}
+	return s
+}
+
+// ParseRef parses s as a secret reference. ok is false when s is a plain
+// value rather than a reference.
+func ParseRef(s string) (ref Ref, ok bool, err error) {
+	rest, ok := strings.CutPrefix(s, Prefix)
+	if !ok {
+		return Ref{}, false, nil
+	}
+	provider, path, found := strings.Cut(rest, ":")
+	if !found || provider == "" || path == "" {
+		return Ref{}, true, fmt.Errorf("malformed secret reference %q: want secretref:<provider>:<path>[#field]", s)
+	}
+	path, field, _ := strings.Cut(path, "#")
+	return Ref{Provider: provider, Path: path, Field: field}, true, nil
+}
+
+// Store resolves references and caches their values until Refresh.
+type Store struct {
+	mu        sync.Mutex
+	resolvers map[string]Resolver
+	cache     map[Ref]string
+}
+
+// NewStore creates a store with the env and file providers registered.
+func NewStore() *Store {
+	return &Store{
+		resolvers: map[string]Resolver{
+			"env":  envResolver{},
+			"file": fileResolver{},
+		},
+		cache: make(map[Ref]string),
+	}
+}
+
+// Default is the store used when loading configuration.
+var Default = NewStore()
+
+// Register makes a provider available in the Default store.
+func Register(provider string, r Resolver) {
+	Default.Register(provider, r)
+}
+
+// Register makes a provider available under the given name.
+func (s *Store) Register(provider string, r Resolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolvers[provider] = r
+}
+
+// Get returns the value for ref, resolving it on first use.
+func (s *Store) Get(ctx context.Context, ref Ref) (string, error) {
+	s.mu.Lock()
+	value, ok := s.cache[ref]
+	resolver := s.resolvers[ref.Provider]
+	s.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	value, err := s.resolve(ctx, resolver, ref)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.cache[ref] = value
+	s.mu.Unlock()
+	return value, nil
+}
+
+// Refresh re-resolves every cached reference and reports whether any value
+// changed. References that fail keep their previous value.
+func (s *Store) Refresh(ctx context.Context) (changed bool, err error) {
+	s.mu.Lock()
+	refs := make(map[Ref]Resolver, len(s.cache))
+	for ref := range s.cache {
+		refs[ref] = s.resolvers[ref.Provider]
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for ref, resolver := range refs {
+		value, err := s.resolve(ctx, resolver, ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.mu.Lock()
+		if s.cache[ref] != value {
+			s.cache[ref] = value
+			changed = true
+		}
+		s.mu.Unlock()
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (s *Store) resolve(ctx context.Context, resolver Resolver, ref Ref) (string, error) {
+	if resolver == nil {
+		return "", fmt.Errorf("resolve %s: unknown secret provider %q", ref, ref.Provider)
+	}
+	value, err := resolver.Resolve(ctx, ref.Path, ref.Field)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", ref, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,172 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeResolver struct {
+	mu     sync.Mutex
+	values map[string]string
+	calls  int
+}
+
+func (f *fakeResolver) Resolve(_ context.Context, path, field string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	v, ok := f.values[path+"#"+field]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func (f *fakeResolver) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key] = value
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Ref
+		isRef   bool
+		wantErr bool
+	}{
+		{"plain-value", Ref{}, false, false},
+		{"secretref:env:API_KEY", Ref{Provider: "env", Path: "API_KEY"}, true, false},
+		{"secretref:vault:secret/data/gw#token", Ref{Provider: "vault", Path: "secret/data/gw", Field: "token"}, true, false},
+		{"secretref:awssm:prod/gateway", Ref{Provider: "awssm", Path: "prod/gateway"}, true, false},
+		{"secretref:env", Ref{}, true, true},
+		{"secretref::path", Ref{}, true, true},
+	}
+
+	for _, tt := range tests {
+		got, isRef, err := ParseRef(tt.in)
+		if isRef != tt.isRef || (err != nil) != tt.wantErr {
+			t.Errorf("ParseRef(%q) = ref %v, err %v", tt.in, isRef, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRef(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if !tt.wantErr && tt.isRef && got.String() != tt.in {
+			t.Errorf("%+v.String() = %q, want %q", got, got.String(), tt.in)
+		}
+	}
+}
+
+func TestStore_CachesAndRefreshesRotatedSecrets(t *testing.T) {
+	fake := &fakeResolver{values: map[string]string{"gw#key": "old-key"}}
+	store := NewStore()
+	store.Register("fake", fake)
+	ref := Ref{Provider: "fake", Path: "gw", Field: "key"}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		v, err := store.Get(ctx, ref)
+		if err != nil || v != "old-key" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	if fake.calls != 1 {
+		t.Errorf("resolver called %d times, want 1 (cached)", fake.calls)
+	}
+
+	changed, err := store.Refresh(ctx)
+	if err != nil || changed {
+		t.Errorf("Refresh without rotation = %v, %v", changed, err)
+	}
+
+	fake.set("gw#key", "new-key")
+	changed, err = store.Refresh(ctx)
+	if err != nil || !changed {
+		t.Errorf("Refresh after rotation = %v, %v", changed, err)
+	}
+	if v, _ := store.Get(ctx, ref); v != "new-key" {
+		t.Errorf("Get after rotation = %q, want new-key", v)
+	}
+}
+
+func TestStore_RefreshKeepsValueOnFailure(t *testing.T) {
+	fake := &fakeResolver{values: map[string]string{"gw#": "s3cret"}}
+	store := NewStore()
+	store.Register("fake", fake)
+	ref := Ref{Provider: "fake", Path: "gw"}
+	ctx := context.Background()
+
+	if _, err := store.Get(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	delete(fake.values, "gw#")
+	fake.mu.Unlock()
+
+	_, err := store.Refresh(ctx)
+	if err == nil || !strings.Contains(err.Error(), "secretref:fake:gw") {
+		t.Errorf("Refresh error %v should name the reference", err)
+	}
+	if v, _ := store.Get(ctx, ref); v != "s3cret" {
+		t.Errorf("Get after failed refresh = %q, want previous value", v)
+	}
+}
+
+func TestStore_ErrorsDoNotLeakSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "creds")
+	if err := os.WriteFile(path, []byte("hunter2-not-json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewStore()
+	_, err := store.Get(context.Background(), Ref{Provider: "file", Path: path, Field: "password"})
+	if err == nil {
+		t.Fatal("expected an error for a non-JSON file with a field")
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("error leaks the secret: %v", err)
+	}
+	if !strings.Contains(err.Error(), "secretref:file:"+path+"#password") {
+		t.Errorf("error %v should name the reference", err)
+	}
+}
+
+func TestProviders(t *testing.T) {
+	t.Setenv("RELAYPOINT_TEST_SECRET", "from-env")
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	doc := filepath.Join(dir, "doc.json")
+	_ = os.WriteFile(plain, []byte("from-file\n"), 0o600)
+	_ = os.WriteFile(doc, []byte(`{"token":"from-json"}`), 0o600)
+
+	store := NewStore()
+	tests := []struct {
+		ref  Ref
+		want string
+	}{
+		{Ref{Provider: "env", Path: "RELAYPOINT_TEST_SECRET"}, "from-env"},
+		{Ref{Provider: "file", Path: plain}, "from-file"},
+		{Ref{Provider: "file", Path: doc, Field: "token"}, "from-json"},
+	}
+	for _, tt := range tests {
+		got, err := store.Get(context.Background(), tt.ref)
+		if err != nil || got != tt.want {
+			t.Errorf("Get(%s) = %q, %v; want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if _, err := store.Get(context.Background(), Ref{Provider: "env", Path: "RELAYPOINT_TEST_UNSET"}); err == nil {
+		t.Error("expected an error for an unset variable")
+	}
+	if _, err := store.Get(context.Background(), Ref{Provider: "nope", Path: "x"}); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
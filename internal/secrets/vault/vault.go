@@ -0,0 +1,67 @@
+// Package vault resolves secret references against HashiCorp Vault's KV v2
+// HTTP API, e.g. secretref:vault:secret/data/gateway#api_key.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/secrets"
+)
+
+// Resolver reads KV v2 secrets. The field is required.
+type Resolver struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// FromEnv creates a resolver from VAULT_ADDR and VAULT_TOKEN.
+func FromEnv() *Resolver {
+	return &Resolver{
+		Address: os.Getenv("VAULT_ADDR"),
+		Token:   os.Getenv("VAULT_TOKEN"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (r *Resolver) Resolve(ctx context.Context, path, field string) (string, error) {
+	if field == "" {
+		return "", fmt.Errorf("vault secrets need a #field")
+	}
+	if r.Address == "" {
+		return "", fmt.Errorf("vault address is not configured")
+	}
+
+	url := strings.TrimRight(r.Address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", r.Token)
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault response: invalid JSON")
+	}
+	return secrets.Field(body.Data.Data, field)
+}
@@ -0,0 +1,43 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/gateway" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"api_key":"k-123"},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	r := &Resolver{Address: srv.URL, Token: "root", Client: srv.Client()}
+	ctx := context.Background()
+
+	got, err := r.Resolve(ctx, "secret/data/gateway", "api_key")
+	if err != nil || got != "k-123" {
+		t.Fatalf("Resolve = %q, %v", got, err)
+	}
+
+	if _, err := r.Resolve(ctx, "secret/data/gateway", "missing"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if _, err := r.Resolve(ctx, "secret/data/gateway", ""); err == nil {
+		t.Error("expected an error without a field")
+	}
+
+	r.Token = "wrong"
+	if _, err := r.Resolve(ctx, "secret/data/gateway", "api_key"); err == nil {
+		t.Error("expected an error for a rejected token")
+	}
+}
@@ -0,0 +1,237 @@
+// Package synthetic runs config-defined probes through the gateway's own
+// handler, so routing, rewrites and auth are exercised the way clients
+// see them without going over the network.
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	defaultTimeout  = 5 * time.Second
+
+	// maxBody bounds how much of a response is kept to look for
+	// expect_body.
+	maxBody = 1 << 20
+)
+
+type probeKey struct{}
+
+// IsProbe reports whether the request was sent by a synthetic probe. The
+// proxy leaves such requests out of usage stats and rate limits.
+func IsProbe(r *http.Request) bool {
+	return r.Context().Value(probeKey{}) != nil
+}
+
+// Config configures a Prober.
+type Config struct {
+	Probes  []config.SyntheticProbe
+	Handler http.Handler // usually the proxy
+
+	Metrics *metrics.Metrics // may be nil
+	Events  *events.Bus      // may be nil
+	Logger  *slog.Logger
+}
+
+// Prober runs each probe on its own interval.
+type Prober struct {
+	cfg Config
+
+	mu      sync.RWMutex
+	passing map[string]bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a prober. No probe has passed until it first runs.
+func New(cfg Config) *Prober {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Prober{
+		cfg:     cfg,
+		passing: make(map[string]bool),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start runs every probe now and then on its interval until Stop.
+func (p *Prober) Start() {
+	for _, probe := range p.cfg.Probes {
+		p.wg.Add(1)
+		go p.loop(probe)
+	}
+}
+
+func (p *Prober) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+func (p *Prober) loop(probe config.SyntheticProbe) {
+	defer p.wg.Done()
+
+	interval := probe.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.run(probe)
+	for {
+		select {
+		case <-ticker.C:
+			p.run(probe)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// RunOnce runs every probe once, in order.
+func (p *Prober) RunOnce() {
+	for _, probe := range p.cfg.Probes {
+		p.run(probe)
+	}
+}
+
+// Ready reports whether every probe with ready set passed its last run.
+func (p *Prober) Ready() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, probe := range p.cfg.Probes {
+		if probe.Ready && !p.passing[probe.Name] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Prober) run(probe config.SyntheticProbe) {
+	start := time.Now()
+	status, err := p.probe(probe)
+	duration := time.Since(start)
+	ok := err == nil
+
+	if p.cfg.Metrics != nil {
+		p.cfg.Metrics.RecordSyntheticProbe(probe.Name, ok, duration)
+	}
+
+	p.mu.Lock()
+	was, ran := p.passing[probe.Name]
+	p.passing[probe.Name] = ok
+	p.mu.Unlock()
+
+	if !ok {
+		p.cfg.Logger.Warn("synthetic probe failed", "probe", probe.Name, "status", status, "duration", duration, "error", err)
+	}
+	// The first run is published only if it fails, so a healthy start
+	// stays quiet.
+	if (ran && was != ok) || (!ran && !ok) {
+		data := map[string]any{
+			"name":        probe.Name,
+			"success":     ok,
+			"status":      status,
+			"duration_ms": duration.Milliseconds(),
+		}
+		if err != nil {
+			data["error"] = err.Error()
+		}
+		p.cfg.Events.Publish(events.SyntheticProbe, data)
+	}
+}
+
+// probe sends one request through the handler and checks the response. It
+// returns the response status, if there was one.
+func (p *Prober) probe(probe config.SyntheticProbe) (int, error) {
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), probeKey{}, probe.Name), timeout)
+	defer cancel()
+
+	method := strings.ToUpper(probe.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, method, probe.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = probe.Host
+	req.RemoteAddr = "127.0.0.1:0"
+	req.RequestURI = probe.Path
+	for k, v := range probe.Headers {
+		req.Header.Set(k, v)
+	}
+
+	w := newResponseWriter()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.cfg.Handler.ServeHTTP(w, req)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return 0, fmt.Errorf("no response within %v", timeout)
+	}
+
+	want := probe.ExpectStatus
+	if want == 0 {
+		want = http.StatusOK
+	}
+	if w.status != want {
+		return w.status, fmt.Errorf("status %d, want %d", w.status, want)
+	}
+	if probe.ExpectBody != "" && !bytes.Contains(w.body.Bytes(), []byte(probe.ExpectBody)) {
+		return w.status, fmt.Errorf("body does not contain %q", probe.ExpectBody)
+	}
+	return w.status, nil
+}
+
+// responseWriter keeps the status and the start of the body of a probe's
+// response.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: make(http.Header)}
+}
+
+func (w *responseWriter) Header() http.Header { return w.header }
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if room := maxBody - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return len(b), nil
+}
+
+// Flush lets streaming routes write through the probe.
+func (w *responseWriter) Flush() {}
@@ -0,0 +1,110 @@
+package synthetic
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+func TestProber(t *testing.T) {
+	status := http.StatusOK
+	var sawProbe, sawHost string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsProbe(r) {
+			sawProbe = "yes"
+		}
+		sawHost = r.Host
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `{"status":"ok"}`)
+	})
+
+	m := metrics.New(metrics.Config{})
+	bus := events.NewBus(m)
+	sub := bus.Subscribe([]string{events.SyntheticProbe}, 4)
+	p := New(Config{
+		Probes: []config.SyntheticProbe{
+			{Name: "orders", Path: "/orders?limit=1", Host: "api.example.com", ExpectBody: `"ok"`, Ready: true},
+		},
+		Handler: handler,
+		Metrics: m,
+		Events:  bus,
+		Logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if p.Ready() {
+		t.Fatal("ready before the first run")
+	}
+	p.RunOnce()
+	if !p.Ready() {
+		t.Fatal("not ready after a passing run")
+	}
+	if sawProbe != "yes" || sawHost != "api.example.com" {
+		t.Errorf("request not marked as a probe or wrong host: probe=%q host=%q", sawProbe, sawHost)
+	}
+	select {
+	case e := <-sub.C:
+		t.Fatalf("passing first run published %v", e)
+	default:
+	}
+
+	status = http.StatusNotFound
+	p.RunOnce()
+	if p.Ready() {
+		t.Error("still ready after a failing run")
+	}
+	select {
+	case e := <-sub.C:
+		if e.Data["success"] != false || e.Data["status"] != http.StatusNotFound {
+			t.Errorf("event = %v", e.Data)
+		}
+	default:
+		t.Error("failure not published")
+	}
+
+	body := httptest.NewRecorder()
+	m.Handler().ServeHTTP(body, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`gateway_synthetic_probe_success{name="orders"} 0`,
+		`gateway_synthetic_probe_duration_seconds_count{name="orders"} 2`,
+	} {
+		if !strings.Contains(body.Body.String(), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+func TestProber_Expectations(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, "created")
+	})
+	p := New(Config{Handler: handler, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+
+	for _, tt := range []struct {
+		name  string
+		probe config.SyntheticProbe
+		ok    bool
+	}{
+		{"default status", config.SyntheticProbe{Path: "/"}, false},
+		{"expected status", config.SyntheticProbe{Path: "/", ExpectStatus: http.StatusCreated}, true},
+		{"body matches", config.SyntheticProbe{Path: "/", ExpectStatus: http.StatusCreated, ExpectBody: "creat"}, true},
+		{"body differs", config.SyntheticProbe{Path: "/", ExpectStatus: http.StatusCreated, ExpectBody: "gone"}, false},
+		{"timeout", config.SyntheticProbe{Path: "/slow", Timeout: 20 * time.Millisecond}, false},
+	} {
+		if _, err := p.probe(tt.probe); (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
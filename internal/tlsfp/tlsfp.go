@@ -0,0 +1,154 @@
+// Package tlsfp computes JA3-style TLS client fingerprints once per
+// connection and makes them available to every request on it.
+package tlsfp
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// extSupportedVersions is the supported_versions extension (RFC 8446).
+const extSupportedVersions = 43
+
+// JA3 returns the JA3 string for hello:
+// version,ciphers,extensions,curves,point_formats with GREASE values removed.
+func JA3(hello *tls.ClientHelloInfo) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(int(legacyVersion(hello))))
+	b.WriteByte(',')
+	writeList(&b, hello.CipherSuites)
+	b.WriteByte(',')
+	writeList(&b, hello.Extensions)
+	b.WriteByte(',')
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	writeList(&b, curves)
+	b.WriteByte(',')
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+	writeList(&b, points)
+	return b.String()
+}
+
+// Fingerprint returns the MD5 hex digest of hello's JA3 string.
+func Fingerprint(hello *tls.ClientHelloInfo) string {
+	sum := md5.Sum([]byte(JA3(hello)))
+	return hex.EncodeToString(sum[:])
+}
+
+// legacyVersion recovers the ClientHello's legacy_version field, which
+// crypto/tls does not expose directly: clients that send supported_versions
+// always use TLS 1.2 there; otherwise it is the highest version offered.
+func legacyVersion(hello *tls.ClientHelloInfo) uint16 {
+	if slices.Contains(hello.Extensions, extSupportedVersions) {
+		return tls.VersionTLS12
+	}
+	var max uint16
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > max {
+			max = v
+		}
+	}
+	return max
+}
+
+func writeList(b *strings.Builder, values []uint16) {
+	first := true
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		if !first {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+		first = false
+	}
+}
+
+// isGREASE reports whether v is a reserved GREASE value (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+type contextKey struct{}
+
+// connState carries the fingerprint of one connection. It is filled in
+// during the handshake, before any request on the connection is read.
+type connState struct {
+	fingerprint atomic.Pointer[string]
+}
+
+// Capture wires fingerprinting into an http.Server.
+type Capture struct {
+	conns sync.Map // net.Conn -> *connState
+}
+
+// Install sets server's TLS hooks so each connection is fingerprinted once
+// during its handshake. server.TLSConfig must already be set.
+func (c *Capture) Install(server *http.Server) {
+	base := server.TLSConfig
+	prevGetConfig := base.GetConfigForClient
+	base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if v, ok := c.conns.Load(hello.Conn); ok {
+			fp := Fingerprint(hello)
+			v.(*connState).fingerprint.Store(&fp)
+		}
+		if prevGetConfig != nil {
+			return prevGetConfig(hello)
+		}
+		return nil, nil
+	}
+
+	prevConnContext := server.ConnContext
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if prevConnContext != nil {
+			ctx = prevConnContext(ctx, conn)
+		}
+		tlsConn, ok := conn.(*tls.Conn)
+		if !ok {
+			return ctx
+		}
+		state := &connState{}
+		c.conns.Store(tlsConn.NetConn(), state)
+		return context.WithValue(ctx, contextKey{}, state)
+	}
+
+	prevConnState := server.ConnState
+	server.ConnState = func(conn net.Conn, s http.ConnState) {
+		if s == http.StateClosed || s == http.StateHijacked {
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				c.conns.Delete(tlsConn.NetConn())
+			}
+		}
+		if prevConnState != nil {
+			prevConnState(conn, s)
+		}
+	}
+}
+
+// FromContext returns the TLS fingerprint of the connection a request
+// arrived on, or "" for plaintext connections.
+func FromContext(ctx context.Context) string {
+	state, ok := ctx.Value(contextKey{}).(*connState)
+	if !ok {
+		return ""
+	}
+	if fp := state.fingerprint.Load(); fp != nil {
+		return *fp
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+package tlsfp
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJA3(t *testing.T) {
+	tests := []struct {
+		name      string
+		hello     *tls.ClientHelloInfo
+		wantJA3   string
+		wantHash  string
+		checkHash bool
+	}{
+		{
+			// The reference example from the JA3 README.
+			name: "ja3 readme",
+			hello: &tls.ClientHelloInfo{
+				SupportedVersions: []uint16{tls.VersionTLS10},
+				CipherSuites:      []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				Extensions:        []uint16{0, 10, 11},
+				SupportedCurves:   []tls.CurveID{23, 24, 25},
+				SupportedPoints:   []uint8{0},
+			},
+			wantJA3:   "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			wantHash:  "ada70206e40642a3e4461f35503241d5",
+			checkHash: true,
+		},
+		{
+			// A TLS 1.3 browser hello with GREASE values sprinkled in.
+			name: "grease removed",
+			hello: &tls.ClientHelloInfo{
+				SupportedVersions: []uint16{0x3a3a, tls.VersionTLS13, tls.VersionTLS12},
+				CipherSuites:      []uint16{0x1a1a, 4865, 4866, 4867, 49195},
+				Extensions:        []uint16{0x2a2a, 0, 23, 65281, 10, 11, 35, 16, 5, 13, 18, 51, 45, 43, 27, 0xdada},
+				SupportedCurves:   []tls.CurveID{0x4a4a, 29, 23, 24},
+				SupportedPoints:   []uint8{0},
+			},
+			wantJA3: "771,4865-4866-4867-49195,0-23-65281-10-11-35-16-5-13-18-51-45-43-27,29-23-24,0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JA3(tt.hello); got != tt.wantJA3 {
+				t.Errorf("JA3 = %q, want %q", got, tt.wantJA3)
+			}
+			if tt.checkHash {
+				if got := Fingerprint(tt.hello); got != tt.wantHash {
+					t.Errorf("Fingerprint = %q, want %q", got, tt.wantHash)
+				}
+			}
+		})
+	}
+}
+
+func TestCapture_OncePerConnection(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, FromContext(r.Context()))
+	}))
+
+	var handshakes atomic.Int32
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			handshakes.Add(1)
+			return nil, nil
+		},
+	}
+	srv.Config.TLSConfig = srv.TLS
+	new(Capture).Install(srv.Config)
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	var first string
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if len(body) != 32 {
+			t.Fatalf("fingerprint %q is not an MD5 hex digest", body)
+		}
+		if i == 0 {
+			first = string(body)
+		} else if string(body) != first {
+			t.Errorf("fingerprint changed on the same client: %q then %q", first, body)
+		}
+	}
+
+	if n := handshakes.Load(); n != 1 {
+		t.Errorf("ClientHello inspected %d times, want once for a kept-alive connection", n)
+	}
+}
+
+func TestFromContext_Plaintext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if fp := FromContext(r.Context()); fp != "" {
+		t.Errorf("plaintext request has fingerprint %q", fp)
+	}
+}
@@ -0,0 +1,268 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/metrics"
+)
+
+const (
+	defaultServiceName = "relaypoint"
+
+	queueSize     = 2048
+	batchSize     = 512
+	flushInterval = 5 * time.Second
+)
+
+// Config configures a Tracer.
+type Config struct {
+	// Endpoint is the OTLP/HTTP traces URL, e.g.
+	// http://tempo:4318/v1/traces.
+	Endpoint    string
+	SampleRatio float64 // share of new traces recorded, 0-1
+	ServiceName string  // default relaypoint
+
+	Client  *http.Client
+	Metrics *metrics.Metrics // may be nil
+	Logger  *slog.Logger
+}
+
+func (c *Config) setDefaults() {
+	if c.ServiceName == "" {
+		c.ServiceName = defaultServiceName
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+}
+
+// exporter posts ended spans in batches from a background goroutine, so the
+// request path never waits on the collector. Spans that arrive while the
+// queue is full are dropped.
+type exporter struct {
+	cfg   Config
+	queue chan *otlpSpan
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func newExporter(cfg Config) *exporter {
+	e := &exporter{
+		cfg:   cfg,
+		queue: make(chan *otlpSpan, queueSize),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (t *Tracer) export(s *Span, end time.Time) {
+	s.mu.Lock()
+	span := toOTLP(s, end)
+	s.mu.Unlock()
+
+	select {
+	case t.exporter.queue <- span:
+	default:
+		t.exporter.record("dropped", 1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*otlpSpan
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				e.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			e.flush(batch)
+			batch = nil
+		case <-e.quit:
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+				default:
+					e.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) stop() {
+	close(e.quit)
+	<-e.done
+}
+
+func (e *exporter) record(outcome string, n int) {
+	if e.cfg.Metrics != nil {
+		e.cfg.Metrics.RecordTracingSpans(outcome, n)
+	}
+}
+
+func (e *exporter) flush(batch []*otlpSpan) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.post(batch); err != nil {
+		e.record("failed", len(batch))
+		e.cfg.Logger.Warn("exporting spans failed", "endpoint", e.cfg.Endpoint, "spans", len(batch), "error", err)
+		return
+	}
+	e.record("exported", len(batch))
+}
+
+func (e *exporter) post(batch []*otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{stringAttr("service.name", e.cfg.ServiceName)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "relaypoint"},
+			Spans: batch,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The types below are the OTLP/HTTP JSON encoding of an
+// ExportTraceServiceRequest. IDs are hex and 64-bit integers strings, as
+// the protobuf JSON mapping requires.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+func stringAttr(key, v string) otlpAttr {
+	return otlpAttr{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func toOTLP(s *Span, end time.Time) *otlpSpan {
+	span := &otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Status:            otlpStatus{Code: statusOK},
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	for _, a := range s.attrs {
+		attr := otlpAttr{Key: a.Key}
+		switch v := a.Value.(type) {
+		case string:
+			attr.Value.StringValue = &v
+		case int:
+			i := strconv.Itoa(v)
+			attr.Value.IntValue = &i
+		case int64:
+			i := strconv.FormatInt(v, 10)
+			attr.Value.IntValue = &i
+		case bool:
+			attr.Value.BoolValue = &v
+		default:
+			str := fmt.Sprint(v)
+			attr.Value.StringValue = &str
+		}
+		span.Attributes = append(span.Attributes, attr)
+	}
+	if s.status != 0 {
+		i := strconv.Itoa(s.status)
+		span.Attributes = append(span.Attributes, otlpAttr{Key: "http.response.status_code", Value: otlpValue{IntValue: &i}})
+	}
+
+	failed := s.status >= 500 || (s.kind == kindClient && s.status >= 400)
+	if s.err != "" || failed {
+		span.Status = otlpStatus{Code: statusError, Message: s.err}
+	}
+	return span
+}
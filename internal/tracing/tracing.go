@@ -0,0 +1,241 @@
+// Package tracing records a server span per proxied request and a client
+// span per upstream call, propagates W3C trace context and exports sampled
+// spans to an OpenTelemetry collector over OTLP/HTTP with JSON encoding.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceparentHeader = "Traceparent"
+
+	// OTLP span kinds.
+	kindServer = 2
+	kindClient = 3
+
+	// OTLP status codes.
+	statusOK    = 1
+	statusError = 2
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Versions other
+// than 00 are read by their 00 prefix, as the spec asks.
+func ParseTraceparent(v string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(v) < 55 || (len(v) > 55 && (v[:2] == "00" || v[55] != '-')) {
+		return sc, false
+	}
+	if v[2] != '-' || v[35] != '-' || v[52] != '-' || v[:2] == "ff" {
+		return sc, false
+	}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(v[:2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(v[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(v[36:52])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(v[53:55])); err != nil {
+		return sc, false
+	}
+	if sc.TraceID == [16]byte{} || sc.SpanID == [8]byte{} {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// Attr is a span attribute. Value is a string, int, int64 or bool.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Span is one timed operation. Spans outside the sample still carry IDs so
+// the trace context can be propagated, but record nothing. All methods are
+// no-ops on a nil span.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	parent [8]byte
+	name   string
+	kind   int
+	start  time.Time
+
+	mu     sync.Mutex
+	attrs  []Attr
+	err    string
+	status int
+	ended  bool
+}
+
+// Context returns the span's identifiers.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetName renames the span, e.g. once the route is known.
+func (s *Span) SetName(name string) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil || !s.ctx.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// Inject sets the traceparent header on h so the next hop continues the
+// trace under this span.
+func (s *Span) Inject(h http.Header) {
+	if s == nil {
+		return
+	}
+	h.Set(traceparentHeader, s.ctx.Traceparent())
+}
+
+// End finishes the span with the HTTP status of the exchange and queues it
+// for export. Server spans fail on 5xx, client spans on 4xx and 5xx.
+func (s *Span) End(status int) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.status = status
+	s.mu.Unlock()
+	s.tracer.export(s, time.Now())
+}
+
+type spanKey struct{}
+
+// FromContext returns the span stored by StartServer, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Tracer creates spans and exports the sampled ones.
+type Tracer struct {
+	cfg       Config
+	threshold uint64 // trace IDs below this are sampled without a parent
+	exporter  *exporter
+}
+
+// New creates a tracer and starts its exporter.
+func New(cfg Config) *Tracer {
+	cfg.setDefaults()
+	t := &Tracer{cfg: cfg}
+	switch {
+	case cfg.SampleRatio >= 1:
+		t.threshold = math.MaxUint64
+	case cfg.SampleRatio > 0:
+		t.threshold = uint64(cfg.SampleRatio * (1 << 64))
+	}
+	t.exporter = newExporter(cfg)
+	return t
+}
+
+// Stop exports the spans still queued and stops the exporter.
+func (t *Tracer) Stop() {
+	t.exporter.stop()
+}
+
+// StartServer starts the span for an incoming request, continuing the trace
+// from its traceparent header if there is a valid one. The returned request
+// carries the span in its context.
+func (t *Tracer) StartServer(r *http.Request) (*http.Request, *Span) {
+	s := &Span{tracer: t, name: r.Method, kind: kindServer, start: time.Now()}
+	if parent, ok := ParseTraceparent(strings.TrimSpace(r.Header.Get(traceparentHeader))); ok {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+		s.ctx.Sampled = parent.Sampled
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = t.sampled(s.ctx.TraceID)
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return r.WithContext(context.WithValue(r.Context(), spanKey{}, s)), s
+}
+
+// StartClient starts a span for an upstream call, as a child of the server
+// span in ctx. It returns nil when ctx has no span.
+func (t *Tracer) StartClient(ctx context.Context, name string) *Span {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	s := &Span{
+		tracer: t,
+		ctx:    SpanContext{TraceID: parent.ctx.TraceID, Sampled: parent.ctx.Sampled},
+		parent: parent.ctx.SpanID,
+		name:   name,
+		kind:   kindClient,
+		start:  time.Now(),
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	return s
+}
+
+// sampled decides for a new trace from the low 8 bytes of its ID, so every
+// hop using ratio sampling agrees on the same traces.
+func (t *Tracer) sampled(id [16]byte) bool {
+	if t.threshold == math.MaxUint64 {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:]) < t.threshold
+}
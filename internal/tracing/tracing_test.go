@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-xyz92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok || sc.Sampled != tt.sampled {
+			t.Errorf("ParseTraceparent(%q) = sampled %v, ok %v; want %v, %v", tt.value, sc.Sampled, ok, tt.sampled, tt.ok)
+		}
+	}
+
+	sc, _ := ParseTraceparent(tests[0].value)
+	if got := sc.Traceparent(); got != tests[0].value {
+		t.Errorf("Traceparent() = %q, want %q", got, tests[0].value)
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	never := New(Config{Endpoint: "http://127.0.0.1:1", SampleRatio: 0})
+	defer never.Stop()
+	always := New(Config{Endpoint: "http://127.0.0.1:1", SampleRatio: 1})
+	defer always.Stop()
+
+	for i := 0; i < 100; i++ {
+		if _, s := never.StartServer(httptest.NewRequest("GET", "/", nil)); s.Context().Sampled {
+			t.Fatal("ratio 0 sampled a new trace")
+		}
+		if _, s := always.StartServer(httptest.NewRequest("GET", "/", nil)); !s.Context().Sampled {
+			t.Fatal("ratio 1 did not sample a new trace")
+		}
+	}
+
+	// A sampled parent is followed regardless of the ratio.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, s := never.StartServer(r); !s.Context().Sampled {
+		t.Error("sampled parent was not followed")
+	}
+}
+
+func TestTracer_Export(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []otlpRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid export body: %v", err)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	tr := New(Config{Endpoint: collector.URL, SampleRatio: 1, ServiceName: "edge"})
+	r := httptest.NewRequest("GET", "/orders", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r, server := tr.StartServer(r)
+	server.SetAttributes(Attr{Key: "relaypoint.route", Value: "orders"})
+
+	client := tr.StartClient(r.Context(), "GET")
+	h := http.Header{}
+	client.Inject(h)
+	if sc, ok := ParseTraceparent(h.Get("traceparent")); !ok || sc.TraceID != server.Context().TraceID || sc.SpanID != client.Context().SpanID {
+		t.Errorf("injected traceparent %q does not name the client span", h.Get("traceparent"))
+	}
+	client.End(http.StatusServiceUnavailable)
+	server.End(http.StatusOK)
+	tr.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 {
+		t.Fatalf("got %d exports, want 1", len(reqs))
+	}
+	rs := reqs[0].ResourceSpans[0]
+	if v := rs.Resource.Attributes[0].Value.StringValue; v == nil || *v != "edge" {
+		t.Errorf("service.name = %v, want edge", v)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	c, s := spans[0], spans[1]
+	if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || s.ParentSpanID != "00f067aa0ba902b7" || s.Kind != kindServer {
+		t.Errorf("server span = %+v", s)
+	}
+	if c.ParentSpanID != s.SpanID || c.Kind != kindClient {
+		t.Errorf("client span parent = %s, want %s", c.ParentSpanID, s.SpanID)
+	}
+	if c.Status.Code != statusError || s.Status.Code != statusOK {
+		t.Errorf("status codes client %d server %d, want %d and %d", c.Status.Code, s.Status.Code, statusError, statusOK)
+	}
+}
@@ -0,0 +1,552 @@
+// Package gateway runs a relaypoint gateway in process. It is what the
+// relaypoint command runs, for programs that embed the gateway or start
+// one in their tests.
+//
+// A Gateway is built from a configuration with New. Handler serves requests
+// without any listener, e.g. behind httptest.NewServer; Start opens the
+// configured listeners and starts health checks, synthetic probes and
+// cluster sync, and Shutdown stops them again.
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/cluster"
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/connlimit"
+	"github.com/relaypoint/relaypoint/internal/events"
+	"github.com/relaypoint/relaypoint/internal/framing"
+	"github.com/relaypoint/relaypoint/internal/health"
+	"github.com/relaypoint/relaypoint/internal/lifecycle"
+	"github.com/relaypoint/relaypoint/internal/pathprefix"
+	"github.com/relaypoint/relaypoint/internal/proxy"
+	"github.com/relaypoint/relaypoint/internal/router"
+	"github.com/relaypoint/relaypoint/internal/secrets"
+	"github.com/relaypoint/relaypoint/internal/synthetic"
+	"github.com/relaypoint/relaypoint/internal/tlsfp"
+)
+
+// Configuration types, as read from relaypoint.yml.
+type (
+	Config      = config.Config
+	Route       = config.Route
+	Upstream    = config.Upstream
+	Target      = config.Target
+	APIKey      = config.APIKey
+	HealthCheck = config.HealthCheck
+)
+
+// DefaultConfig returns the configuration an empty file loads to.
+func DefaultConfig() *Config {
+	return config.DefaultConfig()
+}
+
+// LoadConfig reads, resolves and validates the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	return config.Load(path)
+}
+
+// ParseConfig reads and validates a configuration from YAML.
+func ParseConfig(data []byte) (*Config, error) {
+	return config.Parse(data)
+}
+
+// Middleware wraps the handling of routed requests; see Gateway.Use.
+type Middleware = proxy.Middleware
+
+// MatchedRoute is the route a request was matched to, with the values of
+// its path parameters.
+type MatchedRoute = router.Route
+
+// RouteFromContext returns the route a request was matched to, or nil
+// before routing. Middlewares read it from the request's context.
+func RouteFromContext(ctx context.Context) *MatchedRoute {
+	return router.RouteFromContext(ctx)
+}
+
+// Option customizes a Gateway.
+type Option func(*Gateway)
+
+// WithLogger sets the logger, slog.Default() if not set.
+func WithLogger(logger *slog.Logger) Option {
+	return func(g *Gateway) { g.logger = logger }
+}
+
+// WithConfigFile names the file the configuration was loaded from. Rotated
+// secrets are applied by loading it again, so secrets.refresh_interval
+// only takes effect with it.
+func WithConfigFile(path string) Option {
+	return func(g *Gateway) { g.configFile = path }
+}
+
+// WithVersion sets the version and build time /version reports.
+func WithVersion(version, buildTime string) Option {
+	return func(g *Gateway) { g.version, g.buildTime = version, buildTime }
+}
+
+// Gateway is an API gateway serving one configuration.
+type Gateway struct {
+	cfg        *config.Config
+	logger     *slog.Logger
+	configFile string
+	version    string
+	buildTime  string
+
+	proxy        *proxy.Proxy
+	shutdown     *lifecycle.Coordinator
+	probes       *http.ServeMux
+	handler      http.Handler
+	probeHandler http.Handler
+	checker      *health.Checker   // nil without health checks
+	prober       *synthetic.Prober // nil without synthetic probes
+	node         *cluster.Node     // nil without clustering
+
+	mu          sync.Mutex
+	started     bool
+	servers     []*http.Server // main, probe and metrics, as configured
+	limiter     *connlimit.Limiter
+	stopSecrets chan struct{}
+	stopOnce    sync.Once
+
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error // why the gateway stopped on its own, set before done closes
+}
+
+// New builds a gateway from cfg, which must be valid. It does not listen
+// or start any background work until Start.
+func New(cfg *Config, opts ...Option) (*Gateway, error) {
+	g := &Gateway{
+		cfg:       cfg,
+		logger:    slog.Default(),
+		version:   "dev",
+		buildTime: "unknown",
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	p, err := proxy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	g.proxy = p
+
+	for _, warning := range cfg.Warnings() {
+		g.logger.Warn("configuration warning", "warning", warning)
+	}
+	for _, c := range p.RouteConflicts() {
+		g.logger.Warn("route shadowed", "route", c.Route, "by", c.By, "host", c.Host)
+	}
+	for i, step := range p.RouteOrder() {
+		g.logger.Debug("route order", "position", i+1, "route", step.Route, "host", step.Host, "path", step.Path,
+			"priority", step.Priority, "config_priority", step.ConfigPriority)
+	}
+
+	healthConfigs := make(map[string]*config.HealthCheck)
+	for _, u := range cfg.Upstreams {
+		if u.HealthCheck != nil {
+			healthConfigs[u.Name] = u.HealthCheck
+		}
+	}
+	if len(healthConfigs) > 0 {
+		g.checker = health.NewChecker(p.Upstreams(), healthConfigs, p.Metrics(), g.logger)
+		g.checker.SetEvents(p.Events())
+		g.checker.SetInitialCheck(p.InitialHealthChecked)
+		g.logger.Info("Health checks configured", "upstreams", len(healthConfigs))
+	}
+
+	var probesReady func() bool
+	if len(cfg.SyntheticProbes) > 0 {
+		g.prober = synthetic.New(synthetic.Config{
+			Probes:  cfg.SyntheticProbes,
+			Handler: p.BasePathHandler(p),
+			Metrics: p.Metrics(),
+			Events:  p.Events(),
+			Logger:  g.logger,
+		})
+		probesReady = g.prober.Ready
+		g.logger.Info("synthetic probes configured", "probes", len(cfg.SyntheticProbes))
+	}
+
+	g.shutdown = lifecycle.NewCoordinator(lifecycle.Config{
+		PreStopDelay: cfg.Server.PreStopDelay,
+		DrainTimeout: cfg.Server.ShutdownTimeout,
+		InFlight:     p.Metrics().TotalInFlight,
+		Healthy: func() bool {
+			return p.InitialHealthReady() && (probesReady == nil || probesReady())
+		},
+		Degraded: p.Degraded,
+		Mode:     p.Mode,
+		Metrics:  p.Metrics(),
+		Logger:   g.logger,
+	})
+
+	// Probes are answered before anything else looks at the request.
+	g.probes = g.shutdown.ProbeMux()
+	g.probes.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"version":            g.version,
+			"build_time":         g.buildTime,
+			"config_fingerprint": p.ConfigFingerprint(),
+		})
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/", p)
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := p.UsageStats()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+	})
+
+	if cfg.Cluster.Enabled {
+		g.node = cluster.NewNode(cluster.Config{
+			NodeID:       cfg.Cluster.NodeID,
+			Peers:        cfg.Cluster.Peers,
+			DiscoveryDNS: cfg.Cluster.DiscoveryDNS,
+			// Peers are called under the base path, like every listener.
+			Path:     pathprefix.New(cfg.Server.BasePath).Join(cfg.Cluster.Path),
+			Interval: cfg.Cluster.Interval,
+			Secret:   cfg.Cluster.Secret,
+		}, p.ClusterProviders(), p.Metrics(), g.logger)
+		mux.Handle(cfg.Cluster.Path, g.node.Handler())
+	}
+
+	g.handler = p.BasePathHandler(lifecycle.Prioritize(g.probes, mux))
+	// The pre-stop hook stops the gateway, so like the admin API it is only
+	// on the probe listener.
+	admin := http.NewServeMux()
+	admin.Handle("/admin/prestop", g.shutdown.PreStopHandler())
+	admin.Handle("/", p.AdminHandler())
+	g.probeHandler = p.BasePathHandler(lifecycle.Prioritize(g.probes, admin))
+	return g, nil
+}
+
+// Handler returns the handler of the main listener: proxied routes, the
+// probes and /stats, under server.base_path. The admin API and the
+// pre-stop hook are not on it; requests under /admin/ are routed like any
+// other.
+func (g *Gateway) Handler() http.Handler {
+	return g.handler
+}
+
+// ProbeHandler returns the handler of the probe listener: the probes, the
+// pre-stop hook and the admin API, under server.base_path. It has no authentication of its
+// own, so it must only be reachable by operators.
+func (g *Gateway) ProbeHandler() http.Handler {
+	return g.probeHandler
+}
+
+// Use adds middlewares that run for every routed request, in the order
+// added, after client IP filtering and API key authentication and before
+// the route's pipeline. It must be called before the gateway serves
+// requests.
+func (g *Gateway) Use(mw ...Middleware) {
+	g.proxy.Use(mw...)
+}
+
+// Start opens the main listener and, as configured, the probe and metrics
+// listeners, and starts health checks, synthetic probes, cluster sync and
+// secret refreshes. ctx bounds opening the listeners; the gateway serves
+// until Shutdown. Start fails if a listener cannot be opened, and may only
+// be called once.
+func (g *Gateway) Start(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.started {
+		return errors.New("gateway: already started")
+	}
+	g.started = true
+
+	cfg := g.cfg
+	var lc net.ListenConfig
+	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
+	ln, err := lc.Listen(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	listeners := []net.Listener{ln}
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+	if cfg.Server.ProbePort != 0 {
+		l, err := lc.Listen(ctx, "tcp", fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.ProbePort))
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+	if cfg.Metrics.Enabled {
+		l, err := lc.Listen(ctx, "tcp", fmt.Sprintf(":%d", cfg.Metrics.Port))
+		if err != nil {
+			closeAll()
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	if g.checker != nil {
+		g.checker.Start()
+	}
+	if g.prober != nil {
+		g.prober.Start()
+	}
+	if g.node != nil {
+		g.node.Start()
+		g.logger.Info("cluster sync enabled", "node_id", cfg.Cluster.NodeID, "peers", len(cfg.Cluster.Peers), "discovery_dns", cfg.Cluster.DiscoveryDNS)
+	}
+	if cfg.Secrets.RefreshInterval > 0 && g.configFile != "" {
+		g.stopSecrets = make(chan struct{})
+		go g.refreshSecrets(cfg.Secrets.RefreshInterval, g.stopSecrets)
+	}
+	// A pre-stop hook starts the shutdown sequence from the probe
+	// endpoint; the owner learns of it through Done.
+	go func() {
+		<-g.shutdown.Done()
+		g.stop(nil)
+	}()
+
+	g.serveMain(listeners[0])
+	listeners = listeners[1:]
+	if cfg.Server.ProbePort != 0 {
+		g.serveProbes(listeners[0])
+		listeners = listeners[1:]
+	}
+	if cfg.Metrics.Enabled {
+		g.serveMetrics(listeners[0])
+	}
+	return nil
+}
+
+func (g *Gateway) serveMain(ln net.Listener) {
+	cfg := g.cfg
+	p := g.proxy
+	server := &http.Server{
+		Addr:              ln.Addr().String(),
+		Handler:           g.handler,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ConnectionLimits.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+	}
+	if cfg.Server.TLS != nil {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		new(tlsfp.Capture).Install(server)
+	}
+	if cfg.Server.H2C {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	// Under TLS net/http needs the *tls.Conn itself, so the plaintext bytes
+	// cannot be scanned and its own framing checks apply alone.
+	var framingGuard *framing.Guard
+	if cfg.Server.TLS == nil && cfg.Server.RequestFraming != config.FramingOff {
+		framingGuard = framing.New(cfg.Server.RequestFraming == config.FramingReport, p.Metrics(), g.logger)
+		framingGuard.Install(server)
+	}
+
+	// The limiter wraps the network listener itself, below TLS and the
+	// framing guard, so it counts and times the raw connections.
+	cl := cfg.Server.ConnectionLimits
+	exempt, _ := config.ParseIPPrefixes(cl.Exempt) // checked by Validate
+	g.limiter = connlimit.New(connlimit.Config{
+		MaxPerIP:    cl.MaxPerIP,
+		MinDataRate: cl.MinDataRate,
+		Grace:       cl.MinDataRateGrace,
+		Exempt:      exempt,
+	}, p.Metrics(), g.logger)
+	g.limiter.Install(server)
+	g.servers = append(g.servers, server)
+
+	go func() {
+		g.logger.Info("relaypoint API Gateway starting", "address", server.Addr, "tls", cfg.Server.TLS != nil)
+		ln := g.limiter.Listener(ln)
+		var err error
+		switch {
+		case cfg.Server.TLS != nil:
+			err = server.ServeTLS(ln, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		case framingGuard != nil:
+			err = server.Serve(framingGuard.Listener(ln))
+		default:
+			err = server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			g.logger.Error("server error", "error", err)
+			g.stop(err)
+		}
+	}()
+}
+
+// serveProbes serves the probes and the admin API on the probe listener,
+// which is left out of the connection limits and the framing guard.
+func (g *Gateway) serveProbes(ln net.Listener) {
+	server := &http.Server{
+		Handler:           g.probeHandler,
+		ReadHeaderTimeout: g.cfg.Server.ConnectionLimits.ReadHeaderTimeout,
+	}
+	g.servers = append(g.servers, server)
+	go func() {
+		g.logger.Info("probe server starting", "port", g.cfg.Server.ProbePort)
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			g.logger.Error("probe server error", "error", err)
+		}
+	}()
+}
+
+func (g *Gateway) serveMetrics(ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle(g.cfg.Metrics.Path, g.proxy.Metrics().Handler())
+	server := &http.Server{Handler: g.proxy.BasePathHandler(mux)}
+	g.servers = append(g.servers, server)
+	go func() {
+		path := pathprefix.New(g.cfg.Server.BasePath).Join(g.cfg.Metrics.Path)
+		g.logger.Info("metrics server starting", "port", g.cfg.Metrics.Port, "path", path)
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			g.logger.Error("metrics server error", "error", err)
+		}
+	}()
+}
+
+// Done is closed once the gateway stops serving on its own: a pre-stop
+// hook ran the shutdown sequence, or the main listener failed. Err tells
+// which. The owner should still call Shutdown.
+func (g *Gateway) Done() <-chan struct{} {
+	return g.done
+}
+
+// Err returns the error the main listener failed with, once Done is
+// closed, or nil.
+func (g *Gateway) Err() error {
+	select {
+	case <-g.done:
+		return g.err
+	default:
+		return nil
+	}
+}
+
+func (g *Gateway) stop(err error) {
+	g.doneOnce.Do(func() {
+		g.err = err
+		close(g.done)
+	})
+}
+
+// Shutdown fails the readiness probe, waits out server.pre_stop_delay,
+// drains in-flight requests, closes the listeners and stops all background
+// work. Closing the listeners waits for open connections for up to
+// server.shutdown_timeout, or until ctx ends; the background work is
+// stopped either way.
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	// Returns at once if a pre-stop hook already ran it.
+	g.shutdown.Shutdown()
+
+	g.logger.Info("shutting down server...")
+	ctx, cancel := context.WithTimeout(ctx, g.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var err error
+	for i := len(g.servers) - 1; i >= 0; i-- {
+		if serr := g.servers[i].Shutdown(ctx); serr != nil {
+			g.logger.Error("server shutdown error", "error", serr)
+			err = errors.Join(err, serr)
+		}
+	}
+	g.stopOnce.Do(func() {
+		if g.stopSecrets != nil {
+			close(g.stopSecrets)
+		}
+		if g.limiter != nil {
+			g.limiter.Stop()
+		}
+		if g.started {
+			if g.node != nil {
+				g.node.Stop()
+			}
+			if g.prober != nil {
+				g.prober.Stop()
+			}
+			if g.checker != nil {
+				g.checker.Stop()
+			}
+		}
+		g.proxy.Stop()
+	})
+	g.stop(nil)
+	g.logger.Info("server gracefully stopped")
+	return err
+}
+
+// Reload applies the settings of cfg that change without a restart:
+// upstream client certificates are read again from disk, and route
+// maintenance, enabled state and feature flags are replaced by cfg's.
+// The rest of cfg is ignored. The configuration history records, with
+// reason, e.g. "sighup", the running configuration with only those
+// settings replaced, so the reported fingerprint stays that of what is
+// in effect.
+func (g *Gateway) Reload(cfg *Config, reason string) {
+	p := g.proxy
+	if err := p.ReloadCertificates(); err != nil {
+		g.logger.Error("failed to reload upstream client certificates", "error", err)
+	} else {
+		g.logger.Info("upstream client certificates reloaded")
+	}
+	p.SetMaintenance(cfg.Routes)
+	p.SetRoutesEnabled(cfg.Routes)
+	p.SetFeatureFlags(cfg.FeatureFlags)
+	p.ConfigUpdated(reason, func(applied *Config) *Config { return applied.WithReloadable(cfg) })
+	p.Events().Publish(events.ConfigReload, map[string]any{"reason": reason, "fingerprint": p.ConfigFingerprint()})
+}
+
+// refreshSecrets re-resolves secret references on every interval and, when a
+// value rotated, reloads the configuration file to hand the new API keys to
+// the proxy.
+func (g *Gateway) refreshSecrets(interval time.Duration, stop <-chan struct{}) {
+	p := g.proxy
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := secrets.Default.Refresh(context.Background())
+		p.SecretsRefreshed(err)
+		if !changed {
+			continue
+		}
+
+		cfg, err := config.Load(g.configFile)
+		if err != nil {
+			g.logger.Error("failed to apply rotated secrets", "error", err)
+			continue
+		}
+		p.SetAPIKeys(cfg.APIKeys)
+		p.ConfigUpdated("secret_rotation", func(applied *Config) *Config { return applied.WithAPIKeys(cfg) })
+		p.Events().Publish(events.ConfigReload, map[string]any{
+			"reason":      "secret_rotation",
+			"api_keys":    len(cfg.APIKeys),
+			"fingerprint": p.ConfigFingerprint(),
+		})
+		g.logger.Info("rotated secrets applied", "api_keys", len(cfg.APIKeys), "fingerprint", p.ConfigFingerprint())
+	}
+}
@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/relaypoint/relaypoint/internal/config"
+	"github.com/relaypoint/relaypoint/internal/lifecycle"
+)
+
+func newTestConfig(t *testing.T, backendURL string, port int) *Config {
+	t.Helper()
+	cfg, err := ParseConfig([]byte(fmt.Sprintf(`
+server:
+  host: 127.0.0.1
+  port: %d
+  shutdown_timeout: 2s
+metrics:
+  enabled: false
+rate_limit:
+  enabled: false
+upstreams:
+  - name: backend
+    targets:
+      - url: %s
+routes:
+  - name: accounts
+    path: /accounts/:id/**
+    upstream: backend
+`, port, backendURL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func newBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "backend "+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestGateway_Handler(t *testing.T) {
+	g, err := New(newTestConfig(t, newBackend(t).URL, 8080), WithVersion("1.2.3", "today"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = g.Shutdown(context.Background()) }()
+	g.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RouteFromContext(r.Context()).PathParams["id"] == "blocked" {
+				http.Error(w, "blocked", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	srv := httptest.NewServer(g.Handler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	if status, body := get("/accounts/42/orders"); status != http.StatusOK || body != "backend /accounts/42/orders" {
+		t.Errorf("proxied: %d %q", status, body)
+	}
+	if status, _ := get("/accounts/blocked/orders"); status != http.StatusForbidden {
+		t.Errorf("middleware: status = %d, want 403", status)
+	}
+	if status, _ := get("/health"); status != http.StatusOK {
+		t.Errorf("/health: status = %d", status)
+	}
+	_, body := get("/version")
+	var version map[string]string
+	if err := json.Unmarshal([]byte(body), &version); err != nil || version["version"] != "1.2.3" || version["config_fingerprint"] == "" {
+		t.Errorf("/version = %s", body)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestGateway_StartShutdown(t *testing.T) {
+	backend := newBackend(t)
+	port := freePort(t)
+	g, err := New(newTestConfig(t, backend.URL, port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(context.Background()); err == nil {
+		t.Error("second Start succeeded")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/accounts/1/x", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+
+	// The port is taken, so another gateway cannot start on it.
+	other, err := New(newTestConfig(t, backend.URL, port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Start(context.Background()); err == nil {
+		t.Error("Start on a port in use succeeded")
+	}
+	_ = other.Shutdown(context.Background())
+
+	select {
+	case <-g.Done():
+		t.Fatal("Done closed while serving")
+	default:
+	}
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-g.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after Shutdown")
+	}
+	if g.Err() != nil {
+		t.Errorf("Err = %v", g.Err())
+	}
+	if _, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/health", port)); err == nil {
+		t.Error("still serving after Shutdown")
+	}
+}
+
+func TestGateway_AdminOnProbeListener(t *testing.T) {
+	g, err := New(newTestConfig(t, newBackend(t).URL, 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = g.Shutdown(context.Background()) }()
+
+	// On the main listener /admin/ is routed like any other path; no route
+	// matches it here.
+	for _, req := range []*http.Request{
+		httptest.NewRequest("PUT", "/admin/mode", strings.NewReader(`{"mode": "read_only"}`)),
+		httptest.NewRequest("POST", "/admin/routes/accounts/disable", nil),
+		httptest.NewRequest("GET", "/admin/routes/order", nil),
+	} {
+		rec := httptest.NewRecorder()
+		g.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("main listener %s %s: status = %d, want 404", req.Method, req.URL.Path, rec.Code)
+		}
+	}
+	if g.proxy.Mode() != "active" {
+		t.Errorf("mode = %s after a request on the main listener", g.proxy.Mode())
+	}
+
+	rec := httptest.NewRecorder()
+	g.ProbeHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/routes/order", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("probe listener: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestGateway_PreStop(t *testing.T) {
+	g, err := New(newTestConfig(t, newBackend(t).URL, freePort(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The main listener is public, so the hook is not on it: the request
+	// is proxied like any other.
+	rec := httptest.NewRecorder()
+	g.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/prestop", nil))
+	select {
+	case <-g.Done():
+		t.Fatal("pre-stop hook served on the main listener")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if state := g.shutdown.State(); state != lifecycle.Serving {
+		t.Fatalf("state = %v after a pre-stop request on the main listener, want Serving", state)
+	}
+
+	// On the probe listener a pre-stop hook runs the shutdown sequence, and
+	// the owner learns of it through Done.
+	rec = httptest.NewRecorder()
+	g.ProbeHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/prestop", nil))
+	select {
+	case <-g.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done not closed after the pre-stop hook")
+	}
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGateway_ReloadFingerprint(t *testing.T) {
+	backend := newBackend(t)
+	g, err := New(newTestConfig(t, backend.URL, 8080))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = g.Shutdown(context.Background()) }()
+	started := g.proxy.ConfigFingerprint()
+
+	// A reload does not apply route paths, so a changed path leaves the
+	// fingerprint of the configuration in effect alone.
+	changed := newTestConfig(t, backend.URL, 8080)
+	changed.Routes[0].Path = "/users/:id/**"
+	g.Reload(changed, "sighup")
+	if got := g.proxy.ConfigFingerprint(); got != started {
+		t.Errorf("after reloading a changed path: fingerprint = %s, want %s", got, started)
+	}
+	rec := httptest.NewRecorder()
+	g.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/version", nil))
+	var version map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &version); err != nil || version["config_fingerprint"] != started {
+		t.Errorf("/version = %s, want fingerprint %s", rec.Body.String(), started)
+	}
+
+	// Maintenance is applied, so it changes the fingerprint.
+	maintenance := newTestConfig(t, backend.URL, 8080)
+	maintenance.Routes[0].Maintenance = &config.Maintenance{Enabled: true}
+	g.Reload(maintenance, "sighup")
+	if got := g.proxy.ConfigFingerprint(); got == started || got != maintenance.Fingerprint() {
+		t.Errorf("after reloading maintenance: fingerprint = %s, want %s", got, maintenance.Fingerprint())
+	}
+}